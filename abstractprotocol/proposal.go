@@ -2,6 +2,7 @@ package abstractprotocol
 
 import (
 	"fmt"
+	"github.com/open-horizon/anax/policy"
 )
 
 // =======================================================================================================
@@ -15,18 +16,20 @@ type Proposal interface {
 	TsAndCs() string
 	ProducerPolicy() string
 	ConsumerId() string
+	Capabilities() *policy.CapabilitySet
 }
 
 // A concrete Proposal object that implements all the functions of a Proposal interface. This represents the base protocol object for a proposal. Other
 // agreement protocols might wish to embed and then extend this object.
 type BaseProposal struct {
 	*BaseProtocolMessage
-	TsandCs        string `json:"tsandcs"` // This is a JSON serialized policy file, merged between consumer and producer. It has 1 workload array element.
-	Producerpolicy string `json:"producerPolicy"`
-	Consumerid     string `json:"consumerId"`
+	TsandCs        string                `json:"tsandcs"` // This is a JSON serialized policy file, merged between consumer and producer. It has 1 workload array element.
+	Producerpolicy string                `json:"producerPolicy"`
+	Consumerid     string                `json:"consumerId"`
+	Caps           *policy.CapabilitySet `json:"capabilities,omitempty"` // The protocol capabilities the consumer wants to use, new in V4 protocol
 }
 
-func NewProposal(name string, version int, tsandcs string, pPol string, agId string, cId string) *BaseProposal {
+func NewProposal(name string, version int, tsandcs string, pPol string, agId string, cId string, caps *policy.CapabilitySet) *BaseProposal {
 	return &BaseProposal{
 		BaseProtocolMessage: &BaseProtocolMessage{
 			MsgType:   MsgTypeProposal,
@@ -37,6 +40,7 @@ func NewProposal(name string, version int, tsandcs string, pPol string, agId str
 		TsandCs:        tsandcs,
 		Producerpolicy: pPol,
 		Consumerid:     cId,
+		Caps:           caps,
 	}
 }
 
@@ -45,7 +49,7 @@ func (bp *BaseProposal) IsValid() bool {
 }
 
 func (bp *BaseProposal) String() string {
-	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", ConsumerId: %v", bp.Consumerid)
+	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", ConsumerId: %v, Capabilities: %v", bp.Consumerid, bp.Caps)
 }
 
 func (bp *BaseProposal) ShortString() string {
@@ -67,3 +71,7 @@ func (bp *BaseProposal) ProducerPolicy() string {
 func (bp *BaseProposal) ConsumerId() string {
 	return bp.Consumerid
 }
+
+func (bp *BaseProposal) Capabilities() *policy.CapabilitySet {
+	return bp.Caps
+}
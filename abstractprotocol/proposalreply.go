@@ -16,14 +16,17 @@ type ProposalReply interface {
 	DeviceId() string
 	AcceptProposal()
 	DoNotAcceptProposal()
+	Capabilities() []string
+	SetCapabilities(caps []string)
 }
 
 // A concrete ProposalReply object that implements all the functions of a ProposalReply interface. This represents the base protocol
 // object for a proposal reply. Other agreement protocols might wish to embed and then extend this object.
 type BaseProposalReply struct {
 	*BaseProtocolMessage
-	Decision bool   `json:"decision"`
-	Deviceid string `json:"deviceId"`
+	Decision bool     `json:"decision"`
+	Deviceid string   `json:"deviceId"`
+	Caps     []string `json:"capabilities,omitempty"` // The protocol capabilities the producer agreed to use, new in V4 protocol
 }
 
 func (bp *BaseProposalReply) IsValid() bool {
@@ -31,11 +34,11 @@ func (bp *BaseProposalReply) IsValid() bool {
 }
 
 func (bp *BaseProposalReply) String() string {
-	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", Decision: %v, DeviceId: %v", bp.Decision, bp.Deviceid)
+	return bp.BaseProtocolMessage.String() + fmt.Sprintf(", Decision: %v, DeviceId: %v, Capabilities: %v", bp.Decision, bp.Deviceid, bp.Caps)
 }
 
 func (bp *BaseProposalReply) ShortString() string {
-	return bp.BaseProtocolMessage.ShortString() + fmt.Sprintf(", Decision: %v, DeviceId: %v", bp.Decision, bp.Deviceid)
+	return bp.BaseProtocolMessage.ShortString() + fmt.Sprintf(", Decision: %v, DeviceId: %v, Capabilities: %v", bp.Decision, bp.Deviceid, bp.Caps)
 }
 
 func (bp *BaseProposalReply) ProposalAccepted() bool {
@@ -54,6 +57,14 @@ func (bp *BaseProposalReply) DoNotAcceptProposal() {
 	bp.Decision = false
 }
 
+func (bp *BaseProposalReply) Capabilities() []string {
+	return bp.Caps
+}
+
+func (bp *BaseProposalReply) SetCapabilities(caps []string) {
+	bp.Caps = caps
+}
+
 func NewProposalReply(name string, version int, id string, deviceId string) *BaseProposalReply {
 	return &BaseProposalReply{
 		BaseProtocolMessage: &BaseProtocolMessage{
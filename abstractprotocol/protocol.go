@@ -21,6 +21,9 @@ const MsgTypeDataReceivedAck = "dataverificationack"
 const MsgTypeNotifyMetering = "meteringnotification"
 const MsgTypeCancel = "cancel"
 
+// The first protocol version in which proposals and replies carry protocol capabilities.
+const CAPABILITIES_PROTOCOL_VERSION = 4
+
 // All protocol message have the following header info.
 type ProtocolMessage interface {
 	IsValid() bool
@@ -89,6 +92,7 @@ type ProtocolHandler interface {
 	// Base protocol handler methods. These are implemented by the abstract interface.
 	Name() string
 	Version() int
+	Capabilities() []string
 	PolicyManager() *policy.PolicyManager
 	HTTPClient() *http.Client
 
@@ -168,10 +172,11 @@ type ProtocolHandler interface {
 }
 
 type BaseProtocolHandler struct {
-	name       string
-	version    int
-	httpClient *http.Client
-	pm         *policy.PolicyManager
+	name         string
+	version      int
+	capabilities []string
+	httpClient   *http.Client
+	pm           *policy.PolicyManager
 }
 
 func (bp *BaseProtocolHandler) Name() string {
@@ -182,6 +187,11 @@ func (bp *BaseProtocolHandler) Version() int {
 	return bp.version
 }
 
+// The protocol capabilities supported by this handler.
+func (bp *BaseProtocolHandler) Capabilities() []string {
+	return bp.capabilities
+}
+
 func (bp *BaseProtocolHandler) PolicyManager() *policy.PolicyManager {
 	return bp.pm
 }
@@ -190,12 +200,13 @@ func (bp *BaseProtocolHandler) HTTPClient() *http.Client {
 	return bp.httpClient
 }
 
-func NewBaseProtocolHandler(n string, v int, h *http.Client, p *policy.PolicyManager) *BaseProtocolHandler {
+func NewBaseProtocolHandler(n string, v int, c []string, h *http.Client, p *policy.PolicyManager) *BaseProtocolHandler {
 	return &BaseProtocolHandler{
-		name:       n,
-		version:    v,
-		httpClient: h,
-		pm:         p,
+		name:         n,
+		version:      v,
+		capabilities: c,
+		httpClient:   h,
+		pm:           p,
 	}
 }
 
@@ -222,7 +233,20 @@ func CreateProposal(p ProtocolHandler,
 		} else if pBytes, err := json.Marshal(producerPolicy); err != nil {
 			return nil, errors.New(fmt.Sprintf("Protocol %v error marshalling producer policy %v, error: %v", p.Name(), *producerPolicy, err))
 		} else {
-			return NewProposal(p.Name(), version, string(tcBytes), string(pBytes), agreementId, myId), nil
+			// Capabilities are only sent to producers running a protocol version that understands them. A producer running
+			// an older version can not support the capabilities that are required, so no proposal is made to it.
+			var caps *policy.CapabilitySet
+			consumerCaps := consumerPolicy.ProtocolCapabilities(p.Name())
+			if !consumerCaps.IsEmpty() {
+				if err := consumerCaps.IsValid(); err != nil {
+					return nil, errors.New(fmt.Sprintf("Protocol %v error in consumer policy %v, %v", p.Name(), consumerPolicy.Header.Name, err))
+				} else if version >= CAPABILITIES_PROTOCOL_VERSION {
+					caps = consumerCaps
+				} else if len(consumerCaps.Required) != 0 {
+					return nil, errors.New(fmt.Sprintf("Protocol %v producer supports protocol version %v, which does not support the capabilities %v required by consumer policy %v", p.Name(), version, consumerCaps.Required, consumerPolicy.Header.Name))
+				}
+			}
+			return NewProposal(p.Name(), version, string(tcBytes), string(pBytes), agreementId, myId, caps), nil
 		}
 	}
 }
@@ -283,7 +307,7 @@ func DecideOnProposal(p ProtocolHandler,
 		// now add the node's built-in properties to the producer policy
 		isCluster := device.IsEdgeCluster()
 		var err1 error
-		producerPolicy, err1 = addNodeBuiltInProps(producerPolicy, nodePolicy, isCluster)
+		producerPolicy, err1 = AddNodeBuiltInProps(producerPolicy, nodePolicy, isCluster)
 		if err1 != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error adding node built-in policy to the producer policy, %v", p.Name(), err))
 		}
@@ -322,9 +346,17 @@ func DecideOnProposal(p ProtocolHandler,
 			// compatible with the producer's policy.
 		} else if err := policy.Are_Compatible(producerPolicy, termsAndConditions, nil); err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error, T and C policy is not compatible, rejecting proposal: %v", p.Name(), err))
+
+			// Make sure that all the capabilities required by the consumer are supported. Optional capabilities that are
+			// not supported are ignored. The capabilities that will be used are returned in the reply.
+		} else if caps, err := proposal.Capabilities().Negotiate(p.Capabilities()); err != nil {
+			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error, rejecting proposal: %v", p.Name(), err))
 		} else if err := p.PolicyManager().FinalAgreement(policies, proposal.AgreementId(), myOrg); err != nil {
 			replyErr = errors.New(fmt.Sprintf("Protocol %v decide on proposal received error, unable to record agreement state in PM: %v", p.Name(), err))
 		} else {
+			if len(caps) != 0 {
+				reply.SetCapabilities(caps)
+			}
 			reply.AcceptProposal()
 		}
 
@@ -365,6 +397,12 @@ func SendResponse(p ProtocolHandler,
 
 }
 
+// Returns the capabilities required by the proposal that the producer did not agree to in its reply. A producer running
+// a protocol version older than CAPABILITIES_PROTOCOL_VERSION never agrees to any capabilities.
+func MissingCapabilities(proposal Proposal, reply ProposalReply) []string {
+	return proposal.Capabilities().MissingRequired(reply.Capabilities())
+}
+
 // Confirm a reply from a producer.
 func Confirm(p ProtocolHandler,
 	replyValid bool,
@@ -573,7 +611,7 @@ func DemarshalProposal(proposal string) (Proposal, error) {
 // Adds node built-in properties to the producer policy.
 // It will get node's CPU count, available memory and arch and add them to
 // the producer policy that was used to make the proposal on agbot.
-func addNodeBuiltInProps(pol *policy.Policy, nodePol *externalpolicy.ExternalPolicy, isCluster bool) (*policy.Policy, error) {
+func AddNodeBuiltInProps(pol *policy.Policy, nodePol *externalpolicy.ExternalPolicy, isCluster bool) (*policy.Policy, error) {
 	if pol == nil {
 		return nil, nil
 	}
@@ -8,6 +8,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/agreementbot/persistence"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/dataverification"
 	"net/http"
 	"os"
 	"time"
@@ -17,18 +18,9 @@ import (
 // const testActiveData = `[{"id":"1","lat":41.0064,"lon":-111.9393,"contracts":[{"id":"0x0abcde","type":1,"ts":1447195061},{"id":"0x0ddddd","type":2,"ts":1447195061}]},`+
 //                          `{"id":"2","lat":-41.0064,"lon":111.9393,"contracts":[{"id":"0x0deadbeef","type":1,"ts":1447195061},{"id":"0x0beefdead","type":2,"ts":1447195061}]}]`
 
-type AgreementEntry struct {
-	Id   string `json:"id"`
-	Type int    `json:"type"`
-	Ts   uint64 `json:"ts"`
-}
+type AgreementEntry = dataverification.AgreementEntry
 
-type DeviceEntry struct {
-	Id         string           `json:"id"`
-	Lat        float64          `json:"lat"`
-	Lon        float64          `json:"lon"`
-	Agreements []AgreementEntry `json:"contracts"`
-}
+type DeviceEntry = dataverification.DeviceEntry
 
 func GetActiveAgreements(in_devices map[string][]string, agreement persistence.Agreement, hConfig *config.HorizonConfig) ([]string, error) {
 	err := error(nil)
@@ -51,6 +43,15 @@ func GetActiveAgreements(in_devices map[string][]string, agreement persistence.A
 		activeAgreementsURL = config.ActiveAgreementsURL
 	}
 
+	// When there is no URL to call and the data verification service is embedded in the agbot, ask it directly.
+	if activeAgreementsURL == "" && dataVerifier != nil {
+		if _, ok := in_devices[activeAgreementsURL]; !ok {
+			in_devices[activeAgreementsURL] = dataVerifier.ActiveAgreementIds()
+			glog.V(3).Infof("For embedded data verification gathered agreements: %v", in_devices[activeAgreementsURL])
+		}
+		return in_devices[activeAgreementsURL], err
+	}
+
 	activeAgreementsUser := agreement.DataVerificationUser
 	if activeAgreementsUser == "" {
 		activeAgreementsUser = config.ActiveAgreementsUser
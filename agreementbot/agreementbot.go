@@ -9,6 +9,7 @@ import (
 	"github.com/open-horizon/anax/agreementbot/persistence"
 	"github.com/open-horizon/anax/agreementbot/secrets"
 	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/dataverification"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/externalpolicy"
@@ -31,7 +32,11 @@ const SECRETS_PROVIDER = "AgbotSecretsProvider"
 //const GOVERN_BC_NEEDS = "AgBotGovernBlockchain"
 const POLICY_WATCHER = "AgBotPolicyWatcher"
 const STALE_PARTITIONS = "AgbotStaleDatabasePartition"
+const PARTITION_REBALANCE = "AgbotPartitionRebalance"
+const POLICY_CACHE_CHECK = "AgbotPolicyCacheCheck"
 const MESSAGE_KEY_CHECK = "AgbotMessageKeyCheck"
+const DATA_VERIFICATION_PURGE = "AgbotDataVerificationPurge"
+const AUDIT_PURGE = "AgbotAuditPurge"
 
 // Agreement governance timing state. Used in the GovernAgreements subworker.
 type DVState struct {
@@ -39,10 +44,22 @@ type DVState struct {
 	nhSkip uint64
 }
 
-//package level variable
+// The pattern and deployment policy managers of the primary exchange, shared with the API.
 var patternManager *PatternManager
 var businessPolManager *BusinessPolicyManager
 
+// The embedded data verification service, nil when it is not configured.
+var dataVerifier *dataverification.DataTracker
+
+// The node index, nil when it is not enabled.
+var nodeIndex *NodeIndex
+
+// The MMS object policy manager, shared with the API so that it can check the cache.
+var objectPolManager *MMSObjectPolicyManager
+
+// The consumer protocol handlers, shared with the API so that it can report the state of the work queues.
+var consumerPHMgr *ConsumerPHMgr
+
 // must be safely-constructed!!
 type AgreementBotWorker struct {
 	worker.BaseWorker    // embedded field
@@ -59,6 +76,10 @@ type AgreementBotWorker struct {
 	newMessagesToProcess bool        // True when the agbot has been notified (through the exchange /changes API) that there are messages to process.
 	nodeSearch           *NodeSearch // The object that controls node searches and the state of search sessions.
 	secretProvider       secrets.AgbotSecrets
+	exchangeName         string // The name of the federated exchange served by this worker, empty for the primary exchange.
+	patternManager       *PatternManager
+	businessPolManager   *BusinessPolicyManager
+	quiesced             bool // True when the worker has finished shutting down.
 }
 
 func NewAgreementBotWorker(name string, cfg *config.HorizonConfig, db persistence.AgbotDatabase, s secrets.AgbotSecrets) *AgreementBotWorker {
@@ -79,9 +100,15 @@ func NewAgreementBotWorker(name string, cfg *config.HorizonConfig, db persistenc
 		newMessagesToProcess: false,
 		nodeSearch:           NewNodeSearch(),
 		secretProvider:       s,
+		exchangeName:         cfg.GetAgbotExchangeName(),
+		patternManager:       NewPatternManager(),
 	}
 
-	patternManager = NewPatternManager()
+	// The API reports on the primary exchange.
+	if worker.isPrimaryExchange() {
+		patternManager = worker.patternManager
+		consumerPHMgr = worker.consumerPH
+	}
 
 	glog.Info("Starting AgreementBot worker")
 	worker.Start(worker, int(cfg.AgreementBot.NewContractIntervalS))
@@ -177,6 +204,17 @@ func (w *AgreementBotWorker) NewEvent(incoming events.Message) {
 			}
 		}
 
+	case *events.ABApiPolicyCacheRepairMessage:
+		msg, _ := incoming.(*events.ABApiPolicyCacheRepairMessage)
+		switch msg.Event().Id {
+		case events.REPAIR_POLICY_CACHE:
+			if w.ready {
+				w.Commands <- NewPolicyCacheRepairCommand(msg.Result)
+			} else if msg.Result != nil {
+				msg.Result <- errors.New("the agbot is not ready to repair its policy caches")
+			}
+		}
+
 	case *events.NodeShutdownCompleteMessage:
 		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
 		switch msg.Event().Id {
@@ -244,7 +282,7 @@ func (w *AgreementBotWorker) NewEvent(incoming events.Message) {
 			w.Commands <- NewServicePolicyChangeCommand(msg)
 		case events.CHANGE_NODE_POLICY_TYPE:
 			// A node policy has changed.
-			w.nodeSearch.SetRescanNeeded()
+			w.Commands <- NewNodeChangeCommand(msg)
 		case events.CHANGE_NODE_AGREEMENT_TYPE:
 			// A node agreement has changed.
 			w.nodeSearch.SetRescanNeeded()
@@ -253,7 +291,7 @@ func (w *AgreementBotWorker) NewEvent(incoming events.Message) {
 			w.nodeSearch.SetRescanNeeded()
 		case events.CHANGE_NODE_TYPE:
 			// The node itself has changed.
-			w.nodeSearch.SetRescanNeeded()
+			w.Commands <- NewNodeChangeCommand(msg)
 		}
 
 	default: //nothing
@@ -302,18 +340,25 @@ func (w *AgreementBotWorker) Initialize() bool {
 		return w.fail()
 	}
 
-	// Start the go thread that heartbeats to the database.
-	w.DispatchSubworker(DATABASE_HEARTBEAT, w.databaseHeartBeat, int(w.BaseWorker.Manager.Config.GetPartitionStale()/3), false)
+	// Start the go thread that heartbeats to the database. The database is shared by the workers of all the exchanges
+	// that the agbot serves, so only the worker of the primary exchange maintains it.
+	if w.isPrimaryExchange() {
+		w.DispatchSubworker(DATABASE_HEARTBEAT, w.databaseHeartBeat, int(w.BaseWorker.Manager.Config.GetPartitionStale()/3), false)
+	}
 
 	// Start the go thread that ensures the secrets provider remains logged in.
-	if w.secretProvider != nil {
+	if w.secretProvider != nil && w.isPrimaryExchange() {
 		w.DispatchSubworker(SECRETS_PROVIDER, w.secretsProviderMaintenance, 60, false)
 	}
 
 	// Give the policy manager a chance to read in all the policies. The agbot worker will not proceed past this point
 	// until it has some policies to work with.
-	businessPolManager = NewBusinessPolicyManager(w.Messages())
+	w.businessPolManager = NewBusinessPolicyManager(w.Messages())
 	w.MMSObjectPM = NewMMSObjectPolicyManager(w.BaseWorker.Manager.Config)
+	if w.isPrimaryExchange() {
+		businessPolManager = w.businessPolManager
+		objectPolManager = w.MMSObjectPM
+	}
 	for {
 
 		// Query the exchange for patterns that this agbot is supposed to serve and generate a policy for each one. If an error
@@ -347,7 +392,7 @@ func (w *AgreementBotWorker) Initialize() bool {
 	glog.Info("AgreementBot worker started")
 
 	// Tell the node search component to initialize itself.
-	w.nodeSearch.Init(w.db, w.pm, w.consumerPH, w.Messages(), w, w.Config)
+	w.nodeSearch.Init(w.db, w.pm, w.consumerPH, w.businessPolManager, w.patternManager, w.Messages(), w, w.Config)
 
 	// Make sure that our public key is registered in the exchange so that other parties
 	// can send us messages.
@@ -377,15 +422,41 @@ func (w *AgreementBotWorker) Initialize() bool {
 		return w.fail()
 	}
 
-	// Start the go thread that checks for stale partitions.
-	w.DispatchSubworker(STALE_PARTITIONS, w.stalePartitions, int(w.BaseWorker.Manager.Config.GetPartitionStale()), false)
+	// Start the embedded data verification service if it is configured. Agreements that dont specify a data verification
+	// URL, when the agbot has no ActiveAgreementsURL configured, will be verified against this service.
+	if w.Config.IsDataVerificationEmbedded() && w.isPrimaryExchange() {
+		w.startDataVerification()
+	}
+
+	// The partitions hold the agreements of all the exchanges served by the agbot, so only the worker of the primary
+	// exchange manages them.
+	if w.isPrimaryExchange() {
+
+		// Start the go thread that checks for stale partitions.
+		w.DispatchSubworker(STALE_PARTITIONS, w.stalePartitions, int(w.BaseWorker.Manager.Config.GetPartitionStale()), false)
+
+		// Start the go thread that rebalances partitions across agbot instances, if it is configured.
+		if w.Config.IsPartitionRebalanceEnabled() {
+			w.DispatchSubworker(PARTITION_REBALANCE, w.rebalancePartitions, int(w.BaseWorker.Manager.Config.GetPartitionStale()/3), false)
+		}
+	}
+
+	// Start the go thread that checks the policy caches against the exchange, if it is configured. The cache check
+	// covers the primary exchange, which is the exchange that the cache APIs report on.
+	if w.Config.GetPolicyCacheCheckInterval() != 0 && w.isPrimaryExchange() {
+		w.DispatchSubworker(POLICY_CACHE_CHECK, w.checkPolicyCaches, int(w.Config.GetPolicyCacheCheckInterval()), false)
+	}
 
 	// The agbot worker is now ready to handle incoming messages
 	w.ready = true
+	registerAgbotInstance(w)
 
 	// Start the governance routines using the subworker APIs.
 	w.DispatchSubworker(GOVERN_AGREEMENTS, w.GovernAgreements, int(w.BaseWorker.Manager.Config.AgreementBot.ProcessGovernanceIntervalS), false)
 	w.DispatchSubworker(GOVERN_ARCHIVED_AGREEMENTS, w.GovernArchivedAgreements, 1800, false)
+	if w.isPrimaryExchange() {
+		w.DispatchSubworker(AUDIT_PURGE, w.purgeAuditTrail, 1800, false)
+	}
 	//w.DispatchSubworker(GOVERN_BC_NEEDS, w.GovernBlockchainNeeds, 60, false)
 	w.DispatchSubworker(MESSAGE_KEY_CHECK, w.messageKeyCheck, w.BaseWorker.Manager.Config.AgreementBot.MessageKeyCheck, false)
 
@@ -501,7 +572,7 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 
 	case *CacheServicePolicyCommand:
 		cmd, _ := command.(*CacheServicePolicyCommand)
-		if err := businessPolManager.AddMarshaledServicePolicy(cmd.Msg.BusinessPolOrg, cmd.Msg.BusinessPolName, cmd.Msg.ServiceId, cmd.Msg.ServicePolicy); err != nil {
+		if err := w.businessPolManager.AddMarshaledServicePolicy(cmd.Msg.BusinessPolOrg, cmd.Msg.BusinessPolName, cmd.Msg.ServiceId, cmd.Msg.ServicePolicy); err != nil {
 			glog.Errorf(fmt.Sprintf("AgreementBotWorker failed to cache the service policy for service %v for business policy %v/%v. %v", cmd.Msg.ServiceId, cmd.Msg.BusinessPolOrg, cmd.Msg.BusinessPolName, err))
 		}
 
@@ -608,6 +679,22 @@ func (w *AgreementBotWorker) CommandHandler(command worker.Command) bool {
 		cmd, _ := command.(*ServicePolicyChangeCommand)
 		go w.updateServicePolicies(&cmd.Msg)
 
+	case *PolicyCacheRepairCommand:
+		// The caches are repaired on the worker thread, one repair at a time.
+		cmd, _ := command.(*PolicyCacheRepairCommand)
+		err := w.repairPolicyCaches()
+		if cmd.Result != nil {
+			cmd.Result <- err
+		}
+
+	case *NodeChangeCommand:
+		cmd, _ := command.(*NodeChangeCommand)
+		if nodeChanges, ok := cmd.Msg.GetChange().([]exchange.ExchangeChange); ok {
+			go w.nodeSearch.UpdateNodeIndex(nodeChanges)
+		} else {
+			w.nodeSearch.SetRescanNeeded()
+		}
+
 	case *ServedPatternCommand:
 		w.saveAgbotServedPatterns()
 		go w.generatePolicyFromPatterns(nil)
@@ -777,8 +864,11 @@ func (w *AgreementBotWorker) NoWorkHandler() {
 
 		}
 
-		// If no pending agreements were found, then we can begin the shutdown.
-		if !foundPending {
+		// If no pending agreements were found, then we can begin the shutdown. The worker of the primary exchange waits
+		// for the workers of the federated exchanges, because it gives up the database partition that they are using.
+		if !foundPending && w.isPrimaryExchange() && !federatedInstancesQuiesced() {
+			glog.V(4).Infof("AgreementBotWorker waiting for the federated exchange workers to shut down.")
+		} else if !foundPending {
 
 			glog.V(5).Infof("AgreementBotWorker shutdown beginning")
 
@@ -792,8 +882,14 @@ func (w *AgreementBotWorker) NoWorkHandler() {
 			// Shutdown the subworkers.
 			w.TerminateSubworkers()
 
-			// Shutdown the database partition.
-			w.db.QuiescePartition()
+			// Shutdown the database partition and let another agbot take over the partition leadership.
+			if w.isPrimaryExchange() {
+				w.db.QuiescePartition()
+				if err := w.db.ReleasePartitionLeader(); err != nil {
+					glog.Errorf(AWlogString(fmt.Sprintf("%v", err)))
+				}
+			}
+			w.setQuiesced()
 
 			w.Messages() <- events.NewNodeShutdownCompleteMessage(events.AGBOT_QUIESCE_COMPLETE, "")
 
@@ -807,6 +903,9 @@ func (w *AgreementBotWorker) reportWorkQueues() {
 	for _, cph := range w.consumerPH.GetAll() {
 		rep += fmt.Sprintf("%v High: %6d, Low: %6d\n", cph, w.consumerPH.Get(cph).WorkQueue().HighPriorityBufferLen(), w.consumerPH.Get(cph).WorkQueue().LowPriorityBufferLen())
 		rep += fmt.Sprintf(w.consumerPH.Get(cph).WorkQueue().queueHistory.report())
+		for org, stats := range w.consumerPH.Get(cph).WorkQueue().Status().Orgs {
+			rep += fmt.Sprintf("%v Org: %v Buffered: %6d, Dispatched: %6d, Throttled: %6d, Avg Wait: %.1fs\n", cph, org, stats.Buffered, stats.Dispatched, stats.Throttled, stats.AvgWaitS)
+		}
 	}
 	glog.V(3).Infof(AWlogString(fmt.Sprintf("Prioritized Work Queues: %v", rep)))
 }
@@ -1182,7 +1281,7 @@ func (w *AgreementBotWorker) saveAgbotServedPatterns() {
 	}
 
 	// Consume the configured org/pattern pairs into the PatternManager
-	if err = patternManager.SetCurrentPatterns(servedPatterns, w.Config.AgreementBot.PolicyPath); err != nil {
+	if err = w.patternManager.SetCurrentPatterns(servedPatterns, w.Config.AgreementBot.PolicyPath); err != nil {
 		glog.Errorf(AWlogString(fmt.Sprintf("unable to process agbot served patterns %v, error %v", servedPatterns, err)))
 	}
 }
@@ -1195,7 +1294,7 @@ func (w *AgreementBotWorker) saveAgbotServedPolicies() {
 	}
 
 	// Consume the configured (policy org, business policy, node org) triplets into the BusinessPolicyManager
-	if err = businessPolManager.SetCurrentBusinessPolicies(servedPolicies, w.pm); err != nil {
+	if err = w.businessPolManager.SetCurrentBusinessPolicies(servedPolicies, w.pm); err != nil {
 		glog.Errorf(AWlogString(fmt.Sprintf("unable to process agbot served deployment policies %v, error %v", servedPolicies, err)))
 	}
 
@@ -1216,7 +1315,7 @@ func (w *AgreementBotWorker) generatePolicyFromPatterns(msg *events.ExchangeChan
 	glog.V(5).Infof(AWlogString(fmt.Sprintf("scanning patterns for updates")))
 
 	// Iterate over each org in the PatternManager and process all the patterns in that org
-	for _, org := range patternManager.GetAllPatternOrgs() {
+	for _, org := range w.patternManager.GetAllPatternOrgs() {
 
 		var exchangePatternMetadata map[string]exchange.Pattern
 		var err error
@@ -1234,7 +1333,7 @@ func (w *AgreementBotWorker) generatePolicyFromPatterns(msg *events.ExchangeChan
 		}
 
 		// Check for pattern metadata changes and update policy files accordingly
-		if err := patternManager.UpdatePatternPolicies(org, exchangePatternMetadata, w.Config.AgreementBot.PolicyPath); err != nil {
+		if err := w.patternManager.UpdatePatternPolicies(org, exchangePatternMetadata, w.Config.AgreementBot.PolicyPath); err != nil {
 			return errors.New(fmt.Sprintf("unable to update policies for org %v, error %v", org, err))
 		}
 	}
@@ -1255,7 +1354,7 @@ func (w *AgreementBotWorker) generatePolicyFromBusinessPols(msg *events.Exchange
 	glog.V(5).Infof(AWlogString(fmt.Sprintf("scanning business policies for updates")))
 
 	// Iterate over each org in the BusinessPolManager and process all the business policies in that org
-	for _, org := range businessPolManager.GetAllPolicyOrgs() {
+	for _, org := range w.businessPolManager.GetAllPolicyOrgs() {
 
 		var exchPolsMetadata map[string]exchange.ExchangeBusinessPolicy
 		var err error
@@ -1275,7 +1374,7 @@ func (w *AgreementBotWorker) generatePolicyFromBusinessPols(msg *events.Exchange
 		}
 
 		// Check for business policy metadata changes and update policies accordingly
-		if err := businessPolManager.UpdatePolicies(org, exchPolsMetadata, w.pm); err != nil {
+		if err := w.businessPolManager.UpdatePolicies(org, exchPolsMetadata, w.pm); err != nil {
 			return errors.New(fmt.Sprintf("unable to update business policies for org %v, error %v", org, err))
 		}
 
@@ -1323,8 +1422,8 @@ func (w *AgreementBotWorker) updateServicePolicies(msg *events.ExchangeChangeMes
 	glog.V(5).Infof(AWlogString(fmt.Sprintf("scanning service policies for updates")))
 
 	// Iterate over each org in the BusinessPolManager and process all the business policies in that org
-	for _, org := range businessPolManager.GetAllPolicyOrgs() {
-		orgMap := businessPolManager.GetAllBusinessPolicyEntriesForOrg(org)
+	for _, org := range w.businessPolManager.GetAllPolicyOrgs() {
+		orgMap := w.businessPolManager.GetAllBusinessPolicyEntriesForOrg(org)
 		if orgMap != nil {
 			for bpName, bPol := range orgMap {
 				if bPol.ServicePolicies != nil {
@@ -1335,12 +1434,12 @@ func (w *AgreementBotWorker) updateServicePolicies(msg *events.ExchangeChangeMes
 								glog.Errorf(AWlogString(fmt.Sprintf("Error getting service policy for %v, %v", svcKey, err)))
 							} else if servicePolicy == nil {
 								// delete the service policy from all the business policies that reference it.
-								if err := businessPolManager.RemoveServicePolicy(org, bpName, svcKey); err != nil {
+								if err := w.businessPolManager.RemoveServicePolicy(org, bpName, svcKey); err != nil {
 									glog.Errorf(AWlogString(fmt.Sprintf("Error deleting service policy %v in the business policy manager: %v", svcKey, err)))
 								}
 							} else {
 								// update the service policy for all the business policies that reference it.
-								if err := businessPolManager.AddServicePolicy(org, bpName, svcKey, servicePolicy); err != nil {
+								if err := w.businessPolManager.AddServicePolicy(org, bpName, svcKey, servicePolicy); err != nil {
 									glog.Errorf(AWlogString(fmt.Sprintf("Error updating service policy %v in the business policy manager: %v", svcKey, err)))
 								}
 							}
@@ -1384,6 +1483,43 @@ func (w *AgreementBotWorker) databaseHeartBeat() int {
 	return 0
 }
 
+// Start the embedded data verification service API and the subworker that purges agreements that have stopped
+// reporting data.
+func (w *AgreementBotWorker) startDataVerification() {
+	dvConfig := w.Config.AgreementBot.DataVerification
+	dataVerifier = dataverification.NewDataTracker(dvConfig.ActiveWindowS, dvConfig.RetentionS)
+
+	// This routine does not need to be a subworker because it will terminate on its own when the main
+	// anax process terminates.
+	go func() {
+		server := dataverification.NewServer(dataVerifier, w.Config.AgreementBot.ActiveAgreementsUser, w.Config.AgreementBot.ActiveAgreementsPW)
+		if err := server.ListenAndServe(dvConfig.APIListen); err != nil {
+			glog.Fatalf(AWlogString(fmt.Sprintf("failed to start data verification listener on %v, error %v", dvConfig.APIListen, err)))
+		}
+	}()
+
+	purgeInterval := dvConfig.ActiveWindowS
+	if purgeInterval == 0 {
+		purgeInterval = dataverification.DEFAULT_ACTIVE_WINDOW
+	}
+	w.DispatchSubworker(DATA_VERIFICATION_PURGE, w.purgeDataVerification, int(purgeInterval), false)
+}
+
+func (w *AgreementBotWorker) purgeDataVerification() int {
+	if dataVerifier != nil {
+		dataVerifier.Purge()
+	}
+	return 0
+}
+
+// Remove the agreement decisions that are beyond the retention limits of the audit trail.
+func (w *AgreementBotWorker) purgeAuditTrail() int {
+	if err := w.db.PurgeAuditRecords(w.Config.GetAuditRetention(), w.Config.GetAuditMaxRecords()); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to purge the audit trail, error: %v", err)))
+	}
+	return 0
+}
+
 // Ask the database to check for stale partitions and move them into our partition if one is found.
 func (w *AgreementBotWorker) stalePartitions() int {
 
@@ -1398,7 +1534,7 @@ func (w *AgreementBotWorker) stalePartitions() int {
 		} else if claimed {
 			// Perform the same sanity checks on existing agreements when we pick up a new set of agreements
 			// in the new partition.
-			if err := w.syncOnInit(); err != nil {
+			if err := w.syncAllOnInit(); err != nil {
 				glog.Errorf(AWlogString(fmt.Sprintf("unable to sync up, error: %v", err)))
 			}
 		}
@@ -384,6 +384,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 	var workload, lastWorkload *policy.Workload
 	svcIds := []string{} // stores the service ids for all the services, top level and dependent services
 	found := true        // if the service policy can be found from the businesspol_manager
+	skipReason := ""     // why the last workload that was tried is not supported by the device
 	var servicePol *externalpolicy.ExternalPolicy
 
 	for !foundWorkload {
@@ -403,6 +404,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		// Added second comparison in case the workload pointer got changed by the policy merger
 		if (lastWorkload == workload) || (lastWorkload != nil && workload != nil && lastWorkload.IsSame(*workload)) {
 			glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("unable to find supported workload for %v within %v", wi.Device.Id, wi.ConsumerPolicy.Workloads)))
+			b.auditSkipped(workerId, wi, fmt.Sprintf("no supported workload: %v", skipReason))
 
 			// If we created a workload usage record during this process, get rid of it.
 			if err := b.db.DeleteWorkloadUsage(wi.Device.Id, wi.ConsumerPolicy.Header.Name); err != nil {
@@ -414,6 +416,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		// If the service is suspended, then do not make an agreement.
 		if found, suspended := exchange.ServiceSuspended(exchangeDev.RegisteredServices, workload.WorkloadURL, workload.Org); found && suspended {
 			glog.Infof(BAWlogstring(workerId, fmt.Sprintf("cannot make agreement with %v for policy %v because service %v is suspended by the user.", wi.Device.Id, wi.ConsumerPolicy.Header.Name, cutil.FormOrgSpecUrl(workload.WorkloadURL, workload.Org))))
+			b.auditSkipped(workerId, wi, fmt.Sprintf("service %v is suspended", cutil.FormOrgSpecUrl(workload.WorkloadURL, workload.Org)))
 			// When the service's config state is resumed, the agent will update the node resource and the agbot will be returned this node
 			// in a search result.
 			return
@@ -438,6 +441,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 
 							if arch1 != arch2 {
 								glog.Infof(BAWlogstring(workerId, fmt.Sprintf("workload arch %v does not match the device arch %v. Can not make agreement.", workload.Arch, prop.Value)))
+								b.auditSkipped(workerId, wi, fmt.Sprintf("service arch %v does not match the node arch %v", workload.Arch, prop.Value))
 								return
 							}
 						}
@@ -467,6 +471,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		t_comp, t_reason := compcheck.CheckTypeCompatibility(nodeType, &compcheck.ServiceDefinition{Org: workload.Org, ServiceDefinition: *workloadDetails}, msgPrinter)
 		if !t_comp {
 			glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("cannot make agreement with node %v for service %v/%v %v. %v", wi.Device.Id, workload.Org, workload.WorkloadURL, workload.Version, t_reason)))
+			b.auditSkipped(workerId, wi, fmt.Sprintf("service %v/%v %v is incompatible with the node type: %v", workload.Org, workload.WorkloadURL, workload.Version, t_reason))
 			return
 		}
 
@@ -490,6 +495,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 				if devMS.ConfigState == exchange.SERVICE_CONFIGSTATE_SUSPENDED {
 					if devMS.Url == cutil.FormOrgSpecUrl(apiSpec.SpecRef, apiSpec.Org) || devMS.Url == apiSpec.SpecRef {
 						glog.Infof(BAWlogstring(workerId, fmt.Sprintf("cannot make agreement with %v for policy %v because service %v is suspended by the user.", wi.Device.Id, wi.ConsumerPolicy.Header.Name, devMS.Url)))
+						b.auditSkipped(workerId, wi, fmt.Sprintf("service %v is suspended", devMS.Url))
 						// When the service's config state is resumed, the agent will update the node resource and the agbot will be returned this node
 						// in a search result.
 						return
@@ -522,11 +528,13 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 			} else if ccOutput.Compatible {
 				if err := wi.ProducerPolicy.APISpecs.Supports(*asl); err != nil {
 					glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("skipping workload %v because device %v cant support it: %v", workload, wi.Device.Id, err)))
+					skipReason = fmt.Sprintf("node does not support the required services: %v", err)
 				} else {
 					policy_match = true
 				}
 			} else {
 				policy_match = false
+				skipReason = "node is not compatible with the pattern"
 			}
 
 		} else {
@@ -582,6 +590,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 					policy_match = true
 				} else {
 					glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("failed matching node policy %v and %v, error: %v", wi.ProducerPolicy, wi.ConsumerPolicy, reason)))
+					skipReason = fmt.Sprintf("incompatible policy: %v", reason)
 				}
 			}
 		}
@@ -593,9 +602,11 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 			if compatible, reason, _, err := compcheck.VerifyUserInputForSingleServiceDef(&svcDef, wi.ConsumerPolicy.UserInput, exchangeDev.UserInput, nil); err != nil {
 				glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("Error validating the user input for service %v/%v %v %v: %v", workload.Org, workloadDetails.URL, workloadDetails.Version, workloadDetails.Arch, err)))
 				userInput_match = false
+				skipReason = fmt.Sprintf("invalid user input: %v", err)
 			} else if !compatible {
 				glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("User input does not meet the requirement for service %v/%v %v %v: %v", workload.Org, workloadDetails.URL, workloadDetails.Version, workloadDetails.Arch, reason)))
 				userInput_match = false
+				skipReason = fmt.Sprintf("missing user input: %v", reason)
 			} else {
 				for _, apiSpec := range *asl {
 					svcSpec := compcheck.NewServiceSpec(apiSpec.SpecRef, apiSpec.Org, apiSpec.Version, apiSpec.Arch)
@@ -603,10 +614,12 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 					if compatible, reason, _, err := compcheck.VerifyUserInputForSingleService(svcSpec, getService, wi.ConsumerPolicy.UserInput, exchangeDev.UserInput, nil); err != nil {
 						glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("Error validating the user input for dependent service %v/%v %v %v. %v", apiSpec.Org, apiSpec.SpecRef, apiSpec.Version, apiSpec.Arch, err)))
 						userInput_match = false
+						skipReason = fmt.Sprintf("invalid user input: %v", err)
 						break
 					} else if !compatible {
 						glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("User input does not meet the requirement for dependent service %v/%v %v %v. %v", apiSpec.Org, apiSpec.SpecRef, apiSpec.Version, apiSpec.Arch, reason)))
 						userInput_match = false
+						skipReason = fmt.Sprintf("missing user input: %v", reason)
 						break
 					}
 				}
@@ -621,7 +634,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error updating priority in persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 						return
 					}
-				} else if err := b.db.NewWorkloadUsage(wi.Device.Id, wi.ProducerPolicy.HAGroup.Partners, "", wi.ConsumerPolicy.Header.Name, workload.Priority.PriorityValue, workload.Priority.RetryDurationS, workload.Priority.VerifiedDurationS, true, agreementIdString); err != nil {
+				} else if err := b.db.NewWorkloadUsage(wi.Device.Id, &wi.ProducerPolicy.HAGroup, "", wi.ConsumerPolicy.Header.Name, workload.Priority.PriorityValue, workload.Priority.RetryDurationS, workload.Priority.VerifiedDurationS, true, agreementIdString, b.config.GetAgbotExchangeName()); err != nil {
 					glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", wi.Device.Id, wi.ConsumerPolicy.Header.Name, err)))
 					return
 				}
@@ -683,6 +696,7 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 	// exactly what the merged producer policy looks like.
 	if err := b.incompleteHAGroup(cph, &wi.ProducerPolicy); err != nil {
 		glog.Warningf(BAWlogstring(workerId, fmt.Sprintf("received error checking HA group %v completeness for device %v, error: %v", wi.ProducerPolicy.HAGroup, wi.Device.Id, err)))
+		b.auditSkipped(workerId, wi, fmt.Sprintf("incomplete HA group %v: %v", wi.ProducerPolicy.HAGroup.Partners, err))
 		return
 	}
 
@@ -692,11 +706,12 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		return
 	} else if ignore {
 		glog.V(3).Infof(BAWlogstring(workerId, fmt.Sprintf("skipping device %v, advertises ignored property", wi.Device.Id)))
+		b.auditSkipped(workerId, wi, "node advertises an ignored property")
 		return
 	}
 
 	// Create pending agreement in database
-	if err := b.db.AgreementAttempt(agreementIdString, wi.Org, wi.Device.Id, nodeType, wi.ConsumerPolicy.Header.Name, bcType, bcName, bcOrg, cph.Name(), wi.ConsumerPolicy.PatternId, svcIds, wi.ConsumerPolicy.NodeH, b.config.AgreementBot.GetProtocolTimeout(nodeMaxHBInterval), b.config.AgreementBot.GetAgreementTimeout(nodeMaxHBInterval)); err != nil {
+	if err := b.db.AgreementAttempt(agreementIdString, wi.Org, wi.Device.Id, nodeType, wi.ConsumerPolicy.Header.Name, bcType, bcName, bcOrg, cph.Name(), wi.ConsumerPolicy.PatternId, svcIds, wi.ConsumerPolicy.NodeH, b.config.AgreementBot.GetProtocolTimeout(nodeMaxHBInterval), b.config.AgreementBot.GetAgreementTimeout(nodeMaxHBInterval), b.config.GetAgbotExchangeName()); err != nil {
 		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error persisting agreement attempt: %v", err)))
 
 		// Decoding device publicKey to []byte
@@ -721,8 +736,22 @@ func (b *BaseAgreementWorker) InitiateNewAgreement(cph ConsumerProtocolHandler,
 		// Update the agreement in the DB with the proposal and policy
 	} else if err := cph.PersistAgreement(wi, proposal, workerId); err != nil {
 		glog.Errorf(err.Error())
+	} else {
+		b.audit(workerId, persistence.NewAuditRecord(persistence.AUDIT_ATTEMPT, wi.Device.Id, wi.ConsumerPolicy.Header.Name, agreementIdString, 0, fmt.Sprintf("proposed service %v/%v %v", workload.Org, workload.WorkloadURL, workload.Version)))
+	}
+
+}
+
+// Record an agreement decision in the audit trail. A failure to record the decision does not change the decision.
+func (b *BaseAgreementWorker) audit(workerId string, rec *persistence.AuditRecord) {
+	if err := b.db.AddAuditRecord(rec); err != nil {
+		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error recording audit record %v, error: %v", rec, err)))
 	}
+}
 
+// Record that no agreement was proposed to the device in the work item.
+func (b *BaseAgreementWorker) auditSkipped(workerId string, wi *InitiateAgreement, reason string) {
+	b.audit(workerId, persistence.NewAuditRecord(persistence.AUDIT_SKIPPED, wi.Device.Id, wi.ConsumerPolicy.Header.Name, "", 0, reason))
 }
 
 // get the merged producer policy. asl is the spec list for the dependent services for a top level service.
@@ -800,6 +829,13 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 		} else if pol, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
 			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error demarshalling tsandcs policy from pending agreement %v, error: %v", reply.AgreementId(), err)))
 
+			// A producer that does not support the protocol capabilities required by the proposal must not accept it. Older
+			// producers do not know about capabilities, so they accept without agreeing to them. The agreement is cancelled
+			// and the negative reply ack tells the producer to cancel its side.
+		} else if missing := abstractprotocol.MissingCapabilities(proposal, reply); len(missing) != 0 {
+			glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("reply for agreement %v did not agree to required protocol capabilities %v, cancelling agreement", reply.AgreementId(), missing)))
+			b.CancelAgreement(cph, reply.AgreementId(), cph.GetTerminationCode(TERM_REASON_MISSING_CAPABILITIES), workerId)
+
 		} else if err := cph.PersistReply(reply, pol, workerId); err != nil {
 			glog.Errorf(err.Error())
 
@@ -828,7 +864,7 @@ func (b *BaseAgreementWorker) HandleAgreementReply(cph ConsumerProtocolHandler,
 					// Need a new workload usage record but not the same as the highest priority. That can't be right.
 					ackReplyAsValid = false
 				} else if !pol.Workloads[0].HasEmptyPriority() {
-					if err := b.db.NewWorkloadUsage(wi.SenderId, pol.HAGroup.Partners, agreement.Policy, consumerPolicy.Header.Name, pol.Workloads[0].Priority.PriorityValue, pol.Workloads[0].Priority.RetryDurationS, pol.Workloads[0].Priority.VerifiedDurationS, false, reply.AgreementId()); err != nil {
+					if err := b.db.NewWorkloadUsage(wi.SenderId, &pol.HAGroup, agreement.Policy, consumerPolicy.Header.Name, pol.Workloads[0].Priority.PriorityValue, pol.Workloads[0].Priority.RetryDurationS, pol.Workloads[0].Priority.VerifiedDurationS, false, reply.AgreementId(), b.config.GetAgbotExchangeName()); err != nil {
 						glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error creating persistent workload usage records for device %v with policy %v, error: %v", wi.SenderId, consumerPolicy.Header.Name, err)))
 					}
 				}
@@ -1131,6 +1167,18 @@ func (b *BaseAgreementWorker) CancelAgreement(cph ConsumerProtocolHandler, agree
 		glog.Errorf(BAWlogstring(workerId, fmt.Sprintf("error archiving terminated agreement: %v, error: %v", ag.CurrentAgreementId, err)))
 	}
 
+	// A negative reply from the node is recorded as a rejection rather than a cancellation.
+	event := persistence.AUDIT_CANCELLED
+	if reason == cph.GetTerminationCode(TERM_REASON_NEGATIVE_REPLY) {
+		event = persistence.AUDIT_REJECTED
+	}
+	b.audit(workerId, persistence.NewAuditRecord(event, ag.DeviceId, ag.PolicyName, ag.CurrentAgreementId, reason, cph.GetTerminationReason(reason)))
+
+	// The embedded data verification service no longer needs to track the agreement.
+	if dataVerifier != nil {
+		dataVerifier.Forget(ag.CurrentAgreementId)
+	}
+
 	return true
 }
 
@@ -1,6 +1,7 @@
 package agreementbot
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/golang/glog"
@@ -15,6 +16,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -30,6 +32,7 @@ type API struct {
 	em             *events.EventStateManager
 	shutdownError  string
 	configFile     string
+	bulkJobs       *BulkAgreementJobs
 }
 
 func NewAPIListener(name string, config *config.HorizonConfig, db persistence.AgbotDatabase, configFile string) *API {
@@ -46,6 +49,7 @@ func NewAPIListener(name string, config *config.HorizonConfig, db persistence.Ag
 		EC:         worker.NewExchangeContext(config.AgreementBot.ExchangeId, config.AgreementBot.ExchangeToken, config.AgreementBot.ExchangeURL, config.GetAgbotCSSURL(), config.Collaborators.HTTPClientFactory),
 		em:         events.NewEventStateManager(),
 		configFile: configFile,
+		bulkJobs:   NewBulkAgreementJobs(),
 	}
 
 	listener.listen(config.AgreementBot.APIListen)
@@ -253,6 +257,48 @@ func (a *API) ListDeploy(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// The time to wait for the agbot worker to repair its policy caches.
+const POLICY_CACHE_REPAIR_TIMEOUT_S = 300
+
+func (a *API) cacheDiff(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "POST":
+		// Compare the deployment policy, pattern and object policy caches with the exchange.
+		diff, err := GetPolicyCacheDiff(a)
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error checking policy caches, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// The agbot worker owns the caches, so it does the repair. Wait for the repair to complete before responding.
+		if r.Method == "POST" && len(diff.Differences) != 0 {
+			result := make(chan error, 1)
+			a.Messages() <- events.NewABApiPolicyCacheRepairMessage(events.REPAIR_POLICY_CACHE, result)
+			select {
+			case err := <-result:
+				if err != nil {
+					glog.Error(APIlogString(fmt.Sprintf("error repairing policy caches, error: %v", err)))
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+			case <-time.After(POLICY_CACHE_REPAIR_TIMEOUT_S * time.Second):
+				glog.Error(APIlogString(fmt.Sprintf("policy cache repair did not complete within %v seconds", POLICY_CACHE_REPAIR_TIMEOUT_S)))
+				http.Error(w, "Policy cache repair timed out", http.StatusServiceUnavailable)
+				return
+			}
+			diff.Repair = true
+		}
+		writeResponse(w, diff, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 // A local implementation of the ExchangeContext interface because the API object is not an anax worker.
 func (a *API) GetExchangeId() string {
 	if a.EC != nil {
@@ -323,13 +369,18 @@ func (a *API) listen(apiListen string) {
 		router := mux.NewRouter()
 
 		router.HandleFunc("/agreement", a.agreement).Methods("GET", "OPTIONS")
+		router.HandleFunc("/agreement/bulk", a.bulkAgreement).Methods("GET", "POST", "OPTIONS")
+		router.HandleFunc("/agreement/bulk/{id}", a.bulkAgreement).Methods("GET", "OPTIONS")
 		router.HandleFunc("/agreement/{id}", a.agreement).Methods("GET", "DELETE", "OPTIONS")
 		router.HandleFunc("/partition", a.partition).Methods("GET", "OPTIONS")
+		router.HandleFunc("/partition/status", a.partitionStatus).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy", a.policy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy/{org}", a.policy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy/{org}/{name}", a.policy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/policy/{name}/upgrade", a.policy).Methods("POST", "OPTIONS")
 		router.HandleFunc("/workloadusage", a.workloadusage).Methods("GET", "OPTIONS")
+		router.HandleFunc("/metering", a.metering).Methods("GET", "OPTIONS")
+		router.HandleFunc("/audit", a.audit).Methods("GET", "OPTIONS")
 		router.HandleFunc("/status", a.status).Methods("GET", "OPTIONS")
 		router.HandleFunc("/health", a.health).Methods("GET", "OPTIONS")
 		router.HandleFunc("/status/workers", a.workerstatus).Methods("GET", "OPTIONS")
@@ -342,6 +393,7 @@ func (a *API) listen(apiListen string) {
 		router.HandleFunc("/cache/deploymentpol", a.ListDeploy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/cache/deploymentpol/{org}", a.ListDeploy).Methods("GET", "OPTIONS")
 		router.HandleFunc("/cache/deploymentpol/{org}/{name}", a.ListDeploy).Methods("GET", "OPTIONS")
+		router.HandleFunc("/cache/diff", a.cacheDiff).Methods("GET", "POST", "OPTIONS")
 
 		if err := http.ListenAndServe(apiListen, nocache(router)); err != nil {
 			glog.Fatalf(APIlogString(fmt.Sprintf("failed to start listener on %v, error %v", apiListen, err)))
@@ -591,6 +643,17 @@ func (a *API) workloadusage(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case "GET":
+		// The ha query parameter selects the upgrade progress of the HA groups instead of the workload usage records.
+		if r.URL.Query().Get("ha") == "true" {
+			if progress, err := FindHAUpgradeProgress(a.db); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding HA group upgrade progress, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			} else {
+				writeResponse(w, progress, http.StatusOK)
+			}
+			return
+		}
+
 		if wlusages, err := a.db.FindWorkloadUsages([]persistence.WUFilter{}); err != nil {
 			glog.Error(APIlogString(fmt.Sprintf("error finding all workload usages, error: %v", err)))
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -611,6 +674,217 @@ func (a *API) workloadusage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (a *API) bulkAgreement(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "GET":
+		pathVars := mux.Vars(r)
+		id := pathVars["id"]
+
+		if id == "" {
+			writeResponse(w, a.bulkJobs.List(), http.StatusOK)
+		} else if job := a.bulkJobs.Get(id); job == nil {
+			writeInputErr(w, http.StatusNotFound, &APIUserInputError{Input: "id", Error: "bulk agreement job not found"})
+		} else {
+			writeResponse(w, *job, http.StatusOK)
+		}
+
+	case "POST":
+		// Demarshal the input body and verify it.
+		var bulk BulkAgreementRequest
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &bulk); err != nil {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: fmt.Sprintf("user submitted data couldn't be deserialized to struct: %v. Error: %v", string(body), err)})
+			return
+		} else if ok, msg := bulk.IsValid(); !ok {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "body", Error: msg})
+			return
+		}
+		glog.V(3).Infof(APIlogString(fmt.Sprintf("handling POST of bulk agreement %v, selector %v, preview %v", bulk.Action, bulk.Selector, bulk.Preview)))
+
+		ags, err := SelectAgreements(a.db, bulk.Selector, a.agreementNodePolicyHandler())
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error selecting agreements, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if bulk.Preview {
+			preview := BulkAgreementPreview{Action: bulk.Action, Count: len(ags), Agreements: make([]BulkAgreement, 0, len(ags))}
+			for _, ag := range ags {
+				preview.Agreements = append(preview.Agreements, NewBulkAgreement(ag))
+			}
+			writeResponse(w, preview, http.StatusOK)
+			return
+		}
+
+		// The job runs in the background, the caller polls for its status with the job id.
+		job := a.bulkJobs.Add(bulk.Action, bulk.Selector, ags)
+		go a.bulkJobs.Run(job.Id, a.db, a.Messages())
+		writeResponse(w, job, http.StatusAccepted)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Returns the node policy of the node in an agreement from the exchange that the node is in.
+func (a *API) agreementNodePolicyHandler() AgreementNodePolicyHandler {
+	handlers := map[string]exchange.NodePolicyHandler{"": exchange.GetHTTPNodePolicyHandler(a)}
+	for _, fe := range a.Config.GetAgbotFederatedExchanges() {
+		ec := exchange.NewCustomExchangeContext(fe.ExchangeId, fe.ExchangeToken, fe.ExchangeURL, fe.CSSURL, a.Config.Collaborators.HTTPClientFactory)
+		handlers[fe.Name] = exchange.GetHTTPNodePolicyHandler(ec)
+	}
+
+	return func(ag persistence.Agreement) (*exchange.ExchangePolicy, error) {
+		if handler, ok := handlers[ag.Exchange]; !ok {
+			return nil, fmt.Errorf("exchange %v is not served by this agbot", ag.Exchange)
+		} else {
+			return handler(ag.DeviceId)
+		}
+	}
+}
+
+func (a *API) audit(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "GET":
+		// The node and policy are exact matches, since is a number of seconds since 1970 and limit caps the number of records.
+		filter := persistence.AuditFilter{
+			DeviceId:   r.URL.Query().Get("node"),
+			PolicyName: r.URL.Query().Get("policy"),
+		}
+		if s := r.URL.Query().Get("since"); s != "" {
+			if since, err := strconv.ParseUint(s, 10, 64); err != nil {
+				writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "since", Error: fmt.Sprintf("must be a number of seconds since 1970, error: %v", err)})
+				return
+			} else {
+				filter.Since = since
+			}
+		}
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if limit, err := strconv.Atoi(l); err != nil || limit < 0 {
+				writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "limit", Error: "must be a positive number"})
+				return
+			} else {
+				filter.Limit = limit
+			}
+		}
+
+		if records, err := a.db.FindAuditRecords(filter); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error finding audit records, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			writeResponse(w, records, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *API) metering(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "GET":
+		// Get the time range and output format from the query parameters.
+		var since, until uint64
+		var err error
+		if s := r.URL.Query().Get("since"); s != "" {
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "since", Error: fmt.Sprintf("must be a number of seconds since 1970, error: %v", err)})
+				return
+			}
+		}
+		if u := r.URL.Query().Get("until"); u != "" {
+			if until, err = strconv.ParseUint(u, 10, 64); err != nil {
+				writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "until", Error: fmt.Sprintf("must be a number of seconds since 1970, error: %v", err)})
+				return
+			}
+		} else {
+			until = uint64(time.Now().Unix())
+		}
+		if until < since {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "until", Error: "must not be earlier than since"})
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format != "" && format != "json" && format != "csv" {
+			writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "format", Error: "must be json or csv"})
+			return
+		}
+
+		// Build the ledger from all agreements, active and archived.
+		agreements := make([]persistence.Agreement, 0)
+		for _, agp := range policy.AllAgreementProtocols() {
+			if ags, err := a.db.FindAgreements([]persistence.AFilter{}, agp); err != nil {
+				glog.Error(APIlogString(fmt.Sprintf("error finding all agreements, error: %v", err)))
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			} else {
+				agreements = append(agreements, ags...)
+			}
+		}
+
+		// The meters are signed with the agbot's message key.
+		myPubKey, _, err := exchange.GetKeys(a.Config.AgreementBot.MessageKeyPath)
+		if err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error getting keys, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		ledger := NewMeteringLedger(agreements, since, until, myPubKey)
+
+		if format == "csv" {
+			var buf bytes.Buffer
+			if err := ledger.WriteCSV(&buf, r.URL.Query().Get("view")); err != nil {
+				writeInputErr(w, http.StatusBadRequest, &APIUserInputError{Input: "view", Error: err.Error()})
+				return
+			}
+			w.Header().Set("Content-Type", "text/csv")
+			w.WriteHeader(http.StatusOK)
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				glog.Error(APIlogString(err))
+			}
+		} else {
+			writeResponse(w, ledger, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// The agbot status is the common status plus the state of the agbot's work queues, keyed by agreement protocol. When
+// the agbot serves federated exchanges, the status of each federated exchange is included, keyed by exchange name.
+type AgbotStatus struct {
+	*apicommon.Info
+	WorkQueues         map[string]WorkQueueStatus         `json:"workQueues,omitempty"`
+	FederatedExchanges map[string]FederatedExchangeStatus `json:"federatedExchanges,omitempty"`
+}
+
+// Returns the state of the work queue of each agreement protocol.
+func getWorkQueueStatus(mgr *ConsumerPHMgr) map[string]WorkQueueStatus {
+	res := make(map[string]WorkQueueStatus)
+	for _, protocol := range mgr.GetAll() {
+		if cph := mgr.Get(protocol); cph != nil && cph.WorkQueue() != nil {
+			res[protocol] = cph.WorkQueue().Status()
+		}
+	}
+	return res
+}
+
 func (a *API) status(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
@@ -625,7 +899,16 @@ func (a *API) status(w http.ResponseWriter, r *http.Request) {
 		}
 		info.LiveHealth = health
 
-		writeResponse(w, info, http.StatusOK)
+		// Add the state of the work queue of each agreement protocol.
+		status := &AgbotStatus{Info: info}
+		if consumerPHMgr != nil {
+			status.WorkQueues = getWorkQueueStatus(consumerPHMgr)
+		}
+		if len(a.Config.GetAgbotFederatedExchanges()) != 0 {
+			status.FederatedExchanges = getFederatedExchangeStatus(a.Config)
+		}
+
+		writeResponse(w, status, http.StatusOK)
 	case "OPTIONS":
 		w.Header().Set("Allow", "GET, OPTIONS")
 		w.WriteHeader(http.StatusOK)
@@ -768,51 +1051,42 @@ func (a *API) partition(w http.ResponseWriter, r *http.Request) {
 		const AGREEMENT_ARCHIVED_KEY = "archived agreements"
 		const WORKLOAD_USAGES_KEY = "workload usages"
 
-		output := make(map[string]map[string]interface{}, 0)
-
-		if partitions, err := a.db.FindPartitions(); err != nil {
-			glog.Error(APIlogString(fmt.Sprintf("error finding all partitions, error: %v", err)))
+		if loads, err := FindPartitionLoads(a.db); err != nil {
+			glog.Error(APIlogString(err.Error()))
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		} else {
-
-			// For each partition, get a count of records in the partition.
-			for _, p := range partitions {
-				partitionMaps := make(map[string]interface{}, 0)
-
-				// First get the partition owner.
-				if owner, err := a.db.GetPartitionOwner(p); err != nil {
-					glog.Error(APIlogString(fmt.Sprintf("error finding partition %v owner, error: %v", p, err)))
-					http.Error(w, "Internal server error", http.StatusInternalServerError)
-					return
-				} else {
-					partitionMaps[PARTITION_OWNER] = owner
-				}
-
-				// Then get the agreement count.
-				if active, archived, err := a.db.GetAgreementCount(p); err != nil {
-					glog.Error(APIlogString(fmt.Sprintf("error finding agreement count in partition %v, error: %v", p, err)))
-					http.Error(w, "Internal server error", http.StatusInternalServerError)
-					return
-				} else {
-					partitionMaps[AGREEMENT_ACTIVE_KEY] = active
-					partitionMaps[AGREEMENT_ARCHIVED_KEY] = archived
+			output := make(map[string]map[string]interface{}, 0)
+			for _, l := range loads {
+				output[l.Partition] = map[string]interface{}{
+					PARTITION_OWNER:        l.Owner,
+					AGREEMENT_ACTIVE_KEY:   l.ActiveAgreements,
+					AGREEMENT_ARCHIVED_KEY: l.ArchivedAgreements,
+					WORKLOAD_USAGES_KEY:    l.WorkloadUsages,
 				}
+			}
+			writeResponse(w, output, http.StatusOK)
+		}
 
-				// Then get the workload_usage count.
-				if num, err := a.db.GetWorkloadUsagesCount(p); err != nil {
-					glog.Error(APIlogString(fmt.Sprintf("error finding workload usage count in partition %v, error: %v", p, err)))
-					http.Error(w, "Internal server error", http.StatusInternalServerError)
-					return
-				} else {
-					partitionMaps[WORKLOAD_USAGES_KEY] = num
-				}
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
 
-				// Set the values for the current partition
-				output[p] = partitionMaps
+func (a *API) partitionStatus(w http.ResponseWriter, r *http.Request) {
 
-			}
+	switch r.Method {
+	case "GET":
 
-			writeResponse(w, output, http.StatusOK)
+		// Report the owner and the number of agreements and workload usages in each partition, the leader that
+		// rebalances the partitions and the recent partition moves.
+		if status, err := GetPartitionStatus(a.db); err != nil {
+			glog.Error(APIlogString(fmt.Sprintf("error getting partition status, error: %v", err)))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		} else {
+			writeResponse(w, status, http.StatusOK)
 		}
 
 	case "OPTIONS":
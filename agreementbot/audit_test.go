@@ -0,0 +1,52 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_audit_trail(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	now := uint64(time.Now().Unix())
+	records := []*persistence.AuditRecord{
+		{Timestamp: now - 7200, Event: persistence.AUDIT_ATTEMPT, DeviceId: "org1/n1", PolicyName: "org1/bp1", AgreementId: "ag1"},
+		{Timestamp: now - 3600, Event: persistence.AUDIT_CANCELLED, DeviceId: "org1/n1", PolicyName: "org1/bp1", AgreementId: "ag1", ReasonCode: 204, Reason: "agreement bot policy changed"},
+		{Timestamp: now - 60, Event: persistence.AUDIT_SKIPPED, DeviceId: "org1/n1", PolicyName: "org1/bp2", Reason: "no supported workload"},
+		{Timestamp: now, Event: persistence.AUDIT_SKIPPED, DeviceId: "org1/n2", PolicyName: "org1/bp1", Reason: "service org1/svc1 is suspended"},
+	}
+	for _, rec := range records {
+		if err := db.AddAuditRecord(rec); err != nil {
+			t.Fatalf("unable to add audit record %v, error %v", rec, err)
+		}
+	}
+
+	// The most recent decisions are returned first.
+	if found, err := db.FindAuditRecords(persistence.AuditFilter{DeviceId: "org1/n1"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(found) != 3 || found[0].Event != persistence.AUDIT_SKIPPED || found[2].Event != persistence.AUDIT_ATTEMPT {
+		t.Errorf("wrong audit records for node %v", found)
+	} else if found, err := db.FindAuditRecords(persistence.AuditFilter{DeviceId: "org1/n1", PolicyName: "org1/bp1", Since: now - 3600}); err != nil || len(found) != 1 || found[0].ReasonCode != 204 {
+		t.Errorf("wrong audit records for node and policy %v %v", found, err)
+	} else if found, err := db.FindAuditRecords(persistence.AuditFilter{Limit: 2}); err != nil || len(found) != 2 || found[0].DeviceId != "org1/n2" {
+		t.Errorf("wrong limited audit records %v %v", found, err)
+	}
+
+	// Records beyond the age limit are removed first, then the oldest records beyond the size limit.
+	if err := db.PurgeAuditRecords(5000, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if found, err := db.FindAuditRecords(persistence.AuditFilter{}); err != nil || len(found) != 3 {
+		t.Errorf("wrong audit records after age purge %v %v", found, err)
+	} else if err := db.PurgeAuditRecords(0, 1); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if found, err := db.FindAuditRecords(persistence.AuditFilter{}); err != nil || len(found) != 1 || found[0].DeviceId != "org1/n2" {
+		t.Errorf("wrong audit records after size purge %v %v", found, err)
+	}
+
+}
@@ -40,7 +40,7 @@ func NewBasicProtocolHandler(name string, cfg *config.HorizonConfig, db persiste
 			},
 			agreementPH: basicprotocol.NewProtocolHandler(cfg.Collaborators.HTTPClientFactory.NewHTTPClient(nil), pm),
 			// Allow the main agbot thread to distribute protocol msgs and agreement handling to the worker pool.
-			Work: NewPrioritizedWorkQueue(cfg.GetAgbotAgreementQueueSize(), int(cfg.AgreementBot.NewContractIntervalS), cfg.GetAgbotQueueHistorySize()),
+			Work: NewPrioritizedWorkQueue(cfg.GetAgbotAgreementQueueSize(), int(cfg.AgreementBot.NewContractIntervalS), cfg.GetAgbotQueueHistorySize(), cfg.GetAgbotQueueWeights(), cfg.GetAgbotAgreementRateLimits()),
 		}
 	} else {
 		return nil
@@ -122,7 +122,18 @@ func (c *BasicProtocolHandler) HandleBlockchainEvent(cmd *BlockchainEventCommand
 
 func (c *BasicProtocolHandler) CreateMeteringNotification(mp policy.Meter, ag *persistence.Agreement) (*metering.MeteringNotification, error) {
 
-	return metering.NewMeteringNotification(mp, ag.AgreementCreationTime, uint64(ag.DataVerificationCheckRate), ag.DataVerificationMissedCount, ag.CurrentAgreementId, ag.ProposalHash, ag.ConsumerProposalSig, "", ag.ProposalSig, "")
+	mn, err := metering.NewMeteringNotification(mp, ag.AgreementCreationTime, uint64(ag.DataVerificationCheckRate), ag.DataVerificationMissedCount, ag.CurrentAgreementId, ag.ProposalHash, ag.ConsumerProposalSig, "", ag.ProposalSig, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the meter with the agbot's message key so that the notification can be verified in the metering ledger.
+	if _, myPrivKey, err := exchange.GetKeys(c.config.AgreementBot.MessageKeyPath); err != nil {
+		return nil, errors.New(fmt.Sprintf("error getting keys: %v", err))
+	} else if err := mn.SignMeter(myPrivKey); err != nil {
+		return nil, err
+	}
+	return mn, nil
 }
 
 func (c *BasicProtocolHandler) TerminateAgreement(ag *persistence.Agreement, reason uint, workerId string) {
@@ -163,6 +174,10 @@ func (c *BasicProtocolHandler) GetTerminationCode(reason string) uint {
 		return basicprotocol.AB_CANCEL_NODE_HEARTBEAT
 	case TERM_REASON_AG_MISSING:
 		return basicprotocol.AB_CANCEL_AG_MISSING
+	case TERM_REASON_SERVICE_UNHEALTHY:
+		return basicprotocol.AB_CANCEL_SERVICE_UNHEALTHY
+	case TERM_REASON_MISSING_CAPABILITIES:
+		return basicprotocol.AB_CANCEL_MISSING_CAPABILITIES
 	default:
 		return 999
 	}
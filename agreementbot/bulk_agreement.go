@@ -0,0 +1,351 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bulk agreement operations cancel or re-negotiate every active agreement that matches a selector. The agreements
+// are selected by the API and the cancelations are handed to the agbot workers one agreement at a time, through the
+// same events that are used when a single agreement is canceled or upgraded. The agreement workers cancel each
+// agreement while holding its agreement lock, so a bulk operation never races with the other work being done on
+// an agreement.
+
+const BULK_ACTION_CANCEL = "cancel"
+const BULK_ACTION_RENEGOTIATE = "renegotiate"
+
+const BULK_JOB_RUNNING = "running"
+const BULK_JOB_COMPLETED = "completed"
+
+// The number of bulk jobs that are remembered. The oldest completed jobs are forgotten first.
+const MAX_BULK_JOBS = 50
+
+// The criteria used to select agreements. An agreement has to match all of the criteria that are specified.
+type AgreementSelector struct {
+	Policy         string            `json:"policy,omitempty"`          // The name of the deployment policy, org/name
+	Pattern        string            `json:"pattern,omitempty"`         // The name of the pattern, org/name
+	Service        string            `json:"service,omitempty"`         // The service that was deployed by the agreement, org/url
+	ServiceVersion string            `json:"service_version,omitempty"` // The version of the service, requires service
+	NodeOrg        string            `json:"node_org,omitempty"`        // The org of the node
+	NodeProperties map[string]string `json:"node_properties,omitempty"` // Properties that must be in the node policy, with their value
+	OlderThan      string            `json:"older_than,omitempty"`      // The minimum age of the agreement, e.g. 24h
+}
+
+func (s AgreementSelector) String() string {
+	return fmt.Sprintf("Policy: %v, Pattern: %v, Service: %v, ServiceVersion: %v, NodeOrg: %v, NodeProperties: %v, OlderThan: %v",
+		s.Policy, s.Pattern, s.Service, s.ServiceVersion, s.NodeOrg, s.NodeProperties, s.OlderThan)
+}
+
+func (s AgreementSelector) IsEmpty() bool {
+	return s.Policy == "" && s.Pattern == "" && s.Service == "" && s.ServiceVersion == "" && s.NodeOrg == "" && len(s.NodeProperties) == 0 && s.OlderThan == ""
+}
+
+// Returns the database filters for the criteria that can be checked with the agreement alone. The service and node
+// property criteria need more than the agreement record, so they are checked by SelectAgreements.
+func (s AgreementSelector) Filters() ([]persistence.AFilter, error) {
+	filters := []persistence.AFilter{persistence.ActiveAFilter()}
+	if s.Policy != "" {
+		filters = append(filters, persistence.PolicyNameAFilter(s.Policy))
+	}
+	if s.Pattern != "" {
+		filters = append(filters, persistence.PatternAFilter(s.Pattern))
+	}
+	if s.NodeOrg != "" {
+		filters = append(filters, persistence.NodeOrgAFilter(s.NodeOrg))
+	}
+	if s.OlderThan != "" {
+		if age, err := time.ParseDuration(s.OlderThan); err != nil {
+			return nil, fmt.Errorf("older_than %v is not a valid duration, error: %v", s.OlderThan, err)
+		} else if age < 0 {
+			return nil, fmt.Errorf("older_than %v must not be negative", s.OlderThan)
+		} else {
+			filters = append(filters, persistence.OlderThanAFilter(uint64(age.Seconds())))
+		}
+	}
+	return filters, nil
+}
+
+// The input body of the bulk agreement API.
+type BulkAgreementRequest struct {
+	Action   string            `json:"action"`
+	Selector AgreementSelector `json:"selector"`
+	Preview  bool              `json:"preview,omitempty"` // Return the selected agreements without changing them
+}
+
+func (b *BulkAgreementRequest) IsValid() (bool, string) {
+	if b.Action != BULK_ACTION_CANCEL && b.Action != BULK_ACTION_RENEGOTIATE {
+		return false, fmt.Sprintf("action must be %v or %v", BULK_ACTION_CANCEL, BULK_ACTION_RENEGOTIATE)
+	} else if b.Selector.IsEmpty() {
+		return false, "selector must specify at least one criteria"
+	} else if b.Selector.ServiceVersion != "" && b.Selector.Service == "" {
+		return false, "selector service_version requires service"
+	} else if _, err := b.Selector.Filters(); err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+// A summary of an agreement selected by a bulk operation.
+type BulkAgreement struct {
+	AgreementId           string `json:"current_agreement_id"`
+	AgreementProtocol     string `json:"agreement_protocol"`
+	DeviceId              string `json:"device_id"`
+	PolicyName            string `json:"policy_name"`
+	Pattern               string `json:"pattern,omitempty"`
+	Exchange              string `json:"exchange,omitempty"`
+	AgreementCreationTime uint64 `json:"agreement_creation_time"`
+}
+
+func NewBulkAgreement(ag persistence.Agreement) BulkAgreement {
+	return BulkAgreement{
+		AgreementId:           ag.CurrentAgreementId,
+		AgreementProtocol:     ag.AgreementProtocol,
+		DeviceId:              ag.DeviceId,
+		PolicyName:            ag.PolicyName,
+		Pattern:               ag.Pattern,
+		Exchange:              ag.Exchange,
+		AgreementCreationTime: ag.AgreementCreationTime,
+	}
+}
+
+// The output of a bulk agreement preview.
+type BulkAgreementPreview struct {
+	Action     string          `json:"action"`
+	Count      int             `json:"count"`
+	Agreements []BulkAgreement `json:"agreements"`
+}
+
+// Returns the node policy of the node in an agreement.
+type AgreementNodePolicyHandler func(ag persistence.Agreement) (*exchange.ExchangePolicy, error)
+
+// Returns the active agreements that match the selector, oldest first. The node policy handler is called at most
+// once per node, and only when the selector has node properties.
+func SelectAgreements(db persistence.AgbotDatabase, sel AgreementSelector, nodePolicyHandler AgreementNodePolicyHandler) ([]persistence.Agreement, error) {
+
+	filters, err := sel.Filters()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]persistence.Agreement, 0, 10)
+	nodeMatches := make(map[string]bool)
+	for _, agp := range policy.AllAgreementProtocols() {
+		ags, err := db.FindAgreements(filters, agp)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find agreements, error: %v", err)
+		}
+		for _, ag := range ags {
+			if sel.Service != "" && !agreementHasService(ag, sel.Service, sel.ServiceVersion) {
+				continue
+			}
+			if len(sel.NodeProperties) != 0 {
+				match, ok := nodeMatches[ag.DeviceId]
+				if !ok {
+					if match, err = nodeHasProperties(ag, sel.NodeProperties, nodePolicyHandler); err != nil {
+						return nil, err
+					}
+					nodeMatches[ag.DeviceId] = match
+				}
+				if !match {
+					continue
+				}
+			}
+			res = append(res, ag)
+		}
+	}
+
+	sort.Sort(AgreementsByAgreementCreationTime(res))
+	return res, nil
+}
+
+// Returns true if the proposal of the agreement deploys the service, org/url, at the version when one is given.
+func agreementHasService(ag persistence.Agreement, service string, version string) bool {
+	if ag.Proposal == "" {
+		return false
+	} else if proposal, err := abstractprotocol.DemarshalProposal(ag.Proposal); err != nil {
+		glog.Warningf(APIlogString(fmt.Sprintf("unable to demarshal proposal for agreement %v, error: %v", ag.CurrentAgreementId, err)))
+		return false
+	} else if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
+		glog.Warningf(APIlogString(fmt.Sprintf("unable to demarshal TsAndCs for agreement %v, error: %v", ag.CurrentAgreementId, err)))
+		return false
+	} else {
+		for _, wl := range tcPolicy.Workloads {
+			if fmt.Sprintf("%v/%v", wl.Org, wl.WorkloadURL) == service && (version == "" || wl.Version == version) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if the node policy of the node in the agreement has all the properties with the given values.
+func nodeHasProperties(ag persistence.Agreement, props map[string]string, nodePolicyHandler AgreementNodePolicyHandler) (bool, error) {
+	nodePol, err := nodePolicyHandler(ag)
+	if err != nil {
+		return false, fmt.Errorf("unable to get the node policy of %v, error: %v", ag.DeviceId, err)
+	} else if nodePol == nil {
+		return false, nil
+	}
+
+	for name, value := range props {
+		found := false
+		for _, prop := range nodePol.Properties {
+			if prop.Name == name && fmt.Sprintf("%v", prop.Value) == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// A bulk operation that runs in the background. The counters are updated as the job works through the agreements.
+type BulkAgreementJob struct {
+	Id         string            `json:"id"`
+	Action     string            `json:"action"`
+	Selector   AgreementSelector `json:"selector"`
+	State      string            `json:"state"`
+	Total      int               `json:"total"`
+	Processed  int               `json:"processed"` // The agreements handed to the agbot workers
+	Skipped    int               `json:"skipped"`   // The agreements that ended before the job got to them
+	Errors     int               `json:"errors"`
+	LastError  string            `json:"last_error,omitempty"`
+	StartTime  uint64            `json:"start_time"`
+	EndTime    uint64            `json:"end_time,omitempty"`
+	agreements []persistence.Agreement
+}
+
+func (b BulkAgreementJob) String() string {
+	return fmt.Sprintf("Id: %v, Action: %v, Selector: %v, State: %v, Total: %v, Processed: %v, Skipped: %v, Errors: %v",
+		b.Id, b.Action, b.Selector, b.State, b.Total, b.Processed, b.Skipped, b.Errors)
+}
+
+// The bulk jobs known to the API, oldest first.
+type BulkAgreementJobs struct {
+	lock   sync.Mutex
+	nextId int
+	jobs   []*BulkAgreementJob
+}
+
+func NewBulkAgreementJobs() *BulkAgreementJobs {
+	return &BulkAgreementJobs{
+		nextId: 1,
+		jobs:   make([]*BulkAgreementJob, 0, 10),
+	}
+}
+
+// Create a job for the selected agreements. When there are too many jobs, the oldest completed job is forgotten.
+func (b *BulkAgreementJobs) Add(action string, sel AgreementSelector, ags []persistence.Agreement) BulkAgreementJob {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	job := &BulkAgreementJob{
+		Id:         strconv.Itoa(b.nextId),
+		Action:     action,
+		Selector:   sel,
+		State:      BULK_JOB_RUNNING,
+		Total:      len(ags),
+		StartTime:  uint64(time.Now().Unix()),
+		agreements: ags,
+	}
+	b.nextId += 1
+
+	if len(b.jobs) >= MAX_BULK_JOBS {
+		for ix, j := range b.jobs {
+			if j.State == BULK_JOB_COMPLETED {
+				b.jobs = append(b.jobs[:ix], b.jobs[ix+1:]...)
+				break
+			}
+		}
+	}
+	b.jobs = append(b.jobs, job)
+	return *job
+}
+
+// Returns a copy of a job, or nil if the job is not known.
+func (b *BulkAgreementJobs) Get(id string) *BulkAgreementJob {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, j := range b.jobs {
+		if j.Id == id {
+			job := *j
+			return &job
+		}
+	}
+	return nil
+}
+
+// Returns a copy of all the jobs.
+func (b *BulkAgreementJobs) List() []BulkAgreementJob {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	res := make([]BulkAgreementJob, 0, len(b.jobs))
+	for _, j := range b.jobs {
+		res = append(res, *j)
+	}
+	return res
+}
+
+func (b *BulkAgreementJobs) update(id string, fn func(job *BulkAgreementJob)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, j := range b.jobs {
+		if j.Id == id {
+			fn(j)
+			return
+		}
+	}
+}
+
+// Work through the agreements of a job. Each agreement is checked again before it is handed to the agbot workers,
+// because it might have ended since it was selected. The messages channel is the API's channel to the agbot workers,
+// sending to it paces the job to the rate at which the workers pick up the requests.
+func (b *BulkAgreementJobs) Run(id string, db persistence.AgbotDatabase, messages chan events.Message) {
+
+	job := b.Get(id)
+	if job == nil {
+		return
+	}
+	glog.V(3).Infof(APIlogString(fmt.Sprintf("starting bulk agreement job %v", job)))
+
+	for _, ag := range job.agreements {
+		if cur, err := db.FindSingleAgreementByAgreementId(ag.CurrentAgreementId, ag.AgreementProtocol, []persistence.AFilter{persistence.ActiveAFilter()}); err != nil {
+			glog.Errorf(APIlogString(fmt.Sprintf("bulk agreement job %v unable to find agreement %v, error: %v", id, ag.CurrentAgreementId, err)))
+			b.update(id, func(j *BulkAgreementJob) {
+				j.Errors += 1
+				j.LastError = fmt.Sprintf("unable to find agreement %v, error: %v", ag.CurrentAgreementId, err)
+			})
+		} else if cur == nil {
+			b.update(id, func(j *BulkAgreementJob) { j.Skipped += 1 })
+		} else {
+			if job.Action == BULK_ACTION_RENEGOTIATE {
+				messages <- events.NewABApiWorkloadUpgradeMessage(events.WORKLOAD_UPGRADE, cur.AgreementProtocol, cur.CurrentAgreementId, cur.DeviceId, cur.PolicyName)
+			} else {
+				messages <- events.NewABApiAgreementCancelationMessage(events.AGREEMENT_ENDED, cur.AgreementProtocol, cur.CurrentAgreementId)
+			}
+			b.update(id, func(j *BulkAgreementJob) { j.Processed += 1 })
+		}
+	}
+
+	b.update(id, func(j *BulkAgreementJob) {
+		j.State = BULK_JOB_COMPLETED
+		j.EndTime = uint64(time.Now().Unix())
+		j.agreements = nil
+	})
+	glog.V(3).Infof(APIlogString(fmt.Sprintf("bulk agreement job %v completed", id)))
+}
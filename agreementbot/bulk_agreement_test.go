@@ -0,0 +1,89 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/policy"
+	"os"
+	"testing"
+)
+
+func Test_bulk_agreement_select(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	if err := db.AgreementAttempt("ag1", "org1", "org1/d1", "device", "org1/bp1", "", "", "", policy.BasicProtocol, "", []string{"org1/svc1"}, policy.NodeHealth{}, 0, 0, ""); err != nil {
+		t.Fatalf("unable to create agreement, error %v", err)
+	} else if err := db.AgreementAttempt("ag2", "org1", "org2/d2", "device", "org1/bp1", "", "", "", policy.BasicProtocol, "", []string{"org1/svc1"}, policy.NodeHealth{}, 0, 0, ""); err != nil {
+		t.Fatalf("unable to create agreement, error %v", err)
+	} else if err := db.AgreementAttempt("ag3", "org1", "org1/d3", "device", "org1/pat1", "", "", "", policy.BasicProtocol, "org1/pat1", []string{}, policy.NodeHealth{}, 0, 0, ""); err != nil {
+		t.Fatalf("unable to create agreement, error %v", err)
+	} else if _, err := db.AgreementTimedout("ag3", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to time out agreement, error %v", err)
+	}
+
+	nodePolicies := func(ag persistence.Agreement) (*exchange.ExchangePolicy, error) {
+		pol := &exchange.ExchangePolicy{}
+		if ag.DeviceId == "org1/d1" {
+			pol.Properties = externalpolicy.PropertyList{*externalpolicy.Property_Factory("zone", "east")}
+		}
+		return pol, nil
+	}
+
+	// The agreement being terminated is never selected.
+	if ags, err := SelectAgreements(db, AgreementSelector{Policy: "org1/bp1"}, nodePolicies); err != nil || len(ags) != 2 {
+		t.Errorf("expected 2 agreements for the policy, got %v, error %v", ags, err)
+	} else if ags, err := SelectAgreements(db, AgreementSelector{Pattern: "org1/pat1"}, nodePolicies); err != nil || len(ags) != 0 {
+		t.Errorf("expected no agreements for the pattern, got %v, error %v", ags, err)
+	} else if ags, err := SelectAgreements(db, AgreementSelector{NodeOrg: "org2"}, nodePolicies); err != nil || len(ags) != 1 || ags[0].CurrentAgreementId != "ag2" {
+		t.Errorf("expected agreement ag2 for the node org, got %v, error %v", ags, err)
+	} else if ags, err := SelectAgreements(db, AgreementSelector{NodeProperties: map[string]string{"zone": "east"}}, nodePolicies); err != nil || len(ags) != 1 || ags[0].CurrentAgreementId != "ag1" {
+		t.Errorf("expected agreement ag1 for the node property, got %v, error %v", ags, err)
+	} else if ags, err := SelectAgreements(db, AgreementSelector{Policy: "org1/bp1", OlderThan: "1h"}, nodePolicies); err != nil || len(ags) != 0 {
+		t.Errorf("expected no agreements older than 1 hour, got %v, error %v", ags, err)
+	} else if ags, err := SelectAgreements(db, AgreementSelector{Service: "org1/svc1"}, nodePolicies); err != nil || len(ags) != 0 {
+		t.Errorf("expected no agreements without a proposal, got %v, error %v", ags, err)
+	}
+
+	// A bulk cancel hands each selected agreement to the agbot workers, unless it ended after it was selected.
+	jobs := NewBulkAgreementJobs()
+	ags, _ := SelectAgreements(db, AgreementSelector{Policy: "org1/bp1"}, nodePolicies)
+	job := jobs.Add(BULK_ACTION_CANCEL, AgreementSelector{Policy: "org1/bp1"}, ags)
+	if _, err := db.AgreementTimedout("ag2", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to time out agreement, error %v", err)
+	}
+
+	messages := make(chan events.Message, 10)
+	jobs.Run(job.Id, db, messages)
+	if j := jobs.Get(job.Id); j == nil || j.State != BULK_JOB_COMPLETED || j.Total != 2 || j.Processed != 1 || j.Skipped != 1 {
+		t.Errorf("wrong job status %v", j)
+	} else if msg, ok := (<-messages).(*events.ABApiAgreementCancelationMessage); !ok || msg.AgreementId != "ag1" {
+		t.Errorf("expected a cancelation of ag1, got %v", msg)
+	}
+
+}
+
+func Test_bulk_agreement_request_valid(t *testing.T) {
+
+	for _, req := range []BulkAgreementRequest{
+		{Action: "delete", Selector: AgreementSelector{Policy: "org1/bp1"}},
+		{Action: BULK_ACTION_CANCEL},
+		{Action: BULK_ACTION_CANCEL, Selector: AgreementSelector{ServiceVersion: "1.0.0"}},
+		{Action: BULK_ACTION_RENEGOTIATE, Selector: AgreementSelector{OlderThan: "yesterday"}},
+	} {
+		if ok, _ := req.IsValid(); ok {
+			t.Errorf("expected request %v to be invalid", req)
+		}
+	}
+
+	req := BulkAgreementRequest{Action: BULK_ACTION_RENEGOTIATE, Selector: AgreementSelector{Service: "org1/svc1", ServiceVersion: "1.0.0", OlderThan: "24h"}}
+	if ok, msg := req.IsValid(); !ok {
+		t.Errorf("expected request %v to be valid, error %v", req, msg)
+	}
+
+}
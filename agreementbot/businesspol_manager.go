@@ -96,7 +96,7 @@ func (p *BusinessPolicyEntry) DeepCopy() *BusinessPolicyEntry {
 	if p.Hash == nil {
 		newHash = nil
 	} else {
-		newHash := make([]byte, len(p.Hash))
+		newHash = make([]byte, len(p.Hash))
 		copy(newHash, p.Hash)
 	}
 
@@ -0,0 +1,379 @@
+package agreementbot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/semanticversion"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The deployment policy, pattern and object policy managers keep a cache of the exchange resources that the agbot serves.
+// The caches are kept up to date from the exchange changes, so a missed change leaves a cache out of step with the
+// exchange until the resource changes again. The policy cache check fetches the served resources from the exchange and
+// compares them with the caches. A repair hands the fetched resources to the managers, the same way a change does.
+
+const CACHE_DEPLOYMENT_POLICY = "deploymentpol"
+const CACHE_PATTERN = "pattern"
+const CACHE_OBJECT_POLICY = "objectpol"
+
+const CACHE_DIFF_MISSING = "missing" // In the exchange but not in the cache
+const CACHE_DIFF_EXTRA = "extra"     // In the cache but not in the exchange
+const CACHE_DIFF_CHANGED = "changed" // In both, with a different definition
+
+type CacheDifference struct {
+	Cache  string `json:"cache"`
+	Org    string `json:"org"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (d CacheDifference) String() string {
+	return fmt.Sprintf("%v %v/%v is %v", d.Cache, d.Org, d.Name, d.Reason)
+}
+
+type CacheDiff struct {
+	Checked     int64             `json:"checked"` // The time of the check
+	Differences []CacheDifference `json:"differences"`
+	Repair      bool              `json:"repair"` // True when a repair of the differences was started
+}
+
+// The served resources in the exchange, by org.
+type exchangeCacheState struct {
+	policies map[string]map[string]exchange.ExchangeBusinessPolicy
+	patterns map[string]map[string]exchange.Pattern
+	objects  map[string]exchange.ObjectDestinationPolicies
+}
+
+// Return true if the org exists in the exchange. Only an org that the exchange does not have is reported as missing, any
+// other error is returned so that the caches are not compared with a partial exchange state.
+func orgExists(getOrganization exchange.OrgHandler, org string) (bool, error) {
+	if _, err := getOrganization(org); err == nil {
+		return true, nil
+	} else if exchange.IsOrgNotFound(err) {
+		glog.V(5).Infof(AWlogString(fmt.Sprintf("organization %v no longer exists: %v", org, err)))
+		return false, nil
+	} else {
+		return false, fmt.Errorf("unable to get organization %v, error %v", org, err)
+	}
+}
+
+// Fetch the resources in the orgs known to the managers. An org that no longer exists has no resources. Any other error
+// fetching the resources is returned, the state is never partial.
+func getExchangeCacheState(ec exchange.ExchangeContext) (*exchangeCacheState, error) {
+
+	state := &exchangeCacheState{
+		policies: make(map[string]map[string]exchange.ExchangeBusinessPolicy),
+		patterns: make(map[string]map[string]exchange.Pattern),
+		objects:  make(map[string]exchange.ObjectDestinationPolicies),
+	}
+
+	getOrganization := exchange.GetHTTPExchangeOrgHandler(ec)
+
+	if businessPolManager != nil {
+		getBusinessPolicies := exchange.GetHTTPBusinessPoliciesHandler(ec)
+		for _, org := range businessPolManager.GetAllPolicyOrgs() {
+			state.policies[org] = make(map[string]exchange.ExchangeBusinessPolicy)
+			if exists, err := orgExists(getOrganization, org); err != nil {
+				return nil, err
+			} else if !exists {
+				continue
+			} else if pols, err := getBusinessPolicies(org, ""); err != nil {
+				return nil, fmt.Errorf("unable to get deployment policies for org %v, error %v", org, err)
+			} else {
+				state.policies[org] = pols
+			}
+		}
+	}
+
+	if patternManager != nil {
+		for _, org := range patternManager.GetAllPatternOrgs() {
+			state.patterns[org] = make(map[string]exchange.Pattern)
+			if exists, err := orgExists(getOrganization, org); err != nil {
+				return nil, err
+			} else if !exists {
+				continue
+			} else if pats, err := exchange.GetPatterns(ec.GetHTTPFactory(), org, "", ec.GetExchangeURL(), ec.GetExchangeId(), ec.GetExchangeToken()); err != nil {
+				return nil, fmt.Errorf("unable to get patterns for org %v, error %v", org, err)
+			} else {
+				state.patterns[org] = pats
+			}
+		}
+	}
+
+	if objectPolManager != nil {
+		getObjectPolicies := exchange.GetHTTPObjectPolicyUpdatesQueryHandler(ec)
+		for _, org := range objectPolManager.GetAllPolicyOrgs() {
+			if objPols, err := getObjectPolicies(org, 0); err != nil {
+				return nil, fmt.Errorf("unable to get object policies for org %v, error %v", org, err)
+			} else if objPols != nil {
+				state.objects[org] = *objPols
+			}
+		}
+	}
+
+	return state, nil
+}
+
+// Compare the cached deployment policies in an org with the ones in the exchange that the agbot serves.
+func diffBusinessPolicies(org string, cached map[string]*BusinessPolicyEntry, defined map[string]exchange.ExchangeBusinessPolicy, serves func(string, string) bool) ([]CacheDifference, error) {
+
+	diffs := make([]CacheDifference, 0)
+	found := make(map[string]bool)
+	for polId, exPol := range defined {
+		name := exchange.GetId(polId)
+		if !serves(org, name) {
+			continue
+		}
+		found[name] = true
+
+		pol := exPol.GetBusinessPolicy()
+		if pe, ok := cached[name]; !ok || pe == nil {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_DEPLOYMENT_POLICY, Org: org, Name: name, Reason: CACHE_DIFF_MISSING})
+		} else if hash, err := hashPolicy(&pol); err != nil {
+			return nil, fmt.Errorf("unable to hash the deployment policy %v, error %v", polId, err)
+		} else if !bytes.Equal(pe.Hash, hash) {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_DEPLOYMENT_POLICY, Org: org, Name: name, Reason: CACHE_DIFF_CHANGED})
+		}
+	}
+
+	for name := range cached {
+		if !found[name] {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_DEPLOYMENT_POLICY, Org: org, Name: name, Reason: CACHE_DIFF_EXTRA})
+		}
+	}
+	return diffs, nil
+}
+
+// Compare the cached patterns in an org with the ones in the exchange that the agbot serves.
+func diffPatterns(org string, cached map[string]*PatternEntry, defined map[string]exchange.Pattern, serves func(string, string) bool) ([]CacheDifference, error) {
+
+	diffs := make([]CacheDifference, 0)
+	found := make(map[string]bool)
+	for patternId, pat := range defined {
+		pattern := pat
+		name := exchange.GetId(patternId)
+		if !serves(org, name) {
+			continue
+		}
+		found[name] = true
+
+		if pe, ok := cached[name]; !ok || pe == nil {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_PATTERN, Org: org, Name: name, Reason: CACHE_DIFF_MISSING})
+		} else if hash, err := hashPattern(&pattern); err != nil {
+			return nil, fmt.Errorf("unable to hash the pattern %v, error %v", patternId, err)
+		} else if !bytes.Equal(pe.Hash, hash) {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_PATTERN, Org: org, Name: name, Reason: CACHE_DIFF_CHANGED})
+		}
+	}
+
+	for name := range cached {
+		if !found[name] {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_PATTERN, Org: org, Name: name, Reason: CACHE_DIFF_EXTRA})
+		}
+	}
+	return diffs, nil
+}
+
+// Compare the cached object policies in an org with the ones in the MMS. Only the destination policy is compared, the
+// object's destinations change as the object is delivered. An object is only cached when it refers to a service with
+// a valid version range.
+func diffObjectPolicies(org string, cached exchange.ObjectDestinationPolicies, defined exchange.ObjectDestinationPolicies) ([]CacheDifference, error) {
+
+	cachedPols := make(map[string]exchange.ObjectDestinationPolicy)
+	for _, objPol := range cached {
+		cachedPols[objectPolicyKey(&objPol)] = objPol
+	}
+
+	diffs := make([]CacheDifference, 0)
+	found := make(map[string]bool)
+	for _, objPol := range defined {
+		cacheable := false
+		for _, serviceID := range objPol.DestinationPolicy.Services {
+			if _, err := semanticversion.Version_Expression_Factory(serviceID.Version); err == nil {
+				cacheable = true
+				break
+			}
+		}
+		if !cacheable {
+			continue
+		}
+
+		key := objectPolicyKey(&objPol)
+		found[key] = true
+
+		if cachedPol, ok := cachedPols[key]; !ok {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_OBJECT_POLICY, Org: org, Name: key, Reason: CACHE_DIFF_MISSING})
+		} else if cachedHash, err := hashPolicy(cachedPol.DestinationPolicy); err != nil {
+			return nil, fmt.Errorf("unable to hash the cached object policy %v, error %v", key, err)
+		} else if hash, err := hashPolicy(objPol.DestinationPolicy); err != nil {
+			return nil, fmt.Errorf("unable to hash the object policy %v, error %v", key, err)
+		} else if !bytes.Equal(cachedHash, hash) {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_OBJECT_POLICY, Org: org, Name: key, Reason: CACHE_DIFF_CHANGED})
+		}
+	}
+
+	for key := range cachedPols {
+		if !found[key] {
+			diffs = append(diffs, CacheDifference{Cache: CACHE_OBJECT_POLICY, Org: org, Name: key, Reason: CACHE_DIFF_EXTRA})
+		}
+	}
+	return diffs, nil
+}
+
+// Object policies are identified by their type and id within an org.
+func objectPolicyKey(objPol *exchange.ObjectDestinationPolicy) string {
+	return fmt.Sprintf("%v %v", objPol.ObjectType, objPol.ObjectID)
+}
+
+// Compare all the caches with the exchange state.
+func diffPolicyCaches(state *exchangeCacheState) (*CacheDiff, error) {
+
+	diff := &CacheDiff{Checked: time.Now().Unix(), Differences: make([]CacheDifference, 0)}
+
+	if businessPolManager != nil {
+		orgPolicies := businessPolManager.GetOrgPolicies()
+		for org, defined := range state.policies {
+			if diffs, err := diffBusinessPolicies(org, orgPolicies[org], defined, businessPolManager.serveBusinessPolicy); err != nil {
+				return nil, err
+			} else {
+				diff.Differences = append(diff.Differences, diffs...)
+			}
+		}
+	}
+
+	if patternManager != nil {
+		orgPatterns := patternManager.GetOrgPatterns()
+		for org, defined := range state.patterns {
+			if diffs, err := diffPatterns(org, orgPatterns[org], defined, patternManager.servePattern); err != nil {
+				return nil, err
+			} else {
+				diff.Differences = append(diff.Differences, diffs...)
+			}
+		}
+	}
+
+	if objectPolManager != nil {
+		orgObjects := objectPolManager.GetOrgObjectPolicies()
+		for org, cached := range orgObjects {
+			if diffs, err := diffObjectPolicies(org, cached, state.objects[org]); err != nil {
+				return nil, err
+			} else {
+				diff.Differences = append(diff.Differences, diffs...)
+			}
+		}
+	}
+
+	sort.Slice(diff.Differences, func(i, j int) bool {
+		return diff.Differences[i].String() < diff.Differences[j].String()
+	})
+	return diff, nil
+}
+
+// Fetch the exchange state and compare the caches with it. Nothing is changed.
+func GetPolicyCacheDiff(ec exchange.ExchangeContext) (*CacheDiff, error) {
+	if state, err := getExchangeCacheState(ec); err != nil {
+		return nil, err
+	} else {
+		return diffPolicyCaches(state)
+	}
+}
+
+// This function is called by the policy cache check subworker. The check does not change the caches, a repair is
+// queued to the agbot worker, which owns the caches.
+func (w *AgreementBotWorker) checkPolicyCaches() int {
+
+	diff, err := GetPolicyCacheDiff(w)
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to check policy caches, error: %v", err)))
+		return 0
+	}
+
+	for _, d := range diff.Differences {
+		glog.Warningf(AWlogString(fmt.Sprintf("policy cache drift: %v", d)))
+	}
+
+	if w.Config.IsPolicyCacheRepairEnabled() && len(diff.Differences) != 0 {
+		w.Commands <- NewPolicyCacheRepairCommand(nil)
+	} else {
+		glog.V(5).Infof(AWlogString(fmt.Sprintf("policy cache check found %v differences", len(diff.Differences))))
+	}
+	return 0
+}
+
+// Compare the caches with the exchange and repair the differences. This function runs on the agbot worker thread.
+func (w *AgreementBotWorker) repairPolicyCaches() error {
+
+	state, err := getExchangeCacheState(w)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to check policy caches, error: %v", err))
+	}
+
+	diff, err := diffPolicyCaches(state)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to check policy caches, error: %v", err))
+	} else if len(diff.Differences) == 0 {
+		glog.V(5).Infof(AWlogString("policy caches are already up to date"))
+		return nil
+	}
+
+	repairOrgs := make(map[string]map[string]bool)
+	for _, d := range diff.Differences {
+		if repairOrgs[d.Cache] == nil {
+			repairOrgs[d.Cache] = make(map[string]bool)
+		}
+		repairOrgs[d.Cache][d.Org] = true
+	}
+
+	// The managers reconcile a whole org with the exchange state they are given.
+	repairErrs := make([]string, 0)
+	for org := range repairOrgs[CACHE_DEPLOYMENT_POLICY] {
+		if err := businessPolManager.UpdatePolicies(org, state.policies[org], w.pm); err != nil {
+			repairErrs = append(repairErrs, fmt.Sprintf("unable to repair deployment policies for org %v, error: %v", org, err))
+		}
+	}
+
+	for org := range repairOrgs[CACHE_PATTERN] {
+		if err := patternManager.UpdatePatternPolicies(org, state.patterns[org], w.Config.AgreementBot.PolicyPath); err != nil {
+			repairErrs = append(repairErrs, fmt.Sprintf("unable to repair patterns for org %v, error: %v", org, err))
+		}
+	}
+	if len(repairOrgs[CACHE_PATTERN]) != 0 {
+		w.nodeSearch.SetRescanNeeded()
+	}
+
+	// The object policy manager only adds and updates policies, so the extra ones are removed first.
+	for org := range repairOrgs[CACHE_OBJECT_POLICY] {
+		defined := make(map[string]bool)
+		for _, objPol := range state.objects[org] {
+			defined[objectPolicyKey(&objPol)] = true
+		}
+		extra := make(exchange.ObjectDestinationPolicies, 0)
+		for _, objPol := range objectPolManager.GetOrgObjectPolicies()[org] {
+			if !defined[objectPolicyKey(&objPol)] {
+				extra = append(extra, objPol)
+			}
+		}
+		objectPolManager.RemoveObjectPolicies(org, extra)
+
+		objPols := state.objects[org]
+		if events, err := objectPolManager.UpdatePolicies(org, &objPols, exchange.GetHTTPObjectQueryHandler(w)); err != nil {
+			repairErrs = append(repairErrs, fmt.Sprintf("unable to repair object policies for org %v, error: %v", org, err))
+		} else {
+			for _, ev := range events {
+				w.Messages() <- ev
+			}
+		}
+	}
+
+	if len(repairErrs) != 0 {
+		return errors.New(strings.Join(repairErrs, ", "))
+	}
+
+	glog.V(3).Infof(AWlogString(fmt.Sprintf("repaired %v policy cache differences", len(diff.Differences))))
+	return nil
+}
@@ -0,0 +1,149 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"errors"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/edge-sync-service/common"
+	"testing"
+)
+
+func cacheDiffReasons(diffs []CacheDifference) map[string]string {
+	reasons := make(map[string]string)
+	for _, d := range diffs {
+		reasons[d.Name] = d.Reason
+	}
+	return reasons
+}
+
+func Test_diff_business_policies(t *testing.T) {
+
+	cached := make(map[string]*BusinessPolicyEntry)
+	for name, bp := range map[string]string{"bp1": "1.0.0", "bp2": "1.0.0", "bp4": "1.0.0"} {
+		if pe, err := NewBusinessPolicyEntry(whatifBusinessPolicy(bp, "color == red"), "org1/"+name); err != nil {
+			t.Fatalf("unable to create policy entry, error %v", err)
+		} else {
+			cached[name] = pe
+		}
+	}
+
+	defined := map[string]exchange.ExchangeBusinessPolicy{
+		"org1/bp1": {BusinessPolicy: *whatifBusinessPolicy("1.0.0", "color == red")},
+		"org1/bp2": {BusinessPolicy: *whatifBusinessPolicy("2.0.0", "color == red")},
+		"org1/bp3": {BusinessPolicy: *whatifBusinessPolicy("1.0.0", "color == red")},
+		"org1/bp5": {BusinessPolicy: *whatifBusinessPolicy("1.0.0", "color == red")},
+	}
+	serves := func(org string, name string) bool { return name != "bp5" }
+
+	// The entries copied out of the manager keep their hash, so an unchanged policy is not reported.
+	if diffs, err := diffBusinessPolicies("org1", cached, defined, serves); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if reasons := cacheDiffReasons(diffs); len(reasons) != 3 {
+		t.Errorf("wrong differences %v", diffs)
+	} else if reasons["bp2"] != CACHE_DIFF_CHANGED || reasons["bp3"] != CACHE_DIFF_MISSING || reasons["bp4"] != CACHE_DIFF_EXTRA {
+		t.Errorf("wrong differences %v", diffs)
+	} else if diffs, err := diffBusinessPolicies("org1", map[string]*BusinessPolicyEntry{"bp1": cached["bp1"].DeepCopy()}, map[string]exchange.ExchangeBusinessPolicy{"org1/bp1": defined["org1/bp1"]}, serves); err != nil || len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v %v", diffs, err)
+	}
+
+}
+
+func Test_diff_patterns(t *testing.T) {
+
+	p1 := exchange.Pattern{Label: "p1"}
+	pe, err := NewPatternEntry(&p1)
+	if err != nil {
+		t.Fatalf("unable to create pattern entry, error %v", err)
+	}
+
+	cached := map[string]*PatternEntry{"p1": pe}
+	defined := map[string]exchange.Pattern{"org1/p1": {Label: "p1"}, "org1/p2": {Label: "p2"}}
+	serves := func(org string, name string) bool { return true }
+
+	if diffs, err := diffPatterns("org1", cached, defined, serves); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(diffs) != 1 || diffs[0].Name != "p2" || diffs[0].Reason != CACHE_DIFF_MISSING {
+		t.Errorf("wrong differences %v", diffs)
+	}
+
+	defined["org1/p1"] = exchange.Pattern{Label: "p1", Description: "changed"}
+	delete(defined, "org1/p2")
+	if diffs, err := diffPatterns("org1", cached, defined, serves); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(diffs) != 1 || diffs[0].Name != "p1" || diffs[0].Reason != CACHE_DIFF_CHANGED {
+		t.Errorf("wrong differences %v", diffs)
+	}
+
+}
+
+func Test_diff_object_policies(t *testing.T) {
+
+	objPolicy := func(id string, constraint string, version string) exchange.ObjectDestinationPolicy {
+		return exchange.ObjectDestinationPolicy{
+			OrgID:      "org1",
+			ObjectType: "model",
+			ObjectID:   id,
+			DestinationPolicy: exchange.DestinationPolicy{
+				Constraints: externalpolicy.ConstraintExpression{constraint},
+				Services:    []common.ServiceID{{OrgID: "org1", ServiceName: "svc1", Arch: "amd64", Version: version}},
+			},
+		}
+	}
+
+	om := NewMMSObjectPolicyManager(getBasicConfig())
+	if err := om.SetCurrentPolicyOrgs(map[string]exchange.ServedBusinessPolicy{"org1_bp": {BusinessPolOrg: "org1", BusinessPol: "bp1"}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cached := exchange.ObjectDestinationPolicies{objPolicy("obj1", "color == red", "1.0.0"), objPolicy("obj2", "color == red", "1.0.0")}
+	if _, err := om.UpdatePolicies("org1", &cached, getDummyObjectQueryHandler()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if orgPolicies := om.GetOrgObjectPolicies(); len(orgPolicies["org1"]) != 2 {
+		t.Fatalf("wrong cached object policies %v", orgPolicies)
+	}
+
+	// Objects without a valid service version are never cached, so they are not reported.
+	defined := exchange.ObjectDestinationPolicies{
+		objPolicy("obj1", "color == blue", "1.0.0"),
+		objPolicy("obj3", "color == red", "1.0.0"),
+		objPolicy("obj4", "color == red", "not a version"),
+	}
+	if diffs, err := diffObjectPolicies("org1", om.GetOrgObjectPolicies()["org1"], defined); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if reasons := cacheDiffReasons(diffs); len(reasons) != 3 {
+		t.Errorf("wrong differences %v", diffs)
+	} else if reasons["model obj1"] != CACHE_DIFF_CHANGED || reasons["model obj2"] != CACHE_DIFF_EXTRA || reasons["model obj3"] != CACHE_DIFF_MISSING {
+		t.Errorf("wrong differences %v", diffs)
+	}
+
+	om.RemoveObjectPolicies("org1", exchange.ObjectDestinationPolicies{objPolicy("obj2", "", "")})
+	if orgPolicies := om.GetOrgObjectPolicies(); len(orgPolicies["org1"]) != 1 || orgPolicies["org1"][0].ObjectID != "obj1" {
+		t.Errorf("wrong cached object policies %v", orgPolicies)
+	}
+
+}
+
+func Test_org_exists(t *testing.T) {
+
+	getOrganization := func(org string) (*exchange.Organization, error) {
+		switch org {
+		case "org1":
+			return &exchange.Organization{}, nil
+		case "gone":
+			return nil, exchange.OrgNotFoundError{Org: org}
+		default:
+			return nil, errors.New("Invocation of GET at orgs/" + org + " failed invoking HTTP request, status: 401")
+		}
+	}
+
+	if exists, err := orgExists(getOrganization, "org1"); err != nil || !exists {
+		t.Errorf("expected org1 to exist, got %v %v", exists, err)
+	} else if exists, err := orgExists(getOrganization, "gone"); err != nil || exists {
+		t.Errorf("expected a deleted org to be missing, got %v %v", exists, err)
+	} else if _, err := orgExists(getOrganization, "org2"); err == nil {
+		t.Errorf("expected an error for an org that could not be read")
+	}
+
+}
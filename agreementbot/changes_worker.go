@@ -133,6 +133,9 @@ func (w *ChangesWorker) findAndProcessChanges() {
 	// the agbot worker.
 	batchedEvents := make(map[events.EventId]bool)
 
+	// The node and node policy changes are passed along with their batched events so that the node index can be updated.
+	nodeChanges := make([]exchange.ExchangeChange, 0, 10)
+
 	agbotMessages := 0
 
 	// Loop through each change to identify resources that we are interested in, and then send out event messages
@@ -172,9 +175,11 @@ func (w *ChangesWorker) findAndProcessChanges() {
 
 		} else if change.IsNode("") {
 			batchedEvents[events.CHANGE_NODE_TYPE] = true
+			nodeChanges = append(nodeChanges, change)
 
 		} else if change.IsNodePolicy("") {
 			batchedEvents[events.CHANGE_NODE_POLICY_TYPE] = true
+			nodeChanges = append(nodeChanges, change)
 
 		} else if change.IsNodeAgreement("") {
 			batchedEvents[events.CHANGE_NODE_AGREEMENT_TYPE] = true
@@ -188,7 +193,7 @@ func (w *ChangesWorker) findAndProcessChanges() {
 	}
 
 	// Publish any batched events
-	w.emitChangeMessages(batchedEvents, agbotMessages)
+	w.emitChangeMessages(batchedEvents, agbotMessages, nodeChanges)
 
 	// Record the most recent change id.
 	w.postProcessChanges(changes)
@@ -235,12 +240,21 @@ func (w *ChangesWorker) getChangeId() error {
 }
 
 // Send change message for each change type in the map that is set to true.
-func (w *ChangesWorker) emitChangeMessages(resChanges map[events.EventId]bool, agbotMessages int) {
+func (w *ChangesWorker) emitChangeMessages(resChanges map[events.EventId]bool, agbotMessages int, nodeChanges []exchange.ExchangeChange) {
+	nodeChangesSent := false
 	for changeType, _ := range resChanges {
 		if changeType == events.CHANGE_AGBOT_MESSAGE_TYPE {
 			ev := events.NewExchangeChangeMessage(changeType)
 			ev.SetChange(events.MessageCount{Count: agbotMessages})
 			w.Messages() <- ev
+		} else if changeType == events.CHANGE_NODE_TYPE || changeType == events.CHANGE_NODE_POLICY_TYPE {
+			// Both node change events carry all the node changes, so only one of them needs to.
+			ev := events.NewExchangeChangeMessage(changeType)
+			if !nodeChangesSent {
+				ev.SetChange(nodeChanges)
+				nodeChangesSent = true
+			}
+			w.Messages() <- ev
 		} else {
 			w.Messages() <- events.NewExchangeChangeMessage(changeType)
 		}
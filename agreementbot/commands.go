@@ -322,6 +322,21 @@ func NewServicePolicyChangeCommand(msg *events.ExchangeChangeMessage) *ServicePo
 	}
 }
 
+// ==============================================================================================================
+type NodeChangeCommand struct {
+	Msg events.ExchangeChangeMessage
+}
+
+func (e NodeChangeCommand) ShortString() string {
+	return e.Msg.ShortString()
+}
+
+func NewNodeChangeCommand(msg *events.ExchangeChangeMessage) *NodeChangeCommand {
+	return &NodeChangeCommand{
+		Msg: *msg,
+	}
+}
+
 // ==============================================================================================================
 type ObjectPoliciesChangeCommand struct {
 	Msg events.MMSObjectPoliciesMessage
@@ -360,3 +375,18 @@ func (e ServedPolicyCommand) ShortString() string {
 func NewServedPolicyCommand() *ServedPolicyCommand {
 	return &ServedPolicyCommand{}
 }
+
+// ==============================================================================================================
+type PolicyCacheRepairCommand struct {
+	Result chan error // Receives the outcome of the repair when it is not nil
+}
+
+func (e PolicyCacheRepairCommand) ShortString() string {
+	return "PolicyCacheRepairCommand"
+}
+
+func NewPolicyCacheRepairCommand(result chan error) *PolicyCacheRepairCommand {
+	return &PolicyCacheRepairCommand{
+		Result: result,
+	}
+}
@@ -448,6 +448,10 @@ func (b *BaseConsumerProtocolHandler) PersistReply(reply abstractprotocol.Propos
 
 	if _, err := b.db.AgreementMade(reply.AgreementId(), reply.DeviceId(), "", b.Name(), pol.HAGroup.Partners, "", "", ""); err != nil {
 		return errors.New(BCPHlogstring2(workerID, fmt.Sprintf("error updating agreement %v with reply info in DB, error: %v", reply.AgreementId(), err)))
+	} else if len(reply.Capabilities()) != 0 {
+		if _, err := b.db.AgreementCapabilities(reply.AgreementId(), b.Name(), reply.Capabilities()); err != nil {
+			return errors.New(BCPHlogstring2(workerID, fmt.Sprintf("error updating agreement %v with protocol capabilities in DB, error: %v", reply.AgreementId(), err)))
+		}
 	}
 	return nil
 
@@ -622,6 +626,8 @@ const TERM_REASON_CANCEL_FORCED_UPGRADE = "ForceUpgrade"
 const TERM_REASON_CANCEL_BC_WRITE_FAILED = "WriteFailed"
 const TERM_REASON_NODE_HEARTBEAT = "NodeHeartbeat"
 const TERM_REASON_AG_MISSING = "AgreementMissing"
+const TERM_REASON_SERVICE_UNHEALTHY = "ServiceUnhealthy"
+const TERM_REASON_MISSING_CAPABILITIES = "MissingCapabilities"
 
 var BCPHlogstring = func(p string, v interface{}) string {
 	return fmt.Sprintf("Base Consumer Protocol Handler (%v) %v", p, v)
@@ -0,0 +1,357 @@
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/cutil"
+	"time"
+)
+
+// The low priority buffer of the prioritized work queue is shared fairly among the orgs that the agbot is serving, and
+// among the deployment policies and patterns within each org, so that one org with a large policy cannot starve the others.
+// Orgs are served in weighted round robin order, an org with weight 3 receives 3 work items for every 1 item given to an
+// org with weight 1. The policies within an org are served the same way. The number of agreements started per minute
+// can also be limited for each org. The work of an org that has reached its limit waits in the buffer while the other
+// orgs are served. The node search finds the same node again and again while its agreement is waiting, so a new
+// agreement for a node and policy that is already buffered replaces the buffered work instead of growing the buffer.
+// The number of agreements that an org can have waiting in the buffer is limited. When the org is at its limit, new
+// agreements for the org are dropped, and the node search looks for the nodes of their policies again.
+
+const FAIR_QUEUE_DEFAULT_KEY = "*" // The weight or rate limit key that applies to orgs which are not configured explicitly.
+
+// The cumulative work queue statistics for an org.
+type FairQueueOrgStats struct {
+	Weight     int     `json:"weight"`
+	RateLimit  int     `json:"rate_limit,omitempty"` // The maximum number of agreements started per minute, zero when there is no limit.
+	Buffered   int     `json:"buffered"`             // The number of work items waiting in the buffer.
+	Queued     uint64  `json:"queued"`               // The number of work items added to the buffer.
+	Dispatched uint64  `json:"dispatched"`           // The number of work items given to a worker.
+	Throttled  uint64  `json:"throttled"`            // The number of dispatched work items that were held back by the rate limit.
+	Deduped    uint64  `json:"deduped"`              // The number of work items that replaced buffered work for the same node and policy.
+	Dropped    uint64  `json:"dropped"`              // The number of work items dropped because the org had too much work buffered.
+	AvgWaitS   float64 `json:"avg_wait_s"`           // The average time a dispatched work item waited in the buffer.
+	totalWait  time.Duration
+}
+
+// A work item and the time it was buffered.
+type fairQueueEntry struct {
+	work      *AgreementWork
+	queued    time.Time
+	throttled bool // True when the entry was held back by the rate limit of its org.
+}
+
+// A weighted round robin rotation over a set of keys. The key at next is served until it has used up its credit.
+type roundRobin struct {
+	order  []string
+	next   int
+	credit int
+}
+
+// Record that the key at index ix was served, and remove it from the rotation when it has no more work.
+func (r *roundRobin) served(ix int, weight int, empty bool) {
+	if ix != r.next || r.credit <= 0 {
+		r.next = ix
+		r.credit = weight
+	}
+	r.credit -= 1
+
+	if empty {
+		r.order = append(r.order[:ix], r.order[ix+1:]...)
+		r.credit = 0
+	} else if r.credit <= 0 {
+		r.next += 1
+	}
+
+	if r.next >= len(r.order) {
+		r.next = 0
+	}
+}
+
+// The buffered work of an org, one list of work per policy.
+type orgWorkQueue struct {
+	policies map[string][]*fairQueueEntry
+	rr       roundRobin
+}
+
+func (o *orgWorkQueue) head() *fairQueueEntry {
+	return o.policies[o.rr.order[o.rr.next]][0]
+}
+
+// Limits the rate at which agreements are started for an org. The bucket holds up to a minute's worth of tokens.
+type tokenBucket struct {
+	perMinute int
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		perMinute: perMinute,
+		tokens:    float64(perMinute),
+		last:      now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Minutes() * float64(b.perMinute)
+	if b.tokens > float64(b.perMinute) {
+		b.tokens = float64(b.perMinute)
+	}
+	b.last = now
+}
+
+// Returns zero when a token is available, otherwise the time until the next token is available.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / float64(b.perMinute) * float64(time.Minute))
+}
+
+// The fairly shared work buffer. It is not thread safe, the prioritized work queue protects it with its buffer lock.
+type FairWorkQueue struct {
+	orgs       map[string]*orgWorkQueue
+	rr         roundRobin
+	len        int
+	pending    map[string]*fairQueueEntry // The buffered agreement initiations, keyed by org, policy and node.
+	weights    map[string]int
+	rateLimits map[string]int
+	buckets    map[string]*tokenBucket
+	stats      map[string]*FairQueueOrgStats
+	orgLimit   int               // The maximum number of agreement initiations buffered for an org, zero when there is no limit.
+	dropped    map[string]uint64 // The time of the first dropped agreement initiation of each policy, since the last call to TakeDropped.
+}
+
+func NewFairWorkQueue(weights map[string]int, rateLimits map[string]int, orgLimit int) *FairWorkQueue {
+	if weights == nil {
+		weights = make(map[string]int)
+	}
+	if rateLimits == nil {
+		rateLimits = make(map[string]int)
+	}
+	return &FairWorkQueue{
+		orgs:       make(map[string]*orgWorkQueue),
+		pending:    make(map[string]*fairQueueEntry),
+		weights:    weights,
+		rateLimits: rateLimits,
+		buckets:    make(map[string]*tokenBucket),
+		stats:      make(map[string]*FairQueueOrgStats),
+		orgLimit:   orgLimit,
+		dropped:    make(map[string]uint64),
+	}
+}
+
+// Returns the org and policy that a work item is charged to. Only agreement initiations are shared fairly, all other
+// work is charged to the empty org.
+func fairQueueKey(w *AgreementWork) (string, string) {
+	if wi, ok := (*w).(InitiateAgreement); ok {
+		if wi.ConsumerPolicyName != "" {
+			return wi.Org, wi.ConsumerPolicyName
+		}
+		_, polName := cutil.SplitOrgSpecUrl(wi.ConsumerPolicy.Header.Name)
+		return wi.Org, polName
+	}
+	return "", ""
+}
+
+// Returns the key used to find buffered work for the same node and policy, or the empty string when the work is not
+// an agreement initiation.
+func fairQueuePendingKey(w *AgreementWork, org string, pol string) string {
+	if wi, ok := (*w).(InitiateAgreement); ok {
+		return org + "/" + pol + "/" + wi.Device.Id
+	}
+	return ""
+}
+
+func (f *FairWorkQueue) orgWeight(org string) int {
+	if w, ok := f.weights[org]; ok && org != "" {
+		return w
+	} else if w, ok := f.weights[FAIR_QUEUE_DEFAULT_KEY]; ok {
+		return w
+	}
+	return 1
+}
+
+func (f *FairWorkQueue) policyWeight(org string, pol string) int {
+	if w, ok := f.weights[org+"/"+pol]; ok && org != "" && pol != "" {
+		return w
+	}
+	return 1
+}
+
+func (f *FairWorkQueue) orgRateLimit(org string) int {
+	if org == "" {
+		return 0
+	} else if l, ok := f.rateLimits[org]; ok {
+		return l
+	}
+	return f.rateLimits[FAIR_QUEUE_DEFAULT_KEY]
+}
+
+func (f *FairWorkQueue) orgStats(org string) *FairQueueOrgStats {
+	s, ok := f.stats[org]
+	if !ok {
+		s = &FairQueueOrgStats{Weight: f.orgWeight(org), RateLimit: f.orgRateLimit(org)}
+		f.stats[org] = s
+	}
+	return s
+}
+
+// Returns the time until the org can start another agreement, zero when it is not rate limited.
+func (f *FairWorkQueue) rateLimitWait(org string, now time.Time) time.Duration {
+	limit := f.orgRateLimit(org)
+	if limit == 0 {
+		return 0
+	}
+	b, ok := f.buckets[org]
+	if !ok {
+		b = newTokenBucket(limit, now)
+		f.buckets[org] = b
+	}
+	return b.wait(now)
+}
+
+func (f *FairWorkQueue) Len() int {
+	return f.len
+}
+
+// Returns the number of buffered work items that can be dispatched now, the work of orgs that are rate limited is
+// not included.
+func (f *FairWorkQueue) ReadyLen(now time.Time) int {
+	ready := f.len
+	for org := range f.orgs {
+		if f.rateLimitWait(org, now) != 0 {
+			ready -= f.orgStats(org).Buffered
+		}
+	}
+	return ready
+}
+
+// Add the work to the buffer and return the org it is charged to, and false when the work was dropped because the
+// org is at its limit.
+func (f *FairWorkQueue) Add(w *AgreementWork, now time.Time) (string, bool) {
+	org, pol := fairQueueKey(w)
+
+	// The newer work has the node's latest policy, it keeps the place in the buffer of the work it replaces.
+	key := fairQueuePendingKey(w, org, pol)
+	if entry, ok := f.pending[key]; ok && key != "" {
+		entry.work = w
+		f.orgStats(org).Deduped += 1
+		return org, true
+	}
+
+	if org != "" && f.orgLimit != 0 && f.orgStats(org).Buffered >= f.orgLimit {
+		f.orgStats(org).Dropped += 1
+		if polName := (*w).(InitiateAgreement).ConsumerPolicy.Header.Name; f.dropped[polName] == 0 {
+			f.dropped[polName] = uint64(now.Unix())
+		}
+		return org, false
+	}
+
+	oq, ok := f.orgs[org]
+	if !ok {
+		oq = &orgWorkQueue{policies: make(map[string][]*fairQueueEntry)}
+		f.orgs[org] = oq
+		f.rr.order = append(f.rr.order, org)
+	}
+	if _, ok := oq.policies[pol]; !ok {
+		oq.rr.order = append(oq.rr.order, pol)
+	}
+	entry := &fairQueueEntry{work: w, queued: now}
+	oq.policies[pol] = append(oq.policies[pol], entry)
+	if key != "" {
+		f.pending[key] = entry
+	}
+
+	f.len += 1
+	s := f.orgStats(org)
+	s.Queued += 1
+	s.Buffered += 1
+	return org, true
+}
+
+// Returns the next work item to dispatch and its org, without removing it. When there is buffered work but every org
+// with buffered work is rate limited, the returned work is nil and the duration is the time until the first org can
+// start another agreement.
+func (f *FairWorkQueue) Head(now time.Time) (*AgreementWork, string, time.Duration) {
+	var minWait time.Duration
+	for i := 0; i < len(f.rr.order); i++ {
+		org := f.rr.order[(f.rr.next+i)%len(f.rr.order)]
+		oq := f.orgs[org]
+		if wait := f.rateLimitWait(org, now); wait == 0 {
+			return oq.head().work, org, 0
+		} else {
+			oq.head().throttled = true
+			if minWait == 0 || wait < minWait {
+				minWait = wait
+			}
+		}
+	}
+	return nil, "", minWait
+}
+
+// Remove the work item returned by the most recent call to Head for the given org.
+func (f *FairWorkQueue) Remove(org string, now time.Time) {
+	ix := -1
+	for i, o := range f.rr.order {
+		if o == org {
+			ix = i
+			break
+		}
+	}
+	if ix == -1 {
+		return
+	}
+
+	oq := f.orgs[org]
+	pol := oq.rr.order[oq.rr.next]
+	entry := oq.policies[pol][0]
+	oq.policies[pol] = oq.policies[pol][1:]
+	if key := fairQueuePendingKey(entry.work, org, pol); key != "" {
+		delete(f.pending, key)
+	}
+
+	polEmpty := len(oq.policies[pol]) == 0
+	if polEmpty {
+		delete(oq.policies, pol)
+	}
+	oq.rr.served(oq.rr.next, f.policyWeight(org, pol), polEmpty)
+
+	orgEmpty := len(oq.rr.order) == 0
+	if orgEmpty {
+		delete(f.orgs, org)
+	}
+	f.rr.served(ix, f.orgWeight(org), orgEmpty)
+
+	if b, ok := f.buckets[org]; ok {
+		b.tokens -= 1
+	}
+
+	f.len -= 1
+	s := f.orgStats(org)
+	s.Buffered -= 1
+	s.Dispatched += 1
+	s.totalWait += now.Sub(entry.queued)
+	if entry.throttled {
+		s.Throttled += 1
+	}
+}
+
+// Returns the policies that had agreement initiations dropped and the time of the first one, and forgets them.
+func (f *FairWorkQueue) TakeDropped() map[string]uint64 {
+	dropped := f.dropped
+	f.dropped = make(map[string]uint64)
+	return dropped
+}
+
+// Returns a copy of the statistics of each org.
+func (f *FairWorkQueue) Stats() map[string]FairQueueOrgStats {
+	res := make(map[string]FairQueueOrgStats, len(f.stats))
+	for org, s := range f.stats {
+		c := *s
+		if c.Dispatched != 0 {
+			c.AvgWaitS = c.totalWait.Seconds() / float64(c.Dispatched)
+		}
+		res[org] = c
+	}
+	return res
+}
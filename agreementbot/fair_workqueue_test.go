@@ -0,0 +1,155 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+	"time"
+)
+
+func fairWork(org string, polName string, id string) *AgreementWork {
+	pol := policy.Policy{Header: policy.PolicyHeader{Name: org + "/" + polName}}
+	wi := NewInitiateAgreement(policy.Policy{}, pol, org, exchange.SearchResultDevice{Id: id}, polName, nil)
+	return &wi
+}
+
+// Dispatch all the work that is not rate limited and return the device ids in dispatch order.
+func drainFairQueue(f *FairWorkQueue, now time.Time) []string {
+	res := make([]string, 0)
+	for {
+		w, org, _ := f.Head(now)
+		if w == nil {
+			return res
+		}
+		res = append(res, (*w).(InitiateAgreement).Device.Id)
+		f.Remove(org, now)
+	}
+}
+
+func Test_FairWorkQueue_weights(t *testing.T) {
+
+	f := NewFairWorkQueue(map[string]int{"org1": 2, "org1/bp2": 2}, nil, 0)
+	now := time.Now()
+
+	// A large org fills the buffer before the other orgs have any work.
+	for _, id := range []string{"a1", "a2", "a3", "a4", "a5", "a6"} {
+		f.Add(fairWork("org1", "bp1", id), now)
+	}
+	for _, id := range []string{"b1", "b2"} {
+		f.Add(fairWork("org1", "bp2", id), now)
+	}
+	for _, id := range []string{"c1", "c2", "c3"} {
+		f.Add(fairWork("org2", "bp1", id), now)
+	}
+
+	// org1 gets 2 items for each item of org2, and bp2 gets 2 items for each item of bp1 within org1.
+	expected := []string{"a1", "b1", "c1", "b2", "a2", "c2", "a3", "a4", "c3", "a5", "a6"}
+	if order := drainFairQueue(f, now); len(order) != len(expected) {
+		t.Errorf("wrong dispatch order %v, expected %v", order, expected)
+	} else {
+		for ix := range expected {
+			if order[ix] != expected[ix] {
+				t.Errorf("wrong dispatch order %v, expected %v", order, expected)
+				break
+			}
+		}
+	}
+
+	if f.Len() != 0 {
+		t.Errorf("expected the queue to be empty, has %v", f.Len())
+	} else if stats := f.Stats(); stats["org1"].Dispatched != 8 || stats["org2"].Dispatched != 3 || stats["org1"].Weight != 2 || stats["org2"].Buffered != 0 {
+		t.Errorf("wrong stats %v", stats)
+	}
+
+}
+
+func Test_FairWorkQueue_rate_limit(t *testing.T) {
+
+	f := NewFairWorkQueue(nil, map[string]int{"org1": 2, FAIR_QUEUE_DEFAULT_KEY: 0}, 0)
+	now := time.Now()
+
+	for _, id := range []string{"a1", "a2", "a3"} {
+		f.Add(fairWork("org1", "bp1", id), now)
+	}
+	for _, id := range []string{"c1", "c2", "c3"} {
+		f.Add(fairWork("org2", "bp1", id), now)
+	}
+
+	// org1 can start 2 agreements in a minute, org2 is not limited.
+	expected := []string{"a1", "c1", "a2", "c2", "c3"}
+	if order := drainFairQueue(f, now); len(order) != len(expected) || order[2] != "a2" || order[4] != "c3" {
+		t.Errorf("wrong dispatch order %v, expected %v", order, expected)
+	}
+
+	// The rest of the org1 work waits for the rate limit.
+	if w, _, wait := f.Head(now); w != nil || wait <= 0 || wait > 30*time.Second {
+		t.Errorf("expected org1 to be rate limited, got %v %v", w, wait)
+	} else if order := drainFairQueue(f, now.Add(31*time.Second)); len(order) != 1 || order[0] != "a3" {
+		t.Errorf("expected the rate limited work to be dispatched, got %v", order)
+	} else if stats := f.Stats(); stats["org1"].Throttled != 1 || stats["org1"].RateLimit != 2 || stats["org2"].Throttled != 0 {
+		t.Errorf("wrong stats %v", stats)
+	}
+
+}
+
+func Test_FairWorkQueue_dedupe(t *testing.T) {
+
+	f := NewFairWorkQueue(nil, nil, 0)
+	now := time.Now()
+
+	// The node search finds n1 again before its first agreement work is dispatched.
+	f.Add(fairWork("org1", "bp1", "n1"), now)
+	f.Add(fairWork("org1", "bp1", "n2"), now)
+	f.Add(fairWork("org1", "bp1", "n1"), now)
+	f.Add(fairWork("org1", "bp2", "n1"), now)
+
+	expected := []string{"n1", "n1", "n2"}
+	if f.Len() != 3 {
+		t.Errorf("expected 3 buffered work items, has %v", f.Len())
+	} else if order := drainFairQueue(f, now); len(order) != len(expected) || order[0] != "n1" || order[1] != "n1" || order[2] != "n2" {
+		t.Errorf("wrong dispatch order %v, expected %v", order, expected)
+	} else if stats := f.Stats(); stats["org1"].Queued != 3 || stats["org1"].Deduped != 1 || stats["org1"].Dispatched != 3 || stats["org1"].Buffered != 0 {
+		t.Errorf("wrong stats %v", stats)
+	}
+
+	// Once dispatched, the node's work is buffered again.
+	f.Add(fairWork("org1", "bp1", "n1"), now)
+	if f.Len() != 1 {
+		t.Errorf("expected 1 buffered work item, has %v", f.Len())
+	}
+
+}
+
+func Test_FairWorkQueue_org_limit(t *testing.T) {
+
+	f := NewFairWorkQueue(nil, nil, 2)
+	now := time.Now()
+
+	// org1 is at its limit, its new work is dropped but the work already buffered can still be updated.
+	added := make([]bool, 0)
+	for _, id := range []string{"a1", "a2", "a3", "a1"} {
+		_, ok := f.Add(fairWork("org1", "bp1", id), now)
+		added = append(added, ok)
+	}
+	_, org2Added := f.Add(fairWork("org2", "bp1", "c1"), now)
+
+	if !added[0] || !added[1] || added[2] || !added[3] || !org2Added {
+		t.Errorf("wrong work added %v %v", added, org2Added)
+	} else if stats := f.Stats(); stats["org1"].Dropped != 1 || stats["org1"].Deduped != 1 || stats["org1"].Buffered != 2 || stats["org2"].Dropped != 0 {
+		t.Errorf("wrong stats %v", stats)
+	} else if dropped := f.TakeDropped(); len(dropped) != 1 || dropped["org1/bp1"] != uint64(now.Unix()) {
+		t.Errorf("expected the dropped policy to be reported, got %v", dropped)
+	} else if dropped := f.TakeDropped(); len(dropped) != 0 {
+		t.Errorf("expected the dropped policies to be forgotten, got %v", dropped)
+	}
+
+	// Dispatching the org1 work makes room for more.
+	if order := drainFairQueue(f, now); len(order) != 3 {
+		t.Errorf("wrong dispatch order %v", order)
+	} else if _, ok := f.Add(fairWork("org1", "bp1", "a3"), now); !ok {
+		t.Errorf("expected the org1 work to be added")
+	}
+
+}
@@ -0,0 +1,205 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/apicommon"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/events"
+	"sort"
+	"sync"
+)
+
+// An agbot can serve more than one exchange. The exchange in the agbot's config is the primary exchange, the other
+// exchanges are federated exchanges. Each federated exchange is served by its own agbot worker and exchange changes
+// worker, running in their own message handler registry so that the policy and exchange change events of one exchange
+// are never seen by the workers of another exchange. The workers of all the exchanges share the agbot's database, each
+// through an exchange database that scopes the agreements and workload usages to the nodes of its exchange.
+//
+// The local APIs, the database heartbeat, the partition management, the policy cache check and the embedded data
+// verification service are provided by the workers of the primary exchange only.
+
+// The agbot workers that are serving an exchange, keyed by the name of the exchange. The primary exchange has the
+// empty name. A worker is added once it has initialized.
+var agbotInstancesLock sync.Mutex
+var agbotInstances = make(map[string]*AgreementBotWorker)
+
+func registerAgbotInstance(w *AgreementBotWorker) {
+	agbotInstancesLock.Lock()
+	defer agbotInstancesLock.Unlock()
+	agbotInstances[w.exchangeName] = w
+}
+
+// Returns the agbot workers of all the exchanges, the primary exchange first and then the federated exchanges by name.
+func getAgbotInstances() []*AgreementBotWorker {
+	agbotInstancesLock.Lock()
+	defer agbotInstancesLock.Unlock()
+
+	names := make([]string, 0, len(agbotInstances))
+	for name := range agbotInstances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*AgreementBotWorker, 0, len(names))
+	for _, name := range names {
+		res = append(res, agbotInstances[name])
+	}
+	return res
+}
+
+// Returns true when the workers of all the federated exchanges have finished shutting down.
+func federatedInstancesQuiesced() bool {
+	for _, w := range getAgbotInstances() {
+		if w.exchangeName != "" && !w.IsQuiesced() {
+			return false
+		}
+	}
+	return true
+}
+
+// The status of a federated exchange, reported by the /status API.
+type FederatedExchangeStatus struct {
+	*apicommon.Info
+	Ready      bool                       `json:"ready"` // True when the agbot worker of the exchange has initialized.
+	WorkQueues map[string]WorkQueueStatus `json:"workQueues,omitempty"`
+}
+
+// Returns the status of each federated exchange, keyed by exchange name.
+func getFederatedExchangeStatus(cfg *config.HorizonConfig) map[string]FederatedExchangeStatus {
+	res := make(map[string]FederatedExchangeStatus)
+	for _, fe := range cfg.GetAgbotFederatedExchanges() {
+		status := FederatedExchangeStatus{
+			Info: apicommon.NewInfo(cfg.Collaborators.HTTPClientFactory, fe.ExchangeURL, fe.CSSURL, fe.ExchangeId, fe.ExchangeToken),
+		}
+		agbotInstancesLock.Lock()
+		w, ok := agbotInstances[fe.Name]
+		agbotInstancesLock.Unlock()
+		if ok {
+			status.Ready = w.ready
+			status.WorkQueues = getWorkQueueStatus(w.consumerPH)
+		}
+		res[fe.Name] = status
+	}
+	return res
+}
+
+// The federation relay passes the messages that concern all the exchanges from the registry of the primary exchange
+// to the registry of each federated exchange. These are the agbot shutdown and the agreement cancelations and workload
+// upgrades requested through the API, which the workers of each exchange apply to their own agreements.
+type FederationRelay struct {
+	name     string
+	messages chan events.Message
+	inbound  []*FederationInbound
+}
+
+func NewFederationRelay(name string) *FederationRelay {
+	return &FederationRelay{
+		name:     name,
+		messages: make(chan events.Message),
+		inbound:  make([]*FederationInbound, 0, 2),
+	}
+}
+
+func (r *FederationRelay) GetName() string {
+	return r.name
+}
+
+// The relay does not originate messages in the primary registry.
+func (r *FederationRelay) Messages() chan events.Message {
+	return r.messages
+}
+
+func (r *FederationRelay) NewEvent(incoming events.Message) {
+	switch incoming.(type) {
+	case *events.NodeShutdownMessage, *events.ABApiAgreementCancelationMessage, *events.ABApiWorkloadUpgradeMessage:
+		for _, in := range r.inbound {
+			in.relay(incoming)
+		}
+	}
+}
+
+// Returns the message handler that delivers the relayed messages into the registry of a federated exchange.
+func (r *FederationRelay) NewInbound(name string) *FederationInbound {
+	in := &FederationInbound{
+		name:     name,
+		messages: make(chan events.Message, 20),
+	}
+	r.inbound = append(r.inbound, in)
+	return in
+}
+
+// The message handler in the registry of a federated exchange that receives the relayed messages. It removes itself
+// from the registry once the agbot worker of the exchange has shut down, so that the registry can terminate.
+type FederationInbound struct {
+	name     string
+	lock     sync.Mutex
+	stopped  bool
+	messages chan events.Message
+}
+
+func (in *FederationInbound) GetName() string {
+	return in.name
+}
+
+func (in *FederationInbound) Messages() chan events.Message {
+	return in.messages
+}
+
+func (in *FederationInbound) relay(msg events.Message) {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+	if !in.stopped {
+		in.messages <- msg
+	}
+}
+
+func (in *FederationInbound) NewEvent(incoming events.Message) {
+	switch incoming.(type) {
+	case *events.NodeShutdownCompleteMessage:
+		msg, _ := incoming.(*events.NodeShutdownCompleteMessage)
+		if msg.Event().Id == events.AGBOT_QUIESCE_COMPLETE {
+			in.lock.Lock()
+			defer in.lock.Unlock()
+			if !in.stopped {
+				glog.V(3).Infof("Federation relay %v stopping", in.name)
+				in.stopped = true
+				go func() { in.messages <- events.NewWorkerStopMessage(events.WORKER_STOP, in.name) }()
+			}
+		}
+	}
+}
+
+// Sync up the agreements in a partition that was picked up from another agbot with the workers of every exchange, each
+// worker checks the agreements of its own exchange.
+func (w *AgreementBotWorker) syncAllOnInit() error {
+	if err := w.syncOnInit(); err != nil {
+		return err
+	}
+	for _, fw := range getAgbotInstances() {
+		if fw != w {
+			if err := fw.syncOnInit(); err != nil {
+				return fmt.Errorf("exchange %v: %v", fw.exchangeName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true when the worker has finished shutting down.
+func (w *AgreementBotWorker) IsQuiesced() bool {
+	agbotInstancesLock.Lock()
+	defer agbotInstancesLock.Unlock()
+	return w.quiesced
+}
+
+func (w *AgreementBotWorker) setQuiesced() {
+	agbotInstancesLock.Lock()
+	defer agbotInstancesLock.Unlock()
+	w.quiesced = true
+}
+
+// Returns true when the worker serves the agbot's primary exchange.
+func (w *AgreementBotWorker) isPrimaryExchange() bool {
+	return w.exchangeName == ""
+}
@@ -0,0 +1,101 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/policy"
+	"os"
+	"testing"
+)
+
+func Test_exchange_db_scoping(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	primary := persistence.NewExchangeDB(db, "")
+	east := persistence.NewExchangeDB(db, "east")
+
+	if err := primary.AgreementAttempt("ag1", "org1", "org1/d1", "device", "org1/bp1", "", "", "", policy.BasicProtocol, "", []string{"org1/svc1"}, policy.NodeHealth{}, 0, 0, ""); err != nil {
+		t.Fatalf("unable to create agreement, error %v", err)
+	} else if err := east.AgreementAttempt("ag2", "org1", "org1/d2", "device", "org1/bp1", "", "", "", policy.BasicProtocol, "", []string{"org1/svc1"}, policy.NodeHealth{}, 0, 0, "east"); err != nil {
+		t.Fatalf("unable to create agreement, error %v", err)
+	}
+
+	// Each exchange only sees its own agreements, the shared database sees all of them.
+	if ags, err := primary.FindAgreements(nil, policy.BasicProtocol); err != nil || len(ags) != 1 || ags[0].CurrentAgreementId != "ag1" {
+		t.Errorf("wrong primary agreements %v, error %v", ags, err)
+	} else if ags, err := east.FindAgreements(nil, policy.BasicProtocol); err != nil || len(ags) != 1 || ags[0].Exchange != "east" {
+		t.Errorf("wrong east agreements %v, error %v", ags, err)
+	} else if ags, err := db.FindAgreements(nil, policy.BasicProtocol); err != nil || len(ags) != 2 {
+		t.Errorf("wrong agreements %v, error %v", ags, err)
+	} else if ag, err := primary.FindSingleAgreementByAgreementId("ag2", policy.BasicProtocol, nil); err != nil || ag != nil {
+		t.Errorf("expected no agreement from another exchange, got %v, error %v", ag, err)
+	} else if ag, err := east.FindSingleAgreementByAgreementIdAllProtocols("ag2", policy.AllAgreementProtocols(), nil); err != nil || ag == nil {
+		t.Errorf("expected agreement ag2, error %v", err)
+	}
+
+	if err := east.NewWorkloadUsage("org1/d2", &policy.HighAvailabilityGroup{}, "", "org1/bp1", 1, 600, 0, false, "ag2", "east"); err != nil {
+		t.Fatalf("unable to create workload usage, error %v", err)
+	} else if wu, err := primary.FindSingleWorkloadUsageByDeviceAndPolicyName("org1/d2", "org1/bp1"); err != nil || wu != nil {
+		t.Errorf("expected no workload usage from another exchange, got %v, error %v", wu, err)
+	} else if wus, err := primary.FindWorkloadUsages(nil); err != nil || len(wus) != 0 {
+		t.Errorf("expected no workload usages, got %v, error %v", wus, err)
+	} else if wus, err := east.FindWorkloadUsages(nil); err != nil || len(wus) != 1 || wus[0].Exchange != "east" {
+		t.Errorf("wrong east workload usages %v, error %v", wus, err)
+	}
+
+	// The same device id in the primary exchange cannot change or delete the workload usage of the east exchange.
+	if _, err := primary.UpdatePendingUpgrade("org1/d2", "org1/bp1"); err == nil {
+		t.Errorf("expected an error updating the workload usage of another exchange")
+	} else if _, err := primary.UpdateRetryCount("org1/d2", "org1/bp1", 5, "ag1"); err == nil {
+		t.Errorf("expected an error updating the workload usage of another exchange")
+	} else if _, err := primary.UpdateWUAgreementId("org1/d2", "org1/bp1", "ag1", policy.BasicProtocol); err == nil {
+		t.Errorf("expected an error updating the workload usage of another exchange")
+	} else if err := primary.DeleteWorkloadUsage("org1/d2", "org1/bp1"); err == nil {
+		t.Errorf("expected an error deleting the workload usage of another exchange")
+	} else if err := primary.NewWorkloadUsage("org1/d2", &policy.HighAvailabilityGroup{}, "", "org1/bp1", 1, 600, 0, false, "ag1", ""); err == nil {
+		t.Errorf("expected an error creating a workload usage that another exchange has")
+	} else if wu, err := east.FindSingleWorkloadUsageByDeviceAndPolicyName("org1/d2", "org1/bp1"); err != nil || wu == nil || wu.RetryCount != 0 || wu.PendingUpgradeTime != 0 || wu.CurrentAgreementId != "ag2" {
+		t.Errorf("expected the east workload usage to be unchanged, got %v, error %v", wu, err)
+	}
+
+	// The east exchange changes its own workload usage, and its new workload usages are always in its exchange.
+	if wu, err := east.UpdateRetryCount("org1/d2", "org1/bp1", 5, "ag2"); err != nil || wu.RetryCount != 5 {
+		t.Errorf("expected the retry count to be updated, got %v, error %v", wu, err)
+	} else if err := east.DeleteWorkloadUsage("org1/d2", "org1/bp1"); err != nil {
+		t.Errorf("unable to delete workload usage, error %v", err)
+	} else if err := east.NewWorkloadUsage("org1/d3", &policy.HighAvailabilityGroup{}, "", "org1/bp1", 1, 600, 0, false, "ag3", ""); err != nil {
+		t.Errorf("unable to create workload usage, error %v", err)
+	} else if wu, err := db.FindSingleWorkloadUsageByDeviceAndPolicyName("org1/d3", "org1/bp1"); err != nil || wu == nil || wu.Exchange != "east" {
+		t.Errorf("expected the workload usage to be in the east exchange, got %v, error %v", wu, err)
+	}
+
+}
+
+func Test_federation_relay(t *testing.T) {
+
+	relay := NewFederationRelay("relay")
+	in := relay.NewInbound("relay east")
+
+	// Only the messages that concern every exchange are relayed.
+	relay.NewEvent(events.NewABApiAgreementCancelationMessage(events.AGREEMENT_ENDED, policy.BasicProtocol, "ag1"))
+	relay.NewEvent(events.NewNodeShutdownCompleteMessage(events.AGBOT_QUIESCE_COMPLETE, ""))
+	if len(in.Messages()) != 1 {
+		t.Errorf("expected 1 relayed message, got %v", len(in.Messages()))
+	}
+	<-in.Messages()
+
+	// The inbound handler stops relaying once the agbot worker of its exchange has shut down, and removes itself.
+	in.NewEvent(events.NewNodeShutdownCompleteMessage(events.AGBOT_QUIESCE_COMPLETE, ""))
+	if msg := <-in.Messages(); msg.Event().Id != events.WORKER_STOP {
+		t.Errorf("expected a worker stop message, got %v", msg)
+	}
+	relay.NewEvent(events.NewABApiAgreementCancelationMessage(events.AGREEMENT_ENDED, policy.BasicProtocol, "ag1"))
+	if len(in.Messages()) != 0 {
+		t.Errorf("expected no relayed messages after shutdown, got %v", len(in.Messages()))
+	}
+
+}
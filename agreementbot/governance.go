@@ -41,6 +41,10 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 	// info from the exchange. The exchange might return no updates, but at least the agbot asked for updates.
 	w.NHManager.ResetUpdateStatus()
 
+	// Remember the agreements that are in progress so that the Node Health manager can forget the service health of the others.
+	inProgress := make(map[string]bool)
+	inProgressComplete := true
+
 	// Look at all agreements across all protocols
 	for _, agp := range policy.AllAgreementProtocols() {
 
@@ -55,6 +59,10 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 			glog.V(5).Infof("AgreementBot Governance saving the node orgs to the node health manager for all active agreements under %v protocol.", agp)
 			w.NHManager.SetNodeOrgs(agreements, agp)
 
+			for _, ag := range agreements {
+				inProgress[ag.CurrentAgreementId] = true
+			}
+
 			for _, ag := range agreements {
 
 				// Govern agreements that have seen a reply from the device
@@ -198,12 +206,18 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 			}
 		} else {
 			glog.Errorf(logString(fmt.Sprintf("unable to read agreements from database, error: %v", err)))
+			inProgressComplete = false
 		}
 	}
 
+	if inProgressComplete {
+		w.NHManager.PruneUnhealthy(inProgress)
+	}
+
 	// Proactively check the state of pending workload upgrades for HA devices. When the need for an upgrade is detected, one of the
 	// devices in the HA group is chosen for upgrade and the others are marked for a pending upgrade (in their workload usage record).
-	// The goal of this routine is to detect when 1 member of the group is upgraded and it's safe to start to upgrade another member.
+	// The goal of this routine is to detect when a member of the group is upgraded and has been healthy long enough that it's safe to
+	// start to upgrade more members, up to the maximum number of unavailable members allowed by the HA group, in the group's order.
 	//
 	// Workload usage records survive agreement cancellations. They track the current workload being run on the device. We can be certain of
 	// this because proposals from agbots to devices only contain a single workload choice.
@@ -223,47 +237,19 @@ func (w *AgreementBotWorker) GovernAgreements() int {
 		glog.Errorf(logString(fmt.Sprintf("error searching for HA devices that need their workloads upgraded, error: %v", err)))
 	} else if len(upgrades) != 0 {
 
+		// The progress of each HA group is determined once in this pass, for all its pending members.
+		groups := newHAUpgradeProgressCache(w.db, w.isHAMemberAlive, w.isHAMemberHealthy)
 		for _, wlu := range upgrades {
 
-			// Setup variables to track the state of the HA group that the current workload usage record belongs to.
-			partnerUpgrading := ""
-			upgradedPartnerFound := ""
-
-			// Run through all the partners (wlUsage.HAPartners) of the current workload usage record.
-			for _, partnerId := range wlu.HAPartners {
-				glog.V(5).Infof(logString(fmt.Sprintf("analyzing HA group containing %v with partners %v", wlu.DeviceId, wlu.HAPartners)))
-
-				if partnerWLU, err := w.db.FindSingleWorkloadUsageByDeviceAndPolicyName(partnerId, wlu.PolicyName); err != nil {
-					glog.Errorf(logString(fmt.Sprintf("error obtaining partner workload usage record for device %v and policy %v, error: %v", partnerId, wlu.PolicyName, err)))
-				} else if partnerWLU == nil {
-					// If the partner doesnt have a workload usage record, then it is because that partner is upgrading.
-					// Workload usage records are deleted when we want to upgrade a device. We also cancel the previous agreement.
-					partnerUpgrading = partnerId
-					glog.V(3).Infof(logString(fmt.Sprintf("HA group containing %v and %v has a member %v currently upgrading.", wlu.DeviceId, wlu.HAPartners, partnerId)))
-					break
-
-				} else if partnerWLU.PendingUpgradeTime != 0 {
-					// Skip partners that are pending upgrade, they dont help us figure out if we can upgrade the current member.
-					continue
-
-				} else {
-					// At this point we know that the partner WLU record is not pending an upgrade. Since it has a workload usage record,
-					// then we know it has been attempting to make an agreement in the past. Check the state of the agreement that it
-					// points to.
-
-					partnerUpgrading, upgradedPartnerFound = w.checkWorkloadUsageAgreement(partnerWLU, &wlu)
-
-					// If this partner is upgrading, then there is no reason to do further checks on the HA group.
-					if partnerUpgrading != "" {
-						break
-					}
-				}
-
+			// Determine the upgrade progress of the HA group that the current workload usage record belongs to.
+			progress, err := groups.get(&wlu)
+			if err != nil {
+				glog.Errorf(logString(fmt.Sprintf("error analyzing HA group containing %v with partners %v, error: %v", wlu.DeviceId, wlu.HAPartners, err)))
+				continue
 			}
 
-			// If there is already one partner successfully upgraded and there are no partners in the middle of an upgrade, then
-			// begin upgrading the partner who needs it.
-			if upgradedPartnerFound != "" && partnerUpgrading == "" {
+			// If enough partners have successfully upgraded and it is this member's turn, then begin upgrading it.
+			if progress.CanUpgrade(wlu.DeviceId) {
 				glog.V(3).Infof(logString(fmt.Sprintf("beginning upgrade of HA member %v in group %v.", wlu.DeviceId, wlu.HAPartners)))
 				if ag, err := w.db.FindSingleAgreementByAgreementIdAllProtocols(wlu.CurrentAgreementId, policy.AllAgreementProtocols(), unarchived); err != nil {
 					glog.Errorf(logString(fmt.Sprintf("unable to read agreement %v from database, error: %v", wlu.CurrentAgreementId, err)))
@@ -341,56 +327,48 @@ func calculateSkipTime(dvCheckrate uint64, nhCheckrate uint64, pgi uint64) (uint
 	return dvSkip, nhSkip, waitTime
 }
 
-// This function is used to determine if a device is actively trying to make an agreement. This is important to know because
-// a device in an HA group that is in the midst of making an agreement will prevent the agbot from upgrading other HA
-// partners. This function also considers the possibility that an HA partner has stopped heart beating (because it died), and
-// therefore wont be making any agreements right now. In that case, we skip that device and look for others to start upgrading.
-func (w *AgreementBotWorker) checkWorkloadUsageAgreement(partnerWLU *persistence.WorkloadUsage, currentWLU *persistence.WorkloadUsage) (string, string) {
-
-	partnerUpgrading := ""
-	upgradedPartnerFound := ""
-
-	if ag, err := w.db.FindSingleAgreementByAgreementIdAllProtocols(partnerWLU.CurrentAgreementId, policy.AllAgreementProtocols(), []persistence.AFilter{persistence.UnarchivedAFilter()}); err != nil {
-		glog.Errorf(logString(fmt.Sprintf("unable to read agreement %v from database, error: %v", partnerWLU.CurrentAgreementId, err)))
-	} else if ag == nil {
-		// If we dont find an agreement for a partner, then it is because a previous agreement with that partner has failed and we
-		// managed to catch the workload usage record in a transition state between agreement attempts.
-		// Check to make sure the partner is heart-beating to the exchange. This should tell us if we can expect this device to
-		// complete an agreement at some time, or not.
-
-		if dev, err := GetDevice(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), partnerWLU.DeviceId, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
-			glog.Errorf(logString(fmt.Sprintf("error obtaining device %v heartbeat state: %v", partnerWLU.DeviceId, err)))
-		} else if len(dev.LastHeartbeat) != 0 && (uint64(cutil.TimeInSeconds(dev.LastHeartbeat, cutil.ExchangeTimeFormat)+300) > uint64(time.Now().Unix())) {
-			// If the device is still alive (heart beat received in the last 5 mins), then assume this partner is trying to make an
-			// agreement. Exit the partner loop because no one else can safely upgrade right now. The upgrade might be bad.
-			glog.V(5).Infof(logString(fmt.Sprintf("HA group member %v is upgrading, has partners %v %v.", partnerWLU.DeviceId, currentWLU.HAPartners, currentWLU.DeviceId)))
-			partnerUpgrading = partnerWLU.DeviceId
-		} else {
-			// If the device is not alive then ignore it. We dont want this failed device to hold up the workload
-			// upgrade of other devices.
-			glog.V(5).Infof(logString(fmt.Sprintf("HA group member %v is not heartbeating, has partners %v %v.", partnerWLU.DeviceId, currentWLU.HAPartners, currentWLU.DeviceId)))
-		}
-	} else if ag.DataVerifiedTime != ag.AgreementCreationTime && ag.AgreementTimedout == 0 {
-		// If we find a partner with an agreement where data has been verified and that is also not being cancelled,
-		// then we have found a partner who is upgraded. Now we just need to make sure this partner is running the highest
-		// priority workload. If not, then it is not considered to be upgraded.
-
-		if pol, err := policy.DemarshalPolicy(partnerWLU.Policy); err != nil {
-			glog.Errorf(logString(fmt.Sprintf("unable to demarshal policy for workload usage %v, error %v", partnerWLU, err)))
-		} else {
-			workload := pol.NextHighestPriorityWorkload(0, 0, 0)
-			if partnerWLU.Priority == workload.Priority.PriorityValue {
-				glog.V(5).Infof(logString(fmt.Sprintf("HA group member %v has upgraded, has partners %v %v.", partnerWLU.DeviceId, currentWLU.HAPartners, currentWLU.DeviceId)))
-				upgradedPartnerFound = partnerWLU.DeviceId
-			}
-		}
+// This function is used to determine if a device without an agreement is still trying to make one. This is important to know
+// because a device in an HA group that is in the midst of making an agreement will prevent the agbot from upgrading other HA
+// partners. An HA partner that has stopped heart beating (because it died) wont be making any agreements right now. In that
+// case, we skip that device and look for others to start upgrading.
+func (w *AgreementBotWorker) isHAMemberAlive(deviceId string) bool {
+
+	if dev, err := GetDevice(w.Config.Collaborators.HTTPClientFactory.NewHTTPClient(nil), deviceId, w.GetExchangeURL(), w.GetExchangeId(), w.GetExchangeToken()); err != nil {
+		glog.Errorf(logString(fmt.Sprintf("error obtaining device %v heartbeat state: %v", deviceId, err)))
+	} else if len(dev.LastHeartbeat) != 0 && (uint64(cutil.TimeInSeconds(dev.LastHeartbeat, cutil.ExchangeTimeFormat)+300) > uint64(time.Now().Unix())) {
+		// If the device is still alive (heart beat received in the last 5 mins), then assume this partner is trying to make an
+		// agreement. No one else can safely upgrade right now. The upgrade might be bad.
+		glog.V(5).Infof(logString(fmt.Sprintf("HA group member %v is upgrading.", deviceId)))
+		return true
 	} else {
-		// All other states that the agreement might be in are considered to be making an agreement and therefore the
-		// partner is considered to be upgrading.
-		partnerUpgrading = partnerWLU.DeviceId
+		// If the device is not alive then ignore it. We dont want this failed device to hold up the workload
+		// upgrade of other devices.
+		glog.V(5).Infof(logString(fmt.Sprintf("HA group member %v is not heartbeating.", deviceId)))
+	}
+	return false
+}
+
+// This function is used to determine if an HA group member that is running the upgraded workload is healthy. The member is
+// healthy when its node is heartbeating and the node reports the service of the agreement as running. An upgrade moves on
+// to the next members only when the upgraded members have been healthy for the minimum healthy duration of the group.
+func (w *AgreementBotWorker) isHAMemberHealthy(ag *persistence.Agreement) bool {
+
+	if !w.isHAMemberAlive(ag.DeviceId) {
+		return false
 	}
 
-	return partnerUpgrading, upgradedPartnerFound
+	status, err := exchange.GetHTTPNodeStatusHandler(w)(ag.DeviceId)
+	if err != nil {
+		glog.Errorf(logString(fmt.Sprintf("error obtaining node status of HA group member %v: %v", ag.DeviceId, err)))
+		return false
+	} else if !serviceReported(status, ag.CurrentAgreementId) {
+		glog.V(5).Infof(logString(fmt.Sprintf("HA group member %v has not reported the service for agreement %v.", ag.DeviceId, ag.CurrentAgreementId)))
+		return false
+	} else if problem := serviceHealthProblem(status, ag.CurrentAgreementId, ag.NHMaxRestarts, w.NHManager.RecentRestarts(ag.CurrentAgreementId, status, ag.NHUnhealthyServiceInterval)); problem != "" {
+		glog.V(3).Infof(logString(fmt.Sprintf("HA group member %v is not healthy: %v", ag.DeviceId, problem)))
+		return false
+	}
+	return true
 }
 
 // This function is used to verify that a node is still functioning correctly
@@ -407,20 +385,48 @@ func (w *AgreementBotWorker) VerifyNodeHealth(ag *persistence.Agreement, cph Con
 
 	glog.V(5).Infof("AgreementBot Governance checking node health for %v.", ag.CurrentAgreementId)
 
-	// Make sure the Node Health Manager has updated info for this agreement's pattern.
-	if err := w.NHManager.SetUpdatedStatus(ag.Pattern, ag.Org, nodeHealthHandler); err != nil {
-		return ag.NHCheckAgreementStatus, errors.New(fmt.Sprintf("unable to update node health for %v, error %v", ag.Pattern, err))
+	if ag.NHMissingHBInterval != 0 || ag.NHCheckAgreementStatus != 0 {
+		// Make sure the Node Health Manager has updated info for this agreement's pattern.
+		if err := w.NHManager.SetUpdatedStatus(ag.Pattern, ag.Org, nodeHealthHandler); err != nil {
+			return ag.NHCheckAgreementStatus, errors.New(fmt.Sprintf("unable to update node health for %v, error %v", ag.Pattern, err))
+		}
+
+		// If this agreement's node is out of policy, cancel the agreement and remove the node from the cache.
+		// If the agreement is missing, cancel it.
+		if w.NHManager.NodeOutOfPolicy(ag.Pattern, ag.Org, ag.DeviceId, ag.NHMissingHBInterval) {
+			w.TerminateAgreement(ag, cph.GetTerminationCode(TERM_REASON_NODE_HEARTBEAT))
+			return ag.NHCheckAgreementStatus, nil
+		} else if w.NHManager.AgreementOutOfPolicy(ag.Pattern, ag.Org, ag.DeviceId, ag.CurrentAgreementId, ag.AgreementFinalizedTime, ag.NHCheckAgreementStatus) {
+			w.TerminateAgreement(ag, cph.GetTerminationCode(TERM_REASON_AG_MISSING))
+			return ag.NHCheckAgreementStatus, nil
+		}
+	}
+
+	if !ag.ServiceHealthInUse() {
+		return ag.NHCheckAgreementStatus, nil
+	}
+
+	// The service is checked at least as often as it is allowed to be unhealthy.
+	checkRate := ag.NHCheckAgreementStatus
+	if checkRate == 0 || ag.NHUnhealthyServiceInterval < checkRate {
+		checkRate = ag.NHUnhealthyServiceInterval
 	}
 
-	// If this agreement's node is out of policy, cancel the agreement and remove the node from the cache.
-	// If the agreement is missing, cancel it.
-	if w.NHManager.NodeOutOfPolicy(ag.Pattern, ag.Org, ag.DeviceId, ag.NHMissingHBInterval) {
-		w.TerminateAgreement(ag, cph.GetTerminationCode(TERM_REASON_NODE_HEARTBEAT))
-	} else if w.NHManager.AgreementOutOfPolicy(ag.Pattern, ag.Org, ag.DeviceId, ag.CurrentAgreementId, ag.AgreementFinalizedTime, ag.NHCheckAgreementStatus) {
-		w.TerminateAgreement(ag, cph.GetTerminationCode(TERM_REASON_AG_MISSING))
+	// If the node has reported the service of this agreement as unhealthy for too long, cancel the agreement and search
+	// for nodes again so that the service is placed on another node, or on this node with the next workload priority.
+	status, err := exchange.GetHTTPNodeStatusHandler(w)(ag.DeviceId)
+	if err != nil {
+		return checkRate, errors.New(fmt.Sprintf("unable to get node status for %v, error %v", ag.DeviceId, err))
+	} else if unhealthy, reason := w.NHManager.ServiceOutOfPolicy(ag.CurrentAgreementId, status, ag.NHMaxRestarts, ag.NHUnhealthyServiceInterval); unhealthy {
+		glog.V(3).Infof(logString(fmt.Sprintf("service for agreement %v on node %v is unhealthy: %v", ag.CurrentAgreementId, ag.DeviceId, reason)))
+		w.NHManager.ForgetAgreement(ag.CurrentAgreementId)
+		w.nodeSearch.AddRetry(ag.PolicyName, ag.AgreementCreationTime-w.BaseWorker.Manager.Config.GetAgbotRetryLookBackWindow())
+		w.TerminateAgreement(ag, cph.GetTerminationCode(TERM_REASON_SERVICE_UNHEALTHY))
+	} else if reason != "" {
+		glog.V(5).Infof(logString(fmt.Sprintf("service for agreement %v on node %v is unhealthy: %v", ag.CurrentAgreementId, ag.DeviceId, reason)))
 	}
 
-	return ag.NHCheckAgreementStatus, nil
+	return checkRate, nil
 }
 
 func (w *AgreementBotWorker) TerminateAgreement(ag *persistence.Agreement, reason uint) {
@@ -0,0 +1,270 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+	"strings"
+	"time"
+)
+
+// When a policy change requires the workload on an HA group to be upgraded, the group members are upgraded in a rolling
+// fashion so that the group keeps running the workload. The HA group definition on the node controls how many members
+// can be unavailable at the same time, how long an upgraded member has to be healthy before the upgrade moves on to the
+// next members, and the order in which the members are upgraded. The progress of the upgrade is derived from the
+// workload usage records and agreements of the group members.
+
+// The upgrade states of an HA group member.
+const HA_MEMBER_PENDING = "pending"     // The member is waiting for its turn to upgrade.
+const HA_MEMBER_UPGRADING = "upgrading" // The member is making an agreement for the upgraded workload.
+const HA_MEMBER_SETTLING = "settling"   // The member is running the upgraded workload, but has not been healthy for the minimum healthy duration.
+const HA_MEMBER_UPGRADED = "upgraded"   // The member has been healthy running the upgraded workload for at least the minimum healthy duration.
+const HA_MEMBER_RUNNING = "running"     // The member is running a workload that is not the highest priority workload.
+const HA_MEMBER_DOWN = "down"           // The member has no agreement and is not heartbeating, it does not hold up the upgrade.
+
+type HAMemberProgress struct {
+	DeviceId     string `json:"device_id"`
+	State        string `json:"state"`
+	AgreementId  string `json:"agreement_id,omitempty"`
+	HealthySince uint64 `json:"healthy_since,omitempty"` // The time since which an upgraded member has been healthy, zero when it is not healthy
+}
+
+type HAUpgradeProgress struct {
+	PolicyName          string             `json:"policy_name"`
+	MaxUnavailable      int                `json:"max_unavailable"`
+	MinHealthyDurationS int                `json:"min_healthy_duration"`
+	Order               []string           `json:"order,omitempty"`
+	Members             []HAMemberProgress `json:"members"` // The group members in upgrade order
+	Next                []string           `json:"next"`    // The members that can begin upgrading now
+}
+
+func (p HAUpgradeProgress) String() string {
+	return fmt.Sprintf("PolicyName: %v, MaxUnavailable: %v, MinHealthyDurationS: %v, Order: %v, Members: %v, Next: %v",
+		p.PolicyName, p.MaxUnavailable, p.MinHealthyDurationS, p.Order, p.Members, p.Next)
+}
+
+// Returns true if the device has no agreement but is still alive, and therefore can be expected to make an agreement.
+type HAMemberAliveFunc func(deviceId string) bool
+
+// Returns true if the node of the agreement is heartbeating and reports the service of the agreement as healthy.
+type HAMemberHealthyFunc func(ag *persistence.Agreement) bool
+
+// Determine the upgrade progress of the HA group that the input workload usage record belongs to. The isAlive function
+// is used for members that are between agreements, if it is nil those members are considered to be upgrading. The
+// isHealthy function is used to record the health of members that are running the upgraded workload, if it is nil the
+// health that was last recorded is used.
+func NewHAUpgradeProgress(db persistence.AgbotDatabase, wlu *persistence.WorkloadUsage, isAlive HAMemberAliveFunc, isHealthy HAMemberHealthyFunc) (*HAUpgradeProgress, error) {
+
+	haGroup := wlu.GetHAGroup()
+	progress := &HAUpgradeProgress{
+		PolicyName:          wlu.PolicyName,
+		MaxUnavailable:      haGroup.GetMaxUnavailable(),
+		MinHealthyDurationS: haGroup.MinHealthyDurationS,
+		Order:               haGroup.Order,
+		Members:             make([]HAMemberProgress, 0, len(haGroup.Partners)+1),
+		Next:                make([]string, 0),
+	}
+
+	members := append([]string{wlu.DeviceId}, haGroup.Partners...)
+	haGroup.SortForUpgrade(members)
+
+	now := uint64(time.Now().Unix())
+	for _, deviceId := range members {
+		member := HAMemberProgress{DeviceId: deviceId}
+
+		memberWLU := wlu
+		if deviceId != wlu.DeviceId {
+			var err error
+			if memberWLU, err = db.FindSingleWorkloadUsageByDeviceAndPolicyName(deviceId, wlu.PolicyName); err != nil {
+				return nil, fmt.Errorf("error obtaining partner workload usage record for device %v and policy %v, error: %v", deviceId, wlu.PolicyName, err)
+			}
+		}
+
+		if memberWLU == nil {
+			// Workload usage records are deleted when a member begins to upgrade, they are created again when the
+			// member makes an agreement for the upgraded workload.
+			member.State = HA_MEMBER_UPGRADING
+		} else if memberWLU.PendingUpgradeTime != 0 {
+			member.State = HA_MEMBER_PENDING
+		} else if err := haMemberAgreementState(db, memberWLU, &member, haGroup.MinHealthyDurationS, now, isAlive, isHealthy); err != nil {
+			return nil, err
+		}
+
+		progress.Members = append(progress.Members, member)
+	}
+
+	// Members that are upgrading or settling are unavailable. The upgrade moves on to the pending members, in order, when
+	// at least one member has upgraded, and there is room for more unavailable members. The first member to upgrade is
+	// chosen when the upgrade begins.
+	unavailable := 0
+	upgraded := 0
+	for _, m := range progress.Members {
+		if m.State == HA_MEMBER_UPGRADING || m.State == HA_MEMBER_SETTLING {
+			unavailable += 1
+		} else if m.State == HA_MEMBER_UPGRADED {
+			upgraded += 1
+		}
+	}
+
+	if upgraded != 0 {
+		for _, m := range progress.Members {
+			if unavailable >= progress.MaxUnavailable {
+				break
+			} else if m.State == HA_MEMBER_PENDING {
+				progress.Next = append(progress.Next, m.DeviceId)
+				unavailable += 1
+			}
+		}
+	}
+
+	return progress, nil
+}
+
+// Determine the upgrade state of a member that is not pending an upgrade from the agreement it is using.
+func haMemberAgreementState(db persistence.AgbotDatabase, memberWLU *persistence.WorkloadUsage, member *HAMemberProgress, minHealthyS int, now uint64, isAlive HAMemberAliveFunc, isHealthy HAMemberHealthyFunc) error {
+
+	ag, err := db.FindSingleAgreementByAgreementIdAllProtocols(memberWLU.CurrentAgreementId, policy.AllAgreementProtocols(), []persistence.AFilter{persistence.UnarchivedAFilter()})
+	if err != nil {
+		return fmt.Errorf("unable to read agreement %v from database, error: %v", memberWLU.CurrentAgreementId, err)
+	}
+
+	if ag == nil {
+		// A previous agreement with the member has failed and the workload usage record is between agreement attempts.
+		// If the member is still alive, it can be expected to make an agreement.
+		if isAlive == nil || isAlive(memberWLU.DeviceId) {
+			member.State = HA_MEMBER_UPGRADING
+		} else {
+			member.State = HA_MEMBER_DOWN
+		}
+		return nil
+	}
+
+	member.AgreementId = ag.CurrentAgreementId
+	if ag.AgreementFinalizedTime == 0 || ag.DataVerifiedTime == ag.AgreementCreationTime || ag.AgreementTimedout != 0 {
+		// All other states that the agreement might be in are considered to be making an agreement.
+		member.State = HA_MEMBER_UPGRADING
+		return nil
+	}
+
+	// The agreement is in execution and data has been verified. The member is upgraded when it is running the highest
+	// priority workload and has been healthy for the minimum healthy duration. The healthy duration starts over whenever
+	// the member is seen unhealthy.
+	if pol, err := policy.DemarshalPolicy(memberWLU.Policy); err != nil {
+		return fmt.Errorf("unable to demarshal policy for workload usage %v, error %v", memberWLU, err)
+	} else if workload := pol.NextHighestPriorityWorkload(0, 0, 0); workload == nil || memberWLU.Priority != workload.Priority.PriorityValue {
+		member.State = HA_MEMBER_RUNNING
+		return nil
+	}
+
+	if isHealthy != nil {
+		var updated *persistence.Agreement
+		if isHealthy(ag) {
+			updated, err = db.AgreementHealthy(ag.CurrentAgreementId, ag.AgreementProtocol)
+		} else {
+			updated, err = db.AgreementUnhealthy(ag.CurrentAgreementId, ag.AgreementProtocol)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to record the health of agreement %v, error: %v", ag.CurrentAgreementId, err)
+		} else if updated != nil {
+			ag = updated
+		}
+	}
+
+	member.HealthySince = ag.HealthySince()
+	if member.HealthySince != 0 && now >= member.HealthySince+uint64(minHealthyS) {
+		member.State = HA_MEMBER_UPGRADED
+	} else {
+		member.State = HA_MEMBER_SETTLING
+	}
+	return nil
+}
+
+// Return the key of the HA group that the workload usage record belongs to. The records of all the members of a group
+// have the same key, the members are identified by the policy and the sorted list of members.
+func haGroupKey(wlu *persistence.WorkloadUsage) string {
+	members := append([]string{wlu.DeviceId}, wlu.HAPartners...)
+	sort.Strings(members)
+	return wlu.PolicyName + " " + strings.Join(members, ",")
+}
+
+// The upgrade progress of the HA groups with a pending upgrade, computed once per governance pass. Determining the
+// progress checks the health of every member, so it is shared by all the pending members of a group.
+type haUpgradeProgressCache struct {
+	db        persistence.AgbotDatabase
+	isAlive   HAMemberAliveFunc
+	isHealthy HAMemberHealthyFunc
+	progress  map[string]*HAUpgradeProgress
+	errs      map[string]error
+}
+
+func newHAUpgradeProgressCache(db persistence.AgbotDatabase, isAlive HAMemberAliveFunc, isHealthy HAMemberHealthyFunc) *haUpgradeProgressCache {
+	return &haUpgradeProgressCache{
+		db:        db,
+		isAlive:   isAlive,
+		isHealthy: isHealthy,
+		progress:  make(map[string]*HAUpgradeProgress),
+		errs:      make(map[string]error),
+	}
+}
+
+// Return the upgrade progress of the HA group that the workload usage record belongs to, it is determined on the first
+// call for the group.
+func (c *haUpgradeProgressCache) get(wlu *persistence.WorkloadUsage) (*HAUpgradeProgress, error) {
+	key := haGroupKey(wlu)
+	if progress, ok := c.progress[key]; ok {
+		return progress, nil
+	} else if err, ok := c.errs[key]; ok {
+		return nil, err
+	}
+
+	progress, err := NewHAUpgradeProgress(c.db, wlu, c.isAlive, c.isHealthy)
+	if err != nil {
+		c.errs[key] = err
+		return nil, err
+	}
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("HA group upgrade progress %v", progress)))
+	c.progress[key] = progress
+	return progress, nil
+}
+
+// Return true if the device is one of the members that can begin upgrading now.
+func (p *HAUpgradeProgress) CanUpgrade(deviceId string) bool {
+	for _, id := range p.Next {
+		if id == deviceId {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the upgrade progress of all the HA groups that have workload usage records. Each group is reported once
+// per policy.
+func FindHAUpgradeProgress(db persistence.AgbotDatabase) ([]HAUpgradeProgress, error) {
+
+	HAWUFilter := func() persistence.WUFilter {
+		return func(a persistence.WorkloadUsage) bool { return len(a.HAPartners) != 0 }
+	}
+
+	wlus, err := db.FindWorkloadUsages([]persistence.WUFilter{HAWUFilter()})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(WorkloadUsagesByDeviceId(wlus))
+
+	res := make([]HAUpgradeProgress, 0)
+	groups := newHAUpgradeProgressCache(db, nil, nil)
+	for ix := range wlus {
+		if _, seen := groups.progress[haGroupKey(&wlus[ix])]; seen {
+			continue
+		}
+		progress, err := groups.get(&wlus[ix])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *progress)
+	}
+	return res, nil
+}
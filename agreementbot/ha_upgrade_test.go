@@ -0,0 +1,214 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/agreementbot/persistence/bolt"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func haTestDB(t *testing.T) (persistence.AgbotDatabase, string) {
+	dir, err := ioutil.TempDir("", "agbot-ha-")
+	if err != nil {
+		t.Fatalf("unable to create temp dir, error %v", err)
+	}
+	db := &bolt.AgbotBoltDB{}
+	if err := db.Initialize(&config.HorizonConfig{AgreementBot: config.AGConfig{DBPath: dir}}); err != nil {
+		t.Fatalf("unable to initialize the database, error %v", err)
+	}
+	return db, dir
+}
+
+// Create the workload usage record for an HA group member. When agid is not empty, the member is running the highest
+// priority workload in an agreement that has been finalized and verified.
+func haTestMember(t *testing.T, db persistence.AgbotDatabase, deviceId string, haGroup *policy.HighAvailabilityGroup, agid string, pending bool) {
+	pol := policy.Policy_Factory("pol1")
+	wl := policy.Workload_Factory("svc1", "org1", "1.0.0", "amd64")
+	wl.Priority.PriorityValue = 1
+	wl.Priority.RetryDurationS = 600
+	pol.Add_Workload(wl)
+	polBytes, _ := json.Marshal(pol)
+
+	wuAgId := agid
+	if wuAgId == "" {
+		wuAgId = "none-" + deviceId
+	}
+	if err := db.NewWorkloadUsage(deviceId, haGroup, string(polBytes), "pol1", 1, 600, 0, false, wuAgId, ""); err != nil {
+		t.Fatalf("unable to create workload usage for %v, error %v", deviceId, err)
+	}
+	if pending {
+		if _, err := db.UpdatePendingUpgrade(deviceId, "pol1"); err != nil {
+			t.Fatalf("unable to mark %v pending upgrade, error %v", deviceId, err)
+		}
+	}
+
+	if agid != "" {
+		if err := db.AgreementAttempt(agid, "org1", deviceId, "device", "pol1", "", "", "", policy.BasicProtocol, "", []string{"org1/svc1"}, policy.NodeHealth{}, 0, 0, ""); err != nil {
+			t.Fatalf("unable to create agreement for %v, error %v", deviceId, err)
+		} else if _, err := db.AgreementMade(agid, "", "", policy.BasicProtocol, haGroup.Partners, "", "", ""); err != nil {
+			t.Fatalf("unable to make agreement for %v, error %v", deviceId, err)
+		} else if _, err := db.AgreementFinalized(agid, policy.BasicProtocol); err != nil {
+			t.Fatalf("unable to finalize agreement for %v, error %v", deviceId, err)
+		}
+	}
+}
+
+func Test_ha_upgrade_progress(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	group := func(self string, maxUnavailable int, minHealthyS int) *policy.HighAvailabilityGroup {
+		partners := make([]string, 0, 2)
+		for _, n := range []string{"org1/n1", "org1/n2", "org1/n3"} {
+			if n != self {
+				partners = append(partners, n)
+			}
+		}
+		g := policy.HAGroup_Factory(partners)
+		g.MaxUnavailable = maxUnavailable
+		g.MinHealthyDurationS = minHealthyS
+		g.Order = []string{"org1/n3"}
+		return g
+	}
+
+	// n1 has upgraded, n2 and n3 are pending.
+	haTestMember(t, db, "org1/n1", group("org1/n1", 1, 0), "ag1", false)
+	haTestMember(t, db, "org1/n2", group("org1/n2", 1, 0), "", true)
+	haTestMember(t, db, "org1/n3", group("org1/n3", 1, 0), "", true)
+
+	// The data verification has to be after the agreement was made.
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := db.DataVerified("ag1", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to verify data for ag1, error %v", err)
+	}
+
+	wlu, _ := db.FindSingleWorkloadUsageByDeviceAndPolicyName("org1/n2", "pol1")
+
+	healthy := true
+	isHealthy := func(ag *persistence.Agreement) bool { return healthy }
+
+	// The ordered member n3 is next.
+	if progress, err := NewHAUpgradeProgress(db, wlu, nil, isHealthy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(progress.Members) != 3 || progress.Members[0].DeviceId != "org1/n3" || progress.Members[1].State != HA_MEMBER_UPGRADED {
+		t.Errorf("wrong members %v", progress.Members)
+	} else if len(progress.Next) != 1 || !progress.CanUpgrade("org1/n3") || progress.CanUpgrade("org1/n2") {
+		t.Errorf("expected org1/n3 to be next, got %v", progress.Next)
+	}
+
+	// Two members can upgrade at the same time.
+	wlu.HAMaxUnavailable = 2
+	if progress, err := NewHAUpgradeProgress(db, wlu, nil, isHealthy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(progress.Next) != 2 || progress.Next[0] != "org1/n3" || progress.Next[1] != "org1/n2" {
+		t.Errorf("expected both pending members to be next, got %v", progress.Next)
+	}
+
+	// n1 has not been healthy long enough.
+	wlu.HAMinHealthyS = 3600
+	if progress, err := NewHAUpgradeProgress(db, wlu, nil, isHealthy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if progress.Members[1].State != HA_MEMBER_SETTLING || len(progress.Next) != 0 {
+		t.Errorf("expected no member to be next while org1/n1 is settling, got %v", progress)
+	}
+
+	// n1 is unhealthy, so it is settling again even without a minimum healthy duration.
+	wlu.HAMinHealthyS = 0
+	healthy = false
+	if progress, err := NewHAUpgradeProgress(db, wlu, nil, isHealthy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if progress.Members[1].State != HA_MEMBER_SETTLING || progress.Members[1].HealthySince != 0 || len(progress.Next) != 0 {
+		t.Errorf("expected org1/n1 to be settling while it is unhealthy, got %v", progress)
+	} else if progress, err := NewHAUpgradeProgress(db, wlu, nil, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if progress.Members[1].State != HA_MEMBER_SETTLING {
+		t.Errorf("expected the recorded health to be used, got %v", progress)
+	}
+
+	// n1 is healthy again, the healthy duration starts over.
+	time.Sleep(1100 * time.Millisecond)
+	healthy = true
+	if progress, err := NewHAUpgradeProgress(db, wlu, nil, isHealthy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if progress.Members[1].State != HA_MEMBER_UPGRADED || progress.Members[1].HealthySince < uint64(time.Now().Unix())-1 {
+		t.Errorf("expected org1/n1 to be healthy again, got %v", progress)
+	}
+
+	// n3 is upgrading, so only n2 can start when two members can be unavailable.
+	if err := db.DeleteWorkloadUsage("org1/n3", "pol1"); err != nil {
+		t.Fatalf("unable to delete workload usage, error %v", err)
+	} else if progress, err := NewHAUpgradeProgress(db, wlu, nil, isHealthy); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if progress.Members[0].State != HA_MEMBER_UPGRADING || len(progress.Next) != 1 || progress.Next[0] != "org1/n2" {
+		t.Errorf("expected org1/n2 to be next, got %v", progress)
+	}
+
+	// The same group is reported once.
+	if all, err := FindHAUpgradeProgress(db); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(all) != 1 {
+		t.Errorf("expected 1 HA group, got %v", all)
+	}
+
+}
+
+func Test_ha_upgrade_progress_cache(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	group := func(self string) *policy.HighAvailabilityGroup {
+		partners := make([]string, 0, 2)
+		for _, n := range []string{"org1/n1", "org1/n2", "org1/n3"} {
+			if n != self {
+				partners = append(partners, n)
+			}
+		}
+		g := policy.HAGroup_Factory(partners)
+		g.MaxUnavailable = 2
+		return g
+	}
+
+	// n1 has upgraded, n2 and n3 are pending.
+	haTestMember(t, db, "org1/n1", group("org1/n1"), "ag1", false)
+	haTestMember(t, db, "org1/n2", group("org1/n2"), "", true)
+	haTestMember(t, db, "org1/n3", group("org1/n3"), "", true)
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := db.DataVerified("ag1", policy.BasicProtocol); err != nil {
+		t.Fatalf("unable to verify data for ag1, error %v", err)
+	}
+
+	healthChecks := 0
+	isHealthy := func(ag *persistence.Agreement) bool {
+		healthChecks++
+		return true
+	}
+
+	// The members of a group have the same key, whichever member the record is for.
+	wlu2, _ := db.FindSingleWorkloadUsageByDeviceAndPolicyName("org1/n2", "pol1")
+	wlu3, _ := db.FindSingleWorkloadUsageByDeviceAndPolicyName("org1/n3", "pol1")
+	if haGroupKey(wlu2) != haGroupKey(wlu3) {
+		t.Errorf("expected the same group key, got %v and %v", haGroupKey(wlu2), haGroupKey(wlu3))
+	}
+
+	// The progress is determined once for all the pending members of the group.
+	groups := newHAUpgradeProgressCache(db, nil, isHealthy)
+	if progress, err := groups.get(wlu2); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if !progress.CanUpgrade("org1/n2") || !progress.CanUpgrade("org1/n3") {
+		t.Errorf("expected both pending members to be next, got %v", progress)
+	} else if progress3, err := groups.get(wlu3); err != nil || progress3 != progress {
+		t.Errorf("expected the progress of the group to be reused, got %v, error %v", progress3, err)
+	} else if healthChecks != 1 {
+		t.Errorf("expected 1 health check in the pass, got %v", healthChecks)
+	}
+
+}
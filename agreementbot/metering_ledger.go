@@ -0,0 +1,269 @@
+package agreementbot
+
+import (
+	"crypto/rsa"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/metering"
+	"github.com/open-horizon/anax/policy"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// The metering ledger aggregates the metering notifications that this agbot has sent to nodes. Each agreement
+// records the last metering notifications sent for it, and the amount in a notification is the total number of tokens
+// granted since the agreement started. The ledger turns those into per agreement records for a time range, and
+// totals them by org, service and node. The agbot signs the meter of each notification with its message key.
+// Notifications that are not signed by the agbot, or that do not match the agreement they claim to be metering,
+// are reported in the ledger but excluded from the totals.
+
+// Ledger views, used to select what is exported as CSV.
+const LEDGER_VIEW_AGREEMENT = "agreement"
+const LEDGER_VIEW_ORG = "org"
+const LEDGER_VIEW_SERVICE = "service"
+const LEDGER_VIEW_NODE = "node"
+
+// Signature status of a metering record. A record is signed when the meters of its notifications are signed by this
+// agbot and the notifications carry the agreement hash and signatures recorded for the agreement. The producer
+// signature of the agreement is compared with the recorded one, it is not verified.
+const METER_SIG_SIGNED = "meter_signed"
+const METER_SIG_UNSIGNED = "unsigned"
+const METER_SIG_MISMATCH = "mismatch"
+
+type MeteringRecord struct {
+	AgreementId      string `json:"agreement_id"`
+	Org              string `json:"org"`               // The org of the policy that made the agreement
+	PolicyName       string `json:"policy_name"`       // The policy that made the agreement
+	NodeId           string `json:"node_id"`           // The org qualified node id
+	NodeOrg          string `json:"node_org"`          // The org of the node
+	Service          string `json:"service"`           // The org qualified url of the service
+	ServiceVersion   string `json:"service_version"`   // The version of the service
+	StartTime        uint64 `json:"start_time"`        // The time when the agreement started
+	NotificationTime uint64 `json:"notification_time"` // The time of the notification used for this record
+	Notifications    int    `json:"notifications"`     // The number of notifications sent within the time range of the ledger
+	TotalAmount      uint64 `json:"total_amount"`      // The tokens granted since the agreement started
+	PeriodAmount     uint64 `json:"period_amount"`     // The tokens granted within the time range of the ledger
+	MissedTime       uint64 `json:"missed_time"`       // The number of seconds that data was missing
+	Archived         bool   `json:"archived"`          // The agreement has been terminated
+	SignatureStatus  string `json:"signature_status"`  // meter_signed, unsigned or mismatch
+	SignatureError   string `json:"signature_error,omitempty"`
+}
+
+type MeteringTotal struct {
+	Key        string `json:"key"`
+	Agreements int    `json:"agreements"`
+	Amount     uint64 `json:"amount"`
+	MissedTime uint64 `json:"missed_time"`
+}
+
+type MeteringLedger struct {
+	Since         uint64           `json:"since"`
+	Until         uint64           `json:"until"`
+	Records       []MeteringRecord `json:"records"`
+	Rejected      int              `json:"rejected"` // The number of records excluded from the totals because they are unsigned or do not verify
+	OrgTotals     []MeteringTotal  `json:"org_totals"`
+	ServiceTotals []MeteringTotal  `json:"service_totals"`
+	NodeTotals    []MeteringTotal  `json:"node_totals"`
+}
+
+func (l MeteringLedger) String() string {
+	return fmt.Sprintf("Since: %v, Until: %v, Records: %v, Rejected: %v, OrgTotals: %v, ServiceTotals: %v, NodeTotals: %v",
+		l.Since, l.Until, len(l.Records), l.Rejected, l.OrgTotals, l.ServiceTotals, l.NodeTotals)
+}
+
+// Build a ledger from a set of agreements for the time range (since, until]. A zero since means from the beginning.
+// The meter signatures are verified with the public message key of the agbot.
+func NewMeteringLedger(agreements []persistence.Agreement, since uint64, until uint64, agbotKey *rsa.PublicKey) *MeteringLedger {
+
+	ledger := &MeteringLedger{
+		Since:   since,
+		Until:   until,
+		Records: make([]MeteringRecord, 0, len(agreements)),
+	}
+
+	for _, ag := range agreements {
+		if rec := newMeteringRecord(&ag, since, until, agbotKey); rec != nil {
+			ledger.Records = append(ledger.Records, *rec)
+		}
+	}
+
+	sort.Slice(ledger.Records, func(i, j int) bool { return ledger.Records[i].AgreementId < ledger.Records[j].AgreementId })
+
+	orgs := make(map[string]*MeteringTotal)
+	services := make(map[string]*MeteringTotal)
+	nodes := make(map[string]*MeteringTotal)
+
+	for _, rec := range ledger.Records {
+		if rec.SignatureStatus != METER_SIG_SIGNED {
+			ledger.Rejected += 1
+			continue
+		}
+		addToTotal(orgs, rec.Org, &rec)
+		addToTotal(services, rec.Service, &rec)
+		addToTotal(nodes, rec.NodeId, &rec)
+	}
+
+	ledger.OrgTotals = sortedTotals(orgs)
+	ledger.ServiceTotals = sortedTotals(services)
+	ledger.NodeTotals = sortedTotals(nodes)
+
+	return ledger
+}
+
+// Create the ledger record for an agreement, or nil if the agreement has no metering notification in the time range.
+func newMeteringRecord(ag *persistence.Agreement, since uint64, until uint64, agbotKey *rsa.PublicKey) *MeteringRecord {
+
+	// Agreements made before the history was recorded only have the retained notifications.
+	msgs := ag.MeteringNotificationHistory
+	if len(msgs) == 0 {
+		msgs = make([]string, 0, len(ag.MeteringNotificationMsgs))
+		for ix := len(ag.MeteringNotificationMsgs) - 1; ix >= 0; ix-- {
+			msgs = append(msgs, ag.MeteringNotificationMsgs[ix])
+		}
+	}
+
+	// Demarshal the notifications, oldest first.
+	notifications := make([]metering.MeteringNotification, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg == "" {
+			continue
+		}
+		var mn metering.MeteringNotification
+		if err := json.Unmarshal([]byte(msg), &mn); err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to demarshal metering notification %v for agreement %v, error: %v", msg, ag.CurrentAgreementId, err)))
+			continue
+		}
+		notifications = append(notifications, mn)
+	}
+
+	// Find the notifications in the time range, the newest one is the current one, and the newest one before the range.
+	var current, baseline *metering.MeteringNotification
+	inRange := make([]*metering.MeteringNotification, 0, len(notifications))
+	for ix := range notifications {
+		mn := &notifications[ix]
+		if (until == 0 || mn.CurrentTime <= until) && mn.CurrentTime > since {
+			inRange = append(inRange, mn)
+			if current == nil || mn.CurrentTime >= current.CurrentTime {
+				current = mn
+			}
+		} else if mn.CurrentTime <= since && (baseline == nil || mn.CurrentTime >= baseline.CurrentTime) {
+			baseline = mn
+		}
+	}
+
+	if current == nil {
+		return nil
+	}
+
+	rec := &MeteringRecord{
+		AgreementId:      ag.CurrentAgreementId,
+		Org:              ag.Org,
+		PolicyName:       ag.PolicyName,
+		NodeId:           ag.DeviceId,
+		NodeOrg:          exchange.GetOrg(ag.DeviceId),
+		StartTime:        current.StartTime,
+		NotificationTime: current.CurrentTime,
+		TotalAmount:      current.Amount,
+		MissedTime:       current.MissedTime,
+		Notifications:    len(inRange),
+		Archived:         ag.Archived,
+	}
+
+	// The service comes from the policy used to make the agreement.
+	if ag.Policy != "" {
+		if pol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to demarshal policy for agreement %v, error: %v", ag.CurrentAgreementId, err)))
+		} else if len(pol.Workloads) != 0 {
+			rec.Service = fmt.Sprintf("%v/%v", pol.Workloads[0].Org, pol.Workloads[0].WorkloadURL)
+			rec.ServiceVersion = pol.Workloads[0].Version
+		}
+	}
+
+	// Tokens granted within the time range. When there is no notification from before the range, the tokens
+	// are assumed to have accrued evenly since the agreement started, which is how the agbot calculates them.
+	rec.PeriodAmount = current.Amount
+	if baseline != nil {
+		if baseline.Amount < current.Amount {
+			rec.PeriodAmount = current.Amount - baseline.Amount
+		} else {
+			rec.PeriodAmount = 0
+		}
+	} else if current.StartTime < since && current.CurrentTime > current.StartTime {
+		accrued := float64(current.Amount) * float64(since-current.StartTime) / float64(current.CurrentTime-current.StartTime)
+		rec.PeriodAmount = current.Amount - uint64(accrued)
+	}
+
+	// Verify that each notification used for the record belongs to the agreement it is recorded in, and that its meter
+	// was signed by this agbot.
+	rec.SignatureStatus = METER_SIG_SIGNED
+	if baseline != nil {
+		inRange = append(inRange, baseline)
+	}
+	for _, mn := range inRange {
+		if err := mn.VerifyAgreement(ag.CurrentAgreementId, ag.ProposalHash, ag.ConsumerProposalSig, ag.ProposalSig, agbotKey); err != nil {
+			rec.SignatureStatus = METER_SIG_MISMATCH
+			rec.SignatureError = err.Error()
+			break
+		} else if !mn.IsSigned() {
+			rec.SignatureStatus = METER_SIG_UNSIGNED
+		}
+	}
+
+	return rec
+}
+
+func addToTotal(totals map[string]*MeteringTotal, key string, rec *MeteringRecord) {
+	if _, ok := totals[key]; !ok {
+		totals[key] = &MeteringTotal{Key: key}
+	}
+	totals[key].Agreements += 1
+	totals[key].Amount += rec.PeriodAmount
+	totals[key].MissedTime += rec.MissedTime
+}
+
+func sortedTotals(totals map[string]*MeteringTotal) []MeteringTotal {
+	res := make([]MeteringTotal, 0, len(totals))
+	for _, t := range totals {
+		res = append(res, *t)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Write one view of the ledger in CSV format, with a header row.
+func (l *MeteringLedger) WriteCSV(w io.Writer, view string) error {
+
+	cw := csv.NewWriter(w)
+
+	switch view {
+	case LEDGER_VIEW_AGREEMENT, "":
+		cw.Write([]string{"agreement_id", "org", "policy_name", "node_id", "node_org", "service", "service_version", "start_time", "notification_time", "total_amount", "period_amount", "missed_time", "archived", "signature_status"})
+		for _, r := range l.Records {
+			cw.Write([]string{r.AgreementId, r.Org, r.PolicyName, r.NodeId, r.NodeOrg, r.Service, r.ServiceVersion,
+				strconv.FormatUint(r.StartTime, 10), strconv.FormatUint(r.NotificationTime, 10), strconv.FormatUint(r.TotalAmount, 10),
+				strconv.FormatUint(r.PeriodAmount, 10), strconv.FormatUint(r.MissedTime, 10), strconv.FormatBool(r.Archived), r.SignatureStatus})
+		}
+	case LEDGER_VIEW_ORG, LEDGER_VIEW_SERVICE, LEDGER_VIEW_NODE:
+		totals := l.OrgTotals
+		if view == LEDGER_VIEW_SERVICE {
+			totals = l.ServiceTotals
+		} else if view == LEDGER_VIEW_NODE {
+			totals = l.NodeTotals
+		}
+		cw.Write([]string{view, "agreements", "amount", "missed_time"})
+		for _, t := range totals {
+			cw.Write([]string{t.Key, strconv.Itoa(t.Agreements), strconv.FormatUint(t.Amount, 10), strconv.FormatUint(t.MissedTime, 10)})
+		}
+	default:
+		return errors.New(fmt.Sprintf("unsupported ledger view %v, must be one of %v, %v, %v or %v", view, LEDGER_VIEW_AGREEMENT, LEDGER_VIEW_ORG, LEDGER_VIEW_SERVICE, LEDGER_VIEW_NODE))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
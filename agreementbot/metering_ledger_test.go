@@ -0,0 +1,155 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/metering"
+	"github.com/open-horizon/anax/policy"
+	"strings"
+	"testing"
+)
+
+func meteringMsg(t *testing.T, key *rsa.PrivateKey, agId string, amount uint64, start uint64, current uint64, cSig string, pSig string) string {
+	mn := metering.MeteringNotification{
+		Amount:            amount,
+		StartTime:         start,
+		CurrentTime:       current,
+		AgreementId:       agId,
+		AgreementHash:     "hash-" + agId,
+		ConsumerSignature: cSig,
+		ProducerSignature: pSig,
+	}
+	if key != nil {
+		if err := mn.SignMeter(key); err != nil {
+			t.Fatalf("unable to sign metering notification, error %v", err)
+		}
+	}
+	if b, err := json.Marshal(mn); err != nil {
+		t.Fatalf("unable to marshal metering notification, error %v", err)
+		return ""
+	} else {
+		return string(b)
+	}
+}
+
+func meteredAgreement(t *testing.T, agId string, org string, node string, service string, msgs []string) persistence.Agreement {
+	pol := policy.Policy_Factory("test")
+	pol.Add_Workload(policy.Workload_Factory(service, org, "1.0.0", "amd64"))
+	polBytes, _ := json.Marshal(pol)
+	return persistence.Agreement{
+		CurrentAgreementId:          agId,
+		Org:                         org,
+		DeviceId:                    node,
+		PolicyName:                  "pol1",
+		Policy:                      string(polBytes),
+		ProposalHash:                "hash-" + agId,
+		ConsumerProposalSig:         "csig",
+		ProposalSig:                 "psig",
+		MeteringNotificationMsgs:    []string{msgs[len(msgs)-1], ""},
+		MeteringNotificationHistory: msgs,
+	}
+}
+
+func Test_metering_ledger(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key, error %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key, error %v", err)
+	}
+
+	// The agreement ids are hex because they are part of the meter hash.
+	agreements := []persistence.Agreement{
+		// Both notifications are in range so there is no baseline, the amount is prorated.
+		meteredAgreement(t, "a1", "org1", "nodeorg/node1", "svc1", []string{
+			meteringMsg(t, key, "a1", 200, 1000, 1200, "csig", "psig"),
+			meteringMsg(t, key, "a1", 300, 1000, 1300, "csig", "psig"),
+		}),
+		// The baseline is the newest notification before the range, it is older than the last two notifications.
+		meteredAgreement(t, "a2", "org1", "nodeorg/node2", "svc2", []string{
+			meteringMsg(t, key, "a2", 100, 1000, 1100, "csig", "psig"),
+			meteringMsg(t, key, "a2", 300, 1000, 1300, "csig", "psig"),
+			meteringMsg(t, key, "a2", 400, 1000, 1400, "csig", "psig"),
+			meteringMsg(t, key, "a2", 500, 1000, 1500, "csig", "psig"),
+		}),
+		// Not signed, the record is excluded from the totals.
+		meteredAgreement(t, "a3", "org2", "nodeorg/node1", "svc1", []string{
+			meteringMsg(t, nil, "a3", 100, 1000, 1200, "", ""),
+		}),
+		// The notification does not match the agreement signatures.
+		meteredAgreement(t, "a4", "org2", "nodeorg/node3", "svc1", []string{
+			meteringMsg(t, key, "a4", 100, 1150, 1200, "forged", "psig"),
+		}),
+		// No notification in range.
+		meteredAgreement(t, "a5", "org2", "nodeorg/node3", "svc1", []string{
+			meteringMsg(t, key, "a5", 100, 1000, 1600, "csig", "psig"),
+		}),
+		// The agreement fields are copied but the meter is signed by another key.
+		meteredAgreement(t, "a6", "org2", "nodeorg/node3", "svc1", []string{
+			meteringMsg(t, otherKey, "a6", 100, 1000, 1200, "csig", "psig"),
+		}),
+		// Agreement made before the history was recorded, the amount is prorated from the retained notification.
+		meteredAgreement(t, "a7", "org2", "nodeorg/node1", "svc1", []string{
+			meteringMsg(t, key, "a7", 100, 1000, 1200, "csig", "psig"),
+		}),
+	}
+	agreements[2].ConsumerProposalSig = ""
+	agreements[2].ProposalSig = ""
+	agreements[6].MeteringNotificationHistory = nil
+
+	ledger := NewMeteringLedger(agreements, 1100, 1500, &key.PublicKey)
+
+	if len(ledger.Records) != 6 {
+		t.Fatalf("expected 6 records, got %v", ledger)
+	} else if ledger.Rejected != 3 {
+		t.Errorf("expected 3 rejected records, got %v", ledger.Rejected)
+	}
+
+	expected := map[string]uint64{"a1": 200, "a2": 400, "a3": 50, "a4": 100, "a6": 50, "a7": 50}
+	statuses := map[string]string{"a1": METER_SIG_SIGNED, "a2": METER_SIG_SIGNED, "a3": METER_SIG_UNSIGNED, "a4": METER_SIG_MISMATCH, "a6": METER_SIG_MISMATCH, "a7": METER_SIG_SIGNED}
+	counts := map[string]int{"a1": 2, "a2": 3, "a3": 1, "a4": 1, "a6": 1, "a7": 1}
+	for _, r := range ledger.Records {
+		if r.PeriodAmount != expected[r.AgreementId] {
+			t.Errorf("record %v should have period amount %v", r, expected[r.AgreementId])
+		} else if r.SignatureStatus != statuses[r.AgreementId] {
+			t.Errorf("record %v should have signature status %v", r, statuses[r.AgreementId])
+		} else if r.Notifications != counts[r.AgreementId] {
+			t.Errorf("record %v should have %v notifications", r, counts[r.AgreementId])
+		}
+	}
+
+	if len(ledger.OrgTotals) != 2 || ledger.OrgTotals[0].Key != "org1" || ledger.OrgTotals[0].Amount != 600 || ledger.OrgTotals[1].Amount != 50 {
+		t.Errorf("wrong org totals %v", ledger.OrgTotals)
+	} else if len(ledger.ServiceTotals) != 3 || ledger.ServiceTotals[0].Key != "org1/svc1" || ledger.ServiceTotals[0].Amount != 200 {
+		t.Errorf("wrong service totals %v", ledger.ServiceTotals)
+	} else if len(ledger.NodeTotals) != 2 || ledger.NodeTotals[0].Key != "nodeorg/node1" || ledger.NodeTotals[0].Amount != 250 || ledger.NodeTotals[0].Agreements != 2 {
+		t.Errorf("wrong node totals %v", ledger.NodeTotals)
+	}
+
+	var buf bytes.Buffer
+	if err := ledger.WriteCSV(&buf, LEDGER_VIEW_ORG); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || lines[1] != "org1,2,600,0" {
+		t.Errorf("wrong CSV output %v", buf.String())
+	}
+
+	buf.Reset()
+	if err := ledger.WriteCSV(&buf, LEDGER_VIEW_AGREEMENT); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 7 {
+		t.Errorf("wrong CSV output %v", buf.String())
+	}
+
+	if err := ledger.WriteCSV(&buf, "bad"); err == nil {
+		t.Errorf("expected an error for an unsupported view")
+	}
+
+}
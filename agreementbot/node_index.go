@@ -0,0 +1,329 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The node index is a local copy of the node attributes that the agbot uses to decide which nodes are candidates
+// for an agreement. It is maintained incrementally from the node and node policy changes reported by the exchange
+// /changes API, so that the agbot can evaluate its patterns and deployment policies without searching the exchange
+// on every scan. The index is seeded from the node listings of the served node orgs, and the periodic consistency
+// check reconciles it with the same listings: new nodes are added, changed nodes are updated and deleted nodes are
+// removed. The node policies are only fetched for the policy based nodes whose policy the index does not have yet.
+type IndexedNode struct {
+	Id            string         `json:"id"`      // The org qualified node id
+	Org           string         `json:"org"`     // The org of the node
+	Pattern       string         `json:"pattern"` // The org qualified pattern the node is registered with, empty for policy based nodes
+	Arch          string         `json:"arch"`
+	NodeType      string         `json:"nodeType"`
+	PublicKey     string         `json:"publicKey"`
+	LastHeartbeat string         `json:"lastHeartbeat"`
+	Services      []string       `json:"services"` // The org qualified urls of the services registered by the node
+	Policy        *policy.Policy `json:"policy"`   // The node policy, nil if the node has no policy
+	Changed       uint64         `json:"changed"`  // The time when this entry was last updated
+	policyLoaded  bool           // The node policy was fetched from the exchange, Policy is nil when the node has no policy
+}
+
+func (n IndexedNode) String() string {
+	return fmt.Sprintf("Id: %v, Pattern: %v, Arch: %v, NodeType: %v, HasKey: %v, LastHeartbeat: %v, Services: %v, HasPolicy: %v, Changed: %v",
+		n.Id, n.Pattern, n.Arch, n.NodeType, n.PublicKey != "", n.LastHeartbeat, n.Services, n.Policy != nil, n.Changed)
+}
+
+type NodeIndex struct {
+	lock  sync.RWMutex
+	nodes map[string]*IndexedNode
+}
+
+func NewNodeIndex() *NodeIndex {
+	return &NodeIndex{
+		nodes: make(map[string]*IndexedNode),
+	}
+}
+
+// Return the entry for the node, creating it if necessary. The caller must hold the write lock.
+func (ni *NodeIndex) getOrCreate(id string) *IndexedNode {
+	if n, ok := ni.nodes[id]; ok {
+		return n
+	}
+	n := &IndexedNode{Id: id, Org: exchange.GetOrg(id)}
+	ni.nodes[id] = n
+	return n
+}
+
+// Update the node attributes from the exchange node resource.
+func (ni *NodeIndex) UpdateNode(id string, dev *exchange.Device) {
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+
+	n := ni.getOrCreate(id)
+	n.setDevice(dev)
+	n.Changed = uint64(time.Now().Unix())
+
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("node index updated %v", n)))
+}
+
+// Set the node attributes from the exchange node resource. The node policy has to be fetched again when a pattern
+// node becomes a policy based node.
+func (n *IndexedNode) setDevice(dev *exchange.Device) {
+	if n.Pattern != dev.Pattern {
+		n.policyLoaded = false
+	}
+	n.Pattern = dev.Pattern
+	n.Arch = dev.Arch
+	n.NodeType = dev.NodeType
+	n.PublicKey = dev.PublicKey
+	n.LastHeartbeat = dev.LastHeartbeat
+	n.Services = make([]string, 0, len(dev.RegisteredServices))
+	for _, ms := range dev.RegisteredServices {
+		n.Services = append(n.Services, ms.Url)
+	}
+}
+
+// Return true if the node attributes that are used to find candidates differ from the exchange node resource.
+func (n *IndexedNode) differsFrom(dev *exchange.Device) bool {
+	if n.Pattern != dev.Pattern || n.Arch != dev.Arch || n.NodeType != dev.NodeType || n.PublicKey != dev.PublicKey || len(n.Services) != len(dev.RegisteredServices) {
+		return true
+	}
+	for ix, ms := range dev.RegisteredServices {
+		if n.Services[ix] != ms.Url {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconcile the nodes of an org with the node listing of the org from the exchange. Nodes that are not in the index
+// are added, nodes whose attributes differ are updated, and nodes that are no longer in the listing are removed. Only
+// the entries that are added or updated are marked as changed, the heartbeats of the others are refreshed. Return the
+// ids of the policy based nodes whose node policy is not in the index, ordered by id.
+func (ni *NodeIndex) ReconcileOrg(org string, devs map[string]exchange.Device) []string {
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+
+	now := uint64(time.Now().Unix())
+	added, updated, removed := 0, 0, 0
+	needPolicy := make([]string, 0, 10)
+	for id, dev := range devs {
+		if exchange.GetOrg(id) != org {
+			continue
+		}
+		n, ok := ni.nodes[id]
+		if !ok {
+			n = ni.getOrCreate(id)
+			added++
+		} else if n.differsFrom(&dev) {
+			updated++
+		} else {
+			if dev.LastHeartbeat != "" && (n.LastHeartbeat == "" || cutil.TimeInSeconds(dev.LastHeartbeat, cutil.ExchangeTimeFormat) >= cutil.TimeInSeconds(n.LastHeartbeat, cutil.ExchangeTimeFormat)) {
+				n.LastHeartbeat = dev.LastHeartbeat
+			}
+			if n.Pattern == "" && !n.policyLoaded {
+				needPolicy = append(needPolicy, id)
+			}
+			continue
+		}
+		n.setDevice(&dev)
+		n.Changed = now
+		if n.Pattern == "" && !n.policyLoaded {
+			needPolicy = append(needPolicy, id)
+		}
+	}
+
+	for id, n := range ni.nodes {
+		if _, ok := devs[id]; !ok && n.Org == org {
+			delete(ni.nodes, id)
+			removed++
+		}
+	}
+
+	glog.V(3).Infof(AWlogString(fmt.Sprintf("node index reconciled org %v, %v nodes added, %v updated, %v removed", org, added, updated, removed)))
+	sort.Strings(needPolicy)
+	return needPolicy
+}
+
+// Update the node policy. A nil policy means that the node no longer has a policy.
+func (ni *NodeIndex) UpdateNodePolicy(id string, pol *policy.Policy) {
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+
+	n := ni.getOrCreate(id)
+	n.Policy = pol
+	n.policyLoaded = true
+	n.Changed = uint64(time.Now().Unix())
+
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("node index updated policy for %v", id)))
+}
+
+// Update the last heartbeat of a node in the index. A heartbeat is not a change to the node, so the change time of the
+// entry is not updated. Return false when the node is not in the index.
+func (ni *NodeIndex) UpdateHeartbeat(id string, lastHeartbeat string) bool {
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+
+	if n, ok := ni.nodes[id]; !ok {
+		return false
+	} else if n.LastHeartbeat == "" || cutil.TimeInSeconds(lastHeartbeat, cutil.ExchangeTimeFormat) >= cutil.TimeInSeconds(n.LastHeartbeat, cutil.ExchangeTimeFormat) {
+		n.LastHeartbeat = lastHeartbeat
+	}
+	return true
+}
+
+// Remove the node from the index.
+func (ni *NodeIndex) RemoveNode(id string) {
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+	delete(ni.nodes, id)
+}
+
+// Return true if the node is in the index.
+func (ni *NodeIndex) HasNode(id string) bool {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+	_, ok := ni.nodes[id]
+	return ok
+}
+
+// Return true if the index has the node policy of the node, or knows that the node has no policy.
+func (ni *NodeIndex) HasNodePolicy(id string) bool {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+	n, ok := ni.nodes[id]
+	return ok && n.policyLoaded
+}
+
+// Return the number of nodes in the index.
+func (ni *NodeIndex) Len() int {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+	return len(ni.nodes)
+}
+
+// Return true if the node is in the index and is a candidate for an agreement with the input consumer policy.
+func (ni *NodeIndex) IsCandidate(id string, pol *policy.Policy) bool {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+
+	if n, ok := ni.nodes[id]; !ok {
+		return false
+	} else if pol.PatternId != "" {
+		return n.matchesPattern(pol)
+	} else {
+		return n.matchesPolicy(pol)
+	}
+}
+
+// Return true if the node is in the index and its node constraints are satisfied by the properties of the input
+// consumer policy. A node without a policy or without constraints accepts any consumer policy.
+func (ni *NodeIndex) AcceptsPolicy(id string, pol *policy.Policy) bool {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+
+	if n, ok := ni.nodes[id]; !ok {
+		return false
+	} else if n.Policy == nil {
+		return true
+	} else if err := (&n.Policy.Constraints).IsSatisfiedBy(pol.Properties); err != nil {
+		glog.V(5).Infof(AWlogString(fmt.Sprintf("properties of %v do not satisfy the constraints of node %v: %v", pol.Header.Name, id, err)))
+		return false
+	}
+	return true
+}
+
+// Find the nodes in the index that are candidates for an agreement with the input consumer policy. This is the
+// local equivalent of the exchange node search, so the result is a superset of the nodes that will actually make
+// an agreement; the agreement workers do the full compatibility check. Only nodes in the served node orgs, that
+// changed after changedSince, and that are not stale are returned. The results are ordered by node id, starting after
+// the input node id, and there are at most limit results when limit is not zero.
+func (ni *NodeIndex) FindNodes(pol *policy.Policy, nodeOrgs []string, changedSince uint64, after string, limit uint64, staleS int) []exchange.SearchResultDevice {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+
+	orgs := make(map[string]bool, len(nodeOrgs))
+	for _, org := range nodeOrgs {
+		orgs[org] = true
+	}
+
+	now := time.Now()
+	res := make([]exchange.SearchResultDevice, 0, 10)
+	for id, n := range ni.nodes {
+		if !orgs[n.Org] || n.Changed <= changedSince || (after != "" && id <= after) {
+			continue
+		} else if staleS > 0 && n.isStale(now, staleS) {
+			continue
+		} else if pol.PatternId != "" && !n.matchesPattern(pol) {
+			continue
+		} else if pol.PatternId == "" && !n.matchesPolicy(pol) {
+			continue
+		}
+		res = append(res, exchange.SearchResultDevice{Id: id, NodeType: n.NodeType, PublicKey: n.PublicKey})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	if limit != 0 && uint64(len(res)) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// A node is stale when it has not heartbeated for more than staleS seconds. Node heartbeats are not reported by the
+// exchange /changes API, the node search refreshes them from the exchange node health search.
+func (n *IndexedNode) isStale(now time.Time, staleS int) bool {
+	if n.LastHeartbeat == "" {
+		return true
+	} else if hb, err := time.Parse(cutil.ExchangeTimeFormat, n.LastHeartbeat); err != nil {
+		glog.Warningf(AWlogString(fmt.Sprintf("unable to parse heartbeat time %v of node %v, error: %v", n.LastHeartbeat, n.Id, err)))
+		return false
+	} else {
+		return now.Sub(hb) > time.Duration(staleS)*time.Second
+	}
+}
+
+// A pattern node is a candidate when it is registered with the pattern and registered the pattern's service.
+func (n *IndexedNode) matchesPattern(pol *policy.Policy) bool {
+	if n.Pattern != pol.PatternId {
+		return false
+	} else if len(pol.Workloads) == 0 {
+		return true
+	}
+	svcUrl := cutil.FormOrgSpecUrl(pol.Workloads[0].WorkloadURL, pol.Workloads[0].Org)
+	for _, s := range n.Services {
+		if s == svcUrl {
+			return true
+		}
+	}
+	return false
+}
+
+// A policy node is a candidate when it has a policy, it can run one of the service architectures and its properties
+// satisfy the constraints of the deployment policy. The node constraints are not checked here because the service
+// policy contributes to the properties they are checked against.
+func (n *IndexedNode) matchesPolicy(pol *policy.Policy) bool {
+	if n.Pattern != "" || n.Policy == nil {
+		return false
+	} else if !n.matchesArch(pol) {
+		return false
+	} else if err := (&pol.Constraints).IsSatisfiedBy(n.Policy.Properties); err != nil {
+		glog.V(5).Infof(AWlogString(fmt.Sprintf("node %v does not satisfy the constraints of %v: %v", n.Id, pol.Header.Name, err)))
+		return false
+	}
+	return true
+}
+
+func (n *IndexedNode) matchesArch(pol *policy.Policy) bool {
+	if len(pol.Workloads) == 0 || n.Arch == "" {
+		return true
+	}
+	for _, wl := range pol.Workloads {
+		if wl.Arch == "" || wl.Arch == "*" || wl.Arch == n.Arch {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	_ "github.com/open-horizon/anax/externalpolicy/text_language"
+	"github.com/open-horizon/anax/policy"
+	"testing"
+	"time"
+)
+
+func indexedDevice(pattern string, arch string, services ...string) *exchange.Device {
+	dev := &exchange.Device{
+		Pattern:       pattern,
+		Arch:          arch,
+		PublicKey:     "key",
+		LastHeartbeat: time.Now().UTC().Format(cutil.ExchangeTimeFormat),
+	}
+	for _, s := range services {
+		dev.RegisteredServices = append(dev.RegisteredServices, exchange.Microservice{Url: s})
+	}
+	return dev
+}
+
+func Test_node_index_find_nodes(t *testing.T) {
+
+	ni := NewNodeIndex()
+
+	// Pattern nodes.
+	ni.UpdateNode("org1/p1", indexedDevice("org1/pat1", "amd64", "org1/svc1"))
+	ni.UpdateNode("org1/p2", indexedDevice("org1/pat1", "amd64", "org1/svc2"))
+	ni.UpdateNode("org2/p3", indexedDevice("org1/pat1", "amd64", "org1/svc1"))
+
+	// Policy nodes.
+	np := policy.Policy_Factory("node")
+	np.Add_Property(externalpolicy.Property_Factory("color", "red"), false)
+	ni.UpdateNode("org1/n1", indexedDevice("", "amd64"))
+	ni.UpdateNodePolicy("org1/n1", np)
+	ni.UpdateNode("org1/n2", indexedDevice("", "arm64"))
+	ni.UpdateNodePolicy("org1/n2", np)
+	ni.UpdateNode("org1/n3", indexedDevice("", "amd64"))
+	ni.UpdateNodePolicy("org1/n3", policy.Policy_Factory("node"))
+	ni.UpdateNode("org1/n4", indexedDevice("", "amd64"))
+
+	if ni.Len() != 7 {
+		t.Errorf("expected 7 nodes in the index, got %v", ni.Len())
+	}
+
+	// Only the node registered with the pattern and its service, in a served org.
+	patPol := policy.Policy_Factory("org1/pat1_svc1")
+	patPol.PatternId = "org1/pat1"
+	patPol.Add_Workload(policy.Workload_Factory("svc1", "org1", "1.0.0", "amd64"))
+	if devs := ni.FindNodes(patPol, []string{"org1"}, 0, "", 0, 0); len(devs) != 1 || devs[0].Id != "org1/p1" {
+		t.Errorf("wrong pattern nodes %v", devs)
+	}
+
+	// Only the nodes with a policy that satisfies the constraints and the right architecture.
+	depPol := policy.Policy_Factory("org1/bp1")
+	depPol.Add_Workload(policy.Workload_Factory("svc1", "org1", "1.0.0", "amd64"))
+	if err := depPol.Add_Constraints(&externalpolicy.ConstraintExpression{"color == red"}); err != nil {
+		t.Fatalf("unable to add constraints, error %v", err)
+	}
+	if devs := ni.FindNodes(depPol, []string{"org1"}, 0, "", 0, 0); len(devs) != 1 || devs[0].Id != "org1/n1" {
+		t.Errorf("wrong policy nodes %v", devs)
+	}
+
+	// Any architecture, in batches.
+	depPol.Workloads[0].Arch = "*"
+	if devs := ni.FindNodes(depPol, []string{"org1"}, 0, "", 1, 0); len(devs) != 1 || devs[0].Id != "org1/n1" {
+		t.Errorf("wrong first batch %v", devs)
+	} else if devs := ni.FindNodes(depPol, []string{"org1"}, 0, devs[0].Id, 1, 0); len(devs) != 1 || devs[0].Id != "org1/n2" {
+		t.Errorf("wrong second batch %v", devs)
+	}
+
+	// Nothing changed since now.
+	if devs := ni.FindNodes(depPol, []string{"org1"}, uint64(time.Now().Unix()), "", 0, 0); len(devs) != 0 {
+		t.Errorf("expected no changed nodes, got %v", devs)
+	}
+
+	// Stale nodes are not returned.
+	stale := indexedDevice("", "amd64")
+	stale.LastHeartbeat = time.Now().Add(-time.Hour).UTC().Format(cutil.ExchangeTimeFormat)
+	ni.UpdateNode("org1/n2", stale)
+	if devs := ni.FindNodes(depPol, []string{"org1"}, 0, "", 0, 600); len(devs) != 1 || devs[0].Id != "org1/n1" {
+		t.Errorf("expected the stale node to be skipped, got %v", devs)
+	}
+
+	// A refreshed heartbeat makes the node active again, an older heartbeat does not make it stale.
+	now := time.Now().UTC().Format(cutil.ExchangeTimeFormat)
+	if !ni.UpdateHeartbeat("org1/n2", now) {
+		t.Errorf("expected org1/n2 to be in the index")
+	} else if ni.UpdateHeartbeat("org1/n9", now) {
+		t.Errorf("org1/n9 is not in the index")
+	}
+	ni.UpdateHeartbeat("org1/n2", stale.LastHeartbeat)
+	if devs := ni.FindNodes(depPol, []string{"org1"}, 0, "", 0, 600); len(devs) != 2 {
+		t.Errorf("expected the refreshed node to be returned, got %v", devs)
+	} else if devs := ni.FindNodes(depPol, []string{"org1"}, uint64(time.Now().Unix()), "", 0, 600); len(devs) != 0 {
+		t.Errorf("a heartbeat is not a node change, got %v", devs)
+	}
+
+	// Removed nodes and policies.
+	ni.RemoveNode("org1/n1")
+	ni.UpdateNodePolicy("org1/n2", nil)
+	if devs := ni.FindNodes(depPol, []string{"org1"}, 0, "", 0, 0); len(devs) != 0 {
+		t.Errorf("expected no nodes, got %v", devs)
+	} else if ni.HasNode("org1/n1") {
+		t.Errorf("node org1/n1 should have been removed")
+	}
+
+}
+
+func Test_node_index_reconcile(t *testing.T) {
+
+	ni := NewNodeIndex()
+	ni.UpdateNode("org1/p1", indexedDevice("org1/pat1", "amd64", "org1/svc1"))
+	ni.UpdateNode("org1/n1", indexedDevice("", "amd64"))
+	ni.UpdateNodePolicy("org1/n1", policy.Policy_Factory("node"))
+	ni.UpdateNode("org1/gone", indexedDevice("", "amd64"))
+	ni.UpdateNodePolicy("org1/gone", nil)
+	ni.UpdateNode("org2/n1", indexedDevice("", "amd64"))
+
+	// The org listing has a new policy node, a changed pattern node, an unchanged policy node and misses a node.
+	changedSince := uint64(time.Now().Unix())
+	time.Sleep(1100 * time.Millisecond)
+	devs := map[string]exchange.Device{
+		"org1/p1":  *indexedDevice("org1/pat1", "amd64", "org1/svc2"),
+		"org1/n1":  *indexedDevice("", "amd64"),
+		"org1/new": *indexedDevice("", "arm64"),
+	}
+	if needPolicy := ni.ReconcileOrg("org1", devs); len(needPolicy) != 1 || needPolicy[0] != "org1/new" {
+		t.Errorf("only the new policy node needs its policy, got %v", needPolicy)
+	}
+
+	patPol := policy.Policy_Factory("org1/pat1_svc2")
+	patPol.PatternId = "org1/pat1"
+	patPol.Add_Workload(policy.Workload_Factory("svc2", "org1", "1.0.0", "amd64"))
+	if ni.HasNode("org1/gone") {
+		t.Errorf("the node that is not in the listing should be removed")
+	} else if !ni.HasNode("org2/n1") {
+		t.Errorf("the nodes of the other orgs should be kept")
+	} else if !ni.IsCandidate("org1/p1", patPol) {
+		t.Errorf("the changed node should be updated")
+	} else if devs := ni.FindNodes(patPol, []string{"org1"}, changedSince, "", 0, 0); len(devs) != 1 {
+		t.Errorf("the changed node should be marked as changed, got %v", devs)
+	} else if !ni.HasNodePolicy("org1/n1") || ni.HasNodePolicy("org1/new") {
+		t.Errorf("the policy of the unchanged node should be kept")
+	}
+
+	depPol := policy.Policy_Factory("org1/bp1")
+	depPol.Add_Workload(policy.Workload_Factory("svc1", "org1", "1.0.0", "*"))
+	if devs := ni.FindNodes(depPol, []string{"org1"}, changedSince, "", 0, 0); len(devs) != 0 {
+		t.Errorf("the unchanged node should not be marked as changed, got %v", devs)
+	}
+
+	// A pattern node that becomes a policy based node needs its policy.
+	devs["org1/p1"] = *indexedDevice("", "amd64")
+	if needPolicy := ni.ReconcileOrg("org1", devs); len(needPolicy) != 2 || needPolicy[0] != "org1/new" || needPolicy[1] != "org1/p1" {
+		t.Errorf("the new policy nodes need their policy, got %v", needPolicy)
+	}
+
+}
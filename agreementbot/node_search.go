@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/compcheck"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
@@ -19,6 +20,10 @@ import (
 // policy based searches, the exchange search API returns pages of the entire result set, because the result set can be
 // quite large. This object maintains the search session information that tells the exchange how far the agbot's have
 // progressed through the pages of the result set. The session state is persisted in the agbot's DB.
+//
+// When the node index is enabled, the node search evaluates the policies against the node index instead. The index is
+// seeded and reconciled with the node listings of the served node orgs on the periodic full rescan, which also runs
+// the exchange search as a consistency check of the index.
 type NodeSearch struct {
 	db                   persistence.AgbotDatabase
 	pm                   *policy.PolicyManager
@@ -30,13 +35,24 @@ type NodeSearch struct {
 	lastSearchComplete   bool
 	lastSearchTime       uint64
 	searchThread         chan bool
-	rescanLock           sync.Mutex // The lock that protects the rescanNeeded flag. The rescanNeeded flag can be checked/changed on different threads.
-	rescanNeeded         bool       // A broad indicator that something policy or pattern related changed, and therefore the agbot needs to rescan all nodes.
-	batchSize            uint64     // The max number of nodes that this object will process in a deployment policy search result.
-	activeDeviceTimeoutS int        // The amount of time a device can go without heartbeating and still be considered active for the purposes of search.
-	retryLookBack        uint64     // The amount of time to look backward for node changes when node retries are happening.
-	policyOrder          bool       // When true, order policies most recently changed to least recently changed.
-	clearExchangeCache   bool       // When true, the exchange cache will be deleted after a seach is made with devices returned.
+	rescanLock           sync.Mutex               // The lock that protects the rescanNeeded flag. The rescanNeeded flag can be checked/changed on different threads.
+	rescanNeeded         bool                     // A broad indicator that something policy or pattern related changed, and therefore the agbot needs to rescan all nodes.
+	batchSize            uint64                   // The max number of nodes that this object will process in a deployment policy search result.
+	activeDeviceTimeoutS int                      // The amount of time a device can go without heartbeating and still be considered active for the purposes of search.
+	retryLookBack        uint64                   // The amount of time to look backward for node changes when node retries are happening.
+	policyOrder          bool                     // When true, order policies most recently changed to least recently changed.
+	clearExchangeCache   bool                     // When true, the exchange cache will be deleted after a seach is made with devices returned.
+	index                *NodeIndex               // The local node index, nil when the node index is not enabled.
+	indexLock            sync.Mutex               // The lock that protects the index evaluation state and the consistency check flag.
+	indexEvalTime        map[string]uint64        // The time when each policy was last evaluated against the whole index.
+	indexProgress        map[string]indexProgress // The progress of policy evaluations that did not complete in one pass.
+	consistencyCheck     bool                     // When true, an exchange search is in progress to check the consistency of the index.
+	heartbeatRefresh     uint64                   // The time when the node heartbeats in the index were last refreshed.
+	pauseLock            sync.Mutex               // The lock that protects the paused and idle flags.
+	paused               bool                     // When true, no new scans are started, used while agreements are handed off to another agbot.
+	idle                 bool                     // When true, the node search is paused and no scan is in progress.
+	businessPolManager   *BusinessPolicyManager   // The deployment policies of the exchange being searched.
+	patternManager       *PatternManager          // The patterns of the exchange being searched.
 }
 
 func NewNodeSearch() *NodeSearch {
@@ -53,11 +69,13 @@ func NewNodeSearch() *NodeSearch {
 }
 
 // Give the object a chance to initialize itself.
-func (n *NodeSearch) Init(db persistence.AgbotDatabase, pm *policy.PolicyManager, ph *ConsumerPHMgr, msgs chan events.Message, ec exchange.ExchangeContext, cfg *config.HorizonConfig) {
+func (n *NodeSearch) Init(db persistence.AgbotDatabase, pm *policy.PolicyManager, ph *ConsumerPHMgr, bpm *BusinessPolicyManager, patm *PatternManager, msgs chan events.Message, ec exchange.ExchangeContext, cfg *config.HorizonConfig) {
 
 	n.db = db
 	n.pm = pm
 	n.ph = ph
+	n.businessPolManager = bpm
+	n.patternManager = patm
 	n.msgs = msgs
 	n.ec = ec
 	n.nextScanIntervalS = cfg.AgreementBot.NewContractIntervalS
@@ -67,6 +85,18 @@ func (n *NodeSearch) Init(db persistence.AgbotDatabase, pm *policy.PolicyManager
 	n.retryLookBack = cfg.GetAgbotRetryLookBackWindow()
 	n.policyOrder = cfg.GetAgbotPolicyOrder()
 
+	if cfg.IsAgbotNodeIndexEnabled() {
+		n.index = NewNodeIndex()
+		if cfg.GetAgbotExchangeName() == "" {
+			nodeIndex = n.index
+		}
+		n.indexEvalTime = make(map[string]uint64)
+		n.indexProgress = make(map[string]indexProgress)
+		// The index starts out empty, so the first scan is a consistency check that seeds it.
+		n.consistencyCheck = true
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("node index enabled, consistency check interval is %v seconds", n.fullRescanIntervalS)))
+	}
+
 	// Set the time of the worker restart to 1 minute ago. This time is used to indicate that the node searches need to go backward in time
 	// because this agbot just restarted, and therefore could have lost search results that were in memory but the database was
 	// already updated with a new changedSince.
@@ -101,6 +131,32 @@ func (n *NodeSearch) UnsetRescanNeeded() {
 	n.rescanNeeded = false
 }
 
+// Stop or resume starting new scans. This function is thread safe.
+func (n *NodeSearch) SetPaused(paused bool) {
+	n.pauseLock.Lock()
+	defer n.pauseLock.Unlock()
+	n.paused = paused
+	if !paused {
+		n.idle = false
+	}
+}
+
+// Returns true when the node search is paused and the scan that was in progress when it paused has completed. This
+// function is thread safe.
+func (n *NodeSearch) IsIdle() bool {
+	n.pauseLock.Lock()
+	defer n.pauseLock.Unlock()
+	return n.paused && n.idle
+}
+
+// Returns true when the node search is paused, and records whether it is idle.
+func (n *NodeSearch) isPaused(searchComplete bool) bool {
+	n.pauseLock.Lock()
+	defer n.pauseLock.Unlock()
+	n.idle = n.paused && searchComplete
+	return n.paused
+}
+
 // Check if a node rescan is needed. This function is thread safe.
 func (n *NodeSearch) IsRescanNeeded() bool {
 	n.rescanLock.Lock()
@@ -123,6 +179,15 @@ func (n *NodeSearch) Scan() {
 		}
 	}
 
+	// New agreements that the work queues dropped are retried, the same way as agreements that failed.
+	n.retryDroppedWork()
+
+	// While paused, remember whether a scan is still in progress. Scans that become needed while paused are started when
+	// the node search resumes.
+	if n.isPaused(n.lastSearchComplete) {
+		return
+	}
+
 	// Now check to see if a new scan is needed. This function will periodically scan all nodes, to ensure that missed change events are eventually acted on.
 	// If there is no rescan needed but it's been a while since the last full scan, then do a full scan anyway.
 	// A full rescan uses its own changedSince time so that the full rescans overlap each other.
@@ -130,6 +195,7 @@ func (n *NodeSearch) Scan() {
 		n.lastSearchTime = uint64(time.Now().Unix())
 		glog.V(3).Infof(AWlogString("Polling Exchange (full rescan)"))
 		n.lastSearchComplete = false
+		n.setConsistencyCheck(n.index != nil)
 		go n.findAndMakeAgreements()
 	}
 
@@ -160,6 +226,19 @@ func (n *NodeSearch) findAndMakeAgreements() {
 	// function will clear the cache and set it false after it finds devices to make agreements.
 	n.clearExchangeCache = true
 
+	// Use the node index unless the index is being checked against the exchange.
+	useIndex := n.index != nil && !n.isConsistencyCheck()
+	searchStart := uint64(time.Now().Unix()) - 1
+	if n.index != nil && !useIndex {
+		// Reconcile the index with the node listings before the exchange search. The heartbeats come with the listings.
+		// The consistency check is not complete until all the listings are reconciled.
+		if !n.reconcileIndex() {
+			n.SetRescanNeeded()
+		}
+	} else if n.index != nil {
+		n.refreshIndexHeartbeats(false)
+	}
+
 	// Get a list of all the orgs this agbot is serving.
 	allOrgs := n.pm.GetAllPolicyOrgs()
 	for _, org := range allOrgs {
@@ -173,15 +252,28 @@ func (n *NodeSearch) findAndMakeAgreements() {
 		for _, consumerPolicy := range availablePolicies {
 
 			// Search for nodes based on the current changedSince timestamp to pick up any newly changed nodes.
-			if consumerPolicy.PatternId != "" {
+			if useIndex {
+				if !n.evaluateIndexAndMakeAgreements(&consumerPolicy, org, searchStart) {
+					// There are more nodes to process, let the system work on them first.
+					n.SetRescanNeeded()
+					break
+				}
+			} else if consumerPolicy.PatternId != "" {
 				if _, err := n.searchNodesAndMakeAgreements(&consumerPolicy, org, "", 0); err != nil {
 					// Dont move the changed since time forward since there was an error.
 					searchError = true
 					break
 				}
-			} else if pBE := businessPolManager.GetBusinessPolicyEntry(org, &consumerPolicy); pBE != nil {
+			} else if pBE := n.businessPolManager.GetBusinessPolicyEntry(org, &consumerPolicy); pBE != nil {
 				_, polName := cutil.SplitOrgSpecUrl(consumerPolicy.Header.Name)
-				if lastPage, err := n.searchNodesAndMakeAgreements(&consumerPolicy, org, polName, pBE.Updated); err != nil {
+
+				// A consistency check of the node index searches all the nodes, not just the recently changed nodes.
+				polLastUpdateTime := pBE.Updated
+				if n.index != nil {
+					polLastUpdateTime = searchStart + 1
+				}
+
+				if lastPage, err := n.searchNodesAndMakeAgreements(&consumerPolicy, org, polName, polLastUpdateTime); err != nil {
 					// Dont move the changed since time forward since there was an error.
 					searchError = true
 					break
@@ -204,6 +296,13 @@ func (n *NodeSearch) findAndMakeAgreements() {
 		n.SetRescanNeeded()
 	}
 
+	// The consistency check is complete when the exchange search returned all the nodes without errors. The index is not
+	// seeded until the agbot has policies to search with.
+	if !useIndex && n.index != nil && !n.IsRescanNeeded() && len(allOrgs) != 0 {
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("node index consistency check complete, %v nodes in the index", n.index.Len())))
+		n.setConsistencyCheck(false)
+	}
+
 	// Dump search tables to the log.
 	if err := n.db.DumpSearchSessions(); err != nil {
 		glog.Errorf(AWlogString(fmt.Sprintf("unable to dump search session records, error: %v", err)))
@@ -219,7 +318,7 @@ func (n *NodeSearch) findAndMakeAgreements() {
 func (n *NodeSearch) getOrderedPolicies(org string) []policy.Policy {
 
 	// First, get the deployment policies ordered as configured.
-	res := businessPolManager.GetAllPoliciesOrderedForOrg(org, n.policyOrder)
+	res := n.businessPolManager.GetAllPoliciesOrderedForOrg(org, n.policyOrder)
 
 	// Second, append the pattern based policies to the end of the list.
 	for _, oldPol := range n.pm.GetAllAvailablePolicies(org) {
@@ -249,89 +348,106 @@ func (n *NodeSearch) searchNodesAndMakeAgreements(consumerPolicy *policy.Policy,
 			endOfResults = false
 		}
 
-		// Get all the agreements for this policy that are still active.
-		pendingAgreementFilter := func() persistence.AFilter {
-			return func(a persistence.Agreement) bool {
-				return a.PolicyName == consumerPolicy.Header.Name && a.AgreementTimedout == 0
+		// The exchange search found nodes that the node index does not know about, or that the index does not consider
+		// as candidates for the policy. Those entries are missing or out of date, refresh them.
+		if n.index != nil {
+			for _, dev := range *devices {
+				if !n.index.IsCandidate(dev.Id, consumerPolicy) {
+					glog.V(3).Infof(AWlogString(fmt.Sprintf("node index consistency check refreshing node %v for %v", dev.Id, consumerPolicy.Header.Name)))
+					n.refreshIndexedNode(dev.Id)
+				}
 			}
 		}
 
-		ags := make(map[string][]persistence.Agreement)
+		n.makeAgreements(consumerPolicy, org, polName, devices)
+	}
 
-		// The agreements with this policy could be part of any supported agreement protocol.
-		for _, agp := range policy.AllAgreementProtocols() {
-			// Find all agreements that are in progress. They might be waiting for a reply or not yet finalized.
-			// TODO: To support more than 1 agreement (maxagreements > 1) with this device for this policy, we need to adjust this logic.
-			if agreements, err := n.db.FindAgreements([]persistence.AFilter{persistence.UnarchivedAFilter(), pendingAgreementFilter()}, agp); err != nil {
-				glog.Errorf(AWlogString(fmt.Sprintf("received error trying to find pending agreements for protocol %v: %v", agp, err)))
-			} else {
-				ags[agp] = agreements
-			}
-		}
+	return endOfResults, nil
+
+}
+
+// Make agreements with the input nodes, skipping nodes that already have an agreement in progress with the consumer policy.
+func (n *NodeSearch) makeAgreements(consumerPolicy *policy.Policy, org string, polName string, devices *[]exchange.SearchResultDevice) {
 
-		// For each Scan(), clear the cache only once when there are devices returned from the search api.
-		if n.clearExchangeCache && len(*devices) != 0 {
-			glog.V(5).Infof("Clearing cache for all resources.")
-			exchange.ClearAllResourceCache()
-			n.clearExchangeCache = false
+	// Get all the agreements for this policy that are still active.
+	pendingAgreementFilter := func() persistence.AFilter {
+		return func(a persistence.Agreement) bool {
+			return a.PolicyName == consumerPolicy.Header.Name && a.AgreementTimedout == 0
 		}
+	}
 
-		for _, dev := range *devices {
+	ags := make(map[string][]persistence.Agreement)
 
-			glog.V(3).Infof(AWlogString(fmt.Sprintf("picked up %v for policy %v.", dev.ShortString(), consumerPolicy.Header.Name)))
-			glog.V(5).Infof(AWlogString(fmt.Sprintf("picked up %v", dev)))
+	// The agreements with this policy could be part of any supported agreement protocol.
+	for _, agp := range policy.AllAgreementProtocols() {
+		// Find all agreements that are in progress. They might be waiting for a reply or not yet finalized.
+		// TODO: To support more than 1 agreement (maxagreements > 1) with this device for this policy, we need to adjust this logic.
+		if agreements, err := n.db.FindAgreements([]persistence.AFilter{persistence.UnarchivedAFilter(), pendingAgreementFilter()}, agp); err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("received error trying to find pending agreements for protocol %v: %v", agp, err)))
+		} else {
+			ags[agp] = agreements
+		}
+	}
 
-			// Check for agreements already in progress with this device
-			if found := n.alreadyMakingAgreementWith(&dev, consumerPolicy, ags); found {
-				glog.V(5).Infof(AWlogString(fmt.Sprintf("skipping device id %v, agreement attempt already in progress with %v", dev.Id, consumerPolicy.Header.Name)))
-				continue
-			}
+	// For each Scan(), clear the cache only once when there are devices returned from the search api.
+	if n.clearExchangeCache && len(*devices) != 0 {
+		glog.V(5).Infof("Clearing cache for all resources.")
+		exchange.ClearAllResourceCache()
+		n.clearExchangeCache = false
+	}
 
-			// If the device is not ready to make agreements yet, then skip it.
-			if dev.PublicKey == "" {
-				glog.V(5).Infof(AWlogString(fmt.Sprintf("skipping device id %v, node is not ready to exchange messages", dev.Id)))
-				continue
-			}
+	for _, dev := range *devices {
 
-			producerPolicy := policy.Policy_Factory(consumerPolicy.Header.Name)
-
-			// Get the cached service policies from the business policy manager. The returned value
-			// is a map keyed by the service id.
-			// There could be many service versions defined in a business policy.
-			// The policy manager only caches the ones that are used by an old agreement for this business policy.
-			// The cached ones may not be what the new agreement will use. If the new agreement chooses a
-			// new service version, then the new service policy will be put into the cache.
-			svcPolicies := make(map[string]externalpolicy.ExternalPolicy, 0)
-			if consumerPolicy.PatternId == "" {
-				svcPolicies = businessPolManager.GetServicePoliciesForPolicy(org, polName)
-			}
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("picked up %v for policy %v.", dev.ShortString(), consumerPolicy.Header.Name)))
+		glog.V(5).Infof(AWlogString(fmt.Sprintf("picked up %v", dev)))
 
-			// Select a worker pool based on the agreement protocol that will be used. This is decided by the
-			// consumer policy.
-			protocol := policy.Select_Protocol(producerPolicy, consumerPolicy)
-			cmd := NewMakeAgreementCommand(*producerPolicy, *consumerPolicy, org, polName, dev, svcPolicies)
+		// Check for agreements already in progress with this device
+		if found := n.alreadyMakingAgreementWith(&dev, consumerPolicy, ags); found {
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("skipping device id %v, agreement attempt already in progress with %v", dev.Id, consumerPolicy.Header.Name)))
+			continue
+		}
 
-			bcType, bcName, bcOrg := producerPolicy.RequiresKnownBC(protocol)
+		// If the device is not ready to make agreements yet, then skip it.
+		if dev.PublicKey == "" {
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("skipping device id %v, node is not ready to exchange messages", dev.Id)))
+			continue
+		}
 
-			if !n.ph.Has(protocol) {
-				glog.Errorf(AWlogString(fmt.Sprintf("unable to find protocol handler for %v.", protocol)))
-			} else if bcType != "" && !n.ph.Get(protocol).IsBlockchainWritable(bcType, bcName, bcOrg) {
-				// Get that blockchain running if it isn't up.
-				glog.V(5).Infof(AWlogString(fmt.Sprintf("skipping device id %v, requires blockchain %v %v %v that isnt ready yet.", dev.Id, bcType, bcName, bcOrg)))
-				n.msgs <- events.NewNewBCContainerMessage(events.NEW_BC_CLIENT, bcType, bcName, bcOrg, n.ec.GetExchangeURL(), n.ec.GetExchangeId(), n.ec.GetExchangeToken())
-				continue
-			} else if !n.ph.Get(protocol).AcceptCommand(cmd) {
-				glog.Errorf(AWlogString(fmt.Sprintf("protocol handler for %v not accepting new agreement commands.", protocol)))
-			} else {
-				n.ph.Get(protocol).HandleMakeAgreement(cmd, n.ph.Get(protocol))
-				glog.V(5).Infof(AWlogString(fmt.Sprintf("queued agreement attempt for policy %v and node %v using protocol %v", consumerPolicy.Header.Name, dev.Id, protocol)))
-			}
+		producerPolicy := policy.Policy_Factory(consumerPolicy.Header.Name)
+
+		// Get the cached service policies from the business policy manager. The returned value
+		// is a map keyed by the service id.
+		// There could be many service versions defined in a business policy.
+		// The policy manager only caches the ones that are used by an old agreement for this business policy.
+		// The cached ones may not be what the new agreement will use. If the new agreement chooses a
+		// new service version, then the new service policy will be put into the cache.
+		svcPolicies := make(map[string]externalpolicy.ExternalPolicy, 0)
+		if consumerPolicy.PatternId == "" {
+			svcPolicies = n.businessPolManager.GetServicePoliciesForPolicy(org, polName)
 		}
 
+		// Select a worker pool based on the agreement protocol that will be used. This is decided by the
+		// consumer policy.
+		protocol := policy.Select_Protocol(producerPolicy, consumerPolicy)
+		cmd := NewMakeAgreementCommand(*producerPolicy, *consumerPolicy, org, polName, dev, svcPolicies)
+
+		bcType, bcName, bcOrg := producerPolicy.RequiresKnownBC(protocol)
+
+		if !n.ph.Has(protocol) {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to find protocol handler for %v.", protocol)))
+		} else if bcType != "" && !n.ph.Get(protocol).IsBlockchainWritable(bcType, bcName, bcOrg) {
+			// Get that blockchain running if it isn't up.
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("skipping device id %v, requires blockchain %v %v %v that isnt ready yet.", dev.Id, bcType, bcName, bcOrg)))
+			n.msgs <- events.NewNewBCContainerMessage(events.NEW_BC_CLIENT, bcType, bcName, bcOrg, n.ec.GetExchangeURL(), n.ec.GetExchangeId(), n.ec.GetExchangeToken())
+			continue
+		} else if !n.ph.Get(protocol).AcceptCommand(cmd) {
+			glog.Errorf(AWlogString(fmt.Sprintf("protocol handler for %v not accepting new agreement commands.", protocol)))
+		} else {
+			n.ph.Get(protocol).HandleMakeAgreement(cmd, n.ph.Get(protocol))
+			glog.V(5).Infof(AWlogString(fmt.Sprintf("queued agreement attempt for policy %v and node %v using protocol %v", consumerPolicy.Header.Name, dev.Id, protocol)))
+		}
 	}
 
-	return endOfResults, nil
-
 }
 
 // Check all agreement protocol buckets to see if there are any agreements with this device.
@@ -347,7 +463,10 @@ func (n *NodeSearch) alreadyMakingAgreementWith(dev *exchange.SearchResultDevice
 				if ag.AgreementFinalizedTime != 0 {
 					glog.V(5).Infof(AWlogString(fmt.Sprintf("sending agreement verify for %v", ag.CurrentAgreementId)))
 					n.ph.Get(ag.AgreementProtocol).VerifyAgreement(&ag, n.ph.Get(ag.AgreementProtocol))
-					n.AddRetry(consumerPolicy.Header.Name, ag.AgreementFinalizedTime-n.retryLookBack)
+					// The node index hands the node back when it changes, so there is no need to look back in time for it.
+					if n.index == nil {
+						n.AddRetry(consumerPolicy.Header.Name, ag.AgreementFinalizedTime-n.retryLookBack)
+					}
 				}
 				return true
 			}
@@ -370,7 +489,7 @@ func (n *NodeSearch) searchExchange(pol *policy.Policy, polOrg string, polName s
 	// If it is a pattern based policy, search by workload URL and pattern.
 	if pol.PatternId != "" {
 		// Get a list of node orgs that the agbot is serving for this pattern.
-		nodeOrgs := patternManager.GetServedNodeOrgs(polOrg, exchange.GetId(pol.PatternId))
+		nodeOrgs := n.patternManager.GetServedNodeOrgs(polOrg, exchange.GetId(pol.PatternId))
 		if len(nodeOrgs) == 0 {
 			glog.V(3).Infof(AWlogString(fmt.Sprintf("Policy file for pattern %v exists but currently the agbot is not serving this policy for any organizations.", pol.PatternId)))
 			empty := make([]exchange.SearchResultDevice, 0, 0)
@@ -416,7 +535,7 @@ func (n *NodeSearch) searchExchange(pol *policy.Policy, polOrg string, polName s
 		}
 
 		// Get a list of node orgs that the agbot is serving for this business policy.
-		nodeOrgs := businessPolManager.GetServedNodeOrgs(polOrg, polName)
+		nodeOrgs := n.businessPolManager.GetServedNodeOrgs(polOrg, polName)
 		if len(nodeOrgs) == 0 {
 			glog.V(3).Infof(AWlogString(fmt.Sprintf("Business policy %v exists but currently the agbot is not serving this policy for any organizations.", pol.Header.Name)))
 			empty := make([]exchange.SearchResultDevice, 0, 0)
@@ -492,9 +611,290 @@ func (n *NodeSearch) searchExchange(pol *policy.Policy, polOrg string, polName s
 	}
 }
 
+// Retry the policies whose new agreements were dropped by a work queue because their org had too many agreements waiting.
+func (n *NodeSearch) retryDroppedWork() {
+	for _, protocol := range n.ph.GetAll() {
+		for polName, dropped := range n.ph.Get(protocol).WorkQueue().TakeDroppedPolicies() {
+			glog.V(3).Infof(AWlogString(fmt.Sprintf("retrying the nodes of %v, new agreements were dropped from the work queue", polName)))
+			n.AddRetry(polName, dropped-n.retryLookBack)
+		}
+	}
+}
+
 func (n *NodeSearch) AddRetry(policyName string, changedSince uint64) {
 	n.SetRescanNeeded()
+	if n.index != nil {
+		n.indexLock.Lock()
+		if evalTime, ok := n.indexEvalTime[policyName]; ok && changedSince < evalTime {
+			n.indexEvalTime[policyName] = changedSince
+		}
+		n.indexLock.Unlock()
+	}
 	if err := n.db.ResetPolicyChangedSince(policyName, changedSince); err != nil {
 		glog.Errorf(AWlogString(fmt.Sprintf("unable to update %v search session changed since, error: %v", policyName, err)))
 	}
 }
+
+// The progress of a policy evaluation against the node index that returned more nodes than the batch size.
+type indexProgress struct {
+	after string // The last node returned for the policy.
+	start uint64 // The time when the evaluation started.
+}
+
+func (n *NodeSearch) setConsistencyCheck(check bool) {
+	n.indexLock.Lock()
+	defer n.indexLock.Unlock()
+	n.consistencyCheck = check
+}
+
+func (n *NodeSearch) isConsistencyCheck() bool {
+	n.indexLock.Lock()
+	defer n.indexLock.Unlock()
+	return n.consistencyCheck
+}
+
+// Evaluate a policy against the node index and make agreements with the nodes that changed since the policy was last
+// evaluated. All indexed nodes are evaluated when a deployment policy has changed since then. Like the exchange search,
+// at most batchSize nodes are processed at a time. Return false when there are more nodes to be processed.
+func (n *NodeSearch) evaluateIndexAndMakeAgreements(consumerPolicy *policy.Policy, org string, searchStart uint64) bool {
+
+	// Get a list of node orgs that the agbot is serving for this policy.
+	polName := ""
+	polLastUpdateTime := uint64(0)
+	var nodeOrgs []string
+	if consumerPolicy.PatternId != "" {
+		nodeOrgs = n.patternManager.GetServedNodeOrgs(org, exchange.GetId(consumerPolicy.PatternId))
+	} else if pBE := n.businessPolManager.GetBusinessPolicyEntry(org, consumerPolicy); pBE != nil {
+		_, polName = cutil.SplitOrgSpecUrl(consumerPolicy.Header.Name)
+		polLastUpdateTime = pBE.Updated
+		nodeOrgs = n.businessPolManager.GetServedNodeOrgs(org, polName)
+	}
+
+	if len(nodeOrgs) == 0 {
+		glog.V(3).Infof(AWlogString(fmt.Sprintf("Policy %v exists but currently the agbot is not serving this policy for any organizations.", consumerPolicy.Header.Name)))
+		return true
+	}
+
+	n.indexLock.Lock()
+	changedSince := n.indexEvalTime[consumerPolicy.Header.Name]
+	progress, inProgress := n.indexProgress[consumerPolicy.Header.Name]
+	n.indexLock.Unlock()
+
+	if polLastUpdateTime > changedSince {
+		changedSince = 0
+	}
+	if !inProgress {
+		progress = indexProgress{start: searchStart}
+	}
+
+	devices := n.index.FindNodes(consumerPolicy, nodeOrgs, changedSince, progress.after, n.batchSize, n.activeDeviceTimeoutS)
+	glog.V(3).Infof(AWlogString(fmt.Sprintf("found %v nodes in the node index for %v changed since %v", len(devices), consumerPolicy.Header.Name, changedSince)))
+
+	n.makeAgreements(consumerPolicy, org, polName, &devices)
+
+	n.indexLock.Lock()
+	defer n.indexLock.Unlock()
+	if n.batchSize != 0 && uint64(len(devices)) == n.batchSize {
+		progress.after = devices[len(devices)-1].Id
+		n.indexProgress[consumerPolicy.Header.Name] = progress
+		return false
+	}
+	delete(n.indexProgress, consumerPolicy.Header.Name)
+	n.indexEvalTime[consumerPolicy.Header.Name] = progress.start
+	return true
+}
+
+// Update the node index from node and node policy changes reported by the exchange, and then rescan so that the
+// changed nodes are evaluated. This function is thread safe.
+func (n *NodeSearch) UpdateNodeIndex(changes []exchange.ExchangeChange) {
+
+	if n.index == nil {
+		n.SetRescanNeeded()
+		return
+	}
+
+	// The same node can change many times in one batch of changes, refresh the node and its policy only once. Only the
+	// resource that changed is fetched from the exchange.
+	refreshed := make(map[string]bool)
+	for _, change := range changes {
+		nodeId := fmt.Sprintf("%v/%v", change.OrgID, change.ID)
+		if change.IsNode("") && change.Operation == exchange.CHANGE_OPERATION_DELETED {
+			n.index.RemoveNode(nodeId)
+			refreshed[exchange.RESOURCE_NODE+nodeId] = true
+			refreshed[exchange.RESOURCE_NODE_POLICY+nodeId] = true
+		} else if change.IsNodePolicy("") && change.Operation == exchange.CHANGE_OPERATION_DELETED {
+			if n.index.HasNode(nodeId) {
+				n.index.UpdateNodePolicy(nodeId, nil)
+			}
+		} else if change.IsNodePolicy("") && !refreshed[exchange.RESOURCE_NODE_POLICY+nodeId] {
+			if n.index.HasNode(nodeId) {
+				n.refreshIndexedNodePolicy(nodeId)
+			} else {
+				n.refreshIndexedNode(nodeId)
+			}
+			refreshed[exchange.RESOURCE_NODE_POLICY+nodeId] = true
+		} else if !change.IsNodePolicy("") && !refreshed[exchange.RESOURCE_NODE+nodeId] {
+			n.refreshIndexedDevice(nodeId)
+			refreshed[exchange.RESOURCE_NODE+nodeId] = true
+		}
+	}
+
+	n.SetRescanNeeded()
+}
+
+// Refresh the heartbeats of the nodes in the index. Heartbeats are not reported as node changes, so without a refresh
+// the nodes that are idle would become stale in the index. The heartbeats are refreshed on every consistency check, and
+// often enough in between so that a node that is heartbeating never looks stale.
+func (n *NodeSearch) refreshIndexHeartbeats(force bool) {
+
+	// There is no stale node check when there is no active device timeout.
+	if n.activeDeviceTimeoutS <= 0 {
+		return
+	}
+
+	now := uint64(time.Now().Unix())
+	if !force && now-n.heartbeatRefresh < uint64(n.activeDeviceTimeoutS/2) {
+		return
+	}
+
+	// Only the nodes that heartbeated within the active device timeout are of interest.
+	since := time.Unix(int64(now)-int64(n.activeDeviceTimeoutS), 0).UTC().Format(cutil.ExchangeTimeFormat)
+
+	refreshed := true
+	for _, org := range n.pm.GetAllPolicyOrgs() {
+
+		// The node health search returns the nodes of one pattern, or all the policy based nodes in the node orgs.
+		patterns := make(map[string]bool)
+		policyNodeOrgs := make(map[string]bool)
+		for _, pol := range n.getOrderedPolicies(org) {
+			if pol.PatternId != "" {
+				if !patterns[pol.PatternId] {
+					patterns[pol.PatternId] = true
+					refreshed = n.updateIndexHeartbeats(pol.PatternId, org, n.patternManager.GetServedNodeOrgs(org, exchange.GetId(pol.PatternId)), since) && refreshed
+				}
+			} else {
+				_, polName := cutil.SplitOrgSpecUrl(pol.Header.Name)
+				for _, nodeOrg := range n.businessPolManager.GetServedNodeOrgs(org, polName) {
+					policyNodeOrgs[nodeOrg] = true
+				}
+			}
+		}
+
+		nodeOrgs := make([]string, 0, len(policyNodeOrgs))
+		for nodeOrg := range policyNodeOrgs {
+			nodeOrgs = append(nodeOrgs, nodeOrg)
+		}
+		refreshed = n.updateIndexHeartbeats("", org, nodeOrgs, since) && refreshed
+	}
+
+	// Try again on the next scan when some of the heartbeats could not be refreshed.
+	if refreshed {
+		n.heartbeatRefresh = now
+	}
+}
+
+// Update the node index with the heartbeats of the nodes returned by the exchange node health search. Return false
+// if the search failed.
+func (n *NodeSearch) updateIndexHeartbeats(pattern string, org string, nodeOrgs []string, since string) bool {
+
+	nhs, err := exchange.GetNodeHealthStatus(n.ec.GetHTTPFactory(), pattern, org, nodeOrgs, since, n.ec.GetExchangeURL(), n.ec.GetExchangeId(), n.ec.GetExchangeToken())
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to get node heartbeats of pattern %v or org %v for the node index, error: %v", pattern, org, err)))
+		return false
+	} else if nhs == nil {
+		return true
+	}
+
+	updated := 0
+	for nodeId, info := range nhs.Nodes {
+		if info.LastHeartbeat != "" && n.index.UpdateHeartbeat(nodeId, info.LastHeartbeat) {
+			updated++
+		}
+	}
+	glog.V(5).Infof(AWlogString(fmt.Sprintf("node index refreshed %v heartbeats of pattern %v or org %v", updated, pattern, org)))
+	return true
+}
+
+// Get the node and its policy from the exchange and update the node index with them.
+func (n *NodeSearch) refreshIndexedNode(nodeId string) {
+
+	dev, err := exchange.GetHTTPDeviceHandler(n.ec)(nodeId, "")
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to get node %v for the node index, error: %v", nodeId, err)))
+		return
+	}
+
+	var nodePolicy *policy.Policy
+	if dev.Pattern == "" {
+		if _, nodePolicy, err = compcheck.GetNodePolicy(exchange.GetHTTPNodePolicyHandler(n.ec), nodeId, nil); err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to get node policy of %v for the node index, error: %v", nodeId, err)))
+			return
+		}
+	}
+
+	n.index.UpdateNode(nodeId, dev)
+	n.index.UpdateNodePolicy(nodeId, nodePolicy)
+}
+
+// Get the node from the exchange and update the node index with it. The node policy is also fetched when the index
+// does not have it yet, such as when a pattern node becomes a policy based node.
+func (n *NodeSearch) refreshIndexedDevice(nodeId string) {
+
+	// The exchange cache could hold the node from before the change.
+	exchange.DeleteCacheNodeWriteThru(exchange.GetOrg(nodeId), exchange.GetId(nodeId))
+
+	if dev, err := exchange.GetHTTPDeviceHandler(n.ec)(nodeId, ""); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to get node %v for the node index, error: %v", nodeId, err)))
+	} else if n.index.UpdateNode(nodeId, dev); dev.Pattern == "" && !n.index.HasNodePolicy(nodeId) {
+		n.refreshIndexedNodePolicy(nodeId)
+	}
+}
+
+// Get the node policy from the exchange and update the node index with it.
+func (n *NodeSearch) refreshIndexedNodePolicy(nodeId string) {
+	if _, nodePolicy, err := compcheck.GetNodePolicy(exchange.GetHTTPNodePolicyHandler(n.ec), nodeId, nil); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to get node policy of %v for the node index, error: %v", nodeId, err)))
+	} else {
+		n.index.UpdateNodePolicy(nodeId, nodePolicy)
+	}
+}
+
+// Reconcile the node index with the node listings of the node orgs served by this agbot, one exchange call per node org.
+// The node policies are only fetched for the policy based nodes that the index does not have a policy for. Return false
+// if a listing could not be fetched, the consistency check is then not complete.
+func (n *NodeSearch) reconcileIndex() bool {
+
+	nodeOrgs := make(map[string]bool)
+	for _, org := range n.pm.GetAllPolicyOrgs() {
+		for _, pol := range n.getOrderedPolicies(org) {
+			if pol.PatternId != "" {
+				for _, nodeOrg := range n.patternManager.GetServedNodeOrgs(org, exchange.GetId(pol.PatternId)) {
+					nodeOrgs[nodeOrg] = true
+				}
+			} else {
+				_, polName := cutil.SplitOrgSpecUrl(pol.Header.Name)
+				for _, nodeOrg := range n.businessPolManager.GetServedNodeOrgs(org, polName) {
+					nodeOrgs[nodeOrg] = true
+				}
+			}
+		}
+	}
+
+	reconciled := true
+	for nodeOrg := range nodeOrgs {
+		devs, err := exchange.GetExchangeOrgDevices(n.ec.GetHTTPFactory(), nodeOrg, n.ec.GetExchangeId(), n.ec.GetExchangeToken(), n.ec.GetExchangeURL())
+		if err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to get the nodes of org %v for the node index, error: %v", nodeOrg, err)))
+			reconciled = false
+			continue
+		}
+		for _, nodeId := range n.index.ReconcileOrg(nodeOrg, devs) {
+			n.refreshIndexedNodePolicy(nodeId)
+		}
+	}
+
+	if reconciled {
+		n.heartbeatRefresh = uint64(time.Now().Unix())
+	}
+	return reconciled
+}
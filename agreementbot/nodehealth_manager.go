@@ -7,6 +7,7 @@ import (
 	"github.com/open-horizon/anax/agreementbot/persistence"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/exchange"
+	"strings"
 	"time"
 )
 
@@ -19,6 +20,12 @@ import (
 // in pattern groups. That is, all the nodes using a given pattern are updated in the cache with one
 // call to the exchange. An agbot will only obtain node status info for patterns which are used
 // by agreements that it is managing.
+//
+// When a deployment policy or pattern requires service level health, the manager also remembers when the
+// node first reported the service of an agreement as unhealthy, so that the agreement can be cancelled
+// when the service stays unhealthy for too long. The node reports the total number of times each container
+// has restarted, so the manager also keeps the restart counts it has seen over the last unhealthy service
+// interval, to count the restarts within that interval.
 
 type NodeHealthHandler func(pattern string, org string, nodeOrgs []string, lastCallTime string) (*exchange.NodeHealthStatus, error)
 
@@ -45,8 +52,16 @@ func NewNHPatternEntry() *NHPatternEntry {
 }
 
 type NodeHealthManager struct {
-	Patterns map[string]*NHPatternEntry // A map of patterns for which this agbot has agreements
-	NodeOrgs map[string][]string        // a map of node orgs for each pattern used by current active agreements
+	Patterns  map[string]*NHPatternEntry // A map of patterns for which this agbot has agreements
+	NodeOrgs  map[string][]string        // a map of node orgs for each pattern used by current active agreements
+	Unhealthy map[string]uint64          // A map of agreement ids to the time when the service was first seen unhealthy
+	Restarts  map[string][]RestartSample // A map of agreement ids to the container restart counts seen, oldest first
+}
+
+// The restart counts of the containers of a service, keyed by container name, as reported by the node at a given time.
+type RestartSample struct {
+	Time   uint64
+	Counts map[string]int
 }
 
 func (n *NodeHealthManager) String() string {
@@ -56,7 +71,9 @@ func (n *NodeHealthManager) String() string {
 
 func NewNodeHealthManager() *NodeHealthManager {
 	nh := &NodeHealthManager{
-		Patterns: make(map[string]*NHPatternEntry),
+		Patterns:  make(map[string]*NHPatternEntry),
+		Unhealthy: make(map[string]uint64),
+		Restarts:  make(map[string][]RestartSample),
 	}
 	return nh
 }
@@ -128,6 +145,123 @@ func (m *NodeHealthManager) AgreementOutOfPolicy(pattern string, org string, dev
 	return false
 }
 
+// Determine if the service running under the input agreement has been unhealthy, as reported by the node in its status,
+// for at least interval seconds. The service is also unhealthy when a container restarted maxRestarts times within the
+// last interval seconds. The reason the service is unhealthy is also returned. A node that has not reported the
+// status of the agreement is not judged, the heartbeat and agreement checks take care of a node that is not working.
+func (m *NodeHealthManager) ServiceOutOfPolicy(agreementId string, status *exchange.NodeStatus, maxRestarts int, interval int) (bool, string) {
+
+	problem := serviceHealthProblem(status, agreementId, maxRestarts, m.RecentRestarts(agreementId, status, interval))
+	if problem == "" {
+		delete(m.Unhealthy, agreementId)
+		return false, ""
+	}
+
+	now := uint64(time.Now().Unix())
+	since, ok := m.Unhealthy[agreementId]
+	if !ok {
+		since = now
+		m.Unhealthy[agreementId] = now
+	}
+	return now-since >= uint64(interval), problem
+}
+
+// Record the container restart counts of the service of the agreement, and return the number of times each container
+// restarted within the last window seconds. The restarts are counted from the oldest count seen within the window, so
+// the restarts that happened before the agbot first saw the service are not counted.
+func (m *NodeHealthManager) RecentRestarts(agreementId string, status *exchange.NodeStatus, window int) map[string]int {
+
+	counts := make(map[string]int)
+	if status == nil {
+		return counts
+	}
+	for _, svc := range status.Services {
+		if svc.AgreementId == agreementId {
+			for _, c := range svc.Containers {
+				counts[c.Name] = c.Restarts
+			}
+		}
+	}
+	if len(counts) == 0 {
+		return counts
+	}
+
+	// Drop the samples that are older than the window.
+	now := uint64(time.Now().Unix())
+	samples := m.Restarts[agreementId]
+	for len(samples) != 0 && samples[0].Time+uint64(window) < now {
+		samples = samples[1:]
+	}
+	samples = append(samples, RestartSample{Time: now, Counts: counts})
+	m.Restarts[agreementId] = samples
+
+	restarts := make(map[string]int)
+	for name, count := range counts {
+		if base, ok := samples[0].Counts[name]; ok && base <= count {
+			restarts[name] = count - base
+		} else if ok {
+			// The node lost the count, so the container restarted at least as many times as it reports.
+			restarts[name] = count
+		}
+	}
+	return restarts
+}
+
+// Stop tracking the service health of an agreement.
+func (m *NodeHealthManager) ForgetAgreement(agreementId string) {
+	delete(m.Unhealthy, agreementId)
+	delete(m.Restarts, agreementId)
+}
+
+// Stop tracking the service health of the agreements that are no longer in progress.
+func (m *NodeHealthManager) PruneUnhealthy(inProgress map[string]bool) {
+	for agreementId := range m.Unhealthy {
+		if !inProgress[agreementId] {
+			delete(m.Unhealthy, agreementId)
+		}
+	}
+	for agreementId := range m.Restarts {
+		if !inProgress[agreementId] {
+			delete(m.Restarts, agreementId)
+		}
+	}
+}
+
+// Return why the service of the agreement is unhealthy, or an empty string if it is healthy or its status is unknown.
+// A container is healthy when it is running, and a helm release is healthy when it is deployed. The restarts are the
+// recent restarts of each container.
+func serviceHealthProblem(status *exchange.NodeStatus, agreementId string, maxRestarts int, restarts map[string]int) string {
+	if status == nil {
+		return ""
+	}
+	for _, svc := range status.Services {
+		if svc.AgreementId != agreementId {
+			continue
+		}
+		for _, c := range svc.Containers {
+			if !strings.EqualFold(c.State, "running") && !strings.EqualFold(c.State, "deployed") {
+				return fmt.Sprintf("container %v is %v", c.Name, c.State)
+			} else if maxRestarts != 0 && restarts[c.Name] >= maxRestarts {
+				return fmt.Sprintf("container %v restarted %v times", c.Name, restarts[c.Name])
+			}
+		}
+	}
+	return ""
+}
+
+// Return true if the node status contains the service of the agreement.
+func serviceReported(status *exchange.NodeStatus, agreementId string) bool {
+	if status == nil {
+		return false
+	}
+	for _, svc := range status.Services {
+		if svc.AgreementId == agreementId {
+			return true
+		}
+	}
+	return false
+}
+
 // The manager has updated status if the pattern entry exists and has the Updated flag turned on.
 func (m *NodeHealthManager) hasUpdatedStatus(pattern string, org string) (string, bool) {
 
@@ -248,16 +248,16 @@ func Test_SetNodeOrgs(t *testing.T) {
 		t.Errorf("patterns map should be empty")
 	}
 
-	ag1, _ := persistence.NewAgreement("agreement_id1", "pattern_org1", "node_org1/device1", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag2, _ := persistence.NewAgreement("agreement_id2", "pattern_org1", "node_org1/device2", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag3, _ := persistence.NewAgreement("agreement_id3", "pattern_org1", "node_org2/device1", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag4, _ := persistence.NewAgreement("agreement_id4", "pattern_org1", "node_org2/device2", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag5, _ := persistence.NewAgreement("agreement_id5", "pattern_org2", "node_org1/device1", "device", "", "", "", "", "basic", "pattern_org2/sall", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag6, _ := persistence.NewAgreement("agreement_id6", "pattern_org2", "node_org1/device1", "device", "", "", "", "", "basic", "pattern_org2/sall", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag7, _ := persistence.NewAgreement("agreement_id7", "pattern_org1", "node_org1/device2", "device", "", "", "", "", "basic", "pattern_org1/netspeed", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag8, _ := persistence.NewAgreement("agreement_id8", "pattern_org1", "node_org2/device2", "device", "", "", "", "", "basic", "pattern_org1/netspeed", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag9, _ := persistence.NewAgreement("agreement_id9", "org1", "node_org2/device3", "device", "", "", "", "", "basic", "", []string{""}, policy.NodeHealth{}, 180, 180)
-	ag10, _ := persistence.NewAgreement("agreement_id10", "org1", "node_org3/device3", "device", "", "", "", "", "basic", "", []string{""}, policy.NodeHealth{}, 180, 180)
+	ag1, _ := persistence.NewAgreement("agreement_id1", "pattern_org1", "node_org1/device1", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag2, _ := persistence.NewAgreement("agreement_id2", "pattern_org1", "node_org1/device2", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag3, _ := persistence.NewAgreement("agreement_id3", "pattern_org1", "node_org2/device1", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag4, _ := persistence.NewAgreement("agreement_id4", "pattern_org1", "node_org2/device2", "device", "", "", "", "", "basic", "pattern_org1/sall", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag5, _ := persistence.NewAgreement("agreement_id5", "pattern_org2", "node_org1/device1", "device", "", "", "", "", "basic", "pattern_org2/sall", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag6, _ := persistence.NewAgreement("agreement_id6", "pattern_org2", "node_org1/device1", "device", "", "", "", "", "basic", "pattern_org2/sall", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag7, _ := persistence.NewAgreement("agreement_id7", "pattern_org1", "node_org1/device2", "device", "", "", "", "", "basic", "pattern_org1/netspeed", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag8, _ := persistence.NewAgreement("agreement_id8", "pattern_org1", "node_org2/device2", "device", "", "", "", "", "basic", "pattern_org1/netspeed", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag9, _ := persistence.NewAgreement("agreement_id9", "org1", "node_org2/device3", "device", "", "", "", "", "basic", "", []string{""}, policy.NodeHealth{}, 180, 180, "")
+	ag10, _ := persistence.NewAgreement("agreement_id10", "org1", "node_org3/device3", "device", "", "", "", "", "basic", "", []string{""}, policy.NodeHealth{}, 180, 180, "")
 
 	agreements := []persistence.Agreement{*ag1, *ag2, *ag3, *ag4, *ag5, *ag6, *ag7, *ag8, *ag9, *ag10}
 
@@ -327,6 +327,69 @@ func Test_stringSliceContains(t *testing.T) {
 	}
 }
 
+func Test_ServiceOutOfPolicy(t *testing.T) {
+
+	nhm := NewNodeHealthManager()
+
+	status := &exchange.NodeStatus{
+		Services: []exchange.ServiceStatus{
+			{AgreementId: "ag1", Containers: []exchange.ServiceContainerStatus{{Name: "/ag1-svc1", State: "running", Restarts: 1}}},
+			{AgreementId: "ag2", Containers: []exchange.ServiceContainerStatus{{Name: "/ag2-svc2", State: "exited"}}},
+			{AgreementId: "ag3", Containers: []exchange.ServiceContainerStatus{{Name: "svc3", State: "Running", Restarts: 5}}},
+		},
+	}
+
+	if unhealthy, reason := nhm.ServiceOutOfPolicy("ag1", status, 3, 0); unhealthy || reason != "" {
+		t.Errorf("expected ag1 to be healthy, got %v", reason)
+	} else if unhealthy, reason := nhm.ServiceOutOfPolicy("ag4", status, 3, 0); unhealthy || reason != "" {
+		t.Errorf("expected ag4 without status not to be judged, got %v", reason)
+	} else if unhealthy, reason := nhm.ServiceOutOfPolicy("ag3", status, 3, 60); unhealthy || reason != "" {
+		t.Errorf("expected the restarts before ag3 was first seen not to be counted, got %v", reason)
+	}
+
+	// Only the restarts within the interval are counted.
+	status.Services[2].Containers[0].Restarts = 7
+	if unhealthy, reason := nhm.ServiceOutOfPolicy("ag3", status, 3, 60); unhealthy || reason != "" {
+		t.Errorf("expected ag3 to be healthy after 2 restarts, got %v", reason)
+	}
+	status.Services[2].Containers[0].Restarts = 8
+	if unhealthy, reason := nhm.ServiceOutOfPolicy("ag3", status, 3, 0); !unhealthy || reason != "container svc3 restarted 3 times" {
+		t.Errorf("expected ag3 to be out of policy because of restarts, got %v", reason)
+	}
+
+	nhm.Restarts["ag3"][0].Time = uint64(time.Now().Unix()) - 120
+	if unhealthy, reason := nhm.ServiceOutOfPolicy("ag3", status, 3, 60); unhealthy || reason != "" {
+		t.Errorf("expected the restarts before the interval not to be counted, got %v", reason)
+	}
+
+	// The service has to stay unhealthy for the interval before the agreement is out of policy.
+	if unhealthy, reason := nhm.ServiceOutOfPolicy("ag2", status, 0, 60); unhealthy || reason == "" {
+		t.Errorf("expected ag2 to be unhealthy but within the interval, got %v %v", unhealthy, reason)
+	} else if _, ok := nhm.Unhealthy["ag2"]; !ok {
+		t.Errorf("expected ag2 to be tracked as unhealthy")
+	}
+
+	nhm.Unhealthy["ag2"] = uint64(time.Now().Unix()) - 120
+	if unhealthy, _ := nhm.ServiceOutOfPolicy("ag2", status, 0, 60); !unhealthy {
+		t.Errorf("expected ag2 to be out of policy after the interval")
+	}
+
+	// A healthy report resets the interval, and agreements that are no longer in progress are forgotten.
+	status.Services[1].Containers[0].State = "running"
+	if unhealthy, _ := nhm.ServiceOutOfPolicy("ag2", status, 0, 60); unhealthy {
+		t.Errorf("expected ag2 to be healthy again")
+	} else if _, ok := nhm.Unhealthy["ag2"]; ok {
+		t.Errorf("expected ag2 not to be tracked as unhealthy")
+	}
+
+	nhm.PruneUnhealthy(map[string]bool{"ag1": true})
+	if len(nhm.Unhealthy) != 0 {
+		t.Errorf("expected no unhealthy agreements, got %v", nhm.Unhealthy)
+	} else if _, ok := nhm.Restarts["ag3"]; ok || len(nhm.Restarts) != 1 {
+		t.Errorf("expected only the restarts of ag1 to be kept, got %v", nhm.Restarts)
+	}
+}
+
 func getVariableStatusHandler(node string, agreementId string, nodeOrgs []string, lastHB string) func(pattern string, org string, nodeOrgs []string, lastCall string) (*exchange.NodeHealthStatus, error) {
 	return func(pattern string, org string, nodeOrgs []string, lastCall string) (*exchange.NodeHealthStatus, error) {
 		o := &exchange.NodeHealthStatus{
@@ -113,6 +113,55 @@ func (m *MMSObjectPolicyManager) GetAllPolicyOrgs() []string {
 	return orgs
 }
 
+// Return a copy of the cached object policies in each org. An object policy is cached once for each service it refers to,
+// but it is returned only once.
+func (m *MMSObjectPolicyManager) GetOrgObjectPolicies() map[string]exchange.ObjectDestinationPolicies {
+	m.orgMapLock.Lock()
+	defer m.orgMapLock.Unlock()
+
+	orgPolicies := make(map[string]exchange.ObjectDestinationPolicies)
+	for org, serviceMap := range m.orgMap {
+		seen := make(map[string]bool)
+		objPolicies := make(exchange.ObjectDestinationPolicies, 0)
+		for _, peList := range serviceMap {
+			for _, pe := range peList {
+				key := fmt.Sprintf("%v/%v/%v", pe.Policy.OrgID, pe.Policy.ObjectType, pe.Policy.ObjectID)
+				if !seen[key] {
+					seen[key] = true
+					objPolicies = append(objPolicies, pe.Policy)
+				}
+			}
+		}
+		orgPolicies[org] = objPolicies
+	}
+	return orgPolicies
+}
+
+// Remove the given object policies from the cache, for all the services they refer to.
+func (m *MMSObjectPolicyManager) RemoveObjectPolicies(org string, removed exchange.ObjectDestinationPolicies) {
+	m.orgMapLock.Lock()
+	defer m.orgMapLock.Unlock()
+
+	for service, peList := range m.orgMap[org] {
+		kept := make([]MMSObjectPolicyEntry, 0, len(peList))
+		for _, pe := range peList {
+			found := false
+			for _, objPol := range removed {
+				if pe.Policy.OrgID == objPol.OrgID && pe.Policy.ObjectID == objPol.ObjectID && pe.Policy.ObjectType == objPol.ObjectType {
+					found = true
+					break
+				}
+			}
+			if found {
+				glog.V(3).Infof(mmsLogString(fmt.Sprintf("object %v/%v %v policy removed from %v cache.", pe.Policy.OrgID, pe.Policy.ObjectID, pe.Policy.ObjectType, service)))
+			} else {
+				kept = append(kept, pe)
+			}
+		}
+		m.orgMap[org][service] = kept
+	}
+}
+
 // copy the given map of served business policies
 func (m *MMSObjectPolicyManager) setServedBusinessPolicies(servedOrgs map[string]exchange.ServedBusinessPolicy) {
 	m.spMapLock.Lock()
@@ -0,0 +1,323 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"sort"
+	"time"
+)
+
+// When the agbot is horizontally scaled, each agbot owns a partition in the database. A new agbot instance starts with an
+// empty partition, so the agbots rebalance their partitions. The agbots elect a leader through the database. The leader
+// compares the number of active agreements in each owned partition and proposes to move agreements out of the partitions
+// that exceed the average, into partitions that are below the average. The owner of a source partition stops searching
+// for new nodes and waits for its work queues to drain before moving the records, so that no work is in flight for the
+// agreements it hands off. The owner of a target partition then picks up the moved agreements, the same way it does when
+// it takes over a stale partition.
+
+// The owner reported for a partition that is not owned by any agbot.
+const PARTITION_NO_OWNER = "NO OWNER"
+
+// The number of seconds to keep the moves that have reached a final state.
+const PARTITION_MOVE_RETENTION = 86400
+
+type PartitionLoad struct {
+	Partition          string `json:"partition"`
+	Owner              string `json:"owner"`
+	ActiveAgreements   int64  `json:"active_agreements"`
+	ArchivedAgreements int64  `json:"archived_agreements"`
+	WorkloadUsages     int64  `json:"workload_usages"`
+	Exchanges          string `json:"exchanges,omitempty"` // The federated exchanges served by the agbot that created the partition
+}
+
+type PartitionStatus struct {
+	Leader     string                      `json:"leader"`            // The agbot that proposes partition moves, empty when there is none
+	Primary    string                      `json:"primary_partition"` // The partition of the agbot that answered
+	Partitions []PartitionLoad             `json:"partitions"`
+	Moves      []persistence.PartitionMove `json:"moves"` // The recent moves, including the ones in flight
+}
+
+// Get the ownership and load of all the partitions in the database.
+func FindPartitionLoads(db persistence.AgbotDatabase) ([]PartitionLoad, error) {
+
+	partitions, err := db.FindPartitions()
+	if err != nil {
+		return nil, fmt.Errorf("error finding all partitions, error: %v", err)
+	}
+
+	// The partitions are found from the agreement records, so a partition appears once for each of its agreements.
+	seen := make(map[string]bool)
+	loads := make([]PartitionLoad, 0, len(partitions))
+	for _, p := range partitions {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		load := PartitionLoad{Partition: p}
+		if load.Owner, err = db.GetPartitionOwner(p); err != nil {
+			return nil, fmt.Errorf("error finding partition %v owner, error: %v", p, err)
+		} else if load.ActiveAgreements, load.ArchivedAgreements, err = db.GetAgreementCount(p); err != nil {
+			return nil, fmt.Errorf("error finding agreement count in partition %v, error: %v", p, err)
+		} else if load.WorkloadUsages, err = db.GetWorkloadUsagesCount(p); err != nil {
+			return nil, fmt.Errorf("error finding workload usage count in partition %v, error: %v", p, err)
+		} else if load.Exchanges, err = db.GetPartitionExchanges(p); err != nil {
+			return nil, fmt.Errorf("error finding partition %v exchanges, error: %v", p, err)
+		}
+		loads = append(loads, load)
+	}
+
+	sort.Slice(loads, func(i, j int) bool { return loads[i].Partition < loads[j].Partition })
+	return loads, nil
+}
+
+// Get the partition ownership, load and moves.
+func GetPartitionStatus(db persistence.AgbotDatabase) (*PartitionStatus, error) {
+
+	status := &PartitionStatus{Primary: db.PrimaryPartition()}
+
+	var err error
+	if status.Leader, err = db.GetPartitionLeader(); err != nil {
+		return nil, err
+	} else if status.Partitions, err = FindPartitionLoads(db); err != nil {
+		return nil, err
+	} else if status.Moves, err = db.FindPartitionMoves(false); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Plan the moves that bring the owned partitions closer to the average number of active agreements. A partition is only
+// involved in one move at a time, so partitions that are part of a move in flight are left alone until it completes. A
+// partition is a source when it has more than threshold agreements above the average, and a target when it has more than
+// threshold agreements below the average. The busiest partitions are paired with the least busy partitions. Agreements
+// are only moved between the partitions of agbots that serve the same federated exchanges, so each group of partitions
+// is balanced on its own.
+func PlanPartitionMoves(loads []PartitionLoad, inflight []persistence.PartitionMove, threshold int64) []persistence.PartitionMove {
+
+	moves := make([]persistence.PartitionMove, 0)
+
+	busy := make(map[string]bool)
+	for _, m := range inflight {
+		busy[m.FromPartition] = true
+		busy[m.ToPartition] = true
+	}
+
+	groups := make(map[string][]PartitionLoad)
+	for _, l := range loads {
+		if l.Owner != "" && l.Owner != PARTITION_NO_OWNER {
+			groups[l.Exchanges] = append(groups[l.Exchanges], l)
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		moves = append(moves, planGroupMoves(groups[name], busy, threshold)...)
+	}
+	return moves
+}
+
+// Plan the moves within a group of owned partitions.
+func planGroupMoves(owned []PartitionLoad, busy map[string]bool, threshold int64) []persistence.PartitionMove {
+
+	moves := make([]persistence.PartitionMove, 0)
+
+	total := int64(0)
+	for _, l := range owned {
+		total += l.ActiveAgreements
+	}
+	if len(owned) < 2 {
+		return moves
+	}
+	average := total / int64(len(owned))
+
+	sources := make([]PartitionLoad, 0)
+	targets := make([]PartitionLoad, 0)
+	for _, l := range owned {
+		if busy[l.Partition] {
+			continue
+		} else if l.ActiveAgreements > average+threshold {
+			sources = append(sources, l)
+		} else if l.ActiveAgreements+threshold < average {
+			targets = append(targets, l)
+		}
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].ActiveAgreements > sources[j].ActiveAgreements })
+	sort.SliceStable(targets, func(i, j int) bool { return targets[i].ActiveAgreements < targets[j].ActiveAgreements })
+
+	for ix := 0; ix < len(sources) && ix < len(targets); ix++ {
+		count := sources[ix].ActiveAgreements - average
+		if room := average - targets[ix].ActiveAgreements; room < count {
+			count = room
+		}
+		moves = append(moves, persistence.PartitionMove{
+			FromPartition: sources[ix].Partition,
+			ToPartition:   targets[ix].Partition,
+			Agreements:    count,
+		})
+	}
+	return moves
+}
+
+// This function is called by the partition rebalance subworker. When this agbot is the leader, it proposes moves. Every
+// agbot takes part in the moves of its own partition.
+func (w *AgreementBotWorker) rebalancePartitions() int {
+
+	stale := w.Config.GetPartitionStale()
+
+	if leader, err := w.db.ClaimPartitionLeader(stale); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to claim partition leadership, error: %v", err)))
+	} else if leader {
+		w.proposePartitionMoves(stale)
+	}
+
+	w.handlePartitionMoves(stale)
+	return 0
+}
+
+// Abandon the moves that are not making progress and propose new moves.
+func (w *AgreementBotWorker) proposePartitionMoves(stale uint64) {
+
+	moves, err := w.db.FindPartitionMoves(true)
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to find partition moves, error: %v", err)))
+		return
+	}
+
+	// The owner of the source partition abandons a move when its work queues do not drain, so a move that has not changed
+	// state for much longer than that has lost one of the agbots involved.
+	now := uint64(time.Now().Unix())
+	inflight := make([]persistence.PartitionMove, 0, len(moves))
+	for _, m := range moves {
+		if now > m.Updated+3*stale {
+			if err := w.db.UpdatePartitionMove(m.Id, persistence.PARTITION_MOVE_FAILED, fmt.Sprintf("no progress in state %v", m.State)); err != nil {
+				glog.Errorf(AWlogString(fmt.Sprintf("unable to abandon partition move %v, error: %v", m, err)))
+				inflight = append(inflight, m)
+			}
+		} else {
+			inflight = append(inflight, m)
+		}
+	}
+
+	loads, err := FindPartitionLoads(w.db)
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to find partition loads, error: %v", err)))
+		return
+	}
+
+	for _, m := range PlanPartitionMoves(loads, inflight, int64(w.Config.GetPartitionRebalanceThreshold())) {
+		if _, err := w.db.ProposePartitionMove(m.FromPartition, m.ToPartition, m.Agreements); err != nil {
+			glog.Errorf(AWlogString(fmt.Sprintf("unable to propose partition move %v, error: %v", m, err)))
+		}
+	}
+
+	if err := w.db.PurgePartitionMoves(PARTITION_MOVE_RETENTION); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to purge partition moves, error: %v", err)))
+	}
+}
+
+// Hand off agreements for the moves out of our partition, and pick up the agreements moved into our partition.
+func (w *AgreementBotWorker) handlePartitionMoves(stale uint64) {
+
+	moves, err := w.db.FindPartitionMoves(true)
+	if err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to find partition moves, error: %v", err)))
+		return
+	}
+
+	primary := w.db.PrimaryPartition()
+	now := uint64(time.Now().Unix())
+	handingOff := false
+
+	for ix, m := range moves {
+		if m.FromPartition == primary {
+			switch m.State {
+			case persistence.PARTITION_MOVE_PROPOSED:
+				// Stop searching for new nodes so that the work queues can drain.
+				w.setSearchPaused(true)
+				handingOff = true
+				if err := w.db.UpdatePartitionMove(m.Id, persistence.PARTITION_MOVE_DRAINING, ""); err != nil {
+					glog.Errorf(AWlogString(fmt.Sprintf("unable to start draining for partition move %v, error: %v", m, err)))
+				}
+
+			case persistence.PARTITION_MOVE_DRAINING:
+				w.setSearchPaused(true)
+				if w.isDrained() {
+					if moved, err := w.db.MovePartitionAgreements(&moves[ix]); err != nil {
+						glog.Errorf(AWlogString(fmt.Sprintf("unable to move agreements for partition move %v, error: %v", m, err)))
+						w.failPartitionMove(m, err.Error())
+					} else {
+						glog.V(3).Infof(AWlogString(fmt.Sprintf("handed off %v agreements to partition %v", moved, m.ToPartition)))
+					}
+				} else if now > m.Updated+stale {
+					w.failPartitionMove(m, "work queues did not drain")
+				} else {
+					handingOff = true
+				}
+			}
+
+		} else if m.ToPartition == primary && m.State == persistence.PARTITION_MOVE_MOVED {
+			// Perform the same sanity checks on the moved agreements that are done when a stale partition is taken over.
+			if err := w.syncAllOnInit(); err != nil {
+				glog.Errorf(AWlogString(fmt.Sprintf("unable to sync up after partition move %v, error: %v", m, err)))
+			} else if err := w.db.UpdatePartitionMove(m.Id, persistence.PARTITION_MOVE_DONE, ""); err != nil {
+				glog.Errorf(AWlogString(fmt.Sprintf("unable to complete partition move %v, error: %v", m, err)))
+			}
+		}
+	}
+
+	// Resume searching when there is nothing left to hand off, including when the leader abandoned the move.
+	if !handingOff {
+		w.setSearchPaused(false)
+	}
+}
+
+func (w *AgreementBotWorker) failPartitionMove(m persistence.PartitionMove, reason string) {
+	if err := w.db.UpdatePartitionMove(m.Id, persistence.PARTITION_MOVE_FAILED, reason); err != nil {
+		glog.Errorf(AWlogString(fmt.Sprintf("unable to abandon partition move %v, error: %v", m, err)))
+	}
+}
+
+// The partition holds the agreements of all the exchanges served by the agbot, so the node searches of all the
+// exchanges are paused while agreements are handed off.
+func (w *AgreementBotWorker) setSearchPaused(paused bool) {
+	w.nodeSearch.SetPaused(paused)
+	for _, fw := range getAgbotInstances() {
+		if fw != w {
+			fw.nodeSearch.SetPaused(paused)
+		}
+	}
+}
+
+// The agbot is drained when the node searches of all the exchanges are idle and there is no work waiting in any of
+// their protocol work queues.
+func (w *AgreementBotWorker) isDrained() bool {
+	if !w.isInstanceDrained() {
+		return false
+	}
+	for _, fw := range getAgbotInstances() {
+		if fw != w && !fw.isInstanceDrained() {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *AgreementBotWorker) isInstanceDrained() bool {
+	if !w.nodeSearch.IsIdle() {
+		return false
+	}
+	for _, cph := range w.consumerPH.GetAll() {
+		if !w.consumerPH.Get(cph).WorkQueue().IsDrained() {
+			return false
+		}
+	}
+	return true
+}
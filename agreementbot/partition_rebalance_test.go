@@ -0,0 +1,100 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"os"
+	"testing"
+)
+
+func Test_plan_partition_moves(t *testing.T) {
+
+	loads := []PartitionLoad{
+		{Partition: "1", Owner: "a1", ActiveAgreements: 100},
+		{Partition: "2", Owner: "a2", ActiveAgreements: 0},
+	}
+
+	// A new agbot gets half of the agreements.
+	if moves := PlanPartitionMoves(loads, nil, 10); len(moves) != 1 {
+		t.Errorf("expected 1 move, got %v", moves)
+	} else if moves[0].FromPartition != "1" || moves[0].ToPartition != "2" || moves[0].Agreements != 50 {
+		t.Errorf("wrong move %v", moves[0])
+	}
+
+	// Nothing moves while one of the partitions is part of a move in flight.
+	inflight := []persistence.PartitionMove{{FromPartition: "1", ToPartition: "2", State: persistence.PARTITION_MOVE_DRAINING}}
+	if moves := PlanPartitionMoves(loads, inflight, 10); len(moves) != 0 {
+		t.Errorf("expected no moves, got %v", moves)
+	}
+
+	// Partitions within the threshold of the average and unowned partitions are left alone.
+	loads = []PartitionLoad{
+		{Partition: "1", Owner: "a1", ActiveAgreements: 55},
+		{Partition: "2", Owner: "a2", ActiveAgreements: 45},
+		{Partition: "3", Owner: PARTITION_NO_OWNER, ActiveAgreements: 0},
+	}
+	if moves := PlanPartitionMoves(loads, nil, 10); len(moves) != 0 {
+		t.Errorf("expected no moves, got %v", moves)
+	}
+
+	// The busiest partition is paired with the least busy partition, and no partition receives more than it can take.
+	loads = []PartitionLoad{
+		{Partition: "1", Owner: "a1", ActiveAgreements: 60},
+		{Partition: "2", Owner: "a2", ActiveAgreements: 90},
+		{Partition: "3", Owner: "a3", ActiveAgreements: 30},
+		{Partition: "4", Owner: "a4", ActiveAgreements: 20},
+	}
+	if moves := PlanPartitionMoves(loads, nil, 10); len(moves) != 1 {
+		t.Errorf("expected 1 move, got %v", moves)
+	} else if moves[0].FromPartition != "2" || moves[0].ToPartition != "4" || moves[0].Agreements != 30 {
+		t.Errorf("wrong move %v", moves[0])
+	}
+
+	// Agreements only move between the partitions of agbots serving the same federated exchanges.
+	loads = []PartitionLoad{
+		{Partition: "1", Owner: "a1", ActiveAgreements: 100},
+		{Partition: "2", Owner: "a2", ActiveAgreements: 0, Exchanges: "east"},
+		{Partition: "3", Owner: "a3", ActiveAgreements: 80, Exchanges: "east"},
+		{Partition: "4", Owner: "a4", ActiveAgreements: 90},
+	}
+	if moves := PlanPartitionMoves(loads, nil, 10); len(moves) != 1 {
+		t.Errorf("expected 1 move, got %v", moves)
+	} else if moves[0].FromPartition != "3" || moves[0].ToPartition != "2" || moves[0].Agreements != 40 {
+		t.Errorf("wrong move %v", moves[0])
+	}
+
+}
+
+func Test_select_devices_to_move(t *testing.T) {
+
+	ags := []persistence.Agreement{
+		{CurrentAgreementId: "a1", DeviceId: "org1/d1", AgreementFinalizedTime: 1},
+		{CurrentAgreementId: "a2", DeviceId: "org1/d1", AgreementFinalizedTime: 1},
+		{CurrentAgreementId: "a3", DeviceId: "org1/d2", AgreementFinalizedTime: 0},
+		{CurrentAgreementId: "a4", DeviceId: "org1/d3", AgreementFinalizedTime: 1, HAPartners: []string{"org1/d4"}},
+		{CurrentAgreementId: "a5", DeviceId: "org1/d5", AgreementFinalizedTime: 1},
+		{CurrentAgreementId: "a6", DeviceId: "org1/d6", Archived: true},
+	}
+
+	// Devices are moved with all their agreements, devices with agreements in progress or in an HA group stay.
+	if devices, moved := persistence.SelectDevicesToMove(ags, 1); len(devices) != 1 || devices[0] != "org1/d1" || moved != 2 {
+		t.Errorf("wrong devices %v, %v", devices, moved)
+	} else if devices, moved := persistence.SelectDevicesToMove(ags, 10); len(devices) != 2 || devices[1] != "org1/d5" || moved != 3 {
+		t.Errorf("wrong devices %v, %v", devices, moved)
+	}
+
+}
+
+func Test_partition_status_bolt(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	if status, err := GetPartitionStatus(db); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if status.Leader != "" || status.Primary != "global" || len(status.Partitions) != 1 || len(status.Moves) != 0 {
+		t.Errorf("wrong status %v", status)
+	}
+
+}
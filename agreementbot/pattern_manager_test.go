@@ -1049,7 +1049,7 @@ func getTestPattern() exchange.Pattern {
 					},
 				},
 				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				NodeH:      exchange.NodeHealth{MissingHBInterval: 600, CheckAgreementStatus: 120},
 			},
 
 			{
@@ -1066,7 +1066,7 @@ func getTestPattern() exchange.Pattern {
 					},
 				},
 				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				NodeH:      exchange.NodeHealth{MissingHBInterval: 600, CheckAgreementStatus: 120},
 			},
 
 			{
@@ -1083,11 +1083,11 @@ func getTestPattern() exchange.Pattern {
 					},
 				},
 				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				NodeH:      exchange.NodeHealth{MissingHBInterval: 600, CheckAgreementStatus: 120},
 			},
 		},
 		AgreementProtocols: []exchange.AgreementProtocol{
-			{"Basic", 0, []exchange.Blockchain{}},
+			{"Basic", 0, []exchange.Blockchain{}, nil},
 		},
 	}
 }
@@ -1095,7 +1095,7 @@ func getTestPattern() exchange.Pattern {
 func getTestPattern2() exchange.Pattern {
 	return exchange.Pattern{
 		AgreementProtocols: []exchange.AgreementProtocol{
-			{"Basic", 0, []exchange.Blockchain{}},
+			{"Basic", 0, []exchange.Blockchain{}, nil},
 		},
 		Description: "Pattern for the service version of Core",
 		Public:      true,
@@ -1116,7 +1116,7 @@ func getTestPattern2() exchange.Pattern {
 					},
 				},
 				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				NodeH:      exchange.NodeHealth{MissingHBInterval: 600, CheckAgreementStatus: 120},
 			},
 
 			{
@@ -1133,7 +1133,7 @@ func getTestPattern2() exchange.Pattern {
 					},
 				},
 				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				NodeH:      exchange.NodeHealth{MissingHBInterval: 600, CheckAgreementStatus: 120},
 			},
 
 			{
@@ -1150,7 +1150,7 @@ func getTestPattern2() exchange.Pattern {
 					},
 				},
 				DataVerify: exchange.DataVerification{false, "", "", "", 0, 0, exchange.Meter{0, "", 0}},
-				NodeH:      exchange.NodeHealth{600, 120},
+				NodeH:      exchange.NodeHealth{MissingHBInterval: 600, CheckAgreementStatus: 120},
 			},
 		},
 	}
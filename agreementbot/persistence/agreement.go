@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/open-horizon/anax/policy"
+	"strings"
 	"time"
 )
 
@@ -45,6 +46,7 @@ type Agreement struct {
 	MeteringNotificationInterval   int      `json:"metering_notify_interval"`          // The interval of time between metering notifications (seconds)
 	MeteringNotificationSent       uint64   `json:"metering_notification_sent"`        // The last time a metering notification was sent
 	MeteringNotificationMsgs       []string `json:"metering_notification_msgs"`        // The last metering messages that were sent, oldest at the end
+	MeteringNotificationHistory    []string `json:"metering_notification_history"`     // The last MAX_METERING_NOTIFICATION_HISTORY metering messages that were sent, oldest first
 	Archived                       bool     `json:"archived"`                          // The record is archived
 	TerminatedReason               uint     `json:"terminated_reason"`                 // The reason the agreement was terminated
 	TerminatedDescription          string   `json:"terminated_description"`            // The description of why the agreement was terminated
@@ -54,10 +56,16 @@ type Agreement struct {
 	BCUpdateAckTime                uint64   `json:"blockchain_update_ack_time"`        // The time when the producer ACked our update ot him (new V2 protocol)
 	NHMissingHBInterval            int      `json:"missing_heartbeat_interval"`        // How long a heartbeat can be missing until it is considered missing (in seconds)
 	NHCheckAgreementStatus         int      `json:"check_agreement_status"`            // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	NHUnhealthyServiceInterval     int      `json:"unhealthy_service_interval"`        // How long the service can be reported unhealthy by the node until the agreement is cancelled (in seconds)
+	NHMaxRestarts                  int      `json:"max_restarts"`                      // The number of recent container restarts after which the service is unhealthy
 	Pattern                        string   `json:"pattern"`                           // The pattern used to make the agreement, used for pattern case only
 	ServiceId                      []string `json:"service_id"`                        // All the service ids whose policy is used to make the agreement, used for policy case only
 	ProtocolTimeoutS               uint64   `json:"protocol_timeout_sec"`              // Number of seconds to wait before declaring proposal response is lost
 	AgreementTimeoutS              uint64   `json:"agreement_timeout_sec"`
+	Exchange                       string   `json:"exchange,omitempty"`       // The name of the federated exchange of the device, empty for the agbot's primary exchange
+	Capabilities                   []string `json:"capabilities,omitempty"`   // The protocol capabilities agreed to by the producer, new in V4 protocol
+	HealthyTime                    uint64   `json:"healthy_time,omitempty"`   // The first time the node was seen healthy after UnhealthyTime, used for HA upgrades
+	UnhealthyTime                  uint64   `json:"unhealthy_time,omitempty"` // The last time the node was seen unhealthy, used for HA upgrades
 }
 
 func (a Agreement) String() string {
@@ -99,10 +107,15 @@ func (a Agreement) String() string {
 		"BCUpdateAckTime: %v, "+
 		"NHMissingHBInterval: %v, "+
 		"NHCheckAgreementStatus: %v, "+
+		"NHUnhealthyServiceInterval: %v, "+
+		"NHMaxRestarts: %v, "+
 		"Pattern: %v, "+
 		"ServiceId: %v, "+
 		"ProtocolTimeoutS: %v, "+
-		"AgreementTimeoutS: %v",
+		"AgreementTimeoutS: %v, "+
+		"Exchange: %v, "+
+		"HealthyTime: %v, "+
+		"UnhealthyTime: %v",
 		a.Archived, a.CurrentAgreementId, a.Org, a.AgreementProtocol, a.AgreementProtocolVersion, a.DeviceId, a.DeviceType, a.HAPartners,
 		a.AgreementInceptionTime, a.AgreementCreationTime, a.AgreementFinalizedTime,
 		a.AgreementTimedout, a.ProposalSig, a.ProposalHash, a.ConsumerProposalSig, a.PolicyName, a.CounterPartyAddress,
@@ -110,11 +123,12 @@ func (a Agreement) String() string {
 		a.DisableDataVerificationChecks, a.DataVerifiedTime, a.DataNotificationSent,
 		a.MeteringTokens, a.MeteringPerTimeUnit, a.MeteringNotificationInterval, a.MeteringNotificationSent, a.MeteringNotificationMsgs,
 		a.TerminatedReason, a.TerminatedDescription, a.BlockchainType, a.BlockchainName, a.BlockchainOrg, a.BCUpdateAckTime,
-		a.NHMissingHBInterval, a.NHCheckAgreementStatus, a.Pattern, a.ServiceId, a.ProtocolTimeoutS, a.AgreementTimeoutS)
+		a.NHMissingHBInterval, a.NHCheckAgreementStatus, a.NHUnhealthyServiceInterval, a.NHMaxRestarts, a.Pattern, a.ServiceId, a.ProtocolTimeoutS, a.AgreementTimeoutS, a.Exchange,
+		a.HealthyTime, a.UnhealthyTime)
 }
 
 // Factory method for agreement w/out persistence safety.
-func NewAgreement(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64) (*Agreement, error) {
+func NewAgreement(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64, exchange string) (*Agreement, error) {
 	if agreementid == "" || agreementProto == "" {
 		return nil, errors.New("Illegal input: agreement id or agreement protocol is empty")
 	} else {
@@ -159,16 +173,32 @@ func NewAgreement(agreementid string, org string, deviceid string, deviceType st
 			BCUpdateAckTime:                0,
 			NHMissingHBInterval:            nhPolicy.MissingHBInterval,
 			NHCheckAgreementStatus:         nhPolicy.CheckAgreementStatus,
+			NHUnhealthyServiceInterval:     nhPolicy.UnhealthyServiceInterval,
+			NHMaxRestarts:                  nhPolicy.MaxRestarts,
 			Pattern:                        pattern,
 			ServiceId:                      serviceId,
 			ProtocolTimeoutS:               protocolTimeout,
 			AgreementTimeoutS:              agreementTimeout,
+			Exchange:                       exchange,
 		}, nil
 	}
 }
 
 func (a *Agreement) NodeHealthInUse() bool {
-	return a.NHMissingHBInterval != 0 || a.NHCheckAgreementStatus != 0
+	return a.NHMissingHBInterval != 0 || a.NHCheckAgreementStatus != 0 || a.ServiceHealthInUse()
+}
+
+func (a *Agreement) ServiceHealthInUse() bool {
+	return a.NHUnhealthyServiceInterval != 0
+}
+
+// Return the time since which the node has been seen healthy, zero when it has not been seen healthy since
+// it was last seen unhealthy.
+func (a *Agreement) HealthySince() uint64 {
+	if a.HealthyTime > a.UnhealthyTime {
+		return a.HealthyTime
+	}
+	return 0
 }
 
 func (a *Agreement) GetDeviceType() string {
@@ -229,6 +259,41 @@ func AgreementMade(db AgbotDatabase, agreementId string, counterParty string, si
 	}
 }
 
+func AgreementCapabilities(db AgbotDatabase, agreementId string, protocol string, capabilities []string) (*Agreement, error) {
+	if agreement, err := db.SingleAgreementUpdate(agreementId, protocol, func(a Agreement) *Agreement {
+		a.Capabilities = capabilities
+		return &a
+	}); err != nil {
+		return nil, err
+	} else {
+		return agreement, nil
+	}
+}
+
+func AgreementHealthy(db AgbotDatabase, agreementId string, protocol string) (*Agreement, error) {
+	if agreement, err := db.SingleAgreementUpdate(agreementId, protocol, func(a Agreement) *Agreement {
+		if a.HealthySince() == 0 {
+			a.HealthyTime = uint64(time.Now().Unix())
+		}
+		return &a
+	}); err != nil {
+		return nil, err
+	} else {
+		return agreement, nil
+	}
+}
+
+func AgreementUnhealthy(db AgbotDatabase, agreementId string, protocol string) (*Agreement, error) {
+	if agreement, err := db.SingleAgreementUpdate(agreementId, protocol, func(a Agreement) *Agreement {
+		a.UnhealthyTime = uint64(time.Now().Unix())
+		return &a
+	}); err != nil {
+		return nil, err
+	} else {
+		return agreement, nil
+	}
+}
+
 func AgreementBlockchainUpdate(db AgbotDatabase, agreementId string, consumerSig string, hash string, counterParty string, signature string, protocol string) (*Agreement, error) {
 	if agreement, err := db.SingleAgreementUpdate(agreementId, protocol, func(a Agreement) *Agreement {
 		a.ConsumerProposalSig = consumerSig
@@ -309,6 +374,10 @@ func DataNotification(db AgbotDatabase, agreementid string, protocol string) (*A
 	}
 }
 
+// The maximum number of metering messages kept in the history of an agreement. The amount in a metering message is
+// the total since the agreement started, so the oldest messages are dropped.
+const MAX_METERING_NOTIFICATION_HISTORY = 200
+
 func MeteringNotification(db AgbotDatabase, agreementid string, protocol string, mn string) (*Agreement, error) {
 	if agreement, err := db.SingleAgreementUpdate(agreementid, protocol, func(a Agreement) *Agreement {
 		a.MeteringNotificationSent = uint64(time.Now().Unix())
@@ -317,6 +386,10 @@ func MeteringNotification(db AgbotDatabase, agreementid string, protocol string,
 		}
 		a.MeteringNotificationMsgs[1] = a.MeteringNotificationMsgs[0]
 		a.MeteringNotificationMsgs[0] = mn
+		a.MeteringNotificationHistory = append(a.MeteringNotificationHistory, mn)
+		if len(a.MeteringNotificationHistory) > MAX_METERING_NOTIFICATION_HISTORY {
+			a.MeteringNotificationHistory = a.MeteringNotificationHistory[len(a.MeteringNotificationHistory)-MAX_METERING_NOTIFICATION_HISTORY:]
+		}
 		return &a
 	}); err != nil {
 		return nil, err
@@ -426,6 +499,9 @@ func ValidateStateTransition(mod *Agreement, update *Agreement) {
 	if len(mod.MeteringNotificationMsgs) == 0 || mod.MeteringNotificationMsgs[0] == update.MeteringNotificationMsgs[1] { // msgs must move from new to old in the array
 		mod.MeteringNotificationMsgs = update.MeteringNotificationMsgs
 	}
+	if isNewerHistory(mod.MeteringNotificationHistory, update.MeteringNotificationHistory) { // msgs are only appended to the history
+		mod.MeteringNotificationHistory = update.MeteringNotificationHistory
+	}
 	if !mod.Archived { // 1 transition from false to true
 		mod.Archived = update.Archived
 	}
@@ -450,6 +526,30 @@ func ValidateStateTransition(mod *Agreement, update *Agreement) {
 	if mod.BCUpdateAckTime == 0 { // 1 transition from zero to non-zero
 		mod.BCUpdateAckTime = update.BCUpdateAckTime
 	}
+	if len(mod.Capabilities) == 0 { // 1 transition from empty array to non-empty
+		mod.Capabilities = update.Capabilities
+	}
+	if mod.HealthyTime < update.HealthyTime { // Valid transitions must move forward
+		mod.HealthyTime = update.HealthyTime
+	}
+	if mod.UnhealthyTime < update.UnhealthyTime { // Valid transitions must move forward
+		mod.UnhealthyTime = update.UnhealthyTime
+	}
+}
+
+// Returns true if the update history has msgs appended to the current history. The oldest msgs are dropped from a
+// full history, so the update is newer when it contains the newest msg of the current history before its end.
+func isNewerHistory(current []string, update []string) bool {
+	if len(current) == 0 {
+		return len(update) != 0
+	}
+	newest := current[len(current)-1]
+	for ix := len(update) - 2; ix >= 0; ix-- {
+		if update[ix] == newest {
+			return true
+		}
+	}
+	return false
 }
 
 // Filters used by the caller to control what comes back from the database.
@@ -471,6 +571,40 @@ func DevPolAFilter(deviceId string, policyName string) AFilter {
 	return func(a Agreement) bool { return a.DeviceId == deviceId && a.PolicyName == policyName }
 }
 
+func ExchangeAFilter(exchange string) AFilter {
+	return func(a Agreement) bool { return a.Exchange == exchange }
+}
+
+// The agreements that are neither archived nor being terminated.
+func ActiveAFilter() AFilter {
+	return func(a Agreement) bool { return !a.Archived && a.AgreementTimedout == 0 }
+}
+
+func PolicyNameAFilter(policyName string) AFilter {
+	return func(a Agreement) bool { return a.PolicyName == policyName }
+}
+
+func PatternAFilter(pattern string) AFilter {
+	return func(a Agreement) bool { return a.Pattern == pattern }
+}
+
+// The agreements with the nodes in an org. The device id is in the form org/id.
+func NodeOrgAFilter(org string) AFilter {
+	return func(a Agreement) bool { return strings.HasPrefix(a.DeviceId, org+"/") }
+}
+
+// The agreements that were made at least ageS seconds ago. Agreements that are not yet made are aged from the time
+// the agbot started to negotiate them.
+func OlderThanAFilter(ageS uint64) AFilter {
+	return func(a Agreement) bool {
+		start := a.AgreementCreationTime
+		if start == 0 {
+			start = a.AgreementInceptionTime
+		}
+		return start+ageS <= uint64(time.Now().Unix())
+	}
+}
+
 func RunFilters(ag *Agreement, filters []AFilter) *Agreement {
 	for _, filterFn := range filters {
 		if !filterFn(*ag) {
@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The agbot keeps an audit trail of the decisions it makes about agreements, so that it is possible to find out later
+// why a node did or did not get an agreement for a deployment policy or pattern. Each record describes a single decision.
+// The audit trail is purged periodically so that it does not grow without limit.
+
+// The kinds of decisions recorded in the audit trail.
+const AUDIT_ATTEMPT = "attempt"     // An agreement was proposed to the node.
+const AUDIT_REJECTED = "rejected"   // The node rejected the proposed agreement.
+const AUDIT_CANCELLED = "cancelled" // The agreement was cancelled, the reason code is the termination reason.
+const AUDIT_SKIPPED = "skipped"     // The node was found by the search but no agreement was proposed.
+
+type AuditRecord struct {
+	Id          uint64 `json:"id"`
+	Timestamp   uint64 `json:"timestamp"`
+	Event       string `json:"event"`                  // One of the AUDIT_* decisions
+	DeviceId    string `json:"node"`                   // The org qualified node id
+	PolicyName  string `json:"policy"`                 // The name of the deployment policy or pattern
+	AgreementId string `json:"agreement_id,omitempty"` // Empty when no agreement was proposed
+	ReasonCode  uint   `json:"reason_code,omitempty"`  // The termination reason code of a cancelled agreement
+	Reason      string `json:"reason,omitempty"`
+}
+
+func (r AuditRecord) String() string {
+	return fmt.Sprintf("Id: %v, Timestamp: %v, Event: %v, DeviceId: %v, PolicyName: %v, AgreementId: %v, ReasonCode: %v, Reason: %v",
+		r.Id, r.Timestamp, r.Event, r.DeviceId, r.PolicyName, r.AgreementId, r.ReasonCode, r.Reason)
+}
+
+func NewAuditRecord(event string, deviceId string, policyName string, agreementId string, reasonCode uint, reason string) *AuditRecord {
+	return &AuditRecord{
+		Event:       event,
+		DeviceId:    deviceId,
+		PolicyName:  policyName,
+		AgreementId: agreementId,
+		ReasonCode:  reasonCode,
+		Reason:      reason,
+	}
+}
+
+// Selects the audit records to return. Empty fields do not filter, and a zero Limit returns all the matching records.
+type AuditFilter struct {
+	DeviceId   string
+	PolicyName string
+	Since      uint64
+	Limit      int
+}
+
+func (f AuditFilter) Matches(r *AuditRecord) bool {
+	return (f.DeviceId == "" || r.DeviceId == f.DeviceId) && (f.PolicyName == "" || r.PolicyName == f.PolicyName) && r.Timestamp >= f.Since
+}
+
+// Sort the records with the most recent first and apply the limit of the filter. The id breaks ties between
+// records written in the same second.
+func SortAuditRecords(records []AuditRecord, filter AuditFilter) []AuditRecord {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Timestamp != records[j].Timestamp {
+			return records[i].Timestamp > records[j].Timestamp
+		}
+		return records[i].Id > records[j].Id
+	})
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		return records[:filter.Limit]
+	}
+	return records
+}
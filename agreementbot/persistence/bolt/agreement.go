@@ -21,7 +21,8 @@ const AGREEMENTS = "agreements" // The bolt DB bucket name for agreement objects
 
 // This is the object that represents the handle to the bolt func (db *AgbotBoltDB)
 type AgbotBoltDB struct {
-	db *bolt.DB
+	db        *bolt.DB
+	exchanges string // The federated exchanges served by the agbot.
 }
 
 func (db *AgbotBoltDB) String() string {
@@ -83,8 +84,8 @@ func (db *AgbotBoltDB) FindAgreements(filters []persistence.AFilter, protocol st
 	}
 }
 
-func (db *AgbotBoltDB) AgreementAttempt(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64) error {
-	if agreement, err := persistence.NewAgreement(agreementid, org, deviceid, deviceType, policyName, bcType, bcName, bcOrg, agreementProto, pattern, serviceId, nhPolicy, protocolTimeout, agreementTimeout); err != nil {
+func (db *AgbotBoltDB) AgreementAttempt(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64, exchange string) error {
+	if agreement, err := persistence.NewAgreement(agreementid, org, deviceid, deviceType, policyName, bcType, bcName, bcOrg, agreementProto, pattern, serviceId, nhPolicy, protocolTimeout, agreementTimeout, exchange); err != nil {
 		return err
 	} else if err := db.persistNew(agreement.CurrentAgreementId, bucketName(agreementProto), &agreement); err != nil {
 		return err
@@ -101,6 +102,18 @@ func (db *AgbotBoltDB) AgreementMade(agreementId string, counterParty string, si
 	return persistence.AgreementMade(db, agreementId, counterParty, signature, protocol, hapartners, bcType, bcName, bcOrg)
 }
 
+func (db *AgbotBoltDB) AgreementCapabilities(agreementId string, protocol string, capabilities []string) (*persistence.Agreement, error) {
+	return persistence.AgreementCapabilities(db, agreementId, protocol, capabilities)
+}
+
+func (db *AgbotBoltDB) AgreementHealthy(agreementId string, protocol string) (*persistence.Agreement, error) {
+	return persistence.AgreementHealthy(db, agreementId, protocol)
+}
+
+func (db *AgbotBoltDB) AgreementUnhealthy(agreementId string, protocol string) (*persistence.Agreement, error) {
+	return persistence.AgreementUnhealthy(db, agreementId, protocol)
+}
+
 func (db *AgbotBoltDB) AgreementBlockchainUpdate(agreementId string, consumerSig string, hash string, counterParty string, signature string, protocol string) (*persistence.Agreement, error) {
 	return persistence.AgreementBlockchainUpdate(db, agreementId, consumerSig, hash, counterParty, signature, protocol)
 }
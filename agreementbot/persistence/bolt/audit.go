@@ -0,0 +1,96 @@
+package bolt
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"time"
+)
+
+const AUDIT_BUCKET = "audit" // The bolt DB bucket name for the audit trail.
+
+// The keys are zero padded so that the bucket is iterated in the order the records were written, oldest first.
+func auditKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", id))
+}
+
+func (db *AgbotBoltDB) AddAuditRecord(rec *persistence.AuditRecord) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		if b, err := tx.CreateBucketIfNotExists([]byte(AUDIT_BUCKET)); err != nil {
+			return err
+		} else if nextKey, err := b.NextSequence(); err != nil {
+			return fmt.Errorf("Unable to get sequence key for new audit record %v. Error: %v", rec, err)
+		} else {
+			rec.Id = nextKey
+			if rec.Timestamp == 0 {
+				rec.Timestamp = uint64(time.Now().Unix())
+			}
+			if bytes, err := json.Marshal(rec); err != nil {
+				return fmt.Errorf("Unable to serialize audit record %v. Error: %v", rec, err)
+			} else if err := b.Put(auditKey(nextKey), bytes); err != nil {
+				return fmt.Errorf("Unable to write audit record %v to bucket %v. Error: %v", rec, AUDIT_BUCKET, err)
+			}
+			return nil
+		}
+	})
+}
+
+func (db *AgbotBoltDB) FindAuditRecords(filter persistence.AuditFilter) ([]persistence.AuditRecord, error) {
+	records := make([]persistence.AuditRecord, 0, 10)
+
+	readErr := db.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(AUDIT_BUCKET)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				var r persistence.AuditRecord
+				if err := json.Unmarshal(v, &r); err != nil {
+					glog.Errorf("Unable to deserialize audit record: %v", v)
+				} else if filter.Matches(&r) {
+					records = append(records, r)
+				}
+				return nil
+			})
+		}
+		return nil // end the transaction
+	})
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return persistence.SortAuditRecords(records, filter), nil
+}
+
+// Remove the records older than ageS seconds, and then the oldest records until there are no more than maxRecords.
+// A zero ageS or maxRecords does not limit the audit trail by age or size.
+func (db *AgbotBoltDB) PurgeAuditRecords(ageS uint64, maxRecords int) error {
+	cutoff := uint64(0)
+	if ageS != 0 {
+		cutoff = uint64(time.Now().Unix()) - ageS
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(AUDIT_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		excess := 0
+		if maxRecords > 0 {
+			excess = b.Stats().KeyN - maxRecords
+		}
+
+		// The bucket is in write order, so stop at the first record that is new enough and within the size limit.
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			var r persistence.AuditRecord
+			if err := json.Unmarshal(v, &r); err == nil && r.Timestamp >= cutoff && excess <= 0 {
+				break
+			} else if err := c.Delete(); err != nil {
+				return fmt.Errorf("Unable to delete audit record %v. Error: %v", string(k), err)
+			}
+			excess -= 1
+		}
+		return nil
+	})
+}
@@ -24,6 +24,7 @@ func (db *AgbotBoltDB) Initialize(cfg *config.HorizonConfig) error {
 		return errors.New(fmt.Sprintf("unable to open bolt database %v, error: %v", dbname, err))
 	} else {
 		db.db = agdb
+		db.exchanges = cfg.GetAgbotServedExchanges()
 
 	}
 
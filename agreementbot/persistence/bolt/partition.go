@@ -1,6 +1,9 @@
 package bolt
 
-import ()
+import (
+	"errors"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+)
 
 // Functions related to partitions in the bolt database. It does not use partitions, or rather has only 1 global partition.
 func (db *AgbotBoltDB) FindPartitions() ([]string, error) {
@@ -32,3 +35,44 @@ func (db *AgbotBoltDB) GetPartitionOwner(id string) (string, error) {
 func (db *AgbotBoltDB) MovePartition(timeout uint64) (bool, error) {
 	return false, nil
 }
+
+func (db *AgbotBoltDB) GetPartitionExchanges(id string) (string, error) {
+	return db.exchanges, nil
+}
+
+func (db *AgbotBoltDB) PrimaryPartition() string {
+	return "global"
+}
+
+// The bolt database is used by a single agbot, so there is never a leader and partitions are never rebalanced.
+func (db *AgbotBoltDB) ClaimPartitionLeader(timeout uint64) (bool, error) {
+	return false, nil
+}
+
+func (db *AgbotBoltDB) GetPartitionLeader() (string, error) {
+	return "", nil
+}
+
+func (db *AgbotBoltDB) ReleasePartitionLeader() error {
+	return nil
+}
+
+func (db *AgbotBoltDB) ProposePartitionMove(fromPartition string, toPartition string, agreements int64) (*persistence.PartitionMove, error) {
+	return nil, errors.New("partition moves are not supported by the bolt database")
+}
+
+func (db *AgbotBoltDB) FindPartitionMoves(inflightOnly bool) ([]persistence.PartitionMove, error) {
+	return []persistence.PartitionMove{}, nil
+}
+
+func (db *AgbotBoltDB) UpdatePartitionMove(id int, state string, reason string) error {
+	return errors.New("partition moves are not supported by the bolt database")
+}
+
+func (db *AgbotBoltDB) MovePartitionAgreements(move *persistence.PartitionMove) (int64, error) {
+	return 0, errors.New("partition moves are not supported by the bolt database")
+}
+
+func (db *AgbotBoltDB) PurgePartitionMoves(ageS uint64) error {
+	return nil
+}
@@ -6,13 +6,14 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/policy"
 	"strconv"
 )
 
 const WORKLOAD_USAGE = "workload_usage"
 
-func (db *AgbotBoltDB) NewWorkloadUsage(deviceId string, hapartners []string, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string) error {
-	if wlUsage, err := persistence.NewWorkloadUsage(deviceId, hapartners, policy, policyName, priority, retryDurationS, verifiedDurationS, reqsNotMet, agid); err != nil {
+func (db *AgbotBoltDB) NewWorkloadUsage(deviceId string, haGroup *policy.HighAvailabilityGroup, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string, exchange string) error {
+	if wlUsage, err := persistence.NewWorkloadUsage(deviceId, haGroup, policy, policyName, priority, retryDurationS, verifiedDurationS, reqsNotMet, agid, exchange); err != nil {
 		return err
 	} else if existing, err := db.FindSingleWorkloadUsageByDeviceAndPolicyName(deviceId, policyName); err != nil {
 		return err
@@ -23,7 +23,19 @@ type AgbotDatabase interface {
 	GetHeartbeat() (uint64, error)
 	QuiescePartition() error
 	GetPartitionOwner(id string) (string, error)
+	GetPartitionExchanges(id string) (string, error)
 	MovePartition(timeout uint64) (bool, error)
+	PrimaryPartition() string
+
+	// Partition rebalancing related functions.
+	ClaimPartitionLeader(timeout uint64) (bool, error)
+	GetPartitionLeader() (string, error)
+	ReleasePartitionLeader() error
+	ProposePartitionMove(fromPartition string, toPartition string, agreements int64) (*PartitionMove, error)
+	FindPartitionMoves(inflightOnly bool) ([]PartitionMove, error)
+	UpdatePartitionMove(id int, state string, reason string) error
+	MovePartitionAgreements(move *PartitionMove) (int64, error)
+	PurgePartitionMoves(ageS uint64) error
 
 	// Persistent agreement related functions
 	FindAgreements(filters []AFilter, protocol string) ([]Agreement, error)
@@ -34,10 +46,13 @@ type AgbotDatabase interface {
 
 	SingleAgreementUpdate(agreementid string, protocol string, fn func(Agreement) *Agreement) (*Agreement, error)
 
-	AgreementAttempt(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64) error
+	AgreementAttempt(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64, exchange string) error
 	AgreementFinalized(agreementid string, protocol string) (*Agreement, error)
 	AgreementUpdate(agreementid string, proposal string, policy string, dvPolicy policy.DataVerification, defaultCheckRate uint64, hash string, sig string, protocol string, agreementProtoVersion int) (*Agreement, error)
 	AgreementMade(agreementId string, counterParty string, signature string, protocol string, hapartners []string, bcType string, bcName string, bcOrg string) (*Agreement, error)
+	AgreementCapabilities(agreementId string, protocol string, capabilities []string) (*Agreement, error)
+	AgreementHealthy(agreementId string, protocol string) (*Agreement, error)
+	AgreementUnhealthy(agreementId string, protocol string) (*Agreement, error)
 	AgreementBlockchainUpdate(agreementId string, consumerSig string, hash string, counterParty string, signature string, protocol string) (*Agreement, error)
 	AgreementBlockchainUpdateAck(agreementId string, protocol string) (*Agreement, error)
 	AgreementTimedout(agreementid string, protocol string) (*Agreement, error)
@@ -50,8 +65,13 @@ type AgbotDatabase interface {
 	DeleteAgreement(pk string, protocol string) error
 	ArchiveAgreement(agreementid string, protocol string, reason uint, desc string) (*Agreement, error)
 
+	// Audit trail related functions
+	AddAuditRecord(rec *AuditRecord) error
+	FindAuditRecords(filter AuditFilter) ([]AuditRecord, error)
+	PurgeAuditRecords(ageS uint64, maxRecords int) error
+
 	// Workoad usage related functions
-	NewWorkloadUsage(deviceId string, hapartners []string, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string) error
+	NewWorkloadUsage(deviceId string, haGroup *policy.HighAvailabilityGroup, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string, exchange string) error
 	FindSingleWorkloadUsageByDeviceAndPolicyName(deviceid string, policyName string) (*WorkloadUsage, error)
 	FindWorkloadUsages(filters []WUFilter) ([]WorkloadUsage, error)
 
@@ -0,0 +1,153 @@
+package persistence
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/policy"
+)
+
+// When the agbot serves more than one exchange, the workers of each exchange share the agbot's database. The exchange
+// database wraps the shared database handle so that the workers of an exchange only see the agreements and workload
+// usages of the nodes in their own exchange. The workload usages are keyed by device and policy name in the shared
+// database, so a workload usage of another exchange is neither found nor changed through the exchange database. The
+// search sessions of each exchange are kept apart by qualifying the
+// policy name with the exchange name, because the same org and policy name can exist in more than one exchange. All
+// other functions are passed through to the shared database.
+type ExchangeDB struct {
+	AgbotDatabase
+	Exchange string // The name of the federated exchange, empty for the agbot's primary exchange.
+}
+
+func NewExchangeDB(db AgbotDatabase, exchange string) *ExchangeDB {
+	return &ExchangeDB{
+		AgbotDatabase: db,
+		Exchange:      exchange,
+	}
+}
+
+func (db *ExchangeDB) String() string {
+	return fmt.Sprintf("Exchange: %v, DB: %v", db.Exchange, db.AgbotDatabase)
+}
+
+// Returns a copy of the filters with the exchange filter added, so that the caller's slice is never modified.
+func (db *ExchangeDB) agreementFilters(filters []AFilter) []AFilter {
+	return append(append(make([]AFilter, 0, len(filters)+1), filters...), ExchangeAFilter(db.Exchange))
+}
+
+func (db *ExchangeDB) FindAgreements(filters []AFilter, protocol string) ([]Agreement, error) {
+	return db.AgbotDatabase.FindAgreements(db.agreementFilters(filters), protocol)
+}
+
+func (db *ExchangeDB) FindSingleAgreementByAgreementId(agreementid string, protocol string, filters []AFilter) (*Agreement, error) {
+	return db.AgbotDatabase.FindSingleAgreementByAgreementId(agreementid, protocol, db.agreementFilters(filters))
+}
+
+func (db *ExchangeDB) FindSingleAgreementByAgreementIdAllProtocols(agreementid string, protocols []string, filters []AFilter) (*Agreement, error) {
+	return db.AgbotDatabase.FindSingleAgreementByAgreementIdAllProtocols(agreementid, protocols, db.agreementFilters(filters))
+}
+
+func (db *ExchangeDB) FindSingleWorkloadUsageByDeviceAndPolicyName(deviceid string, policyName string) (*WorkloadUsage, error) {
+	if wlUsage, err := db.AgbotDatabase.FindSingleWorkloadUsageByDeviceAndPolicyName(deviceid, policyName); err != nil {
+		return nil, err
+	} else if wlUsage == nil || wlUsage.Exchange != db.Exchange {
+		return nil, nil
+	} else {
+		return wlUsage, nil
+	}
+}
+
+func (db *ExchangeDB) FindWorkloadUsages(filters []WUFilter) ([]WorkloadUsage, error) {
+	return db.AgbotDatabase.FindWorkloadUsages(append(append(make([]WUFilter, 0, len(filters)+1), filters...), ExchangeWUFilter(db.Exchange)))
+}
+
+// Returns an error when the exchange does not have a workload usage for the device and policy, so that the workload
+// usage of a device with the same id in another exchange is never changed.
+func (db *ExchangeDB) checkWorkloadUsage(deviceid string, policyName string) error {
+	if wlUsage, err := db.FindSingleWorkloadUsageByDeviceAndPolicyName(deviceid, policyName); err != nil {
+		return err
+	} else if wlUsage == nil {
+		return fmt.Errorf("Unable to locate workload usage for device: %v, and policy: %v, in exchange: %v", deviceid, policyName, db.Exchange)
+	}
+	return nil
+}
+
+// The workload usage is always created in this exchange.
+func (db *ExchangeDB) NewWorkloadUsage(deviceId string, haGroup *policy.HighAvailabilityGroup, pol string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string, exchange string) error {
+	return db.AgbotDatabase.NewWorkloadUsage(deviceId, haGroup, pol, policyName, priority, retryDurationS, verifiedDurationS, reqsNotMet, agid, db.Exchange)
+}
+
+func (db *ExchangeDB) SingleWorkloadUsageUpdate(deviceid string, policyName string, fn func(WorkloadUsage) *WorkloadUsage) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.SingleWorkloadUsageUpdate(deviceid, policyName, fn)
+}
+
+func (db *ExchangeDB) UpdatePendingUpgrade(deviceid string, policyName string) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.UpdatePendingUpgrade(deviceid, policyName)
+}
+
+func (db *ExchangeDB) UpdatePriority(deviceid string, policyName string, priority int, retryDurationS int, verifiedDurationS int, agid string) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.UpdatePriority(deviceid, policyName, priority, retryDurationS, verifiedDurationS, agid)
+}
+
+func (db *ExchangeDB) UpdateRetryCount(deviceid string, policyName string, retryCount int, agid string) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.UpdateRetryCount(deviceid, policyName, retryCount, agid)
+}
+
+func (db *ExchangeDB) UpdatePolicy(deviceid string, policyName string, pol string) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.UpdatePolicy(deviceid, policyName, pol)
+}
+
+func (db *ExchangeDB) UpdateWUAgreementId(deviceid string, policyName string, agid string, protocol string) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.UpdateWUAgreementId(deviceid, policyName, agid, protocol)
+}
+
+func (db *ExchangeDB) DisableRollbackChecking(deviceid string, policyName string) (*WorkloadUsage, error) {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return nil, err
+	}
+	return db.AgbotDatabase.DisableRollbackChecking(deviceid, policyName)
+}
+
+func (db *ExchangeDB) DeleteWorkloadUsage(deviceid string, policyName string) error {
+	if err := db.checkWorkloadUsage(deviceid, policyName); err != nil {
+		return err
+	}
+	return db.AgbotDatabase.DeleteWorkloadUsage(deviceid, policyName)
+}
+
+// Returns the name of the search session for a policy in this exchange. The sessions of the primary exchange keep the
+// plain policy name so that the sessions persisted before federation was configured are still used.
+func (db *ExchangeDB) sessionName(policyName string) string {
+	if db.Exchange == "" {
+		return policyName
+	}
+	return db.Exchange + ":" + policyName
+}
+
+func (db *ExchangeDB) ObtainSearchSession(policyName string) (string, uint64, error) {
+	return db.AgbotDatabase.ObtainSearchSession(db.sessionName(policyName))
+}
+
+func (db *ExchangeDB) UpdateSearchSessionChangedSince(currentChangedSince uint64, newChangedSince uint64, policyName string) (bool, error) {
+	return db.AgbotDatabase.UpdateSearchSessionChangedSince(currentChangedSince, newChangedSince, db.sessionName(policyName))
+}
+
+func (db *ExchangeDB) ResetPolicyChangedSince(policy string, newChangedSince uint64) error {
+	return db.AgbotDatabase.ResetPolicyChangedSince(db.sessionName(policy), newChangedSince)
+}
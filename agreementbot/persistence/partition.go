@@ -0,0 +1,81 @@
+package persistence
+
+import (
+	"fmt"
+	"sort"
+)
+
+// When agbots are horizontally scaled, each agbot owns a partition of the agreements and workload usages in the database.
+// A partition move is a request, made by the elected leader among the agbots, to hand off some of the agreements in one
+// partition to another partition so that the load is spread across all the agbots. The owner of the source partition
+// drains its work queues before moving the records, and the owner of the target partition picks up the moved agreements
+// once the records are in its partition.
+
+// The states of a partition move.
+const PARTITION_MOVE_PROPOSED = "proposed" // The leader has proposed the move.
+const PARTITION_MOVE_DRAINING = "draining" // The owner of the source partition is draining its work queues.
+const PARTITION_MOVE_MOVED = "moved"       // The records are in the target partition, waiting for its owner to pick them up.
+const PARTITION_MOVE_DONE = "done"         // The owner of the target partition has picked up the moved agreements.
+const PARTITION_MOVE_FAILED = "failed"     // The move was abandoned.
+
+type PartitionMove struct {
+	Id            int    `json:"id"`
+	FromPartition string `json:"from_partition"`
+	ToPartition   string `json:"to_partition"`
+	Agreements    int64  `json:"agreements"`       // The number of active agreements the leader asked to move
+	Moved         int64  `json:"moved"`            // The number of active agreements that were actually moved
+	State         string `json:"state"`            // One of the PARTITION_MOVE_* states
+	Reason        string `json:"reason,omitempty"` // Why the move failed
+	Created       uint64 `json:"created"`
+	Updated       uint64 `json:"updated"`
+}
+
+func (m PartitionMove) String() string {
+	return fmt.Sprintf("Id: %v, From: %v, To: %v, Agreements: %v, Moved: %v, State: %v, Reason: %v, Created: %v, Updated: %v",
+		m.Id, m.FromPartition, m.ToPartition, m.Agreements, m.Moved, m.State, m.Reason, m.Created, m.Updated)
+}
+
+// Returns true when the move has not yet reached a final state.
+func (m PartitionMove) IsInflight() bool {
+	return m.State == PARTITION_MOVE_PROPOSED || m.State == PARTITION_MOVE_DRAINING || m.State == PARTITION_MOVE_MOVED
+}
+
+// Choose the devices whose records will be moved out of a partition, given all the agreements in that partition. Agreements
+// are moved by device so that the agreements and workload usages of a device always stay in the same partition. A device
+// is only moved when all of its active agreements are finalized, because the protocol messages for an agreement that is
+// still being negotiated are in flight to the current owner. Devices in an HA group are not moved because the HA upgrade
+// of the group relies on all the members being in the same partition. Devices are chosen in id order until at least count
+// active agreements are covered. The chosen devices and the number of active agreements they have are returned.
+func SelectDevicesToMove(ags []Agreement, count int64) ([]string, int64) {
+
+	active := make(map[string]int64)
+	ineligible := make(map[string]bool)
+	for _, ag := range ags {
+		if ag.Archived {
+			continue
+		}
+		active[ag.DeviceId] += 1
+		if ag.AgreementFinalizedTime == 0 || ag.AgreementTimedout != 0 || len(ag.HAPartners) != 0 {
+			ineligible[ag.DeviceId] = true
+		}
+	}
+
+	ids := make([]string, 0, len(active))
+	for id := range active {
+		if !ineligible[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	devices := make([]string, 0, 10)
+	moved := int64(0)
+	for _, id := range ids {
+		if moved >= count {
+			break
+		}
+		devices = append(devices, id)
+		moved += active[id]
+	}
+	return devices, moved
+}
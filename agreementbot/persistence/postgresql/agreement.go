@@ -117,6 +117,14 @@ const AGREEMENT_MOVE = `WITH moved_rows AS (
 INSERT INTO "agreements_ (agreement_id, protocol, partition, agreement) SELECT agreement_id, protocol, 'partition_name', agreement FROM moved_rows;
 `
 
+// Move the agreements of a set of devices, used when partitions are rebalanced.
+const AGREEMENT_DEVICES_MOVE = `WITH moved_rows AS (
+    DELETE FROM "agreements_ a WHERE a.agreement->>'device_id' = ANY($1)
+    RETURNING a.agreement_id, a.protocol, a.agreement
+)
+INSERT INTO "agreements_ (agreement_id, protocol, partition, agreement) SELECT agreement_id, protocol, 'partition_name', agreement FROM moved_rows;
+`
+
 const AGREEMENT_PARTITIONS = `SELECT partition FROM agreements;`
 
 const AGREEMENT_DROP_PARTITION = `DROP TABLE "agreements_;`
@@ -127,6 +135,7 @@ type AgbotPostgresqlDB struct {
 	db               *sql.DB  // A handle to the underlying database.
 	primaryPartition string   // The partition to use when creating new agreements.
 	partitions       []string // The list of partitions this agbot is responsible to maintain.
+	exchanges        string   // The federated exchanges served by this agbot, partitions are only claimed from agbots serving the same exchanges.
 }
 
 func (db *AgbotPostgresqlDB) String() string {
@@ -181,6 +190,14 @@ func (db *AgbotPostgresqlDB) GetAgreementPartitionMove(fromPartition string, toP
 	return sql
 }
 
+// The same replacement scheme as GetAgreementPartitionMove, for moving only the agreements of some devices.
+func (db *AgbotPostgresqlDB) GetAgreementDevicesMove(fromPartition string, toPartition string) string {
+	sql := strings.Replace(AGREEMENT_DEVICES_MOVE, AGREEMENT_TABLE_NAME_ROOT, db.GetAgreementPartitionTableName(toPartition), 2)
+	sql = strings.Replace(sql, db.GetAgreementPartitionTableName(toPartition), db.GetAgreementPartitionTableName(fromPartition), 1)
+	sql = strings.Replace(sql, AGREEMENT_PARTITION_FILLIN, toPartition, 1)
+	return sql
+}
+
 func (db *AgbotPostgresqlDB) FindAgreementPartitions() ([]string, error) {
 
 	// Find all the agreement partitions.
@@ -354,8 +371,8 @@ func (db *AgbotPostgresqlDB) FindSingleAgreementByAgreementIdAllProtocols(agreem
 	return nil, nil
 }
 
-func (db *AgbotPostgresqlDB) AgreementAttempt(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64) error {
-	if agreement, err := persistence.NewAgreement(agreementid, org, deviceid, deviceType, policyName, bcType, bcName, bcOrg, agreementProto, pattern, serviceId, nhPolicy, protocolTimeout, agreementTimeout); err != nil {
+func (db *AgbotPostgresqlDB) AgreementAttempt(agreementid string, org string, deviceid string, deviceType string, policyName string, bcType string, bcName string, bcOrg string, agreementProto string, pattern string, serviceId []string, nhPolicy policy.NodeHealth, protocolTimeout uint64, agreementTimeout uint64, exchange string) error {
+	if agreement, err := persistence.NewAgreement(agreementid, org, deviceid, deviceType, policyName, bcType, bcName, bcOrg, agreementProto, pattern, serviceId, nhPolicy, protocolTimeout, agreementTimeout, exchange); err != nil {
 		return err
 	} else if err := db.insertAgreement(agreement, agreementProto); err != nil {
 		return err
@@ -376,6 +393,18 @@ func (db *AgbotPostgresqlDB) AgreementMade(agreementId string, counterParty stri
 	return persistence.AgreementMade(db, agreementId, counterParty, signature, protocol, hapartners, bcType, bcName, bcOrg)
 }
 
+func (db *AgbotPostgresqlDB) AgreementCapabilities(agreementId string, protocol string, capabilities []string) (*persistence.Agreement, error) {
+	return persistence.AgreementCapabilities(db, agreementId, protocol, capabilities)
+}
+
+func (db *AgbotPostgresqlDB) AgreementHealthy(agreementId string, protocol string) (*persistence.Agreement, error) {
+	return persistence.AgreementHealthy(db, agreementId, protocol)
+}
+
+func (db *AgbotPostgresqlDB) AgreementUnhealthy(agreementId string, protocol string) (*persistence.Agreement, error) {
+	return persistence.AgreementUnhealthy(db, agreementId, protocol)
+}
+
 func (db *AgbotPostgresqlDB) AgreementTimedout(agreementid string, protocol string) (*persistence.Agreement, error) {
 	return persistence.AgreementTimedout(db, agreementid, protocol)
 }
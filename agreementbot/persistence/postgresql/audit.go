@@ -0,0 +1,108 @@
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+)
+
+// Constants for the SQL statements that are used to maintain the audit trail of agreement decisions. The audit trail is
+// shared by all the agbots, it is not partitioned, so that a decision can be found no matter which agbot made it.
+//
+// agbot_audit schema:
+// id:           The record id, serially incremented by the database.
+// timestamp:    A timestamp to record when the decision was made.
+// event:        The decision, one of the AUDIT_* constants in the persistence package.
+// device_id:    The org qualified node id.
+// policy_name:  The name of the deployment policy or pattern.
+// agreement_id: The agreement id, empty when no agreement was proposed.
+// reason_code:  The termination reason code of a cancelled agreement.
+// reason:       Why the decision was made.
+//
+
+const AUDIT_CREATE_TABLE = `CREATE TABLE IF NOT EXISTS agbot_audit (
+	id BIGSERIAL PRIMARY KEY,
+	timestamp timestamp with time zone DEFAULT current_timestamp,
+	event text NOT NULL,
+	device_id text NOT NULL,
+	policy_name text NOT NULL,
+	agreement_id text NOT NULL DEFAULT '',
+	reason_code bigint NOT NULL DEFAULT 0,
+	reason text NOT NULL DEFAULT ''
+);`
+
+const AUDIT_CREATE_DEVICE_INDEX = `CREATE INDEX IF NOT EXISTS agbot_audit_device_idx ON agbot_audit (device_id, timestamp);`
+
+const AUDIT_CREATE_TIMESTAMP_INDEX = `CREATE INDEX IF NOT EXISTS agbot_audit_timestamp_idx ON agbot_audit (timestamp);`
+
+const AUDIT_INSERT = `INSERT INTO agbot_audit (event, device_id, policy_name, agreement_id, reason_code, reason) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, EXTRACT (EPOCH FROM timestamp);`
+
+// Empty filter values match every record, and a limit of NULL returns all the matching records.
+const AUDIT_QUERY = `SELECT id, EXTRACT (EPOCH FROM timestamp), event, device_id, policy_name, agreement_id, reason_code, reason FROM agbot_audit
+	WHERE ($1 = '' OR device_id = $1) AND ($2 = '' OR policy_name = $2) AND timestamp >= to_timestamp($3)
+	ORDER BY timestamp DESC, id DESC LIMIT $4;`
+
+const AUDIT_PURGE_AGE = `DELETE FROM agbot_audit WHERE (SELECT EXTRACT ('epoch' FROM (SELECT AGE(current_timestamp, timestamp)))) > $1;`
+
+const AUDIT_PURGE_SIZE = `DELETE FROM agbot_audit WHERE id <= (SELECT id FROM agbot_audit ORDER BY id DESC OFFSET $1 LIMIT 1);`
+
+func (db *AgbotPostgresqlDB) AddAuditRecord(rec *persistence.AuditRecord) error {
+
+	var timestamp float64
+	if err := db.db.QueryRow(AUDIT_INSERT, rec.Event, rec.DeviceId, rec.PolicyName, rec.AgreementId, rec.ReasonCode, rec.Reason).Scan(&rec.Id, &timestamp); err != nil {
+		return errors.New(fmt.Sprintf("unable to insert audit record %v, error: %v", rec, err))
+	}
+	rec.Timestamp = uint64(timestamp)
+	return nil
+}
+
+func (db *AgbotPostgresqlDB) FindAuditRecords(filter persistence.AuditFilter) ([]persistence.AuditRecord, error) {
+
+	var limit interface{}
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+
+	rows, err := db.db.Query(AUDIT_QUERY, filter.DeviceId, filter.PolicyName, filter.Since, limit)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error querying for audit records, error: %v", err))
+	}
+
+	// If the rows object doesnt get closed, memory and connections will grow and/or leak.
+	defer rows.Close()
+
+	records := make([]persistence.AuditRecord, 0, 10)
+	for rows.Next() {
+		var r persistence.AuditRecord
+		var timestamp float64
+		if err := rows.Scan(&r.Id, &timestamp, &r.Event, &r.DeviceId, &r.PolicyName, &r.AgreementId, &r.ReasonCode, &r.Reason); err != nil {
+			return nil, errors.New(fmt.Sprintf("error scanning audit record row: %v", err))
+		}
+		r.Timestamp = uint64(timestamp)
+		records = append(records, r)
+	}
+
+	// The rows.Next() function will exit with false when done or an error occurred. Get any error encountered during iteration.
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("error iterating audit records: %v", err))
+	}
+
+	return records, nil
+}
+
+// Remove the records older than ageS seconds, and then the oldest records until there are no more than maxRecords.
+// A zero ageS or maxRecords does not limit the audit trail by age or size.
+func (db *AgbotPostgresqlDB) PurgeAuditRecords(ageS uint64, maxRecords int) error {
+
+	if ageS != 0 {
+		if _, err := db.db.Exec(AUDIT_PURGE_AGE, ageS); err != nil {
+			return errors.New(fmt.Sprintf("unable to purge audit records by age, error: %v", err))
+		}
+	}
+	if maxRecords > 0 {
+		if _, err := db.db.Exec(AUDIT_PURGE_SIZE, maxRecords); err != nil {
+			return errors.New(fmt.Sprintf("unable to purge audit records by size, error: %v", err))
+		}
+	}
+	return nil
+}
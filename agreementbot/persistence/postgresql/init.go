@@ -37,6 +37,7 @@ func (db *AgbotPostgresqlDB) Initialize(cfg *config.HorizonConfig) error {
 		} else {
 			db.identity = id.String()
 		}
+		db.exchanges = cfg.GetAgbotServedExchanges()
 		glog.V(1).Infof("Agreementbot %v initializing partitions", db.identity)
 
 		// Now create the tables and initialize them as necessary.
@@ -61,10 +62,28 @@ func (db *AgbotPostgresqlDB) Initialize(cfg *config.HorizonConfig) error {
 		// Create the partition tables and create the postgresql procedure that manages the table.
 		if _, err := db.db.Exec(PARTITION_CREATE_MAIN_TABLE); err != nil {
 			return errors.New(fmt.Sprintf("unable to create partition table, error: %v", err))
+		} else if _, err := db.db.Exec(PARTITION_ADD_EXCHANGES); err != nil {
+			return errors.New(fmt.Sprintf("unable to add exchanges to partition table, error: %v", err))
 		} else if _, err := db.db.Exec(PARTITION_CLAIM_UNOWNED_FUNCTION); err != nil {
 			return errors.New(fmt.Sprintf("unable to create claim unowned partition function, error: %v", err))
 		}
 
+		// Create the tables used to rebalance partitions across agbots.
+		if _, err := db.db.Exec(PARTITION_LEADER_CREATE_TABLE); err != nil {
+			return errors.New(fmt.Sprintf("unable to create partition leader table, error: %v", err))
+		} else if _, err := db.db.Exec(PARTITION_MOVES_CREATE_TABLE); err != nil {
+			return errors.New(fmt.Sprintf("unable to create partition moves table, error: %v", err))
+		}
+
+		// Create the audit trail table and its indexes.
+		if _, err := db.db.Exec(AUDIT_CREATE_TABLE); err != nil {
+			return errors.New(fmt.Sprintf("unable to create audit table, error: %v", err))
+		} else if _, err := db.db.Exec(AUDIT_CREATE_DEVICE_INDEX); err != nil {
+			return errors.New(fmt.Sprintf("unable to create audit table device index, error: %v", err))
+		} else if _, err := db.db.Exec(AUDIT_CREATE_TIMESTAMP_INDEX); err != nil {
+			return errors.New(fmt.Sprintf("unable to create audit table timestamp index, error: %v", err))
+		}
+
 		// Claim a partition for ourselves.
 		if partition, err := db.ClaimPartition(cfg.GetPartitionStale()); err != nil {
 			return errors.New(fmt.Sprintf("unable to claim a partition, error: %v", err))
@@ -28,17 +28,28 @@ import (
 //            available to be taken over immediately.
 // heartbeat: A timestamp to record last heartbeat time. If the owning agbot stops heartbeating, the partition becomes eligible to
 //            be taken over by another agbot.
+// exchanges: The sorted, comma separated names of the federated exchanges served by the agbot that created the partition, empty
+//            when the agbot serves only its primary exchange. A partition can only be taken over by an agbot that serves the
+//            same exchanges, because the partition contains agreements with the nodes of those exchanges.
 //
 
 const PARTITION_CREATE_MAIN_TABLE = `CREATE TABLE IF NOT EXISTS partitions (
 	id SERIAL PRIMARY KEY,
 	owner text,
-	heartbeat timestamp with time zone
+	heartbeat timestamp with time zone,
+	exchanges text NOT NULL DEFAULT ''
 );`
 
+// Partitions created before federated exchanges were supported do not have the exchanges column.
+const PARTITION_ADD_EXCHANGES = `ALTER TABLE partitions ADD COLUMN IF NOT EXISTS exchanges text NOT NULL DEFAULT '';`
+
+const PARTITION_EXCHANGES = `SELECT exchanges FROM partitions WHERE id = $1;`
+
 const PARTITION_OWNER = `SELECT owner FROM partitions WHERE id = $1;`
 
-const PARTITION_INSERT = `INSERT INTO partitions (owner, heartbeat) VALUES ($1,current_timestamp) RETURNING id, owner;`
+const PARTITION_ALL = `SELECT id FROM partitions;`
+
+const PARTITION_INSERT = `INSERT INTO partitions (owner, heartbeat, exchanges) VALUES ($1,current_timestamp,$2) RETURNING id, owner;`
 
 const PARTITION_HEARTBEAT = `UPDATE partitions SET heartbeat = current_timestamp WHERE id = $1 AND owner = $2;`
 
@@ -54,7 +65,8 @@ const PARTITION_DELETE = `DELETE FROM partitions WHERE id = $1;`
 const PARTITION_CLAIM_UNOWNED_FUNCTION = `
 CREATE OR REPLACE FUNCTION claim_ownerless(
 	new_owner CHARACTER VARYING,
-	timeout int)
+	timeout int,
+	served_exchanges text)
 	RETURNS TABLE (
 		retId int,
 		retOwner text
@@ -66,18 +78,21 @@ UPDATE partitions SET owner = new_owner, heartbeat = current_timestamp
 	WHERE id = (
 		SELECT id FROM partitions
 			WHERE
+				exchanges = served_exchanges
+				AND (
 				(owner IS NULL AND heartbeat IS NULL)
 				OR
 				(owner IS NOT NULL AND (
 					SELECT EXTRACT ('epoch' FROM (SELECT AGE(current_timestamp, heartbeat)))
 				) > timeout)
+				)
 			LIMIT 1
 			FOR UPDATE
 		)
 	RETURNING id, owner;
 END $$ LANGUAGE plpgsql;
 `
-const PARTITION_CLAIM_UNOWNED_BY_FUNCTION = `SELECT * FROM claim_ownerless($1, $2);`
+const PARTITION_CLAIM_UNOWNED_BY_FUNCTION = `SELECT * FROM claim_ownerless($1, $2, $3);`
 
 // Functions related to partitions in the postgresql database. The workload usages should always be using the same partitions
 // as the agreements, or fewer partitions if an agreement partition contains only archived records.
@@ -93,7 +108,7 @@ func (db *AgbotPostgresqlDB) ClaimPartition(timeout uint64) (string, error) {
 			// There were no claimable partitions, so create a new partition.
 			var id string
 			var rowowner sql.NullString
-			if err := db.db.QueryRow(PARTITION_INSERT, db.identity).Scan(&id, &rowowner); err != nil {
+			if err := db.db.QueryRow(PARTITION_INSERT, db.identity, db.exchanges).Scan(&id, &rowowner); err != nil {
 				return "", errors.New(fmt.Sprintf("AgreementBot %v unable to insert new partition, error: %v", rowowner, err))
 			} else {
 				glog.V(5).Infof("AgreementBot %v creating new partition %v", rowowner, id)
@@ -116,7 +131,7 @@ func (db *AgbotPostgresqlDB) findUnownedPartition(timeout uint64) (string, error
 		}
 		defer tx.Rollback()
 
-		if err := tx.QueryRow(PARTITION_CLAIM_UNOWNED_BY_FUNCTION, db.identity, timeout).Scan(&id, &rowowner); err != nil && err != sql.ErrNoRows {
+		if err := tx.QueryRow(PARTITION_CLAIM_UNOWNED_BY_FUNCTION, db.identity, timeout, db.exchanges).Scan(&id, &rowowner); err != nil && err != sql.ErrNoRows {
 			return "", errors.New(fmt.Sprintf("unable to claim stale, error: %v", err))
 		} else if err == nil {
 			// Nothing to do, we claimed a previously unowned row.
@@ -149,15 +164,54 @@ func (db *AgbotPostgresqlDB) findUnownedPartition(timeout uint64) (string, error
 	}
 }
 
-// Locate all the partitions currently found in the database, for all agbots.
+// Locate all the partitions currently found in the database, for all agbots. This includes the partitions that have no
+// agreements yet, such as the partition of an agbot that just started, as long as their tables exist.
 func (db *AgbotPostgresqlDB) FindPartitions() ([]string, error) {
 
-	if allPartitions, err := db.FindAgreementPartitions(); err != nil {
+	allPartitions, err := db.FindAgreementPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	partitions := make([]string, 0, 10)
+	for _, p := range allPartitions {
+		if !found[p] {
+			found[p] = true
+			partitions = append(partitions, p)
+		}
+	}
+
+	rows, err := db.db.Query(PARTITION_ALL)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error querying for partitions: %v", err))
+	}
+
+	// If the rows object doesnt get closed, memory and connections will grow and/or leak.
+	defer rows.Close()
+	empty := make([]string, 0, 5)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.New(fmt.Sprintf("error scanning partition row: %v", err))
+		} else if !found[id] {
+			empty = append(empty, id)
+		}
+	}
+
+	// The rows.Next() function will exit with false when done or an error occurred. Get any error encountered during iteration.
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("error iterating partitions: %v", err))
+	}
+
+	if existing, err := db.VerifyPartitions(empty); err != nil {
 		return nil, err
 	} else {
-		return allPartitions, nil
+		partitions = append(partitions, existing...)
 	}
 
+	return partitions, nil
+
 }
 
 // Retrieve the partition owner for a given partition.
@@ -174,6 +228,16 @@ func (db *AgbotPostgresqlDB) GetPartitionOwner(id string) (string, error) {
 
 }
 
+func (db *AgbotPostgresqlDB) GetPartitionExchanges(id string) (string, error) {
+
+	var exchanges string
+	if err := db.db.QueryRow(PARTITION_EXCHANGES, id).Scan(&exchanges); err != nil {
+		return "", errors.New(fmt.Sprintf("error scanning partition %v exchanges result, error: %v", id, err))
+	}
+	return exchanges, nil
+
+}
+
 // If any partition is owned by this agbot where the table is missing for any of the persisted objects, then remove that
 // partition from the database.
 func (db *AgbotPostgresqlDB) VerifyPartitions(partitions []string) ([]string, error) {
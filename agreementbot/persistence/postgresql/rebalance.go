@@ -0,0 +1,257 @@
+package postgresql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/lib/pq"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+)
+
+// Constants for the SQL statements that are used to rebalance partitions across agbots. A partition is only taken over by
+// another agbot when it is quiesced or goes stale, so adding an agbot instance does not spread the existing agreements. To
+// spread them, the agbots elect a leader through the partition_leader table. The leader compares the number of active
+// agreements in each owned partition and proposes moves, recorded in the partition_moves table. The owner of the source
+// partition drains its work queues and then moves the records of some of its devices into the target partition, and the
+// owner of the target partition picks up the moved agreements.
+//
+// partition_leader schema:
+// id:        Always 1, there is only one leader.
+// owner:     The UUID of the agbot that is the leader.
+// heartbeat: A timestamp to record the last time the leader renewed its leadership. When the leader does not renew within
+//            the partition "stale" timeout, any agbot can take over the leadership.
+//
+// partition_moves schema:
+// id:             The move id, serially incremented by the database.
+// from_partition: The partition that agreements are moved out of.
+// to_partition:   The partition that agreements are moved into.
+// agreements:     The number of active agreements the leader asked to move.
+// moved:          The number of active agreements that were actually moved.
+// state:          The state of the move, one of the PARTITION_MOVE_* states in the persistence package.
+// reason:         Why the move failed.
+// created:        A timestamp to record when the move was proposed.
+// updated:        A timestamp to record the last state change.
+//
+
+const PARTITION_LEADER_CREATE_TABLE = `CREATE TABLE IF NOT EXISTS partition_leader (
+	id int PRIMARY KEY,
+	owner text NOT NULL,
+	heartbeat timestamp with time zone NOT NULL
+);`
+
+// The leadership is claimed when there is no leader, when this agbot is already the leader (which renews the leadership)
+// or when the leader has not renewed its leadership within the timeout.
+const PARTITION_LEADER_CLAIM = `INSERT INTO partition_leader (id, owner, heartbeat) VALUES (1, $1, current_timestamp)
+	ON CONFLICT (id) DO UPDATE SET owner = $1, heartbeat = current_timestamp
+	WHERE partition_leader.owner = $1 OR (SELECT EXTRACT ('epoch' FROM (SELECT AGE(current_timestamp, partition_leader.heartbeat)))) > $2
+	RETURNING owner;`
+
+const PARTITION_LEADER_QUERY = `SELECT owner FROM partition_leader WHERE id = 1;`
+
+const PARTITION_LEADER_RELEASE = `DELETE FROM partition_leader WHERE owner = $1;`
+
+const PARTITION_MOVES_CREATE_TABLE = `CREATE TABLE IF NOT EXISTS partition_moves (
+	id SERIAL PRIMARY KEY,
+	from_partition text NOT NULL,
+	to_partition text NOT NULL,
+	agreements bigint NOT NULL,
+	moved bigint NOT NULL DEFAULT 0,
+	state text NOT NULL,
+	reason text NOT NULL DEFAULT '',
+	created timestamp with time zone DEFAULT current_timestamp,
+	updated timestamp with time zone DEFAULT current_timestamp
+);`
+
+const PARTITION_MOVE_INSERT = `INSERT INTO partition_moves (from_partition, to_partition, agreements, state) VALUES ($1, $2, $3, $4) RETURNING id, EXTRACT (EPOCH FROM created);`
+
+const PARTITION_MOVES_QUERY = `SELECT id, from_partition, to_partition, agreements, moved, state, reason, EXTRACT (EPOCH FROM created), EXTRACT (EPOCH FROM updated) FROM partition_moves ORDER BY id;`
+
+const PARTITION_MOVES_INFLIGHT_QUERY = `SELECT id, from_partition, to_partition, agreements, moved, state, reason, EXTRACT (EPOCH FROM created), EXTRACT (EPOCH FROM updated) FROM partition_moves WHERE state IN ($1, $2, $3) ORDER BY id;`
+
+// Only moves that are still in flight can change state.
+const PARTITION_MOVE_UPDATE = `UPDATE partition_moves SET state = $2, reason = $3, updated = current_timestamp WHERE id = $1 AND state IN ($4, $5, $6);`
+
+const PARTITION_MOVE_LOCK = `SELECT state FROM partition_moves WHERE id = $1 FOR UPDATE;`
+
+const PARTITION_MOVE_COMPLETE = `UPDATE partition_moves SET state = $2, moved = $3, updated = current_timestamp WHERE id = $1;`
+
+const PARTITION_MOVES_PURGE = `DELETE FROM partition_moves WHERE state IN ($2, $3) AND (SELECT EXTRACT ('epoch' FROM (SELECT AGE(current_timestamp, updated)))) > $1;`
+
+// Claim or renew the leadership among the agbots. Returns true if this agbot is the leader.
+func (db *AgbotPostgresqlDB) ClaimPartitionLeader(timeout uint64) (bool, error) {
+
+	var owner string
+	if err := db.db.QueryRow(PARTITION_LEADER_CLAIM, db.identity, timeout).Scan(&owner); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, errors.New(fmt.Sprintf("AgreementBot %v unable to claim partition leadership, error: %v", db.identity, err))
+	}
+	glog.V(5).Infof("AgreementBot %v is the partition leader", db.identity)
+	return owner == db.identity, nil
+}
+
+// Retrieve the current leader, an empty string means there is no leader.
+func (db *AgbotPostgresqlDB) GetPartitionLeader() (string, error) {
+
+	var owner string
+	if err := db.db.QueryRow(PARTITION_LEADER_QUERY).Scan(&owner); err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", errors.New(fmt.Sprintf("error scanning partition leader result, error: %v", err))
+	}
+	return owner, nil
+}
+
+// Give up the leadership, if this agbot has it, so that another agbot can take over immediately.
+func (db *AgbotPostgresqlDB) ReleasePartitionLeader() error {
+
+	if _, err := db.db.Exec(PARTITION_LEADER_RELEASE, db.identity); err != nil {
+		return errors.New(fmt.Sprintf("AgreementBot %v unable to release partition leadership, error: %v", db.identity, err))
+	}
+	return nil
+}
+
+// Record a new move of agreements from one partition to another.
+func (db *AgbotPostgresqlDB) ProposePartitionMove(fromPartition string, toPartition string, agreements int64) (*persistence.PartitionMove, error) {
+
+	move := &persistence.PartitionMove{
+		FromPartition: fromPartition,
+		ToPartition:   toPartition,
+		Agreements:    agreements,
+		State:         persistence.PARTITION_MOVE_PROPOSED,
+	}
+
+	var created float64
+	if err := db.db.QueryRow(PARTITION_MOVE_INSERT, fromPartition, toPartition, agreements, move.State).Scan(&move.Id, &created); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to insert partition move from %v to %v, error: %v", fromPartition, toPartition, err))
+	}
+	move.Created = uint64(created)
+	move.Updated = move.Created
+
+	glog.V(3).Infof("AgreementBot %v proposed partition move %v", db.identity, move)
+	return move, nil
+}
+
+// Retrieve the partition moves, either all of them or only the ones that are still in flight.
+func (db *AgbotPostgresqlDB) FindPartitionMoves(inflightOnly bool) ([]persistence.PartitionMove, error) {
+
+	var rows *sql.Rows
+	var err error
+	if inflightOnly {
+		rows, err = db.db.Query(PARTITION_MOVES_INFLIGHT_QUERY, persistence.PARTITION_MOVE_PROPOSED, persistence.PARTITION_MOVE_DRAINING, persistence.PARTITION_MOVE_MOVED)
+	} else {
+		rows, err = db.db.Query(PARTITION_MOVES_QUERY)
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("error querying for partition moves, error: %v", err))
+	}
+
+	// If the rows object doesnt get closed, memory and connections will grow and/or leak.
+	defer rows.Close()
+
+	moves := make([]persistence.PartitionMove, 0, 5)
+	for rows.Next() {
+		var m persistence.PartitionMove
+		var created, updated float64
+		if err := rows.Scan(&m.Id, &m.FromPartition, &m.ToPartition, &m.Agreements, &m.Moved, &m.State, &m.Reason, &created, &updated); err != nil {
+			return nil, errors.New(fmt.Sprintf("error scanning partition move row: %v", err))
+		}
+		m.Created = uint64(created)
+		m.Updated = uint64(updated)
+		moves = append(moves, m)
+	}
+
+	// The rows.Next() function will exit with false when done or an error occurred. Get any error encountered during iteration.
+	if err = rows.Err(); err != nil {
+		return nil, errors.New(fmt.Sprintf("error iterating partition moves: %v", err))
+	}
+
+	return moves, nil
+}
+
+// Change the state of a move that is still in flight.
+func (db *AgbotPostgresqlDB) UpdatePartitionMove(id int, state string, reason string) error {
+
+	if _, err := db.db.Exec(PARTITION_MOVE_UPDATE, id, state, reason, persistence.PARTITION_MOVE_PROPOSED, persistence.PARTITION_MOVE_DRAINING, persistence.PARTITION_MOVE_MOVED); err != nil {
+		return errors.New(fmt.Sprintf("unable to update partition move %v to state %v, error: %v", id, state, err))
+	}
+	glog.V(3).Infof("AgreementBot %v updated partition move %v to state %v %v", db.identity, id, state, reason)
+	return nil
+}
+
+// Move the records of some devices from our primary partition into the target partition of a move that is draining.
+// The records are moved and the move state is updated under a single transaction, which also holds a lock on the move
+// so that the leader cannot abandon the move while the records are being moved. Returns the number of active agreements
+// that were moved.
+func (db *AgbotPostgresqlDB) MovePartitionAgreements(move *persistence.PartitionMove) (int64, error) {
+
+	if move.FromPartition != db.PrimaryPartition() {
+		return 0, errors.New(fmt.Sprintf("partition move %v is not from partition %v", move.Id, db.PrimaryPartition()))
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("unable to start transaction for partition move %v, error: %v", move.Id, err))
+	}
+	defer tx.Rollback()
+
+	var state string
+	if err := tx.QueryRow(PARTITION_MOVE_LOCK, move.Id).Scan(&state); err != nil {
+		return 0, errors.New(fmt.Sprintf("unable to lock partition move %v, error: %v", move.Id, err))
+	} else if state != persistence.PARTITION_MOVE_DRAINING {
+		return 0, errors.New(fmt.Sprintf("partition move %v is in state %v, expected %v", move.Id, state, persistence.PARTITION_MOVE_DRAINING))
+	}
+
+	// Read all the agreements in the partition to choose the devices to move.
+	rows, err := tx.Query(db.GetAgreementPartitionTableCount(move.FromPartition))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("error querying agreements in partition %v, error: %v", move.FromPartition, err))
+	}
+
+	ags := make([]persistence.Agreement, 0, 100)
+	for rows.Next() {
+		agBytes := make([]byte, 0, 2048)
+		ag := new(persistence.Agreement)
+		if err := rows.Scan(&agBytes); err != nil {
+			rows.Close()
+			return 0, errors.New(fmt.Sprintf("error scanning agreement row: %v", err))
+		} else if err := json.Unmarshal(agBytes, ag); err != nil {
+			rows.Close()
+			return 0, errors.New(fmt.Sprintf("error demarshalling agreement row: %v, error: %v", string(agBytes), err))
+		}
+		ags = append(ags, *ag)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, errors.New(fmt.Sprintf("error iterating agreements: %v", err))
+	}
+
+	devices, moved := persistence.SelectDevicesToMove(ags, move.Agreements)
+	if len(devices) != 0 {
+		if _, err := tx.Exec(db.GetAgreementDevicesMove(move.FromPartition, move.ToPartition), pq.Array(devices)); err != nil {
+			return 0, errors.New(fmt.Sprintf("unable to move agreements to partition %v, error: %v", move.ToPartition, err))
+		} else if _, err := tx.Exec(db.GetWorkloadUsageDevicesMove(move.FromPartition, move.ToPartition), pq.Array(devices)); err != nil {
+			return 0, errors.New(fmt.Sprintf("unable to move workload usages to partition %v, error: %v", move.ToPartition, err))
+		}
+	}
+
+	if _, err := tx.Exec(PARTITION_MOVE_COMPLETE, move.Id, persistence.PARTITION_MOVE_MOVED, moved); err != nil {
+		return 0, errors.New(fmt.Sprintf("unable to update partition move %v, error: %v", move.Id, err))
+	} else if err := tx.Commit(); err != nil {
+		return 0, errors.New(fmt.Sprintf("unable to commit transaction for partition move %v, error: %v", move.Id, err))
+	}
+
+	glog.V(3).Infof("AgreementBot %v moved %v agreements of %v devices from partition %v to %v", db.identity, moved, len(devices), move.FromPartition, move.ToPartition)
+	return moved, nil
+}
+
+// Remove the moves that reached a final state more than ageS seconds ago.
+func (db *AgbotPostgresqlDB) PurgePartitionMoves(ageS uint64) error {
+
+	if _, err := db.db.Exec(PARTITION_MOVES_PURGE, ageS, persistence.PARTITION_MOVE_DONE, persistence.PARTITION_MOVE_FAILED); err != nil {
+		return errors.New(fmt.Sprintf("unable to purge partition moves, error: %v", err))
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/policy"
 	"strings"
 )
 
@@ -92,6 +93,14 @@ const WORKLOAD_USAGE_MOVE = `WITH moved_rows AS (
 INSERT INTO "workload_usages_ (device_id, policy_name, partition, workload_usage) SELECT device_id, policy_name, 'partition_name', workload_usage FROM moved_rows;
 `
 
+// Move the workload usages of a set of devices, used when partitions are rebalanced.
+const WORKLOAD_USAGE_DEVICES_MOVE = `WITH moved_rows AS (
+    DELETE FROM "workload_usages_ a WHERE a.device_id = ANY($1)
+    RETURNING a.device_id, a.policy_name, a.workload_usage
+)
+INSERT INTO "workload_usages_ (device_id, policy_name, partition, workload_usage) SELECT device_id, policy_name, 'partition_name', workload_usage FROM moved_rows;
+`
+
 const WORKLOAD_USAGE_DROP_PARTITION = `DROP TABLE "workload_usages_;`
 
 func (db *AgbotPostgresqlDB) GetWorkloadUsagePartitionTableName(partition string) string {
@@ -134,6 +143,14 @@ func (db *AgbotPostgresqlDB) GetWorkloadUsagePartitionMove(fromPartition string,
 	return sql
 }
 
+// The same replacement scheme as GetWorkloadUsagePartitionMove, for moving only the workload usages of some devices.
+func (db *AgbotPostgresqlDB) GetWorkloadUsageDevicesMove(fromPartition string, toPartition string) string {
+	sql := strings.Replace(WORKLOAD_USAGE_DEVICES_MOVE, WORKLOAD_USAGE_TABLE_NAME_ROOT, db.GetWorkloadUsagePartitionTableName(toPartition), 2)
+	sql = strings.Replace(sql, db.GetWorkloadUsagePartitionTableName(toPartition), db.GetWorkloadUsagePartitionTableName(fromPartition), 1)
+	sql = strings.Replace(sql, WORKLOAD_USAGE_PARTITION_FILLIN, toPartition, 1)
+	return sql
+}
+
 // The partition table name replacement scheme used in this function is slightly different from the others above.
 func (db *AgbotPostgresqlDB) GetWorkloadUsagesCount(partition string) (int64, error) {
 	var num int64
@@ -230,8 +247,8 @@ func (db *AgbotPostgresqlDB) FindWorkloadUsages(filters []persistence.WUFilter)
 	return wus, nil
 }
 
-func (db *AgbotPostgresqlDB) NewWorkloadUsage(deviceId string, hapartners []string, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string) error {
-	if wlUsage, err := persistence.NewWorkloadUsage(deviceId, hapartners, policy, policyName, priority, retryDurationS, verifiedDurationS, reqsNotMet, agid); err != nil {
+func (db *AgbotPostgresqlDB) NewWorkloadUsage(deviceId string, haGroup *policy.HighAvailabilityGroup, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string, exchange string) error {
+	if wlUsage, err := persistence.NewWorkloadUsage(deviceId, haGroup, policy, policyName, priority, retryDurationS, verifiedDurationS, reqsNotMet, agid, exchange); err != nil {
 		return err
 	} else if existing, partition, err := db.internalFindSingleWorkloadUsageByDeviceAndPolicyName(nil, deviceId, policyName); err != nil {
 		return err
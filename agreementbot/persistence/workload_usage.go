@@ -3,31 +3,39 @@ package persistence
 import (
 	"errors"
 	"fmt"
+	"github.com/open-horizon/anax/policy"
 	"time"
 )
 
 type WorkloadUsage struct {
-	Id                 uint64   `json:"record_id"`            // unique primary key for records
-	DeviceId           string   `json:"device_id"`            // the device id we are working with, immutable after construction
-	HAPartners         []string `json:"ha_partners"`          // list of device id(s) which are partners to this device
-	PendingUpgradeTime uint64   `json:"pending_upgrade_time"` // time when this usage was marked for pending upgrade
-	Policy             string   `json:"policy"`               // the policy containing the workloads we're managing
-	PolicyName         string   `json:"policy_name"`          // the name of the policy containing the workloads we're managing
-	Priority           int      `json:"priority"`             // the workload priority that we're working with
-	RetryCount         int      `json:"retry_count"`          // The number of retries attempted so far
-	RetryDurationS     int      `json:"retry_durations"`      // The number of seconds in which the specified number of retries must occur in order for the next priority workload to be attempted.
-	CurrentAgreementId string   `json:"current_agreement_id"` // the agreement id currently in use
-	FirstTryTime       uint64   `json:"first_try_time"`       // time when first agrement attempt was made, used to count retries per time
-	LatestRetryTime    uint64   `json:"latest_retry_time"`    // time when the newest retry has occurred
-	DisableRetry       bool     `json:"disable_retry"`        // when true, retry and retry durations are disbled which effectively disables workload rollback
-	VerifiedDurationS  int      `json:"verified_durations"`   // the number of seconds for successful data verification before disabling workload rollback retries
-	ReqsNotMet         bool     `json:"requirements_not_met"` // this workload usage record is not at the highest priority because the device did not meet the API spec requirements at one of the higher priorities
+	Id                 uint64   `json:"record_id"`                         // unique primary key for records
+	DeviceId           string   `json:"device_id"`                         // the device id we are working with, immutable after construction
+	HAPartners         []string `json:"ha_partners"`                       // list of device id(s) which are partners to this device
+	HAMaxUnavailable   int      `json:"ha_max_unavailable,omitempty"`      // the number of HA group members that can upgrade at the same time
+	HAMinHealthyS      int      `json:"ha_min_healthy_duration,omitempty"` // the number of seconds an upgraded HA group member must run before the next one upgrades
+	HAOrder            []string `json:"ha_order,omitempty"`                // the order in which HA group members are upgraded
+	PendingUpgradeTime uint64   `json:"pending_upgrade_time"`              // time when this usage was marked for pending upgrade
+	Policy             string   `json:"policy"`                            // the policy containing the workloads we're managing
+	PolicyName         string   `json:"policy_name"`                       // the name of the policy containing the workloads we're managing
+	Priority           int      `json:"priority"`                          // the workload priority that we're working with
+	RetryCount         int      `json:"retry_count"`                       // The number of retries attempted so far
+	RetryDurationS     int      `json:"retry_durations"`                   // The number of seconds in which the specified number of retries must occur in order for the next priority workload to be attempted.
+	CurrentAgreementId string   `json:"current_agreement_id"`              // the agreement id currently in use
+	FirstTryTime       uint64   `json:"first_try_time"`                    // time when first agrement attempt was made, used to count retries per time
+	LatestRetryTime    uint64   `json:"latest_retry_time"`                 // time when the newest retry has occurred
+	DisableRetry       bool     `json:"disable_retry"`                     // when true, retry and retry durations are disbled which effectively disables workload rollback
+	VerifiedDurationS  int      `json:"verified_durations"`                // the number of seconds for successful data verification before disabling workload rollback retries
+	ReqsNotMet         bool     `json:"requirements_not_met"`              // this workload usage record is not at the highest priority because the device did not meet the API spec requirements at one of the higher priorities
+	Exchange           string   `json:"exchange,omitempty"`                // the name of the federated exchange of the device, empty for the agbot's primary exchange
 }
 
 func (w WorkloadUsage) String() string {
 	return fmt.Sprintf("Id: %v, "+
 		"DeviceId: %v, "+
 		"HA Partners: %v, "+
+		"HA Max Unavailable: %v, "+
+		"HA Min Healthy Duration: %v, "+
+		"HA Order: %v, "+
 		"Pending Upgrade Time: %v, "+
 		"PolicyName: %v, "+
 		"Priority: %v, "+
@@ -39,9 +47,10 @@ func (w WorkloadUsage) String() string {
 		"DisableRetry: %v, "+
 		"VerifiedDurationS: %v, "+
 		"ReqsNotMet: %v, "+
+		"Exchange: %v, "+
 		"Policy: %v",
-		w.Id, w.DeviceId, w.HAPartners, w.PendingUpgradeTime, w.PolicyName, w.Priority, w.RetryCount,
-		w.RetryDurationS, w.CurrentAgreementId, w.FirstTryTime, w.LatestRetryTime, w.DisableRetry, w.VerifiedDurationS, w.ReqsNotMet, w.Policy)
+		w.Id, w.DeviceId, w.HAPartners, w.HAMaxUnavailable, w.HAMinHealthyS, w.HAOrder, w.PendingUpgradeTime, w.PolicyName, w.Priority, w.RetryCount,
+		w.RetryDurationS, w.CurrentAgreementId, w.FirstTryTime, w.LatestRetryTime, w.DisableRetry, w.VerifiedDurationS, w.ReqsNotMet, w.Exchange, w.Policy)
 }
 
 func (w WorkloadUsage) ShortString() string {
@@ -63,17 +72,32 @@ func (w WorkloadUsage) ShortString() string {
 		w.RetryDurationS, w.CurrentAgreementId, w.FirstTryTime, w.LatestRetryTime, w.DisableRetry, w.VerifiedDurationS, w.ReqsNotMet)
 }
 
+// Return the HA group that this device belongs to, including the rolling upgrade settings of the group.
+func (w WorkloadUsage) GetHAGroup() *policy.HighAvailabilityGroup {
+	g := policy.HAGroup_Factory(w.HAPartners)
+	g.MaxUnavailable = w.HAMaxUnavailable
+	g.MinHealthyDurationS = w.HAMinHealthyS
+	g.Order = w.HAOrder
+	return g
+}
+
 // private factory method for workloadusage w/out persistence safety:
-func NewWorkloadUsage(deviceId string, hapartners []string, policy string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string) (*WorkloadUsage, error) {
+func NewWorkloadUsage(deviceId string, haGroup *policy.HighAvailabilityGroup, pol string, policyName string, priority int, retryDurationS int, verifiedDurationS int, reqsNotMet bool, agid string, exchange string) (*WorkloadUsage, error) {
 
 	if deviceId == "" || policyName == "" || priority == 0 || retryDurationS == 0 || agid == "" {
 		return nil, errors.New("Illegal input: one of deviceId, policy, policyName, priority, retryDurationS, retryLimit or agreement id is empty")
 	} else {
+		if haGroup == nil {
+			haGroup = new(policy.HighAvailabilityGroup)
+		}
 		return &WorkloadUsage{
 			DeviceId:           deviceId,
-			HAPartners:         hapartners,
+			HAPartners:         haGroup.Partners,
+			HAMaxUnavailable:   haGroup.MaxUnavailable,
+			HAMinHealthyS:      haGroup.MinHealthyDurationS,
+			HAOrder:            haGroup.Order,
 			PendingUpgradeTime: 0,
-			Policy:             policy,
+			Policy:             pol,
 			PolicyName:         policyName,
 			Priority:           priority,
 			RetryCount:         0,
@@ -84,6 +108,7 @@ func NewWorkloadUsage(deviceId string, hapartners []string, policy string, polic
 			DisableRetry:       false,
 			VerifiedDurationS:  verifiedDurationS,
 			ReqsNotMet:         reqsNotMet,
+			Exchange:           exchange,
 		}, nil
 	}
 }
@@ -219,4 +244,8 @@ func PWUFilter(policyName string) WUFilter {
 	return func(a WorkloadUsage) bool { return a.PolicyName == policyName }
 }
 
+func ExchangeWUFilter(exchange string) WUFilter {
+	return func(a WorkloadUsage) bool { return a.Exchange == exchange }
+}
+
 type WUFilter func(WorkloadUsage) bool
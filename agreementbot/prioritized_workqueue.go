@@ -11,13 +11,16 @@ import (
 // The high priority inbound channel can inject work into the workers even when the low priority queue is non-empty.
 // Essentially, this allows high priority work to skip to the front of the line for the worker threads.
 // Each inbound channel also has a buffer which holds inbound work that hasnt yet been dispatched to a worker. This
-// ensures that high priority work generators dont block for very long.
+// ensures that high priority work generators dont block for very long. The low priority buffer is shared fairly among
+// the orgs and policies that the work is for, and can limit the rate at which agreements are started for each org.
+// Each org can have at most twice the buffer size of agreements waiting in the low priority buffer, so that work which
+// is held back by the rate limit of one org cannot grow the buffer without bound or hold back the work of other orgs.
 type PrioritizedWorkQueue struct {
 	inboundHigh         chan *AgreementWork // This is the high priority inbound channel.
 	workQueueBufferHigh []*AgreementWork    // The internal work queue buffer for the high inbound channel.
 
 	inboundLow         chan *AgreementWork // This is the low priority inbound channel.
-	workQueueBufferLow []*AgreementWork    // The internal work queue buffer for the low inbound channel.
+	workQueueBufferLow *FairWorkQueue      // The internal work queue buffer for the low inbound channel.
 
 	recv       chan *AgreementWork // This is the channel where workers listen/block for work.
 	bufferLock sync.Mutex          // A lock that protects access to the work queue buffers.
@@ -27,12 +30,12 @@ type PrioritizedWorkQueue struct {
 	queueHistory *PrioritizedWorkQueueHistory // Stats records from the recent past.
 }
 
-func NewPrioritizedWorkQueue(bufferSize uint64, statInterval int, maxRecords int) *PrioritizedWorkQueue {
+func NewPrioritizedWorkQueue(bufferSize uint64, statInterval int, maxRecords int, weights map[string]int, rateLimits map[string]int) *PrioritizedWorkQueue {
 	n := &PrioritizedWorkQueue{
 		inboundHigh:         make(chan *AgreementWork, bufferSize),
 		workQueueBufferHigh: make([]*AgreementWork, 0, bufferSize*2),
 		inboundLow:          make(chan *AgreementWork, bufferSize),
-		workQueueBufferLow:  make([]*AgreementWork, 0, bufferSize*2),
+		workQueueBufferLow:  NewFairWorkQueue(weights, rateLimits, int(bufferSize*2)),
 		recv:                make(chan *AgreementWork),
 		bufferSize:          bufferSize,
 		queueHistory:        NewPrioritizedWorkQueueHistory(statInterval, maxRecords),
@@ -69,8 +72,9 @@ func (n *PrioritizedWorkQueue) HighAtSize(threshold int) bool {
 	return n.HighPriorityBufferLen() >= threshold
 }
 
+// The work of orgs that are rate limited is not counted, it cannot be given to a worker until the limit allows it.
 func (n *PrioritizedWorkQueue) LowAtDepth() bool {
-	return uint64(n.LowPriorityBufferLen()) > n.bufferSize
+	return uint64(n.LowPriorityReadyLen()) > n.bufferSize
 }
 
 func (n *PrioritizedWorkQueue) LowIsEmpty() bool {
@@ -102,7 +106,13 @@ func (n *PrioritizedWorkQueue) Receive() chan *AgreementWork {
 func (n *PrioritizedWorkQueue) TotalBufferedWork() int {
 	n.bufferLock.Lock()
 	defer n.bufferLock.Unlock()
-	return len(n.workQueueBufferHigh) + len(n.workQueueBufferLow)
+	return len(n.workQueueBufferHigh) + n.workQueueBufferLow.Len()
+}
+
+// Returns true when there is no work waiting in the queue, neither in the inbound channels, the buffers nor the
+// channel that the agreement workers receive from.
+func (n *PrioritizedWorkQueue) IsDrained() bool {
+	return len(n.InboundHigh()) == 0 && len(n.InboundLow()) == 0 && n.TotalBufferedWork() == 0 && len(n.Receive()) == 0
 }
 
 func (n *PrioritizedWorkQueue) HighPriorityBufferLen() int {
@@ -133,25 +143,58 @@ func (n *PrioritizedWorkQueue) AddToHighPriorityBuffer(w *AgreementWork) {
 func (n *PrioritizedWorkQueue) LowPriorityBufferLen() int {
 	n.bufferLock.Lock()
 	defer n.bufferLock.Unlock()
-	return len(n.workQueueBufferLow)
+	return n.workQueueBufferLow.Len()
 }
 
-func (n *PrioritizedWorkQueue) GetLowPriorityBufferHead() *AgreementWork {
+// Returns the number of low priority work items that are not held back by a rate limit.
+func (n *PrioritizedWorkQueue) LowPriorityReadyLen() int {
 	n.bufferLock.Lock()
 	defer n.bufferLock.Unlock()
-	head := n.workQueueBufferLow[0]
-	return head
+	return n.workQueueBufferLow.ReadyLen(time.Now())
+}
+
+// Returns the next low priority work item and the org it is for. The work item is nil when all the buffered work
+// is rate limited, the returned duration is then the time until more work can be dispatched.
+func (n *PrioritizedWorkQueue) GetLowPriorityBufferHead() (*AgreementWork, string, time.Duration) {
+	n.bufferLock.Lock()
+	defer n.bufferLock.Unlock()
+	return n.workQueueBufferLow.Head(time.Now())
+}
+
+func (n *PrioritizedWorkQueue) RemoveLowPriorityBufferHead(org string) {
+	n.bufferLock.Lock()
+	defer n.bufferLock.Unlock()
+	n.workQueueBufferLow.Remove(org, time.Now())
 }
-func (n *PrioritizedWorkQueue) RemoveLowPriorityBufferHead() {
+
+func (n *PrioritizedWorkQueue) AddToLowPriorityBuffer(w *AgreementWork) (string, bool) {
 	n.bufferLock.Lock()
 	defer n.bufferLock.Unlock()
-	n.workQueueBufferLow = n.workQueueBufferLow[1:]
+	return n.workQueueBufferLow.Add(w, time.Now())
 }
 
-func (n *PrioritizedWorkQueue) AddToLowPriorityBuffer(w *AgreementWork) {
+// Returns the policies that had new agreements dropped from the low priority buffer and the time of the first one.
+func (n *PrioritizedWorkQueue) TakeDroppedPolicies() map[string]uint64 {
 	n.bufferLock.Lock()
 	defer n.bufferLock.Unlock()
-	n.workQueueBufferLow = append(n.workQueueBufferLow, w)
+	return n.workQueueBufferLow.TakeDropped()
+}
+
+// The current state of a work queue, reported in the agbot's status.
+type WorkQueueStatus struct {
+	HighBuffered int                          `json:"high_buffered"`
+	LowBuffered  int                          `json:"low_buffered"`
+	Orgs         map[string]FairQueueOrgStats `json:"orgs"` // The low priority work statistics for each org, since the agbot started.
+}
+
+func (n *PrioritizedWorkQueue) Status() WorkQueueStatus {
+	n.bufferLock.Lock()
+	defer n.bufferLock.Unlock()
+	return WorkQueueStatus{
+		HighBuffered: len(n.workQueueBufferHigh),
+		LowBuffered:  n.workQueueBufferLow.Len(),
+		Orgs:         n.workQueueBufferLow.Stats(),
+	}
 }
 
 const HIGH_PRIORITY = "high"
@@ -172,6 +215,10 @@ func (n *PrioritizedWorkQueue) run() {
 	// will be given preference within the select statement.
 	var inLowChan chan *AgreementWork
 
+	// When all the low priority work is rate limited, a timer wakes up this function when more work can be dispatched.
+	var wakeChan <-chan time.Time
+	recvOrg := ""
+
 	whichInbound := ""
 
 	// Create the statistics object to hold stats of what is happening inside the work queue.
@@ -187,6 +234,7 @@ func (n *PrioritizedWorkQueue) run() {
 
 		// Assume that the select should ONLY block on the inbound channels.
 		recvChan = nil
+		wakeChan = nil
 
 		// However, if there is buffered work, the select will use the channel that worker threads are blocked on. This
 		// will allow work to be passed to a worker.
@@ -196,9 +244,15 @@ func (n *PrioritizedWorkQueue) run() {
 			recvVal = n.GetHighPriorityBufferHead()
 			whichInbound = HIGH_PRIORITY
 		} else if n.LowPriorityBufferLen() > 0 {
-			recvChan = n.recv
-			recvVal = n.GetLowPriorityBufferHead()
-			whichInbound = LOW_PRIORITY
+			if head, org, wait := n.GetLowPriorityBufferHead(); head != nil {
+				recvChan = n.recv
+				recvVal = head
+				recvOrg = org
+				whichInbound = LOW_PRIORITY
+			} else {
+				glog.V(5).Infof(pwqString(fmt.Sprintf("low priority work is rate limited for %v", wait)))
+				wakeChan = time.After(wait)
+			}
 		}
 
 		// Assume that low priority inbound work is being accepted.
@@ -229,8 +283,11 @@ func (n *PrioritizedWorkQueue) run() {
 			if ok {
 				glog.V(3).Infof(pwqString(fmt.Sprintf("queueing inbound low: %v", (*i).ShortString())))
 				stats = n.queueHistory.Collect(stats, false)
-				n.AddToLowPriorityBuffer(i)
-				stats.consumedInboundLow()
+				if org, added := n.AddToLowPriorityBuffer(i); !added {
+					glog.V(3).Infof(pwqString(fmt.Sprintf("dropping inbound low for org %v, the org has too much work buffered: %v", org, (*i).ShortString())))
+				} else {
+					stats.consumedInboundLow(org)
+				}
 			} else {
 				// The channel must be closed now.
 				glog.V(3).Infof(pwqString("closing inbound low"))
@@ -242,10 +299,12 @@ func (n *PrioritizedWorkQueue) run() {
 				n.RemoveHighPriorityBufferHead()
 				stats.consumedHighBuffered()
 			} else if whichInbound == LOW_PRIORITY {
-				n.RemoveLowPriorityBufferHead()
-				stats.consumedLowBuffered()
+				n.RemoveLowPriorityBufferHead(recvOrg)
+				stats.consumedLowBuffered(recvOrg)
 			}
 			stats = n.queueHistory.Collect(stats, false)
+		case <-wakeChan:
+			glog.V(5).Infof(pwqString("rate limit wait is over"))
 		}
 	}
 }
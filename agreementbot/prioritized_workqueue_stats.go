@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/cutil"
+	"sort"
 	"time"
 )
 
@@ -14,26 +15,46 @@ type PrioritizedWorkQueueStats struct {
 	numHighBuffered int       // The number of requests removed from the high buffer to a worker thread.
 	numLowBuffered  int       // The number of requests removed from the low buffer to a worker thread.
 	collectTime     time.Time // The time that this record was collected, i.e. added to history
+	orgs            map[string]*orgQueueCounts
+}
+
+// The low priority requests moved in and out of the buffer for an org.
+type orgQueueCounts struct {
+	numInbound  int
+	numBuffered int
 }
 
 func NewPrioritizedWorkQueueStats() *PrioritizedWorkQueueStats {
-	return new(PrioritizedWorkQueueStats)
+	return &PrioritizedWorkQueueStats{
+		orgs: make(map[string]*orgQueueCounts),
+	}
+}
+
+func (p *PrioritizedWorkQueueStats) org(org string) *orgQueueCounts {
+	c, ok := p.orgs[org]
+	if !ok {
+		c = new(orgQueueCounts)
+		p.orgs[org] = c
+	}
+	return c
 }
 
 func (p *PrioritizedWorkQueueStats) consumedInboundHigh() {
 	p.numInboundHigh += 1
 }
 
-func (p *PrioritizedWorkQueueStats) consumedInboundLow() {
+func (p *PrioritizedWorkQueueStats) consumedInboundLow(org string) {
 	p.numInboundLow += 1
+	p.org(org).numInbound += 1
 }
 
 func (p *PrioritizedWorkQueueStats) consumedHighBuffered() {
 	p.numHighBuffered += 1
 }
 
-func (p *PrioritizedWorkQueueStats) consumedLowBuffered() {
+func (p *PrioritizedWorkQueueStats) consumedLowBuffered(org string) {
 	p.numLowBuffered += 1
+	p.org(org).numBuffered += 1
 }
 
 func (p *PrioritizedWorkQueueStats) empty() bool {
@@ -41,7 +62,17 @@ func (p *PrioritizedWorkQueueStats) empty() bool {
 }
 
 func (p *PrioritizedWorkQueueStats) report() string {
-	return fmt.Sprintf("%-30s In High: %5d, Out High: %5d, In Low: %5d, Out Low: %5d", p.collectTime.Format(cutil.ExchangeTimeFormat), p.numInboundHigh, p.numHighBuffered, p.numInboundLow, p.numLowBuffered)
+	res := fmt.Sprintf("%-30s In High: %5d, Out High: %5d, In Low: %5d, Out Low: %5d", p.collectTime.Format(cutil.ExchangeTimeFormat), p.numInboundHigh, p.numHighBuffered, p.numInboundLow, p.numLowBuffered)
+
+	orgs := make([]string, 0, len(p.orgs))
+	for org := range p.orgs {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		res += fmt.Sprintf(", %v In: %d Out: %d", org, p.orgs[org].numInbound, p.orgs[org].numBuffered)
+	}
+	return res
 }
 
 // This object holds all of the work queue stats generated to date
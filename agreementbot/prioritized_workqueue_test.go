@@ -4,6 +4,7 @@ package agreementbot
 
 import (
 	"flag"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -15,7 +16,7 @@ func init() {
 }
 
 func Test_PrioritizedWorkQueue_serial(t *testing.T) {
-	nbc := NewPrioritizedWorkQueue(uint64(100), 2, 10)
+	nbc := NewPrioritizedWorkQueue(uint64(100), 2, 10, nil, nil)
 	if nbc == nil {
 		t.Errorf("constructor should return non-nil object")
 	}
@@ -74,7 +75,7 @@ func Test_PrioritizedWorkQueue_concurrent_priority_mix(t *testing.T) {
 	const QSIZE = uint64(100)
 
 	// Make the internal buffer smaller to force the work queue-ing thread to give up control once in a while.
-	nbc := NewPrioritizedWorkQueue(10, 2, 10)
+	nbc := NewPrioritizedWorkQueue(10, 2, 10, nil, nil)
 	if nbc == nil {
 		t.Errorf("constructor should return non-nil object")
 	}
@@ -149,6 +150,56 @@ func Test_PrioritizedWorkQueue_concurrent_priority_mix(t *testing.T) {
 
 }
 
+// Test that an org which is held back by its rate limit does not stop the work of other orgs from being dispatched.
+func Test_PrioritizedWorkQueue_throttled_org(t *testing.T) {
+
+	nbc := NewPrioritizedWorkQueue(2, 2, 10, nil, map[string]int{"org1": 1})
+
+	// org1 can start one agreement a minute, the first agreement uses it up.
+	nbc.InboundLow() <- fairWork("org1", "bp1", "a0")
+	if rwi := *(<-nbc.Receive()); rwi.(InitiateAgreement).Device.Id != "a0" {
+		t.Errorf("expected the first org1 work item, got %v", rwi)
+	}
+
+	// The org1 work fills its share of the buffer and the rest is dropped, the senders are never blocked.
+	sent := 0
+	for i := 1; i <= 10; i++ {
+		select {
+		case nbc.InboundLow() <- fairWork("org1", "bp1", fmt.Sprintf("a%v", i)):
+			sent += 1
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if sent != 10 {
+		t.Errorf("expected 10 work items to be accepted, but %v were", sent)
+	} else if nbc.LowPriorityBufferLen() != 4 || nbc.LowAtDepth() {
+		t.Errorf("expected org1 to have 4 work items buffered, has %v", nbc.LowPriorityBufferLen())
+	} else if stats := nbc.Status().Orgs["org1"]; stats.Dropped != 6 || stats.Buffered != 4 {
+		t.Errorf("wrong org1 stats %v", stats)
+	}
+
+	// The work of another org is still dispatched.
+	select {
+	case nbc.InboundLow() <- fairWork("org2", "bp1", "b1"):
+	case <-time.After(50 * time.Millisecond):
+		t.Fatalf("expected the org2 work item to be accepted")
+	}
+	select {
+	case rwi := <-nbc.Receive():
+		if (*rwi).(InitiateAgreement).Device.Id != "b1" {
+			t.Errorf("expected the org2 work item, got %v", *rwi)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the org2 work item to be dispatched")
+	}
+
+	nbc.Close()
+	time.Sleep(10 * time.Millisecond)
+
+}
+
 func workerThread(t *testing.T, nbc *PrioritizedWorkQueue, worklist *[]uint) {
 	for {
 		// Retrieve a work item from the queue
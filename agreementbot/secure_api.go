@@ -173,6 +173,7 @@ func (a *SecureAPI) listen() {
 		router.HandleFunc("/deploycheck/policycompatible", a.policy_compatible).Methods("GET", "OPTIONS")
 		router.HandleFunc("/deploycheck/userinputcompatible", a.userinput_compatible).Methods("GET", "OPTIONS")
 		router.HandleFunc("/deploycheck/deploycompatible", a.deploy_compatible).Methods("GET", "OPTIONS")
+		router.HandleFunc("/deploycheck/whatif", a.whatif).Methods("POST", "OPTIONS")
 		router.HandleFunc("/org/{org}/secrets", a.secrets).Methods("GET", "OPTIONS")
 		router.HandleFunc("/org/{org}/secrets/{secret}", a.secrets).Methods("GET", "PUT", "POST", "DELETE", "OPTIONS")
 
@@ -371,6 +372,83 @@ func (a *SecureAPI) deploy_compatible(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// @Title whatif
+// @Description Evaluate the effect of a deployment policy before it is put in the exchange. The candidate deployment policy is evaluated against the node index of the agbot and the current agreements for the deployment policy. The output lists the nodes that would get an agreement, the nodes that would lose their agreement and the nodes whose agreement would be replaced for the changed deployment policy. Nothing is changed by this API.
+// @Accept  json
+// @Produce json
+// @Param   business_policy_id  body     string   true        "The org qualified exchange id of the deployment policy that would be created or replaced."
+// @Param   business_policy  	body     businesspolicy.BusinessPolicy  true        "The candidate deployment policy."
+// @Success 200 {object}  WhatIfOutput
+// @Failure 400 {object}  string      "No input found"
+// @Failure 401 {object}  string      "Failed to authenticate"
+// @Failure 403 {object}  string      "The user cannot access the deployment policy org"
+// @Failure 503 {object}  string      "The node index is not enabled"
+// @Resource /deploycheck
+// @Router /deploycheck/whatif [post]
+// This function evaluates a candidate deployment policy.
+func (a *SecureAPI) whatif(w http.ResponseWriter, r *http.Request) {
+
+	switch r.Method {
+	case "POST":
+		glog.V(5).Infof(APIlogString(fmt.Sprintf("/deploycheck/whatif called.")))
+
+		user_ec, msgPrinter, ok := a.processUserCred("/deploycheck/whatif", w, r)
+		if !ok {
+			return
+		}
+
+		var input WhatIfInput
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) == 0 {
+			glog.Errorf(APIlogString(fmt.Sprintf("No input found.")))
+			writeResponse(w, msgPrinter.Sprintf("No input found."), http.StatusBadRequest)
+			return
+		} else if err := json.Unmarshal(body, &input); err != nil {
+			glog.Errorf(APIlogString(fmt.Sprintf("Input body couldn't be deserialized to WhatIfInput object. %v", err)))
+			writeResponse(w, msgPrinter.Sprintf("Input body couldn't be deserialized to WhatIfInput object. %v", err), http.StatusBadRequest)
+			return
+		}
+
+		org, name := cutil.SplitOrgSpecUrl(input.BusinessPolicyId)
+		if org == "" || name == "" {
+			writeResponse(w, msgPrinter.Sprintf("The business_policy_id must be an org qualified deployment policy id."), http.StatusBadRequest)
+			return
+		} else if input.BusinessPolicy == nil {
+			writeResponse(w, msgPrinter.Sprintf("No business_policy found in the input."), http.StatusBadRequest)
+			return
+		} else if exchange.GetOrg(user_ec.GetExchangeId()) != org {
+			glog.Errorf(APIlogString(fmt.Sprintf("user %s cannot evaluate deployment policies in org %s.", user_ec.GetExchangeId(), org)))
+			writeResponse(w, msgPrinter.Sprintf("Unauthorized. User %s cannot evaluate deployment policies in org %s.", user_ec.GetExchangeId(), org), http.StatusForbidden)
+			return
+		} else if nodeIndex == nil {
+			writeResponse(w, msgPrinter.Sprintf("The node index is not enabled on this agbot."), http.StatusServiceUnavailable)
+			return
+		}
+
+		// A deployment policy that the agbot does not serve yet is evaluated against the nodes in its own org.
+		var nodeOrgs []string
+		if businessPolManager != nil {
+			nodeOrgs = businessPolManager.GetServedNodeOrgs(org, name)
+		}
+		if len(nodeOrgs) == 0 {
+			nodeOrgs = []string{org}
+		}
+
+		if output, err := EvaluateWhatIf(a.db, nodeIndex, org, name, input.BusinessPolicy, nodeOrgs, a.Config.AgreementBot.ActiveDeviceTimeoutS); err != nil {
+			glog.Errorf(APIlogString(fmt.Sprintf("unable to evaluate deployment policy %v, error: %v", input.BusinessPolicyId, err)))
+			writeResponse(w, msgPrinter.Sprintf("Unable to evaluate deployment policy %v. %v", input.BusinessPolicyId, err), http.StatusBadRequest)
+		} else {
+			writeResponse(w, output, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 // This function checks user cred and writes corrsponding response. It also creates a message printer with given language from the http request.
 func (a *SecureAPI) processUserCred(resource string, w http.ResponseWriter, r *http.Request) (exchange.ExchangeContext, *message.Printer, bool) {
 	// get message printer with the language passed in from the header
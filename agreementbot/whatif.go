@@ -0,0 +1,112 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/policy"
+	"sort"
+)
+
+// A what-if evaluation shows the effect that a candidate deployment policy would have, before it is put in the exchange.
+// The candidate is evaluated against the node index to find the nodes that would be candidates for an agreement, and
+// against the current agreements for the deployment policy to find the agreements that would be affected. Nothing is
+// changed by the evaluation. When the agbot is horizontally scaled, only the agreements in the partitions owned by the
+// agbot that does the evaluation are considered.
+
+type WhatIfInput struct {
+	BusinessPolicyId string                         `json:"business_policy_id"` // The org qualified id of the deployment policy that would be created or replaced
+	BusinessPolicy   *businesspolicy.BusinessPolicy `json:"business_policy"`    // The candidate deployment policy
+}
+
+type WhatIfOutput struct {
+	BusinessPolicyId string   `json:"business_policy_id"`
+	Gain             []string `json:"gain"`          // Nodes without an agreement that would get one
+	Lose             []string `json:"lose"`          // Nodes with an agreement that would lose it
+	Upgrade          []string `json:"upgrade"`       // Nodes with an agreement that would be replaced by one for the changed policy
+	Unchanged        int      `json:"unchanged"`     // The number of nodes with an agreement that would not be affected
+	IndexedNodes     int      `json:"indexed_nodes"` // The number of nodes in the node index
+}
+
+func (o WhatIfOutput) String() string {
+	return fmt.Sprintf("BusinessPolicyId: %v, Gain: %v, Lose: %v, Upgrade: %v, Unchanged: %v, IndexedNodes: %v",
+		o.BusinessPolicyId, o.Gain, o.Lose, o.Upgrade, o.Unchanged, o.IndexedNodes)
+}
+
+// Evaluate the candidate deployment policy org/name. The nodeOrgs are the orgs whose nodes the deployment policy would be
+// served to, and staleS is the time a node can go without heartbeating and still get a new agreement. A node that is not
+// heartbeating keeps its agreement, so staleS is not used to decide whether an agreement would be affected.
+func EvaluateWhatIf(db persistence.AgbotDatabase, index *NodeIndex, org string, name string, bp *businesspolicy.BusinessPolicy, nodeOrgs []string, staleS int) (*WhatIfOutput, error) {
+
+	polId := fmt.Sprintf("%v/%v", org, name)
+	candidate, err := bp.GenPolicyFromBusinessPolicy(polId)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &WhatIfOutput{
+		BusinessPolicyId: polId,
+		Gain:             make([]string, 0),
+		Lose:             make([]string, 0),
+		Upgrade:          make([]string, 0),
+		IndexedNodes:     index.Len(),
+	}
+
+	candidates := make(map[string]bool)
+	for _, dev := range index.FindNodes(candidate, nodeOrgs, 0, "", 0, 0) {
+		candidates[dev.Id] = true
+	}
+
+	// Only the nodes that are heartbeating are searched for new agreements.
+	active := make(map[string]bool)
+	for _, dev := range index.FindNodes(candidate, nodeOrgs, 0, "", 0, staleS) {
+		active[dev.Id] = true
+	}
+
+	// The same comparison that is made when a deployment policy changes decides whether an agreement is kept.
+	pm := policy.PolicyManager_Factory(false, false)
+	if err := pm.AddPolicy(org, candidate); err != nil {
+		return nil, fmt.Errorf("unable to evaluate policy %v, error: %v", polId, err)
+	}
+
+	// Nodes with an agreement that is still being negotiated are neither gained nor affected.
+	inUse := make(map[string]bool)
+	for _, agp := range policy.AllAgreementProtocols() {
+		agreements, err := db.FindAgreements([]persistence.AFilter{persistence.UnarchivedAFilter(), persistence.PolicyNameAFilter(polId)}, agp)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read agreements for policy %v, error: %v", polId, err)
+		}
+
+		for _, ag := range agreements {
+			inUse[ag.DeviceId] = true
+			if ag.AgreementCreationTime == 0 || ag.AgreementTimedout != 0 {
+				continue
+			} else if !candidates[ag.DeviceId] {
+				output.Lose = append(output.Lose, ag.DeviceId)
+			} else if agPol, err := policy.DemarshalPolicy(ag.Policy); err != nil {
+				return nil, fmt.Errorf("unable to demarshal policy for agreement %v, error %v", ag.CurrentAgreementId, err)
+			} else if err := pm.MatchesMine(org, agPol); err != nil {
+				glog.V(5).Infof(AWlogString(fmt.Sprintf("what-if for %v would replace agreement %v: %v", polId, ag.CurrentAgreementId, err)))
+				output.Upgrade = append(output.Upgrade, ag.DeviceId)
+			} else {
+				output.Unchanged += 1
+			}
+		}
+	}
+
+	// The node index only checks the deployment policy constraints, a node gains an agreement only when the properties
+	// of the deployment policy also satisfy the node constraints.
+	for id := range active {
+		if !inUse[id] && index.AcceptsPolicy(id, candidate) {
+			output.Gain = append(output.Gain, id)
+		}
+	}
+
+	sort.Strings(output.Gain)
+	sort.Strings(output.Lose)
+	sort.Strings(output.Upgrade)
+
+	glog.V(3).Infof(AWlogString(fmt.Sprintf("what-if for %v: %v", polId, output)))
+	return output, nil
+}
@@ -0,0 +1,112 @@
+// +build unit
+
+package agreementbot
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/agreementbot/persistence"
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/externalpolicy"
+	_ "github.com/open-horizon/anax/externalpolicy/text_language"
+	"github.com/open-horizon/anax/policy"
+	"os"
+	"testing"
+	"time"
+)
+
+func whatifBusinessPolicy(version string, constraint string) *businesspolicy.BusinessPolicy {
+	return &businesspolicy.BusinessPolicy{
+		Service: businesspolicy.ServiceRef{
+			Name:            "svc1",
+			Org:             "org1",
+			ServiceVersions: []businesspolicy.WorkloadChoice{{Version: version}},
+		},
+		Constraints: externalpolicy.ConstraintExpression{constraint},
+	}
+}
+
+// Create an agreement with the device that was made with the input deployment policy.
+func whatifAgreement(t *testing.T, db persistence.AgbotDatabase, agid string, deviceId string, bp *businesspolicy.BusinessPolicy) {
+	pol, err := bp.GenPolicyFromBusinessPolicy("org1/bp1")
+	if err != nil {
+		t.Fatalf("unable to generate policy, error %v", err)
+	}
+	polBytes, _ := json.Marshal(pol)
+
+	if err := db.AgreementAttempt(agid, "org1", deviceId, "device", "org1/bp1", "", "", "", policy.BasicProtocol, "", []string{"org1/svc1"}, policy.NodeHealth{}, 0, 0, ""); err != nil {
+		t.Fatalf("unable to create agreement for %v, error %v", deviceId, err)
+	} else if _, err := db.AgreementUpdate(agid, "", string(polBytes), policy.DataVerification{}, 0, "", "", policy.BasicProtocol, 2); err != nil {
+		t.Fatalf("unable to update agreement for %v, error %v", deviceId, err)
+	} else if _, err := db.AgreementMade(agid, "", "", policy.BasicProtocol, []string{}, "", "", ""); err != nil {
+		t.Fatalf("unable to make agreement for %v, error %v", deviceId, err)
+	}
+}
+
+func Test_whatif_deployment_policy(t *testing.T) {
+
+	db, dir := haTestDB(t)
+	defer os.RemoveAll(dir)
+
+	red := policy.Policy_Factory("node")
+	red.Add_Property(externalpolicy.Property_Factory("color", "red"), false)
+	blue := policy.Policy_Factory("node")
+	blue.Add_Property(externalpolicy.Property_Factory("color", "blue"), false)
+
+	// n4 accepts the candidate, n5 has a node constraint that the candidate does not satisfy.
+	redDemo := policy.Policy_Factory("node")
+	redDemo.Add_Property(externalpolicy.Property_Factory("color", "red"), false)
+	redDemo.Constraints = externalpolicy.ConstraintExpression{"purpose == demo"}
+	redTest := policy.Policy_Factory("node")
+	redTest.Add_Property(externalpolicy.Property_Factory("color", "red"), false)
+	redTest.Constraints = externalpolicy.ConstraintExpression{"purpose == test"}
+
+	ni := NewNodeIndex()
+	for id, pol := range map[string]*policy.Policy{"org1/n1": red, "org1/n2": red, "org1/n3": blue, "org1/n4": redDemo, "org1/n5": redTest} {
+		ni.UpdateNode(id, indexedDevice("", "amd64"))
+		ni.UpdateNodePolicy(id, pol)
+	}
+
+	candidate := whatifBusinessPolicy("1.0.0", "color == red")
+	candidate.Properties = externalpolicy.PropertyList{*externalpolicy.Property_Factory("purpose", "demo")}
+
+	// n1 runs the candidate already, n2 runs an older version, n3 no longer matches and n4 has no agreement.
+	whatifAgreement(t, db, "ag1", "org1/n1", candidate)
+	whatifAgreement(t, db, "ag2", "org1/n2", whatifBusinessPolicy("0.9.0", "color == red"))
+	whatifAgreement(t, db, "ag3", "org1/n3", whatifBusinessPolicy("0.9.0", "color == blue"))
+
+	if output, err := EvaluateWhatIf(db, ni, "org1", "bp1", candidate, []string{"org1"}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(output.Gain) != 1 || output.Gain[0] != "org1/n4" {
+		t.Errorf("wrong gained nodes %v", output)
+	} else if len(output.Lose) != 1 || output.Lose[0] != "org1/n3" {
+		t.Errorf("wrong lost nodes %v", output)
+	} else if len(output.Upgrade) != 1 || output.Upgrade[0] != "org1/n2" {
+		t.Errorf("wrong upgraded nodes %v", output)
+	} else if output.Unchanged != 1 || output.IndexedNodes != 5 {
+		t.Errorf("wrong counts %v", output)
+	}
+
+	// n1 and n4 stopped heartbeating. n1 keeps its agreement, and n4 is not searched for a new agreement.
+	stale := time.Now().Add(-time.Hour).UTC().Format(cutil.ExchangeTimeFormat)
+	for _, id := range []string{"org1/n1", "org1/n4"} {
+		dev := indexedDevice("", "amd64")
+		dev.LastHeartbeat = stale
+		ni.UpdateNode(id, dev)
+	}
+	if output, err := EvaluateWhatIf(db, ni, "org1", "bp1", candidate, []string{"org1"}, 600); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(output.Gain) != 0 {
+		t.Errorf("expected no gained nodes, got %v", output)
+	} else if len(output.Lose) != 1 || output.Lose[0] != "org1/n3" {
+		t.Errorf("wrong lost nodes %v", output)
+	} else if len(output.Upgrade) != 1 || output.Upgrade[0] != "org1/n2" || output.Unchanged != 1 {
+		t.Errorf("expected the stale node to keep its agreement, got %v", output)
+	}
+
+	// An invalid candidate is rejected.
+	if _, err := EvaluateWhatIf(db, ni, "org1", "bp1", &businesspolicy.BusinessPolicy{}, []string{"org1"}, 0); err == nil {
+		t.Errorf("expected an error for an invalid deployment policy")
+	}
+
+}
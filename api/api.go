@@ -76,6 +76,7 @@ func (a *API) router(includeStaticRedirects bool) *mux.Router {
 	// For working with existing or archived agreements
 	router.HandleFunc("/agreement", a.agreement).Methods("GET", "OPTIONS")
 	router.HandleFunc("/agreement/{id}", a.agreement).Methods("GET", "DELETE", "OPTIONS")
+	router.HandleFunc("/agreement/{id}/history", a.agreementhistory).Methods("GET", "OPTIONS")
 
 	// For obtaining microservice info or configuring a microservice (sensor) userInput variables
 	router.HandleFunc("/service", a.service).Methods("GET", "OPTIONS")
@@ -95,6 +96,7 @@ func (a *API) router(includeStaticRedirects bool) *mux.Router {
 	router.HandleFunc("/node/configstate", a.nodeconfigstate).Methods("GET", "HEAD", "PUT", "OPTIONS")
 	router.HandleFunc("/node/policy", a.nodepolicy).Methods("GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS")
 	router.HandleFunc("/node/userinput", a.nodeuserinput).Methods("GET", "HEAD", "PUT", "POST", "PATCH", "DELETE", "OPTIONS")
+	router.HandleFunc("/node/proposal/test", a.nodeproposaltest).Methods("GET", "OPTIONS")
 
 	// Used to get the event logs on this node.
 	// get the eventlogs for current registration.
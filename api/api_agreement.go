@@ -63,3 +63,31 @@ func (a *API) agreement(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+func (a *API) agreementhistory(w http.ResponseWriter, r *http.Request) {
+
+	resource := "agreement history"
+	errorhandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+		pathVars := mux.Vars(r)
+		id := pathVars["id"]
+
+		// Get the state transitions of the agreement, active or archived.
+		if out, err := FindAgreementHistoryForOutput(a.db, id); err != nil {
+			errorhandler(NewSystemError(fmt.Sprintf("Error getting %v for output, error %v", resource, err)))
+		} else if out == nil {
+			errorhandler(NewNotFoundError(fmt.Sprintf("agreement %v not found", id), "id"))
+		} else {
+			writeResponse(w, out, http.StatusOK)
+		}
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
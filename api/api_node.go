@@ -537,3 +537,38 @@ func (a *API) nodeuserinput(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
+
+func (a *API) nodeproposaltest(w http.ResponseWriter, r *http.Request) {
+
+	resource := "node/proposal/test"
+
+	errorHandler := GetHTTPErrorHandler(w)
+
+	switch r.Method {
+	case "GET":
+		glog.V(5).Infof(apiLogString(fmt.Sprintf("Handling %v on resource %v", r.Method, resource)))
+
+		deploymentPolicy := r.URL.Query().Get("deployment_policy")
+		pattern := r.URL.Query().Get("pattern")
+
+		getBusinessPolicies := exchange.GetHTTPBusinessPoliciesHandler(a)
+		getPatterns := exchange.GetHTTPExchangePatternHandler(a)
+		resolveService := exchange.GetHTTPServiceResolverHandler(a)
+		getService := exchange.GetHTTPServiceHandler(a)
+		getSigningKeys := exchange.GetHTTPObjectSigningKeysHandler(a)
+
+		// Evaluate the proposal without persisting or starting anything.
+		errHandled, out := TestNodeProposal(deploymentPolicy, pattern, errorHandler, getBusinessPolicies, getPatterns, resolveService, getService, getSigningKeys, a.db, a.Config)
+		if errHandled {
+			return
+		}
+
+		writeResponse(w, out, http.StatusOK)
+
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
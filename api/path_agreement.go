@@ -44,6 +44,36 @@ func FindAgreementsForOutput(db *bolt.DB) (map[string]map[string][]persistence.E
 	return wrap, nil
 }
 
+// The state history of an agreement, nil if the agreement is not found.
+type AgreementHistory struct {
+	AgreementId string                            `json:"agreement_id"`
+	Archived    bool                              `json:"archived"`
+	History     []persistence.AgreementTransition `json:"history"`
+}
+
+func FindAgreementHistoryForOutput(db *bolt.DB, agreementId string) (*AgreementHistory, error) {
+
+	agreements, err := persistence.FindEstablishedAgreementsAllProtocols(db, policy.AllAgreementProtocols(), []persistence.EAFilter{persistence.IdEAFilter(agreementId)})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to read agreement objects, error %v", err))
+	} else if len(agreements) == 0 {
+		return nil, nil
+	}
+
+	out := &AgreementHistory{
+		AgreementId: agreementId,
+		Archived:    agreements[0].Archived,
+		History:     agreements[0].StateHistory,
+	}
+
+	// Agreements created before the history was recorded only have the creation time.
+	if len(out.History) == 0 {
+		out.History = []persistence.AgreementTransition{{State: persistence.AG_STATE_CREATED, Time: agreements[0].AgreementCreationTime}}
+	}
+
+	return out, nil
+}
+
 func DeleteAgreement(errorhandler ErrorHandler, agreementId string, db *bolt.DB) (bool, *events.ApiAgreementCancelationMessage) {
 
 	glog.V(3).Infof(apiLogString(fmt.Sprintf("Handling DELETE of agreement: %v", agreementId)))
@@ -223,3 +223,56 @@ func Test_DeleteAgreement3(t *testing.T) {
 	}
 
 }
+
+func Test_FindAgreementHistoryForOutput(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	sp := persistence.ServiceSpec{Url: "http://sensor.org", Org: "myorg"}
+	sps := []persistence.ServiceSpec{sp}
+
+	wi, _ := persistence.NewWorkloadInfo("url", "org", "version", "")
+	if _, err := persistence.NewEstablishedAgreement(db, "name1", "agreementId1", "consumerId", "{}", "Basic", 1, sps, "signature", "address", "bcType", "bcName", "bcOrg", wi, 180); err != nil {
+		t.Errorf("error writing agreement1: %v", err)
+	} else if _, err := persistence.AgreementStateAccepted(db, "agreementId1", "Basic"); err != nil {
+		t.Errorf("error accepting agreement1: %v", err)
+	} else if _, err := persistence.AgreementStateDataReceived(db, "agreementId1", "Basic"); err != nil {
+		t.Errorf("error receiving data for agreement1: %v", err)
+	} else if _, err := persistence.AgreementStateDataReceived(db, "agreementId1", "Basic"); err != nil {
+		t.Errorf("error receiving data for agreement1: %v", err)
+	} else if _, err := persistence.AgreementStateTerminated(db, "agreementId1", 100, "unit test termination", "Basic"); err != nil {
+		t.Errorf("error terminating agreement1: %v", err)
+	} else if _, err := persistence.AgreementStateTerminated(db, "agreementId1", 100, "unit test termination", "Basic"); err != nil {
+		t.Errorf("error terminating agreement1 again: %v", err)
+	} else if _, err := persistence.ArchiveEstablishedAgreement(db, "agreementId1", "Basic"); err != nil {
+		t.Errorf("error archiving agreement1: %v", err)
+	}
+
+	expected := []string{persistence.AG_STATE_CREATED, persistence.AG_STATE_ACCEPTED, persistence.AG_STATE_DATA_RECEIVED, persistence.AG_STATE_TERMINATED, persistence.AG_STATE_ARCHIVED}
+
+	if out, err := FindAgreementHistoryForOutput(db, "agreementId1"); err != nil {
+		t.Errorf("error finding agreement history: %v", err)
+	} else if out == nil || !out.Archived || len(out.History) != len(expected) {
+		t.Errorf("expecting %v transitions for an archived agreement, have %v", len(expected), out)
+	} else {
+		for ix, state := range expected {
+			if out.History[ix].State != state {
+				t.Errorf("expecting transition %v to be %v, have %v", ix, state, out.History[ix])
+			}
+		}
+		if out.History[3].Reason != "unit test termination" {
+			t.Errorf("expecting the termination reason in %v", out.History[3])
+		}
+	}
+
+	if out, err := FindAgreementHistoryForOutput(db, "agreementId2"); err != nil {
+		t.Errorf("error finding agreement history: %v", err)
+	} else if out != nil {
+		t.Errorf("expecting no history for an unknown agreement, have %v", out)
+	}
+
+}
@@ -174,9 +174,46 @@ func parseHA(errorhandler ErrorHandler, permitEmpty bool, given *Attribute) (*pe
 			strPartners = append(strPartners, p)
 
 		}
+
+		// The rolling upgrade settings are optional.
+		var maxUnavailable, minHealthyDuration int64
+		var err error
+		if m, exists := (*given.Mappings)["maxUnavailable"]; exists {
+			if _, ok := m.(json.Number); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected integer", "ha.mappings.maxUnavailable")), nil
+			} else if maxUnavailable, err = m.(json.Number).Int64(); err != nil || maxUnavailable < 0 {
+				return nil, errorhandler(NewAPIUserInputError("must be a non-negative integer", "ha.mappings.maxUnavailable")), nil
+			}
+		}
+		if d, exists := (*given.Mappings)["minHealthyDuration"]; exists {
+			if _, ok := d.(json.Number); !ok {
+				return nil, errorhandler(NewAPIUserInputError("expected integer", "ha.mappings.minHealthyDuration")), nil
+			} else if minHealthyDuration, err = d.(json.Number).Int64(); err != nil || minHealthyDuration < 0 {
+				return nil, errorhandler(NewAPIUserInputError("must be a non-negative integer", "ha.mappings.minHealthyDuration")), nil
+			}
+		}
+
+		strOrder := make([]string, 0, 5)
+		if o, exists := (*given.Mappings)["order"]; exists {
+			if order, ok := o.([]interface{}); !ok {
+				return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("expected []interface{} received %T", o), "ha.mappings.order")), nil
+			} else {
+				for _, val := range order {
+					if p, ok := val.(string); !ok {
+						return nil, errorhandler(NewAPIUserInputError(fmt.Sprintf("array value is not a string, it is %T", val), "ha.mappings.order")), nil
+					} else {
+						strOrder = append(strOrder, p)
+					}
+				}
+			}
+		}
+
 		return &persistence.HAAttributes{
-			Meta:     generateAttributeMetadata(*given, reflect.TypeOf(persistence.HAAttributes{}).Name()),
-			Partners: strPartners,
+			Meta:                generateAttributeMetadata(*given, reflect.TypeOf(persistence.HAAttributes{}).Name()),
+			Partners:            strPartners,
+			MaxUnavailable:      int(maxUnavailable),
+			MinHealthyDurationS: int(minHealthyDuration),
+			Order:               strOrder,
 		}, false, nil
 	}
 }
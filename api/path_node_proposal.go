@@ -0,0 +1,348 @@
+package api
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/abstractprotocol"
+	"github.com/open-horizon/anax/basicprotocol"
+	"github.com/open-horizon/anax/compcheck"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// The outcome of a proposal dry run for one of the policies generated from a deployment policy or pattern.
+type ProposalTestResult struct {
+	Policy   string   `json:"policy"`            // The name of the policy the proposal would be made from
+	Service  string   `json:"service,omitempty"` // The org qualified url, version and arch of the proposed service
+	Accepted bool     `json:"accepted"`
+	Reasons  []string `json:"reasons,omitempty"` // Every reason the node would reject the proposal
+}
+
+type ProposalTest struct {
+	NodeId           string               `json:"node_id"`
+	DeploymentPolicy string               `json:"deployment_policy,omitempty"`
+	Pattern          string               `json:"pattern,omitempty"`
+	Results          []ProposalTestResult `json:"results"`
+}
+
+// Evaluate the proposal that an agbot would send to this node for a deployment policy or pattern, without persisting or
+// starting anything. The terms and conditions are built the same way the agbot builds them, and then the checks that
+// the node makes when a proposal arrives are run against them. Unlike proposal processing, which stops at the first
+// failed check, all checks are run so that every reason for a rejection is returned.
+func TestNodeProposal(deploymentPolicyId string, patternId string,
+	errorhandler ErrorHandler,
+	getBusinessPolicies exchange.BusinessPoliciesHandler,
+	getPatterns exchange.PatternHandler,
+	resolveService exchange.ServiceResolverHandler,
+	getService exchange.ServiceHandler,
+	getSigningKeys exchange.ObjectSigningKeysHandler,
+	db *bolt.DB,
+	cfg *config.HorizonConfig) (bool, *ProposalTest) {
+
+	if (deploymentPolicyId == "") == (patternId == "") {
+		return errorhandler(NewAPIUserInputError("Exactly one of deployment_policy or pattern must be specified.", "deployment_policy")), nil
+	}
+
+	// The node must be registered, the proposal is evaluated against its registration.
+	pDevice, err := persistence.FindExchangeDevice(db)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node object, error %v", err))), nil
+	} else if pDevice == nil {
+		return errorhandler(NewNotFoundError("Exchange registration not recorded. Complete account and node registration with an exchange and then record node registration using this API's /node path.", "node")), nil
+	}
+	nodeId := fmt.Sprintf("%v/%v", pDevice.Org, pDevice.Id)
+
+	// Deployment policies and patterns without an org are assumed to be in the node's org.
+	if deploymentPolicyId != "" && exchange.GetOrg(deploymentPolicyId) == "" {
+		deploymentPolicyId = fmt.Sprintf("%v/%v", pDevice.Org, deploymentPolicyId)
+	} else if patternId != "" && exchange.GetOrg(patternId) == "" {
+		patternId = fmt.Sprintf("%v/%v", pDevice.Org, patternId)
+	}
+
+	// Generate the consumer policies the agbot would use.
+	consumerPolicies := make([]*policy.Policy, 0, 1)
+	if deploymentPolicyId != "" {
+		if bps, err := getBusinessPolicies(exchange.GetOrg(deploymentPolicyId), exchange.GetId(deploymentPolicyId)); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("Unable to get deployment policy %v from the exchange, error %v", deploymentPolicyId, err))), nil
+		} else if bp, ok := bps[deploymentPolicyId]; !ok {
+			return errorhandler(NewNotFoundError(fmt.Sprintf("deployment policy %v not found in the exchange", deploymentPolicyId), "deployment_policy")), nil
+		} else if pol, err := bp.GenPolicyFromBusinessPolicy(deploymentPolicyId); err != nil {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to convert deployment policy %v, error %v", deploymentPolicyId, err), "deployment_policy")), nil
+		} else {
+			consumerPolicies = append(consumerPolicies, pol)
+		}
+	} else {
+		if patterns, err := getPatterns(exchange.GetOrg(patternId), exchange.GetId(patternId)); err != nil {
+			return errorhandler(NewSystemError(fmt.Sprintf("Unable to get pattern %v from the exchange, error %v", patternId, err))), nil
+		} else if pattern, ok := patterns[patternId]; !ok {
+			return errorhandler(NewNotFoundError(fmt.Sprintf("pattern %v not found in the exchange", patternId), "pattern")), nil
+		} else if pols, err := exchange.ConvertToPolicies(patternId, &pattern); err != nil {
+			return errorhandler(NewAPIUserInputError(fmt.Sprintf("Unable to convert pattern %v, error %v", patternId, err), "pattern")), nil
+		} else {
+			// Proposals are only made for the services in the pattern that match the architecture of the node.
+			for _, pol := range pols {
+				if len(pol.Workloads) != 0 && isThisArch(pol.Workloads[0].Arch, cfg) {
+					consumerPolicies = append(consumerPolicies, pol)
+				}
+			}
+			if len(consumerPolicies) == 0 {
+				return errorhandler(NewAPIUserInputError(fmt.Sprintf("Pattern %v has no services for the node architecture %v.", patternId, cutil.ArchString()), "pattern")), nil
+			}
+		}
+	}
+
+	// The producer side of the terms and conditions comes from the node policy.
+	extPolicy, err := persistence.FindNodePolicy(db)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node policy, error %v", err))), nil
+	} else if extPolicy == nil {
+		extPolicy = new(externalpolicy.ExternalPolicy)
+	}
+	producerPolicy, err := policy.GenPolicyFromExternalPolicy(extPolicy, policy.MakeExternalPolicyHeaderName(nodeId))
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to convert node policy, error %v", err))), nil
+	}
+
+	// The node adds its built-in properties to the producer policy before checking it against the terms and conditions.
+	producerPolicy, err = abstractprotocol.AddNodeBuiltInProps(producerPolicy, extPolicy, pDevice.IsEdgeCluster())
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to add node built-in properties to the node policy, error %v", err))), nil
+	}
+
+	nodeUserInput, err := persistence.FindNodeUserInput(db)
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to read node user input, error %v", err))), nil
+	}
+
+	pemFiles, err := cfg.Collaborators.KeyFileNamesFetcher.GetKeyFileNames(cfg.Edge.PublicKeyPath, cfg.UserPublicKeyPath())
+	if err != nil {
+		return errorhandler(NewSystemError(fmt.Sprintf("Unable to get pem key files, error %v", err))), nil
+	}
+
+	out := &ProposalTest{
+		NodeId:           nodeId,
+		DeploymentPolicy: deploymentPolicyId,
+		Pattern:          patternId,
+		Results:          make([]ProposalTestResult, 0, len(consumerPolicies)),
+	}
+
+	for _, consumerPolicy := range consumerPolicies {
+		out.Results = append(out.Results, testProposal(consumerPolicy, producerPolicy, pDevice, nodeUserInput, pemFiles, resolveService, getService, getSigningKeys, db, cfg))
+	}
+
+	return false, out
+}
+
+// Build the terms and conditions for one consumer policy and run the node's proposal checks against them.
+func testProposal(consumerPolicy *policy.Policy,
+	producerPolicy *policy.Policy,
+	pDevice *persistence.ExchangeDevice,
+	nodeUserInput []policy.UserInput,
+	pemFiles []string,
+	resolveService exchange.ServiceResolverHandler,
+	getService exchange.ServiceHandler,
+	getSigningKeys exchange.ObjectSigningKeysHandler,
+	db *bolt.DB,
+	cfg *config.HorizonConfig) ProposalTestResult {
+
+	result := ProposalTestResult{
+		Policy:  consumerPolicy.Header.Name,
+		Reasons: make([]string, 0, 5),
+	}
+	reject := func(reason string) {
+		result.Reasons = append(result.Reasons, reason)
+	}
+
+	// The agbot proposes the highest priority workload first.
+	workload := consumerPolicy.NextHighestPriorityWorkload(0, 0, 0)
+	if workload == nil {
+		reject("The policy does not contain a workload.")
+		return result
+	}
+	if workload.Arch == "" || workload.Arch == "*" {
+		workload.Arch = cutil.ArchString()
+	}
+	result.Service = fmt.Sprintf("%v/%v %v %v", workload.Org, workload.WorkloadURL, workload.Version, workload.Arch)
+
+	// Resolve the service and its dependencies, and fill in the deployment details the way the agbot does.
+	asl, sdef, _, err := resolveService(workload.WorkloadURL, workload.Org, workload.Version, workload.Arch)
+	if err != nil {
+		reject(fmt.Sprintf("Unable to resolve service %v, error %v", result.Service, err))
+		return result
+	} else if sdef == nil {
+		reject(fmt.Sprintf("Service %v not found in the exchange.", result.Service))
+		return result
+	}
+
+	nodeType := pDevice.GetNodeType()
+	if nodeType == persistence.DEVICE_TYPE_CLUSTER {
+		asl = new(policy.APISpecList)
+		workload.ClusterDeployment = sdef.GetClusterDeploymentString()
+		workload.ClusterDeploymentSignature = sdef.GetClusterDeploymentSignature()
+	} else {
+		workload.Deployment = sdef.GetDeploymentString()
+		workload.DeploymentSignature = sdef.GetDeploymentSignature()
+	}
+	if consumerPolicy.PatternId != "" {
+		consumerPolicy.APISpecs = (*asl)
+	}
+
+	tcPolicy, err := policy.Create_Terms_And_Conditions(producerPolicy, consumerPolicy, workload, "", "", cfg.AgreementBot.NoDataIntervalS, basicprotocol.PROTOCOL_CURRENT_VERSION)
+	if err != nil {
+		reject(fmt.Sprintf("Unable to create the terms and conditions, error %v", err))
+		return result
+	}
+
+	// Signing keys that the node would download from the exchange are only kept for the duration of the test.
+	keyFiles, keyDir, err := proposalSigningKeys(tcPolicy, pemFiles, getSigningKeys, cfg)
+	if err != nil {
+		reject(fmt.Sprintf("Error handling signing keys from the exchange: %v", err))
+	} else {
+		if keyDir != "" {
+			defer os.RemoveAll(keyDir)
+		}
+		if err := tcPolicy.Is_Self_Consistent(keyFiles, func(wURL string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, error) {
+			asl, _, _, err := resolveService(wURL, wOrg, wVersion, wArch)
+			return asl, err
+		}); err != nil {
+			reject(fmt.Sprintf("Error checking self consistency of TsAndCs: %v", err))
+		}
+	}
+
+	// The node rejects terms and conditions that are not compatible with its policy.
+	if err := policy.Are_Compatible(producerPolicy, tcPolicy, nil); err != nil {
+		reject(fmt.Sprintf("T and C policy is not compatible: %v", err))
+	}
+
+	if match, reason := compcheck.CheckProposalNodeType(tcPolicy, nodeType, nil); !match {
+		reject(reason)
+	}
+
+	if match, reason := compcheck.CheckProposalPattern(tcPolicy, pDevice.Pattern, nil); !match {
+		reject(reason)
+	}
+
+	if agId, err := findAgreementForPolicy(db, consumerPolicy.Header.Name); err != nil {
+		reject(fmt.Sprintf("Error finding agreement with TsAndCs name '%v', error %v", tcPolicy.Header.Name, err))
+	} else if agId != "" {
+		reject(fmt.Sprintf("Agreement %v already exists for the policy %v.", agId, consumerPolicy.Header.Name))
+	}
+
+	if _, err := consumerPolicy.ProtocolCapabilities(policy.BasicProtocol).Negotiate(basicprotocol.SUPPORTED_CAPABILITIES); err != nil {
+		reject(err.Error())
+	}
+
+	svcDef := compcheck.ServiceDefinition{Org: workload.Org, ServiceDefinition: *sdef}
+	if compatible, reason, _, err := compcheck.VerifyUserInputForSingleServiceDef(&svcDef, consumerPolicy.UserInput, nodeUserInput, nil); err != nil {
+		reject(fmt.Sprintf("Error validating the user input for service %v: %v", result.Service, err))
+	} else if !compatible {
+		reject(fmt.Sprintf("User input does not meet the requirement for service %v: %v", result.Service, reason))
+	}
+	for _, apiSpec := range *asl {
+		svcSpec := compcheck.NewServiceSpec(apiSpec.SpecRef, apiSpec.Org, apiSpec.Version, apiSpec.Arch)
+		if compatible, reason, _, err := compcheck.VerifyUserInputForSingleService(svcSpec, getService, consumerPolicy.UserInput, nodeUserInput, nil); err != nil {
+			reject(fmt.Sprintf("Error validating the user input for dependent service %v/%v %v %v: %v", apiSpec.Org, apiSpec.SpecRef, apiSpec.Version, apiSpec.Arch, err))
+		} else if !compatible {
+			reject(fmt.Sprintf("User input does not meet the requirement for dependent service %v/%v %v %v: %v", apiSpec.Org, apiSpec.SpecRef, apiSpec.Version, apiSpec.Arch, reason))
+		}
+	}
+
+	result.Accepted = len(result.Reasons) == 0
+	glog.V(5).Infof(apiLogString(fmt.Sprintf("proposal test for policy %v returned %v", consumerPolicy.Header.Name, result)))
+
+	return result
+}
+
+// Returns the key files to verify the signatures in the terms and conditions with. When the node trusts the signing keys
+// published in the exchange, they are written to a temporary directory whose files are appended to the pem files. The
+// caller removes the temporary directory, if one is returned.
+func proposalSigningKeys(tcPolicy *policy.Policy, pemFiles []string, getSigningKeys exchange.ObjectSigningKeysHandler, cfg *config.HorizonConfig) ([]string, string, error) {
+
+	if !cfg.Edge.TrustCertUpdatesFromOrg {
+		return pemFiles, "", nil
+	}
+
+	keys := make(map[string]string)
+	if tcPolicy.PatternId != "" {
+		if keyMap, err := getSigningKeys(exchange.PATTERN, exchange.GetId(tcPolicy.PatternId), exchange.GetOrg(tcPolicy.PatternId), "", ""); err != nil {
+			return nil, "", fmt.Errorf("unable to get signing keys for pattern %v, error %v", tcPolicy.PatternId, err)
+		} else {
+			for k, v := range keyMap {
+				keys[k] = v
+			}
+		}
+	}
+	for _, wl := range tcPolicy.Workloads {
+		if keyMap, err := getSigningKeys(exchange.SERVICE, wl.WorkloadURL, wl.Org, wl.Version, wl.Arch); err != nil {
+			return nil, "", fmt.Errorf("unable to get signing keys for service %v/%v %v %v, error %v", wl.Org, wl.WorkloadURL, wl.Version, wl.Arch, err)
+		} else {
+			for k, v := range keyMap {
+				keys[k] = v
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return pemFiles, "", nil
+	}
+
+	dir, err := ioutil.TempDir("", "proposaltest-")
+	if err != nil {
+		return nil, "", err
+	}
+	keyFiles := append([]string{}, pemFiles...)
+	for key, content := range keys {
+		fn := key
+		if !strings.HasSuffix(key, ".pem") {
+			fn = fmt.Sprintf("%v.pem", key)
+		}
+		fn = path.Join(dir, path.Base(fn))
+		if err := ioutil.WriteFile(fn, []byte(content), 0600); err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		keyFiles = append(keyFiles, fn)
+	}
+	return keyFiles, dir, nil
+}
+
+// Returns the id of an active agreement made from the consumer policy, the node ignores proposals for it.
+// The terms and conditions of an agreement are named after the producer and consumer policies they were made from.
+func findAgreementForPolicy(db *bolt.DB, consumerPolicyName string) (string, error) {
+
+	notTerminated := func() persistence.EAFilter {
+		return func(a persistence.EstablishedAgreement) bool {
+			return a.AgreementTerminatedTime == 0
+		}
+	}
+
+	if ags, err := persistence.FindEstablishedAgreementsAllProtocols(db, policy.AllAgreementProtocols(), []persistence.EAFilter{notTerminated(), persistence.UnarchivedEAFilter()}); err != nil {
+		return "", err
+	} else {
+		for _, ag := range ags {
+			if proposal, err := abstractprotocol.DemarshalProposal(ag.Proposal); err != nil {
+				return "", fmt.Errorf("unable to demarshal agreement %v proposal, error %v", ag.CurrentAgreementId, err)
+			} else if tcPolicy, err := policy.DemarshalPolicy(proposal.TsAndCs()); err != nil {
+				return "", fmt.Errorf("unable to demarshal agreement %v TsAndCs, error %v", ag.CurrentAgreementId, err)
+			} else if strings.HasSuffix(tcPolicy.Header.Name, " merged with "+consumerPolicyName) {
+				return ag.CurrentAgreementId, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Returns true if the arch is the arch of this node.
+func isThisArch(arch string, cfg *config.HorizonConfig) bool {
+	thisArch := cutil.ArchString()
+	return arch == thisArch || cfg.ArchSynonyms.GetCanonicalArch(arch) == thisArch
+}
@@ -0,0 +1,152 @@
+// +build unit
+
+package api
+
+import (
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"strings"
+	"testing"
+)
+
+// Verify that a proposal dry run returns every reason the node would reject the proposal.
+func Test_TestNodeProposal(t *testing.T) {
+
+	dir, db, err := utsetup()
+	if err != nil {
+		t.Error(err)
+	}
+	defer cleanTestDir(dir)
+
+	cfg := getBasicConfig()
+	cfg.Collaborators.KeyFileNamesFetcher = &config.KeyFileNamesFetcher{
+		GetKeyFileNames: func(publicKeyPath, userKeyPath string) ([]string, error) {
+			return []string{}, nil
+		},
+	}
+
+	var myError error
+	errorhandler := GetPassThroughErrorHandler(&myError)
+
+	getBusinessPolicies := func(org string, policy_id string) (map[string]exchange.ExchangeBusinessPolicy, error) {
+		bp := exchange.ExchangeBusinessPolicy{
+			BusinessPolicy: businesspolicy.BusinessPolicy{
+				Service: businesspolicy.ServiceRef{
+					Name:            "svc1",
+					Org:             "myorg",
+					Arch:            "*",
+					ServiceVersions: []businesspolicy.WorkloadChoice{{Version: "1.0.0"}},
+				},
+			},
+		}
+		return map[string]exchange.ExchangeBusinessPolicy{"myorg/bp1": bp}, nil
+	}
+
+	resolveService := func(wUrl string, wOrg string, wVersion string, wArch string) (*policy.APISpecList, *exchange.ServiceDefinition, []string, error) {
+		sdef := &exchange.ServiceDefinition{
+			URL:                 wUrl,
+			Version:             wVersion,
+			Arch:                wArch,
+			Deployment:          `{"services":{"svc1":{"image":"svc1:1.0.0"}}}`,
+			DeploymentSignature: "notasignature",
+			UserInputs:          []exchange.UserInput{{Name: "var1", Type: "string"}},
+		}
+		return new(policy.APISpecList), sdef, []string{"myorg/svc1_1.0.0_amd64"}, nil
+	}
+
+	// The node must be registered.
+	if errHandled, _ := TestNodeProposal("myorg/bp1", "", errorhandler, getBusinessPolicies, getDummyGetPatterns(), resolveService, getDummyServiceHandler(), nil, db, cfg); !errHandled {
+		t.Errorf("expected an error for an unregistered node")
+	} else if _, ok := myError.(*NotFoundError); !ok {
+		t.Errorf("expected a not found error, got %v", myError)
+	}
+
+	if _, err := persistence.SaveNewExchangeDevice(db, "testid", "testtoken", "testname", "device", false, "myorg", "", persistence.CONFIGSTATE_CONFIGURED); err != nil {
+		t.Errorf("unexpected error saving the node: %v", err)
+	}
+
+	// Exactly one of the deployment policy or pattern is required.
+	myError = nil
+	if errHandled, _ := TestNodeProposal("", "", errorhandler, getBusinessPolicies, getDummyGetPatterns(), resolveService, getDummyServiceHandler(), nil, db, cfg); !errHandled {
+		t.Errorf("expected an error when neither a deployment policy nor a pattern is given")
+	} else if _, ok := myError.(*APIUserInputError); !ok {
+		t.Errorf("expected an input error, got %v", myError)
+	}
+
+	// The deployment signature cannot be verified and the user input is missing.
+	myError = nil
+	errHandled, out := TestNodeProposal("myorg/bp1", "", errorhandler, getBusinessPolicies, getDummyGetPatterns(), resolveService, getDummyServiceHandler(), nil, db, cfg)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if out.NodeId != "myorg/testid" || len(out.Results) != 1 {
+		t.Errorf("wrong proposal test output %v", out)
+	} else if res := out.Results[0]; res.Accepted || len(res.Reasons) != 2 {
+		t.Errorf("expected the proposal to be rejected for 2 reasons, got %v", res)
+	} else if !strings.Contains(res.Reasons[0], "deployment signature") || !strings.Contains(res.Reasons[1], "user input") {
+		t.Errorf("wrong rejection reasons %v", res.Reasons)
+	}
+
+	// The node policy does not satisfy the constraints of the deployment policy.
+	constraint := "purpose == testing"
+	getConstrainedPolicies := func(org string, policy_id string) (map[string]exchange.ExchangeBusinessPolicy, error) {
+		bps, err := getBusinessPolicies(org, policy_id)
+		bp := bps["myorg/bp1"]
+		bp.Constraints = externalpolicy.ConstraintExpression{constraint}
+		return map[string]exchange.ExchangeBusinessPolicy{"myorg/bp1": bp}, err
+	}
+
+	myError = nil
+	errHandled, out = TestNodeProposal("myorg/bp1", "", errorhandler, getConstrainedPolicies, getDummyGetPatterns(), resolveService, getDummyServiceHandler(), nil, db, cfg)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if len(out.Results) != 1 {
+		t.Errorf("wrong proposal test output %v", out)
+	} else if res := out.Results[0]; res.Accepted || len(res.Reasons) != 1 {
+		t.Errorf("expected the proposal to be rejected for 1 reason, got %v", res)
+	} else if !strings.Contains(res.Reasons[0], "do not satisfy constraint") {
+		t.Errorf("wrong rejection reasons %v", res.Reasons)
+	}
+
+	// The built-in properties of the node are part of the node policy.
+	constraint = "openhorizon.arch == " + cutil.ArchString()
+	myError = nil
+	errHandled, out = TestNodeProposal("myorg/bp1", "", errorhandler, getConstrainedPolicies, getDummyGetPatterns(), resolveService, getDummyServiceHandler(), nil, db, cfg)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if len(out.Results) != 1 {
+		t.Errorf("wrong proposal test output %v", out)
+	} else if res := out.Results[0]; res.Accepted || len(res.Reasons) != 2 {
+		t.Errorf("expected the proposal to be rejected for 2 reasons, got %v", res)
+	}
+
+	// The node is not registered with the pattern.
+	getPatterns := func(org string, pattern string) (map[string]exchange.Pattern, error) {
+		p := exchange.Pattern{
+			Services: []exchange.ServiceReference{{
+				ServiceURL:      "svc1",
+				ServiceOrg:      "myorg",
+				ServiceArch:     cutil.ArchString(),
+				ServiceVersions: []exchange.WorkloadChoice{{Version: "1.0.0"}},
+			}},
+		}
+		return map[string]exchange.Pattern{"myorg/p1": p}, nil
+	}
+
+	myError = nil
+	errHandled, out = TestNodeProposal("", "myorg/p1", errorhandler, getBusinessPolicies, getPatterns, resolveService, getDummyServiceHandler(), nil, db, cfg)
+	if errHandled {
+		t.Errorf("unexpected error %v", myError)
+	} else if out.Pattern != "myorg/p1" || len(out.Results) != 1 {
+		t.Errorf("wrong proposal test output %v", out)
+	} else if res := out.Results[0]; res.Accepted || len(res.Reasons) != 3 {
+		t.Errorf("expected the proposal to be rejected for 3 reasons, got %v", res)
+	} else if !strings.Contains(res.Reasons[1], "does not match the pattern") {
+		t.Errorf("wrong rejection reasons %v", res.Reasons)
+	}
+
+}
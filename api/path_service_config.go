@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/basicprotocol"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/eventlog"
@@ -371,7 +372,7 @@ func CreateService(service *Service,
 	}
 
 	// Information advertised in the edge node policy file
-	var haPartner []string
+	var haGroup *policy.HighAvailabilityGroup
 	var globalAgreementProtocols []interface{}
 
 	props := make(map[string]interface{})
@@ -386,13 +387,13 @@ func CreateService(service *Service,
 	for _, attr := range allAttrs {
 		// Extract HA property
 		if attr.GetMeta().Type == "HAAttributes" {
-			haPartner = attr.(persistence.HAAttributes).Partners
+			haGroup = attr.(persistence.HAAttributes).GetHAGroup()
 			glog.V(5).Infof(apiLogString(fmt.Sprintf("Found default global HA attribute %v", attr)))
 		}
 	}
 
 	// If an HA device has no HA attribute then the configuration is invalid.
-	if pDevice.HA && len(haPartner) == 0 {
+	if pDevice.HA && (haGroup == nil || len(haGroup.Partners) == 0) {
 		return errorhandler(NewAPIUserInputError("services on an HA device must specify an HA partner.", "service.[attribute].type")), nil, nil
 	}
 
@@ -415,7 +416,7 @@ func CreateService(service *Service,
 			}
 
 		case *persistence.HAAttributes:
-			haPartner = attr.(*persistence.HAAttributes).Partners
+			haGroup = attr.(*persistence.HAAttributes).GetHAGroup()
 
 		case *persistence.AgreementProtocolAttributes:
 			agpl := attr.(*persistence.AgreementProtocolAttributes).Protocols
@@ -499,6 +500,8 @@ func CreateService(service *Service,
 			agpList = list
 		}
 
+		basicprotocol.SetProducerProtocolVersion(*agpList)
+
 		// Set max number of agreements for this service's policy.
 		maxAgreements := 1
 		if msdef.Sharable == exchange.MS_SHARING_MODE_SINGLETON || msdef.Sharable == exchange.MS_SHARING_MODE_MULTIPLE || msdef.Sharable == exchange.MS_SHARING_MODE_SINGLE {
@@ -508,7 +511,7 @@ func CreateService(service *Service,
 		glog.V(5).Infof(apiLogString(fmt.Sprintf("Create service policy: %v", service)))
 
 		// Generate a policy based on all the attributes and the service definition.
-		if polFileName, genErr := policy.GeneratePolicy(*service.Url, *service.Org, *service.Name, *service.VersionRange, *service.Arch, &props, haPartner, *agpList, maxAgreements, config.Edge.PolicyPath, pDevice.Org); genErr != nil {
+		if polFileName, genErr := policy.GeneratePolicy(*service.Url, *service.Org, *service.Name, *service.VersionRange, *service.Arch, &props, haGroup, *agpList, maxAgreements, config.Edge.PolicyPath, pDevice.Org); genErr != nil {
 			return errorhandler(NewSystemError(fmt.Sprintf("Error generating policy, error: %v", genErr))), nil, nil
 		} else {
 			if from_user {
@@ -14,7 +14,13 @@ import (
 )
 
 const PROTOCOL_NAME = "Basic"
-const PROTOCOL_CURRENT_VERSION = 1
+const PROTOCOL_CURRENT_VERSION = 4
+
+// The protocol capabilities implemented by this node. A capability is added here when the feature it represents
+// is implemented, so that agbots can start to require or use it on this node. The node reports the health and the
+// restart count of its service containers, which is the healthcheck capability. The secrets and deltaupgrade
+// capabilities are not implemented yet.
+var SUPPORTED_CAPABILITIES = []string{policy.CAP_HEALTH_CHECK}
 
 // Protocol specific extension messages go here.
 
@@ -81,6 +87,7 @@ func NewProtocolHandler(httpClient *http.Client, pm *policy.PolicyManager) *Prot
 
 	bph := abstractprotocol.NewBaseProtocolHandler(PROTOCOL_NAME,
 		PROTOCOL_CURRENT_VERSION,
+		SUPPORTED_CAPABILITIES,
 		httpClient,
 		pm)
 
@@ -89,6 +96,16 @@ func NewProtocolHandler(httpClient *http.Client, pm *policy.PolicyManager) *Prot
 	}
 }
 
+// Set the protocol version of this node into the basic agreement protocols of a producer policy, so that agbots
+// do not propose a newer protocol version than the node supports.
+func SetProducerProtocolVersion(agps []policy.AgreementProtocol) {
+	for ix := range agps {
+		if agps[ix].Name == PROTOCOL_NAME {
+			agps[ix].ProtocolVersion = PROTOCOL_CURRENT_VERSION
+		}
+	}
+}
+
 // The implementation of this protocol method has no extensions to the base abstraction.
 func (p *ProtocolHandler) InitiateAgreement(agreementId string,
 	producerPolicy *policy.Policy,
@@ -101,7 +118,14 @@ func (p *ProtocolHandler) InitiateAgreement(agreementId string,
 	defaultNoData uint64,
 	sendMessage func(msgTarget interface{}, pay []byte) error) (abstractprotocol.Proposal, error) {
 
-	if bp, err := abstractprotocol.CreateProposal(p, agreementId, producerPolicy, consumerPolicy, PROTOCOL_CURRENT_VERSION, myId, workload, defaultPW, defaultNoData); err != nil {
+	// Propose the lower of the protocol versions supported by the producer and by this agbot, so that the producer
+	// understands the proposal.
+	version := producerPolicy.MinimumProtocolVersion(PROTOCOL_NAME, consumerPolicy, PROTOCOL_CURRENT_VERSION)
+	if version > PROTOCOL_CURRENT_VERSION {
+		version = PROTOCOL_CURRENT_VERSION
+	}
+
+	if bp, err := abstractprotocol.CreateProposal(p, agreementId, producerPolicy, consumerPolicy, version, myId, workload, defaultPW, defaultNoData); err != nil {
 		return nil, err
 	} else {
 
@@ -365,6 +389,8 @@ const AB_USER_REQUESTED = 206
 const AB_CANCEL_FORCED_UPGRADE = 207
 const AB_CANCEL_NODE_HEARTBEAT = 208
 const AB_CANCEL_AG_MISSING = 209
+const AB_CANCEL_SERVICE_UNHEALTHY = 210
+const AB_CANCEL_MISSING_CAPABILITIES = 211
 
 // const AB_CANCEL_BC_WRITE_FAILED       = 208  // xd0
 
@@ -400,8 +426,10 @@ func DecodeReasonCode(code uint64) string {
 		AB_USER_REQUESTED:          "agreement bot user requested",
 		AB_CANCEL_FORCED_UPGRADE:   "agreement bot user requested service upgrade",
 		// AB_CANCEL_BC_WRITE_FAILED:   "agreement bot agreement write failed"}
-		AB_CANCEL_NODE_HEARTBEAT: "agreement bot detected node heartbeat stopped",
-		AB_CANCEL_AG_MISSING:     "agreement bot detected agreement missing from node"}
+		AB_CANCEL_NODE_HEARTBEAT:       "agreement bot detected node heartbeat stopped",
+		AB_CANCEL_AG_MISSING:           "agreement bot detected agreement missing from node",
+		AB_CANCEL_SERVICE_UNHEALTHY:    "agreement bot detected service unhealthy on node",
+		AB_CANCEL_MISSING_CAPABILITIES: "agreement bot detected node does not support required protocol capabilities"}
 
 	if reasonString, ok := codeMeanings[code]; !ok {
 		return "unknown reason code, device might be downlevel"
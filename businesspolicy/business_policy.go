@@ -90,14 +90,18 @@ func (w WorkloadChoice) String() string {
 }
 
 type NodeHealth struct {
-	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
-	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBInterval        int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
+	CheckAgreementStatus     int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	UnhealthyServiceInterval int `json:"unhealthy_service_interval,omitempty"` // How long the service can be reported unhealthy by the node until the agreement is cancelled (in seconds)
+	MaxRestarts              int `json:"max_restarts,omitempty"`               // The number of container restarts after which the service is unhealthy
 }
 
 func (w NodeHealth) String() string {
-	return fmt.Sprintf("MissingHBInterval: %v, CheckAgreementStatus: %v",
+	return fmt.Sprintf("MissingHBInterval: %v, CheckAgreementStatus: %v, UnhealthyServiceInterval: %v, MaxRestarts: %v",
 		w.MissingHBInterval,
-		w.CheckAgreementStatus)
+		w.CheckAgreementStatus,
+		w.UnhealthyServiceInterval,
+		w.MaxRestarts)
 }
 
 // The validate function returns errors if the policy does not validate. It uses the constraint language
@@ -194,9 +198,11 @@ func (b *BusinessPolicy) GenPolicyFromBusinessPolicy(policyName string) (*policy
 
 	pol.MaxAgreements = DEFAULT_MAX_AGREEMENT
 
-	// add default agreement protocol
+	// add default agreement protocol, without a protocol version so that the highest version supported by both the agbot
+	// and the node is used
 	newAGP := policy.AgreementProtocol_Factory(policy.BasicProtocol)
 	newAGP.Initialize()
+	newAGP.ProtocolVersion = 0
 	pol.Add_Agreement_Protocol(newAGP)
 
 	// make a copy of the user input
@@ -217,6 +223,7 @@ func ConvertChoice(wl WorkloadChoice, url string, org string, arch string, pol *
 func ConvertNodeHealth(nodeh NodeHealth, pol *policy.Policy) {
 	// Copy over the node health policy
 	nh := policy.NodeHealth_Factory(nodeh.MissingHBInterval, nodeh.CheckAgreementStatus)
+	nh.SetServiceHealth(nodeh.UnhealthyServiceInterval, nodeh.MaxRestarts)
 	pol.Add_NodeHealth(nh)
 }
 
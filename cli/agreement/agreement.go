@@ -1,11 +1,12 @@
 package agreement
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/anax/persistence"
+	"sort"
 )
 
 type ActiveAgreement struct {
@@ -93,6 +94,19 @@ func GetAgreements(archivedAgreements bool) (apiAgreements []persistence.Establi
 	return
 }
 
+// The columns of the table output of the agreements.
+var agreementColumns = []cliutils.OutputColumn{
+	cliutils.NewOutputColumn("AGREEMENT ID", ".current_agreement_id"),
+	cliutils.NewOutputColumn("SERVICE", ".workload_to_run.url"),
+	cliutils.NewOutputColumn("VERSION", ".workload_to_run.version"),
+	cliutils.NewWideOutputColumn("ORG", ".workload_to_run.org"),
+	cliutils.NewWideOutputColumn("ARCH", ".workload_to_run.arch"),
+	cliutils.NewWideOutputColumn("CONSUMER", ".consumer_id"),
+	cliutils.NewOutputColumn("CREATED", ".agreement_creation_time"),
+	cliutils.NewWideOutputColumn("FINALIZED", ".agreement_finalized_time"),
+	cliutils.NewWideOutputColumn("EXECUTION STARTED", ".agreement_execution_start_time"),
+}
+
 func List(archivedAgreements bool, agreementId string) {
 	apiAgreements := GetAgreements(archivedAgreements)
 
@@ -104,11 +118,7 @@ func List(archivedAgreements bool, agreementId string) {
 		for i := range apiAgreements {
 			if agreementId == apiAgreements[i].CurrentAgreementId {
 				// Found it
-				jsonBytes, err := json.MarshalIndent(apiAgreements[i], "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal agreement with index %d: %v", i, err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(apiAgreements[i], "'hzn agreement list'")
 				return
 			}
 		}
@@ -121,22 +131,16 @@ func List(archivedAgreements bool, agreementId string) {
 			for i := range apiAgreements {
 				agreements[i].CopyAgreementInto(apiAgreements[i])
 			}
-			jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn agreement list' output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(agreements, "'hzn agreement list'", agreementColumns...)
 		} else {
 			// Archived agreements
 			agreements := make([]ArchivedAgreement, len(apiAgreements))
 			for i := range apiAgreements {
 				agreements[i].CopyAgreementInto(apiAgreements[i])
 			}
-			jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn agreement list' output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(agreements, "'hzn agreement list'", append(agreementColumns,
+				cliutils.NewOutputColumn("TERMINATED", ".agreement_terminated_time"),
+				cliutils.NewOutputColumn("REASON", ".terminated_description"))...)
 		}
 	}
 }
@@ -170,3 +174,42 @@ func Cancel(agreementId string, allAgreements bool) {
 		cliutils.HorizonDelete("agreement/"+id, []int{200, 204}, []int{}, false)
 	}
 }
+
+// An entry in the timeline of an agreement, either a state transition of the agreement or an event log entry
+// related to the agreement. Service (container) events are related to the agreements they are deployed for.
+type TimelineEntry struct {
+	Timestamp   string `json:"timestamp"`
+	Type        string `json:"type"`               // "state" for state transitions, otherwise the source type of the event
+	Description string `json:"description"`        // the state, or the event message
+	Reason      string `json:"reason,omitempty"`   // why the state was entered
+	Severity    string `json:"severity,omitempty"` // the severity of the event
+	EventCode   string `json:"event_code,omitempty"`
+	time        uint64
+}
+
+func Timeline(agreementId string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	var history api.AgreementHistory
+	if httpCode, _ := cliutils.HorizonGet(fmt.Sprintf("agreement/%v/history", agreementId), []int{200, 404}, &history, false); httpCode == 404 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("agreement id %s not found", agreementId))
+	}
+
+	// Get the event log entries for the agreement, including those from previous registrations.
+	events := make([]persistence.EventLogRaw, 0)
+	cliutils.HorizonGet(fmt.Sprintf("eventlog/all?agreement_id=%v", agreementId), []int{200}, &events, false)
+
+	timeline := make([]TimelineEntry, 0, len(history.History)+len(events))
+	for _, t := range history.History {
+		timeline = append(timeline, TimelineEntry{Timestamp: cliutils.ConvertTime(t.Time), Type: "state", Description: t.State, Reason: t.Reason, time: t.Time})
+	}
+	for _, e := range events {
+		timeline = append(timeline, TimelineEntry{Timestamp: cliutils.ConvertTime(e.Timestamp), Type: e.SourceType, Description: e.Message, Severity: e.Severity, EventCode: e.EventCode, time: e.Timestamp})
+	}
+
+	// State transitions come before the events that happened in the same second.
+	sort.SliceStable(timeline, func(i, j int) bool { return timeline[i].time < timeline[j].time })
+
+	cliutils.Output(timeline, "'hzn agreement timeline'")
+}
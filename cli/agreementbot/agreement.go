@@ -2,11 +2,14 @@ package agreementbot
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"github.com/open-horizon/anax/agreementbot"
 	agbot "github.com/open-horizon/anax/agreementbot/persistence"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
+	"net/http"
 	"os"
+	"strings"
 )
 
 type ActiveAgreement struct {
@@ -92,9 +95,6 @@ func getAgreements(archivedAgreements bool) (apiAgreements []agbot.Agreement) {
 }
 
 func AgreementList(archivedAgreements bool, agreement string) {
-	// get message printer
-	msgPrinter := i18n.GetMessagePrinter()
-
 	apiAgreements := getAgreements(archivedAgreements)
 
 	// Go thru the apiAgreements and convert into our output struct and then print
@@ -103,28 +103,31 @@ func AgreementList(archivedAgreements bool, agreement string) {
 		for i := range apiAgreements {
 			agreements[i] = *NewActiveAgreement(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'agreement list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(agreements, "'hzn agbot agreement list'")
 	} else {
 		agreements := make([]ArchivedAgreement, len(apiAgreements))
 		for i := range apiAgreements {
 			agreements[i] = *NewArchivedAgreement(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(agreements, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'agreement list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(agreements, "'hzn agbot agreement list'")
 	}
 }
 
-func AgreementCancel(agreementId string, allAgreements bool) {
+func AgreementCancel(agreementId string, allAgreements bool, selectors []string, renegotiate bool, preview bool) {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
+	// The agreements matching a selector are canceled by the agbot in a bulk job.
+	if len(selectors) != 0 {
+		if agreementId != "" || allAgreements {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("--selector is mutually exclusive with an agreement ID and -a."))
+		}
+		agreementBulkCancel(selectors, renegotiate, preview)
+		return
+	} else if renegotiate || preview {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("--renegotiate and --preview can only be used with --selector."))
+	}
+
 	// Put the agreement ids in a slice
 	var agrIds []string
 	if allAgreements {
@@ -138,7 +141,7 @@ func AgreementCancel(agreementId string, allAgreements bool) {
 		}
 	} else {
 		if agreementId == "" {
-			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("either an agreement ID or -a must be specified."))
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("either an agreement ID, -a or --selector must be specified."))
 		}
 		agrIds = append(agrIds, agreementId)
 	}
@@ -154,3 +157,113 @@ func AgreementCancel(agreementId string, allAgreements bool) {
 		cliutils.HorizonDelete("agreement/"+id, []int{200, 204}, []int{}, false)
 	}
 }
+
+// Convert the key=value selectors from the command line into an agreement selector. A node property is selected
+// with node_property=name=value.
+func parseAgreementSelector(selectors []string) (*agreementbot.AgreementSelector, error) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	sel := new(agreementbot.AgreementSelector)
+	for _, s := range selectors {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, errors.New(msgPrinter.Sprintf("selector %v must be in the form key=value", s))
+		}
+		switch kv[0] {
+		case "policy":
+			sel.Policy = kv[1]
+		case "pattern":
+			sel.Pattern = kv[1]
+		case "service":
+			sel.Service = kv[1]
+		case "service_version":
+			sel.ServiceVersion = kv[1]
+		case "node_org":
+			sel.NodeOrg = kv[1]
+		case "older_than":
+			sel.OlderThan = kv[1]
+		case "node_property":
+			prop := strings.SplitN(kv[1], "=", 2)
+			if len(prop) != 2 {
+				return nil, errors.New(msgPrinter.Sprintf("selector %v must be in the form node_property=name=value", s))
+			}
+			if sel.NodeProperties == nil {
+				sel.NodeProperties = make(map[string]string)
+			}
+			sel.NodeProperties[prop[0]] = prop[1]
+		default:
+			return nil, errors.New(msgPrinter.Sprintf("selector key %v is not supported, the supported keys are policy, pattern, service, service_version, node_org, node_property and older_than", kv[0]))
+		}
+	}
+	return sel, nil
+}
+
+func agreementBulkCancel(selectors []string, renegotiate bool, preview bool) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	sel, err := parseAgreementSelector(selectors)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, err.Error())
+	}
+
+	bulk := agreementbot.BulkAgreementRequest{
+		Action:   agreementbot.BULK_ACTION_CANCEL,
+		Selector: *sel,
+		Preview:  preview,
+	}
+	if renegotiate {
+		bulk.Action = agreementbot.BULK_ACTION_RENEGOTIATE
+	}
+
+	if err := os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase()); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("unable to set env var 'HORIZON_URL', error %v", err))
+	}
+
+	_, respBody, _ := cliutils.HorizonPutPost(http.MethodPost, "agreement/bulk", []int{200, 202}, bulk, true)
+	if cliutils.IsDryRun() {
+		return
+	}
+
+	// The preview lists the selected agreements, otherwise the agbot returns the job that is canceling them.
+	var output interface{}
+	if preview {
+		output = new(agreementbot.BulkAgreementPreview)
+	} else {
+		output = new(agreementbot.BulkAgreementJob)
+	}
+	if err := json.Unmarshal([]byte(respBody), output); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to unmarshal 'agreement/bulk' output: %v", err))
+	}
+	cliutils.Output(output, "'hzn agbot agreement cancel'")
+	if !preview {
+		msgPrinter.Printf("Use 'hzn agbot agreement job %v' to follow the progress of the job.", output.(*agreementbot.BulkAgreementJob).Id)
+		msgPrinter.Println()
+	}
+}
+
+// List the bulk agreement jobs, or just the one job.
+func AgreementJobList(jobId string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if err := os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase()); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("unable to set env var 'HORIZON_URL', error %v", err))
+	}
+
+	var output interface{}
+	if jobId == "" {
+		jobs := make([]agreementbot.BulkAgreementJob, 0)
+		cliutils.HorizonGet("agreement/bulk", []int{200}, &jobs, false)
+		output = jobs
+	} else {
+		job := agreementbot.BulkAgreementJob{}
+		if httpCode, _ := cliutils.HorizonGet("agreement/bulk/"+jobId, []int{200, 404}, &job, false); httpCode == 404 {
+			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("bulk agreement job %v not found", jobId))
+		}
+		output = job
+	}
+
+	cliutils.Output(output, "'hzn agbot agreement job'")
+}
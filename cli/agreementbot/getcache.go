@@ -1,7 +1,6 @@
 package agreementbot
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/agreementbot"
 	"github.com/open-horizon/anax/cli/cliutils"
@@ -22,11 +21,7 @@ func GetServedOrgs() {
 	cliutils.HorizonGet("cache/servedorg", []int{200}, &servedOrgsInfo, false)
 
 	// Output the combined info
-	jsonBytes, err := json.MarshalIndent(servedOrgsInfo, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn node list' output: %v", err))
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.Output(servedOrgsInfo, "'hzn node list'")
 
 }
 
@@ -65,11 +60,7 @@ func GetPatterns(org string, name string, long bool) {
 			cliutils.HorizonGet(patUrl, []int{200}, &patInfo, false)
 
 			// Output the combined info
-			jsonBytes, err := json.MarshalIndent(patInfo, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(patInfo, "'hzn agbot cache'")
 
 		} else if name == "" {
 			// Get the agbot servedorgs info
@@ -80,11 +71,7 @@ func GetPatterns(org string, name string, long bool) {
 				msgPrinter.Println()
 			} else {
 				// Output the combined info
-				jsonBytes, err := json.MarshalIndent(patInfo, "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(patInfo, "'hzn agbot cache'")
 			}
 		}
 
@@ -97,11 +84,7 @@ func GetPatterns(org string, name string, long bool) {
 				msgPrinter.Println()
 			} else {
 				// Output the combined info
-				jsonBytes, err := json.MarshalIndent(patInfo, "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(patInfo, "'hzn agbot cache'")
 			}
 		} else {
 
@@ -111,11 +94,7 @@ func GetPatterns(org string, name string, long bool) {
 				msgPrinter.Println()
 			} else {
 				// Output the combined info
-				jsonBytes, err := json.MarshalIndent(patInfo, "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(patInfo, "'hzn agbot cache'")
 			}
 		}
 	} else {
@@ -124,11 +103,7 @@ func GetPatterns(org string, name string, long bool) {
 		cliutils.HorizonGet(patUrl, []int{200}, &patInfo, false)
 
 		// Output the combined info
-		jsonBytes, err := json.MarshalIndent(patInfo, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(patInfo, "'hzn agbot cache'")
 	}
 
 }
@@ -168,11 +143,7 @@ func GetPolicies(org string, name string, long bool) {
 			cliutils.HorizonGet(polUrl, []int{200}, &polInfo, false)
 
 			// Output the combined info
-			jsonBytes, err := json.MarshalIndent(polInfo, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(polInfo, "'hzn agbot cache'")
 
 		} else if name == "" {
 			// Get the agbot servedorgs info
@@ -183,11 +154,7 @@ func GetPolicies(org string, name string, long bool) {
 				msgPrinter.Println()
 			} else {
 				// Output the combined info
-				jsonBytes, err := json.MarshalIndent(polInfo, "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(polInfo, "'hzn agbot cache'")
 			}
 		}
 
@@ -200,11 +167,7 @@ func GetPolicies(org string, name string, long bool) {
 				msgPrinter.Println()
 			} else {
 				// Output the combined info
-				jsonBytes, err := json.MarshalIndent(polInfo, "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(polInfo, "'hzn agbot cache'")
 			}
 		} else {
 			polInfo := []string{} //the structure we will output
@@ -213,11 +176,7 @@ func GetPolicies(org string, name string, long bool) {
 				msgPrinter.Println()
 			} else {
 				// Output the combined info
-				jsonBytes, err := json.MarshalIndent(polInfo, "", cliutils.JSON_INDENT)
-				if err != nil {
-					cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-				}
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(polInfo, "'hzn agbot cache'")
 			}
 		}
 	} else {
@@ -226,10 +185,6 @@ func GetPolicies(org string, name string, long bool) {
 		cliutils.HorizonGet(polUrl, []int{200}, &polInfo, false)
 
 		// Output the combined info
-		jsonBytes, err := json.MarshalIndent(polInfo, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(polInfo, "'hzn agbot cache'")
 	}
 }
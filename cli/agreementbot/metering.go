@@ -0,0 +1,41 @@
+package agreementbot
+
+import (
+	"fmt"
+	"github.com/open-horizon/anax/agreementbot"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/i18n"
+	"os"
+)
+
+// Display the metering ledger of the agbot for a time range, either as JSON or as one CSV view.
+func MeteringList(since uint64, until uint64, format string, view string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if format != "json" && format != "csv" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("the output format must be json or csv."))
+	}
+
+	// set env to call agbot url
+	if err := os.Setenv("HORIZON_URL", cliutils.GetAgbotUrlBase()); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("unable to set env var 'HORIZON_URL', error %v", err))
+	}
+
+	url := fmt.Sprintf("metering?since=%v", since)
+	if until != 0 {
+		url = fmt.Sprintf("%v&until=%v", url, until)
+	}
+
+	var ledger agreementbot.MeteringLedger
+	cliutils.HorizonGet(url, []int{200}, &ledger, false)
+
+	if format == "csv" {
+		if err := ledger.WriteCSV(os.Stdout, view); err != nil {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("failed to write 'agbot metering list' output: %v", err))
+		}
+		return
+	}
+
+	cliutils.Output(ledger, "'hzn agbot metering list'")
+}
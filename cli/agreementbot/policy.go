@@ -1,7 +1,6 @@
 package agreementbot
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
@@ -52,11 +51,7 @@ func PolicyList(org string, name string) {
 	if name == "" {
 		policies, httpCode := getPolicyNames(org)
 		if httpCode == 200 {
-			jsonBytes, err := json.MarshalIndent(policies, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'policy list' output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(policies, "'hzn agbot policy list'")
 		} else if httpCode == 400 {
 			msgPrinter.Printf("Error: The organization '%v' does not exist.", org)
 			msgPrinter.Println()
@@ -64,11 +59,7 @@ func PolicyList(org string, name string) {
 	} else {
 		pol, httpCode := getPolicy(org, name)
 		if httpCode == 200 {
-			jsonBytes, err := json.MarshalIndent(pol, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'policy list' output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(pol, "'hzn agbot policy list'")
 		} else if httpCode == 400 {
 			msgPrinter.Printf("Error: Either the organization '%v' does not exist or the policy '%v' is not hosted by this agbot.", org, name)
 			msgPrinter.Println()
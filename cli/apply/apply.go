@@ -0,0 +1,473 @@
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/cli/cliconfig"
+	"github.com/open-horizon/anax/cli/cliutils"
+	cliexchange "github.com/open-horizon/anax/cli/exchange"
+	"github.com/open-horizon/anax/common"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/i18n"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// The kinds of resources that can be applied. A manifest is the JSON input file of the command that publishes the
+// resource, with a kind field added, and a name field for the resources whose name is not in the input file.
+const (
+	KIND_SERVICE           = "service"          // the input file of 'hzn exchange service publish'
+	KIND_PATTERN           = "pattern"          // the input file of 'hzn exchange pattern publish'
+	KIND_DEPLOYMENT_POLICY = "deploymentPolicy" // the input file of 'hzn exchange deployment addpolicy'
+	KIND_NODE_POLICY       = "nodePolicy"       // the input file of 'hzn exchange node addpolicy', the name is the node id
+)
+
+const (
+	OP_CREATE    = "create"
+	OP_UPDATE    = "update"
+	OP_DELETE    = "delete"
+	OP_UNCHANGED = "unchanged"
+)
+
+// The fields that are added to an input file to make it a manifest.
+type Manifest struct {
+	Kind string `json:"kind"`
+	Name string `json:"name,omitempty"`
+}
+
+// A resource read from a manifest.
+type Resource struct {
+	Kind    string
+	Name    string // the id of the resource in the exchange, without the org
+	File    string
+	Service *common.ServiceFile
+	Pattern *common.PatternFile
+	DepPol  *businesspolicy.BusinessPolicy
+	NodePol *externalpolicy.ExternalPolicy
+}
+
+// A step of the plan.
+type Action struct {
+	Op   string `json:"op"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+}
+
+// Apply the manifests in a directory to the exchange. The plan is computed by comparing each manifest with the
+// resource in the exchange, then the resources are created and updated in dependency order: services, with the
+// required services first, then patterns, deployment policies and node policies. With prune, the services, patterns
+// and deployment policies in the org that have no manifest are deleted afterwards, in the reverse order.
+func Apply(org, userPw, dir string, prune bool, planOnly bool, force bool, keyFilePath, pubKeyFilePath string, dontTouchImage bool, pullImage bool, noConstraints bool) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if dontTouchImage && pullImage {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Flags -I and -P are mutually exclusive."))
+	}
+	cliutils.SetWhetherUsingApiKey(userPw)
+
+	resources := ReadManifests(org, dir, noConstraints)
+	plan := ComputePlan(org, userPw, resources, prune)
+	PrintPlan(plan)
+
+	changes := 0
+	for _, action := range plan {
+		if action.Op != OP_UNCHANGED {
+			changes += 1
+		}
+	}
+	if changes == 0 || planOnly || cliutils.IsDryRun() {
+		return
+	}
+	if !force {
+		cliutils.ConfirmRemove(msgPrinter.Sprintf("Do you want to apply these changes to the Horizon Exchange?"))
+	}
+
+	byName := make(map[string]*Resource)
+	for _, r := range resources {
+		byName[r.Kind+"/"+r.Name] = r
+	}
+
+	for _, action := range plan {
+		switch action.Op {
+		case OP_CREATE, OP_UPDATE:
+			r := byName[action.Kind+"/"+action.Name]
+			switch action.Kind {
+			case KIND_SERVICE:
+				// The required services are validated now, because they might have been published by this apply.
+				if err := common.ValidateService(exchange.GetHTTPServiceDefResolverHandler(cliutils.GetUserExchangeContext(org, userPw)), r.Service, msgPrinter); err != nil {
+					cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Error validating the service in %v: %v", r.File, err))
+				}
+				cliexchange.SignAndPublish(r.Service, org, userPw, r.File, keyFilePath, pubKeyFilePath, dontTouchImage, pullImage, []string{}, false)
+			case KIND_PATTERN:
+				cliexchange.PatternPublish(org, userPw, r.File, keyFilePath, pubKeyFilePath, r.Name)
+			case KIND_DEPLOYMENT_POLICY:
+				cliexchange.BusinessAddPolicy(org, userPw, r.Name, r.File, noConstraints)
+			case KIND_NODE_POLICY:
+				cliexchange.NodeAddPolicy(org, userPw, r.Name, r.File)
+			}
+		case OP_DELETE:
+			switch action.Kind {
+			case KIND_SERVICE:
+				cliexchange.ServiceRemove(org, userPw, action.Name, true)
+			case KIND_PATTERN:
+				cliexchange.PatternRemove(org, userPw, action.Name, true)
+			case KIND_DEPLOYMENT_POLICY:
+				cliexchange.BusinessRemovePolicy(org, userPw, action.Name, true)
+			}
+			msgPrinter.Printf("Deleted %v %v/%v.", action.Kind, org, action.Name)
+			msgPrinter.Println()
+		}
+	}
+}
+
+// Read and validate all the manifests in a directory and its sub directories. Files that are not JSON are ignored.
+func ReadManifests(org, dir string, noConstraints bool) []*Resource {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	files := []string{}
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if !info.IsDir() && strings.HasSuffix(info.Name(), ".json") {
+			files = append(files, path)
+		}
+		return nil
+	}); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("failed to read manifests in %v: %v", dir, err))
+	}
+	sort.Strings(files)
+
+	resources := make([]*Resource, 0, len(files))
+	names := make(map[string]string)
+	for _, file := range files {
+		r := readManifest(org, file, noConstraints)
+		if other, ok := names[r.Kind+"/"+r.Name]; ok {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("%v %v is in both %v and %v", r.Kind, r.Name, other, file))
+		}
+		names[r.Kind+"/"+r.Name] = file
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+func readManifest(org, file string, noConstraints bool) *Resource {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	content := cliconfig.ReadJsonFileWithLocalConfig(file)
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to unmarshal json input file %s: %v", file, err))
+	}
+
+	r := &Resource{Kind: manifest.Kind, Name: manifest.Name, File: file}
+	var err error
+	switch manifest.Kind {
+	case KIND_SERVICE:
+		r.Service = new(common.ServiceFile)
+		if err = json.Unmarshal(content, r.Service); err == nil {
+			if r.Service.Org != "" && r.Service.Org != org {
+				err = fmt.Errorf(msgPrinter.Sprintf("the org specified in the input file (%s) must match the org specified on the command line (%s)", r.Service.Org, org))
+			} else {
+				r.Service.Org = org
+				r.Service.SupportVersionRange()
+				r.Name = cutil.FormExchangeIdForService(r.Service.URL, r.Service.Version, r.Service.Arch)
+			}
+		}
+	case KIND_PATTERN:
+		r.Pattern = new(common.PatternFile)
+		if err = json.Unmarshal(content, r.Pattern); err == nil {
+			if r.Pattern.Org != "" && r.Pattern.Org != org {
+				err = fmt.Errorf(msgPrinter.Sprintf("the org specified in the input file (%s) must match the org specified on the command line (%s)", r.Pattern.Org, org))
+			} else if len(r.Pattern.Services) == 0 {
+				err = fmt.Errorf(msgPrinter.Sprintf("the pattern definition must contain services"))
+			} else if r.Name == "" {
+				// The same default as 'hzn exchange pattern publish', the name in the file or the file base name.
+				r.Name = r.Pattern.Name
+				if r.Name == "" {
+					r.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+				}
+			}
+		}
+	case KIND_DEPLOYMENT_POLICY:
+		r.DepPol = new(businesspolicy.BusinessPolicy)
+		if r.Name == "" {
+			err = fmt.Errorf(msgPrinter.Sprintf("the name of the deployment policy must be specified"))
+		} else if err = json.Unmarshal(content, r.DepPol); err == nil {
+			if err = r.DepPol.Validate(); err == nil && !noConstraints && r.DepPol.HasNoConstraints() {
+				err = fmt.Errorf(msgPrinter.Sprintf("the deployment policy has no constraints which might result in the service being deployed to all nodes. Please specify --no-constraints to confirm that this is acceptable."))
+			}
+		}
+	case KIND_NODE_POLICY:
+		r.NodePol = new(externalpolicy.ExternalPolicy)
+		if r.Name == "" {
+			err = fmt.Errorf(msgPrinter.Sprintf("the name of the node must be specified"))
+		} else if err = json.Unmarshal(content, r.NodePol); err == nil {
+			err = r.NodePol.ValidateAndNormalize()
+		}
+	default:
+		err = fmt.Errorf(msgPrinter.Sprintf("kind must be one of %v, %v, %v or %v", KIND_SERVICE, KIND_PATTERN, KIND_DEPLOYMENT_POLICY, KIND_NODE_POLICY))
+	}
+
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Incorrect manifest %v: %v", file, err))
+	} else if strings.Contains(r.Name, "/") {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Incorrect manifest %v: the name %v must not contain the org", file, r.Name))
+	}
+	return r
+}
+
+// Compare the resources with the exchange and return the steps to apply them, in the order they are applied.
+func ComputePlan(org, userPw string, resources []*Resource, prune bool) []Action {
+
+	exchUrl := cliutils.GetExchangeUrl()
+	creds := cliutils.OrgAndCreds(org, userPw)
+
+	// Look up the resources in the org, the same way as the list commands.
+	var services exchange.GetServicesResponse
+	cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+org+"/services", creds, []int{200, 404}, &services)
+	var patterns cliexchange.ExchangePatterns
+	cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+org+"/patterns", creds, []int{200, 404}, &patterns)
+	var depPols exchange.GetBusinessPolicyResponse
+	cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+org+"/business/policies", creds, []int{200, 404}, &depPols)
+
+	plan := []Action{}
+	add := func(r *Resource, exists bool, same bool) {
+		action := Action{Op: OP_CREATE, Kind: r.Kind, Name: r.Name, File: r.File}
+		if exists && same {
+			action.Op = OP_UNCHANGED
+		} else if exists {
+			action.Op = OP_UPDATE
+		}
+		plan = append(plan, action)
+	}
+
+	for _, r := range SortServices(resources) {
+		current, ok := services.Services[org+"/"+r.Name]
+		add(r, ok, ok && SameService(r.Service, &current))
+	}
+	for _, r := range resources {
+		if r.Kind == KIND_PATTERN {
+			current, ok := patterns.Patterns[org+"/"+r.Name]
+			add(r, ok, ok && SamePattern(r.Pattern, &current))
+		}
+	}
+	for _, r := range resources {
+		if r.Kind == KIND_DEPLOYMENT_POLICY {
+			current, ok := depPols.BusinessPolicy[org+"/"+r.Name]
+			add(r, ok, ok && SameContent(r.DepPol, &current.BusinessPolicy, "owner"))
+		}
+	}
+	for _, r := range resources {
+		if r.Kind == KIND_NODE_POLICY {
+			var current exchange.ExchangePolicy
+			httpCode := cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+org+"/nodes/"+r.Name+"/policy", creds, []int{200, 404}, &current)
+			add(r, httpCode == 200, httpCode == 200 && SameContent(r.NodePol, &current.ExternalPolicy))
+		}
+	}
+
+	if prune {
+		inManifests := make(map[string]bool)
+		for _, r := range resources {
+			inManifests[r.Kind+"/"+org+"/"+r.Name] = true
+		}
+		for _, id := range sortedKeys(depPols.BusinessPolicy) {
+			if !inManifests[KIND_DEPLOYMENT_POLICY+"/"+id] {
+				plan = append(plan, Action{Op: OP_DELETE, Kind: KIND_DEPLOYMENT_POLICY, Name: strings.TrimPrefix(id, org+"/")})
+			}
+		}
+		for _, id := range sortedKeys(patterns.Patterns) {
+			if !inManifests[KIND_PATTERN+"/"+id] {
+				plan = append(plan, Action{Op: OP_DELETE, Kind: KIND_PATTERN, Name: strings.TrimPrefix(id, org+"/")})
+			}
+		}
+		pruned := []*Resource{}
+		for _, id := range sortedKeys(services.Services) {
+			if !inManifests[KIND_SERVICE+"/"+id] {
+				s := services.Services[id]
+				pruned = append(pruned, &Resource{Kind: KIND_SERVICE, Name: strings.TrimPrefix(id, org+"/"), Service: &common.ServiceFile{Org: org, URL: s.URL, Version: s.Version, Arch: s.Arch, RequiredServices: s.RequiredServices}})
+			}
+		}
+		// The services that require other services are deleted first.
+		sorted := SortServices(pruned)
+		for i := len(sorted) - 1; i >= 0; i-- {
+			plan = append(plan, Action{Op: OP_DELETE, Kind: KIND_SERVICE, Name: sorted[i].Name})
+		}
+	}
+
+	return plan
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Returns the services in an order in which every service comes after the services it requires.
+func SortServices(resources []*Resource) []*Resource {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	byName := make(map[string]*Resource)
+	items := []exchange.DependencyOrderItem{}
+	for _, r := range resources {
+		if r.Kind == KIND_SERVICE {
+			byName[r.Name] = r
+			items = append(items, exchange.DependencyOrderItem{Id: r.Name, Org: r.Service.Org, URL: r.Service.URL, Arch: r.Service.Arch, Requires: r.Service.RequiredServices})
+		}
+	}
+
+	names, err := exchange.SortByDependency(items)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("unable to order the services: %v", err))
+	}
+	sorted := make([]*Resource, 0, len(names))
+	for _, name := range names {
+		sorted = append(sorted, byName[name])
+	}
+	return sorted
+}
+
+// Print the plan, the unchanged resources are only counted.
+func PrintPlan(plan []Action) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	counts := make(map[string]int)
+	for _, action := range plan {
+		counts[action.Op] += 1
+		if action.Op == OP_UNCHANGED {
+			continue
+		}
+		if action.File != "" {
+			fmt.Printf("  %-7s %-17s %v (%v)\n", action.Op, action.Kind, action.Name, action.File)
+		} else {
+			fmt.Printf("  %-7s %-17s %v\n", action.Op, action.Kind, action.Name)
+		}
+	}
+	msgPrinter.Printf("Plan: %v to create, %v to update, %v to delete, %v unchanged.", counts[OP_CREATE], counts[OP_UPDATE], counts[OP_DELETE], counts[OP_UNCHANGED])
+	msgPrinter.Println()
+}
+
+// Returns true if the service in the exchange has the content of the service file. The deployments are compared
+// as JSON, so the formatting and the signatures do not matter.
+func SameService(sf *common.ServiceFile, current *exchange.ServiceDefinition) bool {
+	desired := exchange.ServiceDefinition{Label: sf.Label, Description: sf.Description, Public: sf.Public, Documentation: sf.Documentation, URL: sf.URL, Version: sf.Version, Arch: sf.Arch, Sharable: sf.Sharable, MatchHardware: sf.MatchHardware, RequiredServices: sf.RequiredServices, UserInputs: sf.UserInputs}
+	return SameContent(&desired, current, "owner", "deployment", "deploymentSignature", "clusterDeployment", "clusterDeploymentSignature", "lastUpdated") &&
+		SameContent(jsonValue(sf.Deployment), jsonValue(current.Deployment)) &&
+		SameContent(jsonValue(sf.ClusterDeployment), jsonValue(current.ClusterDeployment))
+}
+
+// Returns true if the pattern in the exchange has the content of the pattern file. The deployment overrides are
+// compared as JSON, so the signatures do not matter. The data verification and node health of a service are only
+// compared when the pattern file has them, because the exchange fills in defaults.
+func SamePattern(pf *common.PatternFile, current *cliexchange.PatternOutput) bool {
+	if len(pf.Services) != len(current.Services) {
+		return false
+	}
+
+	desired := cliexchange.PatternOutput{Label: pf.Label, Description: pf.Description, Public: pf.Public, AgreementProtocols: pf.AgreementProtocols, UserInput: pf.UserInput}
+	if !SameContent(&desired, current, "owner", "services", "lastUpdated") {
+		return false
+	}
+
+	for i, s := range pf.Services {
+		cs := current.Services[i]
+		if s.ServiceURL != cs.ServiceURL || s.ServiceOrg != cs.ServiceOrg || s.ServiceArch != cs.ServiceArch || s.AgreementLess != cs.AgreementLess || len(s.ServiceVersions) != len(cs.ServiceVersions) {
+			return false
+		} else if s.DataVerify != nil && !SameContent(s.DataVerify, &cs.DataVerify) {
+			return false
+		} else if s.NodeH != nil && !SameContent(s.NodeH, cs.NodeH) {
+			return false
+		}
+		for j, v := range s.ServiceVersions {
+			cv := cs.ServiceVersions[j]
+			if v.Version != cv.Version || (v.Priority != nil && !SameContent(v.Priority, &cv.Priority)) || (v.Upgrade != nil && !SameContent(v.Upgrade, &cv.Upgrade)) {
+				return false
+			} else if !SameContent(jsonValue(v.DeploymentOverrides), jsonValue(cv.DeploymentOverrides)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the value of a field that can be a JSON object or a stringified JSON object.
+func jsonValue(v interface{}) interface{} {
+	if s, ok := v.(string); ok && s != "" {
+		var res interface{}
+		if err := json.Unmarshal([]byte(s), &res); err == nil {
+			return res
+		}
+	}
+	return v
+}
+
+// Returns true if the two values have the same JSON content, ignoring the top level fields that are listed. Empty
+// values are the same as missing values, because the exchange and the input files omit them differently.
+func SameContent(a interface{}, b interface{}, ignore ...string) bool {
+	normalize := func(v interface{}) interface{} {
+		var res interface{}
+		if bytes, err := json.Marshal(v); err != nil {
+			return v
+		} else if err := json.Unmarshal(bytes, &res); err != nil {
+			return v
+		}
+		if m, ok := res.(map[string]interface{}); ok {
+			for _, field := range ignore {
+				delete(m, field)
+			}
+		}
+		return removeEmpty(res)
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func removeEmpty(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if e = removeEmpty(e); e == nil {
+				delete(t, k)
+			} else {
+				t[k] = e
+			}
+		}
+		if len(t) == 0 {
+			return nil
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = removeEmpty(e)
+		}
+		if len(t) == 0 {
+			return nil
+		}
+	case string:
+		if t == "" {
+			return nil
+		}
+	case bool:
+		if !t {
+			return nil
+		}
+	case float64:
+		if t == 0 {
+			return nil
+		}
+	}
+	return v
+}
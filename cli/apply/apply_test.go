@@ -0,0 +1,206 @@
+// +build unit
+
+package apply
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/common"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func serviceResource(name string, url string, arch string, required ...exchange.ServiceDependency) *Resource {
+	return &Resource{Kind: KIND_SERVICE, Name: name, Service: &common.ServiceFile{Org: "myorg", URL: url, Version: "1.0.0", Arch: arch, RequiredServices: required}}
+}
+
+func requires(url string, arch string) exchange.ServiceDependency {
+	return exchange.ServiceDependency{URL: url, Org: "myorg", VersionRange: "1.0.0", Arch: arch}
+}
+
+func resourceNames(resources []*Resource) []string {
+	names := []string{}
+	for _, r := range resources {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func Test_SortServices(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		resources []*Resource
+		expected  []string
+	}{
+		{"empty", []*Resource{}, []string{}},
+		{"no dependencies", []*Resource{serviceResource("a", "a", "amd64"), serviceResource("b", "b", "amd64")}, []string{"a", "b"}},
+		{"required service after its user", []*Resource{
+			serviceResource("top", "top", "amd64", requires("mid", "amd64")),
+			serviceResource("mid", "mid", "amd64", requires("leaf", "amd64")),
+			serviceResource("leaf", "leaf", "amd64"),
+		}, []string{"leaf", "mid", "top"}},
+		{"other arch is not a dependency", []*Resource{
+			serviceResource("top", "top", "amd64", requires("leaf", "arm64")),
+			serviceResource("leaf", "leaf", "amd64"),
+		}, []string{"top", "leaf"}},
+		{"empty arch is the arch of the service", []*Resource{
+			serviceResource("top", "top", "arm64", requires("leaf", "")),
+			serviceResource("leaf", "leaf", "arm64"),
+		}, []string{"leaf", "top"}},
+		{"other version of itself is not a dependency", []*Resource{
+			serviceResource("top_2.0.0", "top", "amd64", requires("top", "amd64")),
+			serviceResource("top_1.0.0", "top", "amd64"),
+		}, []string{"top_2.0.0", "top_1.0.0"}},
+		{"other kinds are left out", []*Resource{
+			{Kind: KIND_PATTERN, Name: "p"},
+			serviceResource("a", "a", "amd64"),
+		}, []string{"a"}},
+	}
+
+	for _, test := range tests {
+		if names := resourceNames(SortServices(test.resources)); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%v: wrong order %v, expected %v", test.name, names, test.expected)
+		}
+	}
+
+}
+
+func Test_SameContent(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		a        interface{}
+		b        interface{}
+		ignore   []string
+		expected bool
+	}{
+		{"same", map[string]interface{}{"a": 1, "b": "x"}, map[string]interface{}{"b": "x", "a": 1}, nil, true},
+		{"different value", map[string]interface{}{"a": 1}, map[string]interface{}{"a": 2}, nil, false},
+		{"missing is empty", map[string]interface{}{"a": 1, "b": "", "c": false, "d": []string{}}, map[string]interface{}{"a": 1}, nil, true},
+		{"ignored field", map[string]interface{}{"a": 1, "owner": "u1"}, map[string]interface{}{"a": 1, "owner": "u2"}, []string{"owner"}, true},
+		{"only top level ignored", map[string]interface{}{"s": map[string]interface{}{"owner": "u1"}}, map[string]interface{}{"s": map[string]interface{}{"owner": "u2"}}, []string{"owner"}, false},
+		{"struct and map", &externalpolicy.ExternalPolicy{Constraints: []string{"a == 1"}}, map[string]interface{}{"constraints": []string{"a == 1"}}, nil, true},
+		{"deployment policy owner", &businesspolicy.BusinessPolicy{Label: "l"}, &exchange.ExchangeBusinessPolicy{BusinessPolicy: businesspolicy.BusinessPolicy{Label: "l", Owner: "myorg/u"}}, []string{"owner"}, true},
+	}
+
+	for _, test := range tests {
+		if same := SameContent(test.a, test.b, test.ignore...); same != test.expected {
+			t.Errorf("%v: SameContent returned %v, expected %v", test.name, same, test.expected)
+		}
+	}
+
+}
+
+func Test_removeEmpty(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		in       string
+		expected interface{}
+	}{
+		{"empty string", `""`, nil},
+		{"false", `false`, nil},
+		{"zero", `0`, nil},
+		{"empty array", `[]`, nil},
+		{"empty object", `{}`, nil},
+		{"nested empty object", `{"a":{"b":""}}`, nil},
+		{"values kept", `{"a":"x","b":true,"c":1,"d":""}`, map[string]interface{}{"a": "x", "b": true, "c": float64(1)}},
+		{"array elements", `["x",""]`, []interface{}{"x", nil}},
+	}
+
+	for _, test := range tests {
+		var v interface{}
+		if err := json.Unmarshal([]byte(test.in), &v); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		} else if res := removeEmpty(v); !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("%v: removeEmpty returned %v, expected %v", test.name, res, test.expected)
+		}
+	}
+
+}
+
+// Serves the given exchange responses, keyed by path, and 404 for everything else.
+func exchangeServer(responses map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if resp, ok := responses[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+			data, _ := json.Marshal(resp)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func Test_ComputePlan(t *testing.T) {
+
+	current := exchange.ServiceDefinition{URL: "leaf", Version: "1.0.0", Arch: "amd64", Owner: "myorg/u"}
+	changed := exchange.ServiceDefinition{URL: "top", Version: "1.0.0", Arch: "amd64", Label: "old", RequiredServices: []exchange.ServiceDependency{requires("leaf", "amd64")}}
+	server := exchangeServer(map[string]interface{}{
+		"orgs/myorg/services": exchange.GetServicesResponse{Services: map[string]exchange.ServiceDefinition{
+			"myorg/leaf_1.0.0_amd64":    current,
+			"myorg/top_1.0.0_amd64":     changed,
+			"myorg/old_1.0.0_amd64":     {URL: "old", Version: "1.0.0", Arch: "amd64", RequiredServices: []exchange.ServiceDependency{requires("oldleaf", "amd64")}},
+			"myorg/oldleaf_1.0.0_amd64": {URL: "oldleaf", Version: "1.0.0", Arch: "amd64"},
+		}},
+		"orgs/myorg/business/policies": exchange.GetBusinessPolicyResponse{BusinessPolicy: map[string]exchange.ExchangeBusinessPolicy{
+			"myorg/pol1": {BusinessPolicy: businesspolicy.BusinessPolicy{Label: "pol1", Owner: "myorg/u"}},
+			"myorg/pol2": {BusinessPolicy: businesspolicy.BusinessPolicy{Label: "pol2"}},
+		}},
+		"orgs/myorg/nodes/node1/policy": exchange.ExchangePolicy{ExternalPolicy: externalpolicy.ExternalPolicy{Constraints: []string{"a == 1"}}},
+	})
+	defer server.Close()
+	os.Setenv("HZN_EXCHANGE_URL", server.URL)
+	defer os.Unsetenv("HZN_EXCHANGE_URL")
+
+	top := serviceResource("top_1.0.0_amd64", "top", "amd64", requires("leaf", "amd64"))
+	top.Service.Label = "new"
+	resources := []*Resource{
+		{Kind: KIND_NODE_POLICY, Name: "node1", NodePol: &externalpolicy.ExternalPolicy{Constraints: []string{"a == 1"}}},
+		{Kind: KIND_NODE_POLICY, Name: "node2", NodePol: &externalpolicy.ExternalPolicy{Constraints: []string{"a == 1"}}},
+		{Kind: KIND_DEPLOYMENT_POLICY, Name: "pol1", DepPol: &businesspolicy.BusinessPolicy{Label: "pol1"}},
+		top,
+		serviceResource("leaf_1.0.0_amd64", "leaf", "amd64"),
+		serviceResource("new_1.0.0_amd64", "new", "amd64"),
+	}
+
+	tests := []struct {
+		name     string
+		prune    bool
+		expected []Action
+	}{
+		{"no prune", false, []Action{
+			{Op: OP_UNCHANGED, Kind: KIND_SERVICE, Name: "leaf_1.0.0_amd64"},
+			{Op: OP_CREATE, Kind: KIND_SERVICE, Name: "new_1.0.0_amd64"},
+			{Op: OP_UPDATE, Kind: KIND_SERVICE, Name: "top_1.0.0_amd64"},
+			{Op: OP_UNCHANGED, Kind: KIND_DEPLOYMENT_POLICY, Name: "pol1"},
+			{Op: OP_UNCHANGED, Kind: KIND_NODE_POLICY, Name: "node1"},
+			{Op: OP_CREATE, Kind: KIND_NODE_POLICY, Name: "node2"},
+		}},
+		{"prune", true, []Action{
+			{Op: OP_UNCHANGED, Kind: KIND_SERVICE, Name: "leaf_1.0.0_amd64"},
+			{Op: OP_CREATE, Kind: KIND_SERVICE, Name: "new_1.0.0_amd64"},
+			{Op: OP_UPDATE, Kind: KIND_SERVICE, Name: "top_1.0.0_amd64"},
+			{Op: OP_UNCHANGED, Kind: KIND_DEPLOYMENT_POLICY, Name: "pol1"},
+			{Op: OP_UNCHANGED, Kind: KIND_NODE_POLICY, Name: "node1"},
+			{Op: OP_CREATE, Kind: KIND_NODE_POLICY, Name: "node2"},
+			{Op: OP_DELETE, Kind: KIND_DEPLOYMENT_POLICY, Name: "pol2"},
+			{Op: OP_DELETE, Kind: KIND_SERVICE, Name: "old_1.0.0_amd64"},
+			{Op: OP_DELETE, Kind: KIND_SERVICE, Name: "oldleaf_1.0.0_amd64"},
+		}},
+	}
+
+	for _, test := range tests {
+		if plan := ComputePlan("myorg", "u:pw", resources, test.prune); !reflect.DeepEqual(plan, test.expected) {
+			t.Errorf("%v: wrong plan %v, expected %v", test.name, plan, test.expected)
+		}
+	}
+
+}
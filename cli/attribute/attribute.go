@@ -1,8 +1,6 @@
 package attribute
 
 import (
-	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
@@ -43,9 +41,5 @@ func List() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(attrs, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn attribute list' output: %v", err))
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.Output(attrs, "'hzn attribute list'")
 }
@@ -75,6 +75,8 @@ const (
 type GlobalOptions struct {
 	Verbose     *bool
 	IsDryRun    *bool
+	Output      *string
+	Query       *string
 	UsingApiKey bool // should go away soon
 }
 
@@ -0,0 +1,750 @@
+package cliutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/i18n"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// The formats of the output of the list and show commands, set with the global --output-format flag.
+const (
+	OUTPUT_JSON  = "json"
+	OUTPUT_YAML  = "yaml"
+	OUTPUT_TABLE = "table"
+	OUTPUT_WIDE  = "wide"
+)
+
+var OUTPUT_FORMATS = []string{OUTPUT_JSON, OUTPUT_YAML, OUTPUT_TABLE, OUTPUT_WIDE}
+
+// The maximum width of a cell in the table format, the wide format does not truncate the cells.
+const OUTPUT_TABLE_CELL_WIDTH = 50
+
+// A column of the table format of a command output. The query selects the value of the column in each row, the column
+// is only displayed in the wide format when Wide is set.
+type OutputColumn struct {
+	Header string
+	Query  string
+	Wide   bool
+}
+
+func NewOutputColumn(header string, query string) OutputColumn {
+	return OutputColumn{Header: header, Query: query}
+}
+
+func NewWideOutputColumn(header string, query string) OutputColumn {
+	return OutputColumn{Header: header, Query: query, Wide: true}
+}
+
+// Output displays the result of a list or show command in the format set with --output-format, after selecting the fields
+// set with --query. The result is any value that can be marshaled to json, or json text as a string or []byte. The
+// columns are used for the table formats, when the rows of the result have known fields. Without columns, or when a
+// query changes the result, the columns are the fields of the rows.
+func Output(result interface{}, errMsg string, columns ...OutputColumn) {
+	if err := WriteOutput(os.Stdout, result, getOutputFormat(), getOutputQuery(), columns); err != nil {
+		Fatal(CLI_INPUT_ERROR, i18n.GetMessagePrinter().Sprintf("failed to display the output of %s: %v", errMsg, err))
+	}
+}
+
+// Returns true if the default output format is used, for the commands that display more than their result in that format.
+func IsDefaultOutput() bool {
+	return getOutputFormat() == "" && getOutputQuery() == ""
+}
+
+func getOutputFormat() string {
+	if Opts.Output == nil {
+		return ""
+	}
+	return *Opts.Output
+}
+
+func getOutputQuery() string {
+	if Opts.Query == nil {
+		return ""
+	}
+	return *Opts.Query
+}
+
+// WriteOutput writes the result in the given format after applying the query.
+func WriteOutput(w io.Writer, result interface{}, format string, query string, columns []OutputColumn) error {
+	// Without a query, the json format keeps the order of the fields of the result.
+	if query == "" && (format == "" || format == OUTPUT_JSON) {
+		return writeJson(w, result)
+	}
+
+	data, err := toGeneric(result)
+	if err != nil {
+		return err
+	}
+
+	if query != "" {
+		if data, err = Query(data, query); err != nil {
+			return err
+		}
+		columns = nil
+	}
+
+	switch format {
+	case "", OUTPUT_JSON:
+		err = writeJson(w, data)
+	case OUTPUT_YAML:
+		var yamlBytes []byte
+		if yamlBytes, err = yaml.Marshal(toYaml(data)); err == nil {
+			_, err = w.Write(yamlBytes)
+		}
+	case OUTPUT_TABLE, OUTPUT_WIDE:
+		err = writeTable(w, data, columns, format == OUTPUT_WIDE)
+	default:
+		err = errors.New(i18n.GetMessagePrinter().Sprintf("invalid output format %v, the formats are %v", format, strings.Join(OUTPUT_FORMATS, ", ")))
+	}
+	return err
+}
+
+// Write the result as indented json, a result that is json text is indented.
+func writeJson(w io.Writer, result interface{}) error {
+	switch r := result.(type) {
+	case string:
+		result = json.RawMessage(r)
+	case []byte:
+		result = json.RawMessage(r)
+	}
+	if raw, ok := result.(json.RawMessage); ok && len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", JSON_INDENT)
+	if err := enc.Encode(result); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Convert the result to the generic json types, json.Number is used for the numbers so that they are not changed.
+func toGeneric(result interface{}) (interface{}, error) {
+	var jsonBytes []byte
+	switch r := result.(type) {
+	case string:
+		jsonBytes = []byte(r)
+	case []byte:
+		jsonBytes = r
+	default:
+		var err error
+		if jsonBytes, err = json.Marshal(result); err != nil {
+			return nil, err
+		}
+	}
+
+	var data interface{}
+	if len(bytes.TrimSpace(jsonBytes)) == 0 {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(jsonBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Convert the numbers to int or float so that they are not quoted in yaml.
+func toYaml(data interface{}) interface{} {
+	switch d := data.(type) {
+	case json.Number:
+		if i, err := d.Int64(); err == nil {
+			return i
+		} else if f, err := d.Float64(); err == nil {
+			return f
+		}
+		return d.String()
+	case []interface{}:
+		list := make([]interface{}, len(d))
+		for i, v := range d {
+			list[i] = toYaml(v)
+		}
+		return list
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			m[k] = toYaml(v)
+		}
+		return m
+	}
+	return data
+}
+
+// Write the data as a table. A list is a row per element, an object whose values are all objects, like the resources
+// of the exchange by id, is a row per value with the key in the ID column, and another object is a row per field.
+func writeTable(w io.Writer, data interface{}, columns []OutputColumn, wide bool) error {
+	var keys []string
+	var rows []interface{}
+	isObject := false
+
+	switch d := data.(type) {
+	case []interface{}:
+		rows = d
+	case map[string]interface{}:
+		if isCollection(d) {
+			for k := range d {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				rows = append(rows, d[k])
+			}
+		} else {
+			isObject = true
+		}
+	default:
+		fmt.Fprintln(w, cellString(data, wide))
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+
+	// An object is displayed as fields and values.
+	if isObject {
+		m := data.(map[string]interface{})
+		fields := make([]string, 0, len(m))
+		for k := range m {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+		fmt.Fprintln(tw, "FIELD\tVALUE")
+		for _, f := range fields {
+			fmt.Fprintf(tw, "%v\t%v\n", f, cellString(m[f], wide))
+		}
+		return tw.Flush()
+	}
+
+	if len(columns) == 0 {
+		columns = defaultColumns(rows, wide)
+	}
+
+	headers := []string{}
+	if keys != nil {
+		headers = append(headers, "ID")
+	}
+	for _, c := range columns {
+		if wide || !c.Wide {
+			headers = append(headers, strings.ToUpper(c.Header))
+		}
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+	for i, row := range rows {
+		cells := []string{}
+		if keys != nil {
+			cells = append(cells, keys[i])
+		}
+		for _, c := range columns {
+			if !wide && c.Wide {
+				continue
+			}
+			value, err := Query(row, c.Query)
+			if err != nil {
+				value = nil
+			}
+			cells = append(cells, cellString(value, wide))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Returns true if all the values of the object are objects.
+func isCollection(m map[string]interface{}) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for _, v := range m {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// The columns of rows without known fields are the fields of the rows in alphabetical order. The table format only
+// has the fields whose values are not lists or objects. A list of values is one VALUE column.
+func defaultColumns(rows []interface{}, wide bool) []OutputColumn {
+	fields := make(map[string]bool)
+	for _, row := range rows {
+		m, ok := row.(map[string]interface{})
+		if !ok {
+			return []OutputColumn{NewOutputColumn("VALUE", ".")}
+		}
+		for k, v := range m {
+			switch v.(type) {
+			case nil:
+				if _, ok := fields[k]; !ok {
+					fields[k] = wide
+				}
+			case map[string]interface{}, []interface{}:
+				if !fields[k] {
+					fields[k] = wide
+				}
+			default:
+				fields[k] = true
+			}
+		}
+	}
+
+	names := []string{}
+	for k, show := range fields {
+		if show {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	columns := []OutputColumn{}
+	for _, name := range names {
+		columns = append(columns, NewOutputColumn(name, "."+strconv.Quote(name)))
+	}
+	return columns
+}
+
+// Returns the text of a table cell, lists and objects are compact json. The cells are truncated in the table format.
+func cellString(value interface{}, wide bool) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		s = ""
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		buf := new(bytes.Buffer)
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		s = strings.TrimSpace(buf.String())
+	}
+
+	// A cell is on one line.
+	s = strings.Join(strings.Fields(s), " ")
+	if !wide && len([]rune(s)) > OUTPUT_TABLE_CELL_WIDTH {
+		s = string([]rune(s)[:OUTPUT_TABLE_CELL_WIDTH-3]) + "..."
+	}
+	return s
+}
+
+// Query selects values in json data with a subset of the jq language:
+//
+//	.                 the data
+//	.name  ."name"    the value of a field of an object, null when there is no such field
+//	["name"]          the value of a field with any characters in its name
+//	[N]               the element N of a list, counting from the end when N is negative
+//	[]                each element of a list or value of an object
+//	query | query     the second query applied to each result of the first one
+//	query // query    the results of the first query that are not null or false, or else the second one
+//	{name, name: query, "name": query}
+//	                  an object made of the given fields
+//
+// For example: .[].services[].url, .[] | {id, arch} or .[] | .name // .id. When the query iterates over lists or objects, the results are
+// returned in a list.
+func Query(data interface{}, query string) (interface{}, error) {
+	p := &queryParser{query: query}
+	q, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	results, err := q.eval(data)
+	if err != nil {
+		return nil, err
+	} else if p.iterates {
+		return results, nil
+	} else if len(results) == 1 {
+		return results[0], nil
+	}
+	return results, nil
+}
+
+// A parsed query returns a stream of results for each input.
+type queryNode interface {
+	eval(data interface{}) ([]interface{}, error)
+}
+
+type identityNode struct{}
+
+func (n identityNode) eval(data interface{}) ([]interface{}, error) {
+	return []interface{}{data}, nil
+}
+
+type fieldNode struct {
+	name string
+}
+
+func (n fieldNode) eval(data interface{}) ([]interface{}, error) {
+	switch d := data.(type) {
+	case nil:
+		return []interface{}{nil}, nil
+	case map[string]interface{}:
+		return []interface{}{d[n.name]}, nil
+	}
+	return nil, fmt.Errorf("cannot get field %q of %v", n.name, typeName(data))
+}
+
+type indexNode struct {
+	index int
+}
+
+func (n indexNode) eval(data interface{}) ([]interface{}, error) {
+	switch d := data.(type) {
+	case nil:
+		return []interface{}{nil}, nil
+	case []interface{}:
+		i := n.index
+		if i < 0 {
+			i += len(d)
+		}
+		if i < 0 || i >= len(d) {
+			return []interface{}{nil}, nil
+		}
+		return []interface{}{d[i]}, nil
+	}
+	return nil, fmt.Errorf("cannot get element %v of %v", n.index, typeName(data))
+}
+
+type iterateNode struct{}
+
+func (n iterateNode) eval(data interface{}) ([]interface{}, error) {
+	switch d := data.(type) {
+	case []interface{}:
+		return d, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		results := make([]interface{}, 0, len(d))
+		for _, k := range keys {
+			results = append(results, d[k])
+		}
+		return results, nil
+	}
+	return nil, fmt.Errorf("cannot iterate over %v", typeName(data))
+}
+
+// Each node is applied to the results of the previous one.
+type pipeNode struct {
+	nodes []queryNode
+}
+
+func (n pipeNode) eval(data interface{}) ([]interface{}, error) {
+	results := []interface{}{data}
+	for _, node := range n.nodes {
+		next := []interface{}{}
+		for _, r := range results {
+			values, err := node.eval(r)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, values...)
+		}
+		results = next
+	}
+	return results, nil
+}
+
+// The results of the first node that are not null or false, or the results of the last node.
+type alternativeNode struct {
+	nodes []queryNode
+}
+
+func (n alternativeNode) eval(data interface{}) ([]interface{}, error) {
+	for i, node := range n.nodes {
+		values, err := node.eval(data)
+		if err != nil && i < len(n.nodes)-1 {
+			continue
+		} else if err != nil || i == len(n.nodes)-1 {
+			return values, err
+		}
+		results := []interface{}{}
+		for _, v := range values {
+			if v != nil && v != false {
+				results = append(results, v)
+			}
+		}
+		if len(results) > 0 {
+			return results, nil
+		}
+	}
+	return []interface{}{nil}, nil
+}
+
+type objectNode struct {
+	names []string
+	nodes []queryNode
+}
+
+func (n objectNode) eval(data interface{}) ([]interface{}, error) {
+	object := make(map[string]interface{})
+	for i, name := range n.names {
+		values, err := n.nodes[i].eval(data)
+		if err != nil {
+			return nil, err
+		} else if len(values) == 1 {
+			object[name] = values[0]
+		} else {
+			object[name] = values
+		}
+	}
+	return []interface{}{object}, nil
+}
+
+func typeName(data interface{}) string {
+	switch data.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
+
+type queryParser struct {
+	query    string
+	pos      int
+	depth    int
+	iterates bool
+}
+
+func (p *queryParser) parse() (queryNode, error) {
+	node, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.query) {
+		return nil, p.errorf("unexpected %q", p.query[p.pos:])
+	}
+	return node, nil
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid query %q at position %v: %v", p.query, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) skipSpaces() {
+	for p.pos < len(p.query) && unicode.IsSpace(rune(p.query[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *queryParser) peek() byte {
+	p.skipSpaces()
+	if p.pos < len(p.query) {
+		return p.query[p.pos]
+	}
+	return 0
+}
+
+func (p *queryParser) parsePipe() (queryNode, error) {
+	pipe := pipeNode{}
+	for {
+		node, err := p.parseAlternative()
+		if err != nil {
+			return nil, err
+		}
+		pipe.nodes = append(pipe.nodes, node)
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(pipe.nodes) == 1 {
+		return pipe.nodes[0], nil
+	}
+	return pipe, nil
+}
+
+func (p *queryParser) parseAlternative() (queryNode, error) {
+	alternative := alternativeNode{}
+	for {
+		node, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		alternative.nodes = append(alternative.nodes, node)
+		if p.peek() != '/' || !strings.HasPrefix(p.query[p.pos:], "//") {
+			break
+		}
+		p.pos += 2
+	}
+	if len(alternative.nodes) == 1 {
+		return alternative.nodes[0], nil
+	}
+	return alternative, nil
+}
+
+func (p *queryParser) parseTerm() (queryNode, error) {
+	switch p.peek() {
+	case '{':
+		return p.parseObject()
+	case '.', '[':
+		return p.parsePath()
+	case 0:
+		return nil, p.errorf("missing query")
+	}
+	return nil, p.errorf("unexpected %q", p.query[p.pos:])
+}
+
+// A path is a sequence of .name, ."name", [N], ["name"] and [], or a single dot.
+func (p *queryParser) parsePath() (queryNode, error) {
+	path := pipeNode{}
+	start := p.pos
+	for {
+		if p.pos >= len(p.query) {
+			break
+		}
+		c := p.query[p.pos]
+		if c == '.' {
+			p.pos++
+			if p.pos < len(p.query) && p.query[p.pos] == '"' {
+				name, err := p.parseString()
+				if err != nil {
+					return nil, err
+				}
+				path.nodes = append(path.nodes, fieldNode{name: name})
+			} else if name := p.parseIdent(); name != "" {
+				path.nodes = append(path.nodes, fieldNode{name: name})
+			} else if len(path.nodes) > 0 || p.pos-1 != start {
+				p.pos--
+				return nil, p.errorf("missing field name")
+			} else if p.pos < len(p.query) && p.query[p.pos] == '.' {
+				return nil, p.errorf("missing field name")
+			}
+		} else if c == '[' {
+			p.pos++
+			switch p.peek() {
+			case ']':
+				path.nodes = append(path.nodes, iterateNode{})
+				p.iterates = p.iterates || p.depth == 0
+			case '"':
+				name, err := p.parseString()
+				if err != nil {
+					return nil, err
+				}
+				path.nodes = append(path.nodes, fieldNode{name: name})
+			default:
+				start := p.pos
+				if p.pos < len(p.query) && p.query[p.pos] == '-' {
+					p.pos++
+				}
+				for p.pos < len(p.query) && p.query[p.pos] >= '0' && p.query[p.pos] <= '9' {
+					p.pos++
+				}
+				index, err := strconv.Atoi(p.query[start:p.pos])
+				if err != nil {
+					p.pos = start
+					return nil, p.errorf("invalid index")
+				}
+				path.nodes = append(path.nodes, indexNode{index: index})
+			}
+			if p.peek() != ']' {
+				return nil, p.errorf("missing ]")
+			}
+			p.pos++
+		} else {
+			break
+		}
+	}
+
+	if len(path.nodes) == 0 {
+		return identityNode{}, nil
+	} else if len(path.nodes) == 1 {
+		return path.nodes[0], nil
+	}
+	return path, nil
+}
+
+func (p *queryParser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.query) {
+		c := rune(p.query[p.pos])
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+			break
+		}
+		p.pos++
+	}
+	return p.query[start:p.pos]
+}
+
+func (p *queryParser) parseString() (string, error) {
+	start := p.pos
+	for p.pos++; p.pos < len(p.query); p.pos++ {
+		if p.query[p.pos] == '\\' {
+			p.pos++
+		} else if p.query[p.pos] == '"' {
+			p.pos++
+			s, err := strconv.Unquote(p.query[start:p.pos])
+			if err != nil {
+				p.pos = start
+				return "", p.errorf("invalid string")
+			}
+			return s, nil
+		}
+	}
+	p.pos = start
+	return "", p.errorf("missing \"")
+}
+
+func (p *queryParser) parseObject() (queryNode, error) {
+	object := objectNode{}
+	p.pos++
+	p.depth++
+	defer func() { p.depth-- }()
+	for p.peek() != '}' {
+		var name string
+		var err error
+		if p.peek() == '"' {
+			if name, err = p.parseString(); err != nil {
+				return nil, err
+			}
+		} else if name = p.parseIdent(); name == "" {
+			return nil, p.errorf("missing field name")
+		}
+
+		var node queryNode = fieldNode{name: name}
+		if p.peek() == ':' {
+			p.pos++
+			if node, err = p.parsePipe(); err != nil {
+				return nil, err
+			}
+		}
+		object.names = append(object.names, name)
+		object.nodes = append(object.nodes, node)
+
+		if p.peek() == ',' {
+			p.pos++
+		} else if p.peek() != '}' {
+			return nil, p.errorf("missing }")
+		}
+	}
+	p.pos++
+	return object, nil
+}
@@ -0,0 +1,146 @@
+// +build unit
+
+package cliutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type testNode struct {
+	Id        string            `json:"id"`
+	Arch      string            `json:"arch"`
+	Heartbeat int64             `json:"heartbeat"`
+	Services  []testService     `json:"services"`
+	Props     map[string]string `json:"properties,omitempty"`
+}
+
+type testService struct {
+	Url     string `json:"url"`
+	Version string `json:"version"`
+}
+
+var testNodes = []testNode{
+	{Id: "node1", Arch: "amd64", Heartbeat: 1600000000123456789, Services: []testService{{Url: "gps", Version: "1.0.0"}, {Url: "cpu", Version: "2.0.0"}}},
+	{Id: "node2", Arch: "arm64", Heartbeat: 2, Props: map[string]string{"a/b": "c"}},
+}
+
+func writeTestOutput(t *testing.T, result interface{}, format string, query string, columns ...OutputColumn) string {
+	buf := new(bytes.Buffer)
+	if err := WriteOutput(buf, result, format, query, columns); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return buf.String()
+}
+
+func Test_WriteOutput_json(t *testing.T) {
+	out := writeTestOutput(t, map[string]string{"url": "a&b<c>"}, "", "")
+	if out != "{\n  \"url\": \"a&b<c>\"\n}\n" {
+		t.Errorf("wrong json output %q", out)
+	}
+
+	// Large numbers are not changed.
+	if out := writeTestOutput(t, testNodes, OUTPUT_JSON, ".[0].heartbeat"); out != "1600000000123456789\n" {
+		t.Errorf("wrong number %q", out)
+	}
+
+	// The result can be json text.
+	if out := writeTestOutput(t, `{"a": [1, 2]}`, OUTPUT_JSON, ".a[-1]"); out != "2\n" {
+		t.Errorf("wrong output of json text %q", out)
+	}
+}
+
+func Test_WriteOutput_yaml(t *testing.T) {
+	out := writeTestOutput(t, testNodes[1], OUTPUT_YAML, "")
+	expected := "arch: arm64\nheartbeat: 2\nid: node2\nproperties:\n  a/b: c\nservices: null\n"
+	if out != expected {
+		t.Errorf("wrong yaml output %q, expected %q", out, expected)
+	}
+}
+
+func Test_WriteOutput_table(t *testing.T) {
+	columns := []OutputColumn{
+		NewOutputColumn("ID", ".id"),
+		NewOutputColumn("ARCH", ".arch"),
+		NewWideOutputColumn("SERVICES", ".services[].url"),
+	}
+
+	out := writeTestOutput(t, testNodes, OUTPUT_TABLE, "", columns...)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || strings.Fields(lines[0])[0] != "ID" || len(strings.Fields(lines[0])) != 2 || strings.Fields(lines[2])[1] != "arm64" {
+		t.Errorf("wrong table output %q", out)
+	}
+
+	// The wide format has all the columns.
+	out = writeTestOutput(t, testNodes, OUTPUT_WIDE, "", columns...)
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "SERVICES") || !strings.Contains(lines[1], `["gps","cpu"]`) {
+		t.Errorf("wrong wide output %q", out)
+	}
+
+	// Without columns, the table has the fields that are not lists or objects.
+	out = writeTestOutput(t, testNodes, OUTPUT_TABLE, "")
+	if fields := strings.Fields(strings.Split(out, "\n")[0]); strings.Join(fields, " ") != "ARCH HEARTBEAT ID" {
+		t.Errorf("wrong default columns %v", fields)
+	}
+
+	// The resources by id have an ID column.
+	out = writeTestOutput(t, map[string]testService{"org/b": {Url: "u2"}, "org/a": {Url: "u1"}}, OUTPUT_TABLE, "")
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || strings.Fields(lines[0])[0] != "ID" || strings.Fields(lines[1])[0] != "org/a" {
+		t.Errorf("wrong table of resources by id %q", out)
+	}
+
+	// An object is displayed as fields and values.
+	out = writeTestOutput(t, testNodes[0], OUTPUT_TABLE, "")
+	if !strings.HasPrefix(out, "FIELD") || !strings.Contains(out, "...") {
+		t.Errorf("wrong table of an object %q", out)
+	}
+
+	// The query changes the columns.
+	out = writeTestOutput(t, testNodes, OUTPUT_TABLE, ".[] | {name: .id}", columns...)
+	if out != "NAME\nnode1\nnode2\n" {
+		t.Errorf("wrong table of the query %q", out)
+	}
+}
+
+func Test_Query(t *testing.T) {
+	data, _ := toGeneric(map[string]interface{}{"nodes": testNodes, "org/x": "y"})
+
+	for query, expected := range map[string]string{
+		".":                                   "",
+		".nodes[0].id":                        `"node1"`,
+		".nodes[].id":                         `["node1","node2"]`,
+		".nodes[0].services[1].url":           `"cpu"`,
+		".nodes[0].services[].version":        `["1.0.0","2.0.0"]`,
+		`.nodes[1].properties["a/b"]`:         `"c"`,
+		`.nodes[1].properties."a/b"`:          `"c"`,
+		`["org/x"]`:                           `"y"`,
+		".missing.field":                      `null`,
+		".nodes[5]":                           `null`,
+		".nodes[] | {id, a: .arch}":           `[{"a":"amd64","id":"node1"},{"a":"arm64","id":"node2"}]`,
+		".nodes[0] | {urls: .services[].url}": `{"urls":["gps","cpu"]}`,
+		".nodes[] | .properties // .arch":     `["amd64",{"a/b":"c"}]`,
+		".missing // .nodes[0].id":            `"node1"`,
+	} {
+		result, err := Query(data, query)
+		if err != nil {
+			t.Errorf("unexpected error %v for %v", err, query)
+			continue
+		}
+		if expected == "" {
+			continue
+		}
+		if s, _ := json.Marshal(result); string(s) != expected {
+			t.Errorf("wrong result %v for %v, expected %v", s, query, expected)
+		}
+	}
+
+	for _, query := range []string{"", "id", ".nodes[", ".nodes[x]", `.["a]`, "{id", ".nodes.id", ".nodes[0][0]", "..id", ". | "} {
+		if _, err := Query(data, query); err == nil {
+			t.Errorf("expected an error for %q", query)
+		}
+	}
+}
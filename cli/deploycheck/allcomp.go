@@ -3,7 +3,6 @@ package deploycheck
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/businesspolicy"
 	"github.com/open-horizon/anax/cli/cliconfig"
@@ -131,12 +130,7 @@ func AllCompatible(org string, userPw string, nodeId string, nodeArch string, no
 		}
 
 		// display the output
-		output, err := cliutils.DisplayAsJson(compOutput)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn policy compatible' output: %v", err))
-		}
-
-		fmt.Println(output)
+		cliutils.Output(compOutput, "'hzn policy compatible'")
 	}
 }
 
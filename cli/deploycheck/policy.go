@@ -3,7 +3,6 @@ package deploycheck
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
 	"github.com/open-horizon/anax/cli/cliconfig"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/common"
@@ -105,12 +104,7 @@ func PolicyCompatible(org string, userPw string, nodeId string, nodeArch string,
 		}
 
 		// display the output
-		output, err := cliutils.DisplayAsJson(compOutput)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn policy compatible' output: %v", err))
-		}
-
-		fmt.Println(output)
+		cliutils.Output(compOutput, "'hzn policy compatible'")
 	}
 }
 
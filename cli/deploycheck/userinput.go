@@ -111,12 +111,7 @@ func UserInputCompatible(org string, userPw string, nodeId string, nodeArch stri
 		}
 
 		// display the output
-		output, err := cliutils.DisplayAsJson(compOutput)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn policy compatible' output: %v", err))
-		}
-
-		fmt.Println(output)
+		cliutils.Output(compOutput, "'hzn policy compatible'")
 	}
 }
 
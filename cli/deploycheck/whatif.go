@@ -0,0 +1,54 @@
+package deploycheck
+
+import (
+	"github.com/open-horizon/anax/agreementbot"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/i18n"
+	"net/http"
+)
+
+// Show the nodes that would gain, lose or upgrade their agreements if the deployment policy were created or replaced
+// with the one in the given file. The evaluation is done by the agbot and nothing is changed.
+func WhatIf(org string, userPw string, businessPolId string, businessPolFile string) {
+
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if businessPolId == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("-b must be specified."))
+	} else if businessPolFile == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("-B must be specified."))
+	}
+
+	agbotUrl := cliutils.GetAgbotSecureAPIUrlBase()
+	if agbotUrl == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("HZN_AGBOT_URL is not set. Please set it to the URL of the agbot secure API."))
+	}
+
+	credToUse := cliutils.WithDefaultEnvVar(&userPw, "HZN_EXCHANGE_NODE_AUTH")
+	if *credToUse == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Please specify the Exchange credential with -u."))
+	}
+
+	// the deployment policy org defaults to the org of the credentials
+	orgToUse := org
+	if orgToUse == "" {
+		if id, _ := cliutils.SplitIdToken(*credToUse); id != "" {
+			orgToUse, _ = cliutils.TrimOrg("", id)
+		}
+		if orgToUse == "" {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Please specify the organization with -o for the Exchange credentials: %v.", *credToUse))
+		}
+	}
+
+	input := agreementbot.WhatIfInput{
+		BusinessPolicyId: cliutils.AddOrg(orgToUse, businessPolId),
+		BusinessPolicy:   getBusinessPolicy(orgToUse, *credToUse, "", businessPolFile),
+	}
+
+	cliutils.Verbose(msgPrinter.Sprintf("Using what-if input: %v", input.BusinessPolicyId))
+
+	var output agreementbot.WhatIfOutput
+	cliutils.ExchangePutPost("Agreement Bot", http.MethodPost, agbotUrl, "deploycheck/whatif", cliutils.OrgAndCreds(orgToUse, *credToUse), []int{200}, input, &output)
+
+	cliutils.Output(output, "'hzn deploycheck whatif'")
+}
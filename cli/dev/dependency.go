@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/open-horizon/anax/cli/cliconfig"
 	"github.com/open-horizon/anax/cli/cliutils"
+	cliexchange "github.com/open-horizon/anax/cli/exchange"
 	"github.com/open-horizon/anax/common"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
@@ -28,6 +29,7 @@ const DEPENDENCY_COMMAND = "dependency"
 const DEPENDENCY_FETCH_COMMAND = "fetch"
 const DEPENDENCY_LIST_COMMAND = "list"
 const DEPENDENCY_REMOVE_COMMAND = "remove"
+const DEPENDENCY_GRAPH_COMMAND = "graph"
 
 type ServiceDependency struct {
 	Service    ServiceSpec
@@ -158,6 +160,71 @@ func DependencyList(homeDirectory string) {
 
 }
 
+// This is the entry point for the hzn dev dependency graph command. It displays the resolved dependency graph of the
+// project service, built from the service definitions in the project dependencies.
+func DependencyGraph(homeDirectory string, format string) {
+
+	dir, err := setup(homeDirectory, true, false, "")
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, "'%v %v' %v", DEPENDENCY_COMMAND, DEPENDENCY_GRAPH_COMMAND, err)
+	}
+
+	serviceDef, err := GetServiceDefinition(dir, SERVICE_DEFINITION_FILE)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", DEPENDENCY_COMMAND, DEPENDENCY_GRAPH_COMMAND, err)
+	}
+
+	// The project service and its dependencies are the only services the graph can resolve to.
+	services := map[string]exchange.ServiceDefinition{}
+	addService := func(sf *common.ServiceFile) {
+		id := cutil.FormOrgSpecUrl(cutil.FormExchangeIdForService(sf.URL, sf.Version, sf.Arch), sf.Org)
+		services[id] = exchange.ServiceDefinition{URL: sf.URL, Version: sf.Version, Arch: sf.Arch, Sharable: sf.Sharable, RequiredServices: sf.RequiredServices}
+	}
+	addService(serviceDef)
+
+	if exists, err := DependenciesExists(dir, false); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", DEPENDENCY_COMMAND, DEPENDENCY_GRAPH_COMMAND, err)
+	} else if exists {
+		depFiles, err := GetDependencyFiles(dir, SERVICE_DEFINITION_FILE)
+		if err != nil {
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", DEPENDENCY_COMMAND, DEPENDENCY_GRAPH_COMMAND, err)
+		}
+		for _, fileInfo := range depFiles {
+			d, err := GetServiceDefinition(path.Join(dir, DEFAULT_DEPENDENCY_DIR), fileInfo.Name())
+			if err != nil {
+				cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", DEPENDENCY_COMMAND, DEPENDENCY_GRAPH_COMMAND, err)
+			}
+			addService(d)
+		}
+	}
+
+	handler := func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		candidates := map[string]exchange.ServiceDefinition{}
+		for id, s := range services {
+			if s.URL == wUrl && exchange.GetOrg(id) == wOrg && s.Arch == wArch {
+				candidates[id] = s
+			}
+		}
+		vRange, err := semanticversion.Version_Expression_Factory(wVersion)
+		if err != nil {
+			return nil, "", err
+		}
+		if highest, sDef, sId, err := exchange.GetHighestVersion(candidates, vRange); err != nil || highest == "" {
+			return nil, "", err
+		} else {
+			return &sDef, sId, nil
+		}
+	}
+
+	graph := exchange.NewServiceGraph()
+	if err := graph.AddRoot(serviceDef.URL, serviceDef.Org, fmt.Sprintf("[%v,%v]", serviceDef.Version, serviceDef.Version), serviceDef.Arch, handler); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", DEPENDENCY_COMMAND, DEPENDENCY_GRAPH_COMMAND, err)
+	}
+	graph.CheckSharing()
+
+	cliexchange.PrintServiceGraph(graph, format)
+}
+
 func marshalListOut(deps interface{}) {
 	jsonBytes, err := json.MarshalIndent(deps, "", "    ")
 	if err != nil {
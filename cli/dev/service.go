@@ -19,6 +19,7 @@ const SERVICE_START_COMMAND = "start"
 const SERVICE_STOP_COMMAND = "stop"
 const SERVICE_VERIFY_COMMAND = "verify"
 const SERVICE_LOG_COMMAND = "log"
+const SERVICE_TEST_COMMAND = "test"
 
 const SERVICE_NEW_DEFAULT_VERSION = "0.0.1"
 
@@ -467,6 +467,11 @@ func ExecutionTearDown(cw *container.ContainerWorker) {
 	}
 }
 
+// FindServiceContainers returns the containers of a service started by 'hzn dev service start'.
+func FindServiceContainers(serviceName string, cw *container.ContainerWorker) ([]docker.APIContainers, error) {
+	return findContainers(serviceName, "", cw)
+}
+
 func findContainers(serviceName string, instancePrefix string, cw *container.ContainerWorker) ([]docker.APIContainers, error) {
 	dcService := docker.ListContainersOptions{
 		All: true,
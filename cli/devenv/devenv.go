@@ -0,0 +1,374 @@
+package devenv
+
+import (
+	"encoding/json"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cli/sync_service"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/i18n"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The defaults of 'hzn dev env up'.
+const DEFAULT_ORG = "devorg"
+const DEFAULT_USER_PW = "admin:adminpw"
+
+// The id of the agbot of the local environment, in the org of the environment.
+const AGBOT_ID = "devagbot"
+
+// The names of the anax processes, which are also the names of their sub-directories, config, log and pid files.
+const AGENT_NAME = "agent"
+const AGBOT_NAME = "agbot"
+
+// The file in which the state of the exchange stub is kept between runs.
+const EXCHANGE_STATE_FILE = "exchange.json"
+
+// The number of seconds given to the agent to unregister, and to the anax processes to end, at shutdown.
+const UNREGISTER_TIMEOUT = 120
+const STOP_TIMEOUT = 30
+
+// GetDefaultDirectory returns the default working directory of the local environment, ~/.hzn/devenv.
+func GetDefaultDirectory() string {
+	return filepath.Join(os.Getenv("HOME"), ".hzn", "devenv")
+}
+
+// Up runs a complete Horizon management hub and agent on this machine, for offline development: an in-process
+// exchange stub, the CSS of the file sync service, an agbot on the bolt backend and an agent. The agbot and the agent
+// run the anax binary as separate processes. Up blocks until it is interrupted, then it unregisters the agent, stops
+// everything and saves the exchange resources in the working directory, so that they are there at the next run.
+func Up(dir string, org string, userPw string, anaxBinary string, exchangePort int, agentPort int, agbotPort int, noCSS bool) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	dir, org, userPw = envDefaults(dir, org, userPw)
+	user, pw := cliutils.SplitIdToken(userPw)
+	if user == "" || pw == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("the user credentials must be in the form user:password"))
+	}
+	if strings.Contains(user, "/") {
+		user = strings.SplitN(user, "/", 2)[1]
+	}
+
+	anaxPath, err := exec.LookPath(anaxBinary)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("unable to find the anax binary %v: %v", anaxBinary, err))
+	}
+	for _, name := range []string{AGENT_NAME, AGBOT_NAME} {
+		if pid := readPid(dir, name); pid != 0 && processRunning(pid) {
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("the %v of the local environment in %v is already running with pid %v. Run 'hzn dev env down' to stop it.", name, dir, pid))
+		}
+		if err := os.MkdirAll(filepath.Join(dir, name, "policy.d"), 0755); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to create directory %v: %v", filepath.Join(dir, name), err))
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, AGENT_NAME, "service"), 0755); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to create directory %v: %v", filepath.Join(dir, AGENT_NAME, "service"), err))
+	}
+
+	// Start the exchange stub with the resources of the last run.
+	stub := NewExchangeStub()
+	stateFile := filepath.Join(dir, EXCHANGE_STATE_FILE)
+	if data, err := ioutil.ReadFile(stateFile); err == nil {
+		if err := stub.Load(data); err != nil {
+			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("unable to load the exchange resources from %v: %v", stateFile, err))
+		}
+	} else if !os.IsNotExist(err) {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to read %v: %v", stateFile, err))
+	}
+	agbotToken, err := cutil.SecureRandomString()
+	if err != nil {
+		cliutils.Fatal(cliutils.INTERNAL_ERROR, msgPrinter.Sprintf("unable to create the agbot token: %v", err))
+	}
+	stub.AddOrg(org)
+	stub.AddUser(org, user, pw)
+	stub.AddAgbot(org, AGBOT_ID, agbotToken)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%v", exchangePort))
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("unable to listen on port %v for the exchange stub: %v", exchangePort, err))
+	}
+	go http.Serve(listener, stub)
+	exchangeURL := fmt.Sprintf("http://127.0.0.1:%v%v", exchangePort, STUB_API_PREFIX)
+	msgPrinter.Printf("Exchange stub listening on %v", exchangeURL)
+	msgPrinter.Println()
+
+	// Start the CSS, the agent runs its own ESS.
+	dc, err := docker.NewClient("unix:///var/run/docker.sock")
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("failed to create docker client, error: %v", err))
+	}
+	cssURL := ""
+	if !noCSS {
+		if err := sync_service.StartCSS(dc, org); err != nil {
+			sync_service.Stop(dc)
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("unable to start the file sync service: %v", err))
+		}
+		cssURL = sync_service.GetCSSURL()
+	}
+
+	// Start the agbot and the agent.
+	agbotConfig := map[string]interface{}{"AgreementBot": map[string]interface{}{
+		"DBPath":                        filepath.Join(dir, AGBOT_NAME, "db"),
+		"TxLostDelayTolerationSeconds":  120,
+		"AgreementWorkers":              5,
+		"ProtocolTimeoutS":              120,
+		"AgreementTimeoutS":             300,
+		"NoDataIntervalS":               300,
+		"PolicyPath":                    filepath.Join(dir, AGBOT_NAME, "policy.d"),
+		"NewContractIntervalS":          10,
+		"ProcessGovernanceIntervalS":    10,
+		"ExchangeURL":                   exchangeURL,
+		"ExchangeId":                    org + "/" + AGBOT_ID,
+		"ExchangeToken":                 agbotToken,
+		"ExchangeVersionCheckIntervalM": 720,
+		"ExchangeHeartbeat":             10,
+		"ExchangeMessageTTL":            1800,
+		"ActiveDeviceTimeoutS":          180,
+		"MessageKeyPath":                filepath.Join(dir, AGBOT_NAME, "msgKey"),
+		"APIListen":                     fmt.Sprintf("127.0.0.1:%v", agbotPort),
+		"PurgeArchivedAgreementHours":   1,
+		"CheckUpdatedPolicyS":           15,
+		"CSSURL":                        cssURL,
+	}}
+	agentConfig := map[string]interface{}{"Edge": map[string]interface{}{
+		"APIListen":                     fmt.Sprintf("127.0.0.1:%v", agentPort),
+		"DBPath":                        filepath.Join(dir, AGENT_NAME, "db"),
+		"DockerEndpoint":                "unix:///var/run/docker.sock",
+		"TrustSystemCACerts":            true,
+		"ServiceStorage":                filepath.Join(dir, AGENT_NAME, "service"),
+		"ExchangeURL":                   exchangeURL,
+		"PolicyPath":                    filepath.Join(dir, AGENT_NAME, "policy.d"),
+		"ExchangeHeartbeat":             10,
+		"ExchangeVersionCheckIntervalM": 720,
+		"ExchangeMessageTTL":            1800,
+		"AgreementTimeoutScaleFactor":   3,
+		"ReportDeviceStatus":            true,
+		"TrustCertUpdatesFromOrg":       true,
+		"TrustDockerAuthFromOrg":        true,
+		"ServiceUpgradeCheckIntervalS":  300,
+		"FileSyncService": map[string]interface{}{
+			"CSSURL": cssURL,
+		},
+	}}
+
+	processes := make(map[string]*exec.Cmd)
+	exited := make(chan string, 2)
+	for _, name := range []string{AGBOT_NAME, AGENT_NAME} {
+		cfg := agbotConfig
+		if name == AGENT_NAME {
+			cfg = agentConfig
+		}
+		cmd, err := startAnax(dir, name, anaxPath, cfg, exchangeURL, cssURL)
+		if err != nil {
+			stopAll(dir, processes, exited, dc, stub, agentPort)
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("unable to start the %v: %v", name, err))
+		}
+		processes[name] = cmd
+		go func(name string, cmd *exec.Cmd) {
+			cmd.Wait()
+			exited <- name
+		}(name, cmd)
+		msgPrinter.Printf("Started the %v with pid %v, its log is %v", name, cmd.Process.Pid, filepath.Join(dir, name+".log"))
+		msgPrinter.Println()
+	}
+
+	msgPrinter.Println()
+	msgPrinter.Printf("The local environment is running. Set these environment variables to use it with hzn in another shell:")
+	msgPrinter.Println()
+	fmt.Printf("  export HORIZON_URL=http://127.0.0.1:%v\n", agentPort)
+	fmt.Printf("  export HZN_AGBOT_API=http://127.0.0.1:%v\n", agbotPort)
+	fmt.Printf("  export %v=%v\n", config.ExchangeURLEnvvarName, exchangeURL)
+	if cssURL != "" {
+		fmt.Printf("  export %v=%v\n", config.FileSyncServiceCSSURLEnvvarName, cssURL)
+	}
+	fmt.Printf("  export HZN_ORG_ID=%v\n", org)
+	fmt.Printf("  export HZN_EXCHANGE_USER_AUTH=%v:%v\n", user, pw)
+	msgPrinter.Printf("Press Ctrl-C to stop it.")
+	msgPrinter.Println()
+
+	// Run until interrupted, or until one of the anax processes ends.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-signals:
+	case name := <-exited:
+		delete(processes, name)
+		msgPrinter.Printf("The %v ended, see %v. Stopping the local environment.", name, filepath.Join(dir, name+".log"))
+		msgPrinter.Println()
+	}
+	signal.Stop(signals)
+
+	if err := stopAll(dir, processes, exited, dc, stub, agentPort); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, err.Error())
+	}
+	msgPrinter.Printf("The local environment is stopped, its state is kept in %v.", dir)
+	msgPrinter.Println()
+}
+
+// Down stops what is left of a local environment whose 'hzn dev env up' did not end normally, and removes its
+// working directory when purge is set.
+func Down(dir string, purge bool) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	dir, _, _ = envDefaults(dir, "", "")
+	for _, name := range []string{AGENT_NAME, AGBOT_NAME} {
+		if pid := readPid(dir, name); pid != 0 && processRunning(pid) {
+			msgPrinter.Printf("Stopping the %v with pid %v", name, pid)
+			msgPrinter.Println()
+			if p, err := os.FindProcess(pid); err == nil {
+				p.Signal(syscall.SIGTERM)
+			}
+			for i := 0; i < STOP_TIMEOUT && processRunning(pid); i++ {
+				time.Sleep(time.Second)
+			}
+			if p, err := os.FindProcess(pid); err == nil && processRunning(pid) {
+				p.Kill()
+			}
+		}
+		os.Remove(filepath.Join(dir, name+".pid"))
+	}
+
+	if dc, err := docker.NewClient("unix:///var/run/docker.sock"); err != nil {
+		cliutils.Warning(msgPrinter.Sprintf("failed to create docker client, error: %v", err))
+	} else if err := sync_service.Stop(dc); err != nil {
+		cliutils.Warning(err.Error())
+	}
+
+	if purge {
+		if err := os.RemoveAll(dir); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to remove %v: %v", dir, err))
+		}
+		msgPrinter.Printf("Removed %v", dir)
+		msgPrinter.Println()
+	}
+}
+
+// Fills in the defaults of the working directory, the org and the user credentials.
+func envDefaults(dir string, org string, userPw string) (string, string, string) {
+	if dir == "" {
+		dir = GetDefaultDirectory()
+	}
+	if absDir, err := filepath.Abs(dir); err == nil {
+		dir = absDir
+	}
+	if org == "" {
+		org = DEFAULT_ORG
+	}
+	if userPw == "" {
+		userPw = DEFAULT_USER_PW
+	}
+	return dir, org, userPw
+}
+
+// Writes the config file of an anax process and starts it, with its output in a log file. The exchange and CSS urls
+// are also set in the environment of the process, in case they are set differently in the environment of hzn.
+func startAnax(dir string, name string, anaxPath string, cfg map[string]interface{}, exchangeURL string, cssURL string) (*exec.Cmd, error) {
+	cfg["ArchSynonyms"] = map[string]string{"x86_64": "amd64", "armhf": "arm", "aarch64": "arm64"}
+	configFile := filepath.Join(dir, name, "anax.json")
+	if data, err := json.MarshalIndent(cfg, "", cliutils.JSON_INDENT); err != nil {
+		return nil, err
+	} else if err := ioutil.WriteFile(configFile, data, 0600); err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(dir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(anaxPath, "-v", "3", "-logtostderr", "-config", configFile)
+	cmd.Dir = filepath.Join(dir, name)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = append(os.Environ(),
+		"HZN_VAR_BASE="+filepath.Join(dir, name),
+		config.ExchangeURLEnvvarName+"="+exchangeURL,
+		config.FileSyncServiceCSSURLEnvvarName+"="+cssURL)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pid"), []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		return cmd, err
+	}
+	return cmd, nil
+}
+
+// Unregisters the agent so that it removes its service containers, stops the anax processes and the CSS, and saves
+// the exchange resources.
+func stopAll(dir string, processes map[string]*exec.Cmd, exited chan string, dc *docker.Client, stub *ExchangeStub, agentPort int) error {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if _, running := processes[AGENT_NAME]; running {
+		msgPrinter.Printf("Unregistering the agent")
+		msgPrinter.Println()
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://127.0.0.1:%v/node?block=true&removeNode=false", agentPort), nil)
+		if resp, err := cliutils.GetHTTPClient(UNREGISTER_TIMEOUT).Do(req); err != nil {
+			cliutils.Verbose(msgPrinter.Sprintf("unable to unregister the agent: %v", err))
+		} else {
+			resp.Body.Close()
+		}
+	}
+
+	for name, cmd := range processes {
+		msgPrinter.Printf("Stopping the %v", name)
+		msgPrinter.Println()
+		cmd.Process.Signal(syscall.SIGTERM)
+	}
+	deadline := time.After(STOP_TIMEOUT * time.Second)
+	for len(processes) != 0 {
+		select {
+		case name := <-exited:
+			delete(processes, name)
+			os.Remove(filepath.Join(dir, name+".pid"))
+		case <-deadline:
+			for name, cmd := range processes {
+				cliutils.Verbose(msgPrinter.Sprintf("the %v did not stop within %v seconds, killing it", name, STOP_TIMEOUT))
+				cmd.Process.Kill()
+				os.Remove(filepath.Join(dir, name+".pid"))
+				delete(processes, name)
+			}
+		}
+	}
+
+	if err := sync_service.Stop(dc); err != nil {
+		cliutils.Verbose(err.Error())
+	}
+
+	stateFile := filepath.Join(dir, EXCHANGE_STATE_FILE)
+	if data, err := stub.Save(); err != nil {
+		return fmt.Errorf(msgPrinter.Sprintf("unable to save the exchange resources: %v", err))
+	} else if err := ioutil.WriteFile(stateFile, data, 0600); err != nil {
+		return fmt.Errorf(msgPrinter.Sprintf("unable to write %v: %v", stateFile, err))
+	}
+	return nil
+}
+
+func readPid(dir string, name string) int {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name+".pid"))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	return err == nil && p.Signal(syscall.Signal(0)) == nil
+}
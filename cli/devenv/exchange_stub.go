@@ -0,0 +1,858 @@
+package devenv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/version"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The path prefix of the exchange stub REST API, the same as the exchange.
+const STUB_API_PREFIX = "/v1/"
+
+// The collections of exchange resources in the stub, named after the path segment of the exchange REST API.
+const (
+	COLL_ORGS     = "orgs"
+	COLL_USERS    = "users"
+	COLL_NODES    = "nodes"
+	COLL_AGBOTS   = "agbots"
+	COLL_SERVICES = "services"
+	COLL_PATTERNS = "patterns"
+	COLL_POLICIES = "business/policies"
+)
+
+// The key of each collection in the response of a GET on the exchange.
+var collectionListKeys = map[string]string{
+	COLL_ORGS:     "orgs",
+	COLL_USERS:    "users",
+	COLL_NODES:    "nodes",
+	COLL_AGBOTS:   "agbots",
+	COLL_SERVICES: "services",
+	COLL_PATTERNS: "patterns",
+	COLL_POLICIES: "businessPolicy",
+}
+
+// The name of a resource of each collection, in the messages of the stub.
+var collectionNames = map[string]string{
+	COLL_ORGS:     "org",
+	COLL_USERS:    "user",
+	COLL_NODES:    "node",
+	COLL_AGBOTS:   "agbot",
+	COLL_SERVICES: "service",
+	COLL_PATTERNS: "pattern",
+	COLL_POLICIES: "business policy",
+}
+
+// The resource type of the exchange change recorded for a write to a resource, or to an attribute of a resource.
+// Writes to the resources that are not listed are not recorded.
+var changeResources = map[string]string{
+	COLL_ORGS:                  exchange.RESOURCE_ORG,
+	COLL_ORGS + "/mmskeys":     exchange.RESOURCE_ORG,
+	COLL_NODES:                 exchange.RESOURCE_NODE,
+	COLL_NODES + "/policy":     exchange.RESOURCE_NODE_POLICY,
+	COLL_NODES + "/status":     exchange.RESOURCE_NODE_STATUS,
+	COLL_NODES + "/errors":     exchange.RESOURCE_NODE_ERROR,
+	COLL_NODES + "/agreements": exchange.RESOURCE_NODE_AGREEMENTS,
+	COLL_NODES + "/msgs":       exchange.RESOURCE_NODE_MSG,
+	COLL_NODES + "/" + exchange.RESOURCE_NODE_SERVICES_CONFIGSTATE: exchange.RESOURCE_NODE_SERVICES_CONFIGSTATE,
+	COLL_AGBOTS:                  exchange.RESOURCE_AGBOT,
+	COLL_AGBOTS + "/agreements":  exchange.RESOURCE_AGBOT_AGREEMENTS,
+	COLL_AGBOTS + "/msgs":        exchange.RESOURCE_AGBOT_MSG,
+	COLL_SERVICES:                exchange.RESOURCE_SERVICE,
+	COLL_SERVICES + "/policy":    exchange.RESOURCE_AGBOT_SERVICE_POLICY,
+	COLL_SERVICES + "/keys":      exchange.RESOURCE_SERVICE,
+	COLL_SERVICES + "/dockauths": exchange.RESOURCE_SERVICE,
+	COLL_PATTERNS:                exchange.RESOURCE_AGBOT_PATTERN,
+	COLL_PATTERNS + "/keys":      exchange.RESOURCE_AGBOT_PATTERN,
+	COLL_POLICIES:                exchange.RESOURCE_AGBOT_POLICY,
+}
+
+// The attributes of a resource that are stored as a whole, without interpretation.
+var stubAttributes = map[string]bool{
+	"policy": true,
+	"status": true,
+	"errors": true,
+	exchange.RESOURCE_NODE_SERVICES_CONFIGSTATE: true,
+}
+
+// A message between a node and an agbot.
+type stubMessage struct {
+	Id      int       `json:"id"`
+	To      string    `json:"to"` // collection/org/id of the receiver
+	From    string    `json:"from"`
+	FromKey []byte    `json:"fromKey"`
+	Message []byte    `json:"message"`
+	Sent    time.Time `json:"sent"`
+	Expires time.Time `json:"expires"`
+}
+
+type stubChange struct {
+	Id     uint64                  `json:"id"`
+	Change exchange.ExchangeChange `json:"change"`
+}
+
+// The state of the exchange stub, which is saved between the runs of the local environment. The keys of the
+// resources are "org/id", except for the orgs. The attributes, agreements and keys of a resource are keyed by
+// "collection/org/id".
+type stubState struct {
+	Resources    map[string]map[string]map[string]interface{} `json:"resources"`
+	Attributes   map[string]map[string]interface{}            `json:"attributes"`
+	Agreements   map[string]map[string]interface{}            `json:"agreements"`
+	Keys         map[string]map[string]string                 `json:"keys"`
+	DockAuths    map[string][]map[string]interface{}          `json:"dockAuths"`
+	Messages     []stubMessage                                `json:"messages"`
+	LastMsgId    int                                          `json:"lastMsgId"`
+	Changes      []stubChange                                 `json:"changes"`
+	LastChangeId uint64                                       `json:"lastChangeId"`
+}
+
+// ExchangeStub is an in-process stand-in for the exchange, which implements the subset of the exchange REST API used
+// by the agent, the agbot and hzn. It does not check the credentials of the callers, the caller id is only used as
+// the sender of the messages and the owner of the resources.
+type ExchangeStub struct {
+	lock  sync.Mutex
+	state stubState
+}
+
+func NewExchangeStub() *ExchangeStub {
+	return &ExchangeStub{
+		state: stubState{
+			Resources:  make(map[string]map[string]map[string]interface{}),
+			Attributes: make(map[string]map[string]interface{}),
+			Agreements: make(map[string]map[string]interface{}),
+			Keys:       make(map[string]map[string]string),
+			DockAuths:  make(map[string][]map[string]interface{}),
+			Messages:   []stubMessage{},
+			Changes:    []stubChange{},
+		},
+	}
+}
+
+// Load restores the state saved by Save.
+func (s *ExchangeStub) Load(data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	state := NewExchangeStub().state
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	s.state = state
+	return nil
+}
+
+func (s *ExchangeStub) Save() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return json.MarshalIndent(s.state, "", cliutils.JSON_INDENT)
+}
+
+// AddOrg creates an org if it does not exist.
+func (s *ExchangeStub) AddOrg(org string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.get(COLL_ORGS, org) == nil {
+		s.put(COLL_ORGS, org, map[string]interface{}{"label": org, "description": "local development org", "orgType": ""}, "")
+	}
+}
+
+// AddUser creates or replaces an admin user of an org.
+func (s *ExchangeStub) AddUser(org string, user string, pw string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.put(COLL_USERS, org+"/"+user, map[string]interface{}{"password": pw, "admin": true, "email": ""}, org+"/"+user)
+}
+
+// AddAgbot creates or replaces an agbot, which serves all the patterns and deployment policies of all the orgs.
+func (s *ExchangeStub) AddAgbot(org string, id string, token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.put(COLL_AGBOTS, org+"/"+id, map[string]interface{}{"token": token, "name": id, "msgEndPoint": "", "publicKey": ""}, "")
+}
+
+func (s *ExchangeStub) get(coll string, key string) map[string]interface{} {
+	if s.state.Resources[coll] == nil {
+		return nil
+	}
+	return s.state.Resources[coll][key]
+}
+
+// Stores a resource and records the change.
+func (s *ExchangeStub) put(coll string, key string, fields map[string]interface{}, owner string) {
+	op := exchange.CHANGE_OPERATION_CREATED
+	if s.state.Resources[coll] == nil {
+		s.state.Resources[coll] = make(map[string]map[string]interface{})
+	}
+	if old := s.state.Resources[coll][key]; old != nil {
+		op = exchange.CHANGE_OPERATION_MODIFIED
+		for _, f := range []string{"owner", "lastHeartbeat", "publicKey"} {
+			if _, ok := fields[f]; !ok && old[f] != nil {
+				fields[f] = old[f]
+			}
+		}
+	}
+	if _, ok := fields["owner"]; !ok && owner != "" {
+		fields["owner"] = owner
+	}
+	fields["lastUpdated"] = stubTime()
+	s.state.Resources[coll][key] = fields
+	s.addChange(coll, key, op)
+
+	// A new org is served by all the agbots.
+	if coll == COLL_ORGS && op == exchange.CHANGE_OPERATION_CREATED {
+		for agbot := range s.state.Resources[COLL_AGBOTS] {
+			s.addChangeResource(exchange.RESOURCE_AGBOT_SERVED_PATTERN, agbot, op)
+			s.addChangeResource(exchange.RESOURCE_AGBOT_SERVED_POLICY, agbot, op)
+		}
+	}
+}
+
+// Records a change to a resource or to one of its attributes.
+func (s *ExchangeStub) addChange(what string, key string, op string) {
+	if resource, ok := changeResources[what]; ok {
+		s.addChangeResource(resource, key, op)
+	}
+}
+
+func (s *ExchangeStub) addChangeResource(resource string, key string, op string) {
+	s.state.LastChangeId++
+	org, id := key, key
+	if strings.Contains(key, "/") {
+		org, id = exchange.GetOrg(key), exchange.GetId(key)
+	}
+	change := exchange.ExchangeChange{OrgID: org, Resource: resource, ID: id, Operation: op, ResourceChanges: []exchange.ResourceChange{{ChangeID: s.state.LastChangeId}}}
+	s.state.Changes = append(s.state.Changes, stubChange{Id: s.state.LastChangeId, Change: change})
+}
+
+// Updates the lastUpdated time of a node, so that the policy searches find it again.
+func (s *ExchangeStub) touch(coll string, key string) {
+	if r := s.get(coll, key); r != nil {
+		r["lastUpdated"] = stubTime()
+	}
+}
+
+func stubTime() string {
+	return time.Now().UTC().Format(cutil.ExchangeTimeFormat)
+}
+
+// The response of the stub, a string is returned as text, anything else as json.
+type stubResponse struct {
+	code int
+	body interface{}
+}
+
+func ok(code int, msg string) stubResponse {
+	return stubResponse{code: code, body: map[string]string{"code": "ok", "msg": msg}}
+}
+
+func failed(code int, msg string) stubResponse {
+	codes := map[int]string{http.StatusBadRequest: "invalid input", http.StatusForbidden: "access denied", http.StatusNotFound: "not found"}
+	return stubResponse{code: code, body: map[string]string{"code": codes[code], "msg": msg}}
+}
+
+func notFound(what string) stubResponse {
+	return failed(http.StatusNotFound, fmt.Sprintf("%v not found", what))
+}
+
+func (s *ExchangeStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	caller, _, _ := r.BasicAuth()
+
+	// The deployment policies are the only resources whose collection has 2 segments.
+	segs := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, STUB_API_PREFIX), "/"), "/")
+	for i := 0; i < len(segs)-1; i++ {
+		if segs[i] == "business" && segs[i+1] == "policies" {
+			segs = append(append(segs[:i:i], COLL_POLICIES), segs[i+2:]...)
+			break
+		}
+	}
+
+	s.lock.Lock()
+	resp := s.route(r.Method, segs, r.URL.Query(), body, caller)
+	s.lock.Unlock()
+	cliutils.Verbose("exchange stub: %v %v %v", r.Method, r.URL.Path, resp.code)
+
+	if text, isText := resp.body.(string); isText {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(resp.code)
+		w.Write([]byte(text))
+	} else if resp.body == nil {
+		w.WriteHeader(resp.code)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(resp.code)
+		json.NewEncoder(w).Encode(resp.body)
+	}
+}
+
+func (s *ExchangeStub) route(method string, segs []string, query url.Values, body []byte, caller string) stubResponse {
+	switch {
+	case len(segs) == 2 && segs[0] == "admin" && segs[1] == "version":
+		return stubResponse{code: http.StatusOK, body: version.PREFERRED_EXCHANGE_VERSION}
+	case len(segs) == 2 && segs[0] == "changes" && segs[1] == "maxchangeid":
+		return stubResponse{code: http.StatusOK, body: exchange.ExchangeChangeIDResponse{MaxChangeID: s.state.LastChangeId}}
+	case len(segs) == 1 && segs[0] == COLL_ORGS && method == http.MethodGet:
+		return s.list(COLL_ORGS, "", query)
+	case len(segs) == 2 && segs[0] == COLL_ORGS:
+		return s.item(method, COLL_ORGS, segs[1], body, caller)
+	case len(segs) < 3 || segs[0] != COLL_ORGS:
+		return notFound(strings.Join(segs, "/"))
+	}
+
+	org, rest := segs[1], segs[2:]
+	switch rest[0] {
+	case "changes":
+		return s.changes(org, body)
+	case "mmskeys":
+		if s.get(COLL_ORGS, org) == nil {
+			return notFound(org)
+		}
+		return s.keys(method, COLL_ORGS, org, rest[0], rest[1:], body)
+	case "search":
+		if len(rest) == 2 && rest[1] == "nodehealth" {
+			return s.nodeHealth(org, "", body)
+		}
+		return notFound(strings.Join(segs, "/"))
+	}
+
+	coll := rest[0]
+	if _, known := collectionListKeys[coll]; !known || coll == COLL_ORGS {
+		return notFound(strings.Join(segs, "/"))
+	}
+	if len(rest) == 1 {
+		if method == http.MethodPost && coll == COLL_SERVICES {
+			return s.postService(org, body, caller)
+		}
+		return s.list(coll, org, query)
+	}
+	key := org + "/" + rest[1]
+	if len(rest) == 2 {
+		return s.item(method, coll, key, body, caller)
+	}
+	if s.get(coll, key) == nil {
+		return notFound(key)
+	}
+	return s.attribute(method, coll, key, rest[2:], query, body, caller)
+}
+
+// Lists the resources of a collection in an org, the services can be filtered by url, version and arch.
+func (s *ExchangeStub) list(coll string, org string, query url.Values) stubResponse {
+	resources := make(map[string]interface{})
+	for key, r := range s.state.Resources[coll] {
+		if coll != COLL_ORGS && exchange.GetOrg(key) != org {
+			continue
+		}
+		if coll == COLL_SERVICES {
+			if query.Get("url") != "" && r["url"] != query.Get("url") || query.Get("version") != "" && r["version"] != query.Get("version") || query.Get("arch") != "" && r["arch"] != query.Get("arch") {
+				continue
+			}
+		}
+		resources[key] = s.view(coll, r)
+	}
+	if len(resources) == 0 {
+		return stubResponse{code: http.StatusNotFound, body: map[string]interface{}{collectionListKeys[coll]: resources, "lastIndex": 0}}
+	}
+	return stubResponse{code: http.StatusOK, body: map[string]interface{}{collectionListKeys[coll]: resources, "lastIndex": 0}}
+}
+
+// Hides the secrets of a resource.
+func (s *ExchangeStub) view(coll string, r map[string]interface{}) map[string]interface{} {
+	v := make(map[string]interface{}, len(r))
+	for k, f := range r {
+		if k == "token" || k == "password" {
+			f = "********"
+		}
+		v[k] = f
+	}
+	return v
+}
+
+func (s *ExchangeStub) item(method string, coll string, key string, body []byte, caller string) stubResponse {
+	r := s.get(coll, key)
+	switch method {
+	case http.MethodGet:
+		if r == nil {
+			return notFound(key)
+		}
+		return stubResponse{code: http.StatusOK, body: map[string]interface{}{collectionListKeys[coll]: map[string]interface{}{key: s.view(coll, r)}, "lastIndex": 0}}
+
+	case http.MethodPut, http.MethodPost:
+		if method == http.MethodPost && r != nil {
+			return failed(http.StatusForbidden, fmt.Sprintf("%v already exists", key))
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		if coll == COLL_NODES || coll == COLL_AGBOTS {
+			fields["lastHeartbeat"] = stubTime()
+		}
+		s.put(coll, key, fields, caller)
+		return ok(http.StatusCreated, fmt.Sprintf("%v '%v' added or updated", collectionNames[coll], key))
+
+	case http.MethodPatch:
+		if r == nil {
+			return notFound(key)
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		for k, f := range r {
+			if _, ok := fields[k]; !ok {
+				fields[k] = f
+			}
+		}
+		s.put(coll, key, fields, caller)
+		return ok(http.StatusCreated, fmt.Sprintf("%v '%v' updated", collectionNames[coll], key))
+
+	case http.MethodDelete:
+		if r == nil {
+			return notFound(key)
+		}
+		delete(s.state.Resources[coll], key)
+		prefix := coll + "/" + key
+		for k := range s.state.Attributes {
+			if strings.HasPrefix(k, prefix+"/") {
+				delete(s.state.Attributes, k)
+			}
+		}
+		delete(s.state.Agreements, prefix)
+		delete(s.state.Keys, prefix)
+		delete(s.state.DockAuths, prefix)
+		s.addChange(coll, key, exchange.CHANGE_OPERATION_DELETED)
+		return stubResponse{code: http.StatusNoContent}
+	}
+	return failed(http.StatusBadRequest, fmt.Sprintf("method %v is not supported", method))
+}
+
+// Creates a service, its id is formed from its url, version and arch.
+func (s *ExchangeStub) postService(org string, body []byte, caller string) stubResponse {
+	var svc struct {
+		URL     string `json:"url"`
+		Version string `json:"version"`
+		Arch    string `json:"arch"`
+	}
+	if err := json.Unmarshal(body, &svc); err != nil || svc.URL == "" || svc.Version == "" || svc.Arch == "" {
+		return failed(http.StatusBadRequest, "the service must have a url, a version and an arch")
+	}
+	return s.item(http.MethodPost, COLL_SERVICES, org+"/"+cutil.FormExchangeIdForService(svc.URL, svc.Version, svc.Arch), body, caller)
+}
+
+// Handles the sub-resources of a resource.
+func (s *ExchangeStub) attribute(method string, coll string, key string, rest []string, query url.Values, body []byte, caller string) stubResponse {
+	prefix := coll + "/" + key
+	what := coll + "/" + rest[0]
+
+	switch {
+	case rest[0] == "heartbeat" && method == http.MethodPost:
+		s.get(coll, key)["lastHeartbeat"] = stubTime()
+		return ok(http.StatusCreated, "heartbeat successful")
+
+	case stubAttributes[rest[0]] && len(rest) == 1:
+		attrKey := prefix + "/" + rest[0]
+		switch method {
+		case http.MethodGet:
+			if a, found := s.state.Attributes[attrKey]; found {
+				return stubResponse{code: http.StatusOK, body: a}
+			}
+			return notFound(attrKey)
+		case http.MethodPut, http.MethodPost:
+			attr := make(map[string]interface{})
+			if err := json.Unmarshal(body, &attr); err != nil {
+				return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+			}
+			attr["lastUpdated"] = stubTime()
+			s.state.Attributes[attrKey] = attr
+			s.touch(coll, key)
+			s.addChange(what, key, exchange.CHANGE_OPERATION_CREATED_MODIFIED)
+			return ok(http.StatusCreated, fmt.Sprintf("%v added or updated", attrKey))
+		case http.MethodDelete:
+			if _, found := s.state.Attributes[attrKey]; !found {
+				return notFound(attrKey)
+			}
+			delete(s.state.Attributes, attrKey)
+			s.touch(coll, key)
+			s.addChange(what, key, exchange.CHANGE_OPERATION_DELETED)
+			return stubResponse{code: http.StatusNoContent}
+		}
+
+	case rest[0] == "agreements" && (coll == COLL_NODES || coll == COLL_AGBOTS):
+		return s.agreements(method, coll, key, rest[1:], body)
+
+	case rest[0] == "msgs" && (coll == COLL_NODES || coll == COLL_AGBOTS):
+		return s.messages(method, coll, key, rest[1:], query, body, caller)
+
+	case rest[0] == "keys" && (coll == COLL_SERVICES || coll == COLL_PATTERNS):
+		return s.keys(method, coll, key, rest[0], rest[1:], body)
+
+	case rest[0] == "dockauths" && coll == COLL_SERVICES:
+		return s.dockAuths(method, key, rest[1:], body)
+
+	case rest[0] == "patterns" && coll == COLL_AGBOTS && method == http.MethodGet:
+		served := make(map[string]exchange.ServedPattern)
+		for _, org := range s.orgs() {
+			served[org+"_*_"+org] = exchange.ServedPattern{PatternOrg: org, Pattern: "*", NodeOrg: org}
+		}
+		return stubResponse{code: http.StatusOK, body: exchange.GetAgbotsPatternsResponse{Patterns: served}}
+
+	case rest[0] == "businesspols" && coll == COLL_AGBOTS && method == http.MethodGet:
+		served := make(map[string]exchange.ServedBusinessPolicy)
+		for _, org := range s.orgs() {
+			served[org+"_*_"+org] = exchange.ServedBusinessPolicy{BusinessPolOrg: org, BusinessPol: "*", NodeOrg: org}
+		}
+		return stubResponse{code: http.StatusOK, body: exchange.GetAgbotsBusinessPolsResponse{BusinessPols: served}}
+
+	case rest[0] == "search" && (coll == COLL_PATTERNS || coll == COLL_POLICIES) && method == http.MethodPost:
+		return s.search(coll, key, body)
+
+	case rest[0] == "nodehealth" && coll == COLL_PATTERNS && method == http.MethodPost:
+		return s.nodeHealth(exchange.GetOrg(key), key, body)
+	}
+	return notFound(prefix + "/" + strings.Join(rest, "/"))
+}
+
+func (s *ExchangeStub) orgs() []string {
+	orgs := []string{}
+	for org := range s.state.Resources[COLL_ORGS] {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	return orgs
+}
+
+func (s *ExchangeStub) agreements(method string, coll string, key string, rest []string, body []byte) stubResponse {
+	prefix := coll + "/" + key
+	ags := s.state.Agreements[prefix]
+	if ags == nil {
+		ags = make(map[string]interface{})
+		s.state.Agreements[prefix] = ags
+	}
+
+	switch {
+	case len(rest) == 0 && method == http.MethodGet:
+		code := http.StatusOK
+		if len(ags) == 0 {
+			code = http.StatusNotFound
+		}
+		return stubResponse{code: code, body: map[string]interface{}{"agreements": ags, "lastIndex": 0}}
+	case len(rest) == 0 && method == http.MethodDelete:
+		s.state.Agreements[prefix] = make(map[string]interface{})
+		s.touch(coll, key)
+		s.addChange(coll+"/agreements", key, exchange.CHANGE_OPERATION_DELETED)
+		return stubResponse{code: http.StatusNoContent}
+	case len(rest) == 1 && method == http.MethodGet:
+		if ag, found := ags[rest[0]]; found {
+			return stubResponse{code: http.StatusOK, body: map[string]interface{}{"agreements": map[string]interface{}{rest[0]: ag}, "lastIndex": 0}}
+		}
+		return notFound(prefix + "/agreements/" + rest[0])
+	case len(rest) == 1 && method == http.MethodPut:
+		ag := make(map[string]interface{})
+		if err := json.Unmarshal(body, &ag); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		ag["lastUpdated"] = stubTime()
+		ags[rest[0]] = ag
+		s.touch(coll, key)
+		s.addChange(coll+"/agreements", key, exchange.CHANGE_OPERATION_CREATED_MODIFIED)
+		return ok(http.StatusCreated, fmt.Sprintf("agreement %v added or updated", rest[0]))
+	case len(rest) == 1 && method == http.MethodDelete:
+		if _, found := ags[rest[0]]; !found {
+			return notFound(prefix + "/agreements/" + rest[0])
+		}
+		delete(ags, rest[0])
+		s.touch(coll, key)
+		s.addChange(coll+"/agreements", key, exchange.CHANGE_OPERATION_DELETED)
+		return stubResponse{code: http.StatusNoContent}
+	}
+	return failed(http.StatusBadRequest, fmt.Sprintf("method %v is not supported", method))
+}
+
+// Handles the messages sent to a node by the agbots, and to an agbot by the nodes.
+func (s *ExchangeStub) messages(method string, coll string, key string, rest []string, query url.Values, body []byte, caller string) stubResponse {
+	to := coll + "/" + key
+	now := time.Now()
+
+	// Remove the expired messages.
+	msgs := s.state.Messages[:0]
+	for _, m := range s.state.Messages {
+		if m.Expires.After(now) {
+			msgs = append(msgs, m)
+		}
+	}
+	s.state.Messages = msgs
+
+	switch {
+	case len(rest) == 0 && method == http.MethodGet:
+		max, _ := strconv.Atoi(query.Get("maxmsgs"))
+		nodeMsgs, agbotMsgs := []exchange.DeviceMessage{}, []exchange.AgbotMessage{}
+		for _, m := range s.state.Messages {
+			if m.To != to || max > 0 && len(nodeMsgs)+len(agbotMsgs) == max {
+				continue
+			}
+			if coll == COLL_NODES {
+				nodeMsgs = append(nodeMsgs, exchange.DeviceMessage{MsgId: m.Id, AgbotId: m.From, AgbotPubKey: m.FromKey, Message: m.Message, TimeSent: m.Sent.UTC().Format(cutil.ExchangeTimeFormat)})
+			} else {
+				agbotMsgs = append(agbotMsgs, exchange.AgbotMessage{MsgId: m.Id, DeviceId: m.From, DevicePubKey: m.FromKey, Message: m.Message, TimeSent: m.Sent.UTC().Format(cutil.ExchangeTimeFormat), TimeExpires: m.Expires.UTC().Format(cutil.ExchangeTimeFormat)})
+			}
+		}
+		if coll == COLL_NODES {
+			return stubResponse{code: http.StatusOK, body: exchange.GetDeviceMessageResponse{Messages: nodeMsgs}}
+		}
+		return stubResponse{code: http.StatusOK, body: exchange.GetAgbotMessageResponse{Messages: agbotMsgs}}
+
+	case len(rest) == 0 && method == http.MethodPost:
+		var pm exchange.PostMessage
+		if err := json.Unmarshal(body, &pm); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		// The nodes send messages to the agbots and the agbots to the nodes.
+		senderColl := COLL_AGBOTS
+		if coll == COLL_AGBOTS {
+			senderColl = COLL_NODES
+		}
+		var fromKey []byte
+		if sender := s.get(senderColl, caller); sender != nil {
+			if k, isString := sender["publicKey"].(string); isString {
+				fromKey, _ = base64.StdEncoding.DecodeString(k)
+			}
+		}
+		if pm.TTL <= 0 {
+			pm.TTL = 180
+		}
+		s.state.LastMsgId++
+		s.state.Messages = append(s.state.Messages, stubMessage{Id: s.state.LastMsgId, To: to, From: caller, FromKey: fromKey, Message: pm.Message, Sent: now, Expires: now.Add(time.Duration(pm.TTL) * time.Second)})
+		s.addChange(coll+"/msgs", key, exchange.CHANGE_OPERATION_CREATED)
+		return ok(http.StatusCreated, fmt.Sprintf("message %v added", s.state.LastMsgId))
+
+	case len(rest) == 1 && method == http.MethodDelete:
+		id, _ := strconv.Atoi(rest[0])
+		for i, m := range s.state.Messages {
+			if m.To == to && m.Id == id {
+				s.state.Messages = append(s.state.Messages[:i], s.state.Messages[i+1:]...)
+				return stubResponse{code: http.StatusNoContent}
+			}
+		}
+		return notFound(to + "/msgs/" + rest[0])
+	}
+	return failed(http.StatusBadRequest, fmt.Sprintf("method %v is not supported", method))
+}
+
+// Handles the signing keys of the services and patterns, and the MMS signing keys of the orgs, they are stored as text.
+func (s *ExchangeStub) keys(method string, coll string, key string, subres string, rest []string, body []byte) stubResponse {
+	prefix := coll + "/" + key
+	keys := s.state.Keys[prefix]
+	if keys == nil {
+		keys = make(map[string]string)
+		s.state.Keys[prefix] = keys
+	}
+
+	switch {
+	case len(rest) == 0 && method == http.MethodGet:
+		names := []string{}
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return stubResponse{code: http.StatusOK, body: names}
+	case len(rest) == 0 && method == http.MethodDelete:
+		s.state.Keys[prefix] = make(map[string]string)
+		s.addChange(coll+"/"+subres, key, exchange.CHANGE_OPERATION_DELETED)
+		return stubResponse{code: http.StatusNoContent}
+	case len(rest) == 1 && method == http.MethodGet:
+		if k, found := keys[rest[0]]; found {
+			return stubResponse{code: http.StatusOK, body: k}
+		}
+		return notFound(prefix + "/" + subres + "/" + rest[0])
+	case len(rest) == 1 && method == http.MethodPut:
+		keys[rest[0]] = string(body)
+		s.addChange(coll+"/"+subres, key, exchange.CHANGE_OPERATION_CREATED_MODIFIED)
+		return ok(http.StatusCreated, fmt.Sprintf("key %v added or updated", rest[0]))
+	case len(rest) == 1 && method == http.MethodDelete:
+		if _, found := keys[rest[0]]; !found {
+			return notFound(prefix + "/" + subres + "/" + rest[0])
+		}
+		delete(keys, rest[0])
+		s.addChange(coll+"/"+subres, key, exchange.CHANGE_OPERATION_DELETED)
+		return stubResponse{code: http.StatusNoContent}
+	}
+	return failed(http.StatusBadRequest, fmt.Sprintf("method %v is not supported", method))
+}
+
+// Handles the docker registry credentials of the services.
+func (s *ExchangeStub) dockAuths(method string, key string, rest []string, body []byte) stubResponse {
+	prefix := COLL_SERVICES + "/" + key
+	auths := s.state.DockAuths[prefix]
+
+	switch {
+	case len(rest) == 0 && method == http.MethodGet:
+		if auths == nil {
+			auths = []map[string]interface{}{}
+		}
+		return stubResponse{code: http.StatusOK, body: auths}
+	case len(rest) == 0 && method == http.MethodPost:
+		auth := make(map[string]interface{})
+		if err := json.Unmarshal(body, &auth); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		id := 1
+		if len(auths) != 0 {
+			id = int(auths[len(auths)-1]["dockAuthId"].(float64)) + 1
+		}
+		auth["dockAuthId"] = float64(id)
+		auth["lastUpdated"] = stubTime()
+		s.state.DockAuths[prefix] = append(auths, auth)
+		s.addChange(COLL_SERVICES+"/dockauths", key, exchange.CHANGE_OPERATION_CREATED)
+		return ok(http.StatusCreated, fmt.Sprintf("dockauth %v added", id))
+	case len(rest) == 0 && method == http.MethodDelete:
+		delete(s.state.DockAuths, prefix)
+		s.addChange(COLL_SERVICES+"/dockauths", key, exchange.CHANGE_OPERATION_DELETED)
+		return stubResponse{code: http.StatusNoContent}
+	case len(rest) == 1 && method == http.MethodDelete:
+		for i, a := range auths {
+			if fmt.Sprintf("%v", a["dockAuthId"]) == rest[0] {
+				s.state.DockAuths[prefix] = append(auths[:i], auths[i+1:]...)
+				s.addChange(COLL_SERVICES+"/dockauths", key, exchange.CHANGE_OPERATION_DELETED)
+				return stubResponse{code: http.StatusNoContent}
+			}
+		}
+		return notFound(prefix + "/dockauths/" + rest[0])
+	}
+	return failed(http.StatusBadRequest, fmt.Sprintf("method %v is not supported", method))
+}
+
+// Returns the changes since a change id in the orgs of the request, or in the org of the caller.
+func (s *ExchangeStub) changes(org string, body []byte) stubResponse {
+	var req exchange.GetExchangeChangesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	}
+	orgs := map[string]bool{org: true}
+	for _, o := range req.Orgs {
+		orgs[o] = true
+	}
+	max := req.MaxRecords
+	if max <= 0 {
+		max = 1000
+	}
+
+	resp := exchange.ExchangeChanges{Changes: []exchange.ExchangeChange{}, ExchangeVersion: version.PREFERRED_EXCHANGE_VERSION}
+	for _, c := range s.state.Changes {
+		if c.Id < req.ChangeId || !orgs[c.Change.OrgID] && !orgs["*"] {
+			continue
+		}
+		if len(resp.Changes) == max {
+			break
+		}
+		resp.Changes = append(resp.Changes, c.Change)
+		resp.MostRecentChangeID = c.Id
+	}
+	if len(resp.Changes) == 0 {
+		resp.MostRecentChangeID = s.state.LastChangeId
+	}
+	return stubResponse{code: http.StatusCreated, body: resp}
+}
+
+// Finds the nodes that a pattern or a deployment policy can be deployed to. The nodes of a pattern are registered
+// with the pattern, the nodes of a deployment policy have no pattern and were updated since the last search.
+func (s *ExchangeStub) search(coll string, key string, body []byte) stubResponse {
+	var nodeOrgs []string
+	var changedSince uint64
+	var session string
+	if coll == COLL_PATTERNS {
+		var req exchange.SearchExchangePatternRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		nodeOrgs = req.NodeOrgIds
+	} else {
+		var req exchange.SearchExchBusinessPolRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		}
+		nodeOrgs, changedSince, session = req.NodeOrgIds, req.ChangedSince, req.Session
+	}
+	if len(nodeOrgs) == 0 {
+		nodeOrgs = []string{exchange.GetOrg(key)}
+	}
+
+	devices := []exchange.SearchResultDevice{}
+	for _, nodeId := range s.nodeIds(nodeOrgs) {
+		node := s.get(COLL_NODES, nodeId)
+		pattern, _ := node["pattern"].(string)
+		publicKey, _ := node["publicKey"].(string)
+		nodeType, _ := node["nodeType"].(string)
+		if publicKey == "" {
+			continue
+		} else if coll == COLL_PATTERNS && pattern != key {
+			continue
+		} else if coll == COLL_POLICIES {
+			lastUpdated, _ := node["lastUpdated"].(string)
+			if pattern != "" || uint64(cutil.TimeInSeconds(lastUpdated, cutil.ExchangeTimeFormat)) < changedSince {
+				continue
+			}
+		}
+		devices = append(devices, exchange.SearchResultDevice{Id: nodeId, NodeType: nodeType, PublicKey: publicKey})
+	}
+
+	if coll == COLL_PATTERNS {
+		return stubResponse{code: http.StatusCreated, body: exchange.SearchExchangePatternResponse{Devices: devices}}
+	}
+	return stubResponse{code: http.StatusCreated, body: exchange.SearchExchBusinessPolResponse{Devices: devices, Offset: stubTime(), Session: session}}
+}
+
+// Returns the last heartbeat and the agreements of the nodes of a pattern, or of the nodes without a pattern.
+func (s *ExchangeStub) nodeHealth(org string, pattern string, body []byte) stubResponse {
+	var req exchange.NodeHealthStatusRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return failed(http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+	}
+	if len(req.NodeOrgIds) == 0 {
+		req.NodeOrgIds = []string{org}
+	}
+
+	status := exchange.NodeHealthStatus{Nodes: make(map[string]exchange.NodeInfo)}
+	for _, nodeId := range s.nodeIds(req.NodeOrgIds) {
+		node := s.get(COLL_NODES, nodeId)
+		if p, _ := node["pattern"].(string); p != pattern {
+			continue
+		}
+		hb, _ := node["lastHeartbeat"].(string)
+		info := exchange.NodeInfo{LastHeartbeat: hb, Agreements: make(map[string]exchange.AgreementObject)}
+		for agId := range s.state.Agreements[COLL_NODES+"/"+nodeId] {
+			info.Agreements[agId] = exchange.AgreementObject{}
+		}
+		status.Nodes[nodeId] = info
+	}
+	return stubResponse{code: http.StatusCreated, body: status}
+}
+
+// Returns the ids of the nodes in the orgs, in order.
+func (s *ExchangeStub) nodeIds(orgs []string) []string {
+	inOrgs := make(map[string]bool)
+	for _, o := range orgs {
+		inOrgs[o] = true
+	}
+	ids := []string{}
+	for id := range s.state.Resources[COLL_NODES] {
+		if inOrgs[exchange.GetOrg(id)] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
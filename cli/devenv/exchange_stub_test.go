@@ -0,0 +1,211 @@
+// +build unit
+
+package devenv
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/version"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Sends a request to the stub as a caller, and decodes the json response into out when it is given.
+func call(t *testing.T, server *httptest.Server, caller string, method string, path string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	if s, isString := body.(string); isString {
+		reader = bytes.NewReader([]byte(s))
+	} else {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequest(method, server.URL+STUB_API_PREFIX+path, reader)
+	req.SetBasicAuth(caller, "pw")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v %v: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if out != nil {
+		if s, isString := out.(*string); isString {
+			*s = string(data)
+		} else if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("%v %v: unable to decode %v: %v", method, path, string(data), err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func Test_ExchangeStub_resources(t *testing.T) {
+
+	stub := NewExchangeStub()
+	stub.AddOrg("devorg")
+	stub.AddUser("devorg", "admin", "adminpw")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	var v string
+	if code := call(t, server, "devorg/admin", "GET", "admin/version", nil, &v); code != 200 || v != version.PREFERRED_EXCHANGE_VERSION {
+		t.Errorf("wrong version %v %v", code, v)
+	}
+
+	var orgs exchange.GetOrganizationResponse
+	if code := call(t, server, "devorg/admin", "GET", "orgs/devorg", nil, &orgs); code != 200 || orgs.Orgs["devorg"].Label != "devorg" {
+		t.Errorf("wrong org %v %v", code, orgs)
+	}
+
+	// A service is created by a POST to the collection, a second POST is refused.
+	svc := map[string]interface{}{"url": "gps", "version": "1.0.0", "arch": "amd64", "label": "gps"}
+	if code := call(t, server, "devorg/admin", "POST", "orgs/devorg/services", svc, nil); code != 201 {
+		t.Errorf("service not created: %v", code)
+	} else if code := call(t, server, "devorg/admin", "POST", "orgs/devorg/services", svc, nil); code != 403 {
+		t.Errorf("expected 403 for an existing service, got %v", code)
+	}
+	var services exchange.GetServicesResponse
+	if code := call(t, server, "devorg/admin", "GET", "orgs/devorg/services?url=gps&arch=amd64", nil, &services); code != 200 || len(services.Services) != 1 {
+		t.Errorf("wrong services %v %v", code, services)
+	} else if _, found := services.Services["devorg/gps_1.0.0_amd64"]; !found {
+		t.Errorf("wrong service id %v", services)
+	}
+	if code := call(t, server, "devorg/admin", "GET", "orgs/devorg/services?arch=arm64", nil, nil); code != 404 {
+		t.Errorf("expected 404 for no service, got %v", code)
+	}
+
+	// The keys are text, the deployment policies have a 2 segment collection.
+	if code := call(t, server, "devorg/admin", "PUT", "orgs/devorg/services/gps_1.0.0_amd64/keys/k.pem", "-----BEGIN-----", nil); code != 201 {
+		t.Errorf("key not added: %v", code)
+	}
+	var key string
+	if code := call(t, server, "devorg/admin", "GET", "orgs/devorg/services/gps_1.0.0_amd64/keys/k.pem", nil, &key); code != 200 || key != "-----BEGIN-----" {
+		t.Errorf("wrong key %v %v", code, key)
+	}
+	if code := call(t, server, "devorg/admin", "PUT", "orgs/devorg/mmskeys/m.pem", "-----MMS-----", nil); code != 201 {
+		t.Errorf("MMS key not added: %v", code)
+	}
+	var mmsKeys []string
+	if code := call(t, server, "devorg/admin", "GET", "orgs/devorg/mmskeys", nil, &mmsKeys); code != 200 || len(mmsKeys) != 1 || mmsKeys[0] != "m.pem" {
+		t.Errorf("wrong MMS keys %v %v", code, mmsKeys)
+	}
+	if code := call(t, server, "devorg/admin", "PUT", "orgs/devorg/business/policies/gps-pol", map[string]interface{}{"label": "gps"}, nil); code != 201 {
+		t.Errorf("policy not added: %v", code)
+	}
+	var pols exchange.GetBusinessPolicyResponse
+	if code := call(t, server, "devorg/admin", "GET", "orgs/devorg/business/policies/gps-pol", nil, &pols); code != 200 || len(pols.BusinessPolicy) != 1 {
+		t.Errorf("wrong policies %v %v", code, pols)
+	}
+
+	// The secrets are hidden, and the resources are removed with their sub-resources.
+	var users struct {
+		Users map[string]map[string]interface{} `json:"users"`
+	}
+	if call(t, server, "devorg/admin", "GET", "orgs/devorg/users/admin", nil, &users); users.Users["devorg/admin"]["password"] != "********" {
+		t.Errorf("the password is not hidden: %v", users)
+	}
+	if code := call(t, server, "devorg/admin", "DELETE", "orgs/devorg/services/gps_1.0.0_amd64", nil, nil); code != 204 {
+		t.Errorf("service not deleted: %v", code)
+	} else if code := call(t, server, "devorg/admin", "GET", "orgs/devorg/services/gps_1.0.0_amd64/keys/k.pem", nil, nil); code != 404 {
+		t.Errorf("expected 404 for the key of a deleted service, got %v", code)
+	}
+
+	// The state survives a save and a load.
+	data, err := stub.Save()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	loaded := NewExchangeStub()
+	if err := loaded.Load(data); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if loaded.get(COLL_POLICIES, "devorg/gps-pol") == nil || loaded.state.LastChangeId != stub.state.LastChangeId {
+		t.Errorf("the state was not restored: %v", loaded.state)
+	}
+}
+
+func Test_ExchangeStub_agreements(t *testing.T) {
+
+	stub := NewExchangeStub()
+	stub.AddOrg("devorg")
+	stub.AddAgbot("devorg", "devagbot", "token")
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	var maxId exchange.ExchangeChangeIDResponse
+	call(t, server, "devorg/devagbot", "GET", "changes/maxchangeid", nil, &maxId)
+
+	// A node registers and sets its public key, the agbot sets its own.
+	if code := call(t, server, "devorg/node1", "PUT", "orgs/devorg/nodes/node1", map[string]interface{}{"token": "t", "pattern": "", "nodeType": "device"}, nil); code != 201 {
+		t.Errorf("node not created: %v", code)
+	}
+	call(t, server, "devorg/node1", "PATCH", "orgs/devorg/nodes/node1", map[string]interface{}{"publicKey": []byte("nodekey")}, nil)
+	call(t, server, "devorg/devagbot", "PATCH", "orgs/devorg/agbots/devagbot", map[string]interface{}{"publicKey": []byte("agbotkey")}, nil)
+	call(t, server, "devorg/node2", "PUT", "orgs/devorg/nodes/node2", map[string]interface{}{"token": "t", "pattern": "devorg/p1", "publicKey": []byte("key2")}, nil)
+
+	// The agbot serves all the orgs, and finds the nodes of the policies and patterns.
+	var served exchange.GetAgbotsBusinessPolsResponse
+	if call(t, server, "devorg/devagbot", "GET", "orgs/devorg/agbots/devagbot/businesspols", nil, &served); served.BusinessPols["devorg_*_devorg"].NodeOrg != "devorg" {
+		t.Errorf("wrong served policies %v", served)
+	}
+	var polNodes exchange.SearchExchBusinessPolResponse
+	if code := call(t, server, "devorg/devagbot", "POST", "orgs/devorg/business/policies/pol/search", exchange.SearchExchBusinessPolRequest{NodeOrgIds: []string{"devorg"}}, &polNodes); code != 404 && code != 201 {
+		t.Errorf("unexpected code %v", code)
+	}
+	call(t, server, "devorg/admin", "PUT", "orgs/devorg/business/policies/pol", map[string]interface{}{"label": "pol"}, nil)
+	if code := call(t, server, "devorg/devagbot", "POST", "orgs/devorg/business/policies/pol/search", exchange.SearchExchBusinessPolRequest{NodeOrgIds: []string{"devorg"}}, &polNodes); code != 201 || len(polNodes.Devices) != 1 || polNodes.Devices[0].Id != "devorg/node1" {
+		t.Errorf("wrong policy search %v %v", code, polNodes)
+	}
+	call(t, server, "devorg/admin", "PUT", "orgs/devorg/patterns/p1", map[string]interface{}{"label": "p1"}, nil)
+	var patNodes exchange.SearchExchangePatternResponse
+	if code := call(t, server, "devorg/devagbot", "POST", "orgs/devorg/patterns/p1/search", exchange.SearchExchangePatternRequest{NodeOrgIds: []string{"devorg"}}, &patNodes); code != 201 || len(patNodes.Devices) != 1 || patNodes.Devices[0].Id != "devorg/node2" {
+		t.Errorf("wrong pattern search %v %v", code, patNodes)
+	}
+
+	// The agbot sends a proposal, the node gets it with the agbot key and deletes it.
+	if code := call(t, server, "devorg/devagbot", "POST", "orgs/devorg/nodes/node1/msgs", exchange.PostMessage{Message: []byte("proposal"), TTL: 60}, nil); code != 201 {
+		t.Errorf("message not sent: %v", code)
+	}
+	var msgs exchange.GetDeviceMessageResponse
+	if call(t, server, "devorg/node1", "GET", "orgs/devorg/nodes/node1/msgs", nil, &msgs); len(msgs.Messages) != 1 || string(msgs.Messages[0].AgbotPubKey) != "agbotkey" || string(msgs.Messages[0].Message) != "proposal" {
+		t.Fatalf("wrong messages %v", msgs)
+	}
+	if code := call(t, server, "devorg/node1", "DELETE", "orgs/devorg/nodes/node1/msgs/"+jsonString(msgs.Messages[0].MsgId), nil, nil); code != 204 {
+		t.Errorf("message not deleted: %v", code)
+	}
+
+	// The agreement of the node is in the node health.
+	call(t, server, "devorg/node1", "PUT", "orgs/devorg/nodes/node1/agreements/ag1", map[string]interface{}{"state": "negotiating"}, nil)
+	var health exchange.NodeHealthStatus
+	if code := call(t, server, "devorg/devagbot", "POST", "orgs/devorg/search/nodehealth", exchange.NodeHealthStatusRequest{NodeOrgIds: []string{"devorg"}}, &health); code != 201 {
+		t.Errorf("unexpected code %v", code)
+	} else if _, found := health.Nodes["devorg/node1"].Agreements["ag1"]; !found || len(health.Nodes) != 1 {
+		t.Errorf("wrong node health %v", health)
+	}
+
+	// The changes since the start are in order, and only for the org.
+	var changes exchange.ExchangeChanges
+	call(t, server, "devorg/devagbot", "POST", "orgs/devorg/changes", exchange.GetExchangeChangesRequest{ChangeId: maxId.MaxChangeID + 1, MaxRecords: 100}, &changes)
+	resources := map[string]bool{}
+	for _, c := range changes.Changes {
+		resources[c.Resource] = true
+	}
+	for _, r := range []string{exchange.RESOURCE_NODE, exchange.RESOURCE_AGBOT, exchange.RESOURCE_NODE_MSG, exchange.RESOURCE_NODE_AGREEMENTS, exchange.RESOURCE_AGBOT_POLICY, exchange.RESOURCE_AGBOT_PATTERN} {
+		if !resources[r] {
+			t.Errorf("no %v change in %v", r, changes)
+		}
+	}
+	if changes.MostRecentChangeID != stub.state.LastChangeId {
+		t.Errorf("wrong most recent change id %v, expected %v", changes.MostRecentChangeID, stub.state.LastChangeId)
+	}
+	var otherChanges exchange.ExchangeChanges
+	call(t, server, "otherorg/admin", "POST", "orgs/otherorg/changes", exchange.GetExchangeChangesRequest{ChangeId: maxId.MaxChangeID + 1}, &otherChanges)
+	if len(otherChanges.Changes) != 0 {
+		t.Errorf("unexpected changes for another org %v", otherChanges)
+	}
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
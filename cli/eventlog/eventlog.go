@@ -79,13 +79,13 @@ func List(all bool, detail bool, selections []string, tailing bool) {
 		}
 	}
 
+	first := true
 	for {
 		// get the eventlog from anax
 		apiOutput := make([]persistence.EventLogRaw, 0)
 		cliutils.HorizonGet(url_s, []int{200}, &apiOutput, false)
 
-		//output
-
+		// By default, the records are displayed without the brackets of the list so that the tailed records follow each other.
 		if detail {
 			long_output := make([]EventLog, len(apiOutput))
 			for i, v := range apiOutput {
@@ -98,11 +98,19 @@ func List(all bool, detail bool, selections []string, tailing bool) {
 				long_output[i].Source = v.Source
 			}
 
-			jsonBytes, err := cliutils.DisplayAsJson(long_output)
-			if err != nil {
+			if !cliutils.IsDefaultOutput() {
+				if len(apiOutput) > 0 || first {
+					cliutils.Output(long_output, "'hzn eventlog list'",
+						cliutils.NewOutputColumn("TIMESTAMP", ".timestamp"),
+						cliutils.NewOutputColumn("SEVERITY", ".severity"),
+						cliutils.NewWideOutputColumn("RECORD ID", ".record_id"),
+						cliutils.NewWideOutputColumn("EVENT CODE", ".event_code"),
+						cliutils.NewWideOutputColumn("SOURCE TYPE", ".source_type"),
+						cliutils.NewOutputColumn("MESSAGE", ".message"))
+				}
+			} else if jsonBytes, err := cliutils.DisplayAsJson(long_output); err != nil {
 				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn eventlog list' output: %v", err))
-			}
-			if len(jsonBytes) > 3 {
+			} else if len(jsonBytes) > 3 {
 				fmt.Printf("%s", jsonBytes[2:len(jsonBytes)-2])
 			}
 		} else {
@@ -111,15 +119,17 @@ func List(all bool, detail bool, selections []string, tailing bool) {
 				t := time.Unix(int64(v.Timestamp), 0)
 				short_output[i] = fmt.Sprintf("%v:   %v", t.Format("2006-01-02 15:04:05"), v.Message)
 			}
-			jsonBytes, err := cliutils.DisplayAsJson(short_output)
-			if err != nil {
+			if !cliutils.IsDefaultOutput() {
+				if len(apiOutput) > 0 || first {
+					cliutils.Output(short_output, "'hzn eventlog list'")
+				}
+			} else if jsonBytes, err := cliutils.DisplayAsJson(short_output); err != nil {
 				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn eventlog list' output: %v", err))
-			}
-
-			if len(jsonBytes) > 3 {
+			} else if len(jsonBytes) > 3 {
 				fmt.Printf("%s", jsonBytes[2:len(jsonBytes)-2])
 			}
 		}
+		first = false
 
 		if tailing {
 			// selection contraints for most recent records
@@ -171,19 +181,11 @@ func ListSurfaced(long bool) {
 			long_output[i].SourceType = fullV.SourceType
 			long_output[i].Source = fullV.Source
 		}
-		jsonBytes, err := cliutils.DisplayAsJson(long_output)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn eventlog surface' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(long_output, "'hzn eventlog surface'")
 	} else {
 		if len(apiOutput) == 0 {
 			apiOutput = []persistence.SurfaceError{}
 		}
-		jsonBytes, err := cliutils.DisplayAsJson(apiOutput)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn eventlog surface' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(apiOutput, "'hzn eventlog surface'")
 	}
 }
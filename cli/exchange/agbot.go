@@ -1,8 +1,6 @@
 package exchange
 
 import (
-	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/i18n"
@@ -31,11 +29,7 @@ func AgbotList(org string, userPw string, agbot string, namesOnly bool) {
 		for a := range resp.Agbots {
 			agbots = append(agbots, a)
 		}
-		jsonBytes, err := json.MarshalIndent(agbots, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'exchange agbot list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(agbots, "'hzn exchange agbot list'")
 	} else {
 		// Display the full resources
 		var agbots ExchangeAgbots
@@ -43,8 +37,11 @@ func AgbotList(org string, userPw string, agbot string, namesOnly bool) {
 		if httpCode == 404 && agbot != "" {
 			cliutils.Fatal(cliutils.NOT_FOUND, i18n.GetMessagePrinter().Sprintf("agbot '%s' not found in org %s", agbot, agbotOrg))
 		}
-		output := cliutils.MarshalIndent(agbots.Agbots, "exchange agbots list")
-		fmt.Println(output)
+		cliutils.Output(agbots.Agbots, "'hzn exchange agbot list'",
+			cliutils.NewOutputColumn("NAME", ".name"),
+			cliutils.NewOutputColumn("OWNER", ".owner"),
+			cliutils.NewOutputColumn("LAST HEARTBEAT", ".lastHeartbeat"),
+			cliutils.NewWideOutputColumn("MSG ENDPOINT", ".msgEndPoint"))
 	}
 }
 
@@ -76,8 +73,7 @@ func AgbotListPatterns(org, userPw, agbot, patternOrg, pattern, nodeOrg string)
 	if httpCode == 404 && patternOrg != "" && pattern != "" {
 		cliutils.Fatal(cliutils.NOT_FOUND, i18n.GetMessagePrinter().Sprintf("pattern '%s' with org '%s' and node org '%s' not found in agbot '%s'", pattern, patternOrg, nodeOrg, agbot))
 	}
-	output := cliutils.MarshalIndent(patterns.Patterns, "exchange agbot listpattern")
-	fmt.Println(output)
+	cliutils.Output(patterns.Patterns, "'hzn exchange agbot listpattern'")
 }
 
 type ServedPattern struct {
@@ -127,8 +123,7 @@ func AgbotListBusinessPolicy(org, userPw, agbot string) {
 	// Display the full resources
 	resp := new(exchange.GetAgbotsBusinessPolsResponse)
 	cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+agbotOrg+"/agbots/"+agbot+"/businesspols", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, resp)
-	output := cliutils.MarshalIndent(resp.BusinessPols, "exchange agbot listbusinesspol")
-	fmt.Println(output)
+	cliutils.Output(resp.BusinessPols, "'hzn exchange agbot listbusinesspol'")
 }
 
 // Add the business policy to the agot supporting list. Currently
@@ -1,7 +1,6 @@
 package exchange
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/businesspolicy"
@@ -34,28 +33,22 @@ func BusinessListPolicy(org string, credToUse string, policy string, namesOnly b
 	if httpCode == 404 && policy != "" {
 		cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("Policy %s not found in org %s", policy, polOrg))
 	} else if httpCode == 404 {
-		policyNameList := []string{}
-		fmt.Println(policyNameList)
+		cliutils.Output([]string{}, "'hzn exchange deployment listpolicy'")
 	} else if namesOnly && policy == "" {
 		policyNameList := []string{}
 		for bPolicy := range policyList.BusinessPolicy {
 			policyNameList = append(policyNameList, bPolicy)
 		}
-		jsonBytes, err := json.MarshalIndent(policyNameList, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange deployment listpolicy' output: %v", err))
-		}
-		fmt.Println(string(jsonBytes))
+		cliutils.Output(policyNameList, "'hzn exchange deployment listpolicy'")
 	} else {
-		buf := new(bytes.Buffer)
-		enc := json.NewEncoder(buf)
-		enc.SetEscapeHTML(false)
-		enc.SetIndent("", cliutils.JSON_INDENT)
-		err := enc.Encode(policyList.BusinessPolicy)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange deployment listpolicy' output: %v", err))
-		}
-		fmt.Println(string(buf.String()))
+		cliutils.Output(policyList.BusinessPolicy, "'hzn exchange deployment listpolicy'",
+			cliutils.NewOutputColumn("LABEL", ".label"),
+			cliutils.NewOutputColumn("SERVICE", ".service.name"),
+			cliutils.NewWideOutputColumn("SERVICE ORG", ".service.org"),
+			cliutils.NewOutputColumn("ARCH", ".service.arch"),
+			cliutils.NewOutputColumn("VERSIONS", ".service.serviceVersions[].version"),
+			cliutils.NewWideOutputColumn("OWNER", ".owner"),
+			cliutils.NewWideOutputColumn("LAST UPDATED", ".lastUpdated"))
 	}
 }
 
@@ -64,11 +64,7 @@ func NodeList(org string, credToUse string, node string, namesOnly bool) {
 		for n := range resp.Nodes {
 			nodes = append(nodes, n)
 		}
-		jsonBytes, err := json.MarshalIndent(nodes, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'exchange node list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(nodes, "'hzn exchange node list'")
 	} else {
 		// Display the full resources
 		var nodes ExchangeNodes
@@ -76,8 +72,15 @@ func NodeList(org string, credToUse string, node string, namesOnly bool) {
 		if httpCode == 404 && node != "" {
 			cliutils.Fatal(cliutils.NOT_FOUND, i18n.GetMessagePrinter().Sprintf("node '%s' not found in org %s", node, nodeOrg))
 		}
-		output := cliutils.MarshalIndent(nodes.Nodes, "exchange node list")
-		fmt.Println(output)
+		cliutils.Output(nodes.Nodes, "'hzn exchange node list'",
+			cliutils.NewOutputColumn("NAME", ".name"),
+			cliutils.NewOutputColumn("TYPE", ".nodeType"),
+			cliutils.NewOutputColumn("ARCH", ".arch"),
+			cliutils.NewOutputColumn("PATTERN", ".pattern"),
+			cliutils.NewOutputColumn("LAST HEARTBEAT", ".lastHeartbeat"),
+			cliutils.NewWideOutputColumn("OWNER", ".owner"),
+			cliutils.NewWideOutputColumn("AGENT VERSION", ".softwareVersions.horizon"),
+			cliutils.NewWideOutputColumn("SERVICES", ".registeredServices[].url"))
 	}
 }
 
@@ -400,12 +403,7 @@ func NodeListPolicy(org string, credToUse string, node string) {
 	cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+nodeOrg+"/nodes"+cliutils.AddSlash(node)+"/policy", cliutils.OrgAndCreds(org, credToUse), []int{200, 404}, &policy)
 
 	// display
-	output, err := cliutils.DisplayAsJson(policy)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal node policy output: %v", err))
-	}
-
-	fmt.Println(output)
+	cliutils.Output(policy, "'hzn exchange node listpolicy'")
 }
 
 func NodeAddPolicy(org string, credToUse string, node string, jsonFilePath string) {
@@ -573,11 +571,7 @@ func NodeListErrors(org string, credToUse string, node string, long bool) {
 	}
 
 	if !long {
-		jsonBytes, err := json.MarshalIndent(errorList, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange node listerrors' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(errorList, "'hzn exchange node listerrors'")
 	} else {
 		long_output := make([]EventLog, len(errorList))
 		for i, v := range errorList {
@@ -595,11 +589,7 @@ func NodeListErrors(org string, credToUse string, node string, long bool) {
 			long_output[i].SourceType = fullV.SourceType
 			long_output[i].Source = fullV.Source
 		}
-		jsonBytes, err := json.MarshalIndent(long_output, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn exchange node listerrors' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(long_output, "'hzn exchange node listerrors'")
 	}
 }
 
@@ -617,8 +607,7 @@ func NodeListStatus(org string, credToUse string, node string) {
 		cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("node status not found for node '%v/%v'.", nodeOrg, node))
 	}
 
-	output := cliutils.MarshalIndent(nodeStatus, "exchange node liststatus")
-	fmt.Println(output)
+	cliutils.Output(nodeStatus, "'hzn exchange node liststatus'")
 
 }
 
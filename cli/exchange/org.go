@@ -1,13 +1,14 @@
 package exchange
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/edge-sync-service/common"
+	"github.com/open-horizon/rsapss-tool/verify"
 	"net/http"
+	"path/filepath"
 	"strings"
 )
 
@@ -52,14 +53,12 @@ func OrgList(org, userPwCreds, theOrg string, long bool) {
 			organizations = append(organizations, o)
 		}
 
-		jsonBytes, err := json.MarshalIndent(organizations, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'exchange org list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(organizations, "'hzn exchange org list'")
 	} else {
-		output := cliutils.MarshalIndent(orgs.Orgs, "exchange orgs list")
-		fmt.Println(output)
+		cliutils.Output(orgs.Orgs, "'hzn exchange org list'",
+			cliutils.NewOutputColumn("LABEL", ".label"),
+			cliutils.NewOutputColumn("DESCRIPTION", ".description"),
+			cliutils.NewWideOutputColumn("LAST UPDATED", ".lastUpdated"))
 	}
 }
 
@@ -401,3 +400,62 @@ func AddOrgToAgbotServingList(org, userPw, theOrg, agbot string) {
 	inputPol := exchange.ServedBusinessPolicy{BusinessPolOrg: theOrg, BusinessPol: "*", NodeOrg: theOrg}
 	cliutils.ExchangePutPost("Exchange", http.MethodPost, cliutils.GetExchangeUrl(), "orgs/"+agbotOrg+"/agbots/"+agbot+"/businesspols", cliutils.OrgAndCreds(org, userPw), []int{201, 409}, inputPol, nil)
 }
+
+// Add a public key or x509 certificate to the keys that the org trusts for signing the model management (MMS) objects.
+// The key is stored under the base name of the file.
+func OrgAddMMSKey(org, userPw, pubKeyFile string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	bodyBytes := cliutils.ReadFile(pubKeyFile)
+	if _, err := verify.ValidKeyOrCert(bodyBytes); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("provided public key is not valid; error: %v", err))
+	}
+
+	baseName := filepath.Base(pubKeyFile)
+	cliutils.ExchangePutPost("Exchange", http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/mmskeys/"+baseName, cliutils.OrgAndCreds(org, userPw), []int{201}, bodyBytes, nil)
+
+	msgPrinter.Printf("Key %v added to the MMS signing keys of org %v.", baseName, org)
+	msgPrinter.Println()
+}
+
+// List the names of the MMS signing keys of the org, or display the content of one of them.
+func OrgListMMSKey(org, userPw, keyName string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if keyName == "" {
+		// Only display the names
+		var output string
+		httpCode := cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+org+"/mmskeys", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &output)
+		if httpCode == 404 {
+			output = "[]"
+		}
+		cliutils.Output(output, "'hzn exchange org listmmskey'")
+	} else {
+		// Display the content of the key
+		var output []byte
+		httpCode := cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+org+"/mmskeys/"+keyName, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &output)
+		if httpCode == 404 {
+			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("key '%s' not found", keyName))
+		}
+		fmt.Printf("%s", string(output))
+	}
+}
+
+// Remove a key from the MMS signing keys of the org.
+func OrgRemoveMMSKey(org, userPw, keyName string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	httpCode := cliutils.ExchangeDelete("Exchange", cliutils.GetExchangeUrl(), "orgs/"+org+"/mmskeys/"+keyName, cliutils.OrgAndCreds(org, userPw), []int{204, 404})
+	if httpCode == 404 {
+		cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("key '%s' not found", keyName))
+	}
+
+	msgPrinter.Printf("Key %v removed from the MMS signing keys of org %v.", keyName, org)
+	msgPrinter.Println()
+}
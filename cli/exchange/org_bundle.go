@@ -0,0 +1,524 @@
+package exchange
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/policy"
+	"github.com/open-horizon/rsapss-tool/sign"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The version of the org bundle format written by OrgExport. OrgImport refuses bundles with a newer version.
+const ORG_BUNDLE_VERSION = 1
+
+const (
+	ORG_BUNDLE_HEADER       = "bundle.json"
+	ORG_BUNDLE_SERVICES     = "services"
+	ORG_BUNDLE_PATTERNS     = "patterns"
+	ORG_BUNDLE_DEP_POLICIES = "deployment_policies"
+)
+
+// The header of an org bundle.
+type OrgBundleHeader struct {
+	Version     int    `json:"version"`
+	Org         string `json:"org"`
+	ExchangeUrl string `json:"exchangeUrl"`
+	Created     string `json:"created"`
+}
+
+// A service in an org bundle, with everything that is stored with it in the exchange.
+type OrgBundleService struct {
+	Service     exchange.ServiceDefinition     `json:"service"`
+	Policy      *externalpolicy.ExternalPolicy `json:"policy,omitempty"`
+	DockerAuths []exchange.ImageDockerAuth     `json:"dockerAuths,omitempty"`
+	Keys        map[string]string              `json:"keys,omitempty"`
+}
+
+// A pattern in an org bundle, with its signing keys.
+type OrgBundlePattern struct {
+	Pattern PatternOutput     `json:"pattern"`
+	Keys    map[string]string `json:"keys,omitempty"`
+}
+
+// The content of an org bundle, keyed by the exchange id of each resource without the org.
+type OrgBundle struct {
+	Header             OrgBundleHeader
+	Services           map[string]*OrgBundleService
+	Patterns           map[string]*OrgBundlePattern
+	DeploymentPolicies map[string]*businesspolicy.BusinessPolicy
+}
+
+// OrgExport writes the services, service policies, patterns, deployment policies, signing keys and docker auths of
+// the org to a versioned tarball.
+func OrgExport(org, userPw, theOrg, filePath string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if theOrg == "" {
+		theOrg = org
+	}
+	if theOrg == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("specify the organization to export, or use -o"))
+	}
+	exchUrl := cliutils.GetExchangeUrl()
+	creds := cliutils.OrgAndCreds(org, userPw)
+
+	bundle := OrgBundle{
+		Header:             OrgBundleHeader{Version: ORG_BUNDLE_VERSION, Org: theOrg, ExchangeUrl: exchUrl, Created: time.Now().UTC().Format(time.RFC3339)},
+		Services:           make(map[string]*OrgBundleService),
+		Patterns:           make(map[string]*OrgBundlePattern),
+		DeploymentPolicies: make(map[string]*businesspolicy.BusinessPolicy),
+	}
+
+	var services exchange.GetServicesResponse
+	cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+theOrg+"/services", creds, []int{200, 404}, &services)
+	for fullId, svc := range services.Services {
+		id := strings.TrimPrefix(fullId, theOrg+"/")
+		bs := &OrgBundleService{Service: svc, Keys: getResourceKeys(exchUrl, "orgs/"+theOrg+"/services/"+id, creds)}
+		bs.Service.Owner = ""
+		bs.Service.LastUpdated = ""
+
+		var pol exchange.ExchangePolicy
+		if httpCode := cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+theOrg+"/services/"+id+"/policy", creds, []int{200, 404}, &pol); httpCode == 200 {
+			bs.Policy = &pol.ExternalPolicy
+		}
+		cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+theOrg+"/services/"+id+"/dockauths", creds, []int{200, 404}, &bs.DockerAuths)
+
+		bundle.Services[id] = bs
+	}
+
+	var patterns ExchangePatterns
+	cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+theOrg+"/patterns", creds, []int{200, 404}, &patterns)
+	for fullId, pat := range patterns.Patterns {
+		id := strings.TrimPrefix(fullId, theOrg+"/")
+		pat.Owner = ""
+		pat.LastUpdated = ""
+		bundle.Patterns[id] = &OrgBundlePattern{Pattern: pat, Keys: getResourceKeys(exchUrl, "orgs/"+theOrg+"/patterns/"+id, creds)}
+	}
+
+	var policies exchange.GetBusinessPolicyResponse
+	cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+theOrg+"/business/policies", creds, []int{200, 404}, &policies)
+	for fullId, pol := range policies.BusinessPolicy {
+		bp := pol.BusinessPolicy
+		bp.Owner = ""
+		bundle.DeploymentPolicies[strings.TrimPrefix(fullId, theOrg+"/")] = &bp
+	}
+
+	if err := WriteOrgBundle(&bundle, filePath); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to write org bundle %v: %v", filePath, err))
+	}
+
+	msgPrinter.Printf("Exported %v services, %v patterns and %v deployment policies of organization %v to %v.", len(bundle.Services), len(bundle.Patterns), len(bundle.DeploymentPolicies), theOrg, filePath)
+	msgPrinter.Println()
+	for _, bs := range bundle.Services {
+		if len(bs.DockerAuths) != 0 {
+			cliutils.Warning(msgPrinter.Sprintf("the bundle contains the docker registry tokens of the services, keep it in a safe place."))
+			break
+		}
+	}
+}
+
+// Returns the content of the public keys stored with a service or a pattern, by key name.
+func getResourceKeys(exchUrl, resourcePath, creds string) map[string]string {
+	var names []string
+	if httpCode := cliutils.ExchangeGet("Exchange", exchUrl, resourcePath+"/keys", creds, []int{200, 404}, &names); httpCode != 200 || len(names) == 0 {
+		return nil
+	}
+	keys := make(map[string]string)
+	for _, name := range names {
+		var content []byte
+		if httpCode := cliutils.ExchangeGet("Exchange", exchUrl, resourcePath+"/keys/"+name, creds, []int{200, 404}, &content); httpCode == 200 {
+			keys[name] = string(content)
+		}
+	}
+	return keys
+}
+
+// WriteOrgBundle writes the bundle as a gzipped tarball with one json file per resource.
+func WriteOrgBundle(bundle *OrgBundle, filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gzw)
+
+	writeEntry := func(name string, content interface{}) error {
+		data, err := json.MarshalIndent(content, "", cliutils.JSON_INDENT)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %v: %v", name, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	if err := writeEntry(ORG_BUNDLE_HEADER, bundle.Header); err != nil {
+		return err
+	}
+	for id, bs := range bundle.Services {
+		if err := writeEntry(path.Join(ORG_BUNDLE_SERVICES, id+".json"), bs); err != nil {
+			return err
+		}
+	}
+	for id, bp := range bundle.Patterns {
+		if err := writeEntry(path.Join(ORG_BUNDLE_PATTERNS, id+".json"), bp); err != nil {
+			return err
+		}
+	}
+	for id, pol := range bundle.DeploymentPolicies {
+		if err := writeEntry(path.Join(ORG_BUNDLE_DEP_POLICIES, id+".json"), pol); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+// ReadOrgBundle reads a bundle written by WriteOrgBundle. Bundles written by a newer version of the format are rejected.
+func ReadOrgBundle(filePath string) (*OrgBundle, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%v is not a gzipped tarball: %v", filePath, err)
+	}
+	tr := tar.NewReader(gzr)
+
+	bundle := &OrgBundle{
+		Services:           make(map[string]*OrgBundleService),
+		Patterns:           make(map[string]*OrgBundlePattern),
+		DeploymentPolicies: make(map[string]*businesspolicy.BusinessPolicy),
+	}
+	foundHeader := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		} else if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		dir, name := path.Split(hdr.Name)
+		id := strings.TrimSuffix(name, ".json")
+
+		var target interface{}
+		switch strings.TrimSuffix(dir, "/") {
+		case "":
+			if name != ORG_BUNDLE_HEADER {
+				continue
+			}
+			foundHeader = true
+			target = &bundle.Header
+		case ORG_BUNDLE_SERVICES:
+			bs := new(OrgBundleService)
+			bundle.Services[id] = bs
+			target = bs
+		case ORG_BUNDLE_PATTERNS:
+			bp := new(OrgBundlePattern)
+			bundle.Patterns[id] = bp
+			target = bp
+		case ORG_BUNDLE_DEP_POLICIES:
+			pol := new(businesspolicy.BusinessPolicy)
+			bundle.DeploymentPolicies[id] = pol
+			target = pol
+		default:
+			continue
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %v: %v", hdr.Name, err)
+		}
+	}
+
+	if !foundHeader {
+		return nil, fmt.Errorf("%v is not an org bundle, %v is missing", filePath, ORG_BUNDLE_HEADER)
+	} else if bundle.Header.Version > ORG_BUNDLE_VERSION {
+		return nil, fmt.Errorf("the bundle version %v is newer than the supported version %v", bundle.Header.Version, ORG_BUNDLE_VERSION)
+	}
+	return bundle, nil
+}
+
+// Rename changes the org of the bundle, and every reference to a service of the old org, to the new org. This includes
+// the required services, the service org property of service policies, the services of patterns and deployment policies,
+// and the services that their user input is for. References to the services of other orgs are not changed.
+func (b *OrgBundle) Rename(newOrg string) {
+	oldOrg := b.Header.Org
+	if oldOrg == newOrg {
+		return
+	}
+	b.Header.Org = newOrg
+
+	for _, bs := range b.Services {
+		for i := range bs.Service.RequiredServices {
+			if bs.Service.RequiredServices[i].Org == oldOrg {
+				bs.Service.RequiredServices[i].Org = newOrg
+			}
+		}
+		if bs.Policy != nil {
+			for i, prop := range bs.Policy.Properties {
+				if prop.Name == externalpolicy.PROP_SVC_ORG && prop.Value == oldOrg {
+					bs.Policy.Properties[i].Value = newOrg
+				}
+			}
+		}
+	}
+	for _, bp := range b.Patterns {
+		for i := range bp.Pattern.Services {
+			if bp.Pattern.Services[i].ServiceOrg == oldOrg {
+				bp.Pattern.Services[i].ServiceOrg = newOrg
+			}
+		}
+		renameUserInput(bp.Pattern.UserInput, oldOrg, newOrg)
+	}
+	for _, pol := range b.DeploymentPolicies {
+		if pol.Service.Org == oldOrg {
+			pol.Service.Org = newOrg
+		}
+		renameUserInput(pol.UserInput, oldOrg, newOrg)
+	}
+}
+
+func renameUserInput(userInput []policy.UserInput, oldOrg, newOrg string) {
+	for i := range userInput {
+		if userInput[i].ServiceOrgid == oldOrg {
+			userInput[i].ServiceOrgid = newOrg
+		}
+	}
+}
+
+// SortedServiceIds returns the ids of the services in the bundle in an order in which every service comes after the
+// services of the bundle it requires, so that the exchange finds them when it is created.
+func (b *OrgBundle) SortedServiceIds() ([]string, error) {
+	ids := make([]string, 0, len(b.Services))
+	for id := range b.Services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	items := make([]exchange.DependencyOrderItem, 0, len(ids))
+	for _, id := range ids {
+		svc := b.Services[id].Service
+		items = append(items, exchange.DependencyOrderItem{Id: id, Org: b.Header.Org, URL: svc.URL, Arch: svc.Arch, Requires: svc.RequiredServices})
+	}
+	return exchange.SortByDependency(items)
+}
+
+// Resign signs the deployments of the services and the deployment overrides of the patterns with the given key, and
+// replaces the exported public keys with the matching public key.
+func (b *OrgBundle) Resign(keyFilePath, pubKeyFilePath string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	keyFilePath, pubKeyFilePath = cliutils.GetSigningKeys(keyFilePath, pubKeyFilePath)
+	pubKeys := map[string]string{filepath.Base(pubKeyFilePath): string(cliutils.ReadFile(pubKeyFilePath))}
+
+	for id, bs := range b.Services {
+		svc := &bs.Service
+		if svc.Deployment != "" {
+			// The images were pushed when the service was first published, so they are left as they are.
+			var dep map[string]interface{}
+			if err := json.Unmarshal([]byte(svc.Deployment), &dep); err != nil {
+				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to unmarshal the deployment of service %v: %v", id, err))
+			}
+			svc.Deployment, svc.DeploymentSignature, _ = SignDeployment(dep, "", "", false, keyFilePath, pubKeyFilePath, true, false)
+		}
+		if svc.ClusterDeployment != "" {
+			// The operator archive is already embedded in the exported cluster deployment, so the deployment is signed as it is.
+			sig, err := sign.Input(keyFilePath, []byte(svc.ClusterDeployment))
+			if err != nil {
+				cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("problem signing the cluster deployment of service %v with %s: %v", id, keyFilePath, err))
+			}
+			svc.ClusterDeploymentSignature = sig
+		}
+		if svc.Deployment != "" || svc.ClusterDeployment != "" {
+			bs.Keys = pubKeys
+		}
+	}
+
+	for id, bp := range b.Patterns {
+		signed := false
+		for i := range bp.Pattern.Services {
+			for j := range bp.Pattern.Services[i].ServiceVersions {
+				sv := &bp.Pattern.Services[i].ServiceVersions[j]
+				if sv.DeploymentOverrides == "" {
+					continue
+				}
+				sig, err := sign.Input(keyFilePath, []byte(sv.DeploymentOverrides))
+				if err != nil {
+					cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("problem signing the deployment_overrides of pattern %v with %s: %v", id, keyFilePath, err))
+				}
+				sv.DeploymentOverridesSignature = sig
+				signed = true
+			}
+		}
+		if signed {
+			bp.Keys = pubKeys
+		}
+	}
+}
+
+// OrgImport creates the resources of an org bundle in the org. The bundle is renamed to the org when it was exported
+// from a different one. A resource that already exists is a conflict, it is left as it is and reported, unless
+// overwrite is set. When a signing key is given, the deployments are signed again with it.
+func OrgImport(org, userPw, filePath string, overwrite bool, keyFilePath, pubKeyFilePath string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	if org == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("specify the organization to import into with -o"))
+	}
+	exchUrl := cliutils.GetExchangeUrl()
+	creds := cliutils.OrgAndCreds(org, userPw)
+
+	bundle, err := ReadOrgBundle(filePath)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("failed to read org bundle %v: %v", filePath, err))
+	}
+	if bundle.Header.Org != org {
+		msgPrinter.Printf("Importing the resources of organization %v into organization %v.", bundle.Header.Org, org)
+		msgPrinter.Println()
+		bundle.Rename(org)
+	}
+	if keyFilePath != "" || pubKeyFilePath != "" {
+		bundle.Resign(keyFilePath, pubKeyFilePath)
+	}
+	serviceIds, err := bundle.SortedServiceIds()
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("unable to import the services: %v", err))
+	}
+
+	created, overwritten, conflicts := []string{}, []string{}, []string{}
+
+	// Creates or overwrites one resource, and returns whether it was written.
+	write := func(kind, id, resourcePath, listPath string, body interface{}) bool {
+		name := kind + " " + org + "/" + id
+		var output string
+		if httpCode := cliutils.ExchangeGet("Exchange", exchUrl, resourcePath, creds, []int{200, 404}, &output); httpCode == 200 {
+			if !overwrite {
+				conflicts = append(conflicts, name)
+				return false
+			}
+			msgPrinter.Printf("Updating %s in the Exchange...", name)
+			msgPrinter.Println()
+			cliutils.ExchangePutPost("Exchange", http.MethodPut, exchUrl, resourcePath, creds, []int{201}, body, nil)
+			overwritten = append(overwritten, name)
+		} else {
+			msgPrinter.Printf("Creating %s in the Exchange...", name)
+			msgPrinter.Println()
+			cliutils.ExchangePutPost("Exchange", http.MethodPost, exchUrl, listPath, creds, []int{201}, body, nil)
+			created = append(created, name)
+		}
+		return true
+	}
+	writeKeys := func(resourcePath string, keys map[string]string) {
+		for name, content := range keys {
+			cliutils.ExchangePutPost("Exchange", http.MethodPut, exchUrl, resourcePath+"/keys/"+name, creds, []int{201}, []byte(content), nil)
+		}
+	}
+
+	for _, id := range serviceIds {
+		bs := bundle.Services[id]
+		// The id is formed again in case the version or arch was changed in the bundle.
+		id = cutil.FormExchangeIdForService(bs.Service.URL, bs.Service.Version, bs.Service.Arch)
+		svcPath := "orgs/" + org + "/services/" + id
+		if !write("service", id, svcPath, "orgs/"+org+"/services", bs.Service) {
+			continue
+		}
+		writeKeys(svcPath, bs.Keys)
+		if bs.Policy != nil {
+			cliutils.ExchangePutPost("Exchange", http.MethodPut, exchUrl, svcPath+"/policy", creds, []int{201}, bs.Policy, nil)
+		}
+
+		// Docker auths that are already stored with an overwritten service are not added again.
+		var existing []exchange.ImageDockerAuth
+		cliutils.ExchangeGet("Exchange", exchUrl, svcPath+"/dockauths", creds, []int{200, 404}, &existing)
+		for _, auth := range bs.DockerAuths {
+			found := false
+			for _, ea := range existing {
+				if ea.Registry == auth.Registry && ea.UserName == auth.UserName {
+					found = true
+					break
+				}
+			}
+			if !found {
+				cliutils.ExchangePutPost("Exchange", http.MethodPost, exchUrl, svcPath+"/dockauths", creds, []int{201}, ServiceDockAuthExch{Registry: auth.Registry, UserName: auth.UserName, Token: auth.Token}, nil)
+			}
+		}
+	}
+
+	for _, id := range sortedBundleIds(bundle.Patterns) {
+		bp := bundle.Patterns[id]
+		pat := bp.Pattern
+		patInput := PatternInput{Label: pat.Label, Description: pat.Description, Public: pat.Public, Services: pat.Services, AgreementProtocols: pat.AgreementProtocols, UserInput: pat.UserInput}
+		patPath := "orgs/" + org + "/patterns/" + id
+		if write("pattern", id, patPath, patPath, patInput) {
+			writeKeys(patPath, bp.Keys)
+		}
+	}
+
+	for _, id := range sortedBundleIds(bundle.DeploymentPolicies) {
+		polPath := "orgs/" + org + "/business/policies/" + id
+		write("deployment policy", id, polPath, polPath, bundle.DeploymentPolicies[id])
+	}
+
+	msgPrinter.Printf("Created %v and overwrote %v resources in organization %v.", len(created), len(overwritten), org)
+	msgPrinter.Println()
+	if len(conflicts) != 0 {
+		msgPrinter.Printf("The following resources already exist and were not imported, use --overwrite to replace them:")
+		msgPrinter.Println()
+		for _, name := range conflicts {
+			fmt.Printf("  %v\n", name)
+		}
+	}
+}
+
+func sortedBundleIds(m interface{}) []string {
+	ids := []string{}
+	switch resources := m.(type) {
+	case map[string]*OrgBundlePattern:
+		for id := range resources {
+			ids = append(ids, id)
+		}
+	case map[string]*businesspolicy.BusinessPolicy:
+		for id := range resources {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,152 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/open-horizon/anax/businesspolicy"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/policy"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testOrgBundle() *OrgBundle {
+	return &OrgBundle{
+		Header: OrgBundleHeader{Version: ORG_BUNDLE_VERSION, Org: "org1", ExchangeUrl: "https://exchange/v1/", Created: "2026-10-19T10:00:00Z"},
+		Services: map[string]*OrgBundleService{
+			"svc1_1.0.0_amd64": {
+				Service: exchange.ServiceDefinition{
+					URL:     "svc1",
+					Version: "1.0.0",
+					Arch:    "amd64",
+					RequiredServices: []exchange.ServiceDependency{
+						{URL: "svc2", Org: "org1", VersionRange: "1.0.0", Arch: "amd64"},
+						{URL: "gps", Org: "IBM", VersionRange: "2.0.0", Arch: "amd64"},
+					},
+				},
+				Policy: &externalpolicy.ExternalPolicy{
+					Properties: externalpolicy.PropertyList{*externalpolicy.Property_Factory(externalpolicy.PROP_SVC_ORG, "org1")},
+				},
+				Keys: map[string]string{"key.pem": "public key"},
+			},
+		},
+		Patterns: map[string]*OrgBundlePattern{
+			"pat1": {
+				Pattern: PatternOutput{
+					Label: "pat1",
+					Services: []ServiceReference{
+						{ServiceURL: "svc1", ServiceOrg: "org1", ServiceArch: "amd64"},
+						{ServiceURL: "gps", ServiceOrg: "IBM", ServiceArch: "amd64"},
+					},
+					UserInput: []policy.UserInput{
+						{ServiceOrgid: "org1", ServiceUrl: "svc1", Inputs: []policy.Input{{Name: "VAR1", Value: "a"}}},
+						{ServiceOrgid: "IBM", ServiceUrl: "gps", Inputs: []policy.Input{{Name: "VAR2", Value: "b"}}},
+					},
+				},
+			},
+		},
+		DeploymentPolicies: map[string]*businesspolicy.BusinessPolicy{
+			"bp1": {
+				Label:   "bp1",
+				Service: businesspolicy.ServiceRef{Name: "svc1", Org: "org1", Arch: "amd64"},
+				UserInput: []policy.UserInput{
+					{ServiceOrgid: "org1", ServiceUrl: "svc1", Inputs: []policy.Input{{Name: "VAR1", Value: "c"}}},
+					{ServiceOrgid: "IBM", ServiceUrl: "gps", Inputs: []policy.Input{{Name: "VAR2", Value: "d"}}},
+				},
+			},
+		},
+	}
+}
+
+func Test_OrgBundle_Rename(t *testing.T) {
+
+	bundle := testOrgBundle()
+	bundle.Rename("org2")
+
+	svc := bundle.Services["svc1_1.0.0_amd64"]
+	pat := bundle.Patterns["pat1"].Pattern
+	pol := bundle.DeploymentPolicies["bp1"]
+
+	if bundle.Header.Org != "org2" {
+		t.Errorf("wrong bundle org %v", bundle.Header.Org)
+	} else if svc.Service.RequiredServices[0].Org != "org2" || svc.Service.RequiredServices[1].Org != "IBM" {
+		t.Errorf("wrong required services %v", svc.Service.RequiredServices)
+	} else if svc.Policy.Properties[0].Value != "org2" {
+		t.Errorf("wrong service policy properties %v", svc.Policy.Properties)
+	} else if pat.Services[0].ServiceOrg != "org2" || pat.Services[1].ServiceOrg != "IBM" {
+		t.Errorf("wrong pattern services %v", pat.Services)
+	} else if pat.UserInput[0].ServiceOrgid != "org2" || pat.UserInput[1].ServiceOrgid != "IBM" {
+		t.Errorf("wrong pattern user input %v", pat.UserInput)
+	} else if pol.Service.Org != "org2" {
+		t.Errorf("wrong deployment policy service %v", pol.Service)
+	} else if pol.UserInput[0].ServiceOrgid != "org2" || pol.UserInput[1].ServiceOrgid != "IBM" {
+		t.Errorf("wrong deployment policy user input %v", pol.UserInput)
+	}
+
+}
+
+func Test_OrgBundle_write_read(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "orgbundle-")
+	if err != nil {
+		t.Fatalf("unable to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "org1.tar.gz")
+	if err := WriteOrgBundle(testOrgBundle(), filePath); err != nil {
+		t.Fatalf("unable to write bundle, error %v", err)
+	}
+
+	bundle, err := ReadOrgBundle(filePath)
+	if err != nil {
+		t.Fatalf("unable to read bundle, error %v", err)
+	}
+
+	expected := testOrgBundle()
+	if bundle.Header != expected.Header {
+		t.Errorf("wrong header %v", bundle.Header)
+	} else if len(bundle.Services) != 1 || len(bundle.Patterns) != 1 || len(bundle.DeploymentPolicies) != 1 {
+		t.Errorf("wrong bundle content %v", bundle)
+	} else if svc, ok := bundle.Services["svc1_1.0.0_amd64"]; !ok || svc.Service.URL != "svc1" || len(svc.Service.RequiredServices) != 2 || svc.Keys["key.pem"] != "public key" || svc.Policy == nil {
+		t.Errorf("wrong service %v", bundle.Services)
+	} else if pat, ok := bundle.Patterns["pat1"]; !ok || len(pat.Pattern.Services) != 2 || len(pat.Pattern.UserInput) != 2 {
+		t.Errorf("wrong pattern %v", bundle.Patterns)
+	} else if pol, ok := bundle.DeploymentPolicies["bp1"]; !ok || pol.Service.Name != "svc1" || pol.UserInput[0].Inputs[0].Value != "c" {
+		t.Errorf("wrong deployment policy %v", bundle.DeploymentPolicies)
+	}
+
+}
+
+func Test_OrgBundle_newer_version(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "orgbundle-")
+	if err != nil {
+		t.Fatalf("unable to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := testOrgBundle()
+	bundle.Header.Version = ORG_BUNDLE_VERSION + 1
+	filePath := filepath.Join(dir, "org1.tar.gz")
+	if err := WriteOrgBundle(bundle, filePath); err != nil {
+		t.Fatalf("unable to write bundle, error %v", err)
+	}
+
+	if _, err := ReadOrgBundle(filePath); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected the newer bundle to be rejected, error %v", err)
+	}
+
+	// A file that is not a bundle is rejected too.
+	notBundle := filepath.Join(dir, "bundle.json")
+	if err := ioutil.WriteFile(notBundle, []byte("{}"), 0600); err != nil {
+		t.Fatalf("unable to write file, error %v", err)
+	} else if _, err := ReadOrgBundle(notBundle); err == nil {
+		t.Errorf("expected an error reading a file that is not a bundle")
+	}
+
+}
@@ -92,11 +92,7 @@ func PatternList(org string, userPw string, pattern string, namesOnly bool) {
 		for p := range resp.Patterns {
 			patterns = append(patterns, p)
 		}
-		jsonBytes, err := json.MarshalIndent(patterns, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'exchange pattern list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(patterns, "'hzn exchange pattern list'")
 	} else {
 		// Display the full resources
 		var patterns ExchangePatterns
@@ -104,11 +100,12 @@ func PatternList(org string, userPw string, pattern string, namesOnly bool) {
 		if httpCode == 404 && pattern != "" {
 			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("pattern '%s' not found in org %s", pattern, patOrg))
 		}
-		jsonBytes, err := json.MarshalIndent(patterns.Patterns, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange pattern list' output: %v", err))
-		}
-		fmt.Println(string(jsonBytes))
+		cliutils.Output(patterns.Patterns, "'hzn exchange pattern list'",
+			cliutils.NewOutputColumn("LABEL", ".label"),
+			cliutils.NewOutputColumn("PUBLIC", ".public"),
+			cliutils.NewOutputColumn("SERVICES", ".services[].serviceUrl"),
+			cliutils.NewWideOutputColumn("OWNER", ".owner"),
+			cliutils.NewWideOutputColumn("LAST UPDATED", ".lastUpdated"))
 	}
 }
 
@@ -420,7 +417,7 @@ func PatternListKey(org, userPw, pattern, keyName string) {
 		// Only display the names
 		var output string
 		cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+patorg+"/patterns/"+pattern+"/keys", cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &output)
-		fmt.Printf("%s\n", output)
+		cliutils.Output(output, "'hzn exchange pattern listkey'")
 	} else {
 		// Display the content of the key
 		var output []byte
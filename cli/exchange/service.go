@@ -1,7 +1,6 @@
 package exchange
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -65,11 +64,7 @@ func ServiceList(credOrg, userPw, service string, namesOnly bool, filePath strin
 		for k := range resp.Services {
 			services = append(services, k)
 		}
-		jsonBytes, err := json.MarshalIndent(services, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange service list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(services, "'hzn exchange service list'")
 	} else {
 		// Display the full resources
 		var services exchange.GetServicesResponse
@@ -101,11 +96,15 @@ func ServiceList(credOrg, userPw, service string, namesOnly bool, filePath strin
 				exchServices[sId] = s_copy
 			}
 		}
-		jsonBytes, err := json.MarshalIndent(exchServices, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange service list' output: %v", err))
-		}
-		fmt.Println(string(jsonBytes))
+		cliutils.Output(exchServices, "'hzn exchange service list'",
+			cliutils.NewOutputColumn("URL", ".url"),
+			cliutils.NewOutputColumn("VERSION", ".version"),
+			cliutils.NewOutputColumn("ARCH", ".arch"),
+			cliutils.NewOutputColumn("SHARABLE", ".sharable"),
+			cliutils.NewOutputColumn("PUBLIC", ".public"),
+			cliutils.NewWideOutputColumn("LABEL", ".label"),
+			cliutils.NewWideOutputColumn("OWNER", ".owner"),
+			cliutils.NewWideOutputColumn("LAST UPDATED", ".lastUpdated"))
 
 		// save the kube operator yaml archive to file if filePath is specified and one service is specified
 		if filePath != "" {
@@ -433,7 +432,7 @@ func ServiceListKey(org, userPw, service, keyName string) {
 		if httpCode == 404 {
 			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("keys not found"))
 		}
-		fmt.Printf("%s\n", output)
+		cliutils.Output(output, "'hzn exchange service listkey'")
 	} else {
 		// Display the content of the key
 		var output []byte
@@ -480,7 +479,7 @@ func ServiceListAuth(org, userPw, service string, authId uint) {
 			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("docker auths not found"))
 		}
 	}
-	fmt.Printf("%s\n", output)
+	cliutils.Output(output, "'hzn exchange service listauth'")
 }
 
 func ServiceRemoveAuth(org, userPw, service string, authId uint) {
@@ -515,15 +514,7 @@ func ServiceListPolicy(org string, credToUse string, service string) {
 	var policy exchange.ExchangePolicy
 	cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+svcorg+"/services"+cliutils.AddSlash(service)+"/policy", cliutils.OrgAndCreds(org, credToUse), []int{200, 404}, &policy)
 
-	buf := new(bytes.Buffer)
-	enc := json.NewEncoder(buf)
-	enc.SetEscapeHTML(false)
-	enc.SetIndent("", cliutils.JSON_INDENT)
-	err := enc.Encode(policy.GetExternalPolicy())
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange service listpolicy' output: %v", err))
-	}
-	fmt.Println(string(buf.String()))
+	cliutils.Output(policy.GetExternalPolicy(), "'hzn exchange service listpolicy'")
 }
 
 //ServiceAddPolicy adds a policy or replaces an existing policy for the service in the Horizon Exchange
@@ -729,11 +720,7 @@ func ListServiceNodes(org, userPw, svcId, nodeOrg string) {
 		if nodes, ok := listNodes["nodes"]; !ok {
 			fmt.Println("[]")
 		} else {
-			jsonBytes, err := json.MarshalIndent(nodes, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, fmt.Sprintf("failed to marshal 'hzn exchange service listnode' output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(nodes, "'hzn exchange service listnode'")
 		}
 	}
 }
@@ -0,0 +1,124 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/semanticversion"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// The output formats of the service dependency graph.
+const (
+	GRAPH_FORMAT_TREE = "tree"
+	GRAPH_FORMAT_JSON = "json"
+	GRAPH_FORMAT_DOT  = "dot"
+)
+
+// ServiceGraph displays the resolved dependency graph of a service in the exchange. Every required service is resolved
+// to the highest version within its version range, the same way the agent resolves it. When the arch is not given,
+// the graph contains every arch variant of the service. The problems found in the graph are displayed on stderr,
+// and make the command fail.
+func ServiceGraph(org, credToUse, service, versionRange, arch, format string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	cliutils.SetWhetherUsingApiKey(credToUse)
+	svcOrg, svcUrl := cliutils.TrimOrg(org, service)
+	handler := GetServiceGraphHandler(org, credToUse)
+
+	arches := []string{arch}
+	if arch == "" {
+		var services exchange.GetServicesResponse
+		cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+svcOrg+"/services?url="+url.QueryEscape(svcUrl), cliutils.OrgAndCreds(org, credToUse), []int{200, 404}, &services)
+		found := make(map[string]bool)
+		arches = []string{}
+		for _, s := range services.Services {
+			if s.URL == svcUrl && !found[s.Arch] {
+				found[s.Arch] = true
+				arches = append(arches, s.Arch)
+			}
+		}
+		sort.Strings(arches)
+		if len(arches) == 0 {
+			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("service '%s' not found in org %s", svcUrl, svcOrg))
+		}
+	}
+
+	graph := exchange.NewServiceGraph()
+	for _, a := range arches {
+		if err := graph.AddRoot(svcUrl, svcOrg, versionRange, a, handler); err != nil {
+			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("unable to resolve service %v/%v %v: %v", svcOrg, svcUrl, a, err))
+		}
+	}
+	graph.CheckSharing()
+
+	PrintServiceGraph(graph, format)
+}
+
+// GetServiceGraphHandler returns a service handler that looks up the services in the exchange with the hzn
+// credentials. The services of each url, org and arch are only read once.
+func GetServiceGraphHandler(org, credToUse string) exchange.ServiceHandler {
+	cache := make(map[string]map[string]exchange.ServiceDefinition)
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*exchange.ServiceDefinition, string, error) {
+		key := wOrg + "/" + wUrl + "/" + wArch
+		services, ok := cache[key]
+		if !ok {
+			var resp exchange.GetServicesResponse
+			cliutils.ExchangeGet("Exchange", cliutils.GetExchangeUrl(), "orgs/"+wOrg+"/services?url="+url.QueryEscape(wUrl)+"&arch="+url.QueryEscape(wArch), cliutils.OrgAndCreds(org, credToUse), []int{200, 404}, &resp)
+			resp.SupportVersionRange()
+			services = make(map[string]exchange.ServiceDefinition)
+			for id, s := range resp.Services {
+				// the exchange filters the url by prefix
+				if s.URL == wUrl && s.Arch == wArch {
+					services[id] = s
+				}
+			}
+			cache[key] = services
+		}
+
+		vRange, err := semanticversion.Version_Expression_Factory(wVersion)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid version range %v: %v", wVersion, err)
+		}
+		if highest, sDef, sId, err := exchange.GetHighestVersion(services, vRange); err != nil || highest == "" {
+			return nil, "", err
+		} else {
+			return &sDef, sId, nil
+		}
+	}
+}
+
+// PrintServiceGraph displays a service dependency graph as a tree, as json or in the DOT language of graphviz. The
+// problems in the graph are displayed on stderr, and make the command fail.
+func PrintServiceGraph(graph *exchange.ServiceGraph, format string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	switch format {
+	case GRAPH_FORMAT_TREE, "":
+		fmt.Print(graph.Tree())
+	case GRAPH_FORMAT_JSON:
+		jsonBytes, err := json.MarshalIndent(graph, "", cliutils.JSON_INDENT)
+		if err != nil {
+			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal the service graph: %v", err))
+		}
+		fmt.Println(string(jsonBytes))
+	case GRAPH_FORMAT_DOT:
+		fmt.Print(graph.Dot())
+	default:
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("unsupported output format %v, it must be %v, %v or %v", format, GRAPH_FORMAT_TREE, GRAPH_FORMAT_JSON, GRAPH_FORMAT_DOT))
+	}
+
+	if len(graph.Problems) != 0 {
+		fmt.Fprintln(os.Stderr, msgPrinter.Sprintf("The dependency graph has %v problems:", len(graph.Problems)))
+		for _, p := range graph.Problems {
+			fmt.Fprintf(os.Stderr, "  %v\n", p)
+		}
+		os.Exit(cliutils.CLI_GENERAL_ERROR)
+	}
+}
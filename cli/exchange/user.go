@@ -1,7 +1,6 @@
 package exchange
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
@@ -54,14 +53,14 @@ func UserList(org, userPwCreds, theUser string, allUsers, namesOnly bool) {
 		for u := range users.Users {
 			usernames = append(usernames, u)
 		}
-		jsonBytes, err := json.MarshalIndent(usernames, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'exchange user list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(usernames, "'hzn exchange user list'")
 	} else { // show full resources
-		output := cliutils.MarshalIndent(users.Users, "exchange users list")
-		fmt.Println(output)
+		cliutils.Output(users.Users, "'hzn exchange user list'",
+			cliutils.NewOutputColumn("EMAIL", ".email"),
+			cliutils.NewOutputColumn("ADMIN", ".admin"),
+			cliutils.NewOutputColumn("HUB ADMIN", ".hubAdmin"),
+			cliutils.NewWideOutputColumn("LAST UPDATED", ".lastUpdated"),
+			cliutils.NewWideOutputColumn("UPDATED BY", ".updatedBy"))
 	}
 }
 
@@ -10,11 +10,13 @@ import (
 
 	"github.com/open-horizon/anax/cli/agreement"
 	"github.com/open-horizon/anax/cli/agreementbot"
+	"github.com/open-horizon/anax/cli/apply"
 	"github.com/open-horizon/anax/cli/attribute"
 	"github.com/open-horizon/anax/cli/cliconfig"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/cli/deploycheck"
 	"github.com/open-horizon/anax/cli/dev"
+	"github.com/open-horizon/anax/cli/devenv"
 	"github.com/open-horizon/anax/cli/eventlog"
 	"github.com/open-horizon/anax/cli/exchange"
 	_ "github.com/open-horizon/anax/cli/i18n_messages"
@@ -25,6 +27,7 @@ import (
 	"github.com/open-horizon/anax/cli/node"
 	"github.com/open-horizon/anax/cli/policy"
 	"github.com/open-horizon/anax/cli/register"
+	"github.com/open-horizon/anax/cli/scenario"
 	"github.com/open-horizon/anax/cli/service"
 	"github.com/open-horizon/anax/cli/status"
 	"github.com/open-horizon/anax/cli/sync_service"
@@ -72,6 +75,7 @@ func main() {
 
 Subcommands Description:
   agbot: List and manage Horizon agreement bot resources.
+  apply: Create, update or delete Horizon Exchange resources from a directory of manifests.
   agreement: List or manage the active or archived agreements this edge node has made with a Horizon agreement bot.
   architecture: Show the architecture of this machine (as defined by Horizon and golang). 
   attribute: List or manage the global attributes that are currently registered on this Horizon edge node.
@@ -125,6 +129,8 @@ Environment Variables:
 	app.UsageTemplate(kingpin.CompactUsageTemplate)
 	cliutils.Opts.Verbose = app.Flag("verbose", msgPrinter.Sprintf("Verbose output.")).Short('v').Bool()
 	cliutils.Opts.IsDryRun = app.Flag("dry-run", msgPrinter.Sprintf("When calling the Horizon or Exchange API, do GETs, but don't do PUTs, POSTs, or DELETEs.")).Bool()
+	cliutils.Opts.Output = app.Flag("output-format", msgPrinter.Sprintf("The output format of the list and show commands: json, yaml, table or wide (a table with all the columns). The default is json. There is no -o short form, because -o is the short form of the --org flag of several commands.")).Enum(cliutils.OUTPUT_FORMATS...)
+	cliutils.Opts.Query = app.Flag("query", msgPrinter.Sprintf("Select fields in the output of the list and show commands, with a subset of the jq language. For example: --query '.[].id' or --query '.[] | {id, arch}'.")).String()
 
 	agbotCmd := app.Command("agbot", msgPrinter.Sprintf("List and manage Horizon agreement bot resources."))
 
@@ -132,6 +138,11 @@ Environment Variables:
 	agbotAgreementCancelCmd := agbotAgreementCmd.Command("cancel", msgPrinter.Sprintf("Cancel 1 or all of the active agreements this Horizon agreement bot has with edge nodes. Usually an agbot will immediately negotiated a new agreement. "))
 	agbotCancelAllAgreements := agbotAgreementCancelCmd.Flag("all", msgPrinter.Sprintf("Cancel all of the current agreements.")).Short('a').Bool()
 	agbotCancelAgreementId := agbotAgreementCancelCmd.Arg("agreement", msgPrinter.Sprintf("The active agreement to cancel.")).String()
+	agbotCancelSelectors := agbotAgreementCancelCmd.Flag("selector", msgPrinter.Sprintf("Cancel the active agreements that match all of the selectors, in a job that runs in the agbot. A selector is key=value, the keys are policy, pattern, service, service_version, node_org, older_than (a duration such as 24h) and node_property (node_property=name=value). This flag can be repeated.")).Short('s').Strings()
+	agbotCancelRenegotiate := agbotAgreementCancelCmd.Flag("renegotiate", msgPrinter.Sprintf("Re-negotiate the selected agreements starting with the highest priority service version, instead of canceling them. Only used with --selector.")).Bool()
+	agbotCancelPreview := agbotAgreementCancelCmd.Flag("preview", msgPrinter.Sprintf("Display the agreements that match the selectors without canceling them. Only used with --selector.")).Bool()
+	agbotAgreementJobCmd := agbotAgreementCmd.Command("job", msgPrinter.Sprintf("Display the status of the jobs that cancel the agreements matching a selector."))
+	agbotAgreementJobId := agbotAgreementJobCmd.Arg("job", msgPrinter.Sprintf("Display just this one job.")).String()
 	agbotAgreementListCmd := agbotAgreementCmd.Command("list", msgPrinter.Sprintf("List the active or archived agreements this Horizon agreement bot has with edge nodes."))
 	agbotlistArchivedAgreements := agbotAgreementListCmd.Flag("archived", msgPrinter.Sprintf("List archived agreements instead of the active agreements.")).Short('r').Bool()
 	agbotAgreement := agbotAgreementListCmd.Arg("agreement", msgPrinter.Sprintf("List just this one agreement.")).String()
@@ -151,6 +162,12 @@ Environment Variables:
 	agbotCacheServedOrgList := agbotCacheServedOrg.Command("list", msgPrinter.Sprintf("Display served pattern orgs and deployment policy orgs."))
 
 	agbotListCmd := agbotCmd.Command("list", msgPrinter.Sprintf("Display general information about this Horizon agbot node."))
+	agbotMeteringCmd := agbotCmd.Command("metering", msgPrinter.Sprintf("List the metering ledger of the tokens this Horizon agreement bot has granted to edge nodes."))
+	agbotMeteringListCmd := agbotMeteringCmd.Command("list", msgPrinter.Sprintf("Display the tokens granted within a time range, per agreement and totaled by organization, service and node. Metering notifications that do not match their agreement are excluded from the totals."))
+	agbotMeteringSince := agbotMeteringListCmd.Flag("since", msgPrinter.Sprintf("The start of the time range, in seconds since 1970. Defaults to the beginning.")).Short('s').Uint64()
+	agbotMeteringUntil := agbotMeteringListCmd.Flag("until", msgPrinter.Sprintf("The end of the time range, in seconds since 1970. Defaults to now.")).Short('u').Uint64()
+	agbotMeteringFormat := agbotMeteringListCmd.Flag("format", msgPrinter.Sprintf("The output format, json or csv.")).Short('f').Default("json").String()
+	agbotMeteringView := agbotMeteringListCmd.Flag("view", msgPrinter.Sprintf("For csv output, the view to display: agreement, org, service or node.")).Default("agreement").String()
 	agbotPolicyCmd := agbotCmd.Command("policy", msgPrinter.Sprintf("List the policies this Horizon agreement bot hosts."))
 	agbotPolicyListCmd := agbotPolicyCmd.Command("list", msgPrinter.Sprintf("List policies this Horizon agreement bot hosts."))
 	agbotPolicyOrg := agbotPolicyListCmd.Arg("org", msgPrinter.Sprintf("The organization the policy belongs to.")).String()
@@ -162,6 +179,8 @@ Environment Variables:
 	agreementListCmd := agreementCmd.Command("list", msgPrinter.Sprintf("List the active or archived agreements this edge node has made with a Horizon agreement bot."))
 	listAgreementId := agreementListCmd.Arg("agreement-id", msgPrinter.Sprintf("Show the details of this active or archived agreement.")).String()
 	listArchivedAgreements := agreementListCmd.Flag("archived", msgPrinter.Sprintf("List archived agreements instead of the active agreements.")).Short('r').Bool()
+	agreementTimelineCmd := agreementCmd.Command("timeline", msgPrinter.Sprintf("Display the state transitions of an active or archived agreement, together with the event log entries of the agreement and of the services deployed for it, in time order."))
+	timelineAgreementId := agreementTimelineCmd.Arg("agreement-id", msgPrinter.Sprintf("The active or archived agreement.")).Required().String()
 	agreementCancelCmd := agreementCmd.Command("cancel", msgPrinter.Sprintf("Cancel 1 or all of the active agreements this edge node has made with a Horizon agreement bot. Usually an agbot will immediately negotiated a new agreement. If you want to cancel all agreements and not have this edge accept new agreements, run 'hzn unregister'."))
 	cancelAllAgreements := agreementCancelCmd.Flag("all", msgPrinter.Sprintf("Cancel all of the current agreements.")).Short('a').Bool()
 	cancelAgreementId := agreementCancelCmd.Arg("agreement-id", msgPrinter.Sprintf("The active agreement to cancel.")).String()
@@ -171,6 +190,19 @@ Environment Variables:
 	attributeCmd := app.Command("attribute", msgPrinter.Sprintf("List or manage the global attributes that are currently registered on this Horizon edge node."))
 	attributeListCmd := attributeCmd.Command("list", msgPrinter.Sprintf("List the global attributes that are currently registered on this Horizon edge node."))
 
+	applyCmd := app.Command("apply", msgPrinter.Sprintf("Create, update or delete services, patterns, deployment policies and node policies in the Horizon Exchange to match a directory of manifests. A manifest is the JSON input file of 'hzn exchange service publish', 'pattern publish', 'deployment addpolicy' or 'node addpolicy', with a \"kind\" field that is service, pattern, deploymentPolicy or nodePolicy. Deployment policies and node policies also need a \"name\" field, which is the node id for a node policy. The plan is displayed before any change is made."))
+	applyOrg := applyCmd.Flag("org", msgPrinter.Sprintf("The Horizon exchange organization ID. If not specified, HZN_ORG_ID will be used as a default.")).Short('o').String()
+	applyUserPw := applyCmd.Flag("user-pw", msgPrinter.Sprintf("Horizon Exchange user credentials. If not specified, HZN_EXCHANGE_USER_AUTH will be used as a default.")).Short('u').PlaceHolder("USER:PW").String()
+	applyDir := applyCmd.Flag("dir", msgPrinter.Sprintf("The directory of manifests. The JSON files in the directory and its sub directories are read.")).Short('f').Required().ExistingDir()
+	applyPrune := applyCmd.Flag("prune", msgPrinter.Sprintf("Delete the services, patterns and deployment policies in the organization that have no manifest.")).Bool()
+	applyPlan := applyCmd.Flag("plan", msgPrinter.Sprintf("Display the plan without making any change.")).Bool()
+	applyForce := applyCmd.Flag("force", msgPrinter.Sprintf("Skip the 'do you want to apply these changes?' prompt.")).Bool()
+	applyPrivKeyFile := applyCmd.Flag("private-key-file", msgPrinter.Sprintf("The path of a private key file to be used to sign the services and the deployment overrides of the patterns. If not specified, the environment variable HZN_PRIVATE_KEY_FILE will be used. If none of them are set, ~/.hzn/keys/service.private.key is the default.")).Short('k').ExistingFile()
+	applyPubKeyFile := applyCmd.Flag("public-key-file", msgPrinter.Sprintf("The path of public key file (that corresponds to the private key) that should be stored with the services and patterns. If both this and -k flags are not specified, the environment variable HZN_PUBLIC_KEY_FILE will be used. If HZN_PUBLIC_KEY_FILE is not set, ~/.hzn/keys/service.public.pem is the default.")).Short('K').ExistingFile()
+	applyDontTouchImage := applyCmd.Flag("dont-change-image-tag", msgPrinter.Sprintf("The image paths in the deployment field of the services have regular tags and should not be changed to sha256 digest values. The images will not get automatically uploaded to the repository.")).Short('I').Bool()
+	applyPullImage := applyCmd.Flag("pull-image", msgPrinter.Sprintf("Use the images of the services from the image repository. This flag is mutually exclusive with -I.")).Short('P').Bool()
+	applyNoConstraints := applyCmd.Flag("no-constraints", msgPrinter.Sprintf("Allow deployment policies without constraints.")).Bool()
+
 	deploycheckCmd := app.Command("deploycheck", msgPrinter.Sprintf("Check deployment compatibility."))
 	deploycheckOrg := deploycheckCmd.Flag("org", msgPrinter.Sprintf("The Horizon exchange organization ID. If not specified, HZN_ORG_ID will be used as a default.")).Short('o').String()
 	deploycheckUserPw := deploycheckCmd.Flag("user-pw", msgPrinter.Sprintf("Horizon exchange user credential to query exchange resources. If not specified, HZN_EXCHANGE_USER_AUTH or HZN_EXCHANGE_NODE_AUTH will be used as a default. If you don't prepend it with the organization id, it will automatically be prepended with the -o value.")).Short('u').PlaceHolder("USER:PW").String()
@@ -213,6 +245,9 @@ Environment Variables:
 	userinputCompSvcFile := userinputCompCmd.Flag("service", msgPrinter.Sprintf("(optional) The JSON input file name containing the service definition. If omitted, the service defined in the deployment policy or pattern will be retrieved from the Exchange. This flag can be repeated to specify different versions of the service.")).Strings()
 	userinputCompPatternId := userinputCompCmd.Flag("pattern-id", msgPrinter.Sprintf("The Horizon exchange pattern ID. Mutually exclusive with -P, -b and -B. If you don't prepend it with the organization id, it will automatically be prepended with the node's organization id.")).Short('p').String()
 	userinputCompPatternFile := userinputCompCmd.Flag("pattern", msgPrinter.Sprintf("The JSON input file name containing the pattern. Mutually exclusive with -p, -b and -B.")).Short('P').String()
+	whatifCmd := deploycheckCmd.Command("whatif", msgPrinter.Sprintf("Show the nodes that would gain, lose or upgrade their agreements if a deployment policy were created or replaced. The agbot at HZN_AGBOT_URL does the evaluation and nothing is changed."))
+	whatifDepPolId := whatifCmd.Flag("deployment-pol-id", msgPrinter.Sprintf("The Horizon exchange deployment policy ID that would be created or replaced. If you don't prepend it with the organization id, it will automatically be prepended with the -o value.")).Short('b').Required().String()
+	whatifDepPolFile := whatifCmd.Flag("deployment-pol", msgPrinter.Sprintf("The JSON input file name containing the candidate deployment policy.")).Short('B').Required().String()
 
 	devCmd := app.Command("dev", msgPrinter.Sprintf("Development tools for creation of services."))
 	devHomeDirectory := devCmd.Flag("directory", msgPrinter.Sprintf("Directory containing Horizon project metadata. If omitted, a subdirectory called 'horizon' under current directory will be used.")).Short('d').String()
@@ -229,6 +264,22 @@ Environment Variables:
 	devDependencyFetchCmdUserInputFile := devDependencyFetchCmd.Flag("userInputFile", msgPrinter.Sprintf("File containing user input values for configuring the new dependency. If omitted, the userinput file in the dependency project will be used.")).Short('f').ExistingFile()
 	devDependencyListCmd := devDependencyCmd.Command("list", msgPrinter.Sprintf("List all dependencies."))
 	devDependencyRemoveCmd := devDependencyCmd.Command("remove", msgPrinter.Sprintf("Remove a project dependency."))
+	devDependencyGraphCmd := devDependencyCmd.Command("graph", msgPrinter.Sprintf("Display the resolved dependency graph of the service project, and check it for dependency cycles, version ranges that no dependency satisfies and conflicting sharing modes."))
+	devDependencyGraphFormat := devDependencyGraphCmd.Flag("output", msgPrinter.Sprintf("The output format: tree, json or dot (the graphviz language).")).Default("tree").Enum("tree", "json", "dot")
+
+	devEnvCmd := devCmd.Command("env", msgPrinter.Sprintf("For working with a local Horizon environment, to publish and deploy services offline on this machine."))
+	devEnvUpCmd := devEnvCmd.Command("up", msgPrinter.Sprintf("Run an in-process Exchange stand-in, the file sync service CSS, an agbot and an agent on this machine, until interrupted. The agbot and the agent are run with the anax binary. The Exchange resources are kept in the working directory between runs."))
+	devEnvUpWorkDir := devEnvUpCmd.Flag("workdir", msgPrinter.Sprintf("The working directory of the local environment. If omitted, ~/.hzn/devenv is used.")).Short('w').String()
+	devEnvUpOrg := devEnvUpCmd.Flag("org", msgPrinter.Sprintf("The organization created in the Exchange stand-in. If omitted, '%v' is used.", devenv.DEFAULT_ORG)).Short('o').String()
+	devEnvUpUserPw := devEnvUpCmd.Flag("user-pw", msgPrinter.Sprintf("The credentials of the admin user created in the organization. If omitted, '%v' is used.", devenv.DEFAULT_USER_PW)).Short('u').PlaceHolder("USER:PW").String()
+	devEnvUpAnax := devEnvUpCmd.Flag("anax", msgPrinter.Sprintf("The anax binary to run the agbot and the agent with.")).Default("anax").String()
+	devEnvUpExchangePort := devEnvUpCmd.Flag("exchange-port", msgPrinter.Sprintf("The port of the Exchange stand-in.")).Default("3090").Int()
+	devEnvUpAgentPort := devEnvUpCmd.Flag("agent-port", msgPrinter.Sprintf("The port of the agent API.")).Default("8510").Int()
+	devEnvUpAgbotPort := devEnvUpCmd.Flag("agbot-port", msgPrinter.Sprintf("The port of the agbot API.")).Default("8046").Int()
+	devEnvUpNoFSS := devEnvUpCmd.Flag("noFSS", msgPrinter.Sprintf("Do not bring up the file sync service (FSS) CSS container. Model management is then not available.")).Short('S').Bool()
+	devEnvDownCmd := devEnvCmd.Command("down", msgPrinter.Sprintf("Stop what is left of a local environment whose 'hzn dev env up' did not end normally."))
+	devEnvDownWorkDir := devEnvDownCmd.Flag("workdir", msgPrinter.Sprintf("The working directory of the local environment. If omitted, ~/.hzn/devenv is used.")).Short('w').String()
+	devEnvDownPurge := devEnvDownCmd.Flag("purge", msgPrinter.Sprintf("Also remove the working directory, with the Exchange resources, the agent and the agbot databases.")).Bool()
 
 	devServiceCmd := devCmd.Command("service", msgPrinter.Sprintf("For working with a service project."))
 	devServiceLogCmd := devServiceCmd.Command("log", msgPrinter.Sprintf("Show the container/system logs for a service."))
@@ -250,6 +301,11 @@ Environment Variables:
 	devServiceNoFSS := devServiceStartTestCmd.Flag("noFSS", msgPrinter.Sprintf("Do not bring up file sync service (FSS) containers. They are brought up by default.")).Short('S').Bool()
 	devServiceStartCmdUserPw := devServiceStartTestCmd.Flag("user-pw", msgPrinter.Sprintf("Horizon Exchange user credentials to query exchange resources. Specify it when you want to automatically fetch the missing dependent services from the Exchange. The default is HZN_EXCHANGE_USER_AUTH environment variable. If you don't prepend it with the user's org, it will automatically be prepended with the value of the HZN_ORG_ID environment variable.")).Short('u').PlaceHolder("USER:PW").String()
 	devServiceStopTestCmd := devServiceCmd.Command("stop", msgPrinter.Sprintf("Stop a service that is running in a mocked Horizon Agent environment. This command is not supported for services using the %v deployment configuration.", kube_deployment.KUBE_DEPLOYMENT_CONFIG_TYPE))
+	devServiceTestCmd := devServiceCmd.Command("test", msgPrinter.Sprintf("Run a test scenario against a service: start the service with its dependencies in a mocked Horizon Agent environment, wait for it to be ready, run the HTTP and exec probes and publish the MMS objects of the scenario, verify the results, and stop the service. This command is not supported for services using the %v deployment configuration.", kube_deployment.KUBE_DEPLOYMENT_CONFIG_TYPE))
+	devServiceTestScenario := devServiceTestCmd.Flag("scenario", msgPrinter.Sprintf("The JSON file containing the test scenario.")).Short('s').Required().ExistingFile()
+	devServiceTestJUnit := devServiceTestCmd.Flag("junit", msgPrinter.Sprintf("Write the results in JUnit XML format to this file.")).String()
+	devServiceTestKeep := devServiceTestCmd.Flag("keep", msgPrinter.Sprintf("Leave the service running at the end of the scenario, to troubleshoot it. Use 'hzn dev service stop' to stop it.")).Bool()
+	devServiceTestUserPw := devServiceTestCmd.Flag("user-pw", msgPrinter.Sprintf("Horizon Exchange user credentials to query exchange resources. Specify it when you want to automatically fetch the missing dependent services from the Exchange. The default is HZN_EXCHANGE_USER_AUTH environment variable. If you don't prepend it with the user's org, it will automatically be prepended with the value of the HZN_ORG_ID environment variable.")).Short('u').PlaceHolder("USER:PW").String()
 	devServiceValidateCmd := devServiceCmd.Command("verify", msgPrinter.Sprintf("Validate the project for completeness and schema compliance."))
 	devServiceVerifyUserInputFile := devServiceValidateCmd.Flag("userInputFile", msgPrinter.Sprintf("File containing user input values for verification of a project. If omitted, the userinput file for the project will be used.")).Short('f').String()
 	devServiceValidateCmdUserPw := devServiceValidateCmd.Flag("user-pw", msgPrinter.Sprintf("Horizon Exchange user credentials to query exchange resources. Specify it when you want to automatically fetch the missing dependent services from the Exchange. The default is HZN_EXCHANGE_USER_AUTH environment variable. If you don't prepend it with the user's org, it will automatically be prepended with the value of the HZN_ORG_ID environment variable.")).Short('u').PlaceHolder("USER:PW").String()
@@ -337,6 +393,10 @@ Environment Variables:
 	exNodeCreateNodeType := exNodeCreateCmd.Flag("node-type", msgPrinter.Sprintf("The type of your node. The valid values are: device, cluster. If omitted, the default is device. However, the node type stays unchanged if the node already exists, only the node token will be updated.")).Short('T').Default("device").String()
 	exNodeCreateNode := exNodeCreateCmd.Arg("node", msgPrinter.Sprintf("The node to be created.")).String()
 	exNodeCreateToken := exNodeCreateCmd.Arg("token", msgPrinter.Sprintf("The token the new node should have.")).String()
+	exNodeProvisionCmd := exNodeCmd.Command("provision", msgPrinter.Sprintf("Create the node resources of a list of devices in the Horizon Exchange, with the pattern, node policy and user input of a registration profile, so that the devices can be registered unattended later with 'hzn register --profile'. The id, token and status of each device are written as CSV."))
+	exNodeProvisionFile := exNodeProvisionCmd.Flag("file", msgPrinter.Sprintf("A CSV file that lists the devices. The first row names the columns: id (required), token, name, arch and nodeType. Devices without a token get a random one.")).Short('f').Required().ExistingFile()
+	exNodeProvisionProfile := exNodeProvisionCmd.Flag("profile", msgPrinter.Sprintf("The registration profile: a file, the name of a file in ~/.hzn/profiles without the .json extension, or the id of a registration_profile object in the Model Management Service of the organization.")).Required().String()
+	exNodeProvisionOutput := exNodeProvisionCmd.Flag("output", msgPrinter.Sprintf("The file to write the ids and tokens of the devices to. It is only readable by the owner. If not specified, they are written to stdout.")).Short('O').String()
 	exNodeConfirmCmd := exNodeCmd.Command("confirm", msgPrinter.Sprintf("Check to see if the specified node and token are valid in the Horizon Exchange."))
 	exNodeConfirmNodeIdTok := exNodeConfirmCmd.Flag("node-id-tok", msgPrinter.Sprintf("The Horizon exchange node ID and token to be checked. If not specified, HZN_EXCHANGE_NODE_AUTH will be used as a default. Mutually exclusive with <node> and <token> arguments.")).Short('n').PlaceHolder("ID:TOK").String()
 	exNodeConfirmNode := exNodeConfirmCmd.Arg("node", msgPrinter.Sprintf("The node id to be checked. Mutually exclusive with -n flag.")).String()
@@ -403,6 +463,20 @@ Environment Variables:
 	exOrgUpdateHBMax := exOrgUpdateCmd.Flag("heartbeatmax", msgPrinter.Sprintf("New maximum number of seconds between agent heartbeats to the Exchange. The default negative integer -1 means no change to this attribute.")).Default("-1").Int()
 	exOrgUpdateHBAdjust := exOrgUpdateCmd.Flag("heartbeatadjust", msgPrinter.Sprintf("New value for the number of seconds to increment the agent's heartbeat interval. The default negative integer -1 means no change to this attribute.")).Default("-1").Int()
 	exOrgUpdateMaxNodes := exOrgUpdateCmd.Flag("max-nodes", msgPrinter.Sprintf("The new maximum number of nodes this organization is allowed to have. The value cannot exceed the Exchange global limit. The default negative integer -1 means no change.")).Default("-1").Int()
+	exOrgExportCmd := exOrgCmd.Command("export", msgPrinter.Sprintf("Export the services, service policies, patterns, deployment policies, signing keys and docker auths of an organization to a versioned tarball. The tarball contains the docker registry tokens of the services."))
+	exOrgExportOrg := exOrgExportCmd.Arg("org", msgPrinter.Sprintf("Export this organization. If not specified, the -o value is used.")).String()
+	exOrgExportFile := exOrgExportCmd.Flag("file", msgPrinter.Sprintf("The path of the tarball to write.")).Short('f').Required().String()
+	exOrgImportCmd := exOrgCmd.Command("import", msgPrinter.Sprintf("Import a tarball created by 'hzn exchange org export' into the organization given by -o. When the tarball was exported from a different organization, the references to the services of that organization are changed to this organization. Resources that already exist are reported as conflicts and left as they are, unless --overwrite is specified."))
+	exOrgImportFile := exOrgImportCmd.Flag("file", msgPrinter.Sprintf("The path of the tarball to import.")).Short('f').Required().ExistingFile()
+	exOrgImportOverwrite := exOrgImportCmd.Flag("overwrite", msgPrinter.Sprintf("Replace the resources that already exist in the organization.")).Bool()
+	exOrgImportPrivKeyFile := exOrgImportCmd.Flag("private-key-file", msgPrinter.Sprintf("The path of a private key file to sign the service deployments and the deployment overrides of the patterns again. If neither this nor -K is specified, the signatures and public keys in the tarball are imported as they are.")).Short('k').ExistingFile()
+	exOrgImportPubKeyFile := exOrgImportCmd.Flag("public-key-file", msgPrinter.Sprintf("The path of public key file (that corresponds to the private key) that is stored with the services and patterns instead of the public keys in the tarball.")).Short('K').ExistingFile()
+	exOrgAddMMSKeyCmd := exOrgCmd.Command("addmmskey", msgPrinter.Sprintf("Add a public key or x509 certificate to the keys that the organization given by -o trusts for signing model management objects. Nodes that require signed objects only accept the objects signed with one of these keys."))
+	exOrgAddMMSKeyFile := exOrgAddMMSKeyCmd.Flag("public-key-file", msgPrinter.Sprintf("The path of the public key or x509 certificate file, for example the one created by 'hzn key create'. The key is stored under the base name of the file.")).Short('k').Required().ExistingFile()
+	exOrgListMMSKeyCmd := exOrgCmd.Command("listmmskey", msgPrinter.Sprintf("List the keys that the organization given by -o trusts for signing model management objects."))
+	exOrgListMMSKeyKey := exOrgListMMSKeyCmd.Arg("key-name", msgPrinter.Sprintf("The existing key name to see the contents of.")).String()
+	exOrgRemMMSKeyCmd := exOrgCmd.Command("removemmskey", msgPrinter.Sprintf("Remove a key from the keys that the organization given by -o trusts for signing model management objects."))
+	exOrgRemMMSKeyKey := exOrgRemMMSKeyCmd.Arg("key-name", msgPrinter.Sprintf("The existing key name to remove.")).Required().String()
 
 	exPatternCmd := exchangeCmd.Command("pattern", msgPrinter.Sprintf("List and manage patterns in the Horizon Exchange"))
 	exPatternListCmd := exPatternCmd.Command("list", msgPrinter.Sprintf("Display the pattern resources from the Horizon Exchange."))
@@ -455,6 +529,12 @@ Environment Variables:
 	exServiceListnode := exServiceCmd.Command("listnode", msgPrinter.Sprintf("Display the nodes that the service is running on."))
 	exServiceListnodeService := exServiceListnode.Arg("service", msgPrinter.Sprintf("The service id. Use <org>/<svc> to specify a service from a different org.")).Required().String()
 	exServiceListnodeNodeOrg := exServiceListnode.Flag("node-org", msgPrinter.Sprintf("The node's organization. If omitted, it will be same as the org specified by -o or HZN_ORG_ID.")).Short('O').String()
+	exServiceGraphCmd := exServiceCmd.Command("graph", msgPrinter.Sprintf("Display the resolved dependency graph of a service, and check it for dependency cycles, version ranges that no service satisfies, required services of a different arch and conflicting sharing modes. Every required service is resolved to the highest version within its version range, the same way the Horizon agent resolves it."))
+	exServiceGraphService := exServiceGraphCmd.Arg("service", msgPrinter.Sprintf("The url of the service. Use <org>/<url> to specify a public service in another org.")).Required().String()
+	exServiceGraphVersion := exServiceGraphCmd.Flag("version", msgPrinter.Sprintf("The version or version range of the service. If not specified, the highest version is used.")).Short('V').String()
+	exServiceGraphArch := exServiceGraphCmd.Flag("arch", msgPrinter.Sprintf("The arch of the service. If not specified, the graph contains every arch variant of the service.")).Short('a').String()
+	exServiceGraphFormat := exServiceGraphCmd.Flag("output", msgPrinter.Sprintf("The output format: tree, json or dot (the graphviz language).")).Default("tree").Enum("tree", "json", "dot")
+	exServiceGraphNodeIdTok := exServiceGraphCmd.Flag("node-id-tok", msgPrinter.Sprintf("The Horizon Exchange node ID and token to be used as credentials to query and modify the node resources if -u flag is not specified. HZN_EXCHANGE_NODE_AUTH will be used as a default for -n. If you don't prepend it with the node's org, it will automatically be prepended with the -o value.")).Short('n').PlaceHolder("ID:TOK").String()
 	exServiceListPolicyCmd := exServiceCmd.Command("listpolicy", msgPrinter.Sprintf("Display the service policy from the Horizon Exchange."))
 	exServiceListPolicyIdTok := exServiceListPolicyCmd.Flag("service-id-tok", msgPrinter.Sprintf("The Horizon Exchange id and password of the user")).Short('n').PlaceHolder("ID:TOK").String()
 	exServiceListPolicyService := exServiceListPolicyCmd.Arg("service", msgPrinter.Sprintf("List policy for this service.")).Required().String()
@@ -570,10 +650,17 @@ Environment Variables:
 	mmsObjectPublishSkipIntegrityCheck := mmsObjectPublishCmd.Flag("noIntegrity", msgPrinter.Sprintf("The publish command will not perform a data integrity check on the uploaded object data. It is mutually exclusive with --hashAlgo and --hash")).Bool()
 	mmsObjectPublishDSHashAlgo := mmsObjectPublishCmd.Flag("hashAlgo", msgPrinter.Sprintf("The hash algorithm used to hash the object data before signing it, ensuring data integrity during upload and download. Supported hash algorithms are SHA1 or SHA256, the default is SHA1. It is mutually exclusive with the --noIntegrity flag")).Short('a').String()
 	mmsObjectPublishDSHash := mmsObjectPublishCmd.Flag("hash", msgPrinter.Sprintf("The hash of the object data being uploaded or downloaded. Use this flag if you want to provide the hash instead of allowing the command to automatically calculate the hash. The hash must be generated using either the SHA1 or SHA256 algorithm. The -a flag must be specified if the hash was generated using SHA256. This flag is mutually exclusive with --noIntegrity.")).String()
+	mmsObjectPublishPrivKeyFile := mmsObjectPublishCmd.Flag("private-key-file", msgPrinter.Sprintf("The path of the private key file used to sign the object data. If not specified, the environment variable HZN_PRIVATE_KEY_FILE will be used. If none of them are set, ~/.hzn/keys/service.private.key is used when it exists, otherwise the data is signed with a one-time key, which nodes that require signed objects do not trust. It is mutually exclusive with --noIntegrity.")).Short('k').String()
+	mmsObjectPublishChunked := mmsObjectPublishCmd.Flag("chunked", msgPrinter.Sprintf("Split the object data into content-defined chunks that are published as separate objects of type {type}.chunks. The chunks already in the Model Management Service are not uploaded again, so an interrupted publish can be resumed by running the command again, and the nodes only download the chunks that changed since the previous version. The data of the object is the list of its chunks, 'hzn mms object download' assembles the chunks. The agent does not assemble the chunks: a service that gets the object gets the list of chunks as the object data, and must assemble the chunks itself. To keep existing services working, this flag can only be used, and must be used, with object types that end with .chunked. This flag requires -f and is mutually exclusive with --hash.")).Bool()
+	mmsObjectPublishChunkSize := mmsObjectPublishCmd.Flag("chunk-size", msgPrinter.Sprintf("The average size in bytes of the chunks when --chunked is specified, rounded up to a power of 2. The minimum is 65536, the default is 4194304. Smaller chunks make smaller updates but more objects.")).Int64()
 	mmsStatusCmd := mmsCmd.Command("status", msgPrinter.Sprintf("Display the status of the Horizon Model Management Service."))
 
 	nodeCmd := app.Command("node", msgPrinter.Sprintf("List and manage general information about this Horizon edge node."))
 	nodeListCmd := nodeCmd.Command("list", msgPrinter.Sprintf("Display general information about this Horizon edge node."))
+	nodeProposalCmd := nodeCmd.Command("proposal", msgPrinter.Sprintf("Evaluate agreement proposals on this Horizon edge node."))
+	nodeProposalTestCmd := nodeProposalCmd.Command("test", msgPrinter.Sprintf("Check whether this node would accept the proposal that an agbot would make for a deployment policy or pattern, and display every reason it would reject it. Nothing is persisted or started on the node."))
+	nodeProposalTestBPId := nodeProposalTestCmd.Flag("deployment-policy", msgPrinter.Sprintf("The Horizon exchange deployment policy ID, in the format of org/name. The node's org is used when the org is omitted. Mutually exclusive with -p.")).Short('b').String()
+	nodeProposalTestPattern := nodeProposalTestCmd.Flag("pattern", msgPrinter.Sprintf("The Horizon exchange pattern ID, in the format of org/name. The node's org is used when the org is omitted. Mutually exclusive with -b.")).Short('p').String()
 
 	policyCmd := app.Command("policy", msgPrinter.Sprintf("List and manage policy for this Horizon edge node."))
 	policyListCmd := policyCmd.Command("list", msgPrinter.Sprintf("Display this edge node's policy."))
@@ -606,6 +693,7 @@ Environment Variables:
 	waitServiceFlag := registerCmd.Flag("service", msgPrinter.Sprintf("Wait for the named service to start executing on this node. When registering with a pattern, use '*' to watch all the services in the pattern. When registering with a policy, '*' is not a valid value for -s. This flag is not supported for edge cluster nodes.")).Short('s').String()
 	waitServiceOrgFlag := registerCmd.Flag("serviceorg", msgPrinter.Sprintf("The org of the service to wait for on this node. If '-s *' is specified, then --serviceorg must be omitted.")).String()
 	waitTimeoutFlag := registerCmd.Flag("timeout", msgPrinter.Sprintf("The number of seconds for the --service to start. The default is 60 seconds, beginning when registration is successful. Ignored if --service is not specified.")).Short('t').Default("60").Int()
+	profileFlag := registerCmd.Flag("profile", msgPrinter.Sprintf("A registration profile with the settings of this command: a file, the name of a file in ~/.hzn/profiles without the .json extension, or the id of a registration_profile object in the Model Management Service of the node organization (-u is required to read it). The flags and arguments on the command line take precedence over the profile.")).String()
 
 	serviceCmd := app.Command("service", msgPrinter.Sprintf("List or manage the services that are currently registered on this Horizon edge node."))
 	serviceConfigStateCmd := serviceCmd.Command("configstate", msgPrinter.Sprintf("List or manage the configuration state for the services that are currently registered on this Horizon edge node."))
@@ -741,6 +829,8 @@ Environment Variables:
 			credToUse = cliutils.GetExchangeAuth(*exUserPw, *exServiceListKeyNodeIdTok)
 		case "service listauth":
 			credToUse = cliutils.GetExchangeAuth(*exUserPw, *exServiceListAuthNodeIdTok)
+		case "service graph":
+			credToUse = cliutils.GetExchangeAuth(*exUserPw, *exServiceGraphNodeIdTok)
 		case "pattern list":
 			credToUse = cliutils.GetExchangeAuth(*exUserPw, *exPatternListNodeIdTok)
 		case "pattern update":
@@ -794,6 +884,17 @@ Environment Variables:
 		}
 	}
 
+	if strings.HasPrefix(fullCmd, "apply") {
+		applyOrg = cliutils.RequiredWithDefaultEnvVar(applyOrg, "HZN_ORG_ID", msgPrinter.Sprintf("organization ID must be specified with either the -o flag or HZN_ORG_ID"))
+		applyUserPw = cliutils.RequiredWithDefaultEnvVar(applyUserPw, "HZN_EXCHANGE_USER_AUTH", msgPrinter.Sprintf("exchange user authentication must be specified with either the -u flag or HZN_EXCHANGE_USER_AUTH"))
+
+		if exVersion := exchange.LoadExchangeVersion(false, *applyOrg, *applyUserPw); exVersion != "" {
+			if err := version.VerifyExchangeVersion1(exVersion, false); err != nil {
+				cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, err.Error())
+			}
+		}
+	}
+
 	if strings.HasPrefix(fullCmd, "deploycheck") {
 		deploycheckOrg = cliutils.WithDefaultEnvVar(deploycheckOrg, "HZN_ORG_ID")
 		deploycheckUserPw = cliutils.WithDefaultEnvVar(deploycheckUserPw, "HZN_EXCHANGE_USER_AUTH")
@@ -890,6 +991,16 @@ Environment Variables:
 		exchange.OrgUpdate(*exOrg, *exUserPw, *exOrgUpdateOrg, *exOrgUpdateLabel, *exOrgUpdateDesc, *exOrgUpdateTags, *exOrgUpdateHBMin, *exOrgUpdateHBMax, *exOrgUpdateHBAdjust, *exOrgUpdateMaxNodes)
 	case exOrgDelCmd.FullCommand():
 		exchange.OrgDel(*exOrg, *exUserPw, *exOrgDelOrg, *exOrgDelFromAgbot, *exOrgDelForce)
+	case exOrgExportCmd.FullCommand():
+		exchange.OrgExport(*exOrg, *exUserPw, *exOrgExportOrg, *exOrgExportFile)
+	case exOrgImportCmd.FullCommand():
+		exchange.OrgImport(*exOrg, *exUserPw, *exOrgImportFile, *exOrgImportOverwrite, *exOrgImportPrivKeyFile, *exOrgImportPubKeyFile)
+	case exOrgAddMMSKeyCmd.FullCommand():
+		exchange.OrgAddMMSKey(*exOrg, *exUserPw, *exOrgAddMMSKeyFile)
+	case exOrgListMMSKeyCmd.FullCommand():
+		exchange.OrgListMMSKey(*exOrg, *exUserPw, *exOrgListMMSKeyKey)
+	case exOrgRemMMSKeyCmd.FullCommand():
+		exchange.OrgRemoveMMSKey(*exOrg, *exUserPw, *exOrgRemMMSKeyKey)
 
 	case exUserListCmd.FullCommand():
 		exchange.UserList(*exOrg, *exUserPw, *exUserListUser, *exUserListAll, *exUserListNamesOnly)
@@ -905,6 +1016,8 @@ Environment Variables:
 		exchange.NodeUpdate(*exOrg, credToUse, *exNodeUpdateNode, *exNodeUpdateJsonFile)
 	case exNodeCreateCmd.FullCommand():
 		exchange.NodeCreate(*exOrg, *exNodeCreateNodeIdTok, *exNodeCreateNode, *exNodeCreateToken, *exUserPw, *exNodeCreateNodeArch, *exNodeCreateNodeName, *exNodeCreateNodeType, true)
+	case exNodeProvisionCmd.FullCommand():
+		register.Provision(*exOrg, *exUserPw, *exNodeProvisionProfile, *exNodeProvisionFile, *exNodeProvisionOutput)
 	case exNodeSetTokCmd.FullCommand():
 		exchange.NodeSetToken(*exOrg, credToUse, *exNodeSetTokNode, *exNodeSetTokToken)
 	case exNodeConfirmCmd.FullCommand():
@@ -961,6 +1074,8 @@ Environment Variables:
 		exchange.PatternRemoveKey(*exOrg, *exUserPw, *exPatRemKeyPat, *exPatRemKeyKey)
 	case exServiceListCmd.FullCommand():
 		exchange.ServiceList(*exOrg, credToUse, *exService, !*exServiceLong, *exSvcOpYamlFilePath, *exSvcOpYamlForce)
+	case exServiceGraphCmd.FullCommand():
+		exchange.ServiceGraph(*exOrg, credToUse, *exServiceGraphService, *exServiceGraphVersion, *exServiceGraphArch, *exServiceGraphFormat)
 	case exServicePublishCmd.FullCommand():
 		exchange.ServicePublish(*exOrg, *exUserPw, *exSvcJsonFile, *exSvcPrivKeyFile, *exSvcPubPubKeyFile, *exSvcPubDontTouchImage, *exSvcPubPullImage, *exSvcRegistryTokens, *exSvcOverwrite, *exSvcPolicyFile, *exSvcPublic)
 	case exServiceVerifyCmd.FullCommand():
@@ -1002,7 +1117,7 @@ Environment Variables:
 	case regInputCmd.FullCommand():
 		register.CreateInputFile(*regInputOrg, *regInputPattern, *regInputArch, *regInputNodeIdTok, *regInputInputFile)
 	case registerCmd.FullCommand():
-		register.DoIt(*org, *pattern, *nodeIdTok, *userPw, *inputFile, *nodeOrgFlag, *patternFlag, *nodeName, *nodepolicyFlag, *waitServiceFlag, *waitServiceOrgFlag, *waitTimeoutFlag)
+		register.DoIt(*org, *pattern, *nodeIdTok, *userPw, *inputFile, *nodeOrgFlag, *patternFlag, *nodeName, *nodepolicyFlag, *waitServiceFlag, *waitServiceOrgFlag, *waitTimeoutFlag, *profileFlag)
 	case keyListCmd.FullCommand():
 		key.List(*keyName, *keyListAll)
 	case keyCreateCmd.FullCommand():
@@ -1013,6 +1128,8 @@ Environment Variables:
 		key.Remove(*keyDelName)
 	case nodeListCmd.FullCommand():
 		node.List()
+	case nodeProposalTestCmd.FullCommand():
+		node.ProposalTest(*nodeProposalTestBPId, *nodeProposalTestPattern)
 	case policyListCmd.FullCommand():
 		policy.List()
 	case policyNewCmd.FullCommand():
@@ -1029,10 +1146,14 @@ Environment Variables:
 		deploycheck.UserInputCompatible(*deploycheckOrg, *deploycheckUserPw, *userinputCompNodeId, *userinputCompNodeArch, *userinputCompNodeType, *userinputCompNodeUIFile, *userinputCompBPolId, *userinputCompBPolFile, *userinputCompPatternId, *userinputCompPatternFile, *userinputCompSvcFile, *deploycheckCheckAll, *deploycheckLong)
 	case allCompCmd.FullCommand():
 		deploycheck.AllCompatible(*deploycheckOrg, *deploycheckUserPw, *allCompNodeId, *allCompNodeArch, *allCompNodeType, *allCompNodePolFile, *allCompNodeUIFile, *allCompBPolId, *allCompBPolFile, *allCompPatternId, *allCompPatternFile, *allCompSPolFile, *allCompSvcFile, *deploycheckCheckAll, *deploycheckLong)
+	case whatifCmd.FullCommand():
+		deploycheck.WhatIf(*deploycheckOrg, *deploycheckUserPw, *whatifDepPolId, *whatifDepPolFile)
 	case agreementListCmd.FullCommand():
 		agreement.List(*listArchivedAgreements, *listAgreementId)
 	case agreementCancelCmd.FullCommand():
 		agreement.Cancel(*cancelAgreementId, *cancelAllAgreements)
+	case agreementTimelineCmd.FullCommand():
+		agreement.Timeline(*timelineAgreementId)
 	case meteringListCmd.FullCommand():
 		metering.List(*listArchivedMetering)
 	case attributeListCmd.FullCommand():
@@ -1073,6 +1194,8 @@ Environment Variables:
 		dev.ServiceStartTest(*devHomeDirectory, *devServiceUserInputFile, *devServiceConfigFile, *devServiceConfigType, *devServiceNoFSS, *devServiceStartCmdUserPw)
 	case devServiceStopTestCmd.FullCommand():
 		dev.ServiceStopTest(*devHomeDirectory)
+	case devServiceTestCmd.FullCommand():
+		scenario.Run(*devHomeDirectory, *devServiceTestScenario, *devServiceTestJUnit, *devServiceTestUserPw, *devServiceTestKeep)
 	case devServiceValidateCmd.FullCommand():
 		dev.ServiceValidate(*devHomeDirectory, *devServiceVerifyUserInputFile, []string{}, "", *devServiceValidateCmdUserPw)
 	case devServiceLogCmd.FullCommand():
@@ -1083,12 +1206,24 @@ Environment Variables:
 		dev.DependencyList(*devHomeDirectory)
 	case devDependencyRemoveCmd.FullCommand():
 		dev.DependencyRemove(*devHomeDirectory, *devDependencyCmdSpecRef, *devDependencyCmdURL, *devDependencyCmdVersion, *devDependencyCmdArch, *devDependencyCmdOrg)
+	case devDependencyGraphCmd.FullCommand():
+		dev.DependencyGraph(*devHomeDirectory, *devDependencyGraphFormat)
+	case devEnvUpCmd.FullCommand():
+		devenv.Up(*devEnvUpWorkDir, *devEnvUpOrg, *devEnvUpUserPw, *devEnvUpAnax, *devEnvUpExchangePort, *devEnvUpAgentPort, *devEnvUpAgbotPort, *devEnvUpNoFSS)
+	case devEnvDownCmd.FullCommand():
+		devenv.Down(*devEnvDownWorkDir, *devEnvDownPurge)
+	case applyCmd.FullCommand():
+		apply.Apply(*applyOrg, *applyUserPw, *applyDir, *applyPrune, *applyPlan, *applyForce, *applyPrivKeyFile, *applyPubKeyFile, *applyDontTouchImage, *applyPullImage, *applyNoConstraints)
 	case agbotAgreementListCmd.FullCommand():
 		agreementbot.AgreementList(*agbotlistArchivedAgreements, *agbotAgreement)
 	case agbotAgreementCancelCmd.FullCommand():
-		agreementbot.AgreementCancel(*agbotCancelAgreementId, *agbotCancelAllAgreements)
+		agreementbot.AgreementCancel(*agbotCancelAgreementId, *agbotCancelAllAgreements, *agbotCancelSelectors, *agbotCancelRenegotiate, *agbotCancelPreview)
+	case agbotAgreementJobCmd.FullCommand():
+		agreementbot.AgreementJobList(*agbotAgreementJobId)
 	case agbotListCmd.FullCommand():
 		agreementbot.List()
+	case agbotMeteringListCmd.FullCommand():
+		agreementbot.MeteringList(*agbotMeteringSince, *agbotMeteringUntil, *agbotMeteringFormat, *agbotMeteringView)
 	case agbotPolicyListCmd.FullCommand():
 		agreementbot.PolicyList(*agbotPolicyOrg, *agbotPolicyName)
 	case utilSignCmd.FullCommand():
@@ -1106,7 +1241,7 @@ Environment Variables:
 	case mmsObjectNewCmd.FullCommand():
 		sync_service.ObjectNew(*mmsOrg)
 	case mmsObjectPublishCmd.FullCommand():
-		sync_service.ObjectPublish(*mmsOrg, *mmsUserPw, *mmsObjectPublishType, *mmsObjectPublishId, *mmsObjectPublishPat, *mmsObjectPublishDef, *mmsObjectPublishObj, *mmsObjectPublishSkipIntegrityCheck, *mmsObjectPublishDSHashAlgo, *mmsObjectPublishDSHash)
+		sync_service.ObjectPublish(*mmsOrg, *mmsUserPw, *mmsObjectPublishType, *mmsObjectPublishId, *mmsObjectPublishPat, *mmsObjectPublishDef, *mmsObjectPublishObj, *mmsObjectPublishSkipIntegrityCheck, *mmsObjectPublishDSHashAlgo, *mmsObjectPublishDSHash, *mmsObjectPublishPrivKeyFile, *mmsObjectPublishChunked, *mmsObjectPublishChunkSize)
 	case mmsObjectDeleteCmd.FullCommand():
 		sync_service.ObjectDelete(*mmsOrg, *mmsUserPw, *mmsObjectDeleteType, *mmsObjectDeleteId)
 	case mmsObjectDownloadCmd.FullCommand():
@@ -1,7 +1,6 @@
 package key
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/cli/cliutils"
@@ -34,11 +33,7 @@ func List(keyName string, listAll bool) {
 	if keyName == "" && listAll {
 		var apiOutput KeyList
 		cliutils.HorizonGet("trust", []int{200}, &apiOutput, false)
-		jsonBytes, err := json.MarshalIndent(apiOutput.Pem, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'key list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(apiOutput.Pem, "'hzn key list'")
 	} else if keyName == "" {
 		// Getting all of the keys only returns the names
 		var apiOutput map[string][]api.KeyPairSimpleRecord
@@ -64,11 +59,7 @@ func List(keyName string, listAll bool) {
 			})
 		}
 
-		jsonBytes, err := json.MarshalIndent(certsSimpleOutput, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'key list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(certsSimpleOutput, "'hzn key list'")
 	} else {
 		// Get the content of 1 key, which is not json
 		var apiOutput string
@@ -1,8 +1,6 @@
 package metering
 
 import (
-	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/anax/persistence"
@@ -114,20 +112,12 @@ func List(archivedMetering bool) {
 		for i := range apiAgreements {
 			metering[i].CopyAgreementInto(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(metering, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn metering list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(metering, "'hzn metering list'")
 	} else {
 		metering := make([]ArchivedMetering, len(apiAgreements))
 		for i := range apiAgreements {
 			metering[i].CopyAgreementInto(apiAgreements[i])
 		}
-		jsonBytes, err := json.MarshalIndent(metering, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn metering list' output: %v", err))
-		}
-		fmt.Printf("%s\n", jsonBytes)
+		cliutils.Output(metering, "'hzn metering list'")
 	}
 }
@@ -1,7 +1,6 @@
 package node
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/apicommon"
@@ -9,6 +8,7 @@ import (
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/anax/version"
+	"net/url"
 	"strings"
 )
 
@@ -80,11 +80,7 @@ func List() {
 	nodeInfo.CopyStatusInto(&status)
 
 	// Output the combined info
-	jsonBytes, err := json.MarshalIndent(nodeInfo, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn node list' output: %v", err))
-	}
-	fmt.Printf("%s\n", jsonBytes) //todo: is there a way to output with json syntax highlighting like jq does?
+	cliutils.Output(nodeInfo, "'hzn node list'")
 }
 
 func Version() {
@@ -137,3 +133,25 @@ func Env(org, userPw, exchUrl, cssUrl, agbotUrl string) {
 	msgPrinter.Printf("HZN_AGBOT_URL: %s", agbotUrl)
 	msgPrinter.Println()
 }
+
+// Ask the agent whether it would accept the proposal that an agbot would send it for a deployment policy or pattern.
+func ProposalTest(deploymentPolicy string, pattern string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if (deploymentPolicy == "" && pattern == "") || (deploymentPolicy != "" && pattern != "") {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("Either -b or -p must be specified, but not both."))
+	}
+
+	query := fmt.Sprintf("deployment_policy=%v", url.QueryEscape(deploymentPolicy))
+	if pattern != "" {
+		query = fmt.Sprintf("pattern=%v", url.QueryEscape(pattern))
+	}
+
+	var out api.ProposalTest
+	if httpCode, _ := cliutils.HorizonGet("node/proposal/test?"+query, []int{200, 404}, &out, false); httpCode == 404 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("The node is not registered, or the deployment policy or pattern is not found in the exchange."))
+	}
+
+	cliutils.Output(out, "'hzn node proposal test'")
+}
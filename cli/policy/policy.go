@@ -16,12 +16,7 @@ func List() {
 	cliutils.HorizonGet("node/policy", []int{200}, &nodePolicy, false)
 
 	// Output the combined info
-	output, err := cliutils.DisplayAsJson(nodePolicy)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn policy list' output: %v", err))
-	}
-
-	fmt.Println(output)
+	cliutils.Output(nodePolicy, "'hzn policy list'")
 }
 
 func Update(fileName string) {
@@ -0,0 +1,154 @@
+package register
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-horizon/anax/cli/cliconfig"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/common"
+	"github.com/open-horizon/anax/externalpolicy"
+	"github.com/open-horizon/anax/i18n"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The MMS object type of the registration profiles stored in the model management service.
+const PROFILE_OBJECT_TYPE = "registration_profile"
+
+// The directory of the registration profiles stored as files, relative to the home directory.
+const PROFILE_DIR = ".hzn/profiles"
+
+// A registration profile holds the settings of 'hzn register' that are the same for a group of nodes, so that they
+// can be registered unattended with 'hzn register --profile'. The user input has the format of the input file of
+// 'hzn register -f'.
+type RegistrationProfile struct {
+	Name           string                         `json:"name"`
+	Description    string                         `json:"description,omitempty"`
+	NodeOrg        string                         `json:"nodeOrg,omitempty"`
+	Pattern        string                         `json:"pattern,omitempty"`
+	NodePolicy     *externalpolicy.ExternalPolicy `json:"nodePolicy,omitempty"`
+	UserInput      json.RawMessage                `json:"userInput,omitempty"`
+	WaitService    string                         `json:"waitService,omitempty"`
+	WaitServiceOrg string                         `json:"waitServiceOrg,omitempty"`
+	WaitTimeout    int                            `json:"waitTimeout,omitempty"`
+	ExchangeUrl    string                         `json:"exchangeUrl,omitempty"`
+	CssUrl         string                         `json:"cssUrl,omitempty"`
+}
+
+func (p RegistrationProfile) String() string {
+	return fmt.Sprintf("Name: %v, NodeOrg: %v, Pattern: %v, NodePolicy: %v, WaitService: %v, WaitServiceOrg: %v, WaitTimeout: %v, ExchangeUrl: %v, CssUrl: %v",
+		p.Name, p.NodeOrg, p.Pattern, p.NodePolicy, p.WaitService, p.WaitServiceOrg, p.WaitTimeout, p.ExchangeUrl, p.CssUrl)
+}
+
+// Validate checks the node policy and the user input of the profile, and the combination of its settings.
+func (p *RegistrationProfile) Validate() error {
+	if p.NodePolicy != nil {
+		if err := p.NodePolicy.ValidateAndNormalize(); err != nil {
+			return fmt.Errorf("invalid node policy: %v", err)
+		}
+	}
+	if _, err := p.GetUserInputFile(); err != nil {
+		return fmt.Errorf("invalid user input: %v", err)
+	}
+	if p.WaitService == "" && p.WaitServiceOrg != "" {
+		return fmt.Errorf("waitServiceOrg is set without waitService")
+	} else if p.WaitService == "*" && p.Pattern == "" {
+		return fmt.Errorf("waitService '*' is only valid with a pattern")
+	} else if p.WaitTimeout < 0 {
+		return fmt.Errorf("waitTimeout cannot be negative")
+	}
+	return nil
+}
+
+// GetUserInputFile returns the user input of the profile, or nil if it has none.
+func (p *RegistrationProfile) GetUserInputFile() (*common.UserInputFile, error) {
+	if len(p.UserInput) == 0 || string(p.UserInput) == "null" {
+		return nil, nil
+	}
+	return common.NewUserInputFileFromJsonBytes(p.UserInput)
+}
+
+// ReadProfile finds a registration profile by name. The name is first tried as a file path, then as a file in
+// ~/.hzn/profiles, and then as an object of type registration_profile in the model management service of the org.
+// The model management service is only tried when user credentials are given.
+func ReadProfile(name, org, userPw string) *RegistrationProfile {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	var data []byte
+	source := name
+	if _, err := os.Stat(name); err == nil {
+		data = cliconfig.ReadJsonFileWithLocalConfig(name)
+	} else if profileFile := filepath.Join(os.Getenv("HOME"), PROFILE_DIR, name+".json"); fileExists(profileFile) {
+		source = profileFile
+		data = cliconfig.ReadJsonFileWithLocalConfig(profileFile)
+	} else if userPw != "" && org != "" {
+		cliutils.SetWhetherUsingApiKey(userPw)
+		source = msgPrinter.Sprintf("object %v of type %v in org %v", name, PROFILE_OBJECT_TYPE, org)
+		urlPath := path.Join("api/v1/objects/", org, PROFILE_OBJECT_TYPE, name, "data")
+		if httpCode := cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &data); httpCode == 404 {
+			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("registration profile %v not found as a file, in %v, or in the model management service of org %v", name, filepath.Join("~", PROFILE_DIR), org))
+		}
+	} else {
+		cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("registration profile %v not found as a file or in %v. Specify the node org and -u to look it up in the model management service.", name, filepath.Join("~", PROFILE_DIR)))
+	}
+
+	profile := new(RegistrationProfile)
+	if err := json.Unmarshal(data, profile); err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to unmarshal registration profile %v: %v", source, err))
+	}
+	if profile.Name == "" {
+		profile.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+	if err := profile.Validate(); err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("registration profile %v is not valid: %v", source, err))
+	}
+	cliutils.Verbose(msgPrinter.Sprintf("Using registration profile from %v: %v", source, profile))
+	return profile
+}
+
+// Returns the register settings with the settings of the profile filled in where they are not given on the command
+// line. The pattern of the profile goes to the positional arguments when they are used, otherwise to the flags. The
+// wait settings of the profile are only used together, when the wait service is not given.
+func (p *RegistrationProfile) merge(org, pattern, nodeOrgFromFlag, patternFromFlag, waitService, waitOrg string, waitTimeout int) (string, string, string, string, string, int) {
+	if org != "" || pattern != "" {
+		if pattern == "" {
+			pattern = p.Pattern
+		}
+	} else {
+		if nodeOrgFromFlag == "" {
+			nodeOrgFromFlag = p.NodeOrg
+		}
+		if patternFromFlag == "" {
+			patternFromFlag = p.Pattern
+		}
+	}
+	if waitService == "" && p.WaitService != "" {
+		waitService = p.WaitService
+		if waitOrg == "" {
+			waitOrg = p.WaitServiceOrg
+		}
+		if p.WaitTimeout != 0 {
+			waitTimeout = p.WaitTimeout
+		}
+	}
+	return pattern, nodeOrgFromFlag, patternFromFlag, waitService, waitOrg, waitTimeout
+}
+
+// SetEndpoints makes the hzn command use the exchange and model management service of the profile, unless
+// HZN_EXCHANGE_URL or HZN_FSS_CSSURL are already set.
+func (p *RegistrationProfile) SetEndpoints() {
+	if p.ExchangeUrl != "" && os.Getenv("HZN_EXCHANGE_URL") == "" {
+		os.Setenv("HZN_EXCHANGE_URL", p.ExchangeUrl)
+	}
+	if p.CssUrl != "" && os.Getenv("HZN_FSS_CSSURL") == "" {
+		os.Setenv("HZN_FSS_CSSURL", p.CssUrl)
+	}
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
@@ -0,0 +1,75 @@
+// +build unit
+
+package register
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/externalpolicy"
+	_ "github.com/open-horizon/anax/externalpolicy/text_language"
+	"strings"
+	"testing"
+)
+
+func Test_RegistrationProfile_Validate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		profile RegistrationProfile
+		err     string
+	}{
+		{"empty", RegistrationProfile{Name: "p"}, ""},
+		{"full", RegistrationProfile{Name: "p", Pattern: "pat", NodePolicy: &externalpolicy.ExternalPolicy{Constraints: []string{"a == 1"}}, UserInput: json.RawMessage(`[{"serviceOrgid":"myorg","serviceUrl":"s","inputs":[{"name":"v","value":1}]}]`), WaitService: "*", WaitTimeout: 60}, ""},
+		{"null user input", RegistrationProfile{Name: "p", UserInput: json.RawMessage(`null`)}, ""},
+		{"bad node policy", RegistrationProfile{Name: "p", NodePolicy: &externalpolicy.ExternalPolicy{Constraints: []string{"a == "}}}, "invalid node policy"},
+		{"bad user input", RegistrationProfile{Name: "p", UserInput: json.RawMessage(`"s"`)}, "invalid user input"},
+		{"wait org without service", RegistrationProfile{Name: "p", WaitServiceOrg: "myorg"}, "waitServiceOrg is set without waitService"},
+		{"wait for all services without pattern", RegistrationProfile{Name: "p", WaitService: "*"}, "only valid with a pattern"},
+		{"negative timeout", RegistrationProfile{Name: "p", WaitTimeout: -1}, "cannot be negative"},
+	}
+
+	for _, test := range tests {
+		if err := test.profile.Validate(); test.err == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", test.name, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: expected error %v, got %v", test.name, test.err, err)
+		}
+	}
+
+}
+
+func Test_RegistrationProfile_merge(t *testing.T) {
+
+	profile := &RegistrationProfile{Name: "p", NodeOrg: "porg", Pattern: "ppat", WaitService: "psvc", WaitServiceOrg: "psvcorg", WaitTimeout: 120}
+
+	type settings struct {
+		org, pattern, nodeOrg, patternFlag, waitService, waitOrg string
+		waitTimeout                                              int
+	}
+	tests := []struct {
+		name     string
+		in       settings
+		expected settings
+	}{
+		{"profile only", settings{"", "", "", "", "", "", 60}, settings{"", "", "porg", "ppat", "psvc", "psvcorg", 120}},
+		{"flags win", settings{"", "", "forg", "fpat", "", "", 60}, settings{"", "", "forg", "fpat", "psvc", "psvcorg", 120}},
+		{"positional org", settings{"aorg", "", "", "", "", "", 60}, settings{"aorg", "ppat", "", "", "psvc", "psvcorg", 120}},
+		{"positional pattern wins", settings{"aorg", "apat", "", "", "", "", 60}, settings{"aorg", "apat", "", "", "psvc", "psvcorg", 120}},
+		{"wait service wins with its own org and timeout", settings{"", "", "", "", "fsvc", "", 60}, settings{"", "", "porg", "ppat", "fsvc", "", 60}},
+		{"wait org flag wins", settings{"", "", "", "", "", "fsvcorg", 60}, settings{"", "", "porg", "ppat", "psvc", "fsvcorg", 120}},
+	}
+
+	for _, test := range tests {
+		in := test.in
+		res := settings{org: in.org}
+		res.pattern, res.nodeOrg, res.patternFlag, res.waitService, res.waitOrg, res.waitTimeout = profile.merge(in.org, in.pattern, in.nodeOrg, in.patternFlag, in.waitService, in.waitOrg, in.waitTimeout)
+		if res != test.expected {
+			t.Errorf("%v: merged settings %+v, expected %+v", test.name, res, test.expected)
+		}
+	}
+
+	// A profile without wait settings keeps the timeout of the command line.
+	if _, _, _, waitService, _, waitTimeout := (&RegistrationProfile{Name: "p"}).merge("", "", "", "", "", "", 60); waitService != "" || waitTimeout != 60 {
+		t.Errorf("wrong wait settings %v %v", waitService, waitTimeout)
+	}
+
+}
@@ -0,0 +1,210 @@
+package register
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/open-horizon/anax/api"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The columns of the device list of Provision. Only the id is required.
+const (
+	PROVISION_COL_ID        = "id"
+	PROVISION_COL_TOKEN     = "token"
+	PROVISION_COL_NAME      = "name"
+	PROVISION_COL_ARCH      = "arch"
+	PROVISION_COL_NODE_TYPE = "nodeType"
+)
+
+// The status of each device in the output of Provision.
+const (
+	PROVISION_CREATED = "created"
+	PROVISION_EXISTS  = "exists"
+	PROVISION_FAILED  = "failed"
+)
+
+// A device to be pre-provisioned in the exchange.
+type ProvisionDevice struct {
+	Id       string
+	Token    string
+	Name     string
+	Arch     string
+	NodeType string
+}
+
+// ReadProvisionDevices reads a CSV device list. The first row names the columns, in any order.
+func ReadProvisionDevices(r io.Reader) ([]ProvisionDevice, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the device list is empty")
+	} else if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, col := range header {
+		cols[strings.TrimSpace(col)] = i
+	}
+	if _, ok := cols[PROVISION_COL_ID]; !ok {
+		return nil, fmt.Errorf("the first row of the device list must name the columns, and include %v", PROVISION_COL_ID)
+	}
+	value := func(record []string, col string) string {
+		if i, ok := cols[col]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	devices := []ProvisionDevice{}
+	ids := make(map[string]bool)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		dev := ProvisionDevice{Id: value(record, PROVISION_COL_ID), Token: value(record, PROVISION_COL_TOKEN), Name: value(record, PROVISION_COL_NAME), Arch: value(record, PROVISION_COL_ARCH), NodeType: value(record, PROVISION_COL_NODE_TYPE)}
+		if dev.Id == "" {
+			return nil, fmt.Errorf("line %v: the device id is empty", line)
+		} else if illegal, err := api.InputIsIllegal(dev.Id); err != nil || illegal != "" || strings.Contains(dev.Id, " ") {
+			return nil, fmt.Errorf("line %v: invalid device id %v", line, dev.Id)
+		} else if ids[dev.Id] {
+			return nil, fmt.Errorf("line %v: duplicate device id %v", line, dev.Id)
+		} else if dev.NodeType != "" && dev.NodeType != persistence.DEVICE_TYPE_DEVICE && dev.NodeType != persistence.DEVICE_TYPE_CLUSTER {
+			return nil, fmt.Errorf("line %v: wrong node type %v, it must be 'device' or 'cluster'", line, dev.NodeType)
+		}
+		ids[dev.Id] = true
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// Provision creates the exchange node of each device in a CSV device list, with the pattern, node policy and user
+// input of the registration profile, so that the devices can later be registered unattended with their node id and
+// token. Devices without a token get a random one. Devices that already exist in the exchange are left as they are.
+// The id, token and status of each device are written as CSV to the output file, or to stdout.
+func Provision(org, userPw, profileName, deviceFile, outputFile string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	profile := ReadProfile(profileName, org, userPw)
+	profile.SetEndpoints()
+	if profile.NodeOrg != "" && profile.NodeOrg != org {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("the registration profile is for org %v, not %v", profile.NodeOrg, org))
+	}
+
+	file, err := os.Open(deviceFile)
+	if err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to open the device list %v: %v", deviceFile, err))
+	}
+	defer file.Close()
+	devices, err := ReadProvisionDevices(file)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("invalid device list %v: %v", deviceFile, err))
+	}
+
+	// The global settings of the user input are set on the node when it registers, they cannot be stored in the exchange.
+	var userInput []policy.UserInput
+	if uif, _ := profile.GetUserInputFile(); uif != nil {
+		if !uif.IsGlobalsEmpty() {
+			cliutils.Warning(msgPrinter.Sprintf("the global user input settings of the registration profile are not stored in the exchange, they are set when the node registers with the profile."))
+		}
+		userInput, _ = uif.GetNewFormat(true)
+	}
+	pattern := ""
+	if profile.Pattern != "" {
+		pattern = cliutils.AddOrg(org, profile.Pattern)
+	}
+
+	out := os.Stdout
+	if outputFile != "" {
+		if out, err = os.OpenFile(outputFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to create the output file %v: %v", outputFile, err))
+		}
+		defer out.Close()
+	}
+	writer := csv.NewWriter(out)
+	writer.Write([]string{PROVISION_COL_ID, PROVISION_COL_TOKEN, "status"})
+
+	cliutils.SetWhetherUsingApiKey(userPw)
+	exchUrl := cliutils.GetExchangeUrl()
+	userOrg, userAuth := cliutils.TrimOrg(org, userPw)
+	creds := cliutils.OrgAndCreds(userOrg, userAuth)
+	counts := make(map[string]int)
+	for i := range devices {
+		dev := &devices[i]
+		status := provisionDevice(exchUrl, org, creds, dev, pattern, userInput, profile)
+		if strings.HasPrefix(status, PROVISION_FAILED) {
+			counts[PROVISION_FAILED]++
+		} else {
+			counts[status]++
+		}
+		token := dev.Token
+		if status != PROVISION_CREATED {
+			// only the tokens of the created nodes are valid
+			token = ""
+		}
+		writer.Write([]string{dev.Id, token, status})
+		writer.Flush()
+	}
+	if err := writer.Error(); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to write the provisioned devices: %v", err))
+	}
+
+	// The summary goes to stderr so that it is not mixed with the CSV written to stdout.
+	fmt.Fprintln(os.Stderr, msgPrinter.Sprintf("Provisioned %v devices in org %v with registration profile %v: %v created, %v already existed, %v failed.", len(devices), org, profile.Name, counts[PROVISION_CREATED], counts[PROVISION_EXISTS], counts[PROVISION_FAILED]))
+	if counts[PROVISION_FAILED] != 0 {
+		os.Exit(cliutils.CLI_GENERAL_ERROR)
+	}
+}
+
+// Creates the exchange node of one device and returns its status. A new token is stored in dev when the device
+// has none.
+func provisionDevice(exchUrl, org, creds string, dev *ProvisionDevice, pattern string, userInput []policy.UserInput, profile *RegistrationProfile) string {
+	var nodes exchange.GetDevicesResponse
+	if httpCode := cliutils.ExchangeGet("Exchange", exchUrl, "orgs/"+org+"/nodes/"+dev.Id, creds, []int{200, 404}, &nodes); httpCode == 200 {
+		return PROVISION_EXISTS
+	}
+
+	if dev.Token == "" {
+		token, err := cutil.SecureRandomString()
+		if err != nil {
+			return fmt.Sprintf("%v: could not create a random token", PROVISION_FAILED)
+		}
+		dev.Token = token
+	}
+	if dev.Name == "" {
+		dev.Name = dev.Id
+	}
+	if dev.NodeType == "" {
+		dev.NodeType = persistence.DEVICE_TYPE_DEVICE
+	}
+
+	var resp struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	putNodeReq := exchange.PutDeviceRequest{Token: dev.Token, Name: dev.Name, NodeType: dev.NodeType, Pattern: pattern, SoftwareVersions: make(map[string]string), PublicKey: []byte(""), Arch: dev.Arch, UserInput: userInput}
+	if httpCode := cliutils.ExchangePutPost("Exchange", http.MethodPut, exchUrl, "orgs/"+org+"/nodes/"+dev.Id+"?"+cliutils.NOHEARTBEAT_PARAM, creds, []int{201, 401, 403}, putNodeReq, &resp); httpCode != 201 {
+		return fmt.Sprintf("%v: %v", PROVISION_FAILED, resp.Msg)
+	}
+	if profile.NodePolicy != nil {
+		if httpCode := cliutils.ExchangePutPost("Exchange", http.MethodPut, exchUrl, "orgs/"+org+"/nodes/"+dev.Id+"/policy", creds, []int{201, 400, 403}, profile.NodePolicy, &resp); httpCode != 201 {
+			return fmt.Sprintf("%v: node policy: %v", PROVISION_FAILED, resp.Msg)
+		}
+	}
+	return PROVISION_CREATED
+}
@@ -0,0 +1,102 @@
+// +build unit
+
+package register
+
+import (
+	"encoding/json"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/externalpolicy"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ReadProvisionDevices(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		csv      string
+		expected []ProvisionDevice
+		err      string
+	}{
+		{"id only", "id\nd1\nd2\n", []ProvisionDevice{{Id: "d1"}, {Id: "d2"}}, ""},
+		{"columns in any order", "name, arch, id, token, nodeType\nn1, arm64, d1, t1, cluster\nn2,,d2,,\n", []ProvisionDevice{{Id: "d1", Token: "t1", Name: "n1", Arch: "arm64", NodeType: "cluster"}, {Id: "d2", Name: "n2"}}, ""},
+		{"short rows", "id,token,name\nd1\n", []ProvisionDevice{{Id: "d1"}}, ""},
+		{"header only", "id\n", []ProvisionDevice{}, ""},
+		{"empty", "", nil, "the device list is empty"},
+		{"missing id column", "token,name\nt1,n1\n", nil, "must name the columns, and include id"},
+		{"empty id", "id,name\n,n1\n", nil, "line 2: the device id is empty"},
+		{"invalid id", "id\nd1\nd 2\n", nil, "line 3: invalid device id"},
+		{"duplicate id", "id\nd1\nd1\n", nil, "line 3: duplicate device id d1"},
+		{"wrong node type", "id,nodeType\nd1,edge\n", nil, "line 2: wrong node type edge"},
+	}
+
+	for _, test := range tests {
+		if devices, err := ReadProvisionDevices(strings.NewReader(test.csv)); test.err == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", test.name, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: expected error %v, got %v", test.name, test.err, err)
+		} else if test.err == "" && !reflect.DeepEqual(devices, test.expected) {
+			t.Errorf("%v: read %v, expected %v", test.name, devices, test.expected)
+		}
+	}
+
+}
+
+func Test_provisionDevice(t *testing.T) {
+
+	dryRun := false
+	cliutils.Opts.IsDryRun = &dryRun
+
+	// The exchange has node d0, and records the nodes and node policies that are created.
+	nodes := make(map[string]exchange.PutDeviceRequest)
+	policies := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/orgs/myorg/nodes/")
+		if r.Method == http.MethodGet && path == "d0" {
+			w.Write([]byte(`{"nodes":{"myorg/d0":{}}}`))
+		} else if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+		} else if strings.HasSuffix(path, "/policy") {
+			policies[strings.TrimSuffix(path, "/policy")] = true
+			w.WriteHeader(http.StatusCreated)
+		} else if path == "bad" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"code":"access denied","msg":"no access"}`))
+		} else {
+			var req exchange.PutDeviceRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			nodes[path] = req
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	profile := &RegistrationProfile{Name: "p", NodePolicy: &externalpolicy.ExternalPolicy{Constraints: []string{"a == 1"}}}
+	devices := []ProvisionDevice{{Id: "d0"}, {Id: "d1"}, {Id: "d2"}, {Id: "d3", Token: "t3", Name: "n3", NodeType: "cluster"}, {Id: "bad"}}
+	expected := []string{PROVISION_EXISTS, PROVISION_CREATED, PROVISION_CREATED, PROVISION_CREATED, PROVISION_FAILED + ": no access"}
+	for i := range devices {
+		if status := provisionDevice(server.URL, "myorg", "myorg/u:pw", &devices[i], "myorg/pat", nil, profile); status != expected[i] {
+			t.Errorf("device %v: status %v, expected %v", devices[i].Id, status, expected[i])
+		}
+	}
+
+	// The devices without a token get a different random token each, the given token is kept.
+	if devices[0].Token != "" {
+		t.Errorf("expected no token for the existing device, got %v", devices[0].Token)
+	} else if len(devices[1].Token) < 64 || devices[1].Token == devices[2].Token {
+		t.Errorf("expected random tokens, got %v and %v", devices[1].Token, devices[2].Token)
+	} else if nodes["d1"].Token != devices[1].Token || nodes["d3"].Token != "t3" {
+		t.Errorf("wrong tokens in the exchange %v", nodes)
+	} else if nodes["d1"].Name != "d1" || nodes["d1"].NodeType != "device" || nodes["d1"].Pattern != "myorg/pat" {
+		t.Errorf("wrong default node settings %+v", nodes["d1"])
+	} else if nodes["d3"].Name != "n3" || nodes["d3"].NodeType != "cluster" {
+		t.Errorf("wrong node settings %+v", nodes["d3"])
+	} else if !policies["d1"] || !policies["d3"] || policies["d0"] || policies["bad"] {
+		t.Errorf("wrong node policies %v", policies)
+	}
+
+}
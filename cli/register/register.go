@@ -66,11 +66,28 @@ func ReadAndVerifyPolicFile(jsonFilePath string, nodePol *externalpolicy.Externa
 	}
 }
 
-// DoIt registers this node to Horizon with a pattern
-func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag string, patternFromFlag string, nodeName string, nodepolicyFlag string, waitService string, waitOrg string, waitTimeout int) {
+// DoIt registers this node to Horizon with a pattern. The settings that are not given on the command line are taken
+// from the registration profile, if one is specified.
+func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag string, patternFromFlag string, nodeName string, nodepolicyFlag string, waitService string, waitOrg string, waitTimeout int, profileName string) {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
+	var profile *RegistrationProfile
+	if profileName != "" {
+		profileOrg := nodeOrgFromFlag
+		if profileOrg == "" {
+			profileOrg = org
+		}
+		if profileOrg == "" {
+			profileOrg = os.Getenv("HZN_ORG_ID")
+		}
+		profile = ReadProfile(profileName, profileOrg, userPw)
+		msgPrinter.Printf("Using registration profile %v.", profile.Name)
+		msgPrinter.Println()
+		profile.SetEndpoints()
+		pattern, nodeOrgFromFlag, patternFromFlag, waitService, waitOrg, waitTimeout = profile.merge(org, pattern, nodeOrgFromFlag, patternFromFlag, waitService, waitOrg, waitTimeout)
+	}
+
 	// check the input
 	org, pattern, waitService, waitOrg = verifyRegisterParamters(org, pattern, nodeOrgFromFlag, patternFromFlag, waitService, waitOrg, nodeIdTok)
 
@@ -81,12 +98,20 @@ func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag str
 		msgPrinter.Println()
 		userInputFileObj = ReadUserInputFile(inputFile)
 		cliutils.Verbose(msgPrinter.Sprintf("Retrieved user input object from file %v: %v", inputFile, userInputFileObj))
+	} else if profile != nil {
+		// the user input of the profile was verified when the profile was read
+		userInputFileObj, _ = profile.GetUserInputFile()
 	}
 
 	// read and verify the node policy if it specified
 	var nodePol externalpolicy.ExternalPolicy
+	hasNodePol := false
 	if nodepolicyFlag != "" {
 		ReadAndVerifyPolicFile(nodepolicyFlag, &nodePol)
+		hasNodePol = true
+	} else if profile != nil && profile.NodePolicy != nil {
+		nodePol = *profile.NodePolicy
+		hasNodePol = true
 	}
 
 	// get the arch from anax
@@ -104,6 +129,14 @@ func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag str
 		msgPrinter.Println()
 	}
 
+	// The agent must use the model management service of the profile.
+	if profile != nil && profile.CssUrl != "" {
+		anaxMMSUrl := strings.TrimSuffix(cliutils.GetMMSUrlFromAnax(), "/")
+		if anaxMMSUrl != "" && anaxMMSUrl != strings.TrimSuffix(profile.CssUrl, "/") {
+			cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("registration profile %v uses the model management service url %s and the horizon agent is configured with %s. hzn register will not work with mismatched model management service urls.", profile.Name, profile.CssUrl, anaxMMSUrl))
+		}
+	}
+
 	timeout := 60
 	var timeoutStr string
 	cliutils.WithDefaultEnvVar(&timeoutStr, "HZN_REGISTER_HTTP_TIMEOUT")
@@ -250,7 +283,7 @@ func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag str
 	// Use the exchange node pattern if any
 	if pattern == "" {
 		if exchangePattern == "" {
-			if nodepolicyFlag == "" {
+			if !hasNodePol {
 				msgPrinter.Printf("No pattern or node policy is specified. Will proceeed with the existing node policy.")
 				msgPrinter.Println()
 			} else {
@@ -290,7 +323,7 @@ func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag str
 	}
 
 	// Update node policy if specified
-	if nodepolicyFlag != "" {
+	if hasNodePol {
 		msgPrinter.Printf("Updating the node policy...")
 		msgPrinter.Println()
 		cliutils.ExchangePutPost("Exchange", http.MethodPut, cliutils.GetExchangeUrl(), "orgs/"+org+"/nodes/"+nodeId+"/policy", cliutils.OrgAndCreds(org, nodeIdTok), []int{201}, nodePol, nil)
@@ -351,7 +384,7 @@ func DoIt(org, pattern, nodeIdTok, userPw, inputFile string, nodeOrgFromFlag str
 		}
 	}
 
-	if inputFile == "" {
+	if userInputFileObj == nil {
 		// Technically an input file is not required, but it is not the common case, so warn them
 		msgPrinter.Printf("Note: no input file was specified. This is only valid if none of the services need variables set.")
 		msgPrinter.Println()
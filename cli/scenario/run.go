@@ -0,0 +1,326 @@
+package scenario
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/cli/cliconfig"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/cli/dev"
+	"github.com/open-horizon/anax/cli/sync_service"
+	"github.com/open-horizon/anax/container"
+	"github.com/open-horizon/anax/i18n"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The timeout of each HTTP probe request, in seconds.
+const HTTP_PROBE_TIMEOUT = 10
+
+// Holds the context of a scenario run.
+type runner struct {
+	dir         string
+	scenarioDir string
+	org         string
+	noFSS       bool
+	cw          *container.ContainerWorker
+}
+
+// Run runs a scenario against the service project in homeDirectory. The service and its dependencies are started
+// with 'hzn dev service start' and stopped with 'hzn dev service stop', unless keep is set. The results are
+// displayed and written to the JUnit XML file when one is given. The command fails when any step fails.
+func Run(homeDirectory string, scenarioFile string, junitFile string, userCreds string, keep bool) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	sc, err := ParseScenario(cliconfig.ReadJsonFileWithLocalConfig(scenarioFile))
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("'%v %v' invalid scenario file %v: %v", dev.SERVICE_COMMAND, dev.SERVICE_TEST_COMMAND, scenarioFile, err))
+	}
+	absScenarioFile, err := filepath.Abs(scenarioFile)
+	if err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("'%v %v' %v", dev.SERVICE_COMMAND, dev.SERVICE_TEST_COMMAND, err))
+	}
+
+	dir, _, cw := dev.CommonExecutionSetup(homeDirectory, "", dev.SERVICE_COMMAND, dev.SERVICE_TEST_COMMAND)
+	serviceDef, err := dev.GetServiceDefinition(dir, dev.SERVICE_DEFINITION_FILE)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, "'%v %v' %v", dev.SERVICE_COMMAND, dev.SERVICE_TEST_COMMAND, err)
+	}
+	suiteName := sc.Name
+	if suiteName == "" {
+		suiteName = serviceDef.URL
+	}
+
+	r := &runner{dir: dir, scenarioDir: filepath.Dir(absScenarioFile), org: serviceDef.Org, noFSS: sc.NoFSS, cw: cw}
+	start := time.Now()
+	results := []Result{}
+
+	// Start the service and its dependencies.
+	startArgs := []string{"dev", dev.SERVICE_COMMAND, dev.SERVICE_START_COMMAND, "-d", dir}
+	if sc.UserInputFile != "" {
+		startArgs = append(startArgs, "-f", r.path(sc.UserInputFile))
+	}
+	if sc.NoFSS {
+		startArgs = append(startArgs, "-S")
+	}
+	if userCreds != "" {
+		startArgs = append(startArgs, "-u", userCreds)
+	}
+	msgPrinter.Printf("Starting service %v", serviceDef.URL)
+	msgPrinter.Println()
+	startResult := r.runHzn("start", startArgs...)
+	results = append(results, startResult)
+
+	// Wait for the ready probes, which share the ready timeout.
+	ready := !startResult.Failed()
+	readyTimeout := sc.ReadyTimeout
+	if readyTimeout == 0 {
+		readyTimeout = DEFAULT_READY_TIMEOUT
+	}
+	readyDeadline := time.Now().Add(time.Duration(readyTimeout) * time.Second)
+	for i := range sc.Ready {
+		name := "ready: " + sc.Ready[i].GetName()
+		if !ready {
+			results = append(results, Result{Name: name, Skipped: msgPrinter.Sprintf("the service did not start")})
+			continue
+		}
+		res := r.runStep(name, &sc.Ready[i], readyDeadline)
+		if res.Failed() {
+			res.Failure = msgPrinter.Sprintf("the service was not ready within %v seconds: %v", readyTimeout, res.Failure)
+			ready = false
+		}
+		results = append(results, res)
+	}
+
+	// Run the steps in order. A failed step does not stop the scenario.
+	for i := range sc.Steps {
+		name := sc.Steps[i].GetName()
+		if !ready {
+			results = append(results, Result{Name: name, Skipped: msgPrinter.Sprintf("the service is not ready")})
+			continue
+		}
+		deadline := time.Now().Add(time.Duration(sc.Steps[i].Timeout) * time.Second)
+		results = append(results, r.runStep(name, &sc.Steps[i], deadline))
+	}
+
+	// Tear down, even when the start failed, so that the partially started containers are removed.
+	if !keep {
+		results = append(results, r.runHzn("stop", "dev", dev.SERVICE_COMMAND, dev.SERVICE_STOP_COMMAND, "-d", dir))
+	}
+
+	failed := 0
+	for _, res := range results {
+		switch {
+		case res.Failed():
+			failed++
+			msgPrinter.Printf("FAIL %v (%.1fs): %v", res.Name, res.Duration.Seconds(), res.Failure)
+		case res.Skipped != "":
+			msgPrinter.Printf("SKIP %v: %v", res.Name, res.Skipped)
+		default:
+			msgPrinter.Printf("PASS %v (%.1fs)", res.Name, res.Duration.Seconds())
+		}
+		msgPrinter.Println()
+	}
+
+	if junitFile != "" {
+		if report, err := NewJUnitReport(suiteName, start, results); err != nil {
+			cliutils.Fatal(cliutils.INTERNAL_ERROR, msgPrinter.Sprintf("failed to create the JUnit report: %v", err))
+		} else if err := ioutil.WriteFile(junitFile, report, 0644); err != nil {
+			cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to write the JUnit report %v: %v", junitFile, err))
+		}
+		cliutils.Verbose(msgPrinter.Sprintf("JUnit report written to %v", junitFile))
+	}
+
+	if failed != 0 {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("scenario %v: %v of %v failed", suiteName, failed, len(results)))
+	}
+	msgPrinter.Printf("Scenario %v passed.", suiteName)
+	msgPrinter.Println()
+}
+
+// Runs hzn itself, so that a failure to start or stop the service is reported instead of ending the scenario.
+func (r *runner) runHzn(name string, args ...string) Result {
+	if cliutils.Opts.Verbose != nil && *cliutils.Opts.Verbose {
+		args = append([]string{"-v"}, args...)
+	}
+	cliutils.Verbose(i18n.GetMessagePrinter().Sprintf("running: %v %v", os.Args[0], strings.Join(args, " ")))
+
+	start := time.Now()
+	output, err := exec.Command(os.Args[0], args...).CombinedOutput()
+	res := Result{Name: name, Duration: time.Since(start), Output: string(output)}
+	if err != nil {
+		res.Failure = fmt.Sprintf("%v: %v", err, strings.TrimSpace(string(output)))
+	}
+	return res
+}
+
+// Runs a step, retrying it until it passes or the deadline expires.
+func (r *runner) runStep(name string, step *Step, deadline time.Time) Result {
+	start := time.Now()
+	var output string
+	var err error
+	for {
+		if output, err = r.attempt(step); err == nil || time.Now().After(deadline) {
+			break
+		}
+		cliutils.Verbose(i18n.GetMessagePrinter().Sprintf("%v: %v, retrying", name, err))
+		time.Sleep(STEP_RETRY_INTERVAL * time.Second)
+	}
+
+	res := Result{Name: name, Duration: time.Since(start), Output: output}
+	if err != nil {
+		res.Failure = err.Error()
+	}
+	return res
+}
+
+// Runs a step once, and verifies the output of the probes.
+func (r *runner) attempt(step *Step) (string, error) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	switch {
+	case step.Sleep != 0:
+		time.Sleep(time.Duration(step.Sleep) * time.Second)
+		return "", nil
+
+	case step.Object != nil:
+		if r.noFSS {
+			return "", errors.New(msgPrinter.Sprintf("objects cannot be published when the file sync service is not started"))
+		}
+		data := []byte(step.Object.Data)
+		if step.Object.File != "" {
+			var err error
+			if data, err = ioutil.ReadFile(r.path(step.Object.File)); err != nil {
+				return "", errors.New(msgPrinter.Sprintf("unable to read object file %v, error %v", step.Object.File, err))
+			}
+		}
+		return "", sync_service.PutObject(r.org, step.Object.Type, step.Object.Id, data)
+
+	case step.HTTP != nil:
+		code, output, err := r.httpProbe(step.HTTP)
+		if err != nil {
+			return output, err
+		}
+		return output, step.Expect.Check(true, code, output)
+
+	default:
+		code, output, err := r.execProbe(step.Exec)
+		if err != nil {
+			return output, err
+		}
+		return output, step.Expect.Check(false, code, output)
+	}
+}
+
+// Sends the HTTP request of a probe and returns the status code and the response body.
+func (r *runner) httpProbe(probe *HTTPProbe) (int, string, error) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	url := probe.URL
+	if url == "" {
+		c, err := r.getContainer(probe.Service)
+		if err != nil {
+			return 0, "", err
+		}
+		ip := containerIP(c)
+		if ip == "" {
+			return 0, "", errors.New(msgPrinter.Sprintf("the container of service %v has no IP address", probe.Service))
+		}
+		url = fmt.Sprintf("http://%v:%v/%v", ip, probe.Port, strings.TrimPrefix(probe.Path, "/"))
+	}
+	method := probe.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	cliutils.Verbose(method + " " + url)
+	req, err := http.NewRequest(method, url, strings.NewReader(probe.Body))
+	if err != nil {
+		return 0, "", errors.New(msgPrinter.Sprintf("unable to create the request %v %v, error %v", method, url, err))
+	}
+	for k, v := range probe.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := cliutils.GetHTTPClient(HTTP_PROBE_TIMEOUT).Do(req)
+	if err != nil {
+		return 0, "", errors.New(msgPrinter.Sprintf("%v %v failed, error %v", method, url, err))
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, "", errors.New(msgPrinter.Sprintf("unable to read the response of %v %v, error %v", method, url, err))
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// Runs the command of a probe in the container of a service and returns the exit code and the output.
+func (r *runner) execProbe(probe *ExecProbe) (int, string, error) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	c, err := r.getContainer(probe.Service)
+	if err != nil {
+		return 0, "", err
+	}
+	client := r.cw.GetClient()
+	ex, err := client.CreateExec(docker.CreateExecOptions{Container: c.ID, Cmd: probe.Command, AttachStdout: true, AttachStderr: true})
+	if err != nil {
+		return 0, "", errors.New(msgPrinter.Sprintf("unable to run %v in service %v, error %v", probe.Command, probe.Service, err))
+	}
+	var out bytes.Buffer
+	if err := client.StartExec(ex.ID, docker.StartExecOptions{OutputStream: &out, ErrorStream: &out}); err != nil {
+		return 0, out.String(), errors.New(msgPrinter.Sprintf("unable to run %v in service %v, error %v", probe.Command, probe.Service, err))
+	}
+	inspect, err := client.InspectExec(ex.ID)
+	if err != nil {
+		return 0, out.String(), errors.New(msgPrinter.Sprintf("unable to get the exit code of %v in service %v, error %v", probe.Command, probe.Service, err))
+	}
+	return inspect.ExitCode, out.String(), nil
+}
+
+// Returns the running container of a service of the project or of its dependencies.
+func (r *runner) getContainer(service string) (*docker.APIContainers, error) {
+	containers, err := dev.FindServiceContainers(service, r.cw)
+	if err != nil {
+		return nil, err
+	}
+	for i := range containers {
+		if containers[i].State == "running" {
+			return &containers[i], nil
+		}
+	}
+	return nil, errors.New(i18n.GetMessagePrinter().Sprintf("service %v has no running container", service))
+}
+
+// Returns the IP address of a container on the first of its networks, by name.
+func containerIP(c *docker.APIContainers) string {
+	names := make([]string, 0, len(c.Networks.Networks))
+	for name := range c.Networks.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ip := c.Networks.Networks[name].IPAddress; ip != "" {
+			return ip
+		}
+	}
+	return ""
+}
+
+// Returns the path of a file named in the scenario, relative to the scenario file.
+func (r *runner) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(r.scenarioDir, file)
+}
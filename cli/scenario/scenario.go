@@ -0,0 +1,315 @@
+package scenario
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/i18n"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The number of seconds to wait for the service to be ready when the scenario does not say.
+const DEFAULT_READY_TIMEOUT = 60
+
+// The number of seconds between the attempts of a step that has a timeout.
+const STEP_RETRY_INTERVAL = 2
+
+// A scenario run by 'hzn dev service test'. The service project is started with its dependencies, the ready probes
+// are retried until they all pass or the ready timeout expires, and then the steps are run in order. The service
+// is stopped at the end, whatever the outcome.
+type Scenario struct {
+	Name          string `json:"name"`
+	UserInputFile string `json:"userInputFile,omitempty"` // relative to the scenario file
+	NoFSS         bool   `json:"noFSS,omitempty"`
+	ReadyTimeout  int    `json:"readyTimeout,omitempty"` // seconds
+	Ready         []Step `json:"ready,omitempty"`
+	Steps         []Step `json:"steps"`
+}
+
+// A step of a scenario does exactly one of: an HTTP request, a command in a service container, the publication of an
+// MMS object, or a pause. The output of the probes is verified against the expectation. A step with a timeout is
+// retried until its expectation is met or the timeout expires.
+type Step struct {
+	Name    string       `json:"name"`
+	HTTP    *HTTPProbe   `json:"http,omitempty"`
+	Exec    *ExecProbe   `json:"exec,omitempty"`
+	Object  *ObjectInput `json:"object,omitempty"`
+	Sleep   int          `json:"sleep,omitempty"`   // seconds
+	Timeout int          `json:"timeout,omitempty"` // seconds
+	Expect  *Expectation `json:"expect,omitempty"`
+}
+
+// An HTTP request to a url, or to a port of a service container on its docker network.
+type HTTPProbe struct {
+	URL     string            `json:"url,omitempty"`
+	Service string            `json:"service,omitempty"`
+	Port    int               `json:"port,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// A command run in the container of a service.
+type ExecProbe struct {
+	Service string   `json:"service"`
+	Command []string `json:"command"`
+}
+
+// An MMS object published in the local file sync service, with the content of a file or inline data.
+type ObjectInput struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+	File string `json:"file,omitempty"` // relative to the scenario file
+	Data string `json:"data,omitempty"`
+}
+
+// The expected result of a probe. The HTTP status code defaults to 200 and the exit code of a command to 0.
+type Expectation struct {
+	Status      int      `json:"status,omitempty"`
+	ExitCode    *int     `json:"exitCode,omitempty"`
+	Contains    []string `json:"contains,omitempty"`
+	NotContains []string `json:"notContains,omitempty"`
+	Matches     string   `json:"matches,omitempty"`
+}
+
+func (s Scenario) String() string {
+	return fmt.Sprintf("Name: %v, UserInputFile: %v, NoFSS: %v, ReadyTimeout: %v, Ready: %v, Steps: %v", s.Name, s.UserInputFile, s.NoFSS, s.ReadyTimeout, s.Ready, s.Steps)
+}
+
+// ParseScenario parses and validates a scenario.
+func ParseScenario(data []byte) (*Scenario, error) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	s := new(Scenario)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, errors.New(msgPrinter.Sprintf("unable to unmarshal the scenario, error %v", err))
+	} else if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scenario) Validate() error {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if s.ReadyTimeout < 0 {
+		return errors.New(msgPrinter.Sprintf("the ready timeout cannot be negative"))
+	}
+	for i, step := range s.Ready {
+		if step.HTTP == nil && step.Exec == nil {
+			return errors.New(msgPrinter.Sprintf("ready probe %v must be an http or exec probe", i+1))
+		} else if err := step.Validate(); err != nil {
+			return errors.New(msgPrinter.Sprintf("ready probe %v: %v", i+1, err))
+		}
+	}
+	if len(s.Steps) == 0 {
+		return errors.New(msgPrinter.Sprintf("the scenario has no steps"))
+	}
+	for i, step := range s.Steps {
+		if err := step.Validate(); err != nil {
+			return errors.New(msgPrinter.Sprintf("step %v: %v", i+1, err))
+		}
+	}
+	return nil
+}
+
+func (s *Step) Validate() error {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	actions := 0
+	if s.HTTP != nil {
+		actions++
+		if s.HTTP.URL == "" && (s.HTTP.Service == "" || s.HTTP.Port == 0) {
+			return errors.New(msgPrinter.Sprintf("the http probe must have a url, or a service and a port"))
+		} else if s.HTTP.URL != "" && s.HTTP.Service != "" {
+			return errors.New(msgPrinter.Sprintf("the http probe cannot have both a url and a service"))
+		}
+	}
+	if s.Exec != nil {
+		actions++
+		if s.Exec.Service == "" || len(s.Exec.Command) == 0 {
+			return errors.New(msgPrinter.Sprintf("the exec probe must have a service and a command"))
+		}
+	}
+	if s.Object != nil {
+		actions++
+		if s.Object.Type == "" || s.Object.Id == "" {
+			return errors.New(msgPrinter.Sprintf("the object must have a type and an id"))
+		} else if s.Object.File != "" && s.Object.Data != "" {
+			return errors.New(msgPrinter.Sprintf("the object cannot have both a file and data"))
+		}
+	}
+	if s.Sleep != 0 {
+		actions++
+		if s.Sleep < 0 {
+			return errors.New(msgPrinter.Sprintf("the sleep time cannot be negative"))
+		}
+	}
+	if actions != 1 {
+		return errors.New(msgPrinter.Sprintf("a step must have exactly one of http, exec, object or sleep"))
+	}
+	if s.Timeout < 0 {
+		return errors.New(msgPrinter.Sprintf("the timeout cannot be negative"))
+	}
+	if s.Expect != nil {
+		if s.HTTP == nil && s.Exec == nil {
+			return errors.New(msgPrinter.Sprintf("only the http and exec probes can have an expectation"))
+		} else if s.Exec != nil && s.Expect.Status != 0 {
+			return errors.New(msgPrinter.Sprintf("an exec probe cannot expect an http status"))
+		} else if s.HTTP != nil && s.Expect.ExitCode != nil {
+			return errors.New(msgPrinter.Sprintf("an http probe cannot expect an exit code"))
+		} else if _, err := regexp.Compile(s.Expect.Matches); err != nil {
+			return errors.New(msgPrinter.Sprintf("invalid regular expression %v, error %v", s.Expect.Matches, err))
+		}
+	}
+	return nil
+}
+
+// GetName returns the name of the step, or a description of what it does.
+func (s *Step) GetName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	switch {
+	case s.HTTP != nil && s.HTTP.URL != "":
+		return fmt.Sprintf("http %v", s.HTTP.URL)
+	case s.HTTP != nil:
+		return fmt.Sprintf("http %v:%v%v", s.HTTP.Service, s.HTTP.Port, s.HTTP.Path)
+	case s.Exec != nil:
+		return fmt.Sprintf("exec %v %v", s.Exec.Service, strings.Join(s.Exec.Command, " "))
+	case s.Object != nil:
+		return fmt.Sprintf("object %v/%v", s.Object.Type, s.Object.Id)
+	default:
+		return fmt.Sprintf("sleep %v", s.Sleep)
+	}
+}
+
+// Check verifies the result of an HTTP probe, whose code is the status code, or of an exec probe, whose code is the
+// exit code. A nil expectation only checks the code.
+func (e *Expectation) Check(isHTTP bool, code int, output string) error {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	if e == nil {
+		e = &Expectation{}
+	}
+	if isHTTP {
+		expected := e.Status
+		if expected == 0 {
+			expected = 200
+		}
+		if code != expected {
+			return errors.New(msgPrinter.Sprintf("expected http status %v, got %v", expected, code))
+		}
+	} else {
+		expected := 0
+		if e.ExitCode != nil {
+			expected = *e.ExitCode
+		}
+		if code != expected {
+			return errors.New(msgPrinter.Sprintf("expected exit code %v, got %v", expected, code))
+		}
+	}
+	for _, c := range e.Contains {
+		if !strings.Contains(output, c) {
+			return errors.New(msgPrinter.Sprintf("the output does not contain %q", c))
+		}
+	}
+	for _, c := range e.NotContains {
+		if strings.Contains(output, c) {
+			return errors.New(msgPrinter.Sprintf("the output contains %q", c))
+		}
+	}
+	if e.Matches != "" {
+		if re, err := regexp.Compile(e.Matches); err != nil {
+			return errors.New(msgPrinter.Sprintf("invalid regular expression %v, error %v", e.Matches, err))
+		} else if !re.MatchString(output) {
+			return errors.New(msgPrinter.Sprintf("the output does not match %v", e.Matches))
+		}
+	}
+	return nil
+}
+
+// The JUnit XML report of a scenario run, in the format understood by most CI systems.
+type JUnitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	Skipped   *JUnitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type JUnitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// The outcome of one phase or step of a scenario run.
+type Result struct {
+	Name     string
+	Duration time.Duration
+	Failure  string
+	Skipped  string
+	Output   string
+}
+
+func (r Result) Failed() bool {
+	return r.Failure != ""
+}
+
+// NewJUnitReport creates the JUnit XML report of the results of a scenario.
+func NewJUnitReport(suiteName string, start time.Time, results []Result) ([]byte, error) {
+	suite := JUnitTestSuite{Name: suiteName, Timestamp: start.UTC().Format(time.RFC3339), TestCases: []JUnitTestCase{}}
+	var total time.Duration
+	for _, r := range results {
+		tc := JUnitTestCase{Name: r.Name, Classname: suiteName, Time: junitSeconds(r.Duration), SystemOut: r.Output}
+		if r.Failed() {
+			tc.Failure = &JUnitFailure{Message: r.Failure, Text: r.Failure}
+			suite.Failures++
+		} else if r.Skipped != "" {
+			tc.Skipped = &JUnitSkipped{Message: r.Skipped}
+			suite.Skipped++
+		}
+		suite.Tests++
+		total += r.Duration
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Time = junitSeconds(total)
+
+	out, err := xml.MarshalIndent(JUnitTestSuites{Suites: []JUnitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,82 @@
+// +build unit
+
+package scenario
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseScenario(t *testing.T) {
+
+	good := `{"name": "gps", "ready": [{"http": {"service": "gps", "port": 8080, "path": "/health"}}],
+		"steps": [{"object": {"type": "model", "id": "m1", "data": "abc"}},
+			{"name": "location", "http": {"url": "http://localhost:8080/v1/location"}, "timeout": 10, "expect": {"contains": ["lat"]}},
+			{"exec": {"service": "gps", "command": ["ls", "/models"]}, "expect": {"exitCode": 0, "matches": "m[0-9]"}},
+			{"sleep": 2}]}`
+	if s, err := ParseScenario([]byte(good)); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(s.Steps) != 4 || s.Steps[1].GetName() != "location" || s.Steps[2].GetName() != "exec gps ls /models" {
+		t.Errorf("wrong scenario %v", s)
+	}
+
+	bad := map[string]string{
+		"no steps":          `{"steps": []}`,
+		"two actions":       `{"steps": [{"sleep": 1, "exec": {"service": "gps", "command": ["ls"]}}]}`,
+		"no action":         `{"steps": [{"name": "nothing"}]}`,
+		"no port":           `{"steps": [{"http": {"service": "gps"}}]}`,
+		"exec status":       `{"steps": [{"exec": {"service": "gps", "command": ["ls"]}, "expect": {"status": 200}}]}`,
+		"object expect":     `{"steps": [{"object": {"type": "t", "id": "i"}, "expect": {"contains": ["x"]}}]}`,
+		"bad regexp":        `{"steps": [{"http": {"url": "http://x"}, "expect": {"matches": "("}}]}`,
+		"ready object":      `{"ready": [{"object": {"type": "t", "id": "i"}}], "steps": [{"sleep": 1}]}`,
+		"negative timeout":  `{"steps": [{"sleep": 1, "timeout": -1}]}`,
+		"not a json object": `[]`,
+	}
+	for name, sc := range bad {
+		if _, err := ParseScenario([]byte(sc)); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func Test_Expectation_Check(t *testing.T) {
+
+	var none *Expectation
+	if err := none.Check(true, 200, ""); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if err := none.Check(true, 503, ""); err == nil {
+		t.Errorf("expected an error for status 503")
+	} else if err := none.Check(false, 1, ""); err == nil {
+		t.Errorf("expected an error for exit code 1")
+	}
+
+	one := 1
+	e := &Expectation{ExitCode: &one, Contains: []string{"not found"}, NotContains: []string{"panic"}, Matches: "^ls: .*"}
+	if err := e.Check(false, 1, "ls: /models: not found"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if err := e.Check(false, 1, "ls: /models: panic not found"); err == nil {
+		t.Errorf("expected an error for the unwanted output")
+	} else if err := e.Check(false, 1, "error: not found"); err == nil {
+		t.Errorf("expected an error for the regular expression")
+	}
+}
+
+func Test_NewJUnitReport(t *testing.T) {
+
+	results := []Result{
+		{Name: "start", Duration: 1500 * time.Millisecond},
+		{Name: "location", Duration: time.Second, Failure: "expected http status 200, got 503", Output: "<html>"},
+		{Name: "model", Skipped: "the service is not ready"},
+	}
+	report, err := NewJUnitReport("gps", time.Now(), results)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	xml := string(report)
+	for _, s := range []string{`<testsuite name="gps" tests="3" failures="1" skipped="1" time="2.500"`, `<testcase name="start" classname="gps" time="1.500"></testcase>`, `<failure message="expected http status 200, got 503">`, `<system-out>&lt;html&gt;</system-out>`, `<skipped message="the service is not ready"></skipped>`} {
+		if !strings.Contains(xml, s) {
+			t.Errorf("the report does not contain %v: %v", s, xml)
+		}
+	}
+}
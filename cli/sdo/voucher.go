@@ -65,8 +65,7 @@ func VoucherInspect(voucherFile *os.File) {
 		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("parsing the json from %s: %v", voucherFile.Name(), err))
 	}
 
-	output := cliutils.MarshalIndent(outStruct, "voucher inspect")
-	fmt.Println(output)
+	cliutils.Output(outStruct, "'hzn voucher inspect'")
 }
 
 func parseVoucherBytes(voucherBytes []byte, outStruct *InspectOutput) error {
@@ -192,7 +191,7 @@ func getVouchers(org, userCreds, apiMsg string, voucher string) ([]byte, string)
 }
 
 // called when the -l flag is used to list the full voucher
-func listFullVoucher(respBodyBytes []byte, apiMsg string) []byte {
+func listFullVoucher(respBodyBytes []byte, apiMsg string) interface{} {
 	msgPrinter := i18n.GetMessagePrinter()
 	cliutils.Verbose(msgPrinter.Sprintf("Listing imported SDO vouchers."))
 
@@ -203,12 +202,7 @@ func listFullVoucher(respBodyBytes []byte, apiMsg string) []byte {
 		cliutils.Fatal(cliutils.HTTP_ERROR, msgPrinter.Sprintf("json unmarshalling HTTP response '%s' from %s: %v", string(respBodyBytes), apiMsg, err))
 	}
 
-	jsonBytes, err := json.MarshalIndent(output, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange service list' output: %v", err))
-	}
-
-	return jsonBytes
+	return output
 }
 
 // list the all the uploaded SDO vouchers, or a single voucher
@@ -229,20 +223,22 @@ func VoucherList(org, userCreds, voucher string, namesOnly bool) {
 			cliutils.Fatal(cliutils.HTTP_ERROR, msgPrinter.Sprintf("json unmarshalling HTTP response '%s' from %s: %v", string(respBodyBytes), apiMsg, err))
 		}
 
-		jsonBytes, err := json.MarshalIndent(output, "", cliutils.JSON_INDENT)
-		if err != nil {
-			cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange service list' output: %v", err))
-		}
-
 		// list only the uuid's of imported vouchers
 		if namesOnly {
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(output, "'hzn voucher list'")
+
+		} else if !cliutils.IsDefaultOutput() { // list full details of all imported vouchers as one list
+			vouchers := make([]interface{}, 0, len(output))
+			for i := range output {
+				respBodyBytes, apiMsg = getVouchers(org, userCreds, apiMsg, output[i])
+				vouchers = append(vouchers, listFullVoucher(respBodyBytes, apiMsg))
+			}
+			cliutils.Output(vouchers, "'hzn voucher list'")
 
 		} else { // list full details of all imported vouchers
 			for i := range output {
 				respBodyBytes, apiMsg = getVouchers(org, userCreds, apiMsg, output[i])
-				jsonBytes := listFullVoucher(respBodyBytes, apiMsg)
-				fmt.Printf("%s\n", jsonBytes)
+				cliutils.Output(listFullVoucher(respBodyBytes, apiMsg), "'hzn voucher list'")
 				fmt.Printf("\n")
 			}
 		}
@@ -258,15 +254,10 @@ func VoucherList(org, userCreds, voucher string, namesOnly bool) {
 				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("parsing the json from %s: %v", voucher, err))
 			}
 
-			jsonBytes, err := json.MarshalIndent(vouch, "", cliutils.JSON_INDENT)
-			if err != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn exchange service list' output: %v", err))
-			}
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(vouch, "'hzn voucher list'")
 
 		} else { // list full details of voucher
-			jsonBytes := listFullVoucher(respBodyBytes, apiMsg)
-			fmt.Printf("%s\n", jsonBytes)
+			cliutils.Output(listFullVoucher(respBodyBytes, apiMsg), "'hzn voucher list'")
 		}
 	}
 }
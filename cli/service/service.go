@@ -2,7 +2,6 @@ package service
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/open-horizon/anax/api"
 	"github.com/open-horizon/anax/apicommon"
 	"github.com/open-horizon/anax/cli/cliutils"
@@ -73,11 +72,12 @@ func List() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(services, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn service list' output: %v", err))
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.Output(services, "'hzn service list'",
+		cliutils.NewOutputColumn("ORG", ".org"),
+		cliutils.NewOutputColumn("URL", ".url"),
+		cliutils.NewOutputColumn("VERSION", ".version"),
+		cliutils.NewOutputColumn("ARCH", ".arch"),
+		cliutils.NewWideOutputColumn("VARIABLES", ".variables"))
 }
 
 func Log(serviceName string, tailing bool) {
@@ -166,11 +166,7 @@ func Registered() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(apiOutput, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn service registered' output: %v", err))
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.Output(apiOutput, "'hzn service registered'")
 }
 
 func ListConfigState() {
@@ -184,11 +180,7 @@ func ListConfigState() {
 	}
 
 	// Convert to json and output
-	jsonBytes, err := json.MarshalIndent(apiOutput, "", cliutils.JSON_INDENT)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal 'hzn service configstate' output: %v", err))
-	}
-	fmt.Printf("%s\n", jsonBytes)
+	cliutils.Output(apiOutput, "'hzn service configstate'")
 }
 
 func Suspend(forceSuspend bool, applyAll bool, serviceOrg string, serviceUrl string) {
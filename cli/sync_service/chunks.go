@@ -0,0 +1,298 @@
+package sync_service
+
+import (
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/config"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/objectchunks"
+	"github.com/open-horizon/edge-sync-service/common"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// A chunked object is published as one MMS object per chunk, of type {objectType}.chunks and id {objectId}_{chunkHash},
+// and the object itself with a manifest of the chunks as its data. The chunks that are already in the MMS are not
+// uploaded again, so an interrupted publish resumes where it stopped, and a new version of the object only adds the
+// chunks that changed. The nodes get the new chunks only. The chunks of the previous version are kept until the next
+// version is published, so that the nodes which are still assembling the previous version can finish it.
+
+// Split the object file into chunks and publish the chunks that are not in the MMS yet. The chunks have the same
+// destinations as the object and are signed with the same key. Returns the manifest and the name of a temporary file
+// containing it, which the caller publishes as the object data and removes.
+func publishChunks(org string, userPw string, objectMeta common.MetaData, objFile string, avgChunkSize int64, hashAlgorithm string, privateKey *rsa.PrivateKey) (*objectchunks.Manifest, string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	file, err := os.Open(objFile)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("unable to open object file %v: %v", objFile, err))
+	}
+	defer file.Close()
+
+	msgPrinter.Printf("Splitting the file into chunks...")
+	msgPrinter.Println()
+	manifest, err := objectchunks.NewManifest(file, avgChunkSize)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("failed to split the file %v into chunks: %v", objFile, err))
+	}
+
+	// Publish each chunk once, even if it is several times in the data.
+	chunks := manifest.Missing(nil)
+	uploaded := 0
+	for i, c := range chunks {
+		cliutils.Verbose(msgPrinter.Sprintf("Publishing chunk %v of %v: %v", i+1, len(chunks), c))
+		if publishChunk(org, userPw, objectMeta, file, c, hashAlgorithm, privateKey) {
+			uploaded++
+		}
+	}
+	msgPrinter.Printf("Object data split into %v chunks, %v chunks uploaded, %v chunks already in the Model Management Service.", len(chunks), uploaded, len(chunks)-uploaded)
+	msgPrinter.Println()
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, msgPrinter.Sprintf("failed to marshal the chunk manifest: %v", err))
+	}
+	manifestFile, err := ioutil.TempFile("", "hzn-manifest-")
+	if err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to create the chunk manifest file: %v", err))
+	}
+	defer manifestFile.Close()
+	if _, err := manifestFile.Write(manifestBytes); err != nil {
+		os.Remove(manifestFile.Name())
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to write the chunk manifest file %v: %v", manifestFile.Name(), err))
+	}
+
+	return manifest, manifestFile.Name()
+}
+
+// Publish one chunk of the object, unless it is already in the MMS with its data. When the chunk is there but the
+// destinations of the object or the signing key changed, only its metadata is updated. Returns true if the chunk data
+// was uploaded.
+func publishChunk(org string, userPw string, objectMeta common.MetaData, file *os.File, c objectchunks.Chunk, hashAlgorithm string, privateKey *rsa.PrivateKey) bool {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	data := make([]byte, c.Size)
+	if _, err := file.ReadAt(data, c.Offset); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("unable to read chunk at offset %v of object file %v: %v", c.Offset, file.Name(), err))
+	}
+
+	chunkMeta := objectMeta
+	chunkMeta.ObjectType = objectchunks.ChunkType(objectMeta.ObjectType)
+	chunkMeta.ObjectID = objectchunks.ChunkId(objectMeta.ObjectID, c.Hash)
+	chunkMeta.Description = msgPrinter.Sprintf("Chunk of object %v", objectMeta.ObjectID)
+	chunkMeta.Link, chunkMeta.DestinationDataURI, chunkMeta.SourceDataURI = "", "", ""
+	chunkMeta.MetaOnly, chunkMeta.NoData = false, false
+
+	if hashAlgorithm != "" {
+		if dataHash, err := GetHash(hashAlgorithm); err != nil {
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, err.Error())
+		} else if _, err := dataHash.Write(data); err != nil {
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, err.Error())
+		} else if publicKey, signature, err := signHashSum(dataHash.Sum(nil), hashAlgorithm, privateKey); err != nil {
+			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("failed to digital sign chunk %v, Error: %v", c.Hash, err))
+		} else {
+			chunkMeta.HashAlgorithm, chunkMeta.PublicKey, chunkMeta.Signature = hashAlgorithm, publicKey, signature
+		}
+	}
+
+	urlPath := path.Join("api/v1/objects/", org, chunkMeta.ObjectType, chunkMeta.ObjectID)
+	var existing common.MetaData
+	httpCode := cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &existing)
+
+	if httpCode == 200 {
+		if chunkMetaChanged(&existing, &chunkMeta) {
+			// The chunk data is the same, it is not uploaded again.
+			metaOnly := chunkMeta
+			metaOnly.MetaOnly = true
+			cliutils.ExchangePutPost("Model Management Service", http.MethodPut, cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{204}, map[string]common.MetaData{"meta": metaOnly}, nil)
+		}
+
+		// A chunk that is not ready was interrupted while its data was uploaded.
+		var status []byte
+		statusPath := path.Join(urlPath, "status")
+		cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), statusPath, cliutils.OrgAndCreds(org, userPw), []int{200}, &status)
+		if strings.TrimSpace(string(status)) == common.ReadyToSend {
+			return false
+		}
+	} else {
+		cliutils.ExchangePutPost("Model Management Service", http.MethodPut, cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{204}, map[string]common.MetaData{"meta": chunkMeta}, nil)
+	}
+
+	cliutils.ExchangePutPost("Model Management Service", http.MethodPut, cliutils.GetMMSUrl(), path.Join(urlPath, "data"), cliutils.OrgAndCreds(org, userPw), []int{204}, data, nil)
+	return true
+}
+
+// Returns true if the metadata of a chunk in the MMS must be updated: the destinations of the object changed, or the
+// chunk was signed with another key.
+func chunkMetaChanged(existing *common.MetaData, wanted *common.MetaData) bool {
+	if existing.DestID != wanted.DestID || existing.DestType != wanted.DestType ||
+		existing.Expiration != wanted.Expiration || existing.Inactive != wanted.Inactive ||
+		existing.ActivationTime != wanted.ActivationTime || existing.Public != wanted.Public ||
+		existing.HashAlgorithm != wanted.HashAlgorithm || existing.PublicKey != wanted.PublicKey {
+		return true
+	} else if len(existing.DestinationsList) != 0 || len(wanted.DestinationsList) != 0 {
+		if !reflect.DeepEqual(existing.DestinationsList, wanted.DestinationsList) {
+			return true
+		}
+	}
+
+	// The timestamp of the policy is set by the MMS.
+	if existing.DestinationPolicy == nil || wanted.DestinationPolicy == nil {
+		return existing.DestinationPolicy != wanted.DestinationPolicy
+	}
+	return !reflect.DeepEqual(existing.DestinationPolicy.Properties, wanted.DestinationPolicy.Properties) ||
+		!reflect.DeepEqual(existing.DestinationPolicy.Constraints, wanted.DestinationPolicy.Constraints) ||
+		!reflect.DeepEqual(existing.DestinationPolicy.Services, wanted.DestinationPolicy.Services)
+}
+
+// Returns the ids of the chunks of the object in the MMS.
+func getChunkIds(org string, userPw string, objType string, objId string) []string {
+	var chunksMeta []common.MetaData
+	urlPath := "api/v1/objects/" + org + "?filters=true&objectType=" + url.QueryEscape(objectchunks.ChunkType(objType))
+	httpCode := cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &chunksMeta)
+	if httpCode == 404 {
+		return []string{}
+	}
+
+	// The id of another object can start with the id of this object, the rest of a chunk id is a chunk hash.
+	ids := []string{}
+	prefix := objectchunks.ChunkId(objId, "")
+	for _, meta := range chunksMeta {
+		if hash := strings.TrimPrefix(meta.ObjectID, prefix); hash != meta.ObjectID && isChunkHash(hash) {
+			ids = append(ids, meta.ObjectID)
+		}
+	}
+	return ids
+}
+
+func isChunkHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == 32
+}
+
+// Returns the manifest that is the data of the object in the MMS, or nil when the object does not exist or is not a
+// chunked object. The data is only downloaded when it is small enough to be a manifest.
+func getManifest(org string, userPw string, objType string, objId string) *objectchunks.Manifest {
+	var meta common.MetaData
+	urlPath := path.Join("api/v1/objects/", org, objType, objId)
+	if httpCode := cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &meta); httpCode == 404 {
+		return nil
+	} else if meta.Deleted || meta.NoData || meta.ObjectSize <= 0 || meta.ObjectSize > objectchunks.MAX_MANIFEST_SIZE {
+		return nil
+	}
+
+	var data []byte
+	if httpCode := cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), path.Join(urlPath, "data"), cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &data); httpCode == 404 {
+		return nil
+	}
+	return objectchunks.ParseManifest(data)
+}
+
+// Delete the chunks of the object that are in none of the manifests, or all of them when there are no manifests.
+func deleteChunks(org string, userPw string, objType string, objId string, manifests ...*objectchunks.Manifest) int {
+	keep := make(map[string]bool)
+	for _, manifest := range manifests {
+		if manifest == nil {
+			continue
+		}
+		for _, c := range manifest.Chunks {
+			keep[objectchunks.ChunkId(objId, c.Hash)] = true
+		}
+	}
+
+	deleted := 0
+	for _, id := range getChunkIds(org, userPw, objType, objId) {
+		if !keep[id] {
+			urlPath := path.Join("api/v1/objects/", org, objectchunks.ChunkType(objType), id)
+			cliutils.ExchangeDelete("Model Management Service", cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{204, 404})
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Download the chunks of a chunked object and write the object data to the file. The chunks are verified against
+// their hashes in the manifest, whose signature has been verified. The chunks of the existing file, the previous
+// version of the object, are not downloaded. The downloaded chunks are kept in the {fileName}.chunks directory until
+// the file is written, so that an interrupted download resumes where it stopped.
+func downloadChunks(org string, userPw string, objType string, objId string, manifest *objectchunks.Manifest, fileName string) {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	local, err := objectchunks.NewLocalChunks(fileName, manifest)
+	if err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to read the existing file %v: %v", fileName, err))
+	}
+
+	chunksDir := fileName + objectchunks.CHUNK_TYPE_SUFFIX
+	if err := os.MkdirAll(chunksDir, 0700); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("failed to create directory %v: %v", chunksDir, err))
+	}
+
+	// Establish the HTTP request override because the download could take some time.
+	setHTTPOverride := false
+	if os.Getenv(config.HTTPRequestTimeoutOverride) == "" {
+		setHTTPOverride = true
+		os.Setenv(config.HTTPRequestTimeoutOverride, "0")
+	}
+
+	total := len(manifest.Missing(nil))
+	missing := manifest.Missing(local)
+	downloaded := 0
+	fetch := func(c objectchunks.Chunk) ([]byte, error) {
+		chunkFile := path.Join(chunksDir, c.Hash)
+		if data, err := ioutil.ReadFile(chunkFile); err == nil && objectchunks.VerifyChunk(c, data) == nil {
+			return data, nil
+		}
+
+		var data []byte
+		urlPath := path.Join("api/v1/objects/", org, objectchunks.ChunkType(objType), objectchunks.ChunkId(objId, c.Hash), "data")
+		httpCode := cliutils.ExchangeGet("Model Management Service", cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{200, 404}, &data)
+		if httpCode == 404 {
+			cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("chunk %v of object '%s' of type '%s' not found in org %s", c.Hash, objId, objType, org))
+		} else if err := objectchunks.VerifyChunk(c, data); err != nil {
+			return nil, err
+		} else if err := ioutil.WriteFile(chunkFile, data, 0600); err != nil {
+			return nil, err
+		}
+		downloaded++
+		cliutils.Verbose(msgPrinter.Sprintf("Downloaded chunk %v of %v: %v", downloaded, len(missing), c))
+		return data, nil
+	}
+
+	// Write to a temporary file, the existing file is read while the data is assembled.
+	tmpFileName := fileName + ".tmp"
+	file, err := os.Create(tmpFileName)
+	if err != nil {
+		cliutils.Fatal(cliutils.INTERNAL_ERROR, msgPrinter.Sprintf("Failed to create file: %s", tmpFileName))
+	}
+	err = manifest.Assemble(file, local, fetch)
+	file.Close()
+	local.Close()
+
+	// Restore HTTP request override if necessary.
+	if setHTTPOverride {
+		os.Setenv(config.HTTPRequestTimeoutOverride, "")
+	}
+
+	if err != nil {
+		os.Remove(tmpFileName)
+		cliutils.Fatal(cliutils.INTERNAL_ERROR, msgPrinter.Sprintf("Failed to assemble the chunks of object '%s' of type '%s': %v", objId, objType, err))
+	}
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		cliutils.Fatal(cliutils.FILE_IO_ERROR, msgPrinter.Sprintf("Failed to save data for object '%s' of type '%s' to file %s: %v", objId, objType, fileName, err))
+	}
+	os.RemoveAll(chunksDir)
+
+	msgPrinter.Printf("Object data assembled from %v chunks, %v chunks downloaded, %v chunks reused.", total, downloaded, total-downloaded)
+	msgPrinter.Println()
+}
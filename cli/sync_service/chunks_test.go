@@ -0,0 +1,296 @@
+// +build unit
+
+package sync_service
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/open-horizon/anax/cli/cliutils"
+	"github.com/open-horizon/anax/objectchunks"
+	"github.com/open-horizon/edge-sync-service/common"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type stubObject struct {
+	meta   common.MetaData
+	data   []byte
+	status string
+}
+
+// An in memory model management service for one org. It records the requests that change the objects.
+type mmsStub struct {
+	objects  map[string]*stubObject // keyed by type/id
+	metaPuts []string
+	dataPuts []string
+	deletes  []string
+	dataGets []string
+}
+
+func newMMSStub(t *testing.T) (*mmsStub, *httptest.Server) {
+	stub := &mmsStub{objects: make(map[string]*stubObject)}
+	server := httptest.NewServer(stub)
+
+	dryRun := false
+	cliutils.Opts.IsDryRun = &dryRun
+	os.Setenv("HZN_FSS_CSSURL", server.URL)
+	return stub, server
+}
+
+func (m *mmsStub) add(objType string, objId string, meta common.MetaData, data []byte, status string) {
+	meta.ObjectType, meta.ObjectID, meta.ObjectSize = objType, objId, int64(len(data))
+	m.objects[objType+"/"+objId] = &stubObject{meta: meta, data: data, status: status}
+}
+
+func (m *mmsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The paths are /api/v1/objects/{org} and /api/v1/objects/{org}/{type}/{id}[/data|/status].
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/objects/"), "/")
+	if len(parts) == 1 {
+		list := []common.MetaData{}
+		for _, obj := range m.objects {
+			if obj.meta.ObjectType == r.URL.Query().Get("objectType") {
+				list = append(list, obj.meta)
+			}
+		}
+		if len(list) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	key := parts[1] + "/" + parts[2]
+	obj, found := m.objects[key]
+	sub := ""
+	if len(parts) == 4 {
+		sub = parts[3]
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodGet && !found:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodGet && sub == "":
+		json.NewEncoder(w).Encode(obj.meta)
+	case r.Method == http.MethodGet && sub == "status":
+		w.Write([]byte(obj.status))
+	case r.Method == http.MethodGet && sub == "data":
+		m.dataGets = append(m.dataGets, key)
+		w.Write(obj.data)
+	case r.Method == http.MethodPut && sub == "":
+		var wrapper struct {
+			Meta common.MetaData `json:"meta"`
+		}
+		json.Unmarshal(body, &wrapper)
+		m.metaPuts = append(m.metaPuts, key)
+		if found && wrapper.Meta.MetaOnly {
+			obj.meta = wrapper.Meta
+		} else {
+			m.add(parts[1], parts[2], wrapper.Meta, nil, common.NotReadyToSend)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && sub == "data":
+		m.dataPuts = append(m.dataPuts, key)
+		obj.data, obj.meta.ObjectSize, obj.status = body, int64(len(body)), common.ReadyToSend
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		m.deletes = append(m.deletes, key)
+		delete(m.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Writes random data to a temporary file and returns the file name and its manifest.
+func chunkedTestFile(t *testing.T, size int) (string, *objectchunks.Manifest) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(7)).Read(data)
+	file, err := ioutil.TempFile("", "hzn-chunks-test-")
+	if err != nil {
+		t.Fatalf("unable to create the test file: %v", err)
+	}
+	file.Write(data)
+	file.Close()
+
+	manifest, err := objectchunks.NewManifest(bytes.NewReader(data), objectchunks.MIN_AVG_CHUNK_SIZE)
+	if err != nil {
+		t.Fatalf("unable to split the test data: %v", err)
+	} else if len(manifest.Chunks) < 3 {
+		t.Fatalf("expected at least 3 chunks, got %v", len(manifest.Chunks))
+	}
+	return file.Name(), manifest
+}
+
+func Test_publishChunks_resume(t *testing.T) {
+
+	stub, server := newMMSStub(t)
+	defer server.Close()
+	defer os.Unsetenv("HZN_FSS_CSSURL")
+
+	objFile, manifest := chunkedTestFile(t, 1024*1024)
+	defer os.Remove(objFile)
+	objectMeta := common.MetaData{ObjectType: "model", ObjectID: "obj", DestType: "node"}
+	chunkType := objectchunks.ChunkType("model")
+	key := func(c objectchunks.Chunk) string { return chunkType + "/" + objectchunks.ChunkId("obj", c.Hash) }
+
+	// The previous publish was interrupted: the first chunk is complete, the data of the second chunk was not uploaded,
+	// and the third chunk is complete but the object has new destinations since.
+	c0, c1, c2 := manifest.Chunks[0], manifest.Chunks[1], manifest.Chunks[2]
+	stub.add(chunkType, objectchunks.ChunkId("obj", c0.Hash), common.MetaData{DestType: "node"}, []byte("d0"), common.ReadyToSend)
+	stub.add(chunkType, objectchunks.ChunkId("obj", c1.Hash), common.MetaData{DestType: "node"}, nil, common.NotReadyToSend)
+	stub.add(chunkType, objectchunks.ChunkId("obj", c2.Hash), common.MetaData{DestType: "gateway"}, []byte("d2"), common.ReadyToSend)
+
+	published, manifestFile := publishChunks("myorg", "u:pw", objectMeta, objFile, objectchunks.MIN_AVG_CHUNK_SIZE, "", nil)
+	defer os.Remove(manifestFile)
+
+	if published.Hash != manifest.Hash || len(published.Chunks) != len(manifest.Chunks) {
+		t.Errorf("wrong manifest %v, expected %v", published, manifest)
+	}
+	if data, err := ioutil.ReadFile(manifestFile); err != nil || objectchunks.ParseManifest(data) == nil {
+		t.Errorf("the manifest file %v does not hold the manifest: %v", manifestFile, err)
+	}
+
+	puts := make(map[string]int)
+	for _, k := range stub.dataPuts {
+		puts[k]++
+	}
+	metaPuts := make(map[string]int)
+	for _, k := range stub.metaPuts {
+		metaPuts[k]++
+	}
+	if puts[key(c0)] != 0 || metaPuts[key(c0)] != 0 {
+		t.Errorf("the complete chunk was published again: %v %v", stub.metaPuts, stub.dataPuts)
+	} else if puts[key(c1)] != 1 || metaPuts[key(c1)] != 0 {
+		t.Errorf("the interrupted chunk was not resumed: %v %v", stub.metaPuts, stub.dataPuts)
+	} else if puts[key(c2)] != 0 || metaPuts[key(c2)] != 1 || stub.objects[key(c2)].meta.DestType != "node" {
+		t.Errorf("only the metadata of the chunk with other destinations should be updated: %v %v", stub.metaPuts, stub.dataPuts)
+	}
+	for _, c := range manifest.Missing(nil) {
+		if obj, ok := stub.objects[key(c)]; !ok || obj.status != common.ReadyToSend {
+			t.Errorf("chunk %v is not ready in the MMS", c)
+		} else if c != c0 && c != c2 && int64(len(obj.data)) != c.Size {
+			t.Errorf("chunk %v has %v bytes of data", c, len(obj.data))
+		}
+	}
+
+	// Publishing again uploads nothing.
+	stub.metaPuts, stub.dataPuts = nil, nil
+	_, manifestFile2 := publishChunks("myorg", "u:pw", objectMeta, objFile, objectchunks.MIN_AVG_CHUNK_SIZE, "", nil)
+	defer os.Remove(manifestFile2)
+	if len(stub.metaPuts) != 0 || len(stub.dataPuts) != 0 {
+		t.Errorf("expected nothing to be published, got %v %v", stub.metaPuts, stub.dataPuts)
+	}
+
+}
+
+func Test_chunkMetaChanged(t *testing.T) {
+
+	policy := func(constraint string, timestamp int64) *common.Policy {
+		return &common.Policy{Constraints: []string{constraint}, Timestamp: timestamp}
+	}
+
+	tests := []struct {
+		name     string
+		existing common.MetaData
+		wanted   common.MetaData
+		expected bool
+	}{
+		{"same", common.MetaData{DestType: "node", DestID: "n1"}, common.MetaData{DestType: "node", DestID: "n1"}, false},
+		{"dest id", common.MetaData{DestType: "node", DestID: "n1"}, common.MetaData{DestType: "node", DestID: "n2"}, true},
+		{"expiration", common.MetaData{}, common.MetaData{Expiration: "2030-01-01T00:00:00Z"}, true},
+		{"public key", common.MetaData{HashAlgorithm: common.Sha256, PublicKey: "k1"}, common.MetaData{HashAlgorithm: common.Sha256, PublicKey: "k2"}, true},
+		{"signature only", common.MetaData{PublicKey: "k1", Signature: "s1"}, common.MetaData{PublicKey: "k1", Signature: "s2"}, false},
+		{"empty destinations list", common.MetaData{DestinationsList: []string{}}, common.MetaData{}, false},
+		{"destinations list", common.MetaData{DestinationsList: []string{"node:n1"}}, common.MetaData{DestinationsList: []string{"node:n2"}}, true},
+		{"same destinations list", common.MetaData{DestinationsList: []string{"node:n1"}}, common.MetaData{DestinationsList: []string{"node:n1"}}, false},
+		{"policy added", common.MetaData{}, common.MetaData{DestinationPolicy: policy("a == 1", 0)}, true},
+		{"policy timestamp", common.MetaData{DestinationPolicy: policy("a == 1", 100)}, common.MetaData{DestinationPolicy: policy("a == 1", 0)}, false},
+		{"policy constraints", common.MetaData{DestinationPolicy: policy("a == 1", 100)}, common.MetaData{DestinationPolicy: policy("a == 2", 0)}, true},
+	}
+
+	for _, test := range tests {
+		if changed := chunkMetaChanged(&test.existing, &test.wanted); changed != test.expected {
+			t.Errorf("%v: chunkMetaChanged returned %v, expected %v", test.name, changed, test.expected)
+		}
+	}
+
+}
+
+func Test_deleteChunks(t *testing.T) {
+
+	stub, server := newMMSStub(t)
+	defer server.Close()
+	defer os.Unsetenv("HZN_FSS_CSSURL")
+
+	hash := func(c string) string { return strings.Repeat(c, 64) }
+	chunkType := objectchunks.ChunkType("model")
+	for _, h := range []string{hash("a"), hash("b"), hash("c"), hash("d")} {
+		stub.add(chunkType, objectchunks.ChunkId("obj", h), common.MetaData{}, []byte(h), common.ReadyToSend)
+	}
+	// The chunks of another object whose id starts with the id of this object.
+	stub.add(chunkType, objectchunks.ChunkId("obj_x", hash("c")), common.MetaData{}, []byte("x"), common.ReadyToSend)
+
+	// The current version has chunks a and b, the version it replaced has chunks b and c.
+	current := &objectchunks.Manifest{Chunks: []objectchunks.Chunk{{Hash: hash("a")}, {Hash: hash("b")}}}
+	previous := &objectchunks.Manifest{Chunks: []objectchunks.Chunk{{Hash: hash("b")}, {Hash: hash("c")}}}
+	if deleted := deleteChunks("myorg", "u:pw", "model", "obj", current, previous); deleted != 1 || len(stub.deletes) != 1 || stub.deletes[0] != chunkType+"/"+objectchunks.ChunkId("obj", hash("d")) {
+		t.Errorf("expected only the chunk of an older version to be deleted, deleted %v: %v", deleted, stub.deletes)
+	}
+
+	// The next version has chunk a only, the chunks of the version it replaced are kept.
+	next := &objectchunks.Manifest{Chunks: []objectchunks.Chunk{{Hash: hash("a")}}}
+	stub.deletes = nil
+	if deleted := deleteChunks("myorg", "u:pw", "model", "obj", next, current); deleted != 1 || stub.deletes[0] != chunkType+"/"+objectchunks.ChunkId("obj", hash("c")) {
+		t.Errorf("expected the chunk of the version before the replaced one to be deleted, deleted %v: %v", deleted, stub.deletes)
+	}
+
+	// Deleting the object deletes all its chunks, and not the chunks of the other object.
+	stub.deletes = nil
+	if deleted := deleteChunks("myorg", "u:pw", "model", "obj"); deleted != 2 {
+		t.Errorf("expected the remaining 2 chunks to be deleted, deleted %v: %v", deleted, stub.deletes)
+	}
+	remaining := []string{}
+	for key := range stub.objects {
+		remaining = append(remaining, key)
+	}
+	sort.Strings(remaining)
+	if len(remaining) != 1 || remaining[0] != chunkType+"/"+objectchunks.ChunkId("obj_x", hash("c")) {
+		t.Errorf("wrong remaining objects %v", remaining)
+	}
+
+}
+
+func Test_getManifest(t *testing.T) {
+
+	stub, server := newMMSStub(t)
+	defer server.Close()
+	defer os.Unsetenv("HZN_FSS_CSSURL")
+
+	manifest := objectchunks.Manifest{Format: objectchunks.MANIFEST_FORMAT, Size: 10, Hash: strings.Repeat("a", 64), Chunks: []objectchunks.Chunk{{Hash: strings.Repeat("b", 64), Size: 10}}}
+	data, _ := json.Marshal(manifest)
+	stub.add("model", "chunked", common.MetaData{}, data, common.ReadyToSend)
+	stub.add("model", "plain", common.MetaData{}, []byte("plain data"), common.ReadyToSend)
+	stub.add("model", "large", common.MetaData{}, []byte("large data"), common.ReadyToSend)
+	stub.objects["model/large"].meta.ObjectSize = objectchunks.MAX_MANIFEST_SIZE + 1
+
+	if m := getManifest("myorg", "u:pw", "model", "chunked"); m == nil || m.Hash != manifest.Hash || len(m.Chunks) != 1 {
+		t.Errorf("expected the manifest of the chunked object, got %v", m)
+	} else if m := getManifest("myorg", "u:pw", "model", "plain"); m != nil {
+		t.Errorf("expected no manifest for an object that is not chunked, got %v", m)
+	} else if m := getManifest("myorg", "u:pw", "model", "missing"); m != nil {
+		t.Errorf("expected no manifest for a missing object, got %v", m)
+	} else if m := getManifest("myorg", "u:pw", "model", "large"); m != nil {
+		t.Errorf("expected no manifest for a large object, got %v", m)
+	} else if len(stub.dataGets) != 2 {
+		t.Errorf("expected the data of the large object not to be downloaded, got %v", stub.dataGets)
+	}
+
+}
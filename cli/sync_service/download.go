@@ -10,6 +10,7 @@ import (
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/objectchunks"
 	"github.com/open-horizon/edge-sync-service/common"
 	"io"
 	"os"
@@ -109,6 +110,14 @@ func ObjectDownLoad(org string, userPw string, objType string, objId string, fil
 		}
 	}
 
+	// The data of a chunked object is the manifest of its chunks, the chunks that are not in the file are downloaded.
+	if manifest := objectchunks.ParseManifest(data); manifest != nil {
+		downloadChunks(org, userPw, objType, objId, manifest, fileName)
+		msgPrinter.Printf("Data of object %v saved to file %v", objId, fileName)
+		msgPrinter.Println()
+		return
+	}
+
 	file, err := os.Create(fileName)
 	if err != nil {
 		cliutils.Fatal(cliutils.INTERNAL_ERROR, msgPrinter.Sprintf("Failed to create file: %s", fileName))
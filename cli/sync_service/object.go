@@ -16,7 +16,9 @@ import (
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/objectchunks"
 	"github.com/open-horizon/edge-sync-service/common"
+	"github.com/open-horizon/rsapss-tool/sign"
 	"hash"
 	"io"
 	"net/http"
@@ -129,7 +131,7 @@ func ObjectList(org string, userPw string, objType string, objId string, destPol
 		cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("no objects found in org %s", org))
 	}
 
-	output := ""
+	var result interface{}
 
 	if details {
 		// Cut the objectsMeta into batches of size 50. For each batch, process the API call concurrently. Use batches strategy to 1) reduce the processing time, 2) avoid overwhelming API calls sent to CSS server at one time
@@ -193,7 +195,7 @@ func ObjectList(org string, userPw string, objType string, objId string, destPol
 			}
 		}
 
-		output = cliutils.MarshalIndent(mmsObjects, "mms object list")
+		result = mmsObjects
 	} else {
 		if !long {
 			mmsObjects := make([]MMSObjectInfo, 0)
@@ -204,19 +206,25 @@ func ObjectList(org string, userPw string, objType string, objId string, destPol
 				}
 				mmsObjects = append(mmsObjects, mmsObjectInfo)
 			}
-			output = cliutils.MarshalIndent(mmsObjects, "mms object list")
+			result = mmsObjects
 		} else {
-			var err1 error
-			output, err1 = cliutils.DisplayAsJson(objectsMeta)
-			if err1 != nil {
-				cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("failed to marshal 'hzn mms object list' output: %v", err1))
-			}
+			result = objectsMeta
 		}
 	}
 
-	msgPrinter.Printf("Listing objects in org %v:", org)
-	msgPrinter.Println()
-	fmt.Println(output)
+	if cliutils.IsDefaultOutput() {
+		msgPrinter.Printf("Listing objects in org %v:", org)
+		msgPrinter.Println()
+	}
+	cliutils.Output(result, "'hzn mms object list'",
+		cliutils.NewOutputColumn("TYPE", ".objectType // .definition.objectType"),
+		cliutils.NewOutputColumn("ID", ".objectID // .definition.objectID"),
+		cliutils.NewOutputColumn("VERSION", ".version // .definition.version"),
+		cliutils.NewOutputColumn("SIZE", ".objectSize // .definition.objectSize"),
+		cliutils.NewWideOutputColumn("DESTINATION TYPE", ".destinationType // .definition.destinationType"),
+		cliutils.NewWideOutputColumn("DESTINATION ID", ".destinationID // .definition.destinationID"),
+		cliutils.NewWideOutputColumn("EXPIRATION", ".expiration // .definition.expiration"),
+		cliutils.NewWideOutputColumn("STATUS", ".objectStatus"))
 }
 
 func ObjectNew(org string) {
@@ -281,7 +289,7 @@ func ObjectNew(org string) {
 
 // Upload an object to the MMS. The user can provide a copy of the object's metadata in a file, or they can simply provide
 // object id and type.
-func ObjectPublish(org string, userPw string, objType string, objId string, objPattern string, objMetadataFile string, objFile string, skipDigitalSig bool, dsHashAlgo string, dsHash string) {
+func ObjectPublish(org string, userPw string, objType string, objId string, objPattern string, objMetadataFile string, objFile string, skipDigitalSig bool, dsHashAlgo string, dsHash string, privKeyFile string, chunked bool, chunkSize int64) {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
@@ -304,8 +312,16 @@ func ObjectPublish(org string, userPw string, objType string, objId string, objP
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("cannot specify --skipDigitalSig with --hash"))
 	} else if skipDigitalSig && dsHashAlgo != "" {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("cannot specify --skipDigitalSig with --hashAlgo"))
+	} else if skipDigitalSig && privKeyFile != "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("cannot specify --noIntegrity with --private-key-file"))
 	} else if dsHashAlgo != "" && dsHashAlgo != common.Sha1 && dsHashAlgo != common.Sha256 {
 		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("invalid value for --hashAlgo, please use SHA1 or SHA256"))
+	} else if chunked && objFile == "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("must specify --object with --chunked"))
+	} else if chunked && dsHash != "" {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("cannot specify --hash with --chunked"))
+	} else if !chunked && chunkSize != 0 {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("must specify --chunked with --chunk-size"))
 	}
 
 	// If we were given a full metadata file, read it in and use it to create the object. Otherwise, construct a minimal
@@ -323,29 +339,66 @@ func ObjectPublish(org string, userPw string, objType string, objId string, objP
 		objectMeta.DestType = objPattern
 	}
 
+	// The services that get the objects of a type expect either the object data or a manifest, so only the types that are
+	// meant for chunked objects are chunked.
+	if chunked && !objectchunks.IsChunkedType(objectMeta.ObjectType) {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("the object type must end with %v to publish a chunked object. The services that get the objects of type %v would get the list of chunks instead of the object data.", objectchunks.CHUNKED_TYPE_SUFFIX, objectMeta.ObjectType))
+	} else if !chunked && objFile != "" && objectchunks.IsChunkedType(objectMeta.ObjectType) {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("must specify --chunked to publish the data of an object of type %v, the services that get the objects of this type expect the list of chunks.", objectMeta.ObjectType))
+	}
+
 	// If there is no data to upload, set the metaonly flag to indicate that we are only updating the object's metadata. This ensures
 	// that the MMS (CSS) correctly interpets the PUT.
+	var manifest *objectchunks.Manifest
 	if objFile == "" {
 		objectMeta.MetaOnly = true
-	} else if !skipDigitalSig {
+	} else {
 
-		hashAlgorithm := common.Sha1
-		if dsHashAlgo == common.Sha256 {
-			hashAlgorithm = common.Sha256
-		}
+		hashAlgorithm := ""
+		var privateKey *rsa.PrivateKey
+		if !skipDigitalSig {
+			hashAlgorithm = common.Sha1
+			if dsHashAlgo == common.Sha256 {
+				hashAlgorithm = common.Sha256
+			}
 
-		msgPrinter.Printf("Digital sign with %s will be performed for data integrity. It will delay the MMS object publish.\n", hashAlgorithm)
+			msgPrinter.Printf("Digital sign with %s will be performed for data integrity. It will delay the MMS object publish.\n", hashAlgorithm)
 
-		// Create public key. Sign data. Set "hashAlgorithm", "publicKey" and "signature" field
-		if publicKey, signature, err := signObjData(objFile, hashAlgorithm, dsHash); err != nil {
-			cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("failed to digital sign the file %v, Error: %v", objFile, err))
-		} else {
-			objectMeta.HashAlgorithm = hashAlgorithm
-			objectMeta.PublicKey = publicKey
-			objectMeta.Signature = signature
+			// Sign with the hzn key of the user when there is one, so that nodes which require signed objects can trust the object.
+			privateKey = getObjectSigningKey(privKeyFile)
+			if privateKey == nil {
+				cliutils.Warning(msgPrinter.Sprintf("no private key found, the object data is signed with a one-time key. Nodes that require signed objects of type %v will reject the object. Use 'hzn key create' to create a signing key, and 'hzn exchange org addmmskey' to make the org trust it.", objectMeta.ObjectType))
+
+				// The chunks and the manifest are signed with the same one-time key.
+				if chunked {
+					var err error
+					if privateKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+						cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("failed to generate a one-time key: %v", err))
+					}
+				}
+			}
+		}
+
+		// Publish the chunks, the data of the object is the manifest of the chunks.
+		if chunked {
+			var manifestFile string
+			manifest, manifestFile = publishChunks(org, userPw, objectMeta, objFile, chunkSize, hashAlgorithm, privateKey)
+			defer os.Remove(manifestFile)
+			objFile = manifestFile
 		}
 
-		msgPrinter.Println("Digital sign finished.")
+		if !skipDigitalSig {
+			// Sign data. Set "hashAlgorithm", "publicKey" and "signature" field
+			if publicKey, signature, err := signObjData(objFile, hashAlgorithm, dsHash, privateKey); err != nil {
+				cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, msgPrinter.Sprintf("failed to digital sign the file %v, Error: %v", objFile, err))
+			} else {
+				objectMeta.HashAlgorithm = hashAlgorithm
+				objectMeta.PublicKey = publicKey
+				objectMeta.Signature = signature
+			}
+
+			msgPrinter.Println("Digital sign finished.")
+		}
 	}
 
 	type ObjectWrapper struct {
@@ -355,6 +408,12 @@ func ObjectPublish(org string, userPw string, objType string, objId string, objP
 
 	wrapper := ObjectWrapper{Meta: objectMeta}
 
+	// The chunks of the version that is replaced are kept for the nodes that are still assembling it.
+	var previous *objectchunks.Manifest
+	if objFile != "" {
+		previous = getManifest(org, userPw, objectMeta.ObjectType, objectMeta.ObjectID)
+	}
+
 	// Call the MMS service over HTTP to add the object's metadata to the MMS.
 	urlPath := path.Join("api/v1/objects/", org, objectMeta.ObjectType, objectMeta.ObjectID)
 	cliutils.ExchangePutPost("Model Management Service", http.MethodPut, cliutils.GetMMSUrl(), urlPath, cliutils.OrgAndCreds(org, userPw), []int{204}, wrapper, nil)
@@ -385,6 +444,11 @@ func ObjectPublish(org string, userPw string, objType string, objId string, objP
 		}
 
 		cliutils.Verbose(msgPrinter.Sprintf("Object %v uploaded to org %v in the Model Management Service", objFile, org))
+
+		// Remove the chunks of the older versions that are neither in this version nor in the version it replaced.
+		if deleted := deleteChunks(org, userPw, objectMeta.ObjectType, objectMeta.ObjectID, manifest, previous); deleted > 0 {
+			cliutils.Verbose(msgPrinter.Sprintf("Removed %v chunks of the older versions of the object", deleted))
+		}
 	}
 
 	// Grab the object status and display it.
@@ -419,6 +483,11 @@ func ObjectDelete(org string, userPw string, objType string, objId string) {
 		cliutils.Fatal(cliutils.NOT_FOUND, msgPrinter.Sprintf("object '%s' of type '%s' not found in org %s", objId, objType, org))
 	}
 
+	// Delete the chunks of the object too, if it was published with --chunked.
+	if deleted := deleteChunks(org, userPw, objType, objId); deleted > 0 {
+		cliutils.Verbose(msgPrinter.Sprintf("Deleted %v chunks of object %v", deleted, objId))
+	}
+
 	msgPrinter.Printf("Object %v deleted from org %v in the Model Management Service", objId, org)
 	msgPrinter.Println()
 
@@ -452,13 +521,39 @@ func timeIsValid(timestamp string) (bool, string) {
 	return true, ""
 }
 
-func signObjData(objFile string, dsHashAlgo string, dsHash string) (string, string, error) {
+// Get the private key to sign the object data with: the given key file, the HZN_PRIVATE_KEY_FILE key file or the
+// default hzn key file when it exists. Returns nil when there is no key to use.
+func getObjectSigningKey(privKeyFile string) *rsa.PrivateKey {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	keyFile := *cliutils.WithDefaultEnvVar(&privKeyFile, "HZN_PRIVATE_KEY_FILE")
+	if keyFile != "" {
+		keyFile = cliutils.VerifySigningKeyInput(keyFile, false)
+	} else if defaultFile, err := cliutils.GetDefaultSigningKeyFile(false); err != nil {
+		cliutils.Fatal(cliutils.CLI_GENERAL_ERROR, err.Error())
+	} else if _, err := os.Stat(defaultFile); err == nil {
+		keyFile = defaultFile
+	} else {
+		return nil
+	}
+
+	privateKey, err := sign.ReadPrivateKey(keyFile)
+	if err != nil {
+		cliutils.Fatal(cliutils.CLI_INPUT_ERROR, msgPrinter.Sprintf("provided private key is not valid; error: %v", err))
+	}
+	cliutils.Verbose(msgPrinter.Sprintf("Signing the object data with the private key %v", keyFile))
+	return privateKey
+}
+
+// Sign the object data with the given private key, or with a one-time key if it is nil. Returns the base64 encoded
+// public key and signature to set in the object metadata.
+func signObjData(objFile string, dsHashAlgo string, dsHash string, privateKey *rsa.PrivateKey) (string, string, error) {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
 
 	var fileHash hash.Hash
 	var fileHashSum []byte
-	var privateKey *rsa.PrivateKey
 	var err error
 
 	if dsHash != "" {
@@ -489,23 +584,31 @@ func signObjData(objFile string, dsHashAlgo string, dsHash string) (string, stri
 		msgPrinter.Println()
 	}
 
-	if privateKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
-		return "", "", err
-	} else {
-		// get public key
-		if publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey); err != nil {
-			return "", "", err
-		} else if cryptoHash, err := GetCryptoHashType(dsHashAlgo); err != nil {
-			return "", "", err
-		} else if signature, err := rsa.SignPSS(rand.Reader, privateKey, cryptoHash, fileHashSum, nil); err != nil {
+	return signHashSum(fileHashSum, dsHashAlgo, privateKey)
+}
+
+// Sign the hash of some data with the given private key, or with a one-time key if it is nil. Returns the base64
+// encoded public key and signature.
+func signHashSum(hashSum []byte, dsHashAlgo string, privateKey *rsa.PrivateKey) (string, string, error) {
+	if privateKey == nil {
+		var err error
+		if privateKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
 			return "", "", err
-		} else {
-			publicKeyString := base64.StdEncoding.EncodeToString(publicKeyBytes)
-			signatureString := base64.StdEncoding.EncodeToString(signature)
-			return publicKeyString, signatureString, nil
 		}
 	}
 
+	// get public key
+	if publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey); err != nil {
+		return "", "", err
+	} else if cryptoHash, err := GetCryptoHashType(dsHashAlgo); err != nil {
+		return "", "", err
+	} else if signature, err := rsa.SignPSS(rand.Reader, privateKey, cryptoHash, hashSum, nil); err != nil {
+		return "", "", err
+	} else {
+		publicKeyString := base64.StdEncoding.EncodeToString(publicKeyBytes)
+		signatureString := base64.StdEncoding.EncodeToString(signature)
+		return publicKeyString, signatureString, nil
+	}
 }
 
 func GetHash(hashAlgo string) (hash.Hash, error) {
@@ -1,7 +1,6 @@
 package sync_service
 
 import (
-	"fmt"
 	"github.com/open-horizon/anax/cli/cliutils"
 	"github.com/open-horizon/anax/i18n"
 	"github.com/open-horizon/edge-sync-service/common"
@@ -35,6 +34,5 @@ func Status(org string, userPw string) {
 	if httpCode != 200 {
 		cliutils.Fatal(cliutils.HTTP_ERROR, msgPrinter.Sprintf("health status API returned HTTP code %v", httpCode))
 	}
-	output := cliutils.MarshalIndent(healthData, "mms health")
-	fmt.Println(output)
+	cliutils.Output(healthData, "'hzn mms status'")
 }
@@ -95,6 +95,39 @@ func Start(cw *container.ContainerWorker, org string, configFiles []string, conf
 	return nil
 }
 
+// StartCSS starts only the CSS of the file sync service, for an environment in which an agent runs its own ESS. The
+// CSS is stopped by Stop.
+func StartCSS(dc *docker.Client, org string) error {
+	// get message printer
+	msgPrinter := i18n.GetMessagePrinter()
+
+	network, err := dev.CreateNetwork(dc, NETWORK_NAME)
+	if err != nil {
+		return errors.New(msgPrinter.Sprintf("unable to create network %v for file sync service, error %v", NETWORK_NAME, err))
+	}
+
+	if err := startCSS(dc, network); err != nil {
+		return errors.New(msgPrinter.Sprintf("unable to start CSS, error %v", err))
+	}
+
+	// Wait a few seconds to give the CSS a chance to initialize itself.
+	time.Sleep(time.Second * 2)
+	if err := checkCSSStatus(org, CSS_INITIAL_WAITING_TIME); err != nil {
+		return errors.New(msgPrinter.Sprintf("CSS is not running correctly, error %v", err))
+	}
+
+	return nil
+}
+
+// GetCSSURL returns the URL of the CSS of the file sync service on this host.
+func GetCSSURL() string {
+	hostIP := os.Getenv("HZN_DEV_HOST_IP")
+	if hostIP == "" {
+		hostIP = "localhost"
+	}
+	return fmt.Sprintf("http://%v:%v", hostIP, getCSSPort())
+}
+
 func Stop(dc *docker.Client) error {
 	// get message printer
 	msgPrinter := i18n.GetMessagePrinter()
@@ -454,6 +487,19 @@ func loadCSS(org string, fileType string, fileObjects []string) error {
 
 }
 
+// PutObject publishes an object in the CSS of the file sync service started by 'hzn dev service start', so that the
+// ESS delivers it to the service under test.
+func PutObject(org string, objType string, objId string, data []byte) error {
+	// Get this host's IP address because that's where the CSS is listening.
+	hostIP := os.Getenv("HZN_DEV_HOST_IP")
+	if hostIP == "" {
+		hostIP = "localhost"
+	}
+
+	url := fmt.Sprintf("http://%v:%v/api/v1/objects/%v/%v/%v", hostIP, getCSSPort(), org, objType, objId)
+	return putFile(url, org, &cssFileMeta{ObjectID: objId, ObjectType: objType}, data)
+}
+
 type cssFileMeta struct {
 	ObjectID   string `json:"objectID"`
 	ObjectType string `json:"objectType"`
@@ -15,11 +15,7 @@ func List() {
 	var inputs []policy.UserInput
 	cliutils.HorizonGet("node/userinput", []int{200}, &inputs, false)
 
-	output, err := cliutils.DisplayAsJson(inputs)
-	if err != nil {
-		cliutils.Fatal(cliutils.JSON_PARSING_ERROR, i18n.GetMessagePrinter().Sprintf("Unable to marshal userinput object: %v", err))
-	}
-	fmt.Println(output)
+	cliutils.Output(inputs, "'hzn userinput list'")
 }
 
 func New() {
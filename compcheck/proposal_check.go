@@ -0,0 +1,52 @@
+package compcheck
+
+import (
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/anax/policy"
+	"golang.org/x/text/message"
+)
+
+// These checks are made by the node on the terms and conditions of a proposal before it decides on the proposal.
+// They are shared by the producer protocol handler and the proposal dry run API so that both reject a proposal
+// for the same reasons.
+
+// Check that the terms and conditions carry the deployment configuration for the node type.
+func CheckProposalNodeType(tcPolicy *policy.Policy, nodeType string, msgPrinter *message.Printer) (bool, string) {
+	// get default message printer if nil
+	if msgPrinter == nil {
+		msgPrinter = i18n.GetMessagePrinter()
+	}
+
+	if tcPolicy.Workloads == nil || len(tcPolicy.Workloads) == 0 {
+		return false, msgPrinter.Sprintf("No workload is supplied in the proposal.")
+	}
+
+	// A cluster node accepts terms and conditions without a cluster deployment, the node type
+	// is only enforced for devices.
+	workload := tcPolicy.Workloads[0]
+	if nodeType == "" || nodeType == persistence.DEVICE_TYPE_DEVICE {
+		if workload.Deployment == "" {
+			if workload.ClusterDeployment == "" {
+				return false, msgPrinter.Sprintf("No deployment configuration is provided.")
+			}
+			return false, msgPrinter.Sprintf("Wrong deployment configuration is provided for the node type '%v'.", persistence.DEVICE_TYPE_DEVICE)
+		}
+	}
+
+	return true, ""
+}
+
+// Check that the pattern in the terms and conditions is the pattern the node is registered with. Both are
+// in the format of org/pattern, and both are empty for policy based deployments.
+func CheckProposalPattern(tcPolicy *policy.Policy, nodePattern string, msgPrinter *message.Printer) (bool, string) {
+	// get default message printer if nil
+	if msgPrinter == nil {
+		msgPrinter = i18n.GetMessagePrinter()
+	}
+
+	if tcPolicy.PatternId != nodePattern {
+		return false, msgPrinter.Sprintf("Pattern from the proposal: '%v' does not match the pattern on the node: '%v'.", tcPolicy.PatternId, nodePattern)
+	}
+	return true, ""
+}
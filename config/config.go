@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -18,10 +19,12 @@ const ManagementHubCertPath = "HZN_MGMT_HUB_CERT_PATH"
 const AnaxAPIPort = "HZN_AGENT_PORT"
 
 type HorizonConfig struct {
-	Edge          Config
-	AgreementBot  AGConfig
-	Collaborators Collaborators
-	ArchSynonyms  ArchSynonyms
+	Edge                  Config
+	AgreementBot          AGConfig
+	Collaborators         Collaborators
+	ArchSynonyms          ArchSynonyms
+	Federation            []FederatedExchange `json:"-"` // The exchanges read from AgreementBot.FederationFile.
+	FederatedExchangeName string              `json:"-"` // The name of the federated exchange this config is for, empty for the primary exchange.
 }
 
 // This is the configuration options for Edge component flavor of Anax
@@ -123,7 +126,18 @@ type AGConfig struct {
 	MaxExchangeChanges            int              // The maximum number of exchange changes to request on a given call the exchange /changes API.
 	RetryLookBackWindow           uint64           // The time window (in seconds) used by the agbot to look backward in time for node changes when node agreements are retried.
 	PolicySearchOrder             bool             // When true, search policies from most recently changed to least recently changed.
+	NodeIndex                     bool             // When true, find nodes in a local index maintained from exchange changes. The exchange search is used only every FullRescanS seconds as a consistency check.
+	PartitionRebalance            bool             // When true, the agbots elect a leader that moves agreements between partitions to spread the load across all agbot instances.
+	PartitionRebalanceThreshold   uint64           // The number of active agreements by which a partition has to exceed the average before agreements are moved out of it. The default is 10.
+	PolicyCacheCheckS             uint64           // The number of seconds between checks of the cached deployment policies, patterns and object policies against the exchange. Zero means the check is turned off.
+	PolicyCacheRepair             bool             // When true, the differences found by the policy cache check are repaired.
+	AuditRetentionS               uint64           // The number of seconds to keep a record in the audit trail of agreement decisions. Zero means records are not removed by age.
+	AuditMaxRecords               int              // The maximum number of records to keep in the audit trail of agreement decisions. Zero means there is no limit.
+	QueueWeights                  string           // A comma separated list of org=weight and org/policy=weight entries that weight the share of the work queue given to orgs and their deployment policies. The default weight is 1, "*" sets the default weight for orgs.
+	AgreementRateLimits           string           // A comma separated list of org=limit entries, the maximum number of agreements the agbot will start per minute for each org. "*" sets the limit for all other orgs. Zero means there is no limit.
+	FederationFile                string           // The path to a JSON file that lists the other exchanges served by this agbot. Empty means the agbot serves only the exchange in ExchangeURL.
 	Vault                         VaultConfig      // The hashicorp vault config to connect to and fetch secrets from.
+	DataVerification              DVConfig         // The config for the embedded data verification service.
 }
 
 // Contains the hashicorp vault configuration used within AGConfig.
@@ -132,6 +146,13 @@ type VaultConfig struct {
 	SSLCertPath string // The SSL certificate for the vault
 }
 
+// Contains the embedded data verification service configuration used within AGConfig.
+type DVConfig struct {
+	APIListen     string // Host and port for the embedded data verification API to listen on. Empty means the service is not embedded.
+	ActiveWindowS uint64 // The number of seconds an agreement remains active after its last data report.
+	RetentionS    uint64 // The number of seconds to remember an agreement after its last data report.
+}
+
 func (c *HorizonConfig) UserPublicKeyPath() string {
 	if c.Edge.UserPublicKeyPath == "" {
 		if commonPath := os.Getenv("HZN_VAR_BASE"); commonPath != "" {
@@ -160,6 +181,64 @@ func (c *HorizonConfig) GetPartitionStale() uint64 {
 	}
 }
 
+func (c *HorizonConfig) IsPartitionRebalanceEnabled() bool {
+	return c.AgreementBot.PartitionRebalance
+}
+
+func (c *HorizonConfig) GetPartitionRebalanceThreshold() uint64 {
+	if c.AgreementBot.PartitionRebalanceThreshold == 0 {
+		return 10
+	} else {
+		return c.AgreementBot.PartitionRebalanceThreshold
+	}
+}
+
+func (c *HorizonConfig) GetPolicyCacheCheckInterval() uint64 {
+	return c.AgreementBot.PolicyCacheCheckS
+}
+
+func (c *HorizonConfig) IsPolicyCacheRepairEnabled() bool {
+	return c.AgreementBot.PolicyCacheRepair
+}
+
+func (c *HorizonConfig) GetAuditRetention() uint64 {
+	return c.AgreementBot.AuditRetentionS
+}
+
+func (c *HorizonConfig) GetAuditMaxRecords() int {
+	return c.AgreementBot.AuditMaxRecords
+}
+
+func (c *HorizonConfig) GetAgbotQueueWeights() map[string]int {
+	weights, _ := ParseOrgValues(c.AgreementBot.QueueWeights)
+	return weights
+}
+
+func (c *HorizonConfig) GetAgbotAgreementRateLimits() map[string]int {
+	limits, _ := ParseOrgValues(c.AgreementBot.AgreementRateLimits)
+	return limits
+}
+
+// Parse a comma separated list of key=value entries, where the key is an org, an org qualified policy name or "*",
+// and the value is a non-negative integer.
+func ParseOrgValues(spec string) (map[string]int, error) {
+	res := make(map[string]int)
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("entry %v is not in the form key=value", entry)
+		} else if value, err := strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || value < 0 {
+			return nil, fmt.Errorf("entry %v must have a non-negative integer value", entry)
+		} else {
+			res[strings.TrimSpace(parts[0])] = value
+		}
+	}
+	return res, nil
+}
+
 func (c *HorizonConfig) IsVaultConfigured() bool {
 	return c.AgreementBot.Vault != VaultConfig{}
 }
@@ -208,6 +287,14 @@ func (c *HorizonConfig) GetAgbotPolicyOrder() bool {
 	return c.AgreementBot.PolicySearchOrder
 }
 
+func (c *HorizonConfig) IsAgbotNodeIndexEnabled() bool {
+	return c.AgreementBot.NodeIndex
+}
+
+func (c *HorizonConfig) IsDataVerificationEmbedded() bool {
+	return c.AgreementBot.DataVerification.APIListen != ""
+}
+
 func (c *HorizonConfig) GetK8sCRInstallTimeouts() int64 {
 	return c.Edge.K8sCRInstallTimeoutS
 }
@@ -352,6 +439,8 @@ func Read(file string) (*HorizonConfig, error) {
 				MaxExchangeChanges:  AgbotMaxChanges_DEFAULT,
 				RetryLookBackWindow: AgbotRetryLookBackWindow_DEFAULT,
 				PolicySearchOrder:   AgbotPolicySearchOrder_DEFAULT,
+				AuditRetentionS:     AgbotAuditRetentionS_DEFAULT,
+				AuditMaxRecords:     AgbotAuditMaxRecords_DEFAULT,
 			},
 		}
 
@@ -435,13 +524,34 @@ func Read(file string) (*HorizonConfig, error) {
 			config.AgreementBot.MMSGarbageCollectionInterval = 300
 		}
 
+		// the work queue weights and agreement rate limits are parsed when they are used, make sure they are well formed.
+		if weights, err := ParseOrgValues(config.AgreementBot.QueueWeights); err != nil {
+			return nil, fmt.Errorf("Unable to parse AgreementBot QueueWeights: %v", err)
+		} else {
+			for key, weight := range weights {
+				if weight == 0 {
+					return nil, fmt.Errorf("Unable to parse AgreementBot QueueWeights: the weight of %v must be greater than zero", key)
+				}
+			}
+		}
+		if _, err := ParseOrgValues(config.AgreementBot.AgreementRateLimits); err != nil {
+			return nil, fmt.Errorf("Unable to parse AgreementBot AgreementRateLimits: %v", err)
+		}
+
+		// read the other exchanges served by the agbot.
+		if config.AgreementBot.FederationFile != "" {
+			if config.Federation, err = ReadFederationFile(config.AgreementBot.FederationFile); err != nil {
+				return nil, err
+			}
+		}
+
 		// success at last!
 		return &config, nil
 	}
 }
 
 func (c *HorizonConfig) String() string {
-	return fmt.Sprintf("Edge: {%v}, AgreementBot: {%v}, Collaborators: {%v}, ArchSynonyms: {%v}", c.Edge.String(), c.AgreementBot.String(), c.Collaborators.String(), c.ArchSynonyms)
+	return fmt.Sprintf("Edge: {%v}, AgreementBot: {%v}, Collaborators: {%v}, ArchSynonyms: {%v}, Federation: %v", c.Edge.String(), c.AgreementBot.String(), c.Collaborators.String(), c.ArchSynonyms, c.Federation)
 }
 
 func (con *Config) String() string {
@@ -534,7 +644,18 @@ func (agc *AGConfig) String() string {
 		", MaxExchangeChanges: %v"+
 		", RetryLookBackWindow: %v"+
 		", PolicySearchOrder: %v"+
-		", Vault: {%v}",
+		", NodeIndex: %v"+
+		", PartitionRebalance: %v"+
+		", PartitionRebalanceThreshold: %v"+
+		", PolicyCacheCheckS: %v"+
+		", PolicyCacheRepair: %v"+
+		", AuditRetentionS: %v"+
+		", AuditMaxRecords: %v"+
+		", QueueWeights: %v"+
+		", AgreementRateLimits: %v"+
+		", FederationFile: %v"+
+		", Vault: {%v}"+
+		", DataVerification: {%v}",
 		agc.TxLostDelayTolerationSeconds, agc.AgreementWorkers, agc.DBPath, agc.Postgresql.String(),
 		agc.PartitionStale, agc.ProtocolTimeoutS, agc.AgreementTimeoutS, agc.NoDataIntervalS, agc.ActiveAgreementsURL,
 		agc.ActiveAgreementsUser, mask, agc.PolicyPath, agc.NewContractIntervalS, agc.ProcessGovernanceIntervalS,
@@ -543,9 +664,13 @@ func (agc *AGConfig) String() string {
 		agc.SecureAPIListenHost, agc.SecureAPIListenPort, agc.SecureAPIServerCert, agc.SecureAPIServerKey,
 		agc.PurgeArchivedAgreementHours, agc.CheckUpdatedPolicyS, agc.CSSURL, agc.CSSSSLCert, agc.AgreementBatchSize,
 		agc.AgreementQueueSize, agc.MessageQueueScale, agc.QueueHistorySize, agc.FullRescanS, agc.MaxExchangeChanges,
-		agc.RetryLookBackWindow, agc.PolicySearchOrder, agc.Vault)
+		agc.RetryLookBackWindow, agc.PolicySearchOrder, agc.NodeIndex, agc.PartitionRebalance, agc.PartitionRebalanceThreshold, agc.PolicyCacheCheckS, agc.PolicyCacheRepair, agc.AuditRetentionS, agc.AuditMaxRecords, agc.QueueWeights, agc.AgreementRateLimits, agc.FederationFile, agc.Vault, agc.DataVerification.String())
 }
 
 func (c *VaultConfig) String() string {
 	return fmt.Sprintf("VaultURL: %v,", c.VaultURL)
 }
+
+func (c *DVConfig) String() string {
+	return fmt.Sprintf("APIListen: %v, ActiveWindowS: %v, RetentionS: %v", c.APIListen, c.ActiveWindowS, c.RetentionS)
+}
@@ -3,7 +3,9 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
+	"path"
 	"testing"
 )
 
@@ -71,3 +73,69 @@ func Test_enrichFromEnvvars_success(t *testing.T) {
 	}
 
 }
+
+func Test_ParseOrgValues(t *testing.T) {
+
+	if values, err := ParseOrgValues(" org1=3, org1/bp1=2,*=1,"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if len(values) != 3 || values["org1"] != 3 || values["org1/bp1"] != 2 || values["*"] != 1 {
+		t.Errorf("wrong values %v", values)
+	}
+
+	if values, err := ParseOrgValues(""); err != nil || len(values) != 0 {
+		t.Errorf("expected no values, got %v %v", values, err)
+	}
+
+	for _, spec := range []string{"org1", "=3", "org1=x", "org1=-1"} {
+		if _, err := ParseOrgValues(spec); err == nil {
+			t.Errorf("expected an error for %v", spec)
+		}
+	}
+
+}
+
+func Test_ReadFederationFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "federation-")
+	if err != nil {
+		t.Fatalf("unable to create temp dir, error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "federation.json")
+
+	content := `[{"Name":"west","ExchangeURL":"https://west/v1","ExchangeId":"IBM/agbot","ExchangeToken":"t1"},{"Name":"east","ExchangeURL":"https://east/v1/","ExchangeId":"IBM/agbot","ExchangeToken":"t2","CSSURL":"https://east/css"}]`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatalf("unable to write federation file, error %v", err)
+	}
+
+	cfg := &HorizonConfig{AgreementBot: AGConfig{ExchangeURL: "https://primary/v1/", PolicyPath: "/var/horizon/policy.d/", APIListen: "localhost:8046"}}
+	if cfg.Federation, err = ReadFederationFile(file); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if len(cfg.Federation) != 2 || cfg.Federation[0].ExchangeURL != "https://west/v1/" {
+		t.Errorf("wrong federated exchanges %v", cfg.Federation)
+	} else if served := cfg.GetAgbotServedExchanges(); served != "east,west" {
+		t.Errorf("wrong served exchanges %v", served)
+	}
+
+	// The config of a federated exchange uses its exchange and has no local APIs.
+	if fc := cfg.ForFederatedExchange(cfg.Federation[1]); fc.AgreementBot.ExchangeURL != "https://east/v1/" || fc.AgreementBot.CSSURL != "https://east/css" || fc.AgreementBot.APIListen != "" || fc.AgreementBot.PolicyPath != "/var/horizon/policy.d-east/" || fc.GetAgbotExchangeName() != "east" {
+		t.Errorf("wrong federated config %v", fc.AgreementBot)
+	} else if cfg.AgreementBot.ExchangeURL != "https://primary/v1/" || cfg.GetAgbotExchangeName() != "" {
+		t.Errorf("primary config was modified %v", cfg.AgreementBot)
+	}
+
+	// Names must be present and unique.
+	for _, bad := range []string{
+		`[{"ExchangeURL":"https://west/v1","ExchangeId":"IBM/agbot","ExchangeToken":"t1"}]`,
+		`[{"Name":"a","ExchangeURL":"https://west/v1","ExchangeId":"IBM/agbot","ExchangeToken":"t1"},{"Name":"a","ExchangeURL":"https://east/v1","ExchangeId":"IBM/agbot","ExchangeToken":"t2"}]`,
+		`[{"Name":"a,b","ExchangeURL":"https://west/v1","ExchangeId":"IBM/agbot","ExchangeToken":"t1"}]`,
+		`[{"Name":"a","ExchangeURL":"https://west/v1","ExchangeId":"IBM/agbot"}]`,
+	} {
+		if err := ioutil.WriteFile(file, []byte(bad), 0600); err != nil {
+			t.Fatalf("unable to write federation file, error %v", err)
+		} else if _, err := ReadFederationFile(file); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+
+}
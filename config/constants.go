@@ -57,6 +57,15 @@ const HZN_FSS_CERT_KEY_FILE = "key.pem"
 // The number of seconds between polls to the CSS for updates.
 const HZN_FSS_POLLING_RATE = 60
 
+// The default relative path of the object data cached by a site cache. This path should be combined with the HZN_VAR_BASE_DEFAULT.
+const HZN_FSS_SITE_CACHE_PATH = "site-cache"
+
+// The default maximum size in MB of the object data cached by a site cache.
+const HZN_FSS_SITE_CACHE_MAX_SIZE = 10240
+
+// The multicast group on which the site caches announce themselves to the other nodes of the LAN.
+const HZN_FSS_SITE_CACHE_MULTICAST_ADDRESS = "239.255.72.78:8446"
+
 // The Default starting exchange message polling interval.
 const ExchangeMessagePollInterval_DEFAULT = 20
 
@@ -99,6 +108,12 @@ const AgbotRetryLookBackWindow_DEFAULT = 3600
 // Policy search order
 const AgbotPolicySearchOrder_DEFAULT = true
 
+// The default number of seconds to keep agreement decisions in the audit trail, 7 days
+const AgbotAuditRetentionS_DEFAULT = 604800
+
+// The default maximum number of agreement decisions in the audit trail
+const AgbotAuditMaxRecords_DEFAULT = 100000
+
 // Scale factor of node max hb interval to wait before declaring an a agreement for that node did not finalize
 const AgreementTimeoutScaleFactor_DEFAULT = 2
 
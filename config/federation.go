@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An agbot can serve more than one exchange. The exchange in AgreementBot.ExchangeURL is the primary exchange, the
+// other exchanges are listed in the file named by AgreementBot.FederationFile. The agbot runs a separate set of workers
+// for each federated exchange, using a copy of its config in which the exchange and CSS settings are replaced by the
+// settings of the federated exchange.
+type FederatedExchange struct {
+	Name          string // A unique name for the exchange, used to tag the agreements made with the nodes in that exchange.
+	ExchangeURL   string // The URL of the exchange.
+	ExchangeId    string // The org qualified id of the agbot in the exchange.
+	ExchangeToken string // The agbot's authentication token in the exchange.
+	CSSURL        string // The URL used to access the CSS of the exchange.
+}
+
+func (f FederatedExchange) String() string {
+	return fmt.Sprintf("Name: %v, ExchangeURL: %v, ExchangeId: %v, ExchangeToken: %v, CSSURL: %v", f.Name, f.ExchangeURL, f.ExchangeId, "********", f.CSSURL)
+}
+
+// Read the list of federated exchanges from the input file, which contains a JSON array of exchanges.
+func ReadFederationFile(file string) ([]FederatedExchange, error) {
+
+	f, err := os.Open(filepath.Clean(file))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read federation file: %s. Error: %v", file, err)
+	}
+	defer f.Close()
+
+	exchanges := make([]FederatedExchange, 0, 2)
+	if err := json.NewDecoder(f).Decode(&exchanges); err != nil {
+		return nil, fmt.Errorf("Unable to decode content of federation file %s: %v", file, err)
+	}
+
+	names := make(map[string]bool)
+	for ix, fe := range exchanges {
+		if fe.Name == "" {
+			return nil, fmt.Errorf("Federated exchange %v in %s has no name", ix, file)
+		} else if strings.ContainsAny(fe.Name, ",/") {
+			return nil, fmt.Errorf("Federated exchange name %v in %s must not contain a comma or a slash", fe.Name, file)
+		} else if names[fe.Name] {
+			return nil, fmt.Errorf("Federated exchange name %v is used more than once in %s", fe.Name, file)
+		} else if fe.ExchangeURL == "" || fe.ExchangeId == "" || fe.ExchangeToken == "" {
+			return nil, fmt.Errorf("Federated exchange %v in %s must have an ExchangeURL, ExchangeId and ExchangeToken", fe.Name, file)
+		}
+		names[fe.Name] = true
+		exchanges[ix].ExchangeURL = strings.TrimRight(fe.ExchangeURL, "/") + "/"
+	}
+	return exchanges, nil
+}
+
+// Returns the exchanges, other than the primary exchange, that the agbot serves.
+func (c *HorizonConfig) GetAgbotFederatedExchanges() []FederatedExchange {
+	return c.Federation
+}
+
+// Returns the name of the exchange this config is for, empty for the primary exchange.
+func (c *HorizonConfig) GetAgbotExchangeName() string {
+	return c.FederatedExchangeName
+}
+
+// Returns the sorted, comma separated names of the federated exchanges served by the agbot. Empty when the agbot
+// serves only the primary exchange.
+func (c *HorizonConfig) GetAgbotServedExchanges() string {
+	names := make([]string, 0, len(c.Federation))
+	for _, fe := range c.Federation {
+		names = append(names, fe.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Returns a copy of the config for the workers that serve a federated exchange. The local APIs and the embedded data
+// verification service are provided by the workers of the primary exchange only. The generated policy files of each
+// exchange are kept in their own directory, next to the primary policy directory.
+func (c *HorizonConfig) ForFederatedExchange(fe FederatedExchange) *HorizonConfig {
+	fc := *c
+	fc.AgreementBot.ExchangeURL = fe.ExchangeURL
+	fc.AgreementBot.ExchangeId = fe.ExchangeId
+	fc.AgreementBot.ExchangeToken = fe.ExchangeToken
+	fc.AgreementBot.CSSURL = fe.CSSURL
+	fc.AgreementBot.PolicyPath = strings.TrimRight(c.AgreementBot.PolicyPath, "/") + "-" + fe.Name + "/"
+	fc.AgreementBot.APIListen = ""
+	fc.AgreementBot.SecureAPIListenHost = ""
+	fc.AgreementBot.DataVerification.APIListen = ""
+	fc.FederatedExchangeName = fe.Name
+	return &fc
+}
@@ -10,18 +10,27 @@ import (
 
 // Configuration for the File Sync Service, which is implemented by the embedded ESS.
 type FSSConfig struct {
-	APIListen          string // The address on which the ESS will listen. The default is in the code below. For a unix domain socket path, it must be the full path name including the file name.
-	APIPort            uint16 // The port on which the ESS will listen. For a unix domain socket, this will always be "0".
-	APIProtocol        string // Can be 'unix' or 'https'. Default is unix. The value of this field determines the Listen and Port values.
-	PersistencePath    string // The absolute location in the host filesystem where anax stores files retrieved by the file sync service.
-	AuthenticationPath string // The absolute location in the host filesystem where anax stores authentication credentials for services so that the service can authenticate to the FSS (ESS) API.
-	CSSURL             string // The URL used to access the CSS.
-	CSSSSLCert         string // The path to the client side SSL certificate for the CSS.
-	PollingRate        uint16 // The number of seconds between polls to the CSS for notification updates.
+	APIListen                string   // The address on which the ESS will listen. The default is in the code below. For a unix domain socket path, it must be the full path name including the file name.
+	APIPort                  uint16   // The port on which the ESS will listen. For a unix domain socket, this will always be "0".
+	APIProtocol              string   // Can be 'unix' or 'https'. Default is unix. The value of this field determines the Listen and Port values.
+	PersistencePath          string   // The absolute location in the host filesystem where anax stores files retrieved by the file sync service.
+	AuthenticationPath       string   // The absolute location in the host filesystem where anax stores authentication credentials for services so that the service can authenticate to the FSS (ESS) API.
+	CSSURL                   string   // The URL used to access the CSS.
+	CSSSSLCert               string   // The path to the client side SSL certificate for the CSS.
+	PollingRate              uint16   // The number of seconds between polls to the CSS for notification updates.
+	RequireSignedObjectTypes []string // The object types whose objects must be signed with a key trusted by the org in the exchange, "*" for all types. Objects that are not, are rejected.
+	SiteCacheListen          string   // The address, host:port, on which this agent serves the CSS to the other nodes of its site, caching the object data. Empty when this agent is not a site cache.
+	SiteCacheCertFile        string   // The path to the SSL certificate of the site cache. The site cache uses http when it is not set.
+	SiteCacheKeyFile         string   // The path to the SSL certificate key of the site cache.
+	SiteCacheMaxSize         uint64   // The maximum size in MB of the object data cached by the site cache.
+	SiteCachePath            string   // The absolute location in the host filesystem where the site cache stores the object data.
+	SiteCacheURLs            []string // The URLs of the site caches that the embedded ESS uses instead of the CSS URL. The first one that is reachable is used.
+	SiteCacheCACert          string   // The path to the CA certificate of the site caches. The CSS SSL certificate is used when it is not set.
+	SiteCacheMulticast       bool     // When true, a site cache with SiteCacheCertFile announces itself on the LAN, and the embedded ESS with SiteCacheCACert looks for one when SiteCacheURLs is not set.
 }
 
 func (f *FSSConfig) String() string {
-	return fmt.Sprintf("APIListen: %v, APIPort: %v, APIProtocol: %v, PersistencePath: %v, AuthenticationPath: %v, CSSURL: %v, CSSSSLCert: %v, PollingRate: %v", f.APIListen, f.APIPort, f.APIProtocol, f.PersistencePath, f.AuthenticationPath, f.CSSURL, f.CSSSSLCert, f.PollingRate)
+	return fmt.Sprintf("APIListen: %v, APIPort: %v, APIProtocol: %v, PersistencePath: %v, AuthenticationPath: %v, CSSURL: %v, CSSSSLCert: %v, PollingRate: %v, RequireSignedObjectTypes: %v, SiteCacheListen: %v, SiteCacheCertFile: %v, SiteCacheKeyFile: %v, SiteCacheMaxSize: %v, SiteCachePath: %v, SiteCacheURLs: %v, SiteCacheCACert: %v, SiteCacheMulticast: %v", f.APIListen, f.APIPort, f.APIProtocol, f.PersistencePath, f.AuthenticationPath, f.CSSURL, f.CSSSSLCert, f.PollingRate, f.RequireSignedObjectTypes, f.SiteCacheListen, f.SiteCacheCertFile, f.SiteCacheKeyFile, f.SiteCacheMaxSize, f.SiteCachePath, f.SiteCacheURLs, f.SiteCacheCACert, f.SiteCacheMulticast)
 }
 
 func (c *HorizonConfig) FSSIsUnixProtocol() bool {
@@ -122,3 +131,32 @@ func (c *HorizonConfig) GetESSPollingRate() uint16 {
 		return c.Edge.FileSyncService.PollingRate
 	}
 }
+
+func (c *HorizonConfig) IsSiteCache() bool {
+	return c.Edge.FileSyncService.SiteCacheListen != ""
+}
+
+func (c *HorizonConfig) GetSiteCacheMaxSize() uint64 {
+	if c.Edge.FileSyncService.SiteCacheMaxSize == 0 {
+		return HZN_FSS_SITE_CACHE_MAX_SIZE
+	} else {
+		return c.Edge.FileSyncService.SiteCacheMaxSize
+	}
+}
+
+func (c *HorizonConfig) GetSiteCachePath() string {
+	if c.Edge.FileSyncService.SiteCachePath == "" {
+		return path.Join(getDefaultBase(), HZN_FSS_SITE_CACHE_PATH)
+	} else {
+		return c.Edge.FileSyncService.SiteCachePath
+	}
+}
+
+// Returns the CA certificate that the embedded ESS uses to connect to a site cache.
+func (c *HorizonConfig) GetSiteCacheCACert() string {
+	if c.Edge.FileSyncService.SiteCacheCACert == "" {
+		return c.GetCSSSSLCert()
+	} else {
+		return c.Edge.FileSyncService.SiteCacheCACert
+	}
+}
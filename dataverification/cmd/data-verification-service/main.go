@@ -0,0 +1,37 @@
+// Package main Data Verification Service
+//
+// A standalone instance of the data verification service. Point the agbot's ActiveAgreementsURL at the
+// /activeagreements API of this service, and have services report data to its /data API.
+package main
+
+import (
+	"flag"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/dataverification"
+	"os"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", "0.0.0.0:8090", "Host and port for the data verification API to listen on")
+	user := flag.String("user", os.Getenv("HZN_DV_USER"), "The basic auth user required by the API, defaults to $HZN_DV_USER")
+	password := flag.String("password", os.Getenv("HZN_DV_PASSWORD"), "The basic auth password required by the API, defaults to $HZN_DV_PASSWORD")
+	activeWindow := flag.Uint64("active-window", dataverification.DEFAULT_ACTIVE_WINDOW, "The number of seconds an agreement remains active after its last data report")
+	retention := flag.Uint64("retention", dataverification.DEFAULT_RETENTION, "The number of seconds to remember an agreement after its last data report")
+
+	flag.Parse()
+
+	tracker := dataverification.NewDataTracker(*activeWindow, *retention)
+
+	// Periodically forget about agreements that have stopped reporting.
+	go func() {
+		for {
+			time.Sleep(time.Duration(*activeWindow) * time.Second)
+			tracker.Purge()
+		}
+	}()
+
+	if err := dataverification.NewServer(tracker, *user, *password).ListenAndServe(*listen); err != nil {
+		glog.Fatalf("Data verification service terminating, error: %v", err)
+	}
+}
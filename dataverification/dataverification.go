@@ -0,0 +1,245 @@
+package dataverification
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The purpose of this module is to provide a reference implementation of the data ingest API that the agbot
+// uses to verify that an agreement is sending data. Services running on a node report heartbeats or sample counts
+// for their agreement (the service receives its agreement id in the HZN_AGREEMENTID environment variable). The
+// agbot asks for the list of active agreements, which is the list of agreements that have reported data within
+// the active window. An agreement that stops reporting drops out of the list, causing the agbot to record a data
+// verification miss and eventually cancel the agreement.
+
+// The default number of seconds that an agreement remains active after its last data report.
+const DEFAULT_ACTIVE_WINDOW = 300
+
+// The default number of seconds to remember an agreement after its last data report.
+const DEFAULT_RETENTION = 86400
+
+// The response format of the active agreements API. This is the format the agbot expects from any data
+// verification service, whether it is this one or an external data ingest system.
+type AgreementEntry struct {
+	Id   string `json:"id"`
+	Type int    `json:"type"`
+	Ts   uint64 `json:"ts"`
+}
+
+type DeviceEntry struct {
+	Id         string           `json:"id"`
+	Lat        float64          `json:"lat"`
+	Lon        float64          `json:"lon"`
+	Agreements []AgreementEntry `json:"contracts"`
+}
+
+// The body of a data report sent by a service. A report with no samples is a heartbeat.
+type DataReport struct {
+	AgreementId string `json:"agreement_id"`
+	DeviceId    string `json:"device_id,omitempty"`
+	Samples     uint64 `json:"samples,omitempty"`
+}
+
+func (d DataReport) String() string {
+	return fmt.Sprintf("AgreementId: %v, DeviceId: %v, Samples: %v", d.AgreementId, d.DeviceId, d.Samples)
+}
+
+func (d DataReport) IsValid() error {
+	if d.AgreementId == "" {
+		return errors.New(fmt.Sprintf("agreement_id must be specified"))
+	}
+	return nil
+}
+
+// The data received so far for a given agreement.
+type AgreementData struct {
+	AgreementId     string `json:"agreement_id"`
+	DeviceId        string `json:"device_id"`
+	FirstReportTime uint64 `json:"first_report_time"` // The time of the first report, in seconds since 1970.
+	LastReportTime  uint64 `json:"last_report_time"`  // The time of the most recent report, in seconds since 1970.
+	Heartbeats      uint64 `json:"heartbeats"`        // The number of reports received
+	Samples         uint64 `json:"samples"`           // The total number of samples reported
+}
+
+func (a AgreementData) String() string {
+	return fmt.Sprintf("AgreementId: %v, DeviceId: %v, FirstReportTime: %v, LastReportTime: %v, Heartbeats: %v, Samples: %v",
+		a.AgreementId, a.DeviceId, a.FirstReportTime, a.LastReportTime, a.Heartbeats, a.Samples)
+}
+
+// The data tracker holds the data reports for all agreements. It is safe for concurrent use by the http handlers
+// and the agbot governance routines.
+type DataTracker struct {
+	lock          sync.Mutex
+	agreements    map[string]*AgreementData
+	activeWindowS uint64
+	retentionS    uint64
+}
+
+func NewDataTracker(activeWindowS uint64, retentionS uint64) *DataTracker {
+	if activeWindowS == 0 {
+		activeWindowS = DEFAULT_ACTIVE_WINDOW
+	}
+	if retentionS == 0 {
+		retentionS = DEFAULT_RETENTION
+	}
+	if retentionS < activeWindowS {
+		retentionS = activeWindowS
+	}
+	return &DataTracker{
+		agreements:    make(map[string]*AgreementData),
+		activeWindowS: activeWindowS,
+		retentionS:    retentionS,
+	}
+}
+
+func (d *DataTracker) String() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return fmt.Sprintf("Agreements: %v, ActiveWindowS: %v, RetentionS: %v", len(d.agreements), d.activeWindowS, d.retentionS)
+}
+
+// Record a data report at the current time.
+func (d *DataTracker) Report(report DataReport) error {
+	return d.reportAt(report, uint64(time.Now().Unix()))
+}
+
+func (d *DataTracker) reportAt(report DataReport, now uint64) error {
+	if err := report.IsValid(); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ad, ok := d.agreements[report.AgreementId]
+	if !ok {
+		ad = &AgreementData{
+			AgreementId:     report.AgreementId,
+			FirstReportTime: now,
+		}
+		d.agreements[report.AgreementId] = ad
+	}
+	if report.DeviceId != "" {
+		ad.DeviceId = report.DeviceId
+	}
+	ad.LastReportTime = now
+	ad.Heartbeats += 1
+	ad.Samples += report.Samples
+
+	glog.V(5).Infof(dvLogString(fmt.Sprintf("recorded data report %v", report)))
+	return nil
+}
+
+// Returns a copy of the data recorded for an agreement, or nil if there is none.
+func (d *DataTracker) GetAgreementData(agreementId string) *AgreementData {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if ad, ok := d.agreements[agreementId]; ok {
+		cp := *ad
+		return &cp
+	}
+	return nil
+}
+
+// Returns a copy of the data recorded for all agreements, sorted by agreement id.
+func (d *DataTracker) GetAllAgreementData() []AgreementData {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	res := make([]AgreementData, 0, len(d.agreements))
+	for _, ad := range d.agreements {
+		res = append(res, *ad)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].AgreementId < res[j].AgreementId })
+	return res
+}
+
+// Forget about an agreement, for example when the agreement has been terminated.
+func (d *DataTracker) Forget(agreementId string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.agreements, agreementId)
+}
+
+// Returns the ids of the agreements that have reported data within the active window.
+func (d *DataTracker) ActiveAgreementIds() []string {
+	return d.activeAgreementIdsAt(uint64(time.Now().Unix()))
+}
+
+func (d *DataTracker) activeAgreementIdsAt(now uint64) []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	res := make([]string, 0, len(d.agreements))
+	for id, ad := range d.agreements {
+		if ad.LastReportTime+d.activeWindowS > now {
+			res = append(res, id)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Returns the active agreements in the response format of the active agreements API. Agreements are grouped
+// by the device that reported them.
+func (d *DataTracker) ActiveAgreements() []DeviceEntry {
+	return d.activeAgreementsAt(uint64(time.Now().Unix()))
+}
+
+func (d *DataTracker) activeAgreementsAt(now uint64) []DeviceEntry {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	devices := make(map[string]*DeviceEntry)
+	for id, ad := range d.agreements {
+		if ad.LastReportTime+d.activeWindowS <= now {
+			continue
+		}
+		dev, ok := devices[ad.DeviceId]
+		if !ok {
+			dev = &DeviceEntry{Id: ad.DeviceId, Agreements: make([]AgreementEntry, 0, 1)}
+			devices[ad.DeviceId] = dev
+		}
+		dev.Agreements = append(dev.Agreements, AgreementEntry{Id: id, Type: 1, Ts: ad.LastReportTime})
+	}
+
+	res := make([]DeviceEntry, 0, len(devices))
+	for _, dev := range devices {
+		sort.Slice(dev.Agreements, func(i, j int) bool { return dev.Agreements[i].Id < dev.Agreements[j].Id })
+		res = append(res, *dev)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Id < res[j].Id })
+	return res
+}
+
+// Remove agreements that have not reported data within the retention period. Returns the number of
+// agreements removed.
+func (d *DataTracker) Purge() int {
+	return d.purgeAt(uint64(time.Now().Unix()))
+}
+
+func (d *DataTracker) purgeAt(now uint64) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	purged := 0
+	for id, ad := range d.agreements {
+		if ad.LastReportTime+d.retentionS <= now {
+			delete(d.agreements, id)
+			purged += 1
+		}
+	}
+	if purged != 0 {
+		glog.V(3).Infof(dvLogString(fmt.Sprintf("purged %v agreements with no data for %v seconds", purged, d.retentionS)))
+	}
+	return purged
+}
+
+var dvLogString = func(v interface{}) string {
+	return fmt.Sprintf("Data Verification: %v", v)
+}
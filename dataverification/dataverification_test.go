@@ -0,0 +1,134 @@
+// +build unit
+
+package dataverification
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_report_invalid(t *testing.T) {
+
+	dt := NewDataTracker(60, 600)
+
+	if err := dt.reportAt(DataReport{}, 1000); err == nil {
+		t.Errorf("expected an error for a report without an agreement id")
+	}
+
+}
+
+func Test_active_window(t *testing.T) {
+
+	dt := NewDataTracker(60, 600)
+
+	if err := dt.reportAt(DataReport{AgreementId: "ag1", DeviceId: "org/dev1"}, 1000); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if err := dt.reportAt(DataReport{AgreementId: "ag2", DeviceId: "org/dev1", Samples: 5}, 1030); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if err := dt.reportAt(DataReport{AgreementId: "ag2", Samples: 3}, 1040); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if ids := dt.activeAgreementIdsAt(1050); len(ids) != 2 {
+		t.Errorf("expected 2 active agreements, got %v", ids)
+	} else if ids := dt.activeAgreementIdsAt(1065); len(ids) != 1 || ids[0] != "ag2" {
+		t.Errorf("expected only ag2 to be active, got %v", ids)
+	} else if ids := dt.activeAgreementIdsAt(1100); len(ids) != 0 {
+		t.Errorf("expected no active agreements, got %v", ids)
+	}
+
+	if ad := dt.GetAgreementData("ag2"); ad == nil {
+		t.Errorf("expected data for ag2")
+	} else if ad.Heartbeats != 2 || ad.Samples != 8 || ad.FirstReportTime != 1030 || ad.LastReportTime != 1040 || ad.DeviceId != "org/dev1" {
+		t.Errorf("wrong data recorded for ag2: %v", ad)
+	}
+
+	if devs := dt.activeAgreementsAt(1050); len(devs) != 1 {
+		t.Errorf("expected 1 device, got %v", devs)
+	} else if devs[0].Id != "org/dev1" || len(devs[0].Agreements) != 2 || devs[0].Agreements[0].Id != "ag1" {
+		t.Errorf("wrong device entry %v", devs[0])
+	}
+
+}
+
+func Test_purge_and_forget(t *testing.T) {
+
+	dt := NewDataTracker(60, 600)
+
+	dt.reportAt(DataReport{AgreementId: "ag1"}, 1000)
+	dt.reportAt(DataReport{AgreementId: "ag2"}, 1500)
+	dt.reportAt(DataReport{AgreementId: "ag3"}, 1500)
+
+	if purged := dt.purgeAt(1700); purged != 1 {
+		t.Errorf("expected 1 purged agreement, got %v", purged)
+	} else if dt.GetAgreementData("ag1") != nil {
+		t.Errorf("ag1 should have been purged")
+	}
+
+	dt.Forget("ag2")
+	if all := dt.GetAllAgreementData(); len(all) != 1 || all[0].AgreementId != "ag3" {
+		t.Errorf("expected only ag3 to remain, got %v", all)
+	}
+
+}
+
+func Test_server_api(t *testing.T) {
+
+	dt := NewDataTracker(60, 600)
+	handler := NewServer(dt, "user", "pw").Handler()
+
+	// Unauthenticated requests are rejected.
+	req := httptest.NewRequest("GET", "/activeagreements", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %v, got %v", http.StatusUnauthorized, rr.Code)
+	}
+
+	// Report some data.
+	body, _ := json.Marshal(DataReport{AgreementId: "ag1", DeviceId: "org/dev1", Samples: 2})
+	req = httptest.NewRequest("POST", "/data", bytes.NewBuffer(body))
+	req.SetBasicAuth("user", "pw")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status %v, got %v", http.StatusCreated, rr.Code)
+	}
+
+	// An invalid report is rejected.
+	req = httptest.NewRequest("POST", "/data", bytes.NewBufferString(`{"samples":1}`))
+	req.SetBasicAuth("user", "pw")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// The agreement is now active.
+	req = httptest.NewRequest("GET", "/activeagreements", nil)
+	req.SetBasicAuth("user", "pw")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	devs := make([]DeviceEntry, 0)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %v, got %v", http.StatusOK, rr.Code)
+	} else if err := json.Unmarshal(rr.Body.Bytes(), &devs); err != nil {
+		t.Errorf("unable to demarshal response %v, error %v", rr.Body.String(), err)
+	} else if len(devs) != 1 || len(devs[0].Agreements) != 1 || devs[0].Agreements[0].Id != "ag1" {
+		t.Errorf("wrong active agreements %v", devs)
+	}
+
+	// Unknown agreements are not found.
+	req = httptest.NewRequest("GET", "/agreement/ag2", nil)
+	req.SetBasicAuth("user", "pw")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %v, got %v", http.StatusNotFound, rr.Code)
+	}
+
+}
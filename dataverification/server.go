@@ -0,0 +1,142 @@
+package dataverification
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+)
+
+// The http API of the data verification service:
+//
+//   POST /data                   Record a data report (a DataReport in the body) for an agreement.
+//   GET  /activeagreements       The agreements that have reported data within the active window. This is
+//                                the URL to configure as the agbot's ActiveAgreementsURL.
+//   GET  /agreement              The data recorded for all agreements.
+//   GET  /agreement/{id}         The data recorded for one agreement.
+//   DELETE /agreement/{id}       Forget an agreement.
+//
+// When a user and password are configured, all requests must use basic auth with those credentials.
+
+type Server struct {
+	tracker  *DataTracker
+	user     string
+	password string
+}
+
+func NewServer(tracker *DataTracker, user string, password string) *Server {
+	return &Server{
+		tracker:  tracker,
+		user:     user,
+		password: password,
+	}
+}
+
+func (s *Server) Tracker() *DataTracker {
+	return s.tracker
+}
+
+// Returns an http handler that serves the data verification API.
+func (s *Server) Handler() http.Handler {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/data", s.data).Methods("POST", "OPTIONS")
+	router.HandleFunc("/activeagreements", s.activeAgreements).Methods("GET", "OPTIONS")
+	router.HandleFunc("/agreement", s.agreement).Methods("GET", "OPTIONS")
+	router.HandleFunc("/agreement/{id}", s.agreement).Methods("GET", "DELETE", "OPTIONS")
+
+	return s.authenticate(router)
+}
+
+// Start serving the API on the given address. This function does not return unless the listener fails.
+func (s *Server) ListenAndServe(listen string) error {
+	glog.Infof(dvLogString(fmt.Sprintf("starting data verification API on %v", listen)))
+	return http.ListenAndServe(listen, s.Handler())
+}
+
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.user != "" {
+			user, pw, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) != 1 || subtle.ConstantTimeCompare([]byte(pw), []byte(s.password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="data verification"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) data(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		var report DataReport
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &report); err != nil {
+			writeResponse(w, fmt.Sprintf("unable to demarshal data report %v, error: %v", string(body), err), http.StatusBadRequest)
+		} else if err := s.tracker.Report(report); err != nil {
+			writeResponse(w, err.Error(), http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case "OPTIONS":
+		w.Header().Set("Allow", "POST, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) activeAgreements(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeResponse(w, s.tracker.ActiveAgreements(), http.StatusOK)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) agreement(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	switch r.Method {
+	case "GET":
+		if id == "" {
+			writeResponse(w, s.tracker.GetAllAgreementData(), http.StatusOK)
+		} else if ad := s.tracker.GetAgreementData(id); ad == nil {
+			writeResponse(w, fmt.Sprintf("agreement %v not found", id), http.StatusNotFound)
+		} else {
+			writeResponse(w, ad, http.StatusOK)
+		}
+	case "DELETE":
+		s.tracker.Forget(id)
+		w.WriteHeader(http.StatusNoContent)
+	case "OPTIONS":
+		w.Header().Set("Allow", "GET, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeResponse(w http.ResponseWriter, payload interface{}, statusCode int) {
+	serial, err := json.Marshal(payload)
+	if err != nil {
+		glog.Errorf(dvLogString(fmt.Sprintf("error serializing response %v, error: %v", payload, err)))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(serial); err != nil {
+		glog.Errorf(dvLogString(err))
+	}
+}
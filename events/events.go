@@ -64,6 +64,7 @@ const (
 	CACHE_SERVICE_POLICY   EventId = "CACHE_SERVICE_POLICY"
 	SERVICE_POLICY_CHANGED EventId = "SERVICE_POLICY_CHANGED"
 	SERVICE_POLICY_DELETED EventId = "SERVICE_POLICY_DELETED"
+	REPAIR_POLICY_CACHE    EventId = "REPAIR_POLICY_CACHE"
 
 	// exchange-related
 	NEW_DEVICE_REG             EventId = "NEW_DEVICE_REG"
@@ -1121,6 +1122,32 @@ func NewABApiWorkloadUpgradeMessage(id EventId, protocol string, agreementId str
 	}
 }
 
+type ABApiPolicyCacheRepairMessage struct {
+	event  Event
+	Result chan error // Receives the outcome of the repair, it must be buffered so that the repair is not blocked.
+}
+
+func (m *ABApiPolicyCacheRepairMessage) Event() Event {
+	return m.event
+}
+
+func (m ABApiPolicyCacheRepairMessage) String() string {
+	return fmt.Sprintf("Event: %v", m.event)
+}
+
+func (m ABApiPolicyCacheRepairMessage) ShortString() string {
+	return m.String()
+}
+
+func NewABApiPolicyCacheRepairMessage(id EventId, result chan error) *ABApiPolicyCacheRepairMessage {
+	return &ABApiPolicyCacheRepairMessage{
+		event: Event{
+			Id: id,
+		},
+		Result: result,
+	}
+}
+
 // Initialization and restart messages
 type InitAgreementCancelationMessage struct {
 	event             Event
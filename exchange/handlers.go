@@ -214,6 +214,15 @@ func GetHTTPObjectSigningKeysHandler(ec ExchangeContext) ObjectSigningKeysHandle
 	}
 }
 
+// A handler for getting the keys that an org trusts for signing MMS objects.
+type MMSSigningKeysHandler func(org string) (map[string]string, error)
+
+func GetHTTPMMSSigningKeysHandler(ec ExchangeContext) MMSSigningKeysHandler {
+	return func(org string) (map[string]string, error) {
+		return GetMMSSigningKeys(ec, org)
+	}
+}
+
 // A handler for getting the image docker auths for a service in the exchange.
 type ServiceDockerAuthsHandler func(sUrl string, sOrg string, sVersion string, sArch string) ([]ImageDockerAuth, error)
 
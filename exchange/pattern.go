@@ -162,8 +162,10 @@ type DataVerification struct {
 }
 
 type NodeHealth struct {
-	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
-	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBInterval        int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
+	CheckAgreementStatus     int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	UnhealthyServiceInterval int `json:"unhealthy_service_interval,omitempty"` // How long the service can be reported unhealthy by the node until the agreement is cancelled (in seconds)
+	MaxRestarts              int `json:"max_restarts,omitempty"`               // The number of container restarts after which the service is unhealthy
 }
 
 type Blockchain struct {
@@ -175,9 +177,10 @@ type Blockchain struct {
 type BlockchainList []Blockchain
 
 type AgreementProtocol struct {
-	Name            string         `json:"name,omitempty"`            // The name of the agreement protocol to be used
-	ProtocolVersion int            `json:"protocolVersion,omitempty"` // The max protocol version supported
-	Blockchains     BlockchainList `json:"blockchains,omitempty"`     // The blockchain to be used if the protocol requires one.
+	Name            string                `json:"name,omitempty"`            // The name of the agreement protocol to be used
+	ProtocolVersion int                   `json:"protocolVersion,omitempty"` // The max protocol version supported
+	Blockchains     BlockchainList        `json:"blockchains,omitempty"`     // The blockchain to be used if the protocol requires one.
+	Capabilities    *policy.CapabilitySet `json:"capabilities,omitempty"`    // The protocol capabilities to negotiate, new in V4 protocol
 }
 
 type GetPatternResponse struct {
@@ -335,20 +338,25 @@ func ConvertDataVerify(dv DataVerification, pol *policy.Policy) {
 func ConvertNodeHealth(nodeh NodeHealth, pol *policy.Policy) {
 	// Copy over the node health policy
 	nh := policy.NodeHealth_Factory(nodeh.MissingHBInterval, nodeh.CheckAgreementStatus)
+	nh.SetServiceHealth(nodeh.UnhealthyServiceInterval, nodeh.MaxRestarts)
 	pol.Add_NodeHealth(nh)
 }
 
 // Copy Agreement protocol metadata into the policy
 func ConvertAgreementProtocol(p *Pattern, pol *policy.Policy) {
 	if p.AgreementProtocols == nil || len(p.AgreementProtocols) == 0 {
-		// add default agreement protocol
+		// add default agreement protocol, without a protocol version so that the highest version supported by both the
+		// agbot and the node is used
 		newAGP := policy.AgreementProtocol_Factory(policy.BasicProtocol)
 		newAGP.Initialize()
+		newAGP.ProtocolVersion = 0
 		pol.Add_Agreement_Protocol(newAGP)
 	} else {
 		for _, agp := range p.AgreementProtocols {
 			newAGP := policy.AgreementProtocol_Factory(agp.Name)
 			newAGP.Initialize()
+			newAGP.ProtocolVersion = agp.ProtocolVersion
+			newAGP.Capabilities = agp.Capabilities
 			for _, bc := range agp.Blockchains {
 				newBC := policy.Blockchain_Factory(bc.Type, bc.Name, bc.Org)
 				(&newAGP.Blockchains).Add_Blockchain(newBC)
@@ -244,6 +244,45 @@ func GetExchangeDevice(httpClientFactory *config.HTTPClientFactory, deviceId str
 	}
 }
 
+// Get all the devices of an org with one exchange call.
+func GetExchangeOrgDevices(httpClientFactory *config.HTTPClientFactory, orgId string, credId string, credPasswd string, exchangeUrl string) (map[string]Device, error) {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("retrieving devices of org %v from exchange", orgId)))
+
+	var resp interface{}
+	resp = new(GetDevicesResponse)
+	targetURL := exchangeUrl + "orgs/" + orgId + "/nodes"
+
+	retryCount := httpClientFactory.RetryCount
+	retryInterval := httpClientFactory.GetRetryInterval()
+	for {
+		if err, tpErr := InvokeExchange(httpClientFactory.NewHTTPClient(nil), "GET", targetURL, credId, credPasswd, nil, &resp); err != nil {
+			glog.Errorf(err.Error())
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
+			if httpClientFactory.RetryCount == 0 {
+				time.Sleep(time.Duration(retryInterval) * time.Second)
+				continue
+			} else if retryCount == 0 {
+				return nil, fmt.Errorf("Exceeded %v retries for error: %v", httpClientFactory.RetryCount, tpErr)
+			} else {
+				retryCount--
+				time.Sleep(time.Duration(retryInterval) * time.Second)
+				continue
+			}
+		} else {
+			// The exchange returns 404 when the org has no devices.
+			devs := resp.(*GetDevicesResponse).Devices
+			if devs == nil {
+				devs = map[string]Device{}
+			}
+			glog.V(3).Infof(rpclogString(fmt.Sprintf("retrieved %v devices of org %v from exchange", len(devs), orgId)))
+			return devs, nil
+		}
+	}
+}
+
 // modify the the device
 func PutExchangeDevice(httpClientFactory *config.HTTPClientFactory, deviceId string, deviceToken string, exchangeUrl string, pdr *PutDeviceRequest) (*PutDeviceResponse, error) {
 	// create PUT body
@@ -317,13 +356,38 @@ func PatchExchangeDevice(httpClientFactory *config.HTTPClientFactory, deviceId s
 }
 
 type NodeStatus struct {
-	RunningServices string `json:"runningServices,omitempty"`
+	RunningServices string          `json:"runningServices,omitempty"`
+	Services        []ServiceStatus `json:"services,omitempty"`
 }
 
 func (w NodeStatus) String() string {
 	return fmt.Sprintf(
-		"Running Services: %v",
-		w.RunningServices)
+		"Running Services: %v, Services: %v",
+		w.RunningServices, w.Services)
+}
+
+// The status of a service as reported by the node.
+type ServiceStatus struct {
+	AgreementId string                   `json:"agreementId"`
+	ServiceURL  string                   `json:"serviceUrl,omitempty"`
+	Org         string                   `json:"orgid,omitempty"`
+	Version     string                   `json:"version,omitempty"`
+	Arch        string                   `json:"arch,omitempty"`
+	Containers  []ServiceContainerStatus `json:"containerStatus"`
+	ConfigState string                   `json:"configState,omitempty"`
+}
+
+func (s ServiceStatus) String() string {
+	return fmt.Sprintf("AgreementId: %v, ServiceURL: %v, Org: %v, Version: %v, Arch: %v, Containers: %v, ConfigState: %v",
+		s.AgreementId, s.ServiceURL, s.Org, s.Version, s.Arch, s.Containers, s.ConfigState)
+}
+
+type ServiceContainerStatus struct {
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	Created  int64  `json:"created"`
+	State    string `json:"state"`
+	Restarts int    `json:"restarts,omitempty"`
 }
 
 func GetNodeStatus(ec ExchangeContext, deviceId string) (*NodeStatus, error) {
@@ -723,6 +787,21 @@ type GetOrganizationResponse struct {
 	LastIndex int                     `json:"lastIndex"`
 }
 
+// The error returned when the exchange does not have the organization.
+type OrgNotFoundError struct {
+	Org string
+}
+
+func (e OrgNotFoundError) Error() string {
+	return fmt.Sprintf("organization %v not found", e.Org)
+}
+
+// Return true if the error means that the organization does not exist in the exchange.
+func IsOrgNotFound(err error) bool {
+	_, ok := err.(OrgNotFoundError)
+	return ok
+}
+
 // Get the metadata for a specific organization.
 func GetOrganization(httpClientFactory *config.HTTPClientFactory, org string, exURL string, id string, token string) (*Organization, error) {
 
@@ -759,7 +838,7 @@ func GetOrganization(httpClientFactory *config.HTTPClientFactory, org string, ex
 		} else {
 			orgs := resp.(*GetOrganizationResponse).Orgs
 			if theOrg, ok := orgs[org]; !ok {
-				return nil, errors.New(fmt.Sprintf("organization %v not found", org))
+				return nil, OrgNotFoundError{Org: org}
 			} else {
 				glog.V(3).Infof(rpclogString(fmt.Sprintf("found organization %v definition %v", org, theOrg)))
 				UpdateCache(org, ORG_DEF_TYPE_CACHE, theOrg)
@@ -1362,3 +1441,85 @@ func GetObjectSigningKeys(ec ExchangeContext, oType string, oURL string, oOrg st
 
 	return ret, nil
 }
+
+// This function gets the names and contents of the keys that an org trusts for signing model management (MMS) objects.
+// The keys are the PEM public keys or x509 certificates added with "hzn exchange org addmmskey".
+func GetMMSSigningKeys(ec ExchangeContext, org string) (map[string]string, error) {
+
+	glog.V(3).Infof(rpclogString(fmt.Sprintf("getting MMS signing keys for org %v", org)))
+
+	targetURL := fmt.Sprintf("%vorgs/%v/mmskeys", ec.GetExchangeURL(), org)
+
+	// get all the signing key names of the org
+	var resp_KeyNames interface{}
+	resp_KeyNames = ""
+
+	key_names := make([]string, 0)
+
+	retryCount := ec.GetHTTPFactory().RetryCount
+	retryInterval := ec.GetHTTPFactory().GetRetryInterval()
+	for {
+		if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", targetURL, ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_KeyNames); err != nil {
+			glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+			return nil, err
+		} else if tpErr != nil {
+			glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
+			if ec.GetHTTPFactory().RetryCount == 0 {
+				time.Sleep(time.Duration(retryInterval) * time.Second)
+				continue
+			} else if retryCount == 0 {
+				return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
+			} else {
+				retryCount--
+				time.Sleep(time.Duration(retryInterval) * time.Second)
+				continue
+			}
+		} else {
+			if resp_KeyNames.(string) != "" {
+				glog.V(5).Infof(rpclogString(fmt.Sprintf("found MMS signing keys %v.", resp_KeyNames)))
+				if err := json.Unmarshal([]byte(resp_KeyNames.(string)), &key_names); err != nil {
+					return nil, errors.New(fmt.Sprintf("Unable to demarshal MMS key list %v to string array, error: %v", resp_KeyNames, err))
+				}
+			}
+			break
+		}
+	}
+
+	// get the key contents
+	ret := make(map[string]string)
+
+	for _, key := range key_names {
+		var resp_KeyContent interface{}
+		resp_KeyContent = ""
+
+		retryCount := ec.GetHTTPFactory().RetryCount
+		retryInterval := ec.GetHTTPFactory().GetRetryInterval()
+		for {
+			if err, tpErr := InvokeExchange(ec.GetHTTPFactory().NewHTTPClient(nil), "GET", fmt.Sprintf("%v/%v", targetURL, key), ec.GetExchangeId(), ec.GetExchangeToken(), nil, &resp_KeyContent); err != nil {
+				glog.Errorf(rpclogString(fmt.Sprintf(err.Error())))
+				return nil, err
+			} else if tpErr != nil {
+				glog.Warningf(rpclogString(fmt.Sprintf(tpErr.Error())))
+				if ec.GetHTTPFactory().RetryCount == 0 {
+					time.Sleep(time.Duration(retryInterval) * time.Second)
+					continue
+				} else if retryCount == 0 {
+					return nil, fmt.Errorf("Exceeded %v retries for error: %v", ec.GetHTTPFactory().RetryCount, tpErr)
+				} else {
+					retryCount--
+					time.Sleep(time.Duration(retryInterval) * time.Second)
+					continue
+				}
+			} else {
+				if resp_KeyContent.(string) != "" {
+					ret[key] = resp_KeyContent.(string)
+				} else {
+					glog.Warningf(rpclogString(fmt.Sprintf("could not find key content for MMS key %v", key)))
+				}
+				break
+			}
+		}
+	}
+
+	return ret, nil
+}
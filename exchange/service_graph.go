@@ -0,0 +1,329 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/open-horizon/anax/cutil"
+	"github.com/open-horizon/anax/semanticversion"
+	"sort"
+	"strings"
+)
+
+// The kinds of problems found while resolving a service dependency graph.
+const (
+	GRAPH_PROBLEM_CYCLE         = "cycle"
+	GRAPH_PROBLEM_UNSATISFIABLE = "unsatisfiable"
+	GRAPH_PROBLEM_ARCH          = "arch"
+	GRAPH_PROBLEM_SHARING       = "sharing"
+)
+
+// A service in a resolved dependency graph.
+type ServiceGraphNode struct {
+	Id       string             `json:"id"`
+	URL      string             `json:"url"`
+	Org      string             `json:"org"`
+	Version  string             `json:"version"`
+	Arch     string             `json:"arch"`
+	Sharable string             `json:"sharable"`
+	Requires []ServiceGraphEdge `json:"requires,omitempty"`
+}
+
+// A required service of a service in a resolved dependency graph. The target is the id of the service the version
+// range resolved to, it is empty when the range cannot be satisfied.
+type ServiceGraphEdge struct {
+	URL          string `json:"url"`
+	Org          string `json:"org"`
+	VersionRange string `json:"versionRange"`
+	Arch         string `json:"arch"`
+	Target       string `json:"target"`
+}
+
+type ServiceGraphProblem struct {
+	Kind    string `json:"kind"`
+	Service string `json:"service"`
+	Message string `json:"message"`
+}
+
+func (p ServiceGraphProblem) String() string {
+	return fmt.Sprintf("%v: %v: %v", p.Kind, p.Service, p.Message)
+}
+
+// The fully resolved dependency graph of one or more top level services, one for each arch variant of a service.
+// Every required service is resolved to the highest version within its version range, the same way the agent
+// resolves it.
+type ServiceGraph struct {
+	Roots    []string                     `json:"roots"`
+	Services map[string]*ServiceGraphNode `json:"services"`
+	Problems []ServiceGraphProblem        `json:"problems,omitempty"`
+}
+
+func NewServiceGraph() *ServiceGraph {
+	return &ServiceGraph{
+		Roots:    []string{},
+		Services: make(map[string]*ServiceGraphNode),
+	}
+}
+
+// AddRoot resolves a top level service and all the services it requires, directly or indirectly, into the graph.
+// A top level service that cannot be found is an error, the problems found in its dependencies are recorded in the
+// graph.
+func (g *ServiceGraph) AddRoot(url string, org string, versionRange string, arch string, serviceHandler ServiceHandler) error {
+	sDef, sId, err := resolveGraphService(url, org, versionRange, arch, serviceHandler)
+	if err != nil {
+		return err
+	} else if sDef == nil {
+		return fmt.Errorf("unable to find service %v %v %v %v", url, org, versionRange, arch)
+	}
+	g.Roots = append(g.Roots, sId)
+	g.addService(sId, org, sDef, []string{}, serviceHandler)
+	return nil
+}
+
+// Adds a resolved service and, recursively, its required services. The path holds the ids of the services that
+// lead to this one, to detect the cycles.
+func (g *ServiceGraph) addService(sId string, org string, sDef *ServiceDefinition, path []string, serviceHandler ServiceHandler) {
+	for i, id := range path {
+		if id == sId {
+			g.addProblem(GRAPH_PROBLEM_CYCLE, sId, fmt.Sprintf("dependency cycle %v", strings.Join(append(path[i:], sId), " -> ")))
+			return
+		}
+	}
+	if _, ok := g.Services[sId]; ok {
+		return
+	}
+
+	node := &ServiceGraphNode{Id: sId, URL: sDef.URL, Org: org, Version: sDef.Version, Arch: sDef.Arch, Sharable: sDef.Sharable, Requires: []ServiceGraphEdge{}}
+	g.Services[sId] = node
+	path = append(path, sId)
+
+	for _, sDep := range sDef.RequiredServices {
+		vRange := sDep.VersionRange
+		if vRange == "" {
+			vRange = sDep.Version
+		}
+		edge := ServiceGraphEdge{URL: sDep.URL, Org: sDep.Org, VersionRange: vRange, Arch: sDep.Arch}
+
+		if sDep.Arch != sDef.Arch {
+			g.addProblem(GRAPH_PROBLEM_ARCH, sId, fmt.Sprintf("required service %v/%v has arch %v, which is different from the arch %v of the service", sDep.Org, sDep.URL, sDep.Arch, sDef.Arch))
+		}
+
+		depDef, depId, err := resolveGraphService(sDep.URL, sDep.Org, vRange, sDep.Arch, serviceHandler)
+		if err != nil {
+			g.addProblem(GRAPH_PROBLEM_UNSATISFIABLE, sId, fmt.Sprintf("unable to resolve required service %v/%v %v %v: %v", sDep.Org, sDep.URL, vRange, sDep.Arch, err))
+		} else if depDef == nil {
+			g.addProblem(GRAPH_PROBLEM_UNSATISFIABLE, sId, fmt.Sprintf("no version of required service %v/%v %v is within the version range %v", sDep.Org, sDep.URL, sDep.Arch, vRange))
+		} else {
+			edge.Target = depId
+			g.addService(depId, sDep.Org, depDef, path, serviceHandler)
+		}
+		node.Requires = append(node.Requires, edge)
+	}
+}
+
+// Resolves a service to the highest version within the version range and returns it with its id.
+func resolveGraphService(url string, org string, versionRange string, arch string, serviceHandler ServiceHandler) (*ServiceDefinition, string, error) {
+	if versionRange == "" {
+		versionRange = "0.0.0"
+	}
+	vExp, err := semanticversion.Version_Expression_Factory(versionRange)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid version range %v: %v", versionRange, err)
+	}
+	sDef, sId, err := serviceHandler(url, org, vExp.Get_expression(), arch)
+	if err != nil || sDef == nil {
+		return nil, "", err
+	}
+	if sId == "" {
+		sId = cutil.FormOrgSpecUrl(cutil.FormExchangeIdForService(sDef.URL, sDef.Version, sDef.Arch), org)
+	}
+	return sDef, sId, nil
+}
+
+func (g *ServiceGraph) addProblem(kind string, service string, message string) {
+	for _, p := range g.Problems {
+		if p.Kind == kind && p.Service == service && p.Message == message {
+			return
+		}
+	}
+	g.Problems = append(g.Problems, ServiceGraphProblem{Kind: kind, Service: service, Message: message})
+}
+
+// CheckSharing records the conflicts between the sharing modes of the services in the graph. Only one instance of
+// a service that is not 'multiple' can run on a node, so it cannot be needed in more than one version. The versions
+// of a service must agree on the sharing mode, and an 'exclusive' service cannot be required by more than one
+// service.
+func (g *ServiceGraph) CheckSharing() {
+	versions := make(map[string][]*ServiceGraphNode)
+	parents := make(map[string][]string)
+	for _, id := range g.sortedIds() {
+		node := g.Services[id]
+		key := cutil.FormOrgSpecUrl(node.URL, node.Org) + " " + node.Arch
+		versions[key] = append(versions[key], node)
+		for _, edge := range node.Requires {
+			if edge.Target != "" {
+				parents[edge.Target] = append(parents[edge.Target], id)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		nodes := versions[key]
+		if len(nodes) < 2 {
+			continue
+		}
+		vers, modes := []string{}, make(map[string]bool)
+		for _, n := range nodes {
+			vers = append(vers, n.Version)
+			modes[sharingMode(n.Sharable)] = true
+		}
+		if len(modes) > 1 {
+			g.addProblem(GRAPH_PROBLEM_SHARING, key, fmt.Sprintf("versions %v have different sharing modes", strings.Join(vers, ", ")))
+		} else if !modes[MS_SHARING_MODE_MULTIPLE] {
+			g.addProblem(GRAPH_PROBLEM_SHARING, key, fmt.Sprintf("versions %v are required, but only one instance of a %v service can run on a node", strings.Join(vers, ", "), sharingMode(nodes[0].Sharable)))
+		}
+	}
+
+	for _, id := range g.sortedIds() {
+		if g.Services[id].Sharable == MS_SHARING_MODE_EXCLUSIVE && len(parents[id]) > 1 {
+			g.addProblem(GRAPH_PROBLEM_SHARING, id, fmt.Sprintf("the exclusive service is required by %v", strings.Join(parents[id], ", ")))
+		}
+	}
+}
+
+// The deprecated 'single' sharing mode is the same as 'singleton'.
+func sharingMode(sharable string) string {
+	if sharable == MS_SHARING_MODE_SINGLE {
+		return MS_SHARING_MODE_SINGLETON
+	}
+	return sharable
+}
+
+func (g *ServiceGraph) sortedIds() []string {
+	ids := make([]string, 0, len(g.Services))
+	for id := range g.Services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Dot returns the graph in the DOT language of graphviz. Unsatisfiable required services are drawn in red.
+func (g *ServiceGraph) Dot() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph services {\n")
+	buf.WriteString("  node [shape=box];\n")
+	for _, id := range g.sortedIds() {
+		node := g.Services[id]
+		buf.WriteString(fmt.Sprintf("  %q [label=%q];\n", id, fmt.Sprintf("%v/%v\n%v %v\n%v", node.Org, node.URL, node.Version, node.Arch, node.Sharable)))
+	}
+	for _, id := range g.sortedIds() {
+		for _, edge := range g.Services[id].Requires {
+			if edge.Target != "" {
+				buf.WriteString(fmt.Sprintf("  %q -> %q [label=%q];\n", id, edge.Target, edge.VersionRange))
+			} else {
+				missing := fmt.Sprintf("%v/%v %v %v", edge.Org, edge.URL, edge.VersionRange, edge.Arch)
+				buf.WriteString(fmt.Sprintf("  %q [color=red];\n", missing))
+				buf.WriteString(fmt.Sprintf("  %q -> %q [color=red];\n", id, missing))
+			}
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// Tree returns the graph as indented text, one tree per top level service. A service that is required more than
+// once is only expanded the first time.
+func (g *ServiceGraph) Tree() string {
+	var buf bytes.Buffer
+	expanded := make(map[string]bool)
+
+	var write func(id string, indent string, via *ServiceGraphEdge)
+	write = func(id string, indent string, via *ServiceGraphEdge) {
+		rangeStr := ""
+		if via != nil {
+			rangeStr = fmt.Sprintf(" (%v)", via.VersionRange)
+		}
+		node := g.Services[id]
+		if node == nil {
+			buf.WriteString(fmt.Sprintf("%v%v/%v %v%v: unsatisfiable\n", indent, via.Org, via.URL, via.Arch, rangeStr))
+			return
+		}
+		buf.WriteString(fmt.Sprintf("%v%v/%v %v %v%v [%v]", indent, node.Org, node.URL, node.Version, node.Arch, rangeStr, node.Sharable))
+		if expanded[id] && len(node.Requires) != 0 {
+			buf.WriteString(" ...\n")
+			return
+		}
+		buf.WriteString("\n")
+		expanded[id] = true
+		for i := range node.Requires {
+			write(node.Requires[i].Target, indent+"  ", &node.Requires[i])
+		}
+	}
+
+	for _, root := range g.Roots {
+		write(root, "", nil)
+	}
+	return buf.String()
+}
+
+// A service to be ordered by SortByDependency, with the services it requires.
+type DependencyOrderItem struct {
+	Id       string
+	Org      string
+	URL      string
+	Arch     string
+	Requires []ServiceDependency
+}
+
+// SortByDependency returns the ids of the services in an order in which every service comes after the services in
+// the list that it requires, so that they can be created in that order. A required service is matched by org, url and
+// arch, any version, and a required service without an arch has the arch of the service that requires it. A service
+// that requires another version of its own url and arch does not wait for it. Otherwise the order of the list is kept.
+func SortByDependency(services []DependencyOrderItem) ([]string, error) {
+	key := func(org, url, arch string) string { return org + "/" + url + "/" + arch }
+
+	remaining := services
+	sorted := make([]string, 0, len(services))
+	for len(remaining) != 0 {
+		pending := make(map[string]bool)
+		for _, s := range remaining {
+			pending[key(s.Org, s.URL, s.Arch)] = true
+		}
+
+		next := []DependencyOrderItem{}
+		for _, s := range remaining {
+			ready := true
+			for _, rs := range s.Requires {
+				arch := rs.Arch
+				if arch == "" {
+					arch = s.Arch
+				}
+				if rkey := key(rs.Org, rs.URL, arch); rkey != key(s.Org, s.URL, s.Arch) && pending[rkey] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, s.Id)
+			} else {
+				next = append(next, s)
+			}
+		}
+
+		if len(next) == len(remaining) {
+			ids := []string{}
+			for _, s := range next {
+				ids = append(ids, s.Id)
+			}
+			return nil, errors.New(fmt.Sprintf("the required services of %v form a cycle", strings.Join(ids, ", ")))
+		}
+		remaining = next
+	}
+	return sorted, nil
+}
@@ -0,0 +1,117 @@
+// +build unit
+
+package exchange
+
+import (
+	"github.com/open-horizon/anax/semanticversion"
+	"strings"
+	"testing"
+)
+
+// Returns a service handler that resolves the services in the list the same way the exchange handler does.
+func graphServiceHandler(services map[string]ServiceDefinition) ServiceHandler {
+	return func(wUrl string, wOrg string, wVersion string, wArch string) (*ServiceDefinition, string, error) {
+		candidates := make(map[string]ServiceDefinition)
+		for id, s := range services {
+			if s.URL == wUrl && strings.HasPrefix(id, wOrg+"/") && s.Arch == wArch {
+				candidates[id] = s
+			}
+		}
+		vRange, _ := semanticversion.Version_Expression_Factory(wVersion)
+		if highest, sDef, sId, err := GetHighestVersion(candidates, vRange); err != nil || highest == "" {
+			return nil, "", err
+		} else {
+			return &sDef, sId, nil
+		}
+	}
+}
+
+func Test_service_graph_resolve(t *testing.T) {
+
+	services := map[string]ServiceDefinition{
+		"org1/top_1.0.0_amd64":   {URL: "top", Version: "1.0.0", Arch: "amd64", Sharable: MS_SHARING_MODE_MULTIPLE, RequiredServices: []ServiceDependency{{URL: "gps", Org: "org1", VersionRange: "[1.0.0,2.0.0)", Arch: "amd64"}, {URL: "cpu", Org: "org2", VersionRange: "[1.0.0,2.0.0)", Arch: "amd64"}}},
+		"org1/gps_1.2.0_amd64":   {URL: "gps", Version: "1.2.0", Arch: "amd64", Sharable: MS_SHARING_MODE_SINGLETON, RequiredServices: []ServiceDependency{{URL: "cpu", Org: "org2", VersionRange: "[2.0.0,INFINITY)", Arch: "amd64"}}},
+		"org1/gps_2.0.0_amd64":   {URL: "gps", Version: "2.0.0", Arch: "amd64", Sharable: MS_SHARING_MODE_SINGLETON},
+		"org2/cpu_1.5.0_amd64":   {URL: "cpu", Version: "1.5.0", Arch: "amd64", Sharable: MS_SHARING_MODE_SINGLETON},
+		"org2/cpu_2.1.0_amd64":   {URL: "cpu", Version: "2.1.0", Arch: "amd64", Sharable: MS_SHARING_MODE_SINGLETON},
+		"org1/loop_1.0.0_amd64":  {URL: "loop", Version: "1.0.0", Arch: "amd64", RequiredServices: []ServiceDependency{{URL: "loop2", Org: "org1", VersionRange: "1.0.0", Arch: "amd64"}}},
+		"org1/loop2_1.0.0_amd64": {URL: "loop2", Version: "1.0.0", Arch: "amd64", RequiredServices: []ServiceDependency{{URL: "loop", Org: "org1", VersionRange: "1.0.0", Arch: "amd64"}, {URL: "none", Org: "org1", VersionRange: "1.0.0", Arch: "amd64"}}},
+	}
+	handler := graphServiceHandler(services)
+
+	g := NewServiceGraph()
+	if err := g.AddRoot("top", "org1", "", "amd64", handler); err != nil {
+		t.Fatalf("unable to resolve the graph, error %v", err)
+	}
+	g.CheckSharing()
+
+	if len(g.Services) != 4 {
+		t.Errorf("expected 4 services, got %v", g.Services)
+	} else if g.Services["org1/gps_1.2.0_amd64"] == nil || g.Services["org1/gps_1.2.0_amd64"].Requires[0].Target != "org2/cpu_2.1.0_amd64" {
+		t.Errorf("expected gps 1.2.0 to require cpu 2.1.0, got %v", g.Services["org1/gps_1.2.0_amd64"])
+	} else if len(g.Problems) != 1 || g.Problems[0].Kind != GRAPH_PROBLEM_SHARING {
+		t.Errorf("expected a sharing conflict for the 2 versions of cpu, got %v", g.Problems)
+	}
+
+	if !strings.Contains(g.Dot(), `"org1/top_1.0.0_amd64" -> "org2/cpu_1.5.0_amd64"`) {
+		t.Errorf("wrong DOT output %v", g.Dot())
+	} else if tree := g.Tree(); !strings.HasPrefix(tree, "org1/top 1.0.0 amd64 [multiple]\n  org1/gps 1.2.0 amd64 ([1.0.0,2.0.0)) [singleton]\n") {
+		t.Errorf("wrong tree output %v", tree)
+	}
+
+	g = NewServiceGraph()
+	if err := g.AddRoot("loop", "org1", "", "amd64", handler); err != nil {
+		t.Fatalf("unable to resolve the graph, error %v", err)
+	}
+	kinds := map[string]bool{}
+	for _, p := range g.Problems {
+		kinds[p.Kind] = true
+	}
+	if len(g.Problems) != 2 || !kinds[GRAPH_PROBLEM_CYCLE] || !kinds[GRAPH_PROBLEM_UNSATISFIABLE] {
+		t.Errorf("expected a cycle and an unsatisfiable range, got %v", g.Problems)
+	} else if !strings.Contains(g.Tree(), "org1/none amd64 (1.0.0): unsatisfiable") {
+		t.Errorf("wrong tree output %v", g.Tree())
+	}
+
+	if err := NewServiceGraph().AddRoot("missing", "org1", "", "amd64", handler); err == nil {
+		t.Errorf("expected an error for a missing top level service")
+	}
+}
+
+func Test_SortByDependency(t *testing.T) {
+
+	item := func(id string, url string, arch string, requires ...ServiceDependency) DependencyOrderItem {
+		return DependencyOrderItem{Id: id, Org: "myorg", URL: url, Arch: arch, Requires: requires}
+	}
+	dep := func(url string, arch string) ServiceDependency {
+		return ServiceDependency{URL: url, Org: "myorg", VersionRange: "1.0.0", Arch: arch}
+	}
+
+	tests := []struct {
+		name     string
+		services []DependencyOrderItem
+		expected string
+		err      bool
+	}{
+		{"empty", []DependencyOrderItem{}, "", false},
+		{"order is kept", []DependencyOrderItem{item("b", "b", "amd64"), item("a", "a", "amd64")}, "b,a", false},
+		{"chain", []DependencyOrderItem{item("top", "top", "amd64", dep("mid", "amd64")), item("mid", "mid", "amd64", dep("leaf", "amd64")), item("leaf", "leaf", "amd64")}, "leaf,mid,top", false},
+		{"other org", []DependencyOrderItem{item("top", "top", "amd64", ServiceDependency{URL: "leaf", Org: "other", Arch: "amd64"}), item("leaf", "leaf", "amd64")}, "top,leaf", false},
+		{"other arch", []DependencyOrderItem{item("top", "top", "amd64", dep("leaf", "arm64")), item("leaf", "leaf", "amd64")}, "top,leaf", false},
+		{"empty arch is the parent arch", []DependencyOrderItem{item("top", "top", "arm64", dep("leaf", "")), item("leaf", "leaf", "arm64")}, "leaf,top", false},
+		{"any version", []DependencyOrderItem{item("top", "top", "amd64", dep("leaf", "amd64")), item("leaf1", "leaf", "amd64"), item("leaf2", "leaf", "amd64")}, "leaf1,leaf2,top", false},
+		{"other version of itself", []DependencyOrderItem{item("top2", "top", "amd64", dep("top", "amd64")), item("top1", "top", "amd64")}, "top2,top1", false},
+		{"cycle", []DependencyOrderItem{item("a", "a", "amd64", dep("b", "amd64")), item("b", "b", "amd64", dep("a", "")), item("c", "c", "amd64")}, "", true},
+	}
+
+	for _, test := range tests {
+		if ids, err := SortByDependency(test.services); test.err && (err == nil || !strings.Contains(err.Error(), "a, b form a cycle")) {
+			t.Errorf("%v: expected a cycle error, got %v %v", test.name, ids, err)
+		} else if !test.err && err != nil {
+			t.Errorf("%v: unexpected error %v", test.name, err)
+		} else if !test.err && strings.Join(ids, ",") != test.expected {
+			t.Errorf("%v: wrong order %v, expected %v", test.name, ids, test.expected)
+		}
+	}
+
+}
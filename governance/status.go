@@ -19,18 +19,22 @@ import (
 )
 
 type ContainerStatus struct {
-	Name    string `json:"name"`
-	Image   string `json:"image"`
-	Created int64  `json:"created"`
-	State   string `json:"state"`
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	Created  int64  `json:"created"`
+	State    string `json:"state"`
+	Restarts int    `json:"restarts,omitempty"` // The number of times the container has been restarted or recreated
+	// The restart count reported by docker or kubernetes for the current instance of the container
+	RuntimeRestarts int `json:"-"`
 }
 
 func (w ContainerStatus) String() string {
 	return fmt.Sprintf("Name: %v, "+
 		"Image: %v, "+
 		"Created: %v, "+
-		"State: %v",
-		w.Name, w.Image, w.Created, w.State)
+		"State: %v, "+
+		"Restarts: %v",
+		w.Name, w.Image, w.Created, w.State, w.Restarts)
 }
 
 type WorkloadStatus struct {
@@ -98,6 +102,7 @@ func (w *GovernanceWorker) reportDeviceStatus(cfgStates []events.ServiceConfigSt
 
 	// get docker containers
 	containers := make([]docker.APIContainers, 0)
+	restartCounts := make(map[string]int)
 	if w.deviceType == persistence.DEVICE_TYPE_DEVICE {
 		if client, err := docker.NewClient(w.Config.Edge.DockerEndpoint); err != nil {
 			glog.Errorf(logString(fmt.Sprintf("Failed to instantiate docker Client: %v", err)))
@@ -105,6 +110,8 @@ func (w *GovernanceWorker) reportDeviceStatus(cfgStates []events.ServiceConfigSt
 			containers, err = client.ListContainers(docker.ListContainersOptions{})
 			if err != nil {
 				glog.Errorf(logString(fmt.Sprintf("Unable to get list of running containers: %v", err)))
+			} else {
+				restartCounts = getContainerRestartCounts(client, containers)
 			}
 		}
 	}
@@ -113,6 +120,7 @@ func (w *GovernanceWorker) reportDeviceStatus(cfgStates []events.ServiceConfigSt
 	if ms_status, err := w.getServiceStatus(containers); err != nil {
 		glog.Errorf(logString(fmt.Sprintf("Error getting service container status: %v", err)))
 	} else {
+		setContainerRestartCounts(ms_status, restartCounts)
 		device_status.Services = ms_status
 	}
 
@@ -149,6 +157,7 @@ func (w *GovernanceWorker) reportDeviceStatus(cfgStates []events.ServiceConfigSt
 	if err != nil {
 		glog.Errorf(logString(fmt.Sprintf("Failed to retrieve previous device status from local database: %v", err)))
 	} else {
+		countContainerRestarts(device_status.Services, oldWlStatus)
 		nonChangedServices := getNonChangedServices(device_status.Services, oldWlStatus)
 		device_status.Services = append(device_status.Services, nonChangedServices...)
 
@@ -170,6 +179,64 @@ func (w *GovernanceWorker) reportDeviceStatus(cfgStates []events.ServiceConfigSt
 	return 60
 }
 
+// getContainerRestartCounts returns the number of times docker restarted each of the anax containers, by container name.
+// The restart policy of a container restarts it in place, so its created time does not change.
+func getContainerRestartCounts(client *docker.Client, containers []docker.APIContainers) map[string]int {
+	counts := make(map[string]int)
+	for _, c := range containers {
+		if _, ok := c.Labels[container.LABEL_PREFIX+".agreement_id"]; !ok || len(c.Names) == 0 {
+			continue
+		} else if conDetail, err := client.InspectContainer(c.ID); err != nil {
+			glog.Warningf(logString(fmt.Sprintf("Unable to inspect container %v: %v", c.Names[0], err)))
+		} else {
+			counts[c.Names[0]] = conDetail.RestartCount
+		}
+	}
+	return counts
+}
+
+// setContainerRestartCounts sets the docker restart count of each service container.
+func setContainerRestartCounts(services []WorkloadStatus, counts map[string]int) {
+	for i, svc := range services {
+		for j, c := range svc.Containers {
+			if count, ok := counts[c.Name]; ok {
+				services[i].Containers[j].Restarts = count
+				services[i].Containers[j].RuntimeRestarts = count
+			}
+		}
+	}
+}
+
+// countContainerRestarts carries the restart count of each container forward from the previous status report. A container
+// that was created again since the previous report has been restarted once more, plus the restarts that docker or
+// kubernetes reports for the new instance. Otherwise, the restarts reported since the previous report are added.
+func countContainerRestarts(updatedServices []WorkloadStatus, oldServices []persistence.WorkloadStatus) {
+	for _, oldSvc := range oldServices {
+		for i, updSvc := range updatedServices {
+			if !statusMatch(updSvc, oldSvc) {
+				continue
+			}
+			for j, updContainer := range updSvc.Containers {
+				for _, oldContainer := range oldSvc.Containers {
+					if oldContainer.Name != updContainer.Name {
+						continue
+					}
+					restarts := oldContainer.Restarts
+					if oldContainer.Created != 0 && updContainer.Created != 0 && oldContainer.Created != updContainer.Created {
+						restarts += 1 + updContainer.RuntimeRestarts
+					} else if updContainer.RuntimeRestarts >= oldContainer.RuntimeRestarts {
+						restarts += updContainer.RuntimeRestarts - oldContainer.RuntimeRestarts
+					} else {
+						restarts += updContainer.RuntimeRestarts
+					}
+					updatedServices[i].Containers[j].Restarts = restarts
+					break
+				}
+			}
+		}
+	}
+}
+
 // getNonChangedServices returns old services that don't have any updates, so their statuses will not be lost
 func getNonChangedServices(updatedServices []WorkloadStatus, oldServices []persistence.WorkloadStatus) (suspendedSvcs []WorkloadStatus) {
 	for _, svc := range oldServices {
@@ -404,6 +471,8 @@ func GetContainerStatus(deployment string, key string, infrastructure bool, cont
 					container_status.Name = container.Name
 					container_status.Created = container.CreatedTime
 					container_status.Image = container.Image
+					container_status.Restarts = container.Restarts
+					container_status.RuntimeRestarts = container.Restarts
 					status = append(status, container_status)
 				}
 			}
@@ -523,7 +592,7 @@ func changeInContainerStatuses(newContainers []ContainerStatus, oldContainers []
 	for _, oldContainer := range oldContainers {
 		for _, newContainer := range newContainers {
 			if oldContainer.Name == newContainer.Name && oldContainer.Image == newContainer.Image && oldContainer.Created == newContainer.Created {
-				if oldContainer.State == newContainer.State {
+				if oldContainer.State == newContainer.State && oldContainer.Restarts == newContainer.Restarts {
 					matches++
 				} else {
 					return true
@@ -552,7 +621,7 @@ func convertToPersistenceType(workload []WorkloadStatus) []persistence.WorkloadS
 func converContainerStatusToPersistenceType(containers []ContainerStatus) []persistence.ContainerStatus {
 	persistentCStatuses := []persistence.ContainerStatus{}
 	for _, cStatus := range containers {
-		persistentCStatuses = append(persistentCStatuses, persistence.ContainerStatus{Name: cStatus.Name, Image: cStatus.Image, Created: cStatus.Created, State: cStatus.State})
+		persistentCStatuses = append(persistentCStatuses, persistence.ContainerStatus{Name: cStatus.Name, Image: cStatus.Image, Created: cStatus.Created, State: cStatus.State, Restarts: cStatus.Restarts, RuntimeRestarts: cStatus.RuntimeRestarts})
 	}
 	return persistentCStatuses
 }
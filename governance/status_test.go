@@ -4,6 +4,7 @@ package governance
 
 import (
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-horizon/anax/persistence"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -111,6 +112,59 @@ func Test_GetContainerStatus(t *testing.T) {
 	assert.True(t, statusArrayIsSame(exp_status, status), "The elements should be the same.")
 }
 
+func Test_countContainerRestarts(t *testing.T) {
+	old := []persistence.WorkloadStatus{
+		{AgreementId: "aaaa", ServiceURL: "netspeed5", Org: "mycompany", Containers: []persistence.ContainerStatus{
+			{Name: "/aaaa-netspeed5", Created: 1507728202, State: "running", Restarts: 2},
+			{Name: "/aaaa-test", Created: 1507728356, State: "running"},
+		}},
+	}
+	updated := []WorkloadStatus{
+		{AgreementId: "aaaa", ServiceURL: "netspeed5", Org: "mycompany", Containers: []ContainerStatus{
+			{Name: "/aaaa-netspeed5", Created: 1507728202, State: "running"},
+			{Name: "/aaaa-test", Created: 1507729000, State: "running"},
+		}},
+		{AgreementId: "bbbb", ServiceURL: "netspeed5", Org: "mycompany", Containers: []ContainerStatus{
+			{Name: "/bbbb-netspeed5", Created: 1507729000, State: "running"},
+		}},
+	}
+
+	countContainerRestarts(updated, old)
+
+	assert.Equal(t, 2, updated[0].Containers[0].Restarts, "The restart count should be carried forward.")
+	assert.Equal(t, 1, updated[0].Containers[1].Restarts, "A recreated container should count as a restart.")
+	assert.Equal(t, 0, updated[1].Containers[0].Restarts, "A new container has not restarted.")
+
+	// Docker restarts a container in place, so only its restart count changes.
+	old = convertToPersistenceType(updated)
+	updated = []WorkloadStatus{
+		{AgreementId: "aaaa", ServiceURL: "netspeed5", Org: "mycompany", Containers: []ContainerStatus{
+			{Name: "/aaaa-netspeed5", Created: 1507728202, State: "running"},
+			{Name: "/aaaa-test", Created: 1507729000, State: "running"},
+		}},
+	}
+	setContainerRestartCounts(updated, map[string]int{"/aaaa-netspeed5": 3, "/aaaa-test": 1})
+	countContainerRestarts(updated, old)
+
+	assert.Equal(t, 5, updated[0].Containers[0].Restarts, "The docker restarts should be added.")
+	assert.Equal(t, 2, updated[0].Containers[1].Restarts, "The docker restarts should be added.")
+	assert.True(t, changeInWorkloadStatuses(updated, old), "A restart should change the status.")
+
+	// The restarts already counted are not counted again, and a recreated container adds its own restarts.
+	old = convertToPersistenceType(updated)
+	updated = []WorkloadStatus{
+		{AgreementId: "aaaa", ServiceURL: "netspeed5", Org: "mycompany", Containers: []ContainerStatus{
+			{Name: "/aaaa-netspeed5", Created: 1507728202, State: "running"},
+			{Name: "/aaaa-test", Created: 1507729500, State: "running"},
+		}},
+	}
+	setContainerRestartCounts(updated, map[string]int{"/aaaa-netspeed5": 3, "/aaaa-test": 1})
+	countContainerRestarts(updated, old)
+
+	assert.Equal(t, 5, updated[0].Containers[0].Restarts, "The restart count should not change.")
+	assert.Equal(t, 4, updated[0].Containers[1].Restarts, "A recreated container should count as a restart.")
+}
+
 // Compare 2 ContainerStatus array contents without considering the order
 func statusArrayIsSame(a1 []ContainerStatus, a2 []ContainerStatus) bool {
 	if len(a1) != len(a2) {
@@ -69,6 +69,7 @@ type ContainerStatus struct {
 	Image       string
 	CreatedTime int64
 	State       string
+	Restarts    int
 }
 
 func NewKubeClient() (*KubeClient, error) {
@@ -221,6 +222,7 @@ func (c KubeClient) Status(tar string, agId string) ([]ContainerStatus, error) {
 			newStatus := ContainerStatus{Name: pod.ObjectMeta.Name}
 			newStatus.Image = status.Image
 			newStatus.Name = status.Name
+			newStatus.Restarts = int(status.RestartCount)
 			if status.State.Running != nil {
 				newStatus.State = "Running"
 				newStatus.CreatedTime = status.State.Running.StartedAt.Time.Unix()
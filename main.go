@@ -161,7 +161,14 @@ func main() {
 	// start workers
 	workers := worker.NewMessageHandlerRegistry()
 
-	workers.Add(agreementbot.NewAgreementBotWorker("AgBot", cfg, agbotDB, agbotSecrets))
+	// When the agbot serves federated exchanges, the workers of each exchange only see the agreements of their exchange.
+	federated := cfg.GetAgbotFederatedExchanges()
+	agbotWorkerDB := agbotDB
+	if agbotDB != nil && len(federated) != 0 {
+		agbotWorkerDB = agbotPersistence.NewExchangeDB(agbotDB, "")
+	}
+
+	workers.Add(agreementbot.NewAgreementBotWorker("AgBot", cfg, agbotWorkerDB, agbotSecrets))
 	if cfg.AgreementBot.APIListen != "" {
 		workers.Add(agreementbot.NewAPIListener("AgBot API", cfg, agbotDB, *configFile))
 	}
@@ -172,6 +179,21 @@ func main() {
 		workers.Add(agreementbot.NewChangesWorker("AgBot ExchangeChanges", cfg))
 	}
 
+	// Each federated exchange has its own agbot and exchange changes workers, dispatched by their own registry so that
+	// the events of one exchange are not seen by the workers of the other exchanges.
+	if agbotDB != nil && len(federated) != 0 {
+		relay := agreementbot.NewFederationRelay("AgBot Federation Relay")
+		workers.Add(relay)
+		for _, fe := range federated {
+			fcfg := cfg.ForFederatedExchange(fe)
+			fworkers := worker.NewMessageHandlerRegistry()
+			fworkers.Add(relay.NewInbound("AgBot Federation Relay " + fe.Name))
+			fworkers.Add(agreementbot.NewAgreementBotWorker("AgBot "+fe.Name, fcfg, agbotPersistence.NewExchangeDB(agbotDB, fe.Name), agbotSecrets))
+			fworkers.Add(agreementbot.NewChangesWorker("AgBot ExchangeChanges "+fe.Name, fcfg))
+			go fworkers.ProcessEventMessages()
+		}
+	}
+
 	if db != nil {
 		workers.Add(api.NewAPIListener("API", cfg, db, pm))
 		workers.Add(agreement.NewAgreementWorker("Agreement", cfg, db, pm))
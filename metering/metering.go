@@ -1,6 +1,9 @@
 package metering
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -98,6 +101,64 @@ func NewMeteringNotification(meterPolicy policy.Meter, startTime uint64, checkRa
 
 }
 
+// Returns true when the consumer has signed the meter.
+func (m *MeteringNotification) IsSigned() bool {
+	return m.ConsumerMeterSignature != ""
+}
+
+// Verify that the agreement id, the agreement hash and the consumer and producer signatures carried in the notification
+// are the ones that were recorded when the agreement was made, and that the meter was signed by the consumer that made
+// the agreement. Only the meter signature is verified cryptographically, the agreement signatures are compared with the
+// recorded ones. A notification that doesnt match the agreement it claims to be metering can not be trusted as a
+// billing record.
+func (m *MeteringNotification) VerifyAgreement(agId string, agHash string, cSig string, pSig string, consumerKey *rsa.PublicKey) error {
+	if m.AgreementId != agId {
+		return errors.New(fmt.Sprintf("agreement id %v does not match %v", m.AgreementId, agId))
+	} else if m.AgreementHash != agHash {
+		return errors.New(fmt.Sprintf("agreement hash %v does not match %v", m.AgreementHash, agHash))
+	} else if m.ConsumerSignature != cSig {
+		return errors.New(fmt.Sprintf("consumer signature of agreement %v does not match the signature recorded for the agreement", agId))
+	} else if m.ProducerSignature != pSig {
+		return errors.New(fmt.Sprintf("producer signature of agreement %v does not match the signature recorded for the agreement", agId))
+	} else if m.IsSigned() {
+		return m.VerifyMeterSignature(consumerKey)
+	}
+	return nil
+}
+
+// Sign the meter hash with the consumer's private key and set the signature into the object.
+func (m *MeteringNotification) SignMeter(consumerKey *rsa.PrivateKey) error {
+	if consumerKey == nil {
+		return errors.New(fmt.Sprintf("unable to sign meter of agreement %v, the private key is nil", m.AgreementId))
+	} else if digest, err := hex.DecodeString(m.GetMeterHash()[2:]); err != nil {
+		return errors.New(fmt.Sprintf("unable to decode meter hash %v, error: %v", m.GetMeterHash(), err))
+	} else if sig, err := rsa.SignPSS(rand.Reader, consumerKey, crypto.SHA3_256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); err != nil {
+		return errors.New(fmt.Sprintf("unable to sign meter of agreement %v, error: %v", m.AgreementId, err))
+	} else {
+		m.SetConsumerMeterSignature(hex.EncodeToString(sig))
+	}
+	return nil
+}
+
+// Verify that the consumer meter signature is a signature of the meter hash by the owner of the public key. The meter hash
+// is calculated again from the content of the notification, so that a notification whose amount, time or agreement id
+// has been changed does not verify.
+func (m *MeteringNotification) VerifyMeterSignature(consumerKey *rsa.PublicKey) error {
+	hash := m.calculateMeterHash()
+	if !m.IsSigned() {
+		return errors.New(fmt.Sprintf("meter of agreement %v is not signed", m.AgreementId))
+	} else if consumerKey == nil {
+		return errors.New(fmt.Sprintf("unable to verify meter signature of agreement %v, the public key is nil", m.AgreementId))
+	} else if sig, err := hex.DecodeString(m.ConsumerMeterSignature); err != nil {
+		return errors.New(fmt.Sprintf("unable to decode meter signature of agreement %v, error: %v", m.AgreementId, err))
+	} else if digest, err := hex.DecodeString(hash[2:]); err != nil {
+		return errors.New(fmt.Sprintf("unable to decode meter hash %v, error: %v", hash, err))
+	} else if err := rsa.VerifyPSS(consumerKey, crypto.SHA3_256, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}); err != nil {
+		return errors.New(fmt.Sprintf("meter signature of agreement %v is not valid, error: %v", m.AgreementId, err))
+	}
+	return nil
+}
+
 func (m *MeteringNotification) SetConsumerMeterSignature(sig string) {
 	m.ConsumerMeterSignature = sig
 }
@@ -138,16 +199,20 @@ func (m *MeteringNotification) GetMeterHash() string {
 	if len(m.meterHash) != 0 {
 		return m.meterHash
 	} else {
-		binaryAgreementId, _ := hex.DecodeString(m.AgreementId)
+		return m.calculateMeterHash()
+	}
+}
 
-		theMeter := make([]byte, 0, 96)
-		theMeter = append(theMeter, toBuffer(m.Amount)...)
-		theMeter = append(theMeter, toBuffer(m.CurrentTime)...)
-		theMeter = append(theMeter, binaryAgreementId...)
+func (m *MeteringNotification) calculateMeterHash() string {
+	binaryAgreementId, _ := hex.DecodeString(m.AgreementId)
 
-		hash := sha3.Sum256(theMeter)
-		return "0x" + hex.EncodeToString(hash[:])
-	}
+	theMeter := make([]byte, 0, 96)
+	theMeter = append(theMeter, toBuffer(m.Amount)...)
+	theMeter = append(theMeter, toBuffer(m.CurrentTime)...)
+	theMeter = append(theMeter, binaryAgreementId...)
+
+	hash := sha3.Sum256(theMeter)
+	return "0x" + hex.EncodeToString(hash[:])
 }
 
 // This is an internal function used to calculate the amount of tokens to send in the notification message. The
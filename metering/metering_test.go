@@ -3,6 +3,9 @@
 package metering
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
 	"fmt"
 	"github.com/open-horizon/anax/policy"
 	"testing"
@@ -386,3 +389,59 @@ func Test_calcAmount_withmisses(t *testing.T) {
 	}
 
 }
+
+func Test_VerifyAgreement(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key, error %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key, error %v", err)
+	}
+
+	m1 := MeteringNotification{
+		Amount: 1, StartTime: 1, CurrentTime: 1, AgreementId: "abc0", AgreementHash: "abcdef",
+		ConsumerSignature: "fdebca", ProducerSignature: "bcdefa",
+	}
+
+	if m1.IsSigned() {
+		t.Errorf("Metering Notification %v is not signed.", m1)
+	} else if err := m1.VerifyMeterSignature(&key.PublicKey); err == nil {
+		t.Errorf("Metering Notification %v without a meter signature should not verify.", m1)
+	} else if err := m1.SignMeter(key); err != nil {
+		t.Errorf("unable to sign Metering Notification %v, error %v", m1, err)
+	} else if !m1.IsSigned() {
+		t.Errorf("Metering Notification %v is signed.", m1)
+	} else if err := m1.VerifyAgreement("abc0", "abcdef", "fdebca", "bcdefa", &key.PublicKey); err != nil {
+		t.Errorf("Metering Notification %v should verify, error %v", m1, err)
+	} else if err := m1.VerifyAgreement("abd0", "abcdef", "fdebca", "bcdefa", &key.PublicKey); err == nil {
+		t.Errorf("Metering Notification %v should not verify against a different agreement.", m1)
+	} else if err := m1.VerifyAgreement("abc0", "abcdef", "fdebcb", "bcdefa", &key.PublicKey); err == nil {
+		t.Errorf("Metering Notification %v should not verify against a different consumer signature.", m1)
+	} else if err := m1.VerifyAgreement("abc0", "abcdef", "fdebca", "", &key.PublicKey); err == nil {
+		t.Errorf("Metering Notification %v should not verify against a missing producer signature.", m1)
+	} else if err := m1.VerifyAgreement("abc0", "abcdef", "fdebca", "bcdefa", &otherKey.PublicKey); err == nil {
+		t.Errorf("Metering Notification %v should not verify with the key of another consumer.", m1)
+	}
+
+	// A notification whose amount was changed after it was signed does not verify, even with all the agreement fields copied.
+	m2 := m1
+	m2.meterHash = ""
+	m2.Amount = 100
+	if err := m2.VerifyAgreement("abc0", "abcdef", "fdebca", "bcdefa", &key.PublicKey); err == nil {
+		t.Errorf("Metering Notification %v with a forged amount should not verify.", m2)
+	}
+
+	// The signature survives the trip through json.
+	var m3 MeteringNotification
+	if b, err := json.Marshal(m1); err != nil {
+		t.Errorf("unable to marshal %v, error %v", m1, err)
+	} else if err := json.Unmarshal(b, &m3); err != nil {
+		t.Errorf("unable to unmarshal %v, error %v", string(b), err)
+	} else if err := m3.VerifyMeterSignature(&key.PublicKey); err != nil {
+		t.Errorf("Metering Notification %v should verify, error %v", m3, err)
+	}
+
+}
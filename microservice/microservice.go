@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/golang/glog"
+	"github.com/open-horizon/anax/basicprotocol"
 	"github.com/open-horizon/anax/cutil"
 	"github.com/open-horizon/anax/events"
 	"github.com/open-horizon/anax/exchange"
@@ -294,7 +295,7 @@ func RemoveMicroservicePolicy(spec_ref string, org string, version string, msdef
 func GenMicroservicePolicy(msdef *persistence.MicroserviceDefinition, policyPath string, db *bolt.DB, e chan events.Message, deviceOrg string, pattern string) error {
 	glog.V(3).Infof("Generate policy for the given service %v/%v version %v key %v", msdef.Org, msdef.SpecRef, msdef.Version, msdef.Id)
 
-	var haPartner []string
+	var haGroup *policy.HighAvailabilityGroup
 	var serviceAgreementProtocols []interface{}
 
 	props := make(map[string]interface{})
@@ -304,7 +305,7 @@ func GenMicroservicePolicy(msdef *persistence.MicroserviceDefinition, policyPath
 		for _, attr := range attributes {
 			switch attr.(type) {
 			case persistence.HAAttributes:
-				haPartner = attr.(persistence.HAAttributes).Partners
+				haGroup = attr.(persistence.HAAttributes).GetHAGroup()
 
 			case persistence.AgreementProtocolAttributes:
 				agpl := attr.(persistence.AgreementProtocolAttributes).Protocols
@@ -367,6 +368,7 @@ func GenMicroservicePolicy(msdef *persistence.MicroserviceDefinition, policyPath
 		if err != nil {
 			return fmt.Errorf("Error converting agreement protocol list attribute %v to agreement protocol list, error: %v", serviceAgreementProtocols, err)
 		}
+		basicprotocol.SetProducerProtocolVersion(*list)
 
 		//Generate a policy based on all the attributes and the service definition
 		maxAgreements := 1
@@ -374,7 +376,7 @@ func GenMicroservicePolicy(msdef *persistence.MicroserviceDefinition, policyPath
 			maxAgreements = 5 // hard coded 2 for now, will change to 0 later
 		}
 
-		if polFileName, err := policy.GeneratePolicy(msdef.SpecRef, msdef.Org, msdef.Name, msdef.Version, msdef.RequestedArch, &props, haPartner, *list, maxAgreements, policyPath, deviceOrg); err != nil {
+		if polFileName, err := policy.GeneratePolicy(msdef.SpecRef, msdef.Org, msdef.Name, msdef.Version, msdef.RequestedArch, &props, haGroup, *list, maxAgreements, policyPath, deviceOrg); err != nil {
 			return fmt.Errorf("Failed to generate policy for %v/%v version %v. Error: %v", msdef.Org, msdef.SpecRef, msdef.Version, err)
 		} else {
 			e <- events.NewPolicyCreatedMessage(events.NEW_POLICY, polFileName)
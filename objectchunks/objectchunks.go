@@ -0,0 +1,266 @@
+package objectchunks
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+)
+
+// The purpose of this module is to split the data of a model management (MMS) object into content-defined chunks.
+// A chunk boundary is placed where a rolling hash of the last bytes matches a mask, so the boundaries follow the content
+// rather than the offsets: when a part of the data changes, only the chunks around the change are different from
+// the previous version of the data. The chunks of an object are published as separate MMS objects, and the data of
+// the object itself is replaced by a manifest listing its chunks, so that a new version of the object only needs to
+// transfer the chunks that changed.
+
+// The format of the manifest, it is at the beginning of the manifest so that it can be recognized.
+const MANIFEST_FORMAT = "horizon.chunked.v1"
+
+// The chunks of an object of type T are objects of type T.chunks.
+const CHUNK_TYPE_SUFFIX = ".chunks"
+
+// Only the objects of a type with this suffix can be chunked. The data of a chunked object is a manifest, which the
+// services that get the objects of other types would take as the object data.
+const CHUNKED_TYPE_SUFFIX = ".chunked"
+
+// The default average chunk size, the chunks are between a quarter of it and 4 times it.
+const DEFAULT_AVG_CHUNK_SIZE = 4 * 1024 * 1024
+const MIN_AVG_CHUNK_SIZE = 64 * 1024
+
+// A manifest larger than this is not a manifest, which avoids parsing large objects.
+const MAX_MANIFEST_SIZE = 64 * 1024 * 1024
+
+type Chunk struct {
+	Hash   string `json:"hash"` // The hex encoded sha256 of the chunk data.
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+func (c Chunk) String() string {
+	return fmt.Sprintf("Chunk %v offset %v size %v", c.Hash, c.Offset, c.Size)
+}
+
+type Manifest struct {
+	Format       string  `json:"format"`
+	Size         int64   `json:"size"`
+	Hash         string  `json:"hash"` // The hex encoded sha256 of the whole data.
+	AvgChunkSize int64   `json:"avgChunkSize"`
+	Chunks       []Chunk `json:"chunks"`
+}
+
+func (m Manifest) String() string {
+	return fmt.Sprintf("Manifest %v size %v hash %v chunks %v", m.Format, m.Size, m.Hash, len(m.Chunks))
+}
+
+// Returns true if the objects of the type are published as chunks.
+func IsChunkedType(objectType string) bool {
+	return strings.HasSuffix(objectType, CHUNKED_TYPE_SUFFIX)
+}
+
+// Returns the object type of the chunks of an object type.
+func ChunkType(objectType string) string {
+	return objectType + CHUNK_TYPE_SUFFIX
+}
+
+// Returns the object id of a chunk of an object. The chunks belong to one object so that the chunks of different
+// objects do not share their destinations.
+func ChunkId(objectId string, chunkHash string) string {
+	return objectId + "_" + chunkHash
+}
+
+// Returns the manifest if the data is one, or nil.
+func ParseManifest(data []byte) *Manifest {
+	if len(data) > MAX_MANIFEST_SIZE || !bytes.HasPrefix(bytes.TrimSpace(data), []byte(`{"format":"`+MANIFEST_FORMAT+`"`)) {
+		return nil
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil || m.Format != MANIFEST_FORMAT {
+		return nil
+	}
+	return m
+}
+
+// The gear table of the rolling hash, generated with splitmix64 from a fixed seed so that every implementation finds
+// the same boundaries.
+var gear [256]uint64
+
+func init() {
+	seed := uint64(0x686f72697a6f6e21)
+	for i := range gear {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Rounds the average chunk size to a power of 2, returns the default when it is 0.
+func normalizeAvgSize(avgSize int64) (int64, error) {
+	if avgSize == 0 {
+		return DEFAULT_AVG_CHUNK_SIZE, nil
+	} else if avgSize < MIN_AVG_CHUNK_SIZE {
+		return 0, errors.New(fmt.Sprintf("the average chunk size must be at least %v bytes", MIN_AVG_CHUNK_SIZE))
+	}
+	return int64(1) << uint(bits.Len64(uint64(avgSize-1))), nil
+}
+
+// Splits the data into content-defined chunks of the given average size, and returns the manifest of the data.
+func NewManifest(r io.Reader, avgSize int64) (*Manifest, error) {
+	avgSize, err := normalizeAvgSize(avgSize)
+	if err != nil {
+		return nil, err
+	}
+	minSize, maxSize := avgSize/4, avgSize*4
+
+	// A boundary is where the top log2(avgSize) bits of the hash are 0, the top bits depend on the last 64 bytes.
+	mask := ^uint64(0) << uint(64-bits.TrailingZeros64(uint64(avgSize)))
+
+	m := &Manifest{Format: MANIFEST_FORMAT, AvgChunkSize: avgSize, Chunks: []Chunk{}}
+	dataHash := sha256.New()
+	chunkHash := sha256.New()
+	reader := bufio.NewReaderSize(r, 1024*1024)
+
+	var hash uint64
+	var size int64
+	buf := make([]byte, 64*1024)
+	endChunk := func() {
+		m.Chunks = append(m.Chunks, Chunk{Hash: hex.EncodeToString(chunkHash.Sum(nil)), Offset: m.Size, Size: size})
+		m.Size += size
+		chunkHash.Reset()
+		hash, size = 0, 0
+	}
+
+	for {
+		n, err := reader.Read(buf)
+		start := 0
+		for i := 0; i < n; i++ {
+			hash = (hash << 1) + gear[buf[i]]
+			size++
+			if (size >= minSize && hash&mask == 0) || size >= maxSize {
+				chunkHash.Write(buf[start : i+1])
+				start = i + 1
+				endChunk()
+			}
+		}
+		chunkHash.Write(buf[start:n])
+		dataHash.Write(buf[:n])
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if size > 0 {
+		endChunk()
+	}
+
+	m.Hash = hex.EncodeToString(dataHash.Sum(nil))
+	return m, nil
+}
+
+// LocalChunks are the chunks of a local file, such as a previous version of the data, which are used instead of
+// fetching them.
+type LocalChunks struct {
+	file   *os.File
+	chunks map[string]Chunk
+}
+
+// Splits a local file with the same average chunk size as the manifest. Returns nil if the file does not exist.
+func NewLocalChunks(fileName string, m *Manifest) (*LocalChunks, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	local, err := NewManifest(file, m.AvgChunkSize)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	lc := &LocalChunks{file: file, chunks: make(map[string]Chunk)}
+	for _, c := range local.Chunks {
+		lc.chunks[c.Hash] = c
+	}
+	return lc, nil
+}
+
+func (lc *LocalChunks) Has(hash string) bool {
+	if lc == nil {
+		return false
+	}
+	_, found := lc.chunks[hash]
+	return found
+}
+
+func (lc *LocalChunks) Close() {
+	if lc != nil {
+		lc.file.Close()
+	}
+}
+
+// Returns the chunks of the manifest that are not in the local chunks, once each.
+func (m *Manifest) Missing(lc *LocalChunks) []Chunk {
+	missing := []Chunk{}
+	seen := make(map[string]bool)
+	for _, c := range m.Chunks {
+		if !seen[c.Hash] && !lc.Has(c.Hash) {
+			missing = append(missing, c)
+		}
+		seen[c.Hash] = true
+	}
+	return missing
+}
+
+// Checks that the data has the size and hash of the chunk.
+func VerifyChunk(c Chunk, data []byte) error {
+	if int64(len(data)) != c.Size {
+		return errors.New(fmt.Sprintf("chunk %v has %v bytes, expected %v", c.Hash, len(data), c.Size))
+	} else if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != c.Hash {
+		return errors.New(fmt.Sprintf("chunk %v has a different hash %v", c.Hash, hex.EncodeToString(sum[:])))
+	}
+	return nil
+}
+
+// Writes the data of the manifest to w. Each chunk is read from the local chunks when it is there, or with fetch
+// otherwise. The chunks and the whole data are verified against their hashes.
+func (m *Manifest) Assemble(w io.Writer, lc *LocalChunks, fetch func(c Chunk) ([]byte, error)) error {
+	dataHash := sha256.New()
+	out := io.MultiWriter(w, dataHash)
+
+	for _, c := range m.Chunks {
+		var data []byte
+		var err error
+		if lc.Has(c.Hash) {
+			local := lc.chunks[c.Hash]
+			data = make([]byte, local.Size)
+			_, err = lc.file.ReadAt(data, local.Offset)
+		} else {
+			data, err = fetch(c)
+		}
+		if err != nil {
+			return err
+		} else if err := VerifyChunk(c, data); err != nil {
+			return err
+		} else if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if hash := hex.EncodeToString(dataHash.Sum(nil)); hash != m.Hash {
+		return errors.New(fmt.Sprintf("the assembled data has a different hash %v, expected %v", hash, m.Hash))
+	}
+	return nil
+}
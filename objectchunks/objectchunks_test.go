@@ -0,0 +1,141 @@
+// +build unit
+
+package objectchunks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+)
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(42)).Read(data)
+	return data
+}
+
+func Test_NewManifest(t *testing.T) {
+
+	data := randomData(3 * 1024 * 1024)
+	m, err := NewManifest(bytes.NewReader(data), 100*1024)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if m.AvgChunkSize != 128*1024 {
+		t.Errorf("the average chunk size should be rounded to 128k, got %v", m.AvgChunkSize)
+	} else if m.Size != int64(len(data)) {
+		t.Errorf("wrong size %v", m.Size)
+	}
+
+	var offset int64
+	for i, c := range m.Chunks {
+		if c.Offset != offset {
+			t.Errorf("wrong offset for %v, expected %v", c, offset)
+		} else if c.Size > 4*m.AvgChunkSize || (c.Size < m.AvgChunkSize/4 && i != len(m.Chunks)-1) {
+			t.Errorf("wrong size for %v", c)
+		} else if err := VerifyChunk(c, data[c.Offset:c.Offset+c.Size]); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+		offset += c.Size
+	}
+
+	if _, err := NewManifest(bytes.NewReader(data), 1024); err == nil {
+		t.Errorf("expected an error for a too small average chunk size")
+	}
+}
+
+func Test_ParseManifest(t *testing.T) {
+
+	m, _ := NewManifest(bytes.NewReader(randomData(1000)), 0)
+	data, _ := json.Marshal(m)
+	if parsed := ParseManifest(data); parsed == nil || parsed.Hash != m.Hash || len(parsed.Chunks) != 1 {
+		t.Errorf("wrong manifest %v for %v", parsed, string(data))
+	}
+
+	if parsed := ParseManifest([]byte(`{"format":"other"}`)); parsed != nil {
+		t.Errorf("expected no manifest, got %v", parsed)
+	} else if parsed := ParseManifest(randomData(100)); parsed != nil {
+		t.Errorf("expected no manifest, got %v", parsed)
+	}
+}
+
+func Test_Assemble_delta(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "objectchunks")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The new version has some bytes changed and some inserted in the middle.
+	oldData := randomData(4 * 1024 * 1024)
+	newData := append([]byte{}, oldData[:2*1024*1024]...)
+	newData = append(newData, []byte("inserted bytes")...)
+	newData = append(newData, oldData[2*1024*1024:]...)
+	copy(newData[100000:], []byte("changed"))
+
+	oldFile := path.Join(dir, "old")
+	ioutil.WriteFile(oldFile, oldData, 0600)
+
+	m, _ := NewManifest(bytes.NewReader(newData), 64*1024)
+	lc, err := NewLocalChunks(oldFile, m)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer lc.Close()
+
+	// Only the chunks around the 2 changes are missing.
+	missing := m.Missing(lc)
+	if len(missing) == 0 || len(missing) > 6 {
+		t.Errorf("expected a few missing chunks out of %v, got %v", len(m.Chunks), len(missing))
+	}
+
+	fetched := 0
+	fetch := func(c Chunk) ([]byte, error) {
+		fetched++
+		return newData[c.Offset : c.Offset+c.Size], nil
+	}
+	var out bytes.Buffer
+	if err := m.Assemble(&out, lc, fetch); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if !bytes.Equal(out.Bytes(), newData) {
+		t.Errorf("the assembled data is different")
+	} else if fetched != len(missing) {
+		t.Errorf("expected %v chunks fetched, got %v", len(missing), fetched)
+	}
+
+	// A corrupted chunk is detected.
+	corrupt := func(c Chunk) ([]byte, error) {
+		data := append([]byte{}, newData[c.Offset:c.Offset+c.Size]...)
+		data[0]++
+		return data, nil
+	}
+	if err := m.Assemble(ioutil.Discard, nil, corrupt); err == nil {
+		t.Errorf("expected an error for a corrupted chunk")
+	}
+
+	failing := func(c Chunk) ([]byte, error) {
+		return nil, errors.New("unavailable")
+	}
+	if err := m.Assemble(ioutil.Discard, nil, failing); err == nil {
+		t.Errorf("expected an error when a chunk cannot be fetched")
+	}
+
+	if lc, err := NewLocalChunks(path.Join(dir, "none"), m); err != nil || lc != nil || lc.Has(m.Chunks[0].Hash) {
+		t.Errorf("expected no local chunks for a missing file, got %v %v", lc, err)
+	}
+}
+
+func Test_IsChunkedType(t *testing.T) {
+	if !IsChunkedType("model.chunked") {
+		t.Errorf("expected model.chunked to be a chunked type")
+	} else if IsChunkedType("model") || IsChunkedType("model.chunked.chunks") {
+		t.Errorf("expected model and the chunk type not to be chunked types")
+	} else if ChunkType("model.chunked") != "model.chunked.chunks" {
+		t.Errorf("wrong chunk type %v", ChunkType("model.chunked"))
+	}
+}
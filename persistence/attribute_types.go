@@ -2,11 +2,15 @@ package persistence
 
 import (
 	"fmt"
+	"github.com/open-horizon/anax/policy"
 )
 
 type HAAttributes struct {
-	Meta     *AttributeMeta `json:"meta"`
-	Partners []string       `json:"partners"`
+	Meta                *AttributeMeta `json:"meta"`
+	Partners            []string       `json:"partners"`
+	MaxUnavailable      int            `json:"max_unavailable,omitempty"`      // The number of group members that can upgrade at the same time
+	MinHealthyDurationS int            `json:"min_healthy_duration,omitempty"` // The number of seconds an upgraded member must run before the next one upgrades
+	Order               []string       `json:"order,omitempty"`                // The order in which group members are upgraded
 }
 
 func (a HAAttributes) GetMeta() *AttributeMeta {
@@ -14,9 +18,19 @@ func (a HAAttributes) GetMeta() *AttributeMeta {
 }
 
 func (a HAAttributes) GetGenericMappings() map[string]interface{} {
-	return map[string]interface{}{
+	mappings := map[string]interface{}{
 		"partnerID": a.Partners,
 	}
+	if a.MaxUnavailable != 0 {
+		mappings["maxUnavailable"] = a.MaxUnavailable
+	}
+	if a.MinHealthyDurationS != 0 {
+		mappings["minHealthyDuration"] = a.MinHealthyDurationS
+	}
+	if len(a.Order) != 0 {
+		mappings["order"] = a.Order
+	}
+	return mappings
 }
 
 // TODO: duplicate this for the others too
@@ -24,6 +38,15 @@ func (a HAAttributes) Update(other Attribute) error {
 	return fmt.Errorf("Update not implemented for type: %T", a)
 }
 
+// Return the HA group described by this attribute, as it appears in the node's policy.
+func (a HAAttributes) GetHAGroup() *policy.HighAvailabilityGroup {
+	g := policy.HAGroup_Factory(a.Partners)
+	g.MaxUnavailable = a.MaxUnavailable
+	g.MinHealthyDurationS = a.MinHealthyDurationS
+	g.Order = a.Order
+	return g
+}
+
 func (a HAAttributes) PartnersContains(id string) bool {
 	for _, p := range a.Partners {
 		if p == id {
@@ -34,7 +57,7 @@ func (a HAAttributes) PartnersContains(id string) bool {
 }
 
 func (a HAAttributes) String() string {
-	return fmt.Sprintf("Meta: %v, Partners: %v", a.Meta, a.Partners)
+	return fmt.Sprintf("Meta: %v, Partners: %v, MaxUnavailable: %v, MinHealthyDurationS: %v, Order: %v", a.Meta, a.Partners, a.MaxUnavailable, a.MinHealthyDurationS, a.Order)
 }
 
 type MeteringAttributes struct {
@@ -62,6 +62,9 @@ const (
 	EC_NODE_HEARTBEAT_FAILED   = "node_heartbeat_failed"
 	EC_NODE_HEARTBEAT_RESTORED = "node_heartbeat_restored"
 
+	// model management objects
+	EC_MMS_OBJECT_REJECTED = "mms_object_rejected"
+
 	// service configuration
 	EC_START_SERVICE_CONFIG                = "start_service_configuration"
 	EC_SERVICE_CONFIG_COMPLETE             = "service_configuration_complete"
@@ -20,10 +20,13 @@ type WorkloadStatus struct {
 }
 
 type ContainerStatus struct {
-	Name    string `json:"name"`
-	Image   string `json:"image"`
-	Created int64  `json:"created"`
-	State   string `json:"state"`
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	Created  int64  `json:"created"`
+	State    string `json:"state"`
+	Restarts int    `json:"restarts,omitempty"`
+	// The restart count reported by docker or kubernetes for the current instance of the container
+	RuntimeRestarts int `json:"runtimeRestarts,omitempty"`
 }
 
 // FindNodeStatus returns the node status currently in the local db
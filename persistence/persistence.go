@@ -84,6 +84,41 @@ type EstablishedAgreement struct {
 	BlockchainOrg                   string                   `json:"blockchain_org,omitempty"`        // the org of the blockchain instance
 	RunningWorkload                 WorkloadInfo             `json:"workload_to_run,omitempty"`       // For display purposes, a copy of the workload info that this agreement is managing. It should be the same info that is buried inside the proposal.
 	AgreementTimeout                uint64                   `json:"agreement_timeout"`
+	StateHistory                    []AgreementTransition    `json:"state_history,omitempty"` // the ordered state transitions of the agreement, oldest first
+	newTransitions                  []AgreementTransition    // transitions made by an update that have not been persisted yet
+}
+
+// Agreement states recorded in the state history of an agreement.
+const AG_STATE_CREATED = "created"
+const AG_STATE_ACCEPTED = "accepted"
+const AG_STATE_BC_UPDATE_ACKED = "blockchain update acknowledged"
+const AG_STATE_FINALIZED = "finalized"
+const AG_STATE_DEPLOYMENT_STARTED = "deployment started"
+const AG_STATE_EXECUTION_STARTED = "execution started"
+const AG_STATE_DATA_RECEIVED = "data received"
+const AG_STATE_TERMINATED = "terminated"
+const AG_STATE_FORCE_TERMINATED = "force terminated"
+const AG_STATE_PROTOCOL_TERMINATED = "agreement protocol terminated"
+const AG_STATE_WORKLOAD_TERMINATED = "workload terminated"
+const AG_STATE_ARCHIVED = "archived"
+
+// The maximum number of transitions kept in the state history of an agreement. The oldest are dropped first, except for
+// the creation of the agreement.
+const MAX_AGREEMENT_STATE_HISTORY = 50
+
+type AgreementTransition struct {
+	State  string `json:"state"`
+	Time   uint64 `json:"time"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (t AgreementTransition) String() string {
+	return fmt.Sprintf("State: %v, Time: %v, Reason: %v", t.State, t.Time, t.Reason)
+}
+
+// Record a state transition in an agreement that is being updated. It is appended to the state history when the update is persisted.
+func (c *EstablishedAgreement) addTransition(state string, reason string) {
+	c.newTransitions = append(c.newTransitions, AgreementTransition{State: state, Time: uint64(time.Now().Unix()), Reason: reason})
 }
 
 func (c EstablishedAgreement) String() string {
@@ -116,13 +151,14 @@ func (c EstablishedAgreement) String() string {
 		"BlockchainName: %v, "+
 		"BlockchainOrg: %v, "+
 		"RunningWorkload: %v"+
-		"AgreementTimeout: %v",
+		"AgreementTimeout: %v, "+
+		"StateHistory: %v",
 		c.Name, c.DependentServices, c.Archived, c.CurrentAgreementId, c.ConsumerId, c.CounterPartyAddress, ServiceConfigNames(&c.CurrentDeployment),
 		"********", c.ProposalSig,
 		c.AgreementCreationTime, c.AgreementExecutionStartTime, c.AgreementAcceptedTime, c.AgreementBCUpdateAckTime, c.AgreementFinalizedTime,
 		c.AgreementDataReceivedTime, c.AgreementTerminatedTime, c.AgreementForceTerminatedTime, c.TerminatedReason, c.TerminatedDescription,
 		c.AgreementProtocol, c.ProtocolVersion, c.AgreementProtocolTerminatedTime, c.WorkloadTerminatedTime,
-		c.MeteringNotificationMsg, c.BlockchainType, c.BlockchainName, c.BlockchainOrg, c.RunningWorkload, c.AgreementTimeout)
+		c.MeteringNotificationMsg, c.BlockchainType, c.BlockchainName, c.BlockchainOrg, c.RunningWorkload, c.AgreementTimeout, c.StateHistory)
 
 }
 
@@ -174,6 +210,7 @@ func NewEstablishedAgreement(db *bolt.DB, name string, agreementId string, consu
 		RunningWorkload:                 *wi,
 		AgreementTimeout:                agreementTimeout,
 	}
+	newAg.StateHistory = []AgreementTransition{{State: AG_STATE_CREATED, Time: newAg.AgreementCreationTime}}
 
 	return newAg, db.Update(func(tx *bolt.Tx) error {
 
@@ -222,6 +259,7 @@ func ArchiveEstablishedAgreement(db *bolt.DB, agreementId string, protocol strin
 	return agreementStateUpdate(db, agreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.Archived = true
 		c.CurrentDeployment = map[string]ServiceConfig{}
+		c.addTransition(AG_STATE_ARCHIVED, "")
 		return &c
 	})
 }
@@ -230,6 +268,7 @@ func ArchiveEstablishedAgreement(db *bolt.DB, agreementId string, protocol strin
 func AgreementStateExecutionStarted(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.AgreementExecutionStartTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_EXECUTION_STARTED, "")
 		return &c
 	})
 }
@@ -238,6 +277,7 @@ func AgreementStateExecutionStarted(db *bolt.DB, dbAgreementId string, protocol
 func AgreementStateAccepted(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.AgreementAcceptedTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_ACCEPTED, "")
 		return &c
 	})
 }
@@ -262,6 +302,7 @@ func AgreementStateBCDataReceived(db *bolt.DB, dbAgreementId string, protocol st
 func AgreementStateBCUpdateAcked(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.AgreementBCUpdateAckTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_BC_UPDATE_ACKED, "")
 		return &c
 	})
 }
@@ -270,6 +311,7 @@ func AgreementStateBCUpdateAcked(db *bolt.DB, dbAgreementId string, protocol str
 func AgreementStateFinalized(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.AgreementFinalizedTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_FINALIZED, "")
 		return &c
 	})
 }
@@ -286,6 +328,7 @@ func AgreementDeploymentStarted(db *bolt.DB, dbAgreementId string, protocol stri
 		} else {
 			c.ExtendedDeployment = pf
 		}
+		c.addTransition(AG_STATE_DEPLOYMENT_STARTED, "")
 		return &c
 	})
 }
@@ -296,6 +339,7 @@ func AgreementStateTerminated(db *bolt.DB, dbAgreementId string, reason uint64,
 		c.AgreementTerminatedTime = uint64(time.Now().Unix())
 		c.TerminatedReason = reason
 		c.TerminatedDescription = reasonString
+		c.addTransition(AG_STATE_TERMINATED, reasonString)
 		return &c
 	})
 }
@@ -304,6 +348,7 @@ func AgreementStateTerminated(db *bolt.DB, dbAgreementId string, reason uint64,
 func AgreementStateForceTerminated(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.AgreementForceTerminatedTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_FORCE_TERMINATED, "")
 		return &c
 	})
 }
@@ -311,6 +356,10 @@ func AgreementStateForceTerminated(db *bolt.DB, dbAgreementId string, protocol s
 // set agreement state to data received
 func AgreementStateDataReceived(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
+		// Data is received repeatedly, only the first time is a state transition.
+		if c.AgreementDataReceivedTime == 0 {
+			c.addTransition(AG_STATE_DATA_RECEIVED, "")
+		}
 		c.AgreementDataReceivedTime = uint64(time.Now().Unix())
 		return &c
 	})
@@ -320,6 +369,7 @@ func AgreementStateDataReceived(db *bolt.DB, dbAgreementId string, protocol stri
 func AgreementStateAgreementProtocolTerminated(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.AgreementProtocolTerminatedTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_PROTOCOL_TERMINATED, "")
 		return &c
 	})
 }
@@ -328,6 +378,7 @@ func AgreementStateAgreementProtocolTerminated(db *bolt.DB, dbAgreementId string
 func AgreementStateWorkloadTerminated(db *bolt.DB, dbAgreementId string, protocol string) (*EstablishedAgreement, error) {
 	return agreementStateUpdate(db, dbAgreementId, protocol, func(c EstablishedAgreement) *EstablishedAgreement {
 		c.WorkloadTerminatedTime = uint64(time.Now().Unix())
+		c.addTransition(AG_STATE_WORKLOAD_TERMINATED, "")
 		return &c
 	})
 }
@@ -474,6 +525,14 @@ func persistUpdatedAgreement(db *bolt.DB, dbAgreementId string, protocol string,
 				if mod.ProposalSig == "" { // 1 transition from empty to non-empty
 					mod.ProposalSig = update.ProposalSig
 				}
+				for _, t := range update.newTransitions { // appended only when the state changes, repeating the current state is not a transition
+					if last := len(mod.StateHistory) - 1; last < 0 || mod.StateHistory[last].State != t.State {
+						mod.StateHistory = append(mod.StateHistory, t)
+					}
+				}
+				if extra := len(mod.StateHistory) - MAX_AGREEMENT_STATE_HISTORY; extra > 0 { // oldest transitions are dropped when the history is full
+					mod.StateHistory = append(mod.StateHistory[:1], mod.StateHistory[1+extra:]...)
+				}
 
 				if serialized, err := json.Marshal(mod); err != nil {
 					return fmt.Errorf("Failed to serialize contract record: %v. Error: %v", mod, err)
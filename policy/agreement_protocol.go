@@ -115,6 +115,7 @@ type AgreementProtocol struct {
 	Name            string         `json:"name"`                      // The name of the agreement protocol to be used
 	ProtocolVersion int            `json:"protocolVersion,omitempty"` // The max protocol version supported
 	Blockchains     BlockchainList `json:"blockchains,omitempty"`     // The blockchain to be used if the protocol requires one.
+	Capabilities    *CapabilitySet `json:"capabilities,omitempty"`    // The protocol capabilities to negotiate, new in V4 protocol
 }
 
 func (a AgreementProtocol) IsSame(compare AgreementProtocol) bool {
@@ -129,6 +130,9 @@ func (a AgreementProtocol) String() string {
 	for _, bc := range a.Blockchains {
 		res += bc.String() + ","
 	}
+	if a.Capabilities != nil {
+		res += fmt.Sprintf(" Capabilities: %v", *a.Capabilities)
+	}
 	return res
 }
 
@@ -142,6 +146,9 @@ func (a *AgreementProtocol) IsValid() error {
 				return errors.New(fmt.Sprintf("AgreementProtocol %v has blockchain type %v that is incompatible.", a.Name, bc.Type))
 			}
 		}
+		if err := a.Capabilities.IsValid(); err != nil {
+			return errors.New(fmt.Sprintf("AgreementProtocol %v has invalid capabilities, %v", a.Name, err))
+		}
 	}
 	return nil
 }
@@ -607,3 +607,41 @@ func Test_AgreementProtocolList_not_FindByName(t *testing.T) {
 	}
 
 }
+
+// Capability negotiation tests
+func Test_CapabilitySet_negotiate(t *testing.T) {
+
+	var nilCaps *CapabilitySet
+	if agreed, err := nilCaps.Negotiate([]string{CAP_SECRETS}); err != nil || len(agreed) != 0 {
+		t.Errorf("Error: a proposal without capabilities should negotiate to none, got %v %v\n", agreed, err)
+	}
+
+	caps := &CapabilitySet{Required: []string{CAP_SECRETS}, Optional: []string{CAP_HEALTH_CHECK, CAP_DELTA_UPGRADE}}
+	if err := caps.IsValid(); err != nil {
+		t.Errorf("Error: %v should be valid, error %v\n", caps, err)
+	} else if agreed, err := caps.Negotiate([]string{CAP_SECRETS, CAP_DELTA_UPGRADE}); err != nil {
+		t.Errorf("Error: unexpected error %v\n", err)
+	} else if len(agreed) != 2 || agreed[0] != CAP_SECRETS || agreed[1] != CAP_DELTA_UPGRADE {
+		t.Errorf("Error: wrong agreed capabilities %v\n", agreed)
+	} else if missing := caps.MissingRequired(agreed); len(missing) != 0 {
+		t.Errorf("Error: no required capabilities should be missing from %v, got %v\n", agreed, missing)
+	}
+
+	if _, err := caps.Negotiate([]string{CAP_HEALTH_CHECK}); err == nil {
+		t.Errorf("Error: %v should not negotiate without the required capability\n", caps)
+	} else if missing := caps.MissingRequired(nil); len(missing) != 1 || missing[0] != CAP_SECRETS {
+		t.Errorf("Error: %v should be missing from a reply without capabilities, got %v\n", CAP_SECRETS, missing)
+	}
+
+	dup := &CapabilitySet{Required: []string{CAP_SECRETS}, Optional: []string{CAP_SECRETS}}
+	if err := dup.IsValid(); err == nil {
+		t.Errorf("Error: %v should not be valid\n", dup)
+	}
+
+	agp := AgreementProtocol_Factory(BasicProtocol)
+	agp.Capabilities = dup
+	if err := agp.IsValid(); err == nil {
+		t.Errorf("Error: %v should not be valid\n", agp)
+	}
+
+}
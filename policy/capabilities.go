@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Capabilities are optional features of an agreement protocol. Starting with protocol version 4, a proposal carries
+// the set of capabilities the consumer wants to use, so that new features can be adopted by the nodes that support
+// them without moving the whole fleet to a new protocol version. A producer rejects a proposal that requires a
+// capability it does not support, and ignores optional capabilities that it does not support.
+const CAP_SECRETS = "secrets"
+const CAP_HEALTH_CHECK = "healthcheck"
+const CAP_DELTA_UPGRADE = "deltaupgrade"
+
+type CapabilitySet struct {
+	Required []string `json:"required,omitempty"` // The producer must support these capabilities to accept the proposal
+	Optional []string `json:"optional,omitempty"` // Used only when the producer supports them
+}
+
+func (c CapabilitySet) String() string {
+	return fmt.Sprintf("Required: %v, Optional: %v", c.Required, c.Optional)
+}
+
+func (c *CapabilitySet) IsEmpty() bool {
+	return c == nil || (len(c.Required) == 0 && len(c.Optional) == 0)
+}
+
+// A capability may only be listed once, either as required or as optional.
+func (c *CapabilitySet) IsValid() error {
+	if c == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range append(append([]string{}, c.Required...), c.Optional...) {
+		if name == "" {
+			return errors.New(fmt.Sprintf("capability set %v contains an empty capability name", *c))
+		} else if seen[name] {
+			return errors.New(fmt.Sprintf("capability %v is listed more than once in capability set %v", name, *c))
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Returns the capabilities that are in effect when a producer supporting the input capabilities accepts this set. That
+// is all of the required capabilities and the supported optional capabilities. An error is returned if any of the required
+// capabilities are not supported.
+func (c *CapabilitySet) Negotiate(supported []string) ([]string, error) {
+	agreed := make([]string, 0, 5)
+	if c == nil {
+		return agreed, nil
+	}
+
+	missing := make([]string, 0, 2)
+	for _, name := range c.Required {
+		if ContainsCapability(supported, name) {
+			agreed = append(agreed, name)
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) != 0 {
+		return nil, errors.New(fmt.Sprintf("required capabilities %v are not supported, supported capabilities are %v", missing, supported))
+	}
+
+	for _, name := range c.Optional {
+		if ContainsCapability(supported, name) {
+			agreed = append(agreed, name)
+		}
+	}
+	return agreed, nil
+}
+
+// Returns the required capabilities that are not in the input list of agreed capabilities.
+func (c *CapabilitySet) MissingRequired(agreed []string) []string {
+	missing := make([]string, 0, 2)
+	if c == nil {
+		return missing
+	}
+	for _, name := range c.Required {
+		if !ContainsCapability(agreed, name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func ContainsCapability(caps []string, name string) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,16 @@ package policy
 
 import (
 	"fmt"
+	"sort"
 )
 
 // The purpose of this file is to abstract the operations on the HA Group type.
 
 type HighAvailabilityGroup struct {
-	Partners []string `json:"partners,omitempty"`
+	Partners            []string `json:"partners,omitempty"`
+	MaxUnavailable      int      `json:"max_unavailable,omitempty"`      // The number of group members that can upgrade at the same time, 0 means 1
+	MinHealthyDurationS int      `json:"min_healthy_duration,omitempty"` // The number of seconds an upgraded member must run before it counts as upgraded
+	Order               []string `json:"order,omitempty"`                // The order in which group members are upgraded, unlisted members are upgraded last
 }
 
 // This function creates HAGroup objects
@@ -19,16 +23,46 @@ func HAGroup_Factory(partners []string) *HighAvailabilityGroup {
 }
 
 func (g *HighAvailabilityGroup) String() string {
-	return fmt.Sprintf("HAGroup partners: %v", g.Partners)
+	return fmt.Sprintf("HAGroup partners: %v, max unavailable: %v, min healthy duration: %v, order: %v", g.Partners, g.MaxUnavailable, g.MinHealthyDurationS, g.Order)
+}
+
+// Return the number of group members that can upgrade at the same time.
+func (g *HighAvailabilityGroup) GetMaxUnavailable() int {
+	if g.MaxUnavailable <= 0 {
+		return 1
+	}
+	return g.MaxUnavailable
+}
+
+// Sort the input group members into upgrade order. Members in the order list come first, in that order, followed
+// by the other members sorted by id.
+func (g *HighAvailabilityGroup) SortForUpgrade(members []string) {
+	rank := func(id string) int {
+		for ix, o := range g.Order {
+			if o == id {
+				return ix
+			}
+		}
+		return len(g.Order)
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		ri, rj := rank(members[i]), rank(members[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return members[i] < members[j]
+	})
 }
 
 // Return true if 2 HAGroups are the same, meaning their partner lists contain the same
 // partners. The partners dont have to be in the same order in both lists.
 func (g *HighAvailabilityGroup) IsSame(other *HighAvailabilityGroup) bool {
 
-	// Different length, not the same groups
+	// Different length or upgrade settings, not the same groups
 	if len(g.Partners) != len(other.Partners) {
 		return false
+	} else if g.GetMaxUnavailable() != other.GetMaxUnavailable() || g.MinHealthyDurationS != other.MinHealthyDurationS || !sameOrder(g.Order, other.Order) {
+		return false
 	}
 
 	for _, partner := range g.Partners {
@@ -47,6 +81,18 @@ func (g *HighAvailabilityGroup) IsSame(other *HighAvailabilityGroup) bool {
 
 }
 
+func sameOrder(o1 []string, o2 []string) bool {
+	if len(o1) != len(o2) {
+		return false
+	}
+	for ix := range o1 {
+		if o1[ix] != o2[ix] {
+			return false
+		}
+	}
+	return true
+}
+
 // Check the compatibility of 2 HAGroups.  HAGroups are only specified on the producer side so
 // this check is really just comparing 2 producer side policies. A single prdducer is supposed to
 // have the same partner list for all services, so the IsSame check should suffice as a
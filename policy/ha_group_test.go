@@ -141,3 +141,36 @@ func Test_hagroup_merge(t *testing.T) {
 	}
 
 }
+
+func Test_hagroup_upgrade_settings(t *testing.T) {
+
+	hag1 := HAGroup_Factory([]string{"n2", "n3"})
+	hag2 := HAGroup_Factory([]string{"n3", "n2"})
+
+	if hag1.GetMaxUnavailable() != 1 {
+		t.Errorf("default max unavailable should be 1, is %v", hag1.GetMaxUnavailable())
+	}
+
+	hag1.MinHealthyDurationS = 60
+	if hag1.IsSame(hag2) {
+		t.Errorf("groups with different min healthy durations should not be the same")
+	}
+
+	hag2.MinHealthyDurationS = 60
+	hag1.MaxUnavailable = 1
+	if !hag1.IsSame(hag2) {
+		t.Errorf("groups %v and %v should be the same", hag1, hag2)
+	}
+
+	hag1.Order = []string{"n3", "n1"}
+	if hag1.IsSame(hag2) {
+		t.Errorf("groups with different orders should not be the same")
+	}
+
+	members := []string{"n4", "n1", "n2", "n3"}
+	hag1.SortForUpgrade(members)
+	if members[0] != "n3" || members[1] != "n1" || members[2] != "n2" || members[3] != "n4" {
+		t.Errorf("wrong upgrade order %v", members)
+	}
+
+}
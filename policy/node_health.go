@@ -3,12 +3,15 @@ package policy
 import ()
 
 type NodeHealth struct {
-	MissingHBInterval    int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
-	CheckAgreementStatus int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	MissingHBInterval        int `json:"missing_heartbeat_interval,omitempty"` // How long a heartbeat can be missing until it is considered missing (in seconds)
+	CheckAgreementStatus     int `json:"check_agreement_status,omitempty"`     // How often to check that the node agreement entry still exists in the exchange (in seconds)
+	UnhealthyServiceInterval int `json:"unhealthy_service_interval,omitempty"` // How long the service can be reported unhealthy by the node until the agreement is cancelled (in seconds), 0 means service health is not checked
+	MaxRestarts              int `json:"max_restarts,omitempty"`               // The number of container restarts within UnhealthyServiceInterval after which the service is unhealthy, 0 means restarts are not counted
 }
 
 func (h NodeHealth) IsSame(compare NodeHealth) bool {
-	return h.MissingHBInterval == compare.MissingHBInterval && h.CheckAgreementStatus == compare.CheckAgreementStatus
+	return h.MissingHBInterval == compare.MissingHBInterval && h.CheckAgreementStatus == compare.CheckAgreementStatus &&
+		h.UnhealthyServiceInterval == compare.UnhealthyServiceInterval && h.MaxRestarts == compare.MaxRestarts
 }
 
 func NodeHealth_Factory(hbInterval int, checkRate int) *NodeHealth {
@@ -21,3 +24,9 @@ func NodeHealth_Factory(hbInterval int, checkRate int) *NodeHealth {
 	}
 	return nh
 }
+
+// Set the thresholds that determine when the service running under an agreement is unhealthy.
+func (h *NodeHealth) SetServiceHealth(unhealthyInterval int, maxRestarts int) {
+	h.UnhealthyServiceInterval = unhealthyInterval
+	h.MaxRestarts = maxRestarts
+}
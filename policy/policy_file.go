@@ -107,8 +107,12 @@ func (self *Policy) DeepCopy() *Policy {
 
 	newPolicy.RequiredWorkload = self.RequiredWorkload
 
-	newPolicy.HAGroup = HighAvailabilityGroup{Partners: make([]string, len(self.HAGroup.Partners))}
+	newPolicy.HAGroup = HighAvailabilityGroup{Partners: make([]string, len(self.HAGroup.Partners)), MaxUnavailable: self.HAGroup.MaxUnavailable, MinHealthyDurationS: self.HAGroup.MinHealthyDurationS}
 	copy(newPolicy.HAGroup.Partners, self.HAGroup.Partners)
+	if len(self.HAGroup.Order) != 0 {
+		newPolicy.HAGroup.Order = make([]string, len(self.HAGroup.Order))
+		copy(newPolicy.HAGroup.Order, self.HAGroup.Order)
+	}
 	newPolicy.NodeH = self.NodeH
 
 	for _, ui := range self.UserInput {
@@ -273,6 +277,13 @@ func Are_Compatible_Producers(producer_policy1 *Policy, producer_policy2 *Policy
 	merged_pol.APISpecs = (&producer_policy1.APISpecs).MergeWith(&producer_policy2.APISpecs)
 	intersecting_agreement_protocols, _ := (&producer_policy1.AgreementProtocols).Intersects_With(&producer_policy2.AgreementProtocols)
 	(&merged_pol.AgreementProtocols).Concatenate(intersecting_agreement_protocols)
+
+	// The merged producer supports the lower of the protocol versions of the two producers.
+	for ix, agp := range merged_pol.AgreementProtocols {
+		if agp1, agp2 := producer_policy1.AgreementProtocols.FindByName(agp.Name), producer_policy2.AgreementProtocols.FindByName(agp.Name); agp1 != nil && agp2 != nil {
+			merged_pol.AgreementProtocols[ix].ProtocolVersion = cutil.Min(agp1.ProtocolVersion, agp2.ProtocolVersion)
+		}
+	}
 	(&merged_pol.Properties).MergeWith(&producer_policy1.Properties, true)
 	(&merged_pol.Properties).MergeWith(&producer_policy2.Properties, true)
 	merged_pol.DataVerify = producer_policy1.DataVerify.ProducerMergeWith(producer_policy2.DataVerify, defaultNoData)
@@ -611,6 +622,15 @@ func (p *Policy) MinimumProtocolVersion(name string, other *Policy, maxSupported
 
 }
 
+// Returns the protocol capabilities that this (consumer) policy wants to use with the named agreement protocol.
+func (p *Policy) ProtocolCapabilities(name string) *CapabilitySet {
+	if agp := p.AgreementProtocols.FindByName(name); agp == nil {
+		return nil
+	} else {
+		return agp.Capabilities
+	}
+}
+
 func (p *Policy) RequiresKnownBC(protocol string) (string, string, string) {
 	if prodAGP := p.AgreementProtocols.FindByName(protocol); prodAGP == nil {
 		return "", "", ""
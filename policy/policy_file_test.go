@@ -481,6 +481,23 @@ func Test_MinimumProtocolVersion(t *testing.T) {
 		t.Errorf("Error: the min version should be 2 but was %v\n", pv)
 	}
 
+	// Merged producers support the lower of their protocol versions.
+	pa = `{"header":{"name":"a","version":"2.0"},"agreementProtocols":[{"name":"Basic","protocolVersion":4}]}`
+	pb = `{"header":{"name":"b","version":"2.0"},"agreementProtocols":[{"name":"Basic","protocolVersion":2}]}`
+	pc := `{"agreementProtocols":[{"name":"Basic"}]}`
+
+	if p1 = create_Policy(pa, t); p1 == nil {
+		t.Errorf("Error: returned %v, should have returned %v\n", p1, pa)
+	} else if p2 = create_Policy(pb, t); p2 == nil {
+		t.Errorf("Error: returned %v, should have returned %v\n", p2, pb)
+	} else if p3 := create_Policy(pc, t); p3 == nil {
+		t.Errorf("Error: returned %v, should have returned %v\n", p3, pc)
+	} else if merged, err := Are_Compatible_Producers(p1, p2, 600); err != nil {
+		t.Errorf("Error: %v should be compatible with %v, error %v\n", pa, pb, err)
+	} else if pv := merged.MinimumProtocolVersion(BasicProtocol, p3, 4); pv != 2 {
+		t.Errorf("Error: the min version should be 2 but was %v\n", pv)
+	}
+
 }
 
 // Additional producer policy compatibility tests
@@ -8,6 +8,7 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/abstractprotocol"
 	"github.com/open-horizon/anax/api"
+	"github.com/open-horizon/anax/compcheck"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/eventlog"
 	"github.com/open-horizon/anax/events"
@@ -382,29 +383,17 @@ func (w *BaseProducerProtocolHandler) saveSigningKeys(pol *policy.Policy) error
 func (w *BaseProducerProtocolHandler) MatchNodeType(tcPolicy *policy.Policy, dev *persistence.ExchangeDevice) (bool, error) {
 	if dev == nil {
 		return false, fmt.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("device is not configured to accept agreement yet.")))
-	} else if tcPolicy.Workloads == nil || len(tcPolicy.Workloads) == 0 {
-		glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("no workload is supplied in the proposal.")))
+	} else if match, reason := compcheck.CheckProposalNodeType(tcPolicy, dev.GetNodeType(), nil); !match {
+		glog.Errorf(BPPHlogString(w.Name(), reason))
 		return false, nil
 	} else {
-		// make sure that the deployment configuration match the node type
 		nodeType := dev.GetNodeType()
 		workload := tcPolicy.Workloads[0]
-		if nodeType == persistence.DEVICE_TYPE_DEVICE {
+		if nodeType == persistence.DEVICE_TYPE_CLUSTER && workload.ClusterDeployment == "" {
 			if workload.Deployment == "" {
-				if workload.ClusterDeployment == "" {
-					glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("no deployment configuration is provided.")))
-				} else {
-					glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("wrong deployment configuration is provided for the node type '%v'.", nodeType)))
-				}
-				return false, nil
-			}
-		} else if nodeType == persistence.DEVICE_TYPE_CLUSTER {
-			if workload.ClusterDeployment == "" {
-				if workload.Deployment == "" {
-					glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("no cluster deployment configuration is provided.")))
-				} else {
-					glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("wrong deployment configuration is provided for the node type '%v'.", nodeType)))
-				}
+				glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("no cluster deployment configuration is provided.")))
+			} else {
+				glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("wrong deployment configuration is provided for the node type '%v'.", nodeType)))
 			}
 		}
 
@@ -417,19 +406,12 @@ func (w *BaseProducerProtocolHandler) MatchNodeType(tcPolicy *policy.Policy, dev
 func (w *BaseProducerProtocolHandler) MatchPattern(tcPolicy *policy.Policy, dev *persistence.ExchangeDevice) (bool, error) {
 	if dev == nil {
 		return false, fmt.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("device is not configured to accept agreement yet.")))
+	} else if match, reason := compcheck.CheckProposalPattern(tcPolicy, dev.Pattern, nil); !match {
+		glog.Errorf(BPPHlogString(w.Name(), reason))
+		return false, nil
 	} else {
-		// the patter id from the proposal is in the format of org/pattern,
-		// we need to compose the same thing from device in order to compare
-		device_pattern := dev.Pattern
-
-		// compare the patterns from the propodal and device
-		if tcPolicy.PatternId != device_pattern {
-			glog.Errorf(BPPHlogString(w.Name(), fmt.Sprintf("pattern from the proposal: '%v' does not match the pattern on the device: '%v'.", tcPolicy.PatternId, device_pattern)))
-			return false, nil
-		} else {
-			glog.V(5).Infof(BPPHlogString(w.Name(), fmt.Sprintf("pattern from the proposal: '%v' matches the pattern on the device: '%v'.", tcPolicy.PatternId, device_pattern)))
-			return true, nil
-		}
+		glog.V(5).Infof(BPPHlogString(w.Name(), fmt.Sprintf("pattern from the proposal: '%v' matches the pattern on the device: '%v'.", tcPolicy.PatternId, dev.Pattern)))
+		return true, nil
 	}
 }
 
@@ -13,10 +13,11 @@ import (
 // It implements the security.Authentication interface. It is also called by the embedded ESS to
 // provide credentials for the node to access the CSS (over the internal SPI).
 type FSSAuthenticate struct {
-	nodeOrg   string
-	nodeID    string
-	nodeToken string
-	AuthMgr   *AuthenticationManager
+	nodeOrg        string
+	nodeID         string
+	nodeToken      string
+	AuthMgr        *AuthenticationManager
+	ObjectVerifier *ObjectVerifier
 }
 
 // Start initializes the HorizonAuthenticate plugin.
@@ -70,6 +71,12 @@ func (auth *FSSAuthenticate) Authenticate(request *http.Request) (int, string, s
 		authId = fmt.Sprintf("%v/%v/%v", sname[0], vers, sname[1])
 	}
 
+	// Refuse the requests for objects that must be signed with a key trusted by the org and are not.
+	if !auth.ObjectVerifier.CheckRequest(request) {
+		glog.Errorf(essALS(fmt.Sprintf("refused request %v from %v for an untrusted object", request.URL.Path, authId)))
+		return security.AuthFailed, "", ""
+	}
+
 	glog.V(3).Infof(essALS(fmt.Sprintf("returned authentication result code %v org %v id %v", authCode, auth.nodeOrg, authId)))
 
 	return authCode, auth.nodeOrg, authId
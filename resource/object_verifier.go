@@ -0,0 +1,250 @@
+package resource
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/eventlog"
+	"github.com/open-horizon/anax/exchange"
+	"github.com/open-horizon/anax/i18n"
+	"github.com/open-horizon/anax/persistence"
+	"github.com/open-horizon/edge-sync-service/common"
+	"github.com/open-horizon/edge-sync-service/core/base"
+	"github.com/open-horizon/rsapss-tool/verify"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The path of the ESS API for objects, followed by {orgID}/{objectType}[/{objectID}[/data]].
+const ESS_OBJECTS_PATH = "/api/v1/objects/"
+
+// The number of seconds that the trusted keys of an org are cached. When an object is signed with a key that is not in
+// the cache, the keys are fetched again if they are older than MMS_KEYS_MIN_REFRESH seconds, so that a key just added
+// to the org is found.
+const MMS_KEYS_CACHE_TTL = 300
+const MMS_KEYS_MIN_REFRESH = 30
+
+// messages for eventlog
+const (
+	EL_RES_MMS_OBJECT_NOT_SIGNED    = "Rejected model management object %v of type %v in org %v, the object is not signed."
+	EL_RES_MMS_OBJECT_UNTRUSTED_KEY = "Rejected model management object %v of type %v in org %v, the object is not signed with a key that the org trusts for model management objects."
+)
+
+// This is does nothing useful at run time.
+// This code is only used at compile time to make the eventlog messages get into the catalog so that
+// they can be translated.
+// The event log messages will be saved in English. But the CLI can request them in different languages.
+func MarkI18nMessages() {
+	// get message printer. anax default language is English
+	msgPrinter := i18n.GetMessagePrinter()
+
+	msgPrinter.Sprintf(EL_RES_MMS_OBJECT_NOT_SIGNED)
+	msgPrinter.Sprintf(EL_RES_MMS_OBJECT_UNTRUSTED_KEY)
+}
+
+// The keys that an org trusts for signing MMS objects, as base64 encoded PKIX public keys, which is the format of the
+// public key in the object metadata.
+type trustedKeys struct {
+	keys    map[string]bool
+	fetched time.Time
+}
+
+// ObjectVerifier rejects the model management objects of the configured object types that are not signed with one of
+// the keys that the object's org trusts in the exchange. The embedded ESS verifies the object data against the public
+// key and signature in the object metadata when it receives the data, so an object is trusted when that public key is
+// one of the org's keys. A rejected object is marked consumed, so that it is not given to the services, and the
+// rejection is recorded in the event log.
+type ObjectVerifier struct {
+	db          *bolt.DB
+	objectTypes []string
+	keysHandler exchange.MMSSigningKeysHandler
+	lock        sync.Mutex
+	keys        map[string]*trustedKeys
+	rejected    map[string]int64
+}
+
+func NewObjectVerifier(db *bolt.DB, objectTypes []string, keysHandler exchange.MMSSigningKeysHandler) *ObjectVerifier {
+	return &ObjectVerifier{
+		db:          db,
+		objectTypes: objectTypes,
+		keysHandler: keysHandler,
+		keys:        make(map[string]*trustedKeys),
+		rejected:    make(map[string]int64),
+	}
+}
+
+func (v *ObjectVerifier) String() string {
+	return fmt.Sprintf("ObjectVerifier: object types %v", v.objectTypes)
+}
+
+// Returns true if the objects of the given type must be signed with a trusted key.
+func (v *ObjectVerifier) Enforced(objectType string) bool {
+	for _, t := range v.objectTypes {
+		if t == "*" || t == objectType {
+			return true
+		}
+	}
+	return false
+}
+
+// Called when a service has been authenticated for an ESS API request. Returns false if the request must be refused
+// because it is for an object that is rejected. A request for the list of objects of a type is not refused, the
+// untrusted objects are rejected before the ESS builds the list so that they are not in it.
+func (v *ObjectVerifier) CheckRequest(request *http.Request) bool {
+
+	if v == nil || request.Method != http.MethodGet || !strings.HasPrefix(request.URL.Path, ESS_OBJECTS_PATH) {
+		return true
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, ESS_OBJECTS_PATH), "/"), "/")
+	if len(parts) < 2 || !v.Enforced(parts[1]) {
+		return true
+	}
+
+	// The ESS reports the errors, such as an object that does not exist, when it handles the request.
+	if len(parts) == 2 {
+		if objects, err := base.ListUpdatedObjects(parts[0], parts[1], true); err != nil {
+			glog.Errorf(ovLogString(fmt.Sprintf("unable to list the objects of type %v in org %v, error %v", parts[1], parts[0], err)))
+		} else {
+			for _, meta := range objects {
+				v.verifyObject(&meta)
+			}
+		}
+		return true
+	}
+
+	if meta, err := base.GetObject(parts[0], parts[1], parts[2]); err != nil || meta == nil {
+		return true
+	} else {
+		return v.verifyObject(meta)
+	}
+}
+
+// Returns true if the object is trusted, otherwise the object is rejected.
+func (v *ObjectVerifier) verifyObject(meta *common.MetaData) bool {
+	if trusted, reason, err := v.IsTrusted(meta); err != nil {
+		// Without the keys of the org, the object can be neither trusted nor rejected. It is rejected later when the
+		// keys can be fetched.
+		glog.Errorf(ovLogString(fmt.Sprintf("unable to verify object %v of type %v in org %v, error %v", meta.ObjectID, meta.ObjectType, meta.DestOrgID, err)))
+		return false
+	} else if !trusted {
+		v.reject(meta, reason)
+		return false
+	}
+	return true
+}
+
+// Returns true if the object is signed with a key trusted by its org. Objects without data and deleted objects are
+// always trusted, there is no data to sign. When the object is not trusted, the event log message saying why is returned.
+func (v *ObjectVerifier) IsTrusted(meta *common.MetaData) (bool, string, error) {
+
+	if meta.NoData || meta.Deleted {
+		return true, "", nil
+	} else if meta.PublicKey == "" || meta.Signature == "" || !common.IsValidHashAlgorithm(meta.HashAlgorithm) {
+		return false, EL_RES_MMS_OBJECT_NOT_SIGNED, nil
+	}
+
+	objectKey, err := normalizePublicKey(meta.PublicKey)
+	if err != nil {
+		glog.Warningf(ovLogString(fmt.Sprintf("object %v of type %v has an invalid public key, error %v", meta.ObjectID, meta.ObjectType, err)))
+		return false, EL_RES_MMS_OBJECT_UNTRUSTED_KEY, nil
+	}
+
+	keys, err := v.getKeys(meta.DestOrgID, false)
+	if err != nil {
+		return false, "", err
+	} else if !keys.keys[objectKey] && time.Since(keys.fetched) > MMS_KEYS_MIN_REFRESH*time.Second {
+		if keys, err = v.getKeys(meta.DestOrgID, true); err != nil {
+			return false, "", err
+		}
+	}
+
+	if !keys.keys[objectKey] {
+		return false, EL_RES_MMS_OBJECT_UNTRUSTED_KEY, nil
+	}
+	return true, "", nil
+}
+
+// Get the trusted keys of the org from the cache, or from the exchange when they are not cached, are too old or refresh is set.
+func (v *ObjectVerifier) getKeys(org string, refresh bool) (*trustedKeys, error) {
+	v.lock.Lock()
+	cached, found := v.keys[org]
+	v.lock.Unlock()
+
+	if found && !refresh && time.Since(cached.fetched) < MMS_KEYS_CACHE_TTL*time.Second {
+		return cached, nil
+	}
+
+	pemKeys, err := v.keysHandler(org)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the MMS signing keys of org %v from the exchange, error %v", org, err)
+	}
+
+	keys := &trustedKeys{keys: make(map[string]bool), fetched: time.Now()}
+	for name, pemKey := range pemKeys {
+		if pubKey, err := verify.ValidKeyOrCert([]byte(pemKey)); err != nil {
+			glog.Warningf(ovLogString(fmt.Sprintf("ignoring MMS signing key %v of org %v, error %v", name, org, err)))
+		} else if keyBytes, err := x509.MarshalPKIXPublicKey(pubKey); err != nil {
+			glog.Warningf(ovLogString(fmt.Sprintf("ignoring MMS signing key %v of org %v, error %v", name, org, err)))
+		} else {
+			keys.keys[base64.StdEncoding.EncodeToString(keyBytes)] = true
+		}
+	}
+	glog.V(5).Infof(ovLogString(fmt.Sprintf("found %v MMS signing keys for org %v", len(keys.keys), org)))
+
+	v.lock.Lock()
+	v.keys[org] = keys
+	v.lock.Unlock()
+
+	return keys, nil
+}
+
+// Mark the object consumed so that the ESS does not give it to the services, and record the rejection in the event log,
+// once for each instance of the object.
+func (v *ObjectVerifier) reject(meta *common.MetaData, reason string) {
+	objectKey := fmt.Sprintf("%v/%v/%v", meta.DestOrgID, meta.ObjectType, meta.ObjectID)
+
+	v.lock.Lock()
+	if instance, found := v.rejected[objectKey]; found && instance == meta.InstanceID {
+		v.lock.Unlock()
+		return
+	}
+	v.rejected[objectKey] = meta.InstanceID
+	v.lock.Unlock()
+
+	glog.Warningf(ovLogString(fmt.Sprintf(reason, meta.ObjectID, meta.ObjectType, meta.DestOrgID)))
+
+	if err := base.ObjectConsumed(meta.DestOrgID, meta.ObjectType, meta.ObjectID); err != nil {
+		glog.Errorf(ovLogString(fmt.Sprintf("unable to mark object %v of type %v consumed, error %v", meta.ObjectID, meta.ObjectType, err)))
+	}
+
+	if dev, err := persistence.FindExchangeDevice(v.db); err != nil {
+		glog.Errorf(ovLogString(fmt.Sprintf("unable to read the node from the local database, error %v", err)))
+	} else if dev != nil {
+		eventlog.LogNodeEvent(v.db, persistence.SEVERITY_WARN,
+			persistence.NewMessageMeta(reason, meta.ObjectID, meta.ObjectType, meta.DestOrgID),
+			persistence.EC_MMS_OBJECT_REJECTED, dev.Id, dev.Org, dev.Pattern, dev.Config.State)
+	}
+}
+
+// Convert a base64 encoded public key from object metadata to the same encoding as the trusted keys.
+func normalizePublicKey(publicKey string) (string, error) {
+	if keyBytes, err := base64.StdEncoding.DecodeString(publicKey); err != nil {
+		return "", err
+	} else if pubKey, err := x509.ParsePKIXPublicKey(keyBytes); err != nil {
+		return "", err
+	} else if keyBytes, err := x509.MarshalPKIXPublicKey(pubKey); err != nil {
+		return "", err
+	} else {
+		return base64.StdEncoding.EncodeToString(keyBytes), nil
+	}
+}
+
+// Logging function
+var ovLogString = func(v interface{}) string {
+	return fmt.Sprintf("ESS: Object Verifier %v", v)
+}
@@ -0,0 +1,92 @@
+// +build unit
+
+package resource
+
+import (
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"github.com/open-horizon/edge-sync-service/common"
+	"testing"
+)
+
+// Generate a key pair, returns the PEM public key as stored in the exchange and the base64 public key as set in the object metadata.
+func generateObjectKey(t *testing.T) (string, string) {
+	privateKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Could not generate private key, error %v\n", err)
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Could not marshal public key, error %v\n", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyBytes})
+	return string(pemKey), base64.StdEncoding.EncodeToString(pubKeyBytes)
+}
+
+func Test_ObjectVerifier_enforced(t *testing.T) {
+
+	ov := NewObjectVerifier(nil, []string{"model"}, nil)
+	if !ov.Enforced("model") || ov.Enforced("config") {
+		t.Errorf("wrong enforced types for %v", ov)
+	}
+
+	ov = NewObjectVerifier(nil, []string{"*"}, nil)
+	if !ov.Enforced("model") || !ov.Enforced("config") {
+		t.Errorf("all types should be enforced for %v", ov)
+	}
+}
+
+func Test_ObjectVerifier_trusted(t *testing.T) {
+
+	trustedPem, trustedKey := generateObjectKey(t)
+	_, otherKey := generateObjectKey(t)
+
+	fetches := 0
+	keysHandler := func(org string) (map[string]string, error) {
+		fetches++
+		if org != "myorg" {
+			return nil, errors.New("unknown org")
+		}
+		return map[string]string{"trusted.pem": trustedPem, "invalid.pem": "not a key"}, nil
+	}
+	ov := NewObjectVerifier(nil, []string{"model"}, keysHandler)
+
+	meta := common.MetaData{ObjectID: "obj1", ObjectType: "model", DestOrgID: "myorg", HashAlgorithm: common.Sha256, PublicKey: trustedKey, Signature: "c2ln"}
+	if trusted, _, err := ov.IsTrusted(&meta); err != nil || !trusted {
+		t.Errorf("object signed with the trusted key should be trusted, error %v", err)
+	}
+
+	// The keys are cached, the other key is not refreshed yet because the keys were just fetched.
+	meta.PublicKey = otherKey
+	if trusted, reason, err := ov.IsTrusted(&meta); err != nil || trusted || reason != EL_RES_MMS_OBJECT_UNTRUSTED_KEY {
+		t.Errorf("object signed with another key should not be trusted: %v %v %v", trusted, reason, err)
+	} else if fetches != 1 {
+		t.Errorf("the keys should have been fetched once, fetched %v times", fetches)
+	}
+
+	meta.PublicKey = ""
+	if trusted, reason, _ := ov.IsTrusted(&meta); trusted || reason != EL_RES_MMS_OBJECT_NOT_SIGNED {
+		t.Errorf("unsigned object should not be trusted: %v %v", trusted, reason)
+	}
+
+	meta.PublicKey = "not base64"
+	if trusted, reason, _ := ov.IsTrusted(&meta); trusted || reason != EL_RES_MMS_OBJECT_UNTRUSTED_KEY {
+		t.Errorf("object with an invalid key should not be trusted: %v %v", trusted, reason)
+	}
+
+	// There is nothing to sign in an object without data.
+	meta.NoData = true
+	if trusted, _, _ := ov.IsTrusted(&meta); !trusted {
+		t.Errorf("object without data should be trusted")
+	}
+
+	// When the keys cannot be fetched, the object is neither trusted nor rejected.
+	meta = common.MetaData{ObjectID: "obj1", ObjectType: "model", DestOrgID: "otherorg", HashAlgorithm: common.Sha1, PublicKey: trustedKey, Signature: "c2ln"}
+	if trusted, _, err := ov.IsTrusted(&meta); err == nil || trusted {
+		t.Errorf("expected an error when the keys cannot be fetched, got %v %v", trusted, err)
+	}
+}
@@ -58,7 +58,7 @@ func (r ResourceManager) String() string {
 		r.org, r.pattern, r.id, r.token)
 }
 
-func (r ResourceManager) StartFileSyncService(am *AuthenticationManager) error {
+func (r ResourceManager) StartFileSyncService(am *AuthenticationManager, ov *ObjectVerifier) error {
 
 	// Generate a self signed certificate to be used for TLS between a service and the embedded ESS API.
 	// The SSL private key is stored in a different location from the certificate so that the services
@@ -121,8 +121,13 @@ func (r ResourceManager) StartFileSyncService(am *AuthenticationManager) error {
 	// The embedded ESS will use a local bolt DB.
 	common.Configuration.StorageProvider = "bolt"
 
-	// Set the fully formed CSS API URL in the global configuration object.
+	// Set the fully formed CSS API URL in the global configuration object. The ESS gets the objects from a site cache
+	// instead of the CSS when there is one.
 	common.HTTPCSSURL = r.config.GetCSSURL()
+	if siteCacheURL := FindSiteCache(r.config); siteCacheURL != "" {
+		common.HTTPCSSURL = siteCacheURL
+		common.Configuration.HTTPCSSCACertificate = r.config.GetSiteCacheCACert()
+	}
 
 	// Init the sync service log and trace.
 	parameters := logger.Parameters{
@@ -149,7 +154,7 @@ func (r ResourceManager) StartFileSyncService(am *AuthenticationManager) error {
 	censorAndDumpConfig()
 
 	// Set the authenticator that we're going to use.
-	security.SetAuthentication(&FSSAuthenticate{nodeOrg: r.org, nodeID: r.id, nodeToken: r.token, AuthMgr: am})
+	security.SetAuthentication(&FSSAuthenticate{nodeOrg: r.org, nodeID: r.id, nodeToken: r.token, AuthMgr: am, ObjectVerifier: ov})
 
 	// Start the embedded ESS.
 	if err := base.Start("", true); err != nil {
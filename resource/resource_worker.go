@@ -7,8 +7,10 @@ import (
 	"github.com/golang/glog"
 	"github.com/open-horizon/anax/config"
 	"github.com/open-horizon/anax/events"
+	"github.com/open-horizon/anax/exchange"
 	"github.com/open-horizon/anax/persistence"
 	"github.com/open-horizon/anax/worker"
+	"net"
 )
 
 type ResourceWorker struct {
@@ -58,8 +60,11 @@ func (w *ResourceWorker) Messages() chan events.Message {
 }
 
 func (w *ResourceWorker) Initialize() bool {
+	if w.Config.IsSiteCache() {
+		w.startSiteCache()
+	}
 	if w.rm.Configured() {
-		if err := w.rm.StartFileSyncService(w.am); err != nil {
+		if err := w.rm.StartFileSyncService(w.am, w.newObjectVerifier()); err != nil {
 			glog.Errorf(reslog(fmt.Sprintf("Error starting ESS: %v", err)))
 			return false
 		}
@@ -151,7 +156,63 @@ func (w *ResourceWorker) handleNodeConfigCommand(cmd *NodeConfigCommand) error {
 		destinationType = "openhorizon/openhorizon.edgenode"
 	}
 	w.rm.NodeConfigUpdate(cmd.msg.Org(), destinationType, cmd.msg.DeviceId(), cmd.msg.Token())
-	return w.rm.StartFileSyncService(w.am)
+	return w.rm.StartFileSyncService(w.am, w.newObjectVerifier())
+}
+
+// Returns the verifier that rejects the objects which are not signed with a key trusted by the org, or nil when no
+// object type requires signed objects.
+func (w *ResourceWorker) newObjectVerifier() *ObjectVerifier {
+	objectTypes := w.Config.Edge.FileSyncService.RequireSignedObjectTypes
+	if len(objectTypes) == 0 {
+		return nil
+	}
+	glog.V(3).Infof(reslog(fmt.Sprintf("objects of types %v must be signed with a key trusted by the org", objectTypes)))
+
+	// The keys are fetched while a service waits for the ESS, so do not retry for long when the exchange is not reachable.
+	httpFactory := &config.HTTPClientFactory{
+		NewHTTPClient: w.Config.Collaborators.HTTPClientFactory.NewHTTPClient,
+		RetryCount:    1,
+		RetryInterval: 2,
+	}
+	ec := exchange.NewCustomExchangeContext(w.GetExchangeId(), w.GetExchangeToken(), w.GetExchangeURL(), w.GetCSSURL(), httpFactory)
+	return NewObjectVerifier(w.db, objectTypes, exchange.GetHTTPMMSSigningKeysHandler(ec))
+}
+
+// Serve the CSS to the other nodes of the site, and announce the site cache on the LAN when multicast is enabled. The
+// site cache does not depend on the node being registered, it uses the credentials of the other nodes.
+func (w *ResourceWorker) startSiteCache() {
+	fss := w.Config.Edge.FileSyncService
+	if w.Config.GetCSSURL() == "" {
+		glog.Errorf(reslog(fmt.Sprintf("unable to start the site cache, the CSS URL is not set")))
+		return
+	}
+
+	client, err := NewSiteCacheHTTPClient(w.Config.GetCSSSSLCert())
+	if err != nil {
+		glog.Errorf(reslog(fmt.Sprintf("unable to start the site cache: %v", err)))
+		return
+	}
+	siteCache, err := NewSiteCache(w.Config.GetCSSURL(), client, w.Config.GetSiteCachePath(), int64(w.Config.GetSiteCacheMaxSize())*1024*1024)
+	if err != nil {
+		glog.Errorf(reslog(fmt.Sprintf("unable to start the site cache: %v", err)))
+		return
+	} else if err := siteCache.Start(fss.SiteCacheListen, fss.SiteCacheCertFile, fss.SiteCacheKeyFile); err != nil {
+		glog.Errorf(reslog(fmt.Sprintf("unable to start the site cache: %v", err)))
+		return
+	}
+
+	if fss.SiteCacheMulticast {
+		// The nodes only use the site caches found on the LAN over https.
+		announcement := SiteCacheAnnouncement{Scheme: "https", CSSURL: w.Config.GetCSSURL()}
+		if fss.SiteCacheCertFile == "" {
+			glog.Warningf(reslog(fmt.Sprintf("not announcing the site cache, SiteCacheCertFile is not set")))
+		} else if _, port, err := net.SplitHostPort(fss.SiteCacheListen); err != nil {
+			glog.Errorf(reslog(fmt.Sprintf("unable to announce the site cache, invalid SiteCacheListen %v: %v", fss.SiteCacheListen, err)))
+		} else {
+			announcement.Port = port
+			go AnnounceSiteCache(config.HZN_FSS_SITE_CACHE_MULTICAST_ADDRESS, announcement)
+		}
+	}
 }
 
 // The node has just been unconfigured so we can stop the file sync service.
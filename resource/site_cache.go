@@ -0,0 +1,465 @@
+package resource
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/edge-sync-service/common"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The path of the CSS API used by the ESS, the site cache forwards the requests under it to the CSS.
+const SITE_CACHE_SPI_PATH = "/spi/v1/"
+const SITE_CACHE_SPI_OBJECTS_PATH = SITE_CACHE_SPI_PATH + "objects/"
+
+// The path on which a site cache answers that it is available, it is not forwarded to the CSS.
+const SITE_CACHE_HEALTH_PATH = "/sitecache/v1/health"
+
+// The size of the last block of cached data that is sent only after the whole data is verified.
+const SITE_CACHE_LAST_BLOCK_SIZE = 64 * 1024
+
+// The response of a site cache on SITE_CACHE_HEALTH_PATH.
+type SiteCacheHealth struct {
+	CSSURL string `json:"cssUrl"`
+}
+
+// The signature of the data of an object instance, from the metadata of the object that the CSS sends to the ESS.
+type dataSignature struct {
+	hashAlgorithm string
+	publicKey     string
+	signature     string
+}
+
+// SiteCache serves the CSS to the embedded ESS of the other nodes of a site, so that the object data is downloaded
+// from the CSS once for the site. The requests of the ESSs are forwarded to the CSS with their credentials. The data of
+// an object instance is cached the first time a node gets it, the other nodes get it from the cache. The CSS still
+// decides whether a node can get the data: the request for the data is forwarded to the CSS, and its response is closed
+// as soon as the CSS accepts it when the data is cached. Only signed data is cached: the site cache records the
+// signatures in the object metadata that the CSS sends to the ESSs, and verifies the data against its signature before
+// it is cached. The data of the objects that are not signed is passed through from the CSS. The cached data is verified
+// against its SHA256 hash when it is served, and the ESS verifies the signature of the data as it does for the data
+// from the CSS.
+type SiteCache struct {
+	cssURL     *url.URL
+	client     *http.Client
+	proxy      *httputil.ReverseProxy
+	dir        string
+	maxSize    int64
+	lock       sync.Mutex
+	filling    map[string]*sync.Mutex
+	signatures map[string]dataSignature // The signatures of the object instances that are not cached yet, keyed by cache key.
+}
+
+func NewSiteCache(cssURL string, client *http.Client, dir string, maxSize int64) (*SiteCache, error) {
+	target, err := url.Parse(strings.TrimRight(cssURL, "/"))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid CSS URL %v, error %v", cssURL, err))
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.New(fmt.Sprintf("unable to create site cache directory %v, error %v", dir, err))
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = client.Transport
+	director := proxy.Director
+	proxy.Director = func(request *http.Request) {
+		director(request)
+		request.Host = target.Host
+	}
+
+	siteCache := &SiteCache{
+		cssURL:     target,
+		client:     client,
+		proxy:      proxy,
+		dir:        dir,
+		maxSize:    maxSize,
+		filling:    make(map[string]*sync.Mutex),
+		signatures: make(map[string]dataSignature),
+	}
+	proxy.ModifyResponse = siteCache.recordSignatures
+	return siteCache, nil
+}
+
+func (c *SiteCache) String() string {
+	return fmt.Sprintf("SiteCache: CSS URL %v, directory %v, max size %v", c.cssURL, c.dir, c.maxSize)
+}
+
+// Serve the site cache on the given address, with SSL when the certificate and key files are set.
+func (c *SiteCache) Start(listen string, certFile string, keyFile string) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to listen on %v, error %v", listen, err))
+	}
+
+	server := &http.Server{Handler: c}
+	go func() {
+		if certFile != "" {
+			err = server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+		glog.Errorf(scLogString(fmt.Sprintf("stopped serving on %v, error %v", listen, err)))
+	}()
+
+	glog.Infof(scLogString(fmt.Sprintf("serving the CSS %v on %v", c.cssURL, listen)))
+	return nil
+}
+
+func (c *SiteCache) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == SITE_CACHE_HEALTH_PATH {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(SiteCacheHealth{CSSURL: c.cssURL.String()})
+	} else if !strings.HasPrefix(request.URL.Path, SITE_CACHE_SPI_PATH) {
+		http.NotFound(writer, request)
+	} else if key := dataKey(request); key != "" {
+		c.serveData(writer, request, key)
+	} else {
+		c.proxy.ServeHTTP(writer, request)
+	}
+}
+
+// Returns the cache key of a request for the data of an object instance, or an empty string for the other requests.
+// The ESS gets the data with GET /spi/v1/objects/{orgID}/{objectType}/{objectID}/{instanceID}/{dataID}/data.
+func dataKey(request *http.Request) string {
+	if request.Method != http.MethodGet || !strings.HasPrefix(request.URL.Path, SITE_CACHE_SPI_OBJECTS_PATH) {
+		return ""
+	}
+	parts := strings.Split(strings.TrimPrefix(request.URL.Path, SITE_CACHE_SPI_OBJECTS_PATH), "/")
+	if len(parts) != 6 || parts[5] != common.Data {
+		return ""
+	}
+	instanceID, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return ""
+	}
+	dataID, err := strconv.ParseInt(parts[4], 10, 64)
+	if err != nil {
+		return ""
+	}
+	return instanceKey(parts[0], parts[1], parts[2], instanceID, dataID)
+}
+
+// Returns the cache key of the data of an object instance.
+func instanceKey(orgID string, objectType string, objectID string, instanceID int64, dataID int64) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{orgID, objectType, objectID, strconv.FormatInt(instanceID, 10), strconv.FormatInt(dataID, 10)}, "/")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Record the signatures of the data in the object updates that the CSS sends to an ESS. The ESS polls for the updates
+// with GET /spi/v1/objects/, and gets the data of an updated object after it got its metadata.
+func (c *SiteCache) recordSignatures(response *http.Response) error {
+	if response.Request.Method != http.MethodGet || strings.TrimPrefix(response.Request.URL.Path, c.cssURL.Path) != SITE_CACHE_SPI_OBJECTS_PATH ||
+		response.StatusCode != http.StatusOK || response.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	updates := []struct {
+		MetaData common.MetaData
+	}{}
+	if err := json.Unmarshal(body, &updates); err != nil {
+		glog.Warningf(scLogString(fmt.Sprintf("unable to read the object updates, error %v", err)))
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, update := range updates {
+		meta := update.MetaData
+		if common.IsValidHashAlgorithm(meta.HashAlgorithm) && meta.PublicKey != "" && meta.Signature != "" {
+			c.signatures[instanceKey(meta.DestOrgID, meta.ObjectType, meta.ObjectID, meta.InstanceID, meta.DataID)] = dataSignature{hashAlgorithm: meta.HashAlgorithm, publicKey: meta.PublicKey, signature: meta.Signature}
+		}
+	}
+	return nil
+}
+
+// Returns the recorded signature of the data of an object instance.
+func (c *SiteCache) getSignature(key string) (dataSignature, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	sig, found := c.signatures[key]
+	return sig, found
+}
+
+func (c *SiteCache) serveData(writer http.ResponseWriter, request *http.Request, key string) {
+
+	// The CSS decides whether the node can get the data.
+	response, err := c.forward(request)
+	if err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("unable to forward %v to the CSS, error %v", request.URL.Path, err)))
+		http.Error(writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		for name, values := range response.Header {
+			writer.Header()[name] = values
+		}
+		writer.WriteHeader(response.StatusCode)
+		io.Copy(writer, response.Body)
+		return
+	}
+
+	dataFile, hash, err := c.get(key, response.Body)
+	if err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("unable to cache the data of %v, error %v", request.URL.Path, err)))
+		http.Error(writer, err.Error(), http.StatusBadGateway)
+		return
+	} else if dataFile == "" {
+		// The data is not signed, so it is not cached.
+		glog.V(5).Infof(scLogString(fmt.Sprintf("passing through the data of %v, it is not signed", request.URL.Path)))
+		for name, values := range response.Header {
+			writer.Header()[name] = values
+		}
+		writer.WriteHeader(response.StatusCode)
+		io.Copy(writer, response.Body)
+		return
+	}
+
+	// The response of the CSS is not read when the data was cached, closing it stops the download from the CSS.
+	response.Body.Close()
+
+	if err := c.serveFile(writer, dataFile, hash); err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("removing the cached data of %v, error %v", request.URL.Path, err)))
+		c.remove(key)
+
+		// Abort the response so that the ESS does not get the data.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Forward a request for data to the CSS.
+func (c *SiteCache) forward(request *http.Request) (*http.Response, error) {
+	target := *c.cssURL
+	target.Path = c.cssURL.Path + request.URL.Path
+	target.RawQuery = request.URL.RawQuery
+
+	forwarded, err := http.NewRequest(request.Method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	forwarded.Header = request.Header.Clone()
+	return c.client.Do(forwarded)
+}
+
+// Returns the file and hash of the cached data, the data is read from the CSS response when it is not cached yet. The
+// data is read from the CSS once when several nodes request it at the same time. The file is empty when the data is
+// not cached and there is no signature to verify it with, the data must then be served from the CSS response.
+func (c *SiteCache) get(key string, body io.Reader) (string, string, error) {
+	c.lock.Lock()
+	fillLock, found := c.filling[key]
+	if !found {
+		fillLock = new(sync.Mutex)
+		c.filling[key] = fillLock
+	}
+	c.lock.Unlock()
+
+	fillLock.Lock()
+	defer fillLock.Unlock()
+
+	dataFile := path.Join(c.dir, key)
+	if hash, err := ioutil.ReadFile(dataFile + ".sha256"); err == nil {
+		if _, err := os.Stat(dataFile); err == nil {
+			now := time.Now()
+			os.Chtimes(dataFile, now, now)
+			return dataFile, string(hash), nil
+		}
+	}
+
+	sig, signed := c.getSignature(key)
+	if !signed {
+		return "", "", nil
+	}
+	hash, err := c.fill(key, body, sig)
+	if err != nil {
+		return "", "", err
+	}
+
+	c.lock.Lock()
+	delete(c.signatures, key)
+	c.lock.Unlock()
+
+	c.evict(key)
+	return dataFile, hash, nil
+}
+
+// Write the data to the cache, the data file is renamed once the data matches its signature and its hash file is
+// written. The names of the other files in the cache directory contain a '.'.
+func (c *SiteCache) fill(key string, body io.Reader, sig dataSignature) (string, error) {
+	sigHash, cryptoHash, syncErr := common.GetHash(sig.hashAlgorithm)
+	if syncErr != nil {
+		return "", syncErr
+	}
+
+	dataFile := path.Join(c.dir, key)
+	tmpFile, err := ioutil.TempFile(c.dir, key+".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	dataHash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, dataHash, sigHash), body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	} else if err := verifyDataSignature(sigHash.Sum(nil), cryptoHash, sig); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(dataHash.Sum(nil))
+	if err := ioutil.WriteFile(dataFile+".sha256", []byte(hash), 0600); err != nil {
+		return "", err
+	} else if err := os.Rename(tmpFile.Name(), dataFile); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Write the cached data. The last block is written only when the whole data matches its hash, so that the ESS never
+// gets complete data that was corrupted in the cache.
+func (c *SiteCache) serveFile(writer http.ResponseWriter, dataFile string, hash string) error {
+	file, err := os.Open(dataFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	lastBlockSize := int64(SITE_CACHE_LAST_BLOCK_SIZE)
+	if size < lastBlockSize {
+		lastBlockSize = size
+	}
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	writer.WriteHeader(http.StatusOK)
+
+	dataHash := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(writer, dataHash), file, size-lastBlockSize); err != nil {
+		return err
+	}
+	lastBlock := make([]byte, lastBlockSize)
+	if _, err := io.ReadFull(file, lastBlock); err != nil {
+		return err
+	}
+	dataHash.Write(lastBlock)
+	if actual := hex.EncodeToString(dataHash.Sum(nil)); actual != hash {
+		return errors.New(fmt.Sprintf("the cached data has hash %v, expected %v", actual, hash))
+	}
+	writer.Write(lastBlock)
+	return nil
+}
+
+// Returns nil if the hash of the data matches its signature, the same way as the ESS verifies the data.
+func verifyDataSignature(hashSum []byte, cryptoHash crypto.Hash, sig dataSignature) error {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(sig.publicKey)
+	if err != nil {
+		return errors.New(fmt.Sprintf("the public key is not base64 encoded, error %v", err))
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(sig.signature)
+	if err != nil {
+		return errors.New(fmt.Sprintf("the signature is not base64 encoded, error %v", err))
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyBytes)
+	if err != nil {
+		return errors.New(fmt.Sprintf("unable to parse the public key, error %v", err))
+	}
+	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("the public key is not an RSA key")
+	} else if err := rsa.VerifyPSS(rsaPublicKey, cryptoHash, hashSum, signatureBytes, nil); err != nil {
+		return errors.New(fmt.Sprintf("the data does not match its signature, error %v", err))
+	}
+	return nil
+}
+
+func (c *SiteCache) remove(key string) {
+	dataFile := path.Join(c.dir, key)
+	os.Remove(dataFile)
+	os.Remove(dataFile + ".sha256")
+}
+
+// Remove the least recently used data until the cache is not larger than its maximum size, except the data that is
+// about to be served.
+func (c *SiteCache) evict(keep string) {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("unable to read site cache directory %v, error %v", c.dir, err)))
+		return
+	}
+
+	var size int64
+	dataFiles := []os.FileInfo{}
+	for _, file := range files {
+		if !file.IsDir() && !strings.Contains(file.Name(), ".") {
+			size += file.Size()
+			if file.Name() != keep {
+				dataFiles = append(dataFiles, file)
+			}
+		}
+	}
+	sort.Slice(dataFiles, func(i, j int) bool { return dataFiles[i].ModTime().Before(dataFiles[j].ModTime()) })
+
+	for _, file := range dataFiles {
+		if size <= c.maxSize {
+			break
+		}
+		glog.V(3).Infof(scLogString(fmt.Sprintf("evicting %v bytes of cached data", file.Size())))
+		c.remove(file.Name())
+		size -= file.Size()
+	}
+}
+
+// Returns an HTTP client that trusts the given CA certificate file, or the system CA certificates when it is not set.
+func NewSiteCacheHTTPClient(caCertFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caCertFile != "" {
+		if caCert, err := ioutil.ReadFile(caCertFile); err != nil {
+			return nil, errors.New(fmt.Sprintf("unable to read CA certificate %v, error %v", caCertFile, err))
+		} else {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(caCert)
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// Logging function
+var scLogString = func(v interface{}) string {
+	return fmt.Sprintf("Site Cache: %v", v)
+}
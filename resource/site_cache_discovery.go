@@ -0,0 +1,165 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/open-horizon/anax/config"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The service name in the announcements of the site caches.
+const SITE_CACHE_SERVICE = "horizon.sitecache"
+
+// The number of seconds between the announcements of a site cache, and the number of seconds that the ESS waits for
+// an announcement before it uses the CSS.
+const SITE_CACHE_ANNOUNCE_INTERVAL = 10
+const SITE_CACHE_DISCOVERY_WAIT = 15
+
+// The number of seconds to wait for a site cache to answer that it is available.
+const SITE_CACHE_HEALTH_TIMEOUT = 5
+
+// A site cache announces itself on the LAN with a multicast message. The URL of the site cache is made of the scheme,
+// the address the message is sent from and the port, so that the site cache does not need to know its LAN address.
+type SiteCacheAnnouncement struct {
+	Service string `json:"service"`
+	Scheme  string `json:"scheme"`
+	Port    string `json:"port"`
+	CSSURL  string `json:"cssUrl"`
+}
+
+func (a SiteCacheAnnouncement) String() string {
+	return fmt.Sprintf("Service: %v, Scheme: %v, Port: %v, CSSURL: %v", a.Service, a.Scheme, a.Port, a.CSSURL)
+}
+
+// Returns the URL of the site cache that the embedded ESS uses instead of the CSS, or an empty string when there is no
+// site cache available. The configured site caches are tried in order, when none are configured the site caches
+// which announce themselves on the LAN are tried. Anyone on the LAN can announce a site cache, so a site cache found
+// on the LAN is only used over https with a certificate signed by the configured SiteCacheCACert.
+func FindSiteCache(cfg *config.HorizonConfig) string {
+	if cfg.IsSiteCache() {
+		return ""
+	}
+
+	urls := cfg.Edge.FileSyncService.SiteCacheURLs
+	caCert := cfg.GetSiteCacheCACert()
+	if len(urls) == 0 && cfg.Edge.FileSyncService.SiteCacheMulticast {
+		if cfg.Edge.FileSyncService.SiteCacheCACert == "" {
+			glog.Warningf(scLogString(fmt.Sprintf("not looking for site caches on the LAN, SiteCacheCACert is not set")))
+			return ""
+		}
+		caCert = cfg.Edge.FileSyncService.SiteCacheCACert
+		urls = DiscoverSiteCaches(config.HZN_FSS_SITE_CACHE_MULTICAST_ADDRESS, cfg.GetCSSURL(), SITE_CACHE_DISCOVERY_WAIT*time.Second)
+	}
+	if len(urls) == 0 {
+		return ""
+	}
+
+	client, err := NewSiteCacheHTTPClient(caCert)
+	if err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("unable to check the site caches, error %v", err)))
+		return ""
+	}
+	client.Timeout = SITE_CACHE_HEALTH_TIMEOUT * time.Second
+
+	for _, url := range urls {
+		if err := CheckSiteCache(client, url, cfg.GetCSSURL()); err != nil {
+			glog.Warningf(scLogString(fmt.Sprintf("not using site cache %v, error %v", url, err)))
+		} else {
+			glog.Infof(scLogString(fmt.Sprintf("the ESS uses site cache %v", url)))
+			return strings.TrimRight(url, "/")
+		}
+	}
+	return ""
+}
+
+// Returns nil if the site cache is available and serves the given CSS.
+func CheckSiteCache(client *http.Client, url string, cssURL string) error {
+	response, err := client.Get(strings.TrimRight(url, "/") + SITE_CACHE_HEALTH_PATH)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	health := SiteCacheHealth{}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %v", response.StatusCode)
+	} else if err := json.NewDecoder(response.Body).Decode(&health); err != nil {
+		return err
+	} else if strings.TrimRight(health.CSSURL, "/") != strings.TrimRight(cssURL, "/") {
+		return fmt.Errorf("the site cache serves CSS %v, not %v", health.CSSURL, cssURL)
+	}
+	return nil
+}
+
+// Send the announcement of the site cache to the multicast group periodically. It does not return.
+func AnnounceSiteCache(multicastAddress string, announcement SiteCacheAnnouncement) {
+	announcement.Service = SITE_CACHE_SERVICE
+	message, err := json.Marshal(announcement)
+	if err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("unable to marshal announcement %v, error %v", announcement, err)))
+		return
+	}
+
+	for {
+		if groupAddr, err := net.ResolveUDPAddr("udp4", multicastAddress); err != nil {
+			glog.Errorf(scLogString(fmt.Sprintf("unable to resolve multicast address %v, error %v", multicastAddress, err)))
+		} else if conn, err := net.DialUDP("udp4", nil, groupAddr); err != nil {
+			glog.Warningf(scLogString(fmt.Sprintf("unable to announce the site cache on %v, error %v", multicastAddress, err)))
+		} else {
+			if _, err := conn.Write(message); err != nil {
+				glog.Warningf(scLogString(fmt.Sprintf("unable to announce the site cache on %v, error %v", multicastAddress, err)))
+			}
+			conn.Close()
+		}
+		time.Sleep(SITE_CACHE_ANNOUNCE_INTERVAL * time.Second)
+	}
+}
+
+// Wait for the announcement of a site cache for the given CSS on the multicast group, returns the URLs of the site
+// caches found, or an empty list when none is found before the timeout.
+func DiscoverSiteCaches(multicastAddress string, cssURL string, timeout time.Duration) []string {
+	groupAddr, err := net.ResolveUDPAddr("udp4", multicastAddress)
+	if err != nil {
+		glog.Errorf(scLogString(fmt.Sprintf("unable to resolve multicast address %v, error %v", multicastAddress, err)))
+		return []string{}
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		glog.Warningf(scLogString(fmt.Sprintf("unable to look for site caches on %v, error %v", multicastAddress, err)))
+		return []string{}
+	}
+	defer conn.Close()
+
+	glog.V(3).Infof(scLogString(fmt.Sprintf("looking for site caches on %v", multicastAddress)))
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 4096)
+	for {
+		n, source, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			glog.V(3).Infof(scLogString(fmt.Sprintf("no site cache found on %v: %v", multicastAddress, err)))
+			return []string{}
+		}
+		if url := siteCacheURL(buf[:n], source, cssURL); url != "" {
+			return []string{url}
+		}
+	}
+}
+
+// Returns the URL of the site cache that sent the announcement if it serves the given CSS, or an empty string.
+func siteCacheURL(message []byte, source *net.UDPAddr, cssURL string) string {
+	announcement := SiteCacheAnnouncement{}
+	if err := json.Unmarshal(message, &announcement); err != nil || announcement.Service != SITE_CACHE_SERVICE {
+		return ""
+	} else if strings.TrimRight(announcement.CSSURL, "/") != strings.TrimRight(cssURL, "/") {
+		glog.V(5).Infof(scLogString(fmt.Sprintf("ignoring site cache %v for another CSS %v", source, announcement.CSSURL)))
+		return ""
+	} else if announcement.Scheme != "https" {
+		glog.V(5).Infof(scLogString(fmt.Sprintf("ignoring site cache %v, it does not use https", source)))
+		return ""
+	}
+	return fmt.Sprintf("%v://%v", announcement.Scheme, net.JoinHostPort(source.IP.String(), announcement.Port))
+}
@@ -0,0 +1,274 @@
+// +build unit
+
+package resource
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/open-horizon/edge-sync-service/common"
+	"io/ioutil"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A CSS that serves data for the node with credentials "good", and the updates of the objects with the signature of
+// their data for the polls. The data is signed when the CSS is created, the data at the unsigned paths is not signed.
+func newFakeCSS(t *testing.T, data map[string][]byte, unsigned ...string) (*httptest.Server, *int) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	publicKey, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	updates := []map[string]interface{}{}
+	for p, d := range data {
+		parts := strings.Split(strings.TrimPrefix(p, SITE_CACHE_SPI_OBJECTS_PATH), "/")
+		instanceID, _ := strconv.ParseInt(parts[3], 10, 64)
+		dataID, _ := strconv.ParseInt(parts[4], 10, 64)
+		meta := common.MetaData{DestOrgID: parts[0], ObjectType: parts[1], ObjectID: parts[2], InstanceID: instanceID, DataID: dataID}
+		signed := true
+		for _, u := range unsigned {
+			signed = signed && u != p
+		}
+		if signed {
+			sum := sha256.Sum256(d)
+			signature, _ := rsa.SignPSS(rand.Reader, key, crypto.SHA256, sum[:], nil)
+			meta.HashAlgorithm = common.Sha256
+			meta.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+			meta.Signature = base64.StdEncoding.EncodeToString(signature)
+		}
+		updates = append(updates, map[string]interface{}{"Type": common.Update, "MetaData": meta})
+	}
+	body, _ := json.Marshal(updates)
+
+	polls := 0
+	css := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "good" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		} else if r.URL.Path == "/css/spi/v1/objects/" {
+			polls++
+			w.Write(body)
+			return
+		}
+		for p, d := range data {
+			if r.URL.Path == "/css"+p {
+				w.Write(d)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	return css, &polls
+}
+
+func getData(t *testing.T, url string, user string) (int, []byte, error) {
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	request.SetBasicAuth(user, "token")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	return response.StatusCode, body, err
+}
+
+func Test_SiteCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sitecache")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dataPath := "/spi/v1/objects/myorg/model/obj1/3/4/data"
+	data := make([]byte, 1024*1024)
+	mrand.New(mrand.NewSource(7)).Read(data)
+	css, polls := newFakeCSS(t, map[string][]byte{dataPath: data})
+	defer css.Close()
+
+	siteCache, err := NewSiteCache(css.URL+"/css/", http.DefaultClient, dir, 10*1024*1024)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	server := httptest.NewServer(siteCache)
+	defer server.Close()
+
+	// The other requests are forwarded to the CSS.
+	if code, body, _ := getData(t, server.URL+"/spi/v1/objects/", "good"); code != http.StatusOK || !strings.Contains(string(body), `"objectID":"obj1"`) || *polls != 1 {
+		t.Errorf("the poll should be forwarded, got %v %v", code, string(body))
+	} else if code, _, _ := getData(t, server.URL+"/other", "good"); code != http.StatusNotFound {
+		t.Errorf("only the CSS API should be served, got %v", code)
+	}
+
+	// The nodes get the data at the same time, it is cached once.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code, body, err := getData(t, server.URL+dataPath, "good"); code != http.StatusOK || err != nil || !bytes.Equal(body, data) {
+				t.Errorf("wrong data, status %v, error %v", code, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("expected the data and its hash in the cache, got %v files", len(files))
+	}
+
+	// The CSS decides whether the node gets the cached data.
+	if code, _, _ := getData(t, server.URL+dataPath, "bad"); code != http.StatusForbidden {
+		t.Errorf("the node should not get the cached data, got %v", code)
+	}
+
+	// Corrupted data is not served completely, it is fetched again on the next request.
+	key := dataKey(httptest.NewRequest(http.MethodGet, dataPath, nil))
+	cached, _ := ioutil.ReadFile(path.Join(dir, key))
+	cached[10]++
+	ioutil.WriteFile(path.Join(dir, key), cached, 0600)
+	if _, body, err := getData(t, server.URL+dataPath, "good"); err == nil || len(body) == len(data) {
+		t.Errorf("corrupted data should not be served, got %v bytes, error %v", len(body), err)
+	}
+	if code, body, err := getData(t, server.URL+dataPath, "good"); code != http.StatusOK || err != nil || !bytes.Equal(body, data) {
+		t.Errorf("wrong data after corruption, status %v, error %v", code, err)
+	}
+}
+
+func Test_SiteCache_evict(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sitecache")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path1 := "/spi/v1/objects/myorg/model/obj1/1/1/data"
+	path2 := "/spi/v1/objects/myorg/model/obj2/1/1/data"
+	css, _ := newFakeCSS(t, map[string][]byte{path1: make([]byte, 600), path2: make([]byte, 600)})
+	defer css.Close()
+
+	siteCache, _ := NewSiteCache(css.URL+"/css", http.DefaultClient, dir, 1000)
+	server := httptest.NewServer(siteCache)
+	defer server.Close()
+
+	getData(t, server.URL+"/spi/v1/objects/", "good")
+	getData(t, server.URL+path1, "good")
+	getData(t, server.URL+path2, "good")
+
+	key1 := dataKey(httptest.NewRequest(http.MethodGet, path1, nil))
+	key2 := dataKey(httptest.NewRequest(http.MethodGet, path2, nil))
+	if _, err := os.Stat(path.Join(dir, key1)); !os.IsNotExist(err) {
+		t.Errorf("the least recently used data should be evicted")
+	} else if _, err := os.Stat(path.Join(dir, key2)); err != nil {
+		t.Errorf("the last data should be cached, error %v", err)
+	}
+}
+
+func Test_SiteCache_signature(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sitecache")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	signedPath := "/spi/v1/objects/myorg/model/obj1/1/1/data"
+	badPath := "/spi/v1/objects/myorg/model/obj2/1/1/data"
+	unsignedPath := "/spi/v1/objects/myorg/model/obj3/1/1/data"
+	data := map[string][]byte{signedPath: []byte("signed"), badPath: []byte("tampered"), unsignedPath: []byte("unsigned")}
+	css, _ := newFakeCSS(t, data, unsignedPath)
+	defer css.Close()
+	data[badPath][0]++
+
+	siteCache, _ := NewSiteCache(css.URL+"/css", http.DefaultClient, dir, 1000)
+	server := httptest.NewServer(siteCache)
+	defer server.Close()
+
+	// The data is not cached before the site cache has its signature.
+	if code, body, _ := getData(t, server.URL+signedPath, "good"); code != http.StatusOK || string(body) != "signed" {
+		t.Errorf("the data should be passed through, got %v %v", code, string(body))
+	} else if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("the data should not be cached without its signature, got %v files", len(files))
+	}
+
+	getData(t, server.URL+"/spi/v1/objects/", "good")
+	if code, body, _ := getData(t, server.URL+signedPath, "good"); code != http.StatusOK || string(body) != "signed" {
+		t.Errorf("wrong signed data, got %v %v", code, string(body))
+	} else if _, err := os.Stat(path.Join(dir, dataKey(httptest.NewRequest(http.MethodGet, signedPath, nil)))); err != nil {
+		t.Errorf("the signed data should be cached, error %v", err)
+	}
+
+	if code, _, _ := getData(t, server.URL+badPath, "good"); code != http.StatusBadGateway {
+		t.Errorf("the data that does not match its signature should not be served, got %v", code)
+	} else if _, err := os.Stat(path.Join(dir, dataKey(httptest.NewRequest(http.MethodGet, badPath, nil)))); !os.IsNotExist(err) {
+		t.Errorf("the data that does not match its signature should not be cached")
+	}
+
+	if code, body, _ := getData(t, server.URL+unsignedPath, "good"); code != http.StatusOK || string(body) != "unsigned" {
+		t.Errorf("the unsigned data should be passed through, got %v %v", code, string(body))
+	} else if _, err := os.Stat(path.Join(dir, dataKey(httptest.NewRequest(http.MethodGet, unsignedPath, nil)))); !os.IsNotExist(err) {
+		t.Errorf("the unsigned data should not be cached")
+	}
+}
+
+func Test_dataKey(t *testing.T) {
+	for p, isData := range map[string]bool{
+		"/spi/v1/objects/myorg/model/obj1/1/2/data":     true,
+		"/spi/v1/objects/myorg/model/obj1/1/2/feedback": false,
+		"/spi/v1/objects/myorg/model/obj1/a/2/data":     false,
+		"/spi/v1/objects/myorg/model/obj1/data":         false,
+		"/spi/v1/objects/":                              false,
+	} {
+		if key := dataKey(httptest.NewRequest(http.MethodGet, p, nil)); (key != "") != isData {
+			t.Errorf("wrong key %v for %v", key, p)
+		}
+	}
+	if key := dataKey(httptest.NewRequest(http.MethodPut, "/spi/v1/objects/myorg/model/obj1/1/2/data", nil)); key != "" {
+		t.Errorf("only GET requests for data should have a key, got %v", key)
+	}
+}
+
+func Test_SiteCache_discovery(t *testing.T) {
+
+	siteCache, _ := NewSiteCache("https://css.example.com/css/", http.DefaultClient, os.TempDir(), 0)
+	server := httptest.NewServer(siteCache)
+	defer server.Close()
+
+	if err := CheckSiteCache(http.DefaultClient, server.URL+"/", "https://css.example.com/css"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	} else if err := CheckSiteCache(http.DefaultClient, server.URL, "https://other.example.com/css"); err == nil {
+		t.Errorf("expected an error for a site cache of another CSS")
+	} else if err := CheckSiteCache(http.DefaultClient, "http://127.0.0.1:1", "https://css.example.com/css"); err == nil {
+		t.Errorf("expected an error for a site cache that is not available")
+	}
+
+	source := &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 40000}
+	message := []byte(`{"service":"horizon.sitecache","scheme":"https","port":"8445","cssUrl":"https://css.example.com/css"}`)
+	if url := siteCacheURL(message, source, "https://css.example.com/css/"); url != "https://192.168.1.20:8445" {
+		t.Errorf("wrong site cache URL %v", url)
+	} else if url := siteCacheURL(message, source, "https://other.example.com/css"); url != "" {
+		t.Errorf("the site cache of another CSS should be ignored, got %v", url)
+	} else if url := siteCacheURL([]byte(strings.Replace(string(message), "horizon.sitecache", "other", 1)), source, "https://css.example.com/css"); url != "" {
+		t.Errorf("other announcements should be ignored, got %v", url)
+	} else if url := siteCacheURL([]byte(strings.Replace(string(message), `"https"`, `"http"`, 1)), source, "https://css.example.com/css"); url != "" {
+		t.Errorf("the site caches that do not use https should be ignored, got %v", url)
+	}
+}